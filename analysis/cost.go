@@ -0,0 +1,252 @@
+package analysis
+
+import (
+	"cmp"
+	"math"
+	"slices"
+
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/pass"
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
+)
+
+// CostAnalysis bounds the work of one call to each function of a program: the
+// most instructions it can execute, the most heap objects it can allocate, and
+// the most weight those instructions carry under a per-opcode cost table. The
+// bound follows the longest path through the function's blocks, counting a
+// protected block as able to enter its catch block, and adds a callee's own
+// bound at each call to a function constant. Imports run on the host and cost
+// only their CALL.
+//
+// A loop, a recursion, or a call whose callee is only known at run time has
+// no static bound, so the function and every caller reaching it are reported
+// unbounded instead, with the sites responsible.
+type CostAnalysis struct {
+	weights *[256]uint64
+}
+
+// Cost is the worst case of one call to a function, callees included. Each
+// count is a separate worst case that different paths may reach. When
+// Unbounded is not empty the counts mean nothing, and it lists the sites that
+// prevent a bound, in the function and in its callees, ordered by slot and
+// offset.
+type Cost struct {
+	Instructions uint64
+	Allocations  uint64
+	Weight       uint64
+	Unbounded    []Unbound
+}
+
+// Unbound locates a site without a static cost bound. Slot is the function
+// slot, 0 for the top-level code and j+1 for constant j, and IP the offset of
+// the branch closing a loop, the call closing a recursion, or the call or
+// RESUME whose callee is dynamic.
+type Unbound struct {
+	Slot int
+	IP   int
+	Kind UnboundKind
+}
+
+// UnboundKind says why a site has no static cost bound.
+type UnboundKind uint8
+
+const (
+	UnboundLoop UnboundKind = iota
+	UnboundRecursion
+	UnboundDynamic
+)
+
+var _ pass.Analysis[*program.Program, []*Cost] = (*CostAnalysis)(nil)
+
+// NewCostAnalysis returns a CostAnalysis weighing each instruction by its
+// opcode's entry in weights, or by 1 when weights is nil.
+func NewCostAnalysis(weights *[256]uint64) *CostAnalysis {
+	return &CostAnalysis{weights: weights}
+}
+
+// Run returns one Cost per function slot, nil for constants that are not
+// functions.
+func (a *CostAnalysis) Run(m *pass.Manager, prog *program.Program) ([]*Cost, error) {
+	fns := make([]*types.Function, len(prog.Constants)+1)
+	fns[0] = &types.Function{Typ: &types.FunctionType{}, Locals: prog.Locals, Code: prog.Code, Handlers: prog.Handlers}
+	for j, c := range prog.Constants {
+		if fn, ok := c.(*types.Function); ok {
+			fns[j+1] = fn
+		}
+	}
+
+	costs := make([]*Cost, len(fns))
+	visiting := make([]bool, len(fns))
+	var visit func(slot int) error
+	visit = func(slot int) error {
+		visiting[slot] = true
+		defer func() { visiting[slot] = false }()
+
+		callee := func(ip int, fn int) (*Cost, error) {
+			switch {
+			case visiting[fn]:
+				return &Cost{Unbounded: []Unbound{{Slot: slot, IP: ip, Kind: UnboundRecursion}}}, nil
+			case costs[fn] == nil:
+				if err := visit(fn); err != nil {
+					return nil, err
+				}
+			}
+			return costs[fn], nil
+		}
+		c, err := a.cost(m, prog, slot, fns[slot], callee)
+		if err != nil {
+			return err
+		}
+		costs[slot] = c
+		return nil
+	}
+	for slot, fn := range fns {
+		if fn != nil && costs[slot] == nil {
+			if err := visit(slot); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return costs, nil
+}
+
+// cost bounds one call to fn, in slot, reaching each statically known callee's
+// bound through callee.
+func (a *CostAnalysis) cost(m *pass.Manager, prog *program.Program, slot int, fn *types.Function, callee func(ip, fn int) (*Cost, error)) (*Cost, error) {
+	if len(fn.Code) == 0 {
+		return &Cost{}, nil
+	}
+	blocks, err := pass.GetResult[[]*BasicBlock](m, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	var unbounded []Unbound
+	own := make([]Cost, len(blocks))
+	succs := make([][]int, len(blocks))
+	for b, blk := range blocks {
+		succs[b] = slices.Clone(blk.Succs)
+		for _, h := range fn.Handlers {
+			if blk.Start < h.End && h.Start < blk.End {
+				if k := slices.IndexFunc(blocks, func(c *BasicBlock) bool { return c.Start == h.Catch }); k >= 0 {
+					succs[b] = append(succs[b], k)
+				}
+			}
+		}
+
+		prev := -1
+		for ip := blk.Start; ip < blk.End; {
+			inst := instr.Instruction(fn.Code[ip:])
+			op := inst.Opcode()
+			own[b].add(1, 0, a.weight(op))
+			if instr.Allocates(op) {
+				own[b].add(0, 1, 0)
+			}
+			switch op {
+			case instr.CALL, instr.RETURN_CALL:
+				target := -1
+				if prev >= 0 && instr.Opcode(fn.Code[prev]) == instr.CONST_GET {
+					j := int(instr.Instruction(fn.Code[prev:]).Operand(0))
+					if _, ok := prog.Constants[j].(*types.Function); ok {
+						target = j + 1
+					}
+				}
+				if target < 0 {
+					unbounded = append(unbounded, Unbound{Slot: slot, IP: ip, Kind: UnboundDynamic})
+					break
+				}
+				c, err := callee(ip, target)
+				if err != nil {
+					return nil, err
+				}
+				unbounded = append(unbounded, c.Unbounded...)
+				own[b].add(c.Instructions, c.Allocations, c.Weight)
+			case instr.RESUME:
+				unbounded = append(unbounded, Unbound{Slot: slot, IP: ip, Kind: UnboundDynamic})
+			}
+			prev, ip = ip, ip+inst.Width()
+		}
+	}
+
+	// A depth-first walk from the entry finds every cycle as an edge back to a
+	// block still on the walk, and otherwise finishes each block after all its
+	// successors, so the longest path from it is known.
+	const (
+		unseen = iota
+		active
+		done
+	)
+	state := make([]int, len(blocks))
+	worst := make([]Cost, len(blocks))
+	var walk func(b int)
+	walk = func(b int) {
+		state[b] = active
+		for _, s := range succs[b] {
+			switch state[s] {
+			case unseen:
+				walk(s)
+			case active:
+				unbounded = append(unbounded, Unbound{Slot: slot, IP: last(fn.Code, blocks[b]), Kind: UnboundLoop})
+				continue
+			}
+			worst[b].Instructions = max(worst[b].Instructions, worst[s].Instructions)
+			worst[b].Allocations = max(worst[b].Allocations, worst[s].Allocations)
+			worst[b].Weight = max(worst[b].Weight, worst[s].Weight)
+		}
+		worst[b].add(own[b].Instructions, own[b].Allocations, own[b].Weight)
+		state[b] = done
+	}
+	walk(0)
+
+	if len(unbounded) > 0 {
+		slices.SortFunc(unbounded, func(x, y Unbound) int {
+			return cmp.Or(cmp.Compare(x.Slot, y.Slot), cmp.Compare(x.IP, y.IP), cmp.Compare(x.Kind, y.Kind))
+		})
+		return &Cost{Unbounded: slices.Compact(unbounded)}, nil
+	}
+	return &worst[0], nil
+}
+
+// weight is what executing op weighs.
+func (a *CostAnalysis) weight(op instr.Opcode) uint64 {
+	if a.weights == nil {
+		return 1
+	}
+	return a.weights[op]
+}
+
+func (k UnboundKind) String() string {
+	switch k {
+	case UnboundLoop:
+		return "loop"
+	case UnboundRecursion:
+		return "recursion"
+	case UnboundDynamic:
+		return "dynamic call"
+	default:
+		return "unknown"
+	}
+}
+
+// add accumulates counts into c, saturating at the largest uint64.
+func (c *Cost) add(instructions, allocations, weight uint64) {
+	sum := func(a, b uint64) uint64 {
+		if a > math.MaxUint64-b {
+			return math.MaxUint64
+		}
+		return a + b
+	}
+	c.Instructions = sum(c.Instructions, instructions)
+	c.Allocations = sum(c.Allocations, allocations)
+	c.Weight = sum(c.Weight, weight)
+}
+
+// last returns the offset of blk's final instruction.
+func last(code []byte, blk *BasicBlock) int {
+	ip := blk.Start
+	for next := ip; next < blk.End; next += instr.Instruction(code[next:]).Width() {
+		ip = next
+	}
+	return ip
+}
@@ -0,0 +1,183 @@
+package analysis_test
+
+import (
+	"testing"
+
+	"github.com/siyul-park/minivm/analysis"
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/pass"
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCostAnalysis(t *testing.T) {
+	require.NotNil(t, analysis.NewCostAnalysis(nil))
+}
+
+func TestCostAnalysis_Run(t *testing.T) {
+	unary := &types.FunctionType{Params: []types.Type{types.TypeI32}, Returns: []types.Type{types.TypeI32}}
+	nullary := &types.FunctionType{Returns: []types.Type{types.TypeI32}}
+
+	costs := func(t *testing.T, prog *program.Program, weights *[256]uint64) []*analysis.Cost {
+		t.Helper()
+		m := pass.NewManager()
+		pass.Register(m, analysis.NewBlocksAnalysis())
+		pass.Register(m, analysis.NewCostAnalysis(weights))
+		got, err := pass.GetResult[[]*analysis.Cost](m, prog)
+		require.NoError(t, err)
+		return got
+	}
+
+	// call builds a program whose top-level code calls constant 0 and drops
+	// its result.
+	call := func(consts ...types.Value) *program.Program {
+		return program.New([]instr.Instruction{
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+			instr.New(instr.DROP),
+		}, program.WithConstants(consts...))
+	}
+
+	t.Run("takes the longer arm", func(t *testing.T) {
+		b := types.NewFunctionBuilder(nullary)
+		other := b.Label()
+		fn := b.Emit(
+			instr.New(instr.I32_CONST, 1),
+		).BrIf(other).Emit(
+			instr.New(instr.I32_CONST, 2),
+			instr.New(instr.I32_CONST, 3),
+			instr.New(instr.I32_ADD),
+			instr.New(instr.RETURN),
+		).Bind(other).Emit(
+			instr.New(instr.I32_CONST, 4),
+			instr.New(instr.RETURN),
+		).MustBuild()
+
+		got := costs(t, call(fn), nil)
+		require.Equal(t, &analysis.Cost{Instructions: 6, Weight: 6}, got[1])
+		require.Equal(t, &analysis.Cost{Instructions: 9, Weight: 9}, got[0])
+	})
+
+	t.Run("counts allocations", func(t *testing.T) {
+		fn := types.NewFunctionBuilder(nullary).Emit(
+			instr.New(instr.I32_CONST, 2),
+			instr.New(instr.ARRAY_NEW_DEFAULT, 0),
+			instr.New(instr.ARRAY_LEN),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		prog := call(fn)
+		prog.Types = []types.Type{types.NewArrayType(types.TypeI32)}
+
+		got := costs(t, prog, nil)
+		require.Equal(t, uint64(1), got[1].Allocations)
+		require.Equal(t, uint64(1), got[0].Allocations)
+	})
+
+	t.Run("weighs by the cost table", func(t *testing.T) {
+		fn := types.NewFunctionBuilder(nullary).Emit(
+			instr.New(instr.I32_CONST, 2),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		weights := &[256]uint64{}
+		weights[instr.I32_CONST] = 3
+		weights[instr.CALL] = 10
+
+		got := costs(t, call(fn), weights)
+		require.Equal(t, &analysis.Cost{Instructions: 2, Weight: 3}, got[1])
+		require.Equal(t, &analysis.Cost{Instructions: 5, Weight: 13}, got[0])
+	})
+
+	t.Run("counts the catch path", func(t *testing.T) {
+		b := types.NewFunctionBuilder(nullary)
+		start, end, catch := b.Label(), b.Label(), b.Label()
+		fn := b.Bind(start).Emit(
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.THROW),
+		).Bind(end).Bind(catch).Emit(
+			instr.New(instr.DROP),
+			instr.New(instr.I32_CONST, 0),
+			instr.New(instr.RETURN),
+		).Try(start, end, catch, 0).MustBuild()
+
+		got := costs(t, call(fn), nil)
+		require.Equal(t, &analysis.Cost{Instructions: 5, Weight: 5}, got[1])
+	})
+
+	t.Run("imports cost their call", func(t *testing.T) {
+		prog := call(program.NewDeclaration(nullary))
+		prog.Imports = []program.Import{{Name: "env.get", Typ: nullary, Const: 0}}
+
+		got := costs(t, prog, nil)
+		require.Equal(t, &analysis.Cost{}, got[1])
+		require.Equal(t, &analysis.Cost{Instructions: 3, Weight: 3}, got[0])
+	})
+
+	t.Run("loops are unbounded", func(t *testing.T) {
+		b := types.NewFunctionBuilder(unary)
+		head, done := b.Label(), b.Label()
+		fn := b.Emit(
+			instr.New(instr.NOP),
+		).Bind(head).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_EQZ),
+		).BrIf(done).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_SUB),
+			instr.New(instr.LOCAL_SET, 0),
+		).Br(head).Bind(done).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 3),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+		}, program.WithConstants(fn))
+
+		got := costs(t, prog, nil)
+		want := []analysis.Unbound{{Slot: 1, IP: 17, Kind: analysis.UnboundLoop}}
+		require.Equal(t, &analysis.Cost{Unbounded: want}, got[1])
+		require.Equal(t, &analysis.Cost{Unbounded: want}, got[0])
+	})
+
+	t.Run("recursion is unbounded", func(t *testing.T) {
+		fn := types.NewFunctionBuilder(unary).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+			instr.New(instr.RETURN),
+		).MustBuild()
+
+		got := costs(t, call(fn), nil)
+		want := []analysis.Unbound{{Slot: 1, IP: 5, Kind: analysis.UnboundRecursion}}
+		require.Equal(t, want, got[1].Unbounded)
+		require.Equal(t, want, got[0].Unbounded)
+	})
+
+	t.Run("dynamic calls are unbounded", func(t *testing.T) {
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.CALL),
+		}, program.WithLocals(unary))
+
+		got := costs(t, prog, nil)
+		require.Equal(t, []analysis.Unbound{{Slot: 0, IP: 7, Kind: analysis.UnboundDynamic}}, got[0].Unbounded)
+	})
+
+	t.Run("other constants have no cost", func(t *testing.T) {
+		prog := program.New(nil, program.WithConstants(types.I32(1)))
+
+		got := costs(t, prog, nil)
+		require.Len(t, got, 2)
+		require.Nil(t, got[1])
+	})
+}
+
+func TestUnboundKind_String(t *testing.T) {
+	require.Equal(t, "loop", analysis.UnboundLoop.String())
+	require.Equal(t, "recursion", analysis.UnboundRecursion.String())
+	require.Equal(t, "dynamic call", analysis.UnboundDynamic.String())
+}
@@ -0,0 +1,137 @@
+package analysis
+
+import (
+	"github.com/siyul-park/minivm/pass"
+	"github.com/siyul-park/minivm/types"
+)
+
+// DominatorsAnalysis computes the dominator tree of a function's basic blocks
+// with the iterative algorithm of Cooper, Harvey, and Kennedy. Catch blocks
+// are entered out of band and have no CFG predecessors, so they are roots
+// alongside the entry block rather than unreachable code.
+type DominatorsAnalysis struct{}
+
+// Dominators is the per-function result. Idom holds each block's immediate
+// dominator, or -1 for a root (the entry or a catch block) and for a block no
+// root reaches.
+type Dominators struct {
+	Idom []int
+}
+
+var _ pass.Analysis[*types.Function, *Dominators] = (*DominatorsAnalysis)(nil)
+
+func NewDominatorsAnalysis() *DominatorsAnalysis {
+	return &DominatorsAnalysis{}
+}
+
+func (a *DominatorsAnalysis) Run(m *pass.Manager, fn *types.Function) (*Dominators, error) {
+	blocks, err := pass.GetResult[[]*BasicBlock](m, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(blocks)
+	roots := []int{}
+	if n > 0 {
+		roots = append(roots, 0)
+	}
+	for _, h := range fn.Handlers {
+		for idx, blk := range blocks {
+			if blk.Start == h.Catch && idx != 0 {
+				roots = append(roots, idx)
+			}
+		}
+	}
+
+	// Number blocks in postorder; the virtual root above every real root
+	// numbers last.
+	order := make([]int, n+1)
+	for i := range order {
+		order[i] = -1
+	}
+	var post []int
+	var visit func(int)
+	visit = func(b int) {
+		order[b] = 0
+		for _, s := range blocks[b].Succs {
+			if order[s] < 0 {
+				visit(s)
+			}
+		}
+		order[b] = len(post)
+		post = append(post, b)
+	}
+	for _, r := range roots {
+		if order[r] < 0 {
+			visit(r)
+		}
+	}
+	order[n] = len(post)
+
+	const undefined = -2
+	idom := make([]int, n+1)
+	for i := range idom {
+		idom[i] = undefined
+	}
+	idom[n] = n
+	for _, r := range roots {
+		idom[r] = n
+	}
+
+	intersect := func(a, b int) int {
+		for a != b {
+			for order[a] < order[b] {
+				a = idom[a]
+			}
+			for order[b] < order[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for k := len(post) - 1; k >= 0; k-- {
+			b := post[k]
+			if idom[b] == n {
+				continue
+			}
+			next := undefined
+			for _, p := range blocks[b].Preds {
+				if idom[p] == undefined {
+					continue
+				}
+				if next == undefined {
+					next = p
+				} else {
+					next = intersect(p, next)
+				}
+			}
+			if next != idom[b] {
+				idom[b] = next
+				changed = true
+			}
+		}
+	}
+
+	d := &Dominators{Idom: make([]int, n)}
+	for b := range n {
+		d.Idom[b] = idom[b]
+		if idom[b] == n || idom[b] == undefined {
+			d.Idom[b] = -1
+		}
+	}
+	return d, nil
+}
+
+// Dominates reports whether every path from a root to block b passes through
+// block a. A block dominates itself.
+func (d *Dominators) Dominates(a, b int) bool {
+	for b >= 0 {
+		if a == b {
+			return true
+		}
+		b = d.Idom[b]
+	}
+	return false
+}
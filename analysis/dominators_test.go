@@ -0,0 +1,77 @@
+package analysis_test
+
+import (
+	"testing"
+
+	"github.com/siyul-park/minivm/analysis"
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/pass"
+	"github.com/siyul-park/minivm/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDominatorsAnalysis(t *testing.T) {
+	require.NotNil(t, analysis.NewDominatorsAnalysis())
+}
+
+func TestDominatorsAnalysis_Run(t *testing.T) {
+	unary := &types.FunctionType{Params: []types.Type{types.TypeI32}, Returns: []types.Type{types.TypeI32}}
+
+	t.Run("diamond", func(t *testing.T) {
+		b := types.NewFunctionBuilder(unary)
+		then, merge := b.Label(), b.Label()
+		fn := b.Emit(
+			instr.New(instr.LOCAL_GET, 0),
+		).BrIf(then).Emit(
+			instr.New(instr.I32_CONST, 1),
+		).Br(merge).Bind(then).Emit(
+			instr.New(instr.I32_CONST, 2),
+		).Bind(merge).Emit(
+			instr.New(instr.RETURN),
+		).MustBuild()
+
+		m := pass.NewManager()
+		pass.Register(m, analysis.NewBlocksAnalysis())
+		pass.Register(m, analysis.NewDominatorsAnalysis())
+		doms, err := pass.GetResult[*analysis.Dominators](m, fn)
+		require.NoError(t, err)
+		require.Equal(t, []int{-1, 0, 0, 0}, doms.Idom)
+	})
+
+	t.Run("catch blocks are roots", func(t *testing.T) {
+		b := types.NewFunctionBuilder(unary)
+		start, end, catch := b.Label(), b.Label(), b.Label()
+		fn := b.Bind(start).Emit(
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_DIV_S),
+		).Bind(end).Emit(
+			instr.New(instr.RETURN),
+		).Bind(catch).Emit(
+			instr.New(instr.DROP),
+			instr.New(instr.I32_CONST, 0),
+			instr.New(instr.RETURN),
+		).Try(start, end, catch, 1).MustBuild()
+
+		m := pass.NewManager()
+		pass.Register(m, analysis.NewBlocksAnalysis())
+		pass.Register(m, analysis.NewDominatorsAnalysis())
+		doms, err := pass.GetResult[*analysis.Dominators](m, fn)
+		require.NoError(t, err)
+		blocks, err := pass.GetResult[[]*analysis.BasicBlock](m, fn)
+		require.NoError(t, err)
+		for i, blk := range blocks {
+			if blk.Start == fn.Handlers[0].Catch {
+				require.Equal(t, -1, doms.Idom[i])
+			}
+		}
+	})
+}
+
+func TestDominators_Dominates(t *testing.T) {
+	doms := &analysis.Dominators{Idom: []int{-1, 0, 1, 1, -1}}
+	require.True(t, doms.Dominates(0, 2))
+	require.True(t, doms.Dominates(1, 1))
+	require.False(t, doms.Dominates(2, 3))
+	require.False(t, doms.Dominates(0, 4))
+}
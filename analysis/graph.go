@@ -0,0 +1,319 @@
+package analysis
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/pass"
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
+)
+
+// ControlFlowGraph is a function's basic blocks laid out for display. Blocks
+// carry their disassembly and, once annotated, their profile heat; edges
+// name block indexes and tell plain control flow from loop back edges and
+// the out-of-band jumps a protected block can take into its catch block.
+// WriteDOT renders it for Graphviz and the JSON tags describe it for other
+// tools.
+type ControlFlowGraph struct {
+	Name     string         `json:"name,omitempty"`
+	Blocks   []GraphBlock   `json:"blocks"`
+	Edges    []GraphEdge    `json:"edges"`
+	Handlers []GraphHandler `json:"handlers,omitempty"`
+}
+
+// GraphBlock is one basic block: the byte range [Start, End), one instr.Format
+// line per instruction, and the profile samples that landed in it.
+type GraphBlock struct {
+	Start int      `json:"start"`
+	End   int      `json:"end"`
+	Code  []string `json:"code"`
+	Heat  uint64   `json:"heat,omitempty"`
+}
+
+// GraphEdge joins two block indexes.
+type GraphEdge struct {
+	From int      `json:"from"`
+	To   int      `json:"to"`
+	Kind EdgeKind `json:"kind"`
+}
+
+// GraphHandler is one exception handler: throws in [Start, End) at operand
+// depth Depth land at Catch.
+type GraphHandler struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+	Catch int `json:"catch"`
+	Depth int `json:"depth"`
+}
+
+// EdgeKind says how control crosses a GraphEdge.
+type EdgeKind uint8
+
+const (
+	EdgeFlow EdgeKind = iota
+	EdgeBack
+	EdgeCatch
+)
+
+// CallGraph is a program's static call graph: one node per function slot
+// with a body and one edge per callee a function reaches through a function
+// constant, as program.Analyze finds them. Imports run on the host and are
+// not nodes.
+type CallGraph struct {
+	Funcs []CallNode `json:"funcs"`
+	Calls []CallEdge `json:"calls"`
+}
+
+// CallNode is one function slot, 0 for the top-level code and j+1 for
+// constant j. Dynamic and Recursive are as in program.FuncStats; Heat is the
+// profile samples taken in the function once annotated.
+type CallNode struct {
+	Slot      int    `json:"slot"`
+	Name      string `json:"name,omitempty"`
+	Dynamic   bool   `json:"dynamic,omitempty"`
+	Recursive bool   `json:"recursive,omitempty"`
+	Heat      uint64 `json:"heat,omitempty"`
+}
+
+// CallEdge joins a caller slot to a callee slot. Tail reports a RETURN_CALL.
+type CallEdge struct {
+	From int  `json:"from"`
+	To   int  `json:"to"`
+	Tail bool `json:"tail,omitempty"`
+}
+
+// NewControlFlowGraph builds fn's graph from the blocks and loops m computes
+// for it. An edge closing a natural loop is a back edge.
+func NewControlFlowGraph(m *pass.Manager, fn *types.Function) (*ControlFlowGraph, error) {
+	g := &ControlFlowGraph{Blocks: []GraphBlock{}, Edges: []GraphEdge{}}
+	if fn.Debug != nil {
+		g.Name = fn.Debug.Name
+	}
+	if len(fn.Code) == 0 {
+		return g, nil
+	}
+	blocks, err := pass.GetResult[[]*BasicBlock](m, fn)
+	if err != nil {
+		return nil, err
+	}
+	loops, err := pass.GetResult[[]*Loop](m, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(strings.TrimSuffix(instr.Format(fn.Code), "\n"), "\n")
+	line := 0
+	for _, blk := range blocks {
+		b := GraphBlock{Start: blk.Start, End: blk.End}
+		for ip := blk.Start; ip < blk.End; ip += instr.Instruction(fn.Code[ip:]).Width() {
+			b.Code = append(b.Code, lines[line])
+			line++
+		}
+		g.Blocks = append(g.Blocks, b)
+	}
+
+	back := map[[2]int]bool{}
+	for _, loop := range loops {
+		for _, latch := range loop.Latches {
+			back[[2]int{latch, loop.Header}] = true
+		}
+	}
+	for b, blk := range blocks {
+		for _, s := range blk.Succs {
+			kind := EdgeFlow
+			if back[[2]int{b, s}] {
+				kind = EdgeBack
+			}
+			g.Edges = append(g.Edges, GraphEdge{From: b, To: s, Kind: kind})
+		}
+	}
+
+	for _, h := range fn.Handlers {
+		g.Handlers = append(g.Handlers, GraphHandler{Start: h.Start, End: h.End, Catch: h.Catch, Depth: h.Depth})
+		catch := slices.IndexFunc(blocks, func(blk *BasicBlock) bool { return blk.Start == h.Catch })
+		if catch < 0 {
+			continue
+		}
+		for b, blk := range blocks {
+			if blk.Start < h.End && h.Start < blk.End {
+				g.Edges = append(g.Edges, GraphEdge{From: b, To: catch, Kind: EdgeCatch})
+			}
+		}
+	}
+	slices.SortFunc(g.Edges, func(x, y GraphEdge) int {
+		return cmp.Or(cmp.Compare(x.From, y.From), cmp.Compare(x.To, y.To), cmp.Compare(x.Kind, y.Kind))
+	})
+	g.Edges = slices.Compact(g.Edges)
+	return g, nil
+}
+
+// NewCallGraph builds prog's call graph, naming each function as
+// Program.FuncName does.
+func NewCallGraph(prog *program.Program) (*CallGraph, error) {
+	stats, err := program.Analyze(prog)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &CallGraph{Funcs: []CallNode{}, Calls: []CallEdge{}}
+	for slot, f := range stats.Funcs {
+		if slot > 0 {
+			if fn, ok := prog.Constants[slot-1].(*types.Function); !ok || len(fn.Code) == 0 {
+				continue
+			}
+		}
+		g.Funcs = append(g.Funcs, CallNode{
+			Slot:      slot,
+			Name:      prog.FuncName(slot),
+			Dynamic:   f.Dynamic,
+			Recursive: f.Recursive,
+		})
+		for _, callee := range f.Calls {
+			g.Calls = append(g.Calls, CallEdge{From: slot, To: callee})
+		}
+		for _, callee := range f.TailCalls {
+			g.Calls = append(g.Calls, CallEdge{From: slot, To: callee, Tail: true})
+		}
+	}
+	return g, nil
+}
+
+// Annotate sets each block's heat to the sum of heat over the offsets of its
+// instructions, as prof.Collector.IP reports them for the function.
+func (g *ControlFlowGraph) Annotate(heat func(ip int) uint64) {
+	for k := range g.Blocks {
+		b := &g.Blocks[k]
+		b.Heat = 0
+		for ip := b.Start; ip < b.End; ip++ {
+			b.Heat += heat(ip)
+		}
+	}
+}
+
+// WriteDOT writes g to w as a Graphviz digraph. Back edges are bold, catch
+// edges dashed, and annotated blocks shaded by their share of the hottest
+// block's heat; the handler ranges label the graph.
+func (g *ControlFlowGraph) WriteDOT(w io.Writer) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "digraph %s {\n", quote(g.Name))
+	sb.WriteString("\tnode [shape=box fontname=monospace];\n")
+	if len(g.Handlers) > 0 {
+		var label strings.Builder
+		for _, h := range g.Handlers {
+			fmt.Fprintf(&label, "try %04d..%04d catch %04d depth %d\n", h.Start, h.End, h.Catch, h.Depth)
+		}
+		fmt.Fprintf(&sb, "\tlabel=%s;\n\tlabeljust=l;\n", quote(label.String()))
+	}
+
+	var hottest uint64
+	for _, b := range g.Blocks {
+		hottest = max(hottest, b.Heat)
+	}
+	for k, b := range g.Blocks {
+		label := strings.Join(b.Code, "\n") + "\n"
+		if b.Heat > 0 {
+			label += fmt.Sprintf("heat %d\n", b.Heat)
+		}
+		fmt.Fprintf(&sb, "\tb%d [label=%s%s];\n", k, quote(label), shade(b.Heat, hottest))
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&sb, "\tb%d -> b%d", e.From, e.To)
+		switch e.Kind {
+		case EdgeBack:
+			sb.WriteString(" [style=bold label=back]")
+		case EdgeCatch:
+			sb.WriteString(" [style=dashed label=catch]")
+		}
+		sb.WriteString(";\n")
+	}
+	sb.WriteString("}\n")
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// Annotate sets each function's heat to heat of its slot, as
+// prof.Collector.Samples reports it for the function.
+func (g *CallGraph) Annotate(heat func(slot int) uint64) {
+	for k := range g.Funcs {
+		g.Funcs[k].Heat = heat(g.Funcs[k].Slot)
+	}
+}
+
+// WriteDOT writes g to w as a Graphviz digraph. Tail calls are dashed,
+// functions making dynamic calls double-bordered, and annotated functions
+// shaded by their share of the hottest function's heat.
+func (g *CallGraph) WriteDOT(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("digraph calls {\n")
+	sb.WriteString("\tnode [shape=box fontname=monospace];\n")
+
+	var hottest uint64
+	for _, f := range g.Funcs {
+		hottest = max(hottest, f.Heat)
+	}
+	for _, f := range g.Funcs {
+		label := fmt.Sprintf("%d", f.Slot)
+		if f.Name != "" {
+			label += " " + f.Name
+		}
+		if f.Heat > 0 {
+			label += fmt.Sprintf("\nheat %d", f.Heat)
+		}
+		attrs := shade(f.Heat, hottest)
+		if f.Dynamic {
+			attrs += " peripheries=2"
+		}
+		fmt.Fprintf(&sb, "\tf%d [label=%s%s];\n", f.Slot, quote(label), attrs)
+	}
+	for _, c := range g.Calls {
+		fmt.Fprintf(&sb, "\tf%d -> f%d", c.From, c.To)
+		if c.Tail {
+			sb.WriteString(" [style=dashed label=tail]")
+		}
+		sb.WriteString(";\n")
+	}
+	sb.WriteString("}\n")
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func (k EdgeKind) String() string {
+	switch k {
+	case EdgeFlow:
+		return "flow"
+	case EdgeBack:
+		return "back"
+	case EdgeCatch:
+		return "catch"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes k as its name, so JSON carries "flow", "back", or
+// "catch".
+func (k EdgeKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// quote writes s as a DOT string whose lines are left-justified.
+func quote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\t", "  ", "\n", `\l`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// shade returns the DOT attributes filling a node red in proportion to heat
+// over hottest, or none when it is cold.
+func shade(heat, hottest uint64) string {
+	if heat == 0 || hottest == 0 {
+		return ""
+	}
+	return fmt.Sprintf(` style=filled fillcolor="0.000 %.3f 1.000"`, float64(heat)/float64(hottest))
+}
@@ -0,0 +1,211 @@
+package analysis_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/siyul-park/minivm/analysis"
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/pass"
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewControlFlowGraph(t *testing.T) {
+	unary := &types.FunctionType{Params: []types.Type{types.TypeI32}, Returns: []types.Type{types.TypeI32}}
+
+	graph := func(t *testing.T, fn *types.Function) *analysis.ControlFlowGraph {
+		t.Helper()
+		m := pass.NewManager()
+		pass.Register(m, analysis.NewBlocksAnalysis())
+		pass.Register(m, analysis.NewDominatorsAnalysis())
+		pass.Register(m, analysis.NewLoopsAnalysis())
+		g, err := analysis.NewControlFlowGraph(m, fn)
+		require.NoError(t, err)
+		return g
+	}
+
+	t.Run("disassembles blocks and marks back edges", func(t *testing.T) {
+		b := types.NewFunctionBuilder(unary)
+		head, done := b.Label(), b.Label()
+		fn := b.Bind(head).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+		).BrIf(done).Emit(
+			instr.New(instr.NOP),
+		).Br(head).Bind(done).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		fn.Debug = &types.Debug{Name: "spin"}
+
+		g := graph(t, fn)
+		require.Equal(t, "spin", g.Name)
+		require.Len(t, g.Blocks, 3)
+		require.Equal(t, []string{"0000:\tlocal.get 0x00", "0002:\tbr_if 0x0004"}, g.Blocks[0].Code)
+		require.Equal(t, []analysis.GraphEdge{
+			{From: 0, To: 1, Kind: analysis.EdgeFlow},
+			{From: 0, To: 2, Kind: analysis.EdgeFlow},
+			{From: 1, To: 0, Kind: analysis.EdgeBack},
+		}, g.Edges)
+	})
+
+	t.Run("links protected blocks to their catch", func(t *testing.T) {
+		b := types.NewFunctionBuilder(&types.FunctionType{Returns: []types.Type{types.TypeI32}})
+		start, end, catch := b.Label(), b.Label(), b.Label()
+		fn := b.Bind(start).Emit(
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.THROW),
+		).Bind(end).Bind(catch).Emit(
+			instr.New(instr.DROP),
+			instr.New(instr.I32_CONST, 0),
+			instr.New(instr.RETURN),
+		).Try(start, end, catch, 0).MustBuild()
+
+		g := graph(t, fn)
+		require.Equal(t, []analysis.GraphHandler{{Start: 0, End: 6, Catch: 6, Depth: 0}}, g.Handlers)
+		require.Equal(t, []analysis.GraphEdge{{From: 0, To: 1, Kind: analysis.EdgeCatch}}, g.Edges)
+	})
+
+	t.Run("has no blocks without code", func(t *testing.T) {
+		g := graph(t, program.NewDeclaration(unary))
+		require.Empty(t, g.Blocks)
+		require.Empty(t, g.Edges)
+	})
+}
+
+func TestNewCallGraph(t *testing.T) {
+	nullary := &types.FunctionType{Returns: []types.Type{types.TypeI32}}
+
+	t.Run("links direct and tail calls", func(t *testing.T) {
+		leaf := types.NewFunctionBuilder(nullary).Emit(
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		tail := types.NewFunctionBuilder(nullary).Emit(
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.RETURN_CALL),
+		).MustBuild()
+		tail.Debug = &types.Debug{Name: "tail"}
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.CONST_GET, 1),
+			instr.New(instr.CALL),
+			instr.New(instr.DROP),
+		}, program.WithConstants(leaf, tail))
+
+		g, err := analysis.NewCallGraph(prog)
+		require.NoError(t, err)
+		require.Equal(t, []analysis.CallNode{{Slot: 0}, {Slot: 1}, {Slot: 2, Name: "tail"}}, g.Funcs)
+		require.Equal(t, []analysis.CallEdge{{From: 0, To: 2}, {From: 2, To: 1, Tail: true}}, g.Calls)
+	})
+
+	t.Run("marks recursive and dynamic functions", func(t *testing.T) {
+		unary := &types.FunctionType{Params: []types.Type{types.TypeI32}, Returns: []types.Type{types.TypeI32}}
+		fn := types.NewFunctionBuilder(unary).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.CALL),
+			instr.New(instr.DROP),
+		}, program.WithConstants(fn), program.WithLocals(unary))
+
+		g, err := analysis.NewCallGraph(prog)
+		require.NoError(t, err)
+		require.Equal(t, []analysis.CallNode{{Slot: 0, Dynamic: true}, {Slot: 1, Recursive: true}}, g.Funcs)
+		require.Equal(t, []analysis.CallEdge{{From: 1, To: 1}}, g.Calls)
+	})
+
+	t.Run("leaves out imports", func(t *testing.T) {
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+			instr.New(instr.DROP),
+		}, program.WithConstants(program.NewDeclaration(nullary)))
+		prog.Imports = []program.Import{{Name: "env.get", Typ: nullary, Const: 0}}
+
+		g, err := analysis.NewCallGraph(prog)
+		require.NoError(t, err)
+		require.Equal(t, []analysis.CallNode{{Slot: 0}}, g.Funcs)
+		require.Empty(t, g.Calls)
+	})
+}
+
+func TestControlFlowGraph_Annotate(t *testing.T) {
+	g := &analysis.ControlFlowGraph{Blocks: []analysis.GraphBlock{{Start: 0, End: 3}, {Start: 3, End: 4}}}
+	g.Annotate(func(ip int) uint64 { return uint64(ip) })
+	require.Equal(t, uint64(3), g.Blocks[0].Heat)
+	require.Equal(t, uint64(3), g.Blocks[1].Heat)
+}
+
+func TestControlFlowGraph_WriteDOT(t *testing.T) {
+	g := &analysis.ControlFlowGraph{
+		Name: "f",
+		Blocks: []analysis.GraphBlock{
+			{Start: 0, End: 2, Code: []string{"0000:\tlocal.get 0"}, Heat: 4},
+			{Start: 2, End: 3, Code: []string{"0002:\treturn"}, Heat: 2},
+		},
+		Edges: []analysis.GraphEdge{
+			{From: 0, To: 1, Kind: analysis.EdgeFlow},
+			{From: 1, To: 0, Kind: analysis.EdgeBack},
+			{From: 0, To: 1, Kind: analysis.EdgeCatch},
+		},
+		Handlers: []analysis.GraphHandler{{Start: 0, End: 2, Catch: 2}},
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, g.WriteDOT(&out))
+	require.Equal(t, `digraph "f" {
+	node [shape=box fontname=monospace];
+	label="try 0000..0002 catch 0002 depth 0\l";
+	labeljust=l;
+	b0 [label="0000:  local.get 0\lheat 4\l" style=filled fillcolor="0.000 1.000 1.000"];
+	b1 [label="0002:  return\lheat 2\l" style=filled fillcolor="0.000 0.500 1.000"];
+	b0 -> b1;
+	b1 -> b0 [style=bold label=back];
+	b0 -> b1 [style=dashed label=catch];
+}
+`, out.String())
+}
+
+func TestCallGraph_Annotate(t *testing.T) {
+	g := &analysis.CallGraph{Funcs: []analysis.CallNode{{Slot: 0}, {Slot: 2}}}
+	g.Annotate(func(slot int) uint64 { return uint64(slot * 10) })
+	require.Equal(t, uint64(0), g.Funcs[0].Heat)
+	require.Equal(t, uint64(20), g.Funcs[1].Heat)
+}
+
+func TestCallGraph_WriteDOT(t *testing.T) {
+	g := &analysis.CallGraph{
+		Funcs: []analysis.CallNode{{Slot: 0, Dynamic: true}, {Slot: 1, Name: "fib", Heat: 7}},
+		Calls: []analysis.CallEdge{{From: 0, To: 1}, {From: 1, To: 1, Tail: true}},
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, g.WriteDOT(&out))
+	require.Equal(t, `digraph calls {
+	node [shape=box fontname=monospace];
+	f0 [label="0" peripheries=2];
+	f1 [label="1 fib\lheat 7" style=filled fillcolor="0.000 1.000 1.000"];
+	f0 -> f1;
+	f1 -> f1 [style=dashed label=tail];
+}
+`, out.String())
+}
+
+func TestEdgeKind_String(t *testing.T) {
+	require.Equal(t, "flow", analysis.EdgeFlow.String())
+	require.Equal(t, "back", analysis.EdgeBack.String())
+	require.Equal(t, "catch", analysis.EdgeCatch.String())
+}
+
+func TestEdgeKind_MarshalText(t *testing.T) {
+	data, err := json.Marshal(analysis.GraphEdge{From: 1, To: 0, Kind: analysis.EdgeBack})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"from":1,"to":0,"kind":"back"}`, string(data))
+}
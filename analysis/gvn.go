@@ -351,7 +351,7 @@ func (g *numbering) step(ip int, inst instr.Instruction) bool {
 		return false
 	}
 
-	if isPure(op) {
+	if IsPure(op) {
 		return g.pure(ip, end, inst)
 	}
 
@@ -556,10 +556,11 @@ func (g *numbering) kindOf(slot int) instr.Kind {
 	return g.locals[slot].Kind()
 }
 
-// isPure reports whether op is a deterministic, side-effect-free, non-allocating
+// IsPure reports whether op is a deterministic, side-effect-free, non-allocating
 // value computation: the numeric ALU/compare/convert ops (whose operands and
-// result are all numeric) plus the reference comparisons.
-func isPure(op instr.Opcode) bool {
+// result are all numeric) plus the reference comparisons. A pure op may still
+// trap; see MayTrap.
+func IsPure(op instr.Opcode) bool {
 	switch op {
 	case instr.REF_EQ, instr.REF_NE, instr.REF_IS_NULL:
 		return true
@@ -576,6 +577,20 @@ func isPure(op instr.Opcode) bool {
 	return true
 }
 
+// MayTrap reports whether pure op can trap on some operands: integer division
+// and remainder, and their float counterparts, trap on a zero divisor.
+func MayTrap(op instr.Opcode) bool {
+	switch op {
+	case instr.I32_DIV_S, instr.I32_DIV_U, instr.I32_REM_S, instr.I32_REM_U,
+		instr.I64_DIV_S, instr.I64_DIV_U, instr.I64_REM_S, instr.I64_REM_U,
+		instr.F32_DIV, instr.F32_REM, instr.F32_MOD,
+		instr.F64_DIV, instr.F64_REM, instr.F64_MOD:
+		return true
+	default:
+		return false
+	}
+}
+
 // commutative reports whether op's two operands may be reordered without
 // changing its result, so the value key can canonicalize their order.
 func commutative(op instr.Opcode) bool {
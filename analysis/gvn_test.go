@@ -237,3 +237,16 @@ func TestGVNAnalysis_Run(t *testing.T) {
 		require.Empty(t, gvn.Redundant)
 	})
 }
+
+func TestIsPure(t *testing.T) {
+	require.True(t, analysis.IsPure(instr.I32_ADD))
+	require.True(t, analysis.IsPure(instr.I64_DIV_S))
+	require.False(t, analysis.IsPure(instr.LOCAL_SET))
+	require.False(t, analysis.IsPure(instr.CALL))
+}
+
+func TestMayTrap(t *testing.T) {
+	require.True(t, analysis.MayTrap(instr.I32_DIV_S))
+	require.True(t, analysis.MayTrap(instr.F64_REM))
+	require.False(t, analysis.MayTrap(instr.I32_ADD))
+}
@@ -0,0 +1,114 @@
+package analysis
+
+import (
+	"slices"
+
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/pass"
+	"github.com/siyul-park/minivm/types"
+)
+
+// LivenessAnalysis computes which local slots are live, read on some path
+// before being written again, at every point of a function. It runs the
+// classic backward dataflow over the basic blocks. An instruction inside a
+// handler's protected range may transfer control to the catch block, so the
+// slots live on entry to the catch block are live before it as well.
+type LivenessAnalysis struct{}
+
+// Liveness is the per-function result. In and Out hold, per block and per
+// local slot, whether the slot is live on entry to and on exit from the
+// block. Dead marks each LOCAL_GET, LOCAL_SET, and LOCAL_TEE offset after
+// which its slot is not live: a store there is never read, and a load there
+// is the slot's last use.
+type Liveness struct {
+	In   [][]bool
+	Out  [][]bool
+	Dead map[int]bool
+}
+
+var _ pass.Analysis[*types.Function, *Liveness] = (*LivenessAnalysis)(nil)
+
+func NewLivenessAnalysis() *LivenessAnalysis {
+	return &LivenessAnalysis{}
+}
+
+func (a *LivenessAnalysis) Run(m *pass.Manager, fn *types.Function) (*Liveness, error) {
+	blocks, err := pass.GetResult[[]*BasicBlock](m, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	slots := len(fn.Locals)
+	if fn.Typ != nil {
+		slots += len(fn.Typ.Params)
+	}
+	catch := make([]int, len(fn.Handlers))
+	for k, h := range fn.Handlers {
+		catch[k] = slices.IndexFunc(blocks, func(b *BasicBlock) bool { return b.Start == h.Catch })
+	}
+
+	l := &Liveness{
+		In:   make([][]bool, len(blocks)),
+		Out:  make([][]bool, len(blocks)),
+		Dead: map[int]bool{},
+	}
+	for b := range blocks {
+		l.In[b] = make([]bool, slots)
+		l.Out[b] = make([]bool, slots)
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for b := len(blocks) - 1; b >= 0; b-- {
+			for _, s := range blocks[b].Succs {
+				for k, live := range l.In[s] {
+					l.Out[b][k] = l.Out[b][k] || live
+				}
+			}
+			in := a.flow(fn, blocks, b, l, catch, false)
+			if !slices.Equal(in, l.In[b]) {
+				l.In[b] = in
+				changed = true
+			}
+		}
+	}
+	for b := range blocks {
+		a.flow(fn, blocks, b, l, catch, true)
+	}
+	return l, nil
+}
+
+// flow walks block b backward from its exit set and returns its entry set.
+// When record is set, it also marks the local accesses after which their slot
+// is dead.
+func (a *LivenessAnalysis) flow(fn *types.Function, blocks []*BasicBlock, b int, l *Liveness, catch []int, record bool) []bool {
+	var ips []int
+	for ip := blocks[b].Start; ip < blocks[b].End; ip += instr.Instruction(fn.Code[ip:]).Width() {
+		ips = append(ips, ip)
+	}
+
+	live := slices.Clone(l.Out[b])
+	for _, ip := range slices.Backward(ips) {
+		inst := instr.Instruction(fn.Code[ip:])
+		switch op := inst.Opcode(); op {
+		case instr.LOCAL_GET, instr.LOCAL_SET, instr.LOCAL_TEE:
+			slot := int(inst.Operand(0))
+			if slot >= len(live) {
+				break
+			}
+			if record && !live[slot] {
+				l.Dead[ip] = true
+			}
+			live[slot] = op == instr.LOCAL_GET
+		}
+		for k, h := range fn.Handlers {
+			if catch[k] < 0 || ip < h.Start || ip >= h.End {
+				continue
+			}
+			for slot, in := range l.In[catch[k]] {
+				live[slot] = live[slot] || in
+			}
+		}
+	}
+	return live
+}
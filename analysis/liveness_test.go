@@ -0,0 +1,90 @@
+package analysis_test
+
+import (
+	"testing"
+
+	"github.com/siyul-park/minivm/analysis"
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/pass"
+	"github.com/siyul-park/minivm/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLivenessAnalysis(t *testing.T) {
+	require.NotNil(t, analysis.NewLivenessAnalysis())
+}
+
+func TestLivenessAnalysis_Run(t *testing.T) {
+	unary := &types.FunctionType{Params: []types.Type{types.TypeI32}, Returns: []types.Type{types.TypeI32}}
+
+	run := func(t *testing.T, fn *types.Function) *analysis.Liveness {
+		t.Helper()
+		m := pass.NewManager()
+		pass.Register(m, analysis.NewBlocksAnalysis())
+		pass.Register(m, analysis.NewLivenessAnalysis())
+		live, err := pass.GetResult[*analysis.Liveness](m, fn)
+		require.NoError(t, err)
+		return live
+	}
+
+	t.Run("straight-line stores and loads", func(t *testing.T) {
+		fn := types.NewFunctionBuilder(unary).Locals(types.TypeI32).Emit(
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.I32_CONST, 2),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_ADD),
+			instr.New(instr.RETURN),
+		).MustBuild()
+
+		live := run(t, fn)
+		require.Equal(t, [][]bool{{true, false}}, live.In)
+		require.Equal(t, [][]bool{{false, false}}, live.Out)
+		require.Equal(t, map[int]bool{5: true, 14: true, 16: true}, live.Dead)
+	})
+
+	t.Run("loop keeps a slot live around the back edge", func(t *testing.T) {
+		b := types.NewFunctionBuilder(unary)
+		head, done := b.Label(), b.Label()
+		fn := b.Bind(head).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_EQZ),
+		).BrIf(done).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_SUB),
+			instr.New(instr.LOCAL_SET, 0),
+		).Br(head).Bind(done).Emit(
+			instr.New(instr.I32_CONST, 0),
+			instr.New(instr.RETURN),
+		).MustBuild()
+
+		live := run(t, fn)
+		require.Equal(t, []bool{true}, live.Out[1])
+		require.False(t, live.Dead[14])
+		require.True(t, live.Dead[6])
+	})
+
+	t.Run("catch block reads keep protected stores live", func(t *testing.T) {
+		b := types.NewFunctionBuilder(unary).Locals(types.TypeI32)
+		start, end, catch := b.Label(), b.Label(), b.Label()
+		fn := b.Bind(start).Emit(
+			instr.New(instr.I32_CONST, 7),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_DIV_S),
+		).Bind(end).Emit(
+			instr.New(instr.RETURN),
+		).Bind(catch).Emit(
+			instr.New(instr.DROP),
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.RETURN),
+		).Try(start, end, catch, 1).MustBuild()
+
+		live := run(t, fn)
+		require.False(t, live.Dead[5])
+	})
+}
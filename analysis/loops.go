@@ -0,0 +1,101 @@
+package analysis
+
+import (
+	"slices"
+
+	"github.com/siyul-park/minivm/pass"
+	"github.com/siyul-park/minivm/types"
+)
+
+// LoopsAnalysis finds a function's natural loops. Every CFG edge whose target
+// dominates its source is a back edge; the loop it closes holds the target,
+// its header, and every block that reaches the source without passing through
+// the header. Back edges to one header form a single loop.
+type LoopsAnalysis struct{}
+
+// Loop is one natural loop. Blocks lists its block indexes in ascending
+// order, header included; Latches lists the blocks that branch back to the
+// header. Preheader is the only block outside the loop that enters it, or -1
+// when the loop has several entries or none. Parent indexes the innermost
+// enclosing loop in the same result, or is -1 for an outermost loop.
+type Loop struct {
+	Header    int
+	Blocks    []int
+	Latches   []int
+	Preheader int
+	Parent    int
+}
+
+var _ pass.Analysis[*types.Function, []*Loop] = (*LoopsAnalysis)(nil)
+
+func NewLoopsAnalysis() *LoopsAnalysis {
+	return &LoopsAnalysis{}
+}
+
+// Run returns the loops ordered by header offset.
+func (a *LoopsAnalysis) Run(m *pass.Manager, fn *types.Function) ([]*Loop, error) {
+	blocks, err := pass.GetResult[[]*BasicBlock](m, fn)
+	if err != nil {
+		return nil, err
+	}
+	doms, err := pass.GetResult[*Dominators](m, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	byHeader := map[int]*Loop{}
+	var loops []*Loop
+	for src, blk := range blocks {
+		for _, dst := range blk.Succs {
+			if !doms.Dominates(dst, src) {
+				continue
+			}
+			loop, ok := byHeader[dst]
+			if !ok {
+				loop = &Loop{Header: dst, Blocks: []int{dst}, Preheader: -1, Parent: -1}
+				byHeader[dst] = loop
+				loops = append(loops, loop)
+			}
+			loop.Latches = append(loop.Latches, src)
+
+			work := []int{src}
+			for len(work) > 0 {
+				b := work[len(work)-1]
+				work = work[:len(work)-1]
+				if slices.Contains(loop.Blocks, b) {
+					continue
+				}
+				loop.Blocks = append(loop.Blocks, b)
+				work = append(work, blocks[b].Preds...)
+			}
+		}
+	}
+
+	for _, loop := range loops {
+		slices.Sort(loop.Blocks)
+		slices.Sort(loop.Latches)
+		var outside []int
+		for _, p := range blocks[loop.Header].Preds {
+			if !slices.Contains(loop.Blocks, p) {
+				outside = append(outside, p)
+			}
+		}
+		// A root header is also entered from outside the CFG.
+		if len(outside) == 1 && doms.Idom[loop.Header] >= 0 {
+			loop.Preheader = outside[0]
+		}
+	}
+
+	slices.SortFunc(loops, func(a, b *Loop) int { return a.Header - b.Header })
+	for i, loop := range loops {
+		for j, outer := range loops {
+			if i == j || len(outer.Blocks) <= len(loop.Blocks) || !slices.Contains(outer.Blocks, loop.Header) {
+				continue
+			}
+			if loop.Parent < 0 || len(outer.Blocks) < len(loops[loop.Parent].Blocks) {
+				loop.Parent = j
+			}
+		}
+	}
+	return loops, nil
+}
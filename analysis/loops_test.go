@@ -0,0 +1,115 @@
+package analysis_test
+
+import (
+	"testing"
+
+	"github.com/siyul-park/minivm/analysis"
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/pass"
+	"github.com/siyul-park/minivm/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLoopsAnalysis(t *testing.T) {
+	require.NotNil(t, analysis.NewLoopsAnalysis())
+}
+
+func TestLoopsAnalysis_Run(t *testing.T) {
+	unary := &types.FunctionType{Params: []types.Type{types.TypeI32}, Returns: []types.Type{types.TypeI32}}
+
+	loops := func(t *testing.T, fn *types.Function) []*analysis.Loop {
+		t.Helper()
+		m := pass.NewManager()
+		pass.Register(m, analysis.NewBlocksAnalysis())
+		pass.Register(m, analysis.NewDominatorsAnalysis())
+		pass.Register(m, analysis.NewLoopsAnalysis())
+		got, err := pass.GetResult[[]*analysis.Loop](m, fn)
+		require.NoError(t, err)
+		return got
+	}
+
+	t.Run("straight-line code has no loops", func(t *testing.T) {
+		fn := types.NewFunctionBuilder(unary).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		require.Empty(t, loops(t, fn))
+	})
+
+	t.Run("countdown", func(t *testing.T) {
+		b := types.NewFunctionBuilder(unary)
+		head, done := b.Label(), b.Label()
+		fn := b.Emit(
+			instr.New(instr.NOP),
+		).Bind(head).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_EQZ),
+		).BrIf(done).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_SUB),
+			instr.New(instr.LOCAL_SET, 0),
+		).Br(head).Bind(done).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.RETURN),
+		).MustBuild()
+
+		require.Equal(t, []*analysis.Loop{
+			{Header: 1, Blocks: []int{1, 2}, Latches: []int{2}, Preheader: 0, Parent: -1},
+		}, loops(t, fn))
+	})
+
+	t.Run("nested", func(t *testing.T) {
+		b := types.NewFunctionBuilder(unary)
+		outer, inner, next, done := b.Label(), b.Label(), b.Label(), b.Label()
+		fn := b.Emit(
+			instr.New(instr.NOP),
+		).Bind(outer).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_EQZ),
+		).BrIf(done).Bind(inner).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_AND),
+		).BrIf(next).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_SUB),
+			instr.New(instr.LOCAL_SET, 0),
+		).Br(inner).Bind(next).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_SHR_U),
+			instr.New(instr.LOCAL_SET, 0),
+		).Br(outer).Bind(done).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.RETURN),
+		).MustBuild()
+
+		got := loops(t, fn)
+		require.Len(t, got, 2)
+		require.Equal(t, -1, got[0].Parent)
+		require.Equal(t, 0, got[1].Parent)
+		require.Equal(t, 1, got[1].Preheader)
+		require.Subset(t, got[0].Blocks, got[1].Blocks)
+	})
+
+	t.Run("root header has no preheader", func(t *testing.T) {
+		b := types.NewFunctionBuilder(unary)
+		head := b.Label()
+		fn := b.Bind(head).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_SUB),
+			instr.New(instr.LOCAL_TEE, 0),
+		).BrIf(head).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.RETURN),
+		).MustBuild()
+
+		got := loops(t, fn)
+		require.Len(t, got, 1)
+		require.Equal(t, 0, got[0].Header)
+		require.Equal(t, -1, got[0].Preheader)
+	})
+}
@@ -0,0 +1,498 @@
+package analysis
+
+import (
+	"math/bits"
+	"slices"
+
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/pass"
+	"github.com/siyul-park/minivm/types"
+)
+
+// SCCPAnalysis runs sparse conditional constant propagation over a function's
+// basic blocks. For every reached block it tracks which locals and operand
+// stack entries hold a known constant on entry, evaluates integer arithmetic,
+// comparisons, and conversions over known operands, and follows only the edges
+// a branch can take given its condition. A block is reached only through an
+// edge some reached branch can take, so the arm a decided condition skips stays
+// unreached and its values never weaken a merge.
+//
+// Values are tracked in the kinds the interpreter stores: i32, i64, f32, f64,
+// and i1 booleans. Floats propagate through locals and the stack but are not
+// evaluated. Parameters, declared locals, and everything in a catch block
+// start unknown.
+type SCCPAnalysis struct{}
+
+// SCCP is the per-function result. Reachable flags each block a root reaches.
+// Values maps the offset of every reached LOCAL_GET that always reads the same
+// constant to that constant. Branches maps every reached BR_IF or BR_TABLE
+// whose condition is constant to the edge it always takes.
+type SCCP struct {
+	Reachable []bool
+	Values    map[int]Constant
+	Branches  map[int]Decision
+}
+
+// Constant is a known value: its kind and its bits, zero-extended from the
+// kind's width. An i1 is 0 or 1.
+type Constant struct {
+	Kind instr.Kind
+	Bits uint64
+}
+
+// Decision is the edge a branch always takes. Target is the offset control
+// continues at, the instruction after the branch when a BR_IF falls through.
+// Cond is where the side-effect-free code computing the condition begins when
+// it runs contiguously up to the branch, or -1 when it does not.
+type Decision struct {
+	Target int
+	Cond   int
+}
+
+// lattice is one tracked value: a constant when known, otherwise overdefined.
+// When pure, [start, end) is the contiguous side-effect-free code computing it.
+type lattice struct {
+	Constant
+	known bool
+	pure  bool
+	start int
+	end   int
+}
+
+// state is the abstract machine state on entry to a block. stack holds the
+// tracked top of the operand stack; every entry beneath it is unknown.
+type state struct {
+	locals []lattice
+	stack  []lattice
+}
+
+var _ pass.Analysis[*types.Function, *SCCP] = (*SCCPAnalysis)(nil)
+
+func NewSCCPAnalysis() *SCCPAnalysis {
+	return &SCCPAnalysis{}
+}
+
+func (a *SCCPAnalysis) Run(m *pass.Manager, fn *types.Function) (*SCCP, error) {
+	blocks, err := pass.GetResult[[]*BasicBlock](m, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	slots := len(fn.Locals)
+	if fn.Typ != nil {
+		slots += len(fn.Typ.Params)
+	}
+	index := map[int]int{}
+	for b, blk := range blocks {
+		index[blk.Start] = b
+	}
+
+	in := make([]*state, len(blocks))
+	var work []int
+	enter := func(b int, s *state) {
+		if in[b] == nil {
+			in[b] = &state{locals: slices.Clone(s.locals), stack: slices.Clone(s.stack)}
+			work = append(work, b)
+		} else if in[b].meet(s) {
+			work = append(work, b)
+		}
+	}
+
+	if len(blocks) > 0 {
+		enter(0, &state{locals: make([]lattice, slots)})
+	}
+	for _, h := range fn.Handlers {
+		if b, ok := index[h.Catch]; ok {
+			enter(b, &state{locals: make([]lattice, slots)})
+		}
+	}
+	for len(work) > 0 {
+		b := work[len(work)-1]
+		work = work[:len(work)-1]
+		out, succs := a.flow(fn, blocks[b], in[b], nil)
+		for _, off := range succs {
+			if s, ok := index[off]; ok {
+				enter(s, out)
+			}
+		}
+	}
+
+	result := &SCCP{Reachable: make([]bool, len(blocks)), Values: map[int]Constant{}, Branches: map[int]Decision{}}
+	for b, s := range in {
+		if s != nil {
+			result.Reachable[b] = true
+			a.flow(fn, blocks[b], s, result)
+		}
+	}
+	return result, nil
+}
+
+// flow interprets blk from entry state in and returns its exit state and the
+// offsets control may continue at. When out is not nil, it also records the
+// constant loads and decided branches it meets.
+func (a *SCCPAnalysis) flow(fn *types.Function, blk *BasicBlock, in *state, out *SCCP) (*state, []int) {
+	s := &state{locals: slices.Clone(in.locals)}
+	for _, v := range in.stack {
+		v.pure = false
+		s.stack = append(s.stack, v)
+	}
+
+	for ip := blk.Start; ip < blk.End; {
+		inst := instr.Instruction(fn.Code[ip:])
+		op := inst.Opcode()
+		end := ip + inst.Width()
+		leaf := lattice{pure: true, start: ip, end: end}
+
+		switch op {
+		case instr.NOP:
+		case instr.I32_CONST, instr.F32_CONST:
+			leaf.Constant, leaf.known = Constant{Kind: instr.TypeOf(op).Push[0], Bits: uint64(uint32(inst.Operand(0)))}, true
+			s.push(leaf)
+		case instr.I64_CONST, instr.F64_CONST:
+			leaf.Constant, leaf.known = Constant{Kind: instr.TypeOf(op).Push[0], Bits: inst.Operand(0)}, true
+			s.push(leaf)
+		case instr.CONST_GET, instr.REF_NULL:
+			s.push(leaf)
+		case instr.LOCAL_GET:
+			if slot := int(inst.Operand(0)); slot < len(s.locals) {
+				leaf.Constant, leaf.known = s.locals[slot].Constant, s.locals[slot].known
+			}
+			if leaf.known && out != nil {
+				out.Values[ip] = leaf.Constant
+			}
+			s.push(leaf)
+		case instr.LOCAL_SET:
+			v := s.pop()
+			if slot := int(inst.Operand(0)); slot < len(s.locals) {
+				s.locals[slot] = v
+			}
+		case instr.LOCAL_TEE:
+			v := s.pop()
+			if slot := int(inst.Operand(0)); slot < len(s.locals) {
+				s.locals[slot] = v
+			}
+			v.pure = false
+			s.push(v)
+		case instr.DROP:
+			s.pop()
+		case instr.DUP:
+			v := s.pop()
+			s.push(v)
+			v.pure = false
+			s.push(v)
+		case instr.SWAP:
+			top, below := s.pop(), s.pop()
+			top.pure, below.pure = false, false
+			s.push(top)
+			s.push(below)
+		case instr.SELECT:
+			c, y, x := s.pop(), s.pop(), s.pop()
+			v := lattice{}
+			switch {
+			case c.known && c.Bits != 0:
+				v = x
+			case c.known:
+				v = y
+			case x.known && y.known && x.Constant == y.Constant:
+				v = x
+			}
+			v.pure = false
+			s.push(v)
+		case instr.BR:
+			return s, instr.Targets(fn.Code, ip)
+		case instr.BR_IF, instr.BR_TABLE:
+			c := s.pop()
+			targets := instr.Targets(fn.Code, ip)
+			if op == instr.BR_IF {
+				targets = append(targets, end)
+			}
+			if !c.known {
+				return s, targets
+			}
+			target := targets[len(targets)-1]
+			if op == instr.BR_IF && c.Bits != 0 {
+				target = targets[0]
+			} else if idx := int32(c.Bits); op == instr.BR_TABLE && idx >= 0 && int(idx) < len(targets)-1 {
+				target = targets[idx]
+			}
+			if out != nil {
+				cond := -1
+				if c.pure && c.end == ip {
+					cond = c.start
+				}
+				out.Branches[ip] = Decision{Target: target, Cond: cond}
+			}
+			return s, []int{target}
+		case instr.RETURN, instr.RETURN_CALL, instr.THROW, instr.UNREACHABLE:
+			return s, nil
+		default:
+			t := instr.TypeOf(op)
+			if t.Pop == nil && t.Push == nil {
+				// Calls, coroutines, and the like move an unknown number of
+				// operands; everything on the stack becomes unknown.
+				s.stack = nil
+				break
+			}
+			args := make([]lattice, len(t.Pop))
+			for k := len(args) - 1; k >= 0; k-- {
+				args[k] = s.pop()
+			}
+			if !IsPure(op) {
+				for range t.Push {
+					s.push(lattice{})
+				}
+				break
+			}
+			v := lattice{pure: true, end: end}
+			if len(args) > 0 {
+				v.start = args[0].start
+			}
+			known := true
+			consts := make([]Constant, len(args))
+			for k, arg := range args {
+				next := ip
+				if k+1 < len(args) {
+					next = args[k+1].start
+				}
+				v.pure = v.pure && arg.pure && arg.end == next
+				known = known && arg.known
+				consts[k] = arg.Constant
+			}
+			if known {
+				v.Constant, v.known = evaluate(op, consts)
+			}
+			if !v.known && MayTrap(op) {
+				v.pure = false
+			}
+			s.push(v)
+		}
+		ip = end
+	}
+	return s, []int{blk.End}
+}
+
+// meet lowers s to agree with other, keeping only the locals and top stack
+// entries both hold the same constant in. It reports whether s changed.
+func (s *state) meet(other *state) bool {
+	changed := false
+	for k := range s.locals {
+		if s.locals[k].known && (!other.locals[k].known || other.locals[k].Constant != s.locals[k].Constant) {
+			s.locals[k] = lattice{}
+			changed = true
+		}
+	}
+	if len(other.stack) < len(s.stack) {
+		s.stack = s.stack[len(s.stack)-len(other.stack):]
+		changed = true
+	}
+	for k := range s.stack {
+		o := other.stack[len(other.stack)-len(s.stack)+k]
+		if s.stack[k].known && (!o.known || o.Constant != s.stack[k].Constant) {
+			s.stack[k] = lattice{}
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (s *state) push(v lattice) {
+	s.stack = append(s.stack, v)
+}
+
+func (s *state) pop() lattice {
+	if len(s.stack) == 0 {
+		return lattice{}
+	}
+	v := s.stack[len(s.stack)-1]
+	s.stack = s.stack[:len(s.stack)-1]
+	return v
+}
+
+// evaluate computes pure integer op over known operands. It reports false for
+// the ops it does not model and for operands that would trap.
+func evaluate(op instr.Opcode, args []Constant) (Constant, bool) {
+	i32 := func(v uint32) (Constant, bool) { return Constant{Kind: instr.KindI32, Bits: uint64(v)}, true }
+	i64 := func(v uint64) (Constant, bool) { return Constant{Kind: instr.KindI64, Bits: v}, true }
+	i1 := func(v bool) (Constant, bool) {
+		if v {
+			return Constant{Kind: instr.KindI1, Bits: 1}, true
+		}
+		return Constant{Kind: instr.KindI1}, true
+	}
+
+	switch t := instr.TypeOf(op); {
+	case len(t.Pop) == 1 && t.Pop[0] == instr.KindI32:
+		a := uint32(args[0].Bits)
+		switch op {
+		case instr.I32_CLZ:
+			return i32(uint32(bits.LeadingZeros32(a)))
+		case instr.I32_CTZ:
+			return i32(uint32(bits.TrailingZeros32(a)))
+		case instr.I32_POPCNT:
+			return i32(uint32(bits.OnesCount32(a)))
+		case instr.I32_EXTEND8_S:
+			return i32(uint32(int32(int8(a))))
+		case instr.I32_EXTEND16_S:
+			return i32(uint32(int32(int16(a))))
+		case instr.I32_EQZ:
+			return i1(a == 0)
+		case instr.I32_TO_I64_S:
+			return i64(uint64(int64(int32(a))))
+		case instr.I32_TO_I64_U:
+			return i64(uint64(a))
+		}
+	case len(t.Pop) == 2 && t.Pop[0] == instr.KindI32:
+		a, b := uint32(args[0].Bits), uint32(args[1].Bits)
+		switch op {
+		case instr.I32_ADD:
+			return i32(a + b)
+		case instr.I32_SUB:
+			return i32(a - b)
+		case instr.I32_MUL:
+			return i32(a * b)
+		case instr.I32_DIV_S:
+			if b == 0 || (int32(a) == -1<<31 && int32(b) == -1) {
+				return Constant{}, false
+			}
+			return i32(uint32(int32(a) / int32(b)))
+		case instr.I32_DIV_U:
+			if b == 0 {
+				return Constant{}, false
+			}
+			return i32(a / b)
+		case instr.I32_REM_S:
+			if b == 0 || (int32(a) == -1<<31 && int32(b) == -1) {
+				return Constant{}, false
+			}
+			return i32(uint32(int32(a) % int32(b)))
+		case instr.I32_REM_U:
+			if b == 0 {
+				return Constant{}, false
+			}
+			return i32(a % b)
+		case instr.I32_SHL:
+			return i32(a << (b & 31))
+		case instr.I32_SHR_S:
+			return i32(uint32(int32(a) >> (b & 31)))
+		case instr.I32_SHR_U:
+			return i32(a >> (b & 31))
+		case instr.I32_ROTL:
+			return i32(bits.RotateLeft32(a, int(b&31)))
+		case instr.I32_ROTR:
+			return i32(bits.RotateLeft32(a, -int(b&31)))
+		case instr.I32_AND:
+			return i32(a & b)
+		case instr.I32_OR:
+			return i32(a | b)
+		case instr.I32_XOR:
+			return i32(a ^ b)
+		case instr.I32_EQ:
+			return i1(a == b)
+		case instr.I32_NE:
+			return i1(a != b)
+		case instr.I32_LT_S:
+			return i1(int32(a) < int32(b))
+		case instr.I32_LT_U:
+			return i1(a < b)
+		case instr.I32_GT_S:
+			return i1(int32(a) > int32(b))
+		case instr.I32_GT_U:
+			return i1(a > b)
+		case instr.I32_LE_S:
+			return i1(int32(a) <= int32(b))
+		case instr.I32_LE_U:
+			return i1(a <= b)
+		case instr.I32_GE_S:
+			return i1(int32(a) >= int32(b))
+		case instr.I32_GE_U:
+			return i1(a >= b)
+		}
+	case len(t.Pop) == 1 && t.Pop[0] == instr.KindI64:
+		a := args[0].Bits
+		switch op {
+		case instr.I64_CLZ:
+			return i64(uint64(bits.LeadingZeros64(a)))
+		case instr.I64_CTZ:
+			return i64(uint64(bits.TrailingZeros64(a)))
+		case instr.I64_POPCNT:
+			return i64(uint64(bits.OnesCount64(a)))
+		case instr.I64_EXTEND8_S:
+			return i64(uint64(int64(int8(a))))
+		case instr.I64_EXTEND16_S:
+			return i64(uint64(int64(int16(a))))
+		case instr.I64_EXTEND32_S:
+			return i64(uint64(int64(int32(a))))
+		case instr.I64_EQZ:
+			return i1(a == 0)
+		case instr.I64_TO_I32:
+			return i32(uint32(a))
+		}
+	case len(t.Pop) == 2 && t.Pop[0] == instr.KindI64:
+		a, b := args[0].Bits, args[1].Bits
+		switch op {
+		case instr.I64_ADD:
+			return i64(a + b)
+		case instr.I64_SUB:
+			return i64(a - b)
+		case instr.I64_MUL:
+			return i64(a * b)
+		case instr.I64_DIV_S:
+			if b == 0 || (int64(a) == -1<<63 && int64(b) == -1) {
+				return Constant{}, false
+			}
+			return i64(uint64(int64(a) / int64(b)))
+		case instr.I64_DIV_U:
+			if b == 0 {
+				return Constant{}, false
+			}
+			return i64(a / b)
+		case instr.I64_REM_S:
+			if b == 0 || (int64(a) == -1<<63 && int64(b) == -1) {
+				return Constant{}, false
+			}
+			return i64(uint64(int64(a) % int64(b)))
+		case instr.I64_REM_U:
+			if b == 0 {
+				return Constant{}, false
+			}
+			return i64(a % b)
+		case instr.I64_SHL:
+			return i64(a << (b & 63))
+		case instr.I64_SHR_S:
+			return i64(uint64(int64(a) >> (b & 63)))
+		case instr.I64_SHR_U:
+			return i64(a >> (b & 63))
+		case instr.I64_ROTL:
+			return i64(bits.RotateLeft64(a, int(b&63)))
+		case instr.I64_ROTR:
+			return i64(bits.RotateLeft64(a, -int(b&63)))
+		case instr.I64_AND:
+			return i64(a & b)
+		case instr.I64_OR:
+			return i64(a | b)
+		case instr.I64_XOR:
+			return i64(a ^ b)
+		case instr.I64_EQ:
+			return i1(a == b)
+		case instr.I64_NE:
+			return i1(a != b)
+		case instr.I64_LT_S:
+			return i1(int64(a) < int64(b))
+		case instr.I64_LT_U:
+			return i1(a < b)
+		case instr.I64_GT_S:
+			return i1(int64(a) > int64(b))
+		case instr.I64_GT_U:
+			return i1(a > b)
+		case instr.I64_LE_S:
+			return i1(int64(a) <= int64(b))
+		case instr.I64_LE_U:
+			return i1(a <= b)
+		case instr.I64_GE_S:
+			return i1(int64(a) >= int64(b))
+		case instr.I64_GE_U:
+			return i1(a >= b)
+		}
+	}
+	return Constant{}, false
+}
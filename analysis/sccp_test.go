@@ -0,0 +1,147 @@
+package analysis_test
+
+import (
+	"testing"
+
+	"github.com/siyul-park/minivm/analysis"
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/pass"
+	"github.com/siyul-park/minivm/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSCCPAnalysis(t *testing.T) {
+	require.NotNil(t, analysis.NewSCCPAnalysis())
+}
+
+func TestSCCPAnalysis_Run(t *testing.T) {
+	unary := &types.FunctionType{Params: []types.Type{types.TypeI32}, Returns: []types.Type{types.TypeI32}}
+
+	run := func(t *testing.T, fn *types.Function) *analysis.SCCP {
+		t.Helper()
+		m := pass.NewManager()
+		pass.Register(m, analysis.NewBlocksAnalysis())
+		pass.Register(m, analysis.NewSCCPAnalysis())
+		sccp, err := pass.GetResult[*analysis.SCCP](m, fn)
+		require.NoError(t, err)
+		return sccp
+	}
+
+	// diamond stores 10 or 20 into local 1 depending on cond and returns it.
+	diamond := func(cond ...instr.Instruction) *types.Function {
+		b := types.NewFunctionBuilder(unary).Locals(types.TypeI32)
+		other, merge := b.Label(), b.Label()
+		return b.Emit(cond...).BrIf(other).Emit(
+			instr.New(instr.I32_CONST, 10),
+			instr.New(instr.LOCAL_SET, 1),
+		).Br(merge).Bind(other).Emit(
+			instr.New(instr.I32_CONST, 20),
+			instr.New(instr.LOCAL_SET, 1),
+		).Bind(merge).Emit(
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.RETURN),
+		).MustBuild()
+	}
+
+	t.Run("propagates a local across blocks", func(t *testing.T) {
+		b := types.NewFunctionBuilder(unary).Locals(types.TypeI32)
+		next := b.Label()
+		fn := b.Emit(
+			instr.New(instr.I32_CONST, 5),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.LOCAL_GET, 0),
+		).BrIf(next).Bind(next).Emit(
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.RETURN),
+		).MustBuild()
+
+		sccp := run(t, fn)
+		require.Equal(t, []bool{true, true}, sccp.Reachable)
+		require.Equal(t, map[int]analysis.Constant{12: {Kind: instr.KindI32, Bits: 5}}, sccp.Values)
+		require.Empty(t, sccp.Branches)
+	})
+
+	t.Run("decides a branch and skips its arm", func(t *testing.T) {
+		fn := diamond(
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.I32_EQZ),
+		)
+
+		sccp := run(t, fn)
+		require.Equal(t, map[int]analysis.Decision{10: {Target: 13, Cond: 7}}, sccp.Branches)
+		require.Equal(t, []bool{true, true, false, true}, sccp.Reachable)
+		require.Equal(t, map[int]analysis.Constant{
+			7:  {Kind: instr.KindI32, Bits: 1},
+			30: {Kind: instr.KindI32, Bits: 10},
+		}, sccp.Values)
+	})
+
+	t.Run("merges differing constants into an unknown", func(t *testing.T) {
+		fn := diamond(instr.New(instr.LOCAL_GET, 0))
+
+		sccp := run(t, fn)
+		require.Empty(t, sccp.Branches)
+		require.Empty(t, sccp.Values)
+		require.Equal(t, []bool{true, true, true, true}, sccp.Reachable)
+	})
+
+	t.Run("keeps a condition that would trap", func(t *testing.T) {
+		fn := diamond(
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_CONST, 0),
+			instr.New(instr.I32_DIV_S),
+		)
+
+		sccp := run(t, fn)
+		require.Empty(t, sccp.Branches)
+	})
+
+	t.Run("loop-carried locals are unknown", func(t *testing.T) {
+		b := types.NewFunctionBuilder(unary)
+		head, done := b.Label(), b.Label()
+		fn := b.Emit(
+			instr.New(instr.I32_CONST, 3),
+			instr.New(instr.LOCAL_SET, 0),
+		).Bind(head).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_EQZ),
+		).BrIf(done).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_SUB),
+			instr.New(instr.LOCAL_SET, 0),
+		).Br(head).Bind(done).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.RETURN),
+		).MustBuild()
+
+		sccp := run(t, fn)
+		require.Empty(t, sccp.Values)
+		require.Empty(t, sccp.Branches)
+	})
+
+	t.Run("decides a branch table", func(t *testing.T) {
+		b := types.NewFunctionBuilder(unary)
+		zero, one, def := b.Label(), b.Label(), b.Label()
+		fn := b.Emit(
+			instr.New(instr.I32_CONST, 7),
+			instr.New(instr.I32_CONST, 6),
+			instr.New(instr.I32_SUB),
+		).BrTable(def, zero, one).Bind(zero).Emit(
+			instr.New(instr.I32_CONST, 0),
+			instr.New(instr.RETURN),
+		).Bind(one).Emit(
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.RETURN),
+		).Bind(def).Emit(
+			instr.New(instr.I32_CONST, 2),
+			instr.New(instr.RETURN),
+		).MustBuild()
+
+		sccp := run(t, fn)
+		require.Equal(t, map[int]analysis.Decision{11: {Target: 25, Cond: 0}}, sccp.Branches)
+		require.Equal(t, []bool{true, false, true, false}, sccp.Reachable)
+	})
+}
@@ -6,10 +6,27 @@ import (
 	"github.com/siyul-park/minivm/asm"
 )
 
+// abi implements asm.ABI for x86-64 context-pointer invocation.
 type abi struct{}
 
-var _ asm.ABI = abi{}
+// caller implements asm.Callable for an amd64 native entry. The trampoline
+// passes ctx in RDI and preserves the registers JIT code pins.
+type caller struct {
+	addr unsafe.Pointer
+}
+
+var (
+	_ asm.ABI      = abi{}
+	_ asm.Callable = (*caller)(nil)
+)
 
-func (abi) NewCallable(_ unsafe.Pointer) (asm.Callable, error) {
-	return nil, asm.ErrNotImplemented
+func (abi) NewCallable(addr unsafe.Pointer) (asm.Callable, error) {
+	return &caller{addr: addr}, nil
 }
+
+func (c *caller) Call(ctx unsafe.Pointer) error {
+	invoke(uintptr(c.addr), ctx)
+	return nil
+}
+
+func (c *caller) Addr() unsafe.Pointer { return c.addr }
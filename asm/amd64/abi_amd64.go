@@ -0,0 +1,10 @@
+//go:build amd64
+
+package amd64
+
+import "unsafe"
+
+// invoke calls the compiled native block at addr with ctx passed in RDI.
+//
+//go:noescape
+func invoke(addr uintptr, ctx unsafe.Pointer)
@@ -0,0 +1,30 @@
+#include "textflag.h"
+
+// func invoke(addr uintptr, ctx unsafe.Pointer)
+//
+// ctx is passed to native code in DI. BX and R12-R15 hold pinned VM context
+// registers, and Go's ABIInternal gives R14 (g) a fixed role, so the
+// trampoline preserves all five. The encoder uses X15 as scratch; it is
+// zeroed again before returning to Go code that assumes a zero X15.
+// The frame reserves 4096 bytes for the pushes the encoder's division and
+// shift expansions emit. Native code starts at the top of that reserve so it
+// never crosses the Go stack frame or bypasses Go's stack-growth check.
+
+TEXT ·invoke(SB), $4136-16
+    MOVQ BX,  0(SP)
+    MOVQ R12, 8(SP)
+    MOVQ R13, 16(SP)
+    MOVQ R14, 24(SP)
+    MOVQ R15, 32(SP)
+    MOVQ addr+0(FP), AX
+    MOVQ ctx+8(FP), DI
+    ADDQ $4136, SP
+    CALL AX
+    SUBQ $4136, SP
+    MOVQ  0(SP), BX
+    MOVQ  8(SP), R12
+    MOVQ 16(SP), R13
+    MOVQ 24(SP), R14
+    MOVQ 32(SP), R15
+    XORPS X15, X15
+    RET
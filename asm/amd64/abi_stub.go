@@ -0,0 +1,9 @@
+//go:build !amd64
+
+package amd64
+
+import "unsafe"
+
+func invoke(addr uintptr, ctx unsafe.Pointer) {
+	panic("not implemented")
+}
@@ -1,25 +1,39 @@
-// Package amd64 is a stub backend confirming the asm public surface is
-// arch-portable. Encoder.Encode and every ABI method return
-// asm.ErrNotImplemented.
+// Package amd64 targets x86-64. Its Encoder lowers the architecture-neutral
+// three-register form onto x86's two-operand instructions, and its ABI calls
+// native code through a Go assembly trampoline that passes the context in
+// RDI. Spilling is unsupported: Frame returns nil.
 package amd64
 
 import "github.com/siyul-park/minivm/asm"
 
 type arch struct {
 	registers asm.RegInfo
-	encoder   encoder
+	encoder   *Encoder
 	abi       abi
 }
 
 var _ asm.Arch = arch{}
 
-// New returns an asm.Arch placeholder for amd64. Every operation that would
-// actually need to emit machine code returns asm.ErrNotImplemented.
+// New returns an asm.Arch targeting x86-64. The arch's encoder and ABI are
+// stateless singletons; allocate once per process.
 func New() asm.Arch {
 	return arch{
-		registers: asm.NewRegInfo(16, 16, nil, nil, nil),
-		encoder:   encoder{},
-		abi:       abi{},
+		registers: asm.NewRegInfo(
+			16, 16,
+			// RSP and RBP belong to the Go runtime's stack and frame
+			// pointer; R11 is the encoder's scratch for pseudo-instruction
+			// expansions.
+			[]uint8{4, 5, 11},
+			// X15 is the encoder's float scratch. Go's ABIInternal expects
+			// it to be zero, which the invoke trampoline restores.
+			[]uint8{15},
+			// RBX, R12-R15: pinned VM context registers, saved and restored
+			// by the invoke trampoline. Pinning can claim them explicitly;
+			// auto-allocation cannot.
+			[]uint8{3, 12, 13, 14, 15},
+		),
+		encoder: NewEncoder(),
+		abi:     abi{},
 	}
 }
 
@@ -1,11 +1,775 @@
 package amd64
 
-import "github.com/siyul-park/minivm/asm"
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
 
-type encoder struct{}
+	"github.com/siyul-park/minivm/asm"
+)
 
-var _ asm.Encoder = encoder{}
+// ---------------------------------------------------------------------------
+// Encoder
+// ---------------------------------------------------------------------------
 
-func (encoder) Encode(_ asm.Instruction) ([]byte, error) {
-	return nil, asm.ErrNotImplemented
+// Encoder lowers asm instructions to x86-64 machine code. Three-register
+// forms and the division, shift, select, and SSE pseudo-instructions expand
+// to short fixed sequences that use R11 and X15 as scratch, so neither may
+// be handed to the register allocator.
+type Encoder struct{}
+
+// ---------------------------------------------------------------------------
+// Sentinel errors
+// ---------------------------------------------------------------------------
+
+var (
+	ErrUnsupportedOpcode     = errors.New("unsupported opcode")
+	ErrMissingDestinationReg = errors.New("missing destination register")
+	ErrMissingSourceReg      = errors.New("missing source register")
+	ErrMissingSourceRegs     = errors.New("missing source registers")
+	ErrMissingImmediate      = errors.New("missing immediate")
+	ErrMissingMemoryOperand  = errors.New("missing memory operand")
+	ErrMissingBranchOffset   = errors.New("missing branch offset")
+)
+
+var _ asm.Encoder = (*Encoder)(nil)
+
+// Register ids the encoder claims for its own expansions.
+const (
+	rax     = 0
+	rcx     = 1
+	rdx     = 2
+	scratch = 11 // R11
+	xtmp    = 15 // X15
+)
+
+// aluOpcodes maps each integer register-register opcode to its "op r/m, reg"
+// opcode and its /digit for the immediate group-1 form (0x81 / 0x83).
+var aluOpcodes = map[Op]struct {
+	rr   byte
+	ext  byte
+	comm bool
+}{
+	OpADD: {0x01, 0, true},
+	OpOR:  {0x09, 1, true},
+	OpAND: {0x21, 4, true},
+	OpSUB: {0x29, 5, false},
+	OpXOR: {0x31, 6, true},
+}
+
+// aluImmOpcodes maps each immediate opcode to its group-1 /digit.
+var aluImmOpcodes = map[Op]byte{
+	OpADDI: 0,
+	OpORI:  1,
+	OpANDI: 4,
+	OpSUBI: 5,
+	OpXORI: 6,
+}
+
+// shiftOpcodes maps each shift opcode to its group-2 /digit.
+var shiftOpcodes = map[Op]byte{
+	OpSHL:  4,
+	OpSHR:  5,
+	OpSAR:  7,
+	OpSHLI: 4,
+	OpSHRI: 5,
+	OpSARI: 7,
+}
+
+// floatBinaryOpcodes maps each scalar SSE opcode to its second opcode byte
+// and whether its operands commute. F2 selects SD, F3 selects SS.
+var floatBinaryOpcodes = map[Op]struct {
+	op   byte
+	comm bool
+}{
+	OpFADD: {0x58, true},
+	OpFMUL: {0x59, true},
+	OpFSUB: {0x5C, false},
+	OpFDIV: {0x5E, false},
+}
+
+func NewEncoder() *Encoder { return &Encoder{} }
+
+func (e *Encoder) Encode(inst asm.Instruction) ([]byte, error) {
+	op := Op(inst.Op)
+	switch op {
+
+	// -----------------------------------------------------------------------
+	// Move
+	// -----------------------------------------------------------------------
+
+	case OpMOV:
+		d, s, err := e.decodeReg2(inst)
+		if err != nil {
+			return nil, err
+		}
+		w, err := sameKind(asm.RegTypeInt, d, s)
+		if err != nil {
+			return nil, err
+		}
+		return mov(nil, w == asm.Width64, id(d), id(s)), nil
+
+	case OpMOVI:
+		d, v, err := e.decodeDstImm(inst)
+		if err != nil {
+			return nil, err
+		}
+		w, err := sameKind(asm.RegTypeInt, d)
+		if err != nil {
+			return nil, err
+		}
+		return movi(nil, w == asm.Width64, id(d), v), nil
+
+	case OpMOVSXD:
+		d, s, err := e.decodeReg2(inst)
+		if err != nil {
+			return nil, err
+		}
+		if d.Type() != asm.RegTypeInt || d.Width() != asm.Width64 || s.Type() != asm.RegTypeInt {
+			return nil, asm.ErrInvalidOperand
+		}
+		return rr(nil, true, []byte{0x63}, id(d), id(s)), nil
+
+	// -----------------------------------------------------------------------
+	// Load / Store
+	// -----------------------------------------------------------------------
+
+	case OpLOAD:
+		d, base, off, err := e.decodeMemOp(inst)
+		if err != nil {
+			return nil, err
+		}
+		w, err := sameKind(asm.RegTypeInt, d)
+		if err != nil {
+			return nil, err
+		}
+		return mem(nil, w == asm.Width64, 0x8B, id(d), id(base), off)
+
+	case OpSTORE:
+		s, base, off, err := e.decodeStrOp(inst)
+		if err != nil {
+			return nil, err
+		}
+		w, err := sameKind(asm.RegTypeInt, s)
+		if err != nil {
+			return nil, err
+		}
+		return mem(nil, w == asm.Width64, 0x89, id(s), id(base), off)
+
+	// -----------------------------------------------------------------------
+	// Arithmetic / bitwise — register
+	// -----------------------------------------------------------------------
+
+	case OpADD, OpSUB, OpAND, OpOR, OpXOR:
+		d, n, m, err := e.decodeReg3(inst)
+		if err != nil {
+			return nil, err
+		}
+		w, err := sameKind(asm.RegTypeInt, d, n, m)
+		if err != nil {
+			return nil, err
+		}
+		wide := w == asm.Width64
+		alu := aluOpcodes[op]
+		apply := func(b []byte, dst, src uint8) []byte { return rr(b, wide, []byte{alu.rr}, src, dst) }
+		return binary3(wide, id(d), id(n), id(m), alu.comm, apply), nil
+
+	case OpIMUL:
+		d, n, m, err := e.decodeReg3(inst)
+		if err != nil {
+			return nil, err
+		}
+		w, err := sameKind(asm.RegTypeInt, d, n, m)
+		if err != nil {
+			return nil, err
+		}
+		wide := w == asm.Width64
+		apply := func(b []byte, dst, src uint8) []byte { return rr(b, wide, []byte{0x0F, 0xAF}, dst, src) }
+		return binary3(wide, id(d), id(n), id(m), true, apply), nil
+
+	case OpIDIV, OpDIV, OpIREM, OpREM:
+		d, n, m, err := e.decodeReg3(inst)
+		if err != nil {
+			return nil, err
+		}
+		w, err := sameKind(asm.RegTypeInt, d, n, m)
+		if err != nil {
+			return nil, err
+		}
+		if w != asm.Width64 {
+			return nil, asm.ErrInvalidOperand
+		}
+		return divide(op, id(d), id(n), id(m)), nil
+
+	// -----------------------------------------------------------------------
+	// Arithmetic / bitwise — immediate
+	// -----------------------------------------------------------------------
+
+	case OpADDI, OpSUBI, OpANDI, OpORI, OpXORI:
+		d, n, v, err := e.decodeRegImm(inst)
+		if err != nil {
+			return nil, err
+		}
+		w, err := sameKind(asm.RegTypeInt, d, n)
+		if err != nil {
+			return nil, err
+		}
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return nil, fmt.Errorf("%w: immediate %d", ErrMissingImmediate, v)
+		}
+		wide := w == asm.Width64
+		b := mov(nil, wide, id(d), id(n))
+		return group1(b, wide, aluImmOpcodes[op], id(d), int32(v)), nil
+
+	// -----------------------------------------------------------------------
+	// Shift
+	// -----------------------------------------------------------------------
+
+	case OpSHL, OpSHR, OpSAR:
+		d, n, m, err := e.decodeReg3(inst)
+		if err != nil {
+			return nil, err
+		}
+		w, err := sameKind(asm.RegTypeInt, d, n)
+		if err != nil {
+			return nil, err
+		}
+		if m.Type() != asm.RegTypeInt {
+			return nil, asm.ErrInvalidOperand
+		}
+		wide := w == asm.Width64
+		// PUSH RCX; MOV R11, n; MOV RCX, m; SHx R11, CL; POP RCX; MOV d, R11
+		b := []byte{0x51}
+		b = mov(b, true, scratch, id(n))
+		b = mov(b, true, rcx, id(m))
+		b = rr(b, wide, []byte{0xD3}, shiftOpcodes[op], scratch)
+		b = append(b, 0x59)
+		return mov(b, wide, id(d), scratch), nil
+
+	case OpSHLI, OpSHRI, OpSARI:
+		d, n, v, err := e.decodeRegImm(inst)
+		if err != nil {
+			return nil, err
+		}
+		w, err := sameKind(asm.RegTypeInt, d, n)
+		if err != nil {
+			return nil, err
+		}
+		if v < 0 || v >= int64(w) {
+			return nil, fmt.Errorf("%w: shift %d", ErrMissingImmediate, v)
+		}
+		wide := w == asm.Width64
+		b := mov(nil, wide, id(d), id(n))
+		b = rr(b, wide, []byte{0xC1}, shiftOpcodes[op], id(d))
+		return append(b, byte(v)), nil
+
+	// -----------------------------------------------------------------------
+	// Compare
+	// -----------------------------------------------------------------------
+
+	case OpCMP, OpTEST:
+		n, m, err := e.decodeCmp(inst)
+		if err != nil {
+			return nil, err
+		}
+		w, err := sameKind(asm.RegTypeInt, n, m)
+		if err != nil {
+			return nil, err
+		}
+		opc := byte(0x39)
+		if op == OpTEST {
+			opc = 0x85
+		}
+		return rr(nil, w == asm.Width64, []byte{opc}, id(m), id(n)), nil
+
+	case OpCMPI:
+		n, v, err := e.decodeCmpImm(inst)
+		if err != nil {
+			return nil, err
+		}
+		w, err := sameKind(asm.RegTypeInt, n)
+		if err != nil {
+			return nil, err
+		}
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return nil, fmt.Errorf("%w: immediate %d", ErrMissingImmediate, v)
+		}
+		return group1(nil, w == asm.Width64, 7, id(n), int32(v)), nil
+
+	// -----------------------------------------------------------------------
+	// Conditional
+	// -----------------------------------------------------------------------
+
+	case OpSETCC:
+		d, cond, err := e.decodeDstImm(inst)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := sameKind(asm.RegTypeInt, d); err != nil {
+			return nil, err
+		}
+		// SETcc d8; MOVZX d32, d8. The REX prefix selects SPL-DIL rather
+		// than AH-BH for ids 4-7.
+		b := rex(nil, false, 0, 0, id(d), true)
+		b = append(b, 0x0F, 0x90|byte(cond&0xF), modrm(0, id(d)))
+		b = rex(b, false, id(d), 0, id(d), true)
+		return append(b, 0x0F, 0xB6, modrm(id(d), id(d))), nil
+
+	case OpCMOV:
+		d, t, cond, f, err := e.decodeSelect(inst)
+		if err != nil {
+			return nil, err
+		}
+		w, err := sameKind(asm.RegTypeInt, d, t, f)
+		if err != nil {
+			return nil, err
+		}
+		// MOV R11, f; CMOVcc R11, t; MOV d, R11
+		b := mov(nil, true, scratch, id(f))
+		b = rr(b, true, []byte{0x0F, 0x40 | byte(cond&0xF)}, scratch, id(t))
+		return mov(b, w == asm.Width64, id(d), scratch), nil
+
+	// -----------------------------------------------------------------------
+	// Float
+	// -----------------------------------------------------------------------
+
+	case OpFMOV:
+		d, s, err := e.decodeReg2(inst)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case d.Type() == asm.RegTypeFloat && s.Type() == asm.RegTypeFloat:
+			return movf(nil, id(d), id(s)), nil
+		case d.Type() == asm.RegTypeFloat:
+			// MOVQ/MOVD xmm, r
+			return rr([]byte{0x66}, s.Width() == asm.Width64, []byte{0x0F, 0x6E}, id(d), id(s)), nil
+		case s.Type() == asm.RegTypeFloat:
+			// MOVQ/MOVD r, xmm
+			return rr([]byte{0x66}, d.Width() == asm.Width64, []byte{0x0F, 0x7E}, id(s), id(d)), nil
+		default:
+			return nil, asm.ErrInvalidOperand
+		}
+
+	case OpFADD, OpFSUB, OpFMUL, OpFDIV:
+		d, n, m, err := e.decodeReg3(inst)
+		if err != nil {
+			return nil, err
+		}
+		w, err := sameKind(asm.RegTypeFloat, d, n, m)
+		if err != nil {
+			return nil, err
+		}
+		prefix := scalarPrefix(w)
+		fop := floatBinaryOpcodes[op]
+		apply := func(b []byte, dst, src uint8) []byte {
+			return rr(append(b, prefix), false, []byte{0x0F, fop.op}, dst, src)
+		}
+		return float3(id(d), id(n), id(m), fop.comm, apply), nil
+
+	case OpUCOMIS:
+		n, m, err := e.decodeCmp(inst)
+		if err != nil {
+			return nil, err
+		}
+		w, err := sameKind(asm.RegTypeFloat, n, m)
+		if err != nil {
+			return nil, err
+		}
+		var b []byte
+		if w == asm.Width64 {
+			b = append(b, 0x66)
+		}
+		return rr(b, false, []byte{0x0F, 0x2E}, id(n), id(m)), nil
+
+	case OpCVTSI2S:
+		d, s, err := e.decodeReg2(inst)
+		if err != nil {
+			return nil, err
+		}
+		if d.Type() != asm.RegTypeFloat || s.Type() != asm.RegTypeInt {
+			return nil, asm.ErrInvalidOperand
+		}
+		return rr([]byte{scalarPrefix(d.Width())}, s.Width() == asm.Width64, []byte{0x0F, 0x2A}, id(d), id(s)), nil
+
+	case OpCVTTS2SI:
+		d, s, err := e.decodeReg2(inst)
+		if err != nil {
+			return nil, err
+		}
+		if d.Type() != asm.RegTypeInt || s.Type() != asm.RegTypeFloat {
+			return nil, asm.ErrInvalidOperand
+		}
+		return rr([]byte{scalarPrefix(s.Width())}, d.Width() == asm.Width64, []byte{0x0F, 0x2C}, id(d), id(s)), nil
+
+	// -----------------------------------------------------------------------
+	// Branch
+	// -----------------------------------------------------------------------
+
+	case OpJMP:
+		offset, err := e.decodeBranch(inst)
+		if err != nil {
+			return nil, err
+		}
+		rel, err := rel32(op, offset, 5)
+		if err != nil {
+			return nil, err
+		}
+		return binary.LittleEndian.AppendUint32([]byte{0xE9}, uint32(rel)), nil
+
+	case OpJCC:
+		cond, ok := inst.Src1.(asm.ImmOperand)
+		if !ok {
+			return nil, ErrMissingImmediate
+		}
+		offset, err := e.decodeBranch(inst)
+		if err != nil {
+			return nil, err
+		}
+		rel, err := rel32(op, offset, 6)
+		if err != nil {
+			return nil, err
+		}
+		return binary.LittleEndian.AppendUint32([]byte{0x0F, 0x80 | byte(cond.Value&0xF)}, uint32(rel)), nil
+
+	case OpRET:
+		return []byte{0xC3}, nil
+
+	case OpNOP:
+		return []byte{0x90}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %d", ErrUnsupportedOpcode, op)
+}
+
+func (e *Encoder) decodeReg3(inst asm.Instruction) (dst, src1, src2 asm.PReg, err error) {
+	dstOp, ok := inst.Dst.(asm.PRegOperand)
+	if !ok {
+		err = ErrMissingDestinationReg
+		return
+	}
+	s1, ok1 := inst.Src1.(asm.PRegOperand)
+	s2, ok2 := inst.Src2.(asm.PRegOperand)
+	if !ok1 || !ok2 {
+		err = ErrMissingSourceRegs
+		return
+	}
+	return dstOp.Reg, s1.Reg, s2.Reg, nil
+}
+
+func (e *Encoder) decodeReg2(inst asm.Instruction) (dst, src asm.PReg, err error) {
+	dstOp, ok := inst.Dst.(asm.PRegOperand)
+	if !ok {
+		return asm.PReg{}, asm.PReg{}, ErrMissingDestinationReg
+	}
+	srcOp, ok := inst.Src1.(asm.PRegOperand)
+	if !ok {
+		return asm.PReg{}, asm.PReg{}, ErrMissingSourceReg
+	}
+	return dstOp.Reg, srcOp.Reg, nil
+}
+
+func (e *Encoder) decodeRegImm(inst asm.Instruction) (dst, src asm.PReg, imm int64, err error) {
+	dstOp, ok := inst.Dst.(asm.PRegOperand)
+	if !ok {
+		return asm.PReg{}, asm.PReg{}, 0, ErrMissingDestinationReg
+	}
+	srcOp, ok := inst.Src1.(asm.PRegOperand)
+	if !ok {
+		return asm.PReg{}, asm.PReg{}, 0, ErrMissingSourceReg
+	}
+	immOp, ok := inst.Src2.(asm.ImmOperand)
+	if !ok {
+		return asm.PReg{}, asm.PReg{}, 0, ErrMissingImmediate
+	}
+	return dstOp.Reg, srcOp.Reg, immOp.Value, nil
+}
+
+func (e *Encoder) decodeDstImm(inst asm.Instruction) (dst asm.PReg, imm int64, err error) {
+	dstOp, ok := inst.Dst.(asm.PRegOperand)
+	if !ok {
+		return asm.PReg{}, 0, ErrMissingDestinationReg
+	}
+	immOp, ok := inst.Src1.(asm.ImmOperand)
+	if !ok {
+		return asm.PReg{}, 0, ErrMissingImmediate
+	}
+	return dstOp.Reg, immOp.Value, nil
+}
+
+func (e *Encoder) decodeCmp(inst asm.Instruction) (src1, src2 asm.PReg, err error) {
+	s1, ok := inst.Src1.(asm.PRegOperand)
+	if !ok {
+		return asm.PReg{}, asm.PReg{}, ErrMissingSourceReg
+	}
+	s2, ok := inst.Src2.(asm.PRegOperand)
+	if !ok {
+		return asm.PReg{}, asm.PReg{}, ErrMissingSourceReg
+	}
+	return s1.Reg, s2.Reg, nil
+}
+
+func (e *Encoder) decodeCmpImm(inst asm.Instruction) (src asm.PReg, imm int64, err error) {
+	srcOp, ok := inst.Src1.(asm.PRegOperand)
+	if !ok {
+		return asm.PReg{}, 0, ErrMissingSourceReg
+	}
+	immOp, ok := inst.Src2.(asm.ImmOperand)
+	if !ok {
+		return asm.PReg{}, 0, ErrMissingImmediate
+	}
+	return srcOp.Reg, immOp.Value, nil
+}
+
+func (e *Encoder) decodeSelect(inst asm.Instruction) (dst, t asm.PReg, cond int64, f asm.PReg, err error) {
+	dstOp, ok := inst.Dst.(asm.PRegOperand)
+	if !ok {
+		err = ErrMissingDestinationReg
+		return
+	}
+	tOp, ok1 := inst.Src1.(asm.PRegOperand)
+	fOp, ok2 := inst.Src3.(asm.PRegOperand)
+	if !ok1 || !ok2 {
+		err = ErrMissingSourceRegs
+		return
+	}
+	condOp, ok := inst.Src2.(asm.ImmOperand)
+	if !ok {
+		err = ErrMissingImmediate
+		return
+	}
+	return dstOp.Reg, tOp.Reg, condOp.Value, fOp.Reg, nil
+}
+
+func (e *Encoder) decodeMemOp(inst asm.Instruction) (dst, base asm.PReg, offset int64, err error) {
+	dstOp, ok := inst.Dst.(asm.PRegOperand)
+	if !ok {
+		return asm.PReg{}, asm.PReg{}, 0, ErrMissingDestinationReg
+	}
+	memOp, ok := inst.Src1.(asm.MemOperand)
+	if !ok {
+		return asm.PReg{}, asm.PReg{}, 0, ErrMissingMemoryOperand
+	}
+	baseOp, ok := memOp.Base.(asm.PRegOperand)
+	if !ok {
+		return asm.PReg{}, asm.PReg{}, 0, ErrMissingMemoryOperand
+	}
+	return dstOp.Reg, baseOp.Reg, memOp.Offset, nil
+}
+
+func (e *Encoder) decodeStrOp(inst asm.Instruction) (src, base asm.PReg, offset int64, err error) {
+	memOp, ok := inst.Dst.(asm.MemOperand)
+	if !ok {
+		return asm.PReg{}, asm.PReg{}, 0, ErrMissingMemoryOperand
+	}
+	baseOp, ok := memOp.Base.(asm.PRegOperand)
+	if !ok {
+		return asm.PReg{}, asm.PReg{}, 0, ErrMissingMemoryOperand
+	}
+	srcOp, ok := inst.Src1.(asm.PRegOperand)
+	if !ok {
+		return asm.PReg{}, asm.PReg{}, 0, ErrMissingSourceReg
+	}
+	return srcOp.Reg, baseOp.Reg, memOp.Offset, nil
+}
+
+func (e *Encoder) decodeBranch(inst asm.Instruction) (int64, error) {
+	immOp, ok := inst.Src2.(asm.ImmOperand)
+	if !ok {
+		return 0, ErrMissingBranchOffset
+	}
+	return immOp.Value, nil
+}
+
+// binary3 lowers dst = src1 op src2 onto the two-operand form apply encodes
+// (dst op= src), copying through R11 when dst aliases src2 and op does not
+// commute.
+func binary3(wide bool, d, n, m uint8, comm bool, apply func(b []byte, dst, src uint8) []byte) []byte {
+	switch {
+	case d == n:
+		return apply(nil, d, m)
+	case d == m && comm:
+		return apply(nil, d, n)
+	case d != m:
+		return apply(mov(nil, wide, d, n), d, m)
+	default:
+		b := mov(nil, true, scratch, n)
+		b = apply(b, scratch, m)
+		return mov(b, wide, d, scratch)
+	}
+}
+
+// float3 is binary3 for XMM registers, copying through X15.
+func float3(d, n, m uint8, comm bool, apply func(b []byte, dst, src uint8) []byte) []byte {
+	switch {
+	case d == n:
+		return apply(nil, d, m)
+	case d == m && comm:
+		return apply(nil, d, n)
+	case d != m:
+		return apply(movf(nil, d, n), d, m)
+	default:
+		b := movf(nil, xtmp, n)
+		b = apply(b, xtmp, m)
+		return movf(b, d, xtmp)
+	}
+}
+
+// divide expands a 64-bit division around RAX:RDX, preserving both:
+//
+//	PUSH RAX; PUSH RDX; MOV R11, m; MOV RAX, n; CQO | XOR EDX, EDX
+//	IDIV | DIV R11; MOV R11, RAX | RDX; POP RDX; POP RAX; MOV d, R11
+func divide(op Op, d, n, m uint8) []byte {
+	b := []byte{0x50, 0x52}
+	b = mov(b, true, scratch, m)
+	b = mov(b, true, rax, n)
+	signed := op == OpIDIV || op == OpIREM
+	if signed {
+		b = append(b, 0x48, 0x99)
+	} else {
+		b = append(b, 0x31, 0xD2)
+	}
+	ext := byte(6)
+	if signed {
+		ext = 7
+	}
+	b = rr(b, true, []byte{0xF7}, ext, scratch)
+	result := uint8(rax)
+	if op == OpIREM || op == OpREM {
+		result = rdx
+	}
+	b = mov(b, true, scratch, result)
+	b = append(b, 0x5A, 0x58)
+	return mov(b, true, d, scratch)
+}
+
+// group1 appends the 0x83 (imm8) or 0x81 (imm32) form of a group-1 ALU op
+// on rm.
+func group1(b []byte, wide bool, ext, rm uint8, v int32) []byte {
+	if v >= math.MinInt8 && v <= math.MaxInt8 {
+		b = rr(b, wide, []byte{0x83}, ext, rm)
+		return append(b, byte(int8(v)))
+	}
+	b = rr(b, wide, []byte{0x81}, ext, rm)
+	return binary.LittleEndian.AppendUint32(b, uint32(v))
+}
+
+// mov appends MOV dst, src. A 64-bit self-move is elided; a 32-bit one is
+// kept because it clears the upper half.
+func mov(b []byte, wide bool, dst, src uint8) []byte {
+	if wide && dst == src {
+		return b
+	}
+	return rr(b, wide, []byte{0x89}, src, dst)
+}
+
+// movi appends the shortest flag-preserving load of v into dst.
+func movi(b []byte, wide bool, dst uint8, v int64) []byte {
+	switch {
+	case !wide || (v >= 0 && v <= math.MaxUint32):
+		b = rex(b, false, 0, 0, dst, false)
+		b = append(b, 0xB8|dst&7)
+		return binary.LittleEndian.AppendUint32(b, uint32(v))
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		b = rr(b, true, []byte{0xC7}, 0, dst)
+		return binary.LittleEndian.AppendUint32(b, uint32(v))
+	default:
+		b = rex(b, true, 0, 0, dst, false)
+		b = append(b, 0xB8|dst&7)
+		return binary.LittleEndian.AppendUint64(b, uint64(v))
+	}
+}
+
+// movf appends MOVAPD dst, src unless the registers coincide.
+func movf(b []byte, dst, src uint8) []byte {
+	if dst == src {
+		return b
+	}
+	return rr(append(b, 0x66), false, []byte{0x0F, 0x28}, dst, src)
+}
+
+// rr appends a register-direct ModRM instruction.
+func rr(b []byte, wide bool, opcode []byte, reg, rm uint8) []byte {
+	b = rex(b, wide, reg, 0, rm, false)
+	b = append(b, opcode...)
+	return append(b, modrm(reg, rm))
+}
+
+// mem appends a [base+disp] ModRM instruction. It always uses a disp8 or
+// disp32 form so RBP and R13 need no special case, and adds the SIB byte
+// RSP and R12 require.
+func mem(b []byte, wide bool, opcode byte, reg, base uint8, disp int64) ([]byte, error) {
+	if disp < math.MinInt32 || disp > math.MaxInt32 {
+		return nil, fmt.Errorf("%w: displacement %d", asm.ErrInvalidOperand, disp)
+	}
+	b = rex(b, wide, reg, 0, base, false)
+	b = append(b, opcode)
+	short := disp >= math.MinInt8 && disp <= math.MaxInt8
+	mod := byte(0x80)
+	if short {
+		mod = 0x40
+	}
+	b = append(b, mod|(reg&7)<<3|base&7)
+	if base&7 == 4 {
+		b = append(b, 0x24)
+	}
+	if short {
+		return append(b, byte(int8(disp))), nil
+	}
+	return binary.LittleEndian.AppendUint32(b, uint32(int32(disp))), nil
+}
+
+// rex appends a REX prefix when any of its bits are needed or force is set.
+func rex(b []byte, wide bool, reg, index, base uint8, force bool) []byte {
+	v := byte(0x40)
+	if wide {
+		v |= 0x08
+	}
+	v |= (reg >> 3 & 1) << 2
+	v |= (index >> 3 & 1) << 1
+	v |= base >> 3 & 1
+	if v != 0x40 || force {
+		b = append(b, v)
+	}
+	return b
+}
+
+func modrm(reg, rm uint8) byte { return 0xC0 | (reg&7)<<3 | rm&7 }
+
+// rel32 converts a displacement from the instruction start into the rel32
+// field of a branch size bytes long.
+func rel32(op Op, offset int64, size int64) (int32, error) {
+	rel := offset - size
+	if rel < math.MinInt32 || rel > math.MaxInt32 {
+		return 0, fmt.Errorf("%w: op=%v offset=%d", asm.ErrBranchOutOfRange, op, offset)
+	}
+	return int32(rel), nil
+}
+
+func scalarPrefix(w asm.RegWidth) byte {
+	if w == asm.Width32 {
+		return 0xF3
+	}
+	return 0xF2
+}
+
+func id(r asm.PReg) uint8 { return r.ID() & 0xF }
+
+// sameKind verifies that every reg has the given type and a uniform 32- or
+// 64-bit width. Returns the shared width.
+func sameKind(typ asm.RegType, regs ...asm.PReg) (asm.RegWidth, error) {
+	if len(regs) == 0 {
+		return 0, asm.ErrInvalidOperand
+	}
+	width := regs[0].Width()
+	if regs[0].Type() != typ || (width != asm.Width32 && width != asm.Width64) {
+		return 0, asm.ErrInvalidOperand
+	}
+	for _, r := range regs[1:] {
+		if r.Type() != typ || r.Width() != width {
+			return 0, asm.ErrInvalidOperand
+		}
+	}
+	return width, nil
 }
@@ -3,32 +3,190 @@ package amd64_test
 import (
 	"testing"
 
-	"github.com/stretchr/testify/require"
-
 	"github.com/siyul-park/minivm/asm"
 	amd64 "github.com/siyul-park/minivm/asm/amd64"
+	"github.com/stretchr/testify/require"
 )
 
-func TestNew(t *testing.T) {
-	t.Run("register info is present", func(t *testing.T) {
-		arch := amd64.New()
-		require.NotZero(t, arch.Registers())
-	})
+func TestNewEncoder(t *testing.T) {
+	require.NotNil(t, amd64.NewEncoder())
+}
 
-	t.Run("encoder reports not implemented", func(t *testing.T) {
-		arch := amd64.New()
-		_, err := arch.Encoder().Encode(asm.Instruction{})
-		require.ErrorIs(t, err, asm.ErrNotImplemented)
-	})
+func TestEncoder_Encode(t *testing.T) {
+	encoder := amd64.NewEncoder()
 
-	t.Run("ABI.NewCallable reports not implemented", func(t *testing.T) {
-		arch := amd64.New()
-		_, err := arch.ABI().NewCallable(nil)
-		require.ErrorIs(t, err, asm.ErrNotImplemented)
+	goldens := []struct {
+		name string
+		inst asm.Instruction
+		want []byte
+	}{
+		{"MOV RAX,RCX", amd64.MOV(amd64.RAX, amd64.RCX), []byte{0x48, 0x89, 0xC8}},
+		{"MOV R8,R15", amd64.MOV(amd64.R8, amd64.R15), []byte{0x4D, 0x89, 0xF8}},
+		{"MOV EAX,R9D", amd64.MOV(amd64.EAX, amd64.R9D), []byte{0x44, 0x89, 0xC8}},
+		{"MOVI RAX,#1", amd64.MOVI(amd64.RAX, 1), []byte{0xB8, 0x01, 0x00, 0x00, 0x00}},
+		{"MOVI R9,#-1", amd64.MOVI(amd64.R9, -1), []byte{0x49, 0xC7, 0xC1, 0xFF, 0xFF, 0xFF, 0xFF}},
+		{
+			"MOVI R10,#0x1122334455667788", amd64.MOVI(amd64.R10, 0x1122334455667788),
+			[]byte{0x49, 0xBA, 0x88, 0x77, 0x66, 0x55, 0x44, 0x33, 0x22, 0x11},
+		},
+		{"MOVSXD R8,EAX", amd64.MOVSXD(amd64.R8, amd64.EAX), []byte{0x4C, 0x63, 0xC0}},
+		{"LOAD RAX,[R12+8]", amd64.LOAD(amd64.RAX, amd64.R12, 8), []byte{0x49, 0x8B, 0x44, 0x24, 0x08}},
+		{"LOAD EAX,[R13]", amd64.LOAD(amd64.EAX, amd64.R13, 0), []byte{0x41, 0x8B, 0x45, 0x00}},
+		{"STORE [R14-8],RDX", amd64.STORE(amd64.RDX, amd64.R14, -8), []byte{0x49, 0x89, 0x56, 0xF8}},
+		{"STORE [RBX+1024],R8", amd64.STORE(amd64.R8, amd64.RBX, 1024), []byte{0x4C, 0x89, 0x83, 0x00, 0x04, 0x00, 0x00}},
+		{"ADD RAX,RAX,RCX", amd64.ADD(amd64.RAX, amd64.RAX, amd64.RCX), []byte{0x48, 0x01, 0xC8}},
+		{"ADD RAX,RCX,RAX", amd64.ADD(amd64.RAX, amd64.RCX, amd64.RAX), []byte{0x48, 0x01, 0xC8}},
+		{"ADD RAX,RCX,RDX", amd64.ADD(amd64.RAX, amd64.RCX, amd64.RDX), []byte{0x48, 0x89, 0xC8, 0x48, 0x01, 0xD0}},
+		{
+			"SUB RAX,RCX,RAX", amd64.SUB(amd64.RAX, amd64.RCX, amd64.RAX),
+			[]byte{0x49, 0x89, 0xCB, 0x49, 0x29, 0xC3, 0x4C, 0x89, 0xD8},
+		},
+		{"SUB R8D,R9D,R10D", amd64.SUB(amd64.R8D, amd64.R9D, amd64.R10D), []byte{0x45, 0x89, 0xC8, 0x45, 0x29, 0xD0}},
+		{"IMUL RSI,RDI,R8", amd64.IMUL(amd64.RSI, amd64.RDI, amd64.R8), []byte{0x48, 0x89, 0xFE, 0x49, 0x0F, 0xAF, 0xF0}},
+		{"AND RAX,RAX,R9", amd64.AND(amd64.RAX, amd64.RAX, amd64.R9), []byte{0x4C, 0x21, 0xC8}},
+		{"ANDI R9,RCX,#15", amd64.ANDI(amd64.R9, amd64.RCX, 15), []byte{0x49, 0x89, 0xC9, 0x49, 0x83, 0xE1, 0x0F}},
+		{"ANDI RAX,RAX,#0xFF", amd64.ANDI(amd64.RAX, amd64.RAX, 0xFF), []byte{0x48, 0x81, 0xE0, 0xFF, 0x00, 0x00, 0x00}},
+		{"OR RAX,RAX,R9", amd64.OR(amd64.RAX, amd64.RAX, amd64.R9), []byte{0x4C, 0x09, 0xC8}},
+		{"XOR RAX,RAX,R9", amd64.XOR(amd64.RAX, amd64.RAX, amd64.R9), []byte{0x4C, 0x31, 0xC8}},
+		{"ADDI RAX,RCX,#8", amd64.ADDI(amd64.RAX, amd64.RCX, 8), []byte{0x48, 0x89, 0xC8, 0x48, 0x83, 0xC0, 0x08}},
+		{"SUBI R9,R9,#1000", amd64.SUBI(amd64.R9, amd64.R9, 1000), []byte{0x49, 0x81, 0xE9, 0xE8, 0x03, 0x00, 0x00}},
+		{"ORI RAX,RAX,#1", amd64.ORI(amd64.RAX, amd64.RAX, 1), []byte{0x48, 0x83, 0xC8, 0x01}},
+		{"XORI RAX,RAX,#-1", amd64.XORI(amd64.RAX, amd64.RAX, -1), []byte{0x48, 0x83, 0xF0, 0xFF}},
+		{
+			"SHL RAX,RDX,RCX", amd64.SHL(amd64.RAX, amd64.RDX, amd64.RCX),
+			[]byte{0x51, 0x49, 0x89, 0xD3, 0x49, 0xD3, 0xE3, 0x59, 0x4C, 0x89, 0xD8},
+		},
+		{
+			"SHR RAX,RDX,RCX", amd64.SHR(amd64.RAX, amd64.RDX, amd64.RCX),
+			[]byte{0x51, 0x49, 0x89, 0xD3, 0x49, 0xD3, 0xEB, 0x59, 0x4C, 0x89, 0xD8},
+		},
+		{
+			"SAR RAX,RDX,RCX", amd64.SAR(amd64.RAX, amd64.RDX, amd64.RCX),
+			[]byte{0x51, 0x49, 0x89, 0xD3, 0x49, 0xD3, 0xFB, 0x59, 0x4C, 0x89, 0xD8},
+		},
+		{"SHLI RAX,RCX,#15", amd64.SHLI(amd64.RAX, amd64.RCX, 15), []byte{0x48, 0x89, 0xC8, 0x48, 0xC1, 0xE0, 0x0F}},
+		{"SHRI R9,R9,#3", amd64.SHRI(amd64.R9, amd64.R9, 3), []byte{0x49, 0xC1, 0xE9, 0x03}},
+		{"SARI R9,R9,#3", amd64.SARI(amd64.R9, amd64.R9, 3), []byte{0x49, 0xC1, 0xF9, 0x03}},
+		{"SARI EAX,ECX,#31", amd64.SARI(amd64.EAX, amd64.ECX, 31), []byte{0x89, 0xC8, 0xC1, 0xF8, 0x1F}},
+		{
+			"IDIV RAX,RCX,RDX", amd64.IDIV(amd64.RAX, amd64.RCX, amd64.RDX),
+			[]byte{
+				0x50, 0x52, 0x49, 0x89, 0xD3, 0x48, 0x89, 0xC8, 0x48, 0x99, 0x49, 0xF7, 0xFB,
+				0x49, 0x89, 0xC3, 0x5A, 0x58, 0x4C, 0x89, 0xD8,
+			},
+		},
+		{
+			"DIV RAX,RCX,RDX", amd64.DIV(amd64.RAX, amd64.RCX, amd64.RDX),
+			[]byte{
+				0x50, 0x52, 0x49, 0x89, 0xD3, 0x48, 0x89, 0xC8, 0x31, 0xD2, 0x49, 0xF7, 0xF3,
+				0x49, 0x89, 0xC3, 0x5A, 0x58, 0x4C, 0x89, 0xD8,
+			},
+		},
+		{
+			"IREM RAX,RCX,RDX", amd64.IREM(amd64.RAX, amd64.RCX, amd64.RDX),
+			[]byte{
+				0x50, 0x52, 0x49, 0x89, 0xD3, 0x48, 0x89, 0xC8, 0x48, 0x99, 0x49, 0xF7, 0xFB,
+				0x49, 0x89, 0xD3, 0x5A, 0x58, 0x4C, 0x89, 0xD8,
+			},
+		},
+		{
+			"REM RCX,RCX,RCX", amd64.REM(amd64.RCX, amd64.RCX, amd64.RCX),
+			[]byte{
+				0x50, 0x52, 0x49, 0x89, 0xCB, 0x48, 0x89, 0xC8, 0x31, 0xD2, 0x49, 0xF7, 0xF3,
+				0x49, 0x89, 0xD3, 0x5A, 0x58, 0x4C, 0x89, 0xD9,
+			},
+		},
+		{"CMP RAX,RCX", amd64.CMP(amd64.RAX, amd64.RCX), []byte{0x48, 0x39, 0xC8}},
+		{"CMP R8D,R9D", amd64.CMP(amd64.R8D, amd64.R9D), []byte{0x45, 0x39, 0xC8}},
+		{"CMPI R10,#100000", amd64.CMPI(amd64.R10, 100000), []byte{0x49, 0x81, 0xFA, 0xA0, 0x86, 0x01, 0x00}},
+		{"TEST RAX,RAX", amd64.TEST(amd64.RAX, amd64.RAX), []byte{0x48, 0x85, 0xC0}},
+		{"SETCC RSI,L", amd64.SETCC(amd64.RSI, amd64.CondL), []byte{0x40, 0x0F, 0x9C, 0xC6, 0x40, 0x0F, 0xB6, 0xF6}},
+		{"SETCC R9,A", amd64.SETCC(amd64.R9, amd64.CondA), []byte{0x41, 0x0F, 0x97, 0xC1, 0x45, 0x0F, 0xB6, 0xC9}},
+		{
+			"CMOV RAX,RCX,RDX,NE", amd64.CMOV(amd64.RAX, amd64.RCX, amd64.RDX, amd64.CondNE),
+			[]byte{0x49, 0x89, 0xD3, 0x4C, 0x0F, 0x45, 0xD9, 0x4C, 0x89, 0xD8},
+		},
+		{"FMOV X1,X2", amd64.FMOV(amd64.X1, amd64.X2), []byte{0x66, 0x0F, 0x28, 0xCA}},
+		{"FMOV X9,R10", amd64.FMOV(amd64.X9, amd64.R10), []byte{0x66, 0x4D, 0x0F, 0x6E, 0xCA}},
+		{"FMOV RAX,X0", amd64.FMOV(amd64.RAX, amd64.X0), []byte{0x66, 0x48, 0x0F, 0x7E, 0xC0}},
+		{"FMOV S1,EAX", amd64.FMOV(amd64.S1, amd64.EAX), []byte{0x66, 0x0F, 0x6E, 0xC8}},
+		{"FADD X0,X0,X1", amd64.FADD(amd64.X0, amd64.X0, amd64.X1), []byte{0xF2, 0x0F, 0x58, 0xC1}},
+		{
+			"FSUB X0,X1,X0", amd64.FSUB(amd64.X0, amd64.X1, amd64.X0),
+			[]byte{0x66, 0x44, 0x0F, 0x28, 0xF9, 0xF2, 0x44, 0x0F, 0x5C, 0xF8, 0x66, 0x41, 0x0F, 0x28, 0xC7},
+		},
+		{"FMUL X8,X9,X10", amd64.FMUL(amd64.X8, amd64.X9, amd64.X10), []byte{0x66, 0x45, 0x0F, 0x28, 0xC1, 0xF2, 0x45, 0x0F, 0x59, 0xC2}},
+		{"FDIV S1,S1,S2", amd64.FDIV(amd64.S1, amd64.S1, amd64.S2), []byte{0xF3, 0x0F, 0x5E, 0xCA}},
+		{"UCOMIS X0,X1", amd64.UCOMIS(amd64.X0, amd64.X1), []byte{0x66, 0x0F, 0x2E, 0xC1}},
+		{"UCOMIS S9,S1", amd64.UCOMIS(amd64.S9, amd64.S1), []byte{0x44, 0x0F, 0x2E, 0xC9}},
+		{"CVTSI2S X0,RAX", amd64.CVTSI2S(amd64.X0, amd64.RAX), []byte{0xF2, 0x48, 0x0F, 0x2A, 0xC0}},
+		{"CVTSI2S S1,R9", amd64.CVTSI2S(amd64.S1, amd64.R9), []byte{0xF3, 0x49, 0x0F, 0x2A, 0xC9}},
+		{"CVTTS2SI RAX,X3", amd64.CVTTS2SI(amd64.RAX, amd64.X3), []byte{0xF2, 0x48, 0x0F, 0x2C, 0xC3}},
+		{"JMP #0", amd64.JMP(0), []byte{0xE9, 0xFB, 0xFF, 0xFF, 0xFF}},
+		{"JCC E,#16", amd64.JCC(amd64.CondE, 16), []byte{0x0F, 0x84, 0x0A, 0x00, 0x00, 0x00}},
+		{"RET", amd64.RET(), []byte{0xC3}},
+		{"NOP", amd64.NOP(), []byte{0x90}},
+	}
+	for _, tt := range goldens {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := encoder.Encode(tt.inst)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("covers every opcode", func(t *testing.T) {
+		covered := make(map[uint16]bool)
+		for _, tt := range goldens {
+			covered[tt.inst.Op] = true
+		}
+		// OpNOP closes the opcode list.
+		var missing []amd64.Op
+		for op := amd64.OpMOV; op <= amd64.OpNOP; op++ {
+			if !covered[uint16(op)] {
+				missing = append(missing, op)
+			}
+		}
+		require.Empty(t, missing)
 	})
 
-	t.Run("frame is unsupported", func(t *testing.T) {
-		arch := amd64.New()
-		require.Nil(t, arch.Frame())
+	t.Run("label branches keep their length", func(t *testing.T) {
+		a := asm.New(amd64.New())
+		loop := a.Label()
+		a.Bind(loop)
+		a.Emit(amd64.JCCLabel(amd64.CondNE, loop))
+		a.Emit(amd64.JMPLabel(loop))
+		code, err := a.Build()
+		require.NoError(t, err)
+		require.Equal(t, []byte{0x0F, 0x85, 0xFA, 0xFF, 0xFF, 0xFF, 0xE9, 0xF5, 0xFF, 0xFF, 0xFF}, code)
 	})
+
+	invalid := []struct {
+		name string
+		inst asm.Instruction
+		want error
+	}{
+		{
+			"unsupported opcode",
+			asm.Instruction{Op: 0xFFFF, Dst: asm.P(amd64.RAX), Src1: asm.P(amd64.RCX), Src2: asm.P(amd64.RDX)},
+			amd64.ErrUnsupportedOpcode,
+		},
+		{"mixed widths", amd64.ADD(amd64.RAX, amd64.RCX, amd64.EDX), asm.ErrInvalidOperand},
+		{
+			"missing immediate",
+			asm.Instruction{Op: uint16(amd64.OpADDI), Dst: asm.P(amd64.RAX), Src1: asm.P(amd64.RCX)},
+			amd64.ErrMissingImmediate,
+		},
+		{"shift beyond width", amd64.SHLI(amd64.EAX, amd64.EAX, 32), amd64.ErrMissingImmediate},
+		{"32-bit division", amd64.IDIV(amd64.EAX, amd64.ECX, amd64.EDX), asm.ErrInvalidOperand},
+		{"int destination for CVTSI2S", amd64.CVTSI2S(amd64.RAX, amd64.RCX), asm.ErrInvalidOperand},
+		{"float source for MOV", amd64.MOV(amd64.RAX, amd64.X0), asm.ErrInvalidOperand},
+		{"JCC offset exceeds rel32", amd64.JCC(amd64.CondE, -1<<31), asm.ErrBranchOutOfRange},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := encoder.Encode(tt.inst)
+			require.ErrorIs(t, err, tt.want)
+		})
+	}
 }
@@ -0,0 +1,299 @@
+package amd64
+
+import "github.com/siyul-park/minivm/asm"
+
+type Op uint16
+
+const (
+	// Move
+	OpMOV Op = iota
+	OpMOVI
+	OpMOVSXD
+
+	// Load / Store
+	OpLOAD
+	OpSTORE
+
+	// Arithmetic
+	OpADD
+	OpADDI
+	OpSUB
+	OpSUBI
+	OpIMUL
+	OpIDIV
+	OpDIV
+	OpIREM
+	OpREM
+
+	// Bitwise / Shift
+	OpAND
+	OpANDI
+	OpOR
+	OpORI
+	OpXOR
+	OpXORI
+	OpSHL
+	OpSHR
+	OpSAR
+	OpSHLI
+	OpSHRI
+	OpSARI
+
+	// Compare
+	OpCMP
+	OpCMPI
+	OpTEST
+
+	// Conditional
+	OpSETCC
+	OpCMOV
+
+	// Float
+	OpFMOV
+	OpFADD
+	OpFSUB
+	OpFMUL
+	OpFDIV
+	OpUCOMIS
+	OpCVTSI2S
+	OpCVTTS2SI
+
+	// Branch
+	OpJMP
+	OpJCC
+	OpRET
+
+	// System
+	OpNOP
+)
+
+// Condition codes are the low nibble of the Jcc, SETcc, and CMOVcc opcodes.
+const (
+	CondO  uint8 = 0x0
+	CondNO uint8 = 0x1
+	CondB  uint8 = 0x2 // unsigned <
+	CondAE uint8 = 0x3 // unsigned >=
+	CondE  uint8 = 0x4
+	CondNE uint8 = 0x5
+	CondBE uint8 = 0x6 // unsigned <=
+	CondA  uint8 = 0x7 // unsigned >
+	CondS  uint8 = 0x8
+	CondNS uint8 = 0x9
+	CondP  uint8 = 0xA // unordered after UCOMIS
+	CondNP uint8 = 0xB
+	CondL  uint8 = 0xC // signed <
+	CondGE uint8 = 0xD // signed >=
+	CondLE uint8 = 0xE // signed <=
+	CondG  uint8 = 0xF // signed >
+)
+
+// ---------------------------------------------------------------------------
+// Internal helpers
+// ---------------------------------------------------------------------------
+
+func newReg3(op Op, dst, src1, src2 asm.Reg) asm.Instruction {
+	return newInst(op, regOperand(dst), regOperand(src1), regOperand(src2))
+}
+
+func newReg2(op Op, dst, src asm.Reg) asm.Instruction {
+	return newInst(op, regOperand(dst), regOperand(src))
+}
+
+func newRegImm(op Op, dst, src asm.Reg, v int64) asm.Instruction {
+	return newInst(op, regOperand(dst), regOperand(src), imm(v))
+}
+
+func newCmp(op Op, src1, src2 asm.Reg) asm.Instruction {
+	return newInst(op, nil, regOperand(src1), regOperand(src2))
+}
+
+func newInst(op Op, dst asm.Operand, srcs ...asm.Operand) asm.Instruction {
+	var src1, src2, src3 asm.Operand
+	if len(srcs) > 0 {
+		src1 = srcs[0]
+	}
+	if len(srcs) > 1 {
+		src2 = srcs[1]
+	}
+	if len(srcs) > 2 {
+		src3 = srcs[2]
+	}
+	return asm.Instruction{Op: uint16(op), Dst: dst, Src1: src1, Src2: src2, Src3: src3}
+}
+
+// ---------------------------------------------------------------------------
+// Move
+// ---------------------------------------------------------------------------
+
+// MOV copies src into dst. A 32-bit move zero-extends into the full register.
+func MOV(dst, src asm.Reg) asm.Instruction { return newReg2(OpMOV, dst, src) }
+
+// MOVI dst, #imm picks the shortest of MOV r32, MOV r/m64 sign-extended, and
+// MOVABS. None of the forms touch the flags, so MOVI may sit between a
+// compare and the branch that reads it.
+func MOVI(dst asm.Reg, val int64) asm.Instruction {
+	return newInst(OpMOVI, regOperand(dst), imm(val))
+}
+
+// MOVSXD Rq, Rd sign-extends a 32-bit register into a 64-bit one.
+func MOVSXD(dst, src asm.Reg) asm.Instruction { return newReg2(OpMOVSXD, dst, src) }
+
+// ---------------------------------------------------------------------------
+// Load / Store
+// ---------------------------------------------------------------------------
+
+// LOAD dst, [base+offset]. A 32-bit dst zero-extends.
+func LOAD(dst, base asm.Reg, offset int32) asm.Instruction {
+	return newInst(OpLOAD, regOperand(dst), asm.Mem(regOperand(base), int64(offset)))
+}
+
+// STORE [base+offset], src writes src at its own width.
+func STORE(src, base asm.Reg, offset int32) asm.Instruction {
+	return newInst(OpSTORE, asm.Mem(regOperand(base), int64(offset)), regOperand(src))
+}
+
+// ---------------------------------------------------------------------------
+// Arithmetic
+// ---------------------------------------------------------------------------
+
+// The three-register forms are pseudo-instructions: the encoder lowers them
+// onto x86's two-operand forms, copying through R11 when dst aliases src2.
+
+func ADD(dst, src1, src2 asm.Reg) asm.Instruction    { return newReg3(OpADD, dst, src1, src2) }
+func ADDI(dst, src asm.Reg, i int32) asm.Instruction { return newRegImm(OpADDI, dst, src, int64(i)) }
+func SUB(dst, src1, src2 asm.Reg) asm.Instruction    { return newReg3(OpSUB, dst, src1, src2) }
+func SUBI(dst, src asm.Reg, i int32) asm.Instruction { return newRegImm(OpSUBI, dst, src, int64(i)) }
+func IMUL(dst, src1, src2 asm.Reg) asm.Instruction   { return newReg3(OpIMUL, dst, src1, src2) }
+func AND(dst, src1, src2 asm.Reg) asm.Instruction    { return newReg3(OpAND, dst, src1, src2) }
+func ANDI(dst, src asm.Reg, mask int32) asm.Instruction {
+	return newRegImm(OpANDI, dst, src, int64(mask))
+}
+func OR(dst, src1, src2 asm.Reg) asm.Instruction { return newReg3(OpOR, dst, src1, src2) }
+func ORI(dst, src asm.Reg, mask int32) asm.Instruction {
+	return newRegImm(OpORI, dst, src, int64(mask))
+}
+func XOR(dst, src1, src2 asm.Reg) asm.Instruction { return newReg3(OpXOR, dst, src1, src2) }
+func XORI(dst, src asm.Reg, mask int32) asm.Instruction {
+	return newRegImm(OpXORI, dst, src, int64(mask))
+}
+
+// IDIV, DIV, IREM, and REM divide src1 by src2 at 64-bit width. The encoder
+// saves RAX and RDX around the division, so any registers may be used; the
+// caller must rule out a zero divisor and, for the signed forms, MinInt64/-1.
+func IDIV(dst, src1, src2 asm.Reg) asm.Instruction { return newReg3(OpIDIV, dst, src1, src2) }
+func DIV(dst, src1, src2 asm.Reg) asm.Instruction  { return newReg3(OpDIV, dst, src1, src2) }
+func IREM(dst, src1, src2 asm.Reg) asm.Instruction { return newReg3(OpIREM, dst, src1, src2) }
+func REM(dst, src1, src2 asm.Reg) asm.Instruction  { return newReg3(OpREM, dst, src1, src2) }
+
+// ---------------------------------------------------------------------------
+// Shift
+// ---------------------------------------------------------------------------
+
+// Shift (register). x86 only shifts by CL; the encoder routes the count
+// through RCX and restores it, so any registers may be used. The count is
+// masked to the operand width like the hardware does.
+func SHL(dst, src1, src2 asm.Reg) asm.Instruction { return newReg3(OpSHL, dst, src1, src2) }
+func SHR(dst, src1, src2 asm.Reg) asm.Instruction { return newReg3(OpSHR, dst, src1, src2) }
+func SAR(dst, src1, src2 asm.Reg) asm.Instruction { return newReg3(OpSAR, dst, src1, src2) }
+
+// Shift (immediate)
+func SHLI(dst, src asm.Reg, shift uint8) asm.Instruction {
+	return newRegImm(OpSHLI, dst, src, int64(shift))
+}
+func SHRI(dst, src asm.Reg, shift uint8) asm.Instruction {
+	return newRegImm(OpSHRI, dst, src, int64(shift))
+}
+func SARI(dst, src asm.Reg, shift uint8) asm.Instruction {
+	return newRegImm(OpSARI, dst, src, int64(shift))
+}
+
+// ---------------------------------------------------------------------------
+// Compare
+// ---------------------------------------------------------------------------
+
+// CMP sets the flags from src1 - src2.
+func CMP(src1, src2 asm.Reg) asm.Instruction { return newCmp(OpCMP, src1, src2) }
+func CMPI(src asm.Reg, i int32) asm.Instruction {
+	return newInst(OpCMPI, nil, regOperand(src), imm(int64(i)))
+}
+func TEST(src1, src2 asm.Reg) asm.Instruction { return newCmp(OpTEST, src1, src2) }
+
+// ---------------------------------------------------------------------------
+// Conditional
+// ---------------------------------------------------------------------------
+
+// SETCC dst, cond — dst = cond ? 1 : 0, zero-extended to the full register.
+func SETCC(dst asm.Reg, cond uint8) asm.Instruction {
+	return newInst(OpSETCC, regOperand(dst), imm(int64(cond)))
+}
+
+// CMOV dst, t, f, cond — dst = cond ? t : f. Like CSEL, it reads the flags
+// of the preceding compare and leaves them intact.
+func CMOV(dst, t, f asm.Reg, cond uint8) asm.Instruction {
+	return newInst(OpCMOV, regOperand(dst), regOperand(t), imm(int64(cond)), regOperand(f))
+}
+
+// ---------------------------------------------------------------------------
+// Float
+// ---------------------------------------------------------------------------
+
+// FMOV moves between XMM registers or between an XMM and a general register
+// (MOVQ/MOVD); the register widths pick the form.
+func FMOV(dst, src asm.Reg) asm.Instruction { return newReg2(OpFMOV, dst, src) }
+
+// Scalar SSE arithmetic. S registers select the single-precision form.
+func FADD(dst, src1, src2 asm.Reg) asm.Instruction { return newReg3(OpFADD, dst, src1, src2) }
+func FSUB(dst, src1, src2 asm.Reg) asm.Instruction { return newReg3(OpFSUB, dst, src1, src2) }
+func FMUL(dst, src1, src2 asm.Reg) asm.Instruction { return newReg3(OpFMUL, dst, src1, src2) }
+func FDIV(dst, src1, src2 asm.Reg) asm.Instruction { return newReg3(OpFDIV, dst, src1, src2) }
+
+// UCOMIS sets ZF, PF, and CF from an unordered compare of src1 with src2.
+// Read it with the unsigned conditions; PF is set when either side is NaN.
+func UCOMIS(src1, src2 asm.Reg) asm.Instruction { return newCmp(OpUCOMIS, src1, src2) }
+
+// CVTSI2S converts a signed integer register to float.
+func CVTSI2S(dst, src asm.Reg) asm.Instruction { return newReg2(OpCVTSI2S, dst, src) }
+
+// CVTTS2SI converts a float to a signed integer, truncating toward zero.
+func CVTTS2SI(dst, src asm.Reg) asm.Instruction { return newReg2(OpCVTTS2SI, dst, src) }
+
+// ---------------------------------------------------------------------------
+// Branch
+// ---------------------------------------------------------------------------
+
+// JMP and JCC always use the rel32 form so that a branch keeps its length
+// between the draft and final encoding. offset is measured from the start of
+// the instruction, like every other asm branch displacement.
+func JMP(offset int32) asm.Instruction { return newInst(OpJMP, nil, nil, imm(int64(offset))) }
+func JCC(cond uint8, offset int32) asm.Instruction {
+	return newInst(OpJCC, nil, imm(int64(cond)), imm(int64(offset)))
+}
+func RET() asm.Instruction { return newInst(OpRET, nil) }
+
+// Build resolves label branches after every label is bound.
+func JMPLabel(id asm.Label) asm.Instruction {
+	return asm.Instruction{Op: uint16(OpJMP), Src2: asm.LabelOperand{ID: id}}
+}
+
+func JCCLabel(cond uint8, id asm.Label) asm.Instruction {
+	return asm.Instruction{Op: uint16(OpJCC), Src1: imm(int64(cond)), Src2: asm.LabelOperand{ID: id}}
+}
+
+// ---------------------------------------------------------------------------
+// System
+// ---------------------------------------------------------------------------
+
+func NOP() asm.Instruction { return newInst(OpNOP, nil) }
+
+func regOperand(reg asm.Reg) asm.Operand {
+	switch r := reg.(type) {
+	case asm.PReg:
+		return asm.P(r)
+	case asm.VReg:
+		return asm.V(r)
+	default:
+		panic("unsupported register type")
+	}
+}
+
+func imm(v int64) asm.Operand { return asm.ImmOperand{Value: v} }
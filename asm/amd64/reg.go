@@ -0,0 +1,73 @@
+package amd64
+
+import "github.com/siyul-park/minivm/asm"
+
+var (
+	RAX = asm.NewPReg(0, asm.RegTypeInt, asm.Width64)
+	RCX = asm.NewPReg(1, asm.RegTypeInt, asm.Width64)
+	RDX = asm.NewPReg(2, asm.RegTypeInt, asm.Width64)
+	RBX = asm.NewPReg(3, asm.RegTypeInt, asm.Width64)
+	RSP = asm.NewPReg(4, asm.RegTypeInt, asm.Width64)
+	RBP = asm.NewPReg(5, asm.RegTypeInt, asm.Width64)
+	RSI = asm.NewPReg(6, asm.RegTypeInt, asm.Width64)
+	RDI = asm.NewPReg(7, asm.RegTypeInt, asm.Width64)
+	R8  = asm.NewPReg(8, asm.RegTypeInt, asm.Width64)
+	R9  = asm.NewPReg(9, asm.RegTypeInt, asm.Width64)
+	R10 = asm.NewPReg(10, asm.RegTypeInt, asm.Width64)
+	R11 = asm.NewPReg(11, asm.RegTypeInt, asm.Width64)
+	R12 = asm.NewPReg(12, asm.RegTypeInt, asm.Width64)
+	R13 = asm.NewPReg(13, asm.RegTypeInt, asm.Width64)
+	R14 = asm.NewPReg(14, asm.RegTypeInt, asm.Width64)
+	R15 = asm.NewPReg(15, asm.RegTypeInt, asm.Width64)
+
+	EAX  = asm.NewPReg(0, asm.RegTypeInt, asm.Width32)
+	ECX  = asm.NewPReg(1, asm.RegTypeInt, asm.Width32)
+	EDX  = asm.NewPReg(2, asm.RegTypeInt, asm.Width32)
+	EBX  = asm.NewPReg(3, asm.RegTypeInt, asm.Width32)
+	ESI  = asm.NewPReg(6, asm.RegTypeInt, asm.Width32)
+	EDI  = asm.NewPReg(7, asm.RegTypeInt, asm.Width32)
+	R8D  = asm.NewPReg(8, asm.RegTypeInt, asm.Width32)
+	R9D  = asm.NewPReg(9, asm.RegTypeInt, asm.Width32)
+	R10D = asm.NewPReg(10, asm.RegTypeInt, asm.Width32)
+	R11D = asm.NewPReg(11, asm.RegTypeInt, asm.Width32)
+	R12D = asm.NewPReg(12, asm.RegTypeInt, asm.Width32)
+	R13D = asm.NewPReg(13, asm.RegTypeInt, asm.Width32)
+	R14D = asm.NewPReg(14, asm.RegTypeInt, asm.Width32)
+	R15D = asm.NewPReg(15, asm.RegTypeInt, asm.Width32)
+
+	X0  = asm.NewPReg(0, asm.RegTypeFloat, asm.Width64)
+	X1  = asm.NewPReg(1, asm.RegTypeFloat, asm.Width64)
+	X2  = asm.NewPReg(2, asm.RegTypeFloat, asm.Width64)
+	X3  = asm.NewPReg(3, asm.RegTypeFloat, asm.Width64)
+	X4  = asm.NewPReg(4, asm.RegTypeFloat, asm.Width64)
+	X5  = asm.NewPReg(5, asm.RegTypeFloat, asm.Width64)
+	X6  = asm.NewPReg(6, asm.RegTypeFloat, asm.Width64)
+	X7  = asm.NewPReg(7, asm.RegTypeFloat, asm.Width64)
+	X8  = asm.NewPReg(8, asm.RegTypeFloat, asm.Width64)
+	X9  = asm.NewPReg(9, asm.RegTypeFloat, asm.Width64)
+	X10 = asm.NewPReg(10, asm.RegTypeFloat, asm.Width64)
+	X11 = asm.NewPReg(11, asm.RegTypeFloat, asm.Width64)
+	X12 = asm.NewPReg(12, asm.RegTypeFloat, asm.Width64)
+	X13 = asm.NewPReg(13, asm.RegTypeFloat, asm.Width64)
+	X14 = asm.NewPReg(14, asm.RegTypeFloat, asm.Width64)
+	X15 = asm.NewPReg(15, asm.RegTypeFloat, asm.Width64)
+
+	// S0-S15 view the XMM registers as single-precision scalars; the
+	// encoder picks the SS rather than SD form of an SSE op from the width.
+	S0  = asm.NewPReg(0, asm.RegTypeFloat, asm.Width32)
+	S1  = asm.NewPReg(1, asm.RegTypeFloat, asm.Width32)
+	S2  = asm.NewPReg(2, asm.RegTypeFloat, asm.Width32)
+	S3  = asm.NewPReg(3, asm.RegTypeFloat, asm.Width32)
+	S4  = asm.NewPReg(4, asm.RegTypeFloat, asm.Width32)
+	S5  = asm.NewPReg(5, asm.RegTypeFloat, asm.Width32)
+	S6  = asm.NewPReg(6, asm.RegTypeFloat, asm.Width32)
+	S7  = asm.NewPReg(7, asm.RegTypeFloat, asm.Width32)
+	S8  = asm.NewPReg(8, asm.RegTypeFloat, asm.Width32)
+	S9  = asm.NewPReg(9, asm.RegTypeFloat, asm.Width32)
+	S10 = asm.NewPReg(10, asm.RegTypeFloat, asm.Width32)
+	S11 = asm.NewPReg(11, asm.RegTypeFloat, asm.Width32)
+	S12 = asm.NewPReg(12, asm.RegTypeFloat, asm.Width32)
+	S13 = asm.NewPReg(13, asm.RegTypeFloat, asm.Width32)
+	S14 = asm.NewPReg(14, asm.RegTypeFloat, asm.Width32)
+	S15 = asm.NewPReg(15, asm.RegTypeFloat, asm.Width32)
+)
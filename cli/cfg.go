@@ -0,0 +1,173 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"strconv"
+
+	"github.com/siyul-park/minivm/analysis"
+	"github.com/siyul-park/minivm/interp"
+	"github.com/siyul-park/minivm/pass"
+	"github.com/siyul-park/minivm/prof"
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
+	"github.com/spf13/cobra"
+)
+
+// NewCFGCommand returns the `minivm cfg <file>` subcommand. It loads <file>
+// from fsys as `run` does and prints the control-flow graph of every function
+// with a body, or of the one --func names by debug name, export name, or
+// slot, as Graphviz DOT or, with --format json, as JSON. --calls prints the
+// program's call graph instead. --profile runs the program first and shades
+// blocks and functions by the samples taken in them.
+func NewCFGCommand(fsys fs.FS) *cobra.Command {
+	var format, only string
+	var calls, profile bool
+	cmd := &cobra.Command{
+		Use:          "cfg <file>",
+		Short:        "Print control-flow and call graphs",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+	}
+	cmd.Flags().StringVar(&format, "format", "dot", "output format: dot or json")
+	cmd.Flags().StringVar(&only, "func", "", "print only the function with this name or slot")
+	cmd.Flags().BoolVar(&calls, "calls", false, "print the call graph")
+	cmd.Flags().BoolVar(&profile, "profile", false, "run the program and annotate sample heat")
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		path := args[0]
+		if format != "dot" && format != "json" {
+			return fmt.Errorf("cfg: unknown format %q", format)
+		}
+
+		prog, err := loadProgram(fsys, path)
+		if err != nil {
+			return err
+		}
+
+		var p *prof.Profiler
+		var addrs []int
+		if profile {
+			p = prof.New()
+			if addrs, err = profileRun(cmd, prog, p); err != nil {
+				return fmt.Errorf("run %s: %w", path, err)
+			}
+		}
+
+		out := cmd.OutOrStdout()
+		if calls {
+			g, err := analysis.NewCallGraph(prog)
+			if err != nil {
+				return fmt.Errorf("cfg %s: %w", path, err)
+			}
+			if p != nil {
+				g.Annotate(func(slot int) uint64 { return p.Samples(addrs[slot]) })
+			}
+			if format == "json" {
+				return json.NewEncoder(out).Encode(g)
+			}
+			return g.WriteDOT(out)
+		}
+
+		fns := functions(prog)
+		slots, err := selectFuncs(prog, fns, only)
+		if err != nil {
+			return err
+		}
+
+		m := pass.NewManager()
+		pass.Register(m, analysis.NewBlocksAnalysis())
+		pass.Register(m, analysis.NewDominatorsAnalysis())
+		pass.Register(m, analysis.NewLoopsAnalysis())
+
+		type slotGraph struct {
+			Slot int `json:"slot"`
+			*analysis.ControlFlowGraph
+		}
+		var graphs []slotGraph
+		for _, slot := range slots {
+			g, err := analysis.NewControlFlowGraph(m, fns[slot])
+			if err != nil {
+				return fmt.Errorf("cfg %s: %w", path, err)
+			}
+			g.Name = prog.FuncName(slot)
+			if p != nil {
+				addr := addrs[slot]
+				g.Annotate(func(ip int) uint64 { return p.IP(addr, ip) })
+			}
+			graphs = append(graphs, slotGraph{Slot: slot, ControlFlowGraph: g})
+		}
+
+		if format == "json" {
+			return json.NewEncoder(out).Encode(graphs)
+		}
+		for _, g := range graphs {
+			if err := g.WriteDOT(out); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return cmd
+}
+
+// functions returns the function of every slot with a body, the top-level
+// code included, and nil for the others.
+func functions(prog *program.Program) []*types.Function {
+	fns := make([]*types.Function, len(prog.Constants)+1)
+	fns[0] = &types.Function{Typ: &types.FunctionType{}, Locals: prog.Locals, Code: prog.Code, Handlers: prog.Handlers}
+	if prog.Debug != nil {
+		fns[0].Debug = prog.Debug.Code
+	}
+	for j, c := range prog.Constants {
+		if fn, ok := c.(*types.Function); ok && len(fn.Code) > 0 {
+			fns[j+1] = fn
+		}
+	}
+	return fns
+}
+
+// selectFuncs returns the slots of fns to print: all of them when only is
+// empty, or the one only names by slot or by name.
+func selectFuncs(prog *program.Program, fns []*types.Function, only string) ([]int, error) {
+	var slots []int
+	for slot, fn := range fns {
+		if fn == nil {
+			continue
+		}
+		if only == "" || only == prog.FuncName(slot) || only == strconv.Itoa(slot) {
+			slots = append(slots, slot)
+		}
+	}
+	if only != "" && len(slots) == 0 {
+		return nil, fmt.Errorf("no function %q", only)
+	}
+	if only != "" {
+		slots = slots[:1]
+	}
+	return slots, nil
+}
+
+// profileRun runs prog to completion under p, sampling every instruction, and
+// returns the interpreter address of each function slot, which is what the
+// profiler keys samples by.
+func profileRun(cmd *cobra.Command, prog *program.Program, p *prof.Profiler) ([]int, error) {
+	vm := interp.New(prog, interp.WithProfiler(p), interp.WithTick(1))
+	addrs := make([]int, len(prog.Constants)+1)
+	for j, c := range prog.Constants {
+		if _, ok := c.(*types.Function); !ok {
+			continue
+		}
+		val, err := vm.Const(j)
+		if err != nil {
+			_ = vm.Close()
+			return nil, err
+		}
+		addrs[j+1] = val.Ref()
+	}
+	if err := vm.Run(cmd.Context()); err != nil {
+		_ = vm.Close()
+		return nil, err
+	}
+	return addrs, vm.Close()
+}
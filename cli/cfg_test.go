@@ -0,0 +1,97 @@
+package cli_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"testing/fstest"
+
+	cli "github.com/siyul-park/minivm/cli"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCFGCommand(t *testing.T) {
+	cfg := func(t *testing.T, fsys fstest.MapFS, args ...string) (string, error) {
+		t.Helper()
+		var out bytes.Buffer
+		cmd := cli.NewCFGCommand(fsys)
+		cmd.SetOut(&out)
+		cmd.SetErr(&out)
+		cmd.SetArgs(args)
+		err := cmd.ExecuteContext(context.Background())
+		return out.String(), err
+	}
+
+	loop := fstest.MapFS{
+		"loop.mvl": &fstest.MapFile{Data: []byte("fn sum(n: i32) -> i32 {\n\tlet s: i32 = 0;\n\twhile n > 0 { s = s + n; n = n - 1; }\n\treturn s;\n}\nsum(10)\n")},
+	}
+
+	t.Run("prints every function as dot", func(t *testing.T) {
+		out, err := cfg(t, loop, "loop.mvl")
+		require.NoError(t, err)
+		require.Contains(t, out, "digraph \"\" {\n")
+		require.Contains(t, out, "digraph \"sum\" {\n")
+		require.Contains(t, out, "[style=bold label=back];\n")
+	})
+
+	t.Run("prints one function as json", func(t *testing.T) {
+		out, err := cfg(t, loop, "--format", "json", "--func", "sum", "loop.mvl")
+		require.NoError(t, err)
+
+		var graphs []struct {
+			Slot   int    `json:"slot"`
+			Name   string `json:"name"`
+			Blocks []struct {
+				Code []string `json:"code"`
+			} `json:"blocks"`
+			Edges []struct {
+				Kind string `json:"kind"`
+			} `json:"edges"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(out), &graphs))
+		require.Len(t, graphs, 1)
+		require.Equal(t, "sum", graphs[0].Name)
+		require.NotEmpty(t, graphs[0].Blocks[0].Code)
+		require.Contains(t, graphs[0].Edges, struct {
+			Kind string `json:"kind"`
+		}{Kind: "back"})
+	})
+
+	t.Run("selects a function by slot", func(t *testing.T) {
+		out, err := cfg(t, loop, "--func", "0", "loop.mvl")
+		require.NoError(t, err)
+		require.NotContains(t, out, "\"sum\"")
+	})
+
+	t.Run("annotates profile heat", func(t *testing.T) {
+		out, err := cfg(t, loop, "--profile", "--func", "sum", "loop.mvl")
+		require.NoError(t, err)
+		require.Regexp(t, `heat \d+`, out)
+		require.Contains(t, out, "fillcolor=\"0.000 1.000 1.000\"")
+	})
+
+	t.Run("prints the call graph", func(t *testing.T) {
+		out, err := cfg(t, loop, "--calls", "loop.mvl")
+		require.NoError(t, err)
+		require.Contains(t, out, "digraph calls {\n")
+		require.Regexp(t, `f0 -> f\d+;`, out)
+	})
+
+	t.Run("prints the call graph as json", func(t *testing.T) {
+		out, err := cfg(t, loop, "--calls", "--profile", "--format", "json", "loop.mvl")
+		require.NoError(t, err)
+		require.Contains(t, out, `"name":"sum"`)
+		require.Contains(t, out, `"heat":`)
+	})
+
+	t.Run("rejects an unknown function", func(t *testing.T) {
+		_, err := cfg(t, loop, "--func", "nope", "loop.mvl")
+		require.ErrorContains(t, err, `no function "nope"`)
+	})
+
+	t.Run("rejects an unknown format", func(t *testing.T) {
+		_, err := cfg(t, loop, "--format", "svg", "loop.mvl")
+		require.ErrorContains(t, err, `unknown format "svg"`)
+	})
+}
@@ -18,8 +18,8 @@ type options struct {
 	fs WriteFS
 }
 
-// WithFS overrides the filesystem used by `run` and the REPL's
-// .load/.save commands. Defaults to OS().
+// WithFS overrides the filesystem the subcommands read and write, and the
+// REPL's .load/.save commands use. Defaults to OS().
 func WithFS(fs WriteFS) Option {
 	return func(o *options) { o.fs = fs }
 }
@@ -42,5 +42,11 @@ func Root(opts ...Option) *cobra.Command {
 		},
 	}
 	cmd.AddCommand(NewRunCommand(o.fs))
+	cmd.AddCommand(NewVerifyCommand(o.fs))
+	cmd.AddCommand(NewCFGCommand(o.fs))
+	cmd.AddCommand(NewOptCommand(o.fs))
+	cmd.AddCommand(NewDisasmCommand(o.fs))
+	cmd.AddCommand(NewFmtCommand(o.fs))
+	cmd.AddCommand(NewDAPCommand(o.fs))
 	return cmd
 }
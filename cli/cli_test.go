@@ -31,6 +31,48 @@ func TestRoot(t *testing.T) {
 		require.NoError(t, err)
 	})
 
+	t.Run("exposes verify subcommand", func(t *testing.T) {
+		root := cli.Root()
+		cmd, _, err := root.Find([]string{"verify"})
+		require.NoError(t, err)
+		require.Equal(t, "verify", cmd.Name())
+	})
+
+	t.Run("exposes cfg subcommand", func(t *testing.T) {
+		root := cli.Root()
+		cmd, _, err := root.Find([]string{"cfg"})
+		require.NoError(t, err)
+		require.Equal(t, "cfg", cmd.Name())
+	})
+
+	t.Run("exposes opt subcommand", func(t *testing.T) {
+		root := cli.Root()
+		cmd, _, err := root.Find([]string{"opt"})
+		require.NoError(t, err)
+		require.Equal(t, "opt", cmd.Name())
+	})
+
+	t.Run("exposes disasm subcommand", func(t *testing.T) {
+		root := cli.Root()
+		cmd, _, err := root.Find([]string{"disasm"})
+		require.NoError(t, err)
+		require.Equal(t, "disasm", cmd.Name())
+	})
+
+	t.Run("exposes fmt subcommand", func(t *testing.T) {
+		root := cli.Root()
+		cmd, _, err := root.Find([]string{"fmt"})
+		require.NoError(t, err)
+		require.Equal(t, "fmt", cmd.Name())
+	})
+
+	t.Run("exposes dap subcommand", func(t *testing.T) {
+		root := cli.Root()
+		cmd, _, err := root.Find([]string{"dap"})
+		require.NoError(t, err)
+		require.Equal(t, "dap", cmd.Name())
+	})
+
 	t.Run("default Use is minivm", func(t *testing.T) {
 		require.Equal(t, "minivm", cli.Root().Use)
 	})
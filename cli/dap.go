@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"strconv"
+
+	"github.com/siyul-park/minivm/debug"
+	"github.com/siyul-park/minivm/program"
+	"github.com/spf13/cobra"
+)
+
+// NewDAPCommand returns the `minivm dap` subcommand. It serves the Debug
+// Adapter Protocol over stdio, or to a single client on a loopback TCP socket
+// with --listen, loading the program a launch request names from fsys. The
+// protocol is unauthenticated, so --listen refuses any other host.
+func NewDAPCommand(fsys fs.FS) *cobra.Command {
+	var listen string
+	cmd := &cobra.Command{
+		Use:          "dap",
+		Short:        "Serve the Debug Adapter Protocol",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+	}
+	cmd.Flags().StringVar(&listen, "listen", "", "accept one client on this loopback TCP address or port instead of stdio")
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		server := debug.NewServer(func(path string) (*program.Program, error) {
+			return loadProgram(fsys, path)
+		})
+
+		if listen == "" {
+			conn := struct {
+				io.Reader
+				io.Writer
+			}{cmd.InOrStdin(), cmd.OutOrStdout()}
+			return server.Serve(cmd.Context(), conn)
+		}
+
+		addr, err := loopback(listen)
+		if err != nil {
+			return err
+		}
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		defer ln.Close()
+		stop := context.AfterFunc(cmd.Context(), func() { _ = ln.Close() })
+		defer stop()
+		fmt.Fprintf(cmd.ErrOrStderr(), "listening on %s\n", ln.Addr())
+
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx := cmd.Context(); ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		defer conn.Close()
+		return server.Serve(cmd.Context(), conn)
+	}
+	return cmd
+}
+
+// loopback resolves a --listen value to a TCP address on the loopback
+// interface. A bare port or an empty host binds 127.0.0.1.
+func loopback(listen string) (string, error) {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		if _, perr := strconv.ParseUint(listen, 10, 16); perr != nil {
+			return "", fmt.Errorf("dap: listen address %q: %w", listen, err)
+		}
+		host, port = "", listen
+	}
+	switch {
+	case host == "":
+		host = "127.0.0.1"
+	case host == "localhost":
+	default:
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			return "", fmt.Errorf("dap: listen address %q is not a loopback address", listen)
+		}
+	}
+	return net.JoinHostPort(host, port), nil
+}
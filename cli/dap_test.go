@@ -0,0 +1,131 @@
+package cli_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	cli "github.com/siyul-park/minivm/cli"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDAPCommand(t *testing.T) {
+	t.Run("serves stdio", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"add.mvm": &fstest.MapFile{Data: []byte("0000:\ti32.const 0x00000001\n0005:\ti32.const 0x00000002\n0010:\ti32.add\n")},
+		}
+		inR, inW := io.Pipe()
+		outR, outW := io.Pipe()
+		cmd := cli.NewDAPCommand(fsys)
+		cmd.SetIn(inR)
+		cmd.SetOut(outW)
+		cmd.SetArgs(nil)
+
+		done := make(chan error, 1)
+		go func() {
+			done <- cmd.ExecuteContext(context.Background())
+			_ = outW.Close()
+		}()
+
+		send := func(seq int, command string, args any) {
+			data, err := json.Marshal(map[string]any{"seq": seq, "type": "request", "command": command, "arguments": args})
+			require.NoError(t, err)
+			_, err = fmt.Fprintf(inW, "Content-Length: %d\r\n\r\n%s", len(data), data)
+			require.NoError(t, err)
+		}
+
+		msgs := make(chan map[string]any, 16)
+		go func() {
+			defer close(msgs)
+			r := bufio.NewReader(outR)
+			for {
+				header, err := textproto.NewReader(r).ReadMIMEHeader()
+				if err != nil {
+					return
+				}
+				size, _ := strconv.Atoi(header.Get("Content-Length"))
+				data := make([]byte, size)
+				if _, err := io.ReadFull(r, data); err != nil {
+					return
+				}
+				var msg map[string]any
+				if json.Unmarshal(data, &msg) == nil {
+					msgs <- msg
+				}
+			}
+		}()
+
+		send(1, "initialize", nil)
+		send(2, "launch", map[string]any{"program": "add.mvm"})
+		send(3, "configurationDone", nil)
+
+		var output string
+		for terminated := false; !terminated; {
+			select {
+			case msg := <-msgs:
+				if msg["type"] == "response" {
+					require.Equal(t, true, msg["success"], "%v", msg["message"])
+				}
+				switch msg["event"] {
+				case "output":
+					output += msg["body"].(map[string]any)["output"].(string)
+				case "terminated":
+					terminated = true
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the program to terminate")
+			}
+		}
+		require.Equal(t, "3\n", output)
+
+		send(4, "disconnect", nil)
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("dap command did not exit")
+		}
+	})
+
+	t.Run("refuses a non-loopback address", func(t *testing.T) {
+		cmd := cli.NewDAPCommand(fstest.MapFS{})
+		cmd.SetArgs([]string{"--listen", "0.0.0.0:0"})
+		cmd.SetErr(io.Discard)
+
+		err := cmd.ExecuteContext(context.Background())
+		require.ErrorContains(t, err, "not a loopback address")
+	})
+
+	t.Run("binds a bare port to loopback", func(t *testing.T) {
+		r, w := io.Pipe()
+		cmd := cli.NewDAPCommand(fstest.MapFS{})
+		cmd.SetArgs([]string{"--listen", "0"})
+		cmd.SetErr(w)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- cmd.ExecuteContext(ctx) }()
+
+		br := bufio.NewReader(r)
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(line, "listening on 127.0.0.1:"), line)
+		go func() { _, _ = io.Copy(io.Discard, br) }()
+
+		cancel()
+		select {
+		case err := <-done:
+			require.ErrorIs(t, err, context.Canceled)
+		case <-time.After(5 * time.Second):
+			t.Fatal("dap command did not exit")
+		}
+	})
+}
@@ -0,0 +1,144 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// diffContext is how many unchanged lines surround each change in a hunk.
+const diffContext = 3
+
+// offset matches the byte offset or index opening a dump line, which shifts
+// whenever an earlier instruction changes size.
+var offset = regexp.MustCompile(`^(\t*)\d+:\t`)
+
+// edit is one line of an edit script: ' ' keeps a line of both sides, '-'
+// removes one of the old side, and '+' adds one of the new side.
+type edit struct {
+	op   byte
+	line string
+}
+
+// diff writes a unified diff turning the dump a, labelled from, into b,
+// labelled to, or nothing when they agree. Lines are compared without their
+// leading offsets, so an instruction that only moved is unchanged.
+func diff(w io.Writer, from, to, a, b string) {
+	edits := script(lines(a), lines(b))
+	var changes []int
+	for k, e := range edits {
+		if e.op != ' ' {
+			changes = append(changes, k)
+		}
+	}
+	if len(changes) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", from, to)
+	for len(changes) > 0 {
+		last := 0
+		for last+1 < len(changes) && changes[last+1]-changes[last] <= 2*diffContext {
+			last++
+		}
+		start := max(changes[0]-diffContext, 0)
+		end := min(changes[last]+diffContext+1, len(edits))
+		changes = changes[last+1:]
+
+		oldLine, newLine := 1, 1
+		for _, e := range edits[:start] {
+			if e.op != '+' {
+				oldLine++
+			}
+			if e.op != '-' {
+				newLine++
+			}
+		}
+		var oldLen, newLen int
+		var body strings.Builder
+		for _, e := range edits[start:end] {
+			if e.op != '+' {
+				oldLen++
+			}
+			if e.op != '-' {
+				newLen++
+			}
+			body.WriteByte(e.op)
+			body.WriteString(e.line)
+			body.WriteByte('\n')
+		}
+		fmt.Fprintf(w, "@@ -%d,%d +%d,%d @@\n%s", oldLine, oldLen, newLine, newLen, body.String())
+	}
+}
+
+// script finds a shortest edit script from a to b with Myers' algorithm.
+func script(a, b []string) []edit {
+	key := func(line string) string { return offset.ReplaceAllString(line, "$1") }
+	n, m := len(a), len(b)
+	off := n + m + 1
+	v := make([]int, 2*off+1)
+	var trace [][]int
+	for d := 0; ; d++ {
+		trace = append(trace, slices.Clone(v))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && key(a[x]) == key(b[y]) {
+				x, y = x+1, y+1
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b, off)
+			}
+		}
+	}
+}
+
+// backtrack walks the furthest-reaching paths trace recorded back from the
+// end of a and b, emitting the edits in order.
+func backtrack(trace [][]int, a, b []string, off int) []edit {
+	var edits []edit
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		prev := k - 1
+		if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+			prev = k + 1
+		}
+		px := v[off+prev]
+		py := px - prev
+		for x > px && y > py {
+			x, y = x-1, y-1
+			edits = append(edits, edit{op: ' ', line: b[y]})
+		}
+		if x == px {
+			y--
+			edits = append(edits, edit{op: '+', line: b[y]})
+		} else {
+			x--
+			edits = append(edits, edit{op: '-', line: a[x]})
+		}
+	}
+	for x > 0 && y > 0 {
+		x, y = x-1, y-1
+		edits = append(edits, edit{op: ' ', line: b[y]})
+	}
+	slices.Reverse(edits)
+	return edits
+}
+
+// lines splits a dump into its lines.
+func lines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
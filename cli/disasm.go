@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
+	"github.com/spf13/cobra"
+)
+
+// NewDisasmCommand returns the `minivm disasm <file>` subcommand. It reads
+// <file> from fsys as `run` does, but without verifying it, and lists every
+// function with a body, or the one --func names: a header with its slot,
+// name, and type, then one instr.Format line per instruction, tagged with the
+// source position where debug info starts a new one, then its handlers.
+func NewDisasmCommand(fsys fs.FS) *cobra.Command {
+	var only string
+	cmd := &cobra.Command{
+		Use:          "disasm <file>",
+		Short:        "Disassemble a MiniVM program",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+	}
+	cmd.Flags().StringVar(&only, "func", "", "list only the function with this name or slot")
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		path := args[0]
+
+		prog, err := readFile(fsys, path)
+		if err != nil {
+			return err
+		}
+		fns := functions(prog)
+		slots, err := selectFuncs(prog, fns, only)
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		for k, slot := range slots {
+			if k > 0 {
+				fmt.Fprintln(out)
+			}
+			disasm(out, prog, slot, fns[slot])
+		}
+		return nil
+	}
+	return cmd
+}
+
+// disasm writes the listing of fn, in slot, to out.
+func disasm(out io.Writer, prog *program.Program, slot int, fn *types.Function) {
+	header := fmt.Sprintf("func %d", slot)
+	if name := prog.FuncName(slot); name != "" {
+		header += " " + name
+	}
+	fmt.Fprintf(out, "%s: %s\n", header, fn.Typ)
+
+	lines := strings.Split(strings.TrimSuffix(instr.Format(fn.Code), "\n"), "\n")
+	var last types.Position
+	ip := 0
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		if pos, ok := fn.Debug.Position(ip); ok && pos != last {
+			line += "\t; " + pos.String()
+			last = pos
+		}
+		fmt.Fprintln(out, line)
+		if ip < len(fn.Code) {
+			ip += instr.Instruction(fn.Code[ip:]).Width()
+		}
+	}
+	for _, h := range fn.Handlers {
+		fmt.Fprintf(out, "try %04d..%04d catch %04d depth %d\n", h.Start, h.End, h.Catch, h.Depth)
+	}
+}
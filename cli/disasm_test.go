@@ -0,0 +1,71 @@
+package cli_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"testing/fstest"
+
+	cli "github.com/siyul-park/minivm/cli"
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/program"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDisasmCommand(t *testing.T) {
+	disasm := func(t *testing.T, fsys fstest.MapFS, args ...string) (string, error) {
+		t.Helper()
+		var out bytes.Buffer
+		cmd := cli.NewDisasmCommand(fsys)
+		cmd.SetOut(&out)
+		cmd.SetErr(&out)
+		cmd.SetArgs(args)
+		err := cmd.ExecuteContext(context.Background())
+		return out.String(), err
+	}
+
+	t.Run("lists a binary module", func(t *testing.T) {
+		var bin bytes.Buffer
+		prog := program.New([]instr.Instruction{instr.New(instr.I32_CONST, 1), instr.New(instr.DROP)})
+		require.NoError(t, program.Encode(&bin, prog))
+		fsys := fstest.MapFS{"one.bin": &fstest.MapFile{Data: bin.Bytes()}}
+
+		out, err := disasm(t, fsys, "one.bin")
+		require.NoError(t, err)
+		require.Equal(t, "func 0: func()\n0000:\ti32.const 0x00000001\n0005:\tdrop\n", out)
+	})
+
+	t.Run("lists an unverified text dump", func(t *testing.T) {
+		fsys := fstest.MapFS{"bad.mvm": &fstest.MapFile{Data: []byte("0000:\ti32.add\n")}}
+
+		out, err := disasm(t, fsys, "bad.mvm")
+		require.NoError(t, err)
+		require.Equal(t, "func 0: func()\n0000:\ti32.add\n", out)
+	})
+
+	t.Run("tags source positions", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"add.mvl": &fstest.MapFile{Data: []byte("fn add(a: i32, b: i32) -> i32 { return a + b; }\nadd(1, 2)\n")},
+		}
+
+		out, err := disasm(t, fsys, "--func", "add", "add.mvl")
+		require.NoError(t, err)
+		require.Regexp(t, `^func \d+ add: func\(i32, i32\) i32\n0000:\tlocal.get 0x00\t; add.mvl:1:\d+\n`, out)
+		require.NotContains(t, out, "main")
+	})
+
+	t.Run("lists handlers", func(t *testing.T) {
+		fsys := fstest.MapFS{"try.mvm": &fstest.MapFile{Data: []byte(".code\n0000:\ti32.const 0x00000001\n0005:\tthrow\n0006:\tdrop\n.handlers\n0000:\tstart=0 end=6 catch=6 depth=0\n")}}
+
+		out, err := disasm(t, fsys, "try.mvm")
+		require.NoError(t, err)
+		require.Contains(t, out, "try 0000..0006 catch 0006 depth 0\n")
+	})
+
+	t.Run("rejects an unknown function", func(t *testing.T) {
+		fsys := fstest.MapFS{"one.mvm": &fstest.MapFile{Data: []byte("0000:\tnop\n")}}
+
+		_, err := disasm(t, fsys, "--func", "nope", "one.mvm")
+		require.ErrorContains(t, err, `no function "nope"`)
+	})
+}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/siyul-park/minivm/program"
+	"github.com/spf13/cobra"
+)
+
+// NewFmtCommand returns the `minivm fmt <file>...` subcommand. It parses each
+// hand-written assembly file from fsys with program.Parse and prints it in
+// the canonical form Program.String() writes. -w writes the canonical form
+// back to each file that differs instead, and -l lists those files rather
+// than printing them.
+func NewFmtCommand(fsys WriteFS) *cobra.Command {
+	var write, list bool
+	cmd := &cobra.Command{
+		Use:          "fmt <file>...",
+		Short:        "Format MiniVM assembly files",
+		Args:         cobra.MinimumNArgs(1),
+		SilenceUsage: true,
+	}
+	cmd.Flags().BoolVarP(&write, "write", "w", false, "write the result back to each file that differs")
+	cmd.Flags().BoolVarP(&list, "list", "l", false, "list the files that differ")
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+		for _, path := range args {
+			src, err := fs.ReadFile(fsys, path)
+			if err != nil {
+				return fmt.Errorf("open %s: %w", path, err)
+			}
+			prog, err := program.Parse(bytes.NewReader(src))
+			if err != nil {
+				return fmt.Errorf("parse %s: %w", path, err)
+			}
+			formatted := prog.String()
+			changed := formatted != string(src)
+
+			if list && changed {
+				fmt.Fprintln(out, path)
+			}
+			if write && changed {
+				if err := writeFile(fsys, path, formatted); err != nil {
+					return err
+				}
+			}
+			if !list && !write {
+				if _, err := io.WriteString(out, formatted); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return cmd
+}
+
+// writeFile replaces path in fsys with data.
+func writeFile(fsys WriteFS, path, data string) error {
+	file, err := fsys.Create(path)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", path, err)
+	}
+	_, err = io.WriteString(file, data)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package cli_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"testing/fstest"
+
+	cli "github.com/siyul-park/minivm/cli"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFmtCommand(t *testing.T) {
+	format := func(t *testing.T, fsys memFS, args ...string) (string, error) {
+		t.Helper()
+		var out bytes.Buffer
+		cmd := cli.NewFmtCommand(fsys)
+		cmd.SetOut(&out)
+		cmd.SetErr(&out)
+		cmd.SetArgs(args)
+		err := cmd.ExecuteContext(context.Background())
+		return out.String(), err
+	}
+	const canonical = ".code\n0000:\ti32.const 0x00000001\n0005:\ti32.const 0x00000002\n0010:\ti32.add\n"
+	messy := func() memFS {
+		return memFS{fstest.MapFS{
+			"messy.mvm": &fstest.MapFile{Data: []byte(".code\n  i32.const 1\ni32.const   2\n\ni32.add\n")},
+			"tidy.mvm":  &fstest.MapFile{Data: []byte(canonical)},
+		}}
+	}
+
+	t.Run("prints the canonical form", func(t *testing.T) {
+		out, err := format(t, messy(), "messy.mvm")
+		require.NoError(t, err)
+		require.Equal(t, canonical, out)
+	})
+
+	t.Run("lists files that differ", func(t *testing.T) {
+		out, err := format(t, messy(), "-l", "messy.mvm", "tidy.mvm")
+		require.NoError(t, err)
+		require.Equal(t, "messy.mvm\n", out)
+	})
+
+	t.Run("writes files back", func(t *testing.T) {
+		fsys := messy()
+		out, err := format(t, fsys, "-w", "messy.mvm")
+		require.NoError(t, err)
+		require.Empty(t, out)
+		require.Equal(t, canonical, string(fsys.MapFS["messy.mvm"].Data))
+	})
+
+	t.Run("rejects malformed assembly", func(t *testing.T) {
+		fsys := memFS{fstest.MapFS{"bad.mvm": &fstest.MapFile{Data: []byte("nope\n")}}}
+		_, err := format(t, fsys, "bad.mvm")
+		require.ErrorContains(t, err, "parse bad.mvm")
+	})
+
+	t.Run("rejects a missing file", func(t *testing.T) {
+		_, err := format(t, messy(), "missing.mvm")
+		require.ErrorContains(t, err, "open missing.mvm")
+	})
+}
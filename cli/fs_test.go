@@ -1,10 +1,12 @@
 package cli_test
 
 import (
+	"bytes"
 	"io"
 	"os"
 	"path/filepath"
 	"testing"
+	"testing/fstest"
 
 	cli "github.com/siyul-park/minivm/cli"
 	"github.com/stretchr/testify/require"
@@ -35,3 +37,24 @@ func TestOS(t *testing.T) {
 		require.True(t, os.IsNotExist(err))
 	})
 }
+
+// memFS is a cli.WriteFS over fstest.MapFS. A created file appears in the map
+// once it is closed.
+type memFS struct{ fstest.MapFS }
+
+var _ cli.WriteFS = memFS{}
+
+func (m memFS) Create(name string) (io.WriteCloser, error) {
+	return &memFile{fsys: m.MapFS, name: name}, nil
+}
+
+type memFile struct {
+	bytes.Buffer
+	fsys fstest.MapFS
+	name string
+}
+
+func (f *memFile) Close() error {
+	f.fsys[f.name] = &fstest.MapFile{Data: bytes.Clone(f.Bytes())}
+	return nil
+}
@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/siyul-park/minivm/optimize"
+	"github.com/siyul-park/minivm/pass"
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/transform"
+	"github.com/spf13/cobra"
+)
+
+// transforms names the passes --passes selects from.
+var transforms = map[string]func() pass.Pass[*program.Program]{
+	"algebraic": func() pass.Pass[*program.Program] { return transform.NewAlgebraicPass() },
+	"dce":       func() pass.Pass[*program.Program] { return transform.NewDCEPass() },
+	"dedup":     func() pass.Pass[*program.Program] { return transform.NewDedupPass() },
+	"fold":      func() pass.Pass[*program.Program] { return transform.NewFoldPass() },
+	"gvn":       func() pass.Pass[*program.Program] { return transform.NewGVNPass() },
+	"inline":    func() pass.Pass[*program.Program] { return transform.NewInlinePass() },
+	"licm":      func() pass.Pass[*program.Program] { return transform.NewLICMPass() },
+	"sccp":      func() pass.Pass[*program.Program] { return transform.NewSCCPPass() },
+	"tailcall":  func() pass.Pass[*program.Program] { return transform.NewTailCallPass() },
+}
+
+// NewOptCommand returns the `minivm opt <in>` subcommand. It loads <in> from
+// fsys as `run` does, optimizes it with optimize.New at the -O level, or
+// with exactly the passes --passes lists, in order, and verifies the result.
+// With -o it writes the program to that file in fsys as a binary module, or
+// as a Program.String() dump with --text; without it the dump goes to
+// standard output. --diff prints a unified diff of the dump before and after
+// instead, comparing instructions apart from their offsets.
+func NewOptCommand(fsys WriteFS) *cobra.Command {
+	var level int
+	var output string
+	var passes []string
+	var text, diffs bool
+	cmd := &cobra.Command{
+		Use:          "opt <in>",
+		Short:        "Optimize a MiniVM program",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+	}
+	cmd.Flags().IntVarP(&level, "level", "O", int(optimize.O2), "optimization level, 0 to 3")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "write the optimized program to this file")
+	cmd.Flags().StringSliceVar(&passes, "passes", nil, "run these passes instead of a level: "+strings.Join(passNames(), ", "))
+	cmd.Flags().BoolVar(&text, "text", false, "write the output file as text instead of binary")
+	cmd.Flags().BoolVar(&diffs, "diff", false, "print a diff of the program before and after")
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		path := args[0]
+
+		var o *optimize.Optimizer
+		switch {
+		case len(passes) > 0 && cmd.Flags().Changed("level"):
+			return fmt.Errorf("opt: -O and --passes are exclusive")
+		case len(passes) > 0:
+			o = optimize.New(optimize.O0)
+			for _, name := range passes {
+				p, ok := transforms[name]
+				if !ok {
+					return fmt.Errorf("opt: unknown pass %q", name)
+				}
+				o.Add(p())
+			}
+		case level < int(optimize.O0) || level > int(optimize.O3):
+			return fmt.Errorf("opt: unknown level -O%d", level)
+		default:
+			o = optimize.New(optimize.Level(level))
+		}
+
+		prog, err := loadProgram(fsys, path)
+		if err != nil {
+			return err
+		}
+		before := prog.String()
+
+		prog, err = o.Optimize(prog)
+		if err != nil {
+			return fmt.Errorf("opt %s: %w", path, err)
+		}
+		if err := program.Verify(prog); err != nil {
+			return fmt.Errorf("verify optimized %s: %w", path, err)
+		}
+
+		out := cmd.OutOrStdout()
+		if diffs {
+			diff(out, path, path+" (optimized)", before, prog.String())
+		}
+		switch {
+		case output != "":
+			return writeProgram(fsys, output, prog, text)
+		case !diffs:
+			_, err := io.WriteString(out, prog.String())
+			return err
+		default:
+			return nil
+		}
+	}
+	return cmd
+}
+
+// writeProgram creates path in fsys and writes prog to it, as a
+// Program.String() dump when text is set and as a binary module otherwise.
+func writeProgram(fsys WriteFS, path string, prog *program.Program, text bool) error {
+	if text {
+		return writeFile(fsys, path, prog.String())
+	}
+	file, err := fsys.Create(path)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", path, err)
+	}
+	err = program.Encode(file, prog)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
+
+// passNames lists the names --passes accepts, sorted.
+func passNames() []string {
+	names := make([]string, 0, len(transforms))
+	for name := range transforms {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,93 @@
+package cli_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"testing/fstest"
+
+	cli "github.com/siyul-park/minivm/cli"
+	"github.com/siyul-park/minivm/program"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewOptCommand(t *testing.T) {
+	opt := func(t *testing.T, fsys memFS, args ...string) (string, error) {
+		t.Helper()
+		var out bytes.Buffer
+		cmd := cli.NewOptCommand(fsys)
+		cmd.SetOut(&out)
+		cmd.SetErr(&out)
+		cmd.SetArgs(args)
+		err := cmd.ExecuteContext(context.Background())
+		return out.String(), err
+	}
+	source := func() memFS {
+		return memFS{fstest.MapFS{
+			"f.mvl": &fstest.MapFile{Data: []byte("fn f(x: i32) -> i32 { return x * 1 + 0; }\nf(3)\n")},
+		}}
+	}
+
+	t.Run("writes a binary module", func(t *testing.T) {
+		fsys := source()
+		out, err := opt(t, fsys, "-O2", "f.mvl", "-o", "f.bin")
+		require.NoError(t, err)
+		require.Empty(t, out)
+
+		prog, err := program.Decode(bytes.NewReader(fsys.MapFS["f.bin"].Data))
+		require.NoError(t, err)
+		require.NotContains(t, prog.String(), "i32.mul")
+	})
+
+	t.Run("writes a text dump", func(t *testing.T) {
+		fsys := source()
+		_, err := opt(t, fsys, "--text", "f.mvl", "-o", "f.mvm")
+		require.NoError(t, err)
+
+		prog, err := program.Parse(bytes.NewReader(fsys.MapFS["f.mvm"].Data))
+		require.NoError(t, err)
+		require.NoError(t, program.Verify(prog))
+	})
+
+	t.Run("prints the program without an output", func(t *testing.T) {
+		out, err := opt(t, source(), "-O0", "f.mvl")
+		require.NoError(t, err)
+		require.Contains(t, out, "i32.mul")
+	})
+
+	t.Run("runs the selected passes", func(t *testing.T) {
+		out, err := opt(t, source(), "--passes", "algebraic", "f.mvl")
+		require.NoError(t, err)
+		require.NotContains(t, out, "i32.mul")
+		require.Contains(t, out, "unreachable")
+	})
+
+	t.Run("prints a diff", func(t *testing.T) {
+		out, err := opt(t, source(), "--diff", "f.mvl")
+		require.NoError(t, err)
+		require.Contains(t, out, "--- f.mvl\n+++ f.mvl (optimized)\n@@ ")
+		require.Contains(t, out, "\n-\t0002:\ti32.const 0x00000001\n")
+		require.Contains(t, out, "\n \t0002:\treturn\n")
+	})
+
+	t.Run("prints no diff when nothing changes", func(t *testing.T) {
+		out, err := opt(t, source(), "-O0", "--diff", "f.mvl")
+		require.NoError(t, err)
+		require.Empty(t, out)
+	})
+
+	t.Run("rejects an unknown pass", func(t *testing.T) {
+		_, err := opt(t, source(), "--passes", "nope", "f.mvl")
+		require.ErrorContains(t, err, `unknown pass "nope"`)
+	})
+
+	t.Run("rejects a level with passes", func(t *testing.T) {
+		_, err := opt(t, source(), "-O1", "--passes", "fold", "f.mvl")
+		require.ErrorContains(t, err, "exclusive")
+	})
+
+	t.Run("rejects an unknown level", func(t *testing.T) {
+		_, err := opt(t, source(), "-O9", "f.mvl")
+		require.ErrorContains(t, err, "unknown level -O9")
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -28,6 +29,7 @@ type REPL struct {
 	codeLen   int // byte length of instr.Marshal(instrs); updated incrementally
 	constants []types.Value
 	types     []types.Type
+	info      *program.Debug  // debug info of the last .load, if any
 	debugger  *debug.Debugger // nil until first .break; breakpoint storage only
 }
 
@@ -58,7 +60,7 @@ Commands:
   .profile            profile accumulated program
   .load <file>        replace REPL state with the program parsed from <file>
   .save <file>        write the current program (code, constants, types) to <file>
-  .reset              clear all accumulated instructions, stack, constants, types, and breakpoints
+  .reset              clear all accumulated instructions, stack, constants, types, breakpoints, and watchpoints
 
 Debug commands:
   .break <ip>         set breakpoint at bytecode offset ip (func 0)
@@ -67,6 +69,13 @@ Debug commands:
   .clear <id>         remove breakpoint by ID
   .enable <id>        enable a breakpoint
   .disable <id>       disable a breakpoint
+  .watch <spec> [changed]
+                      stop before a write to a global, local, or heap slot
+                        e.g.  .watch global 0
+                              .watch local 1:0 changed   (func 1, slot 0)
+                              .watch heap 3:2            (heap addr 3, element 2)
+  .watch              list all watchpoints
+  .unwatch <id>       remove watchpoint by ID
   .debug              run accumulated program in debug mode (stops at first instruction)
                         In debug mode:
                           step/s       execute one instruction (entering calls)
@@ -80,6 +89,8 @@ Debug commands:
                           breaks       list breakpoints
                           break <spec> add breakpoint
                           clear <id>   remove breakpoint
+                          watch <spec> add watchpoint
+                          unwatch <id> remove watchpoint
                           quit/q       exit debug session
 
   .help               show this help
@@ -191,6 +202,18 @@ func (r *REPL) command(ctx context.Context, scanner *bufio.Scanner, line string)
 		if err := r.enableBreakpoint(arg, false); err != nil {
 			r.printErr(err)
 		}
+	case ".watch":
+		if arg == "" {
+			r.showWatchpoints()
+			break
+		}
+		if err := r.watchpoint(arg); err != nil {
+			r.printErr(err)
+		}
+	case ".unwatch":
+		if err := r.unwatch(arg); err != nil {
+			r.printErr(err)
+		}
 	case ".debug":
 		if err := r.debug(ctx, scanner); err != nil {
 			r.printErr(err)
@@ -271,7 +294,8 @@ func (r *REPL) addType(s string) error {
 	return nil
 }
 
-// load replaces REPL state with the program parsed from path. Merging
+// load replaces REPL state with the program read from path, in either
+// the text or the binary module form. Merging
 // into existing state would require renumbering instruction-embedded
 // constant and type indices; replace keeps the semantics simple and
 // matches what users expect from a "load this file" command.
@@ -288,7 +312,7 @@ func (r *REPL) load(path string) error {
 	}
 	defer file.Close()
 
-	prog, err := program.Parse(file)
+	prog, err := readProgram(file)
 	if err != nil {
 		return fmt.Errorf("parse %s: %w", path, err)
 	}
@@ -298,6 +322,14 @@ func (r *REPL) load(path string) error {
 	r.codeLen = len(prog.Code)
 	r.constants = append([]types.Value(nil), prog.Constants...)
 	r.types = append([]types.Type(nil), prog.Types...)
+	r.info = prog.Debug
+	if d := r.info; d != nil && d.Code != nil && len(d.Code.Lines) > 0 {
+		// Instructions typed after the load have no source; end the loaded
+		// code's last line range where the loaded code ends.
+		code := *d.Code
+		code.Lines = append(slices.Clip(code.Lines), types.Line{IP: len(prog.Code)})
+		r.info = &program.Debug{Code: &code, Globals: d.Globals}
+	}
 	fmt.Fprintf(r.out, "loaded %s\n", path)
 	return nil
 }
@@ -307,6 +339,7 @@ func (r *REPL) clear() {
 	r.codeLen = 0
 	r.constants = nil
 	r.types = nil
+	r.info = nil
 	r.debugger = nil
 }
 
@@ -501,17 +534,52 @@ func (r *REPL) enableBreakpoint(arg string, on bool) error {
 	return nil
 }
 
+func (r *REPL) watchpoint(spec string) error {
+	w, err := parseWatchSpec(spec)
+	if err != nil {
+		return err
+	}
+	r.ensureDebugger()
+	id := r.debugger.Watch(w)
+	fmt.Fprintf(r.out, "watchpoint %d set on %s\n", id, formatWatch(w))
+	return nil
+}
+
+func (r *REPL) unwatch(arg string) error {
+	if arg == "" {
+		return fmt.Errorf("usage: .unwatch <id>")
+	}
+	id, err := parseInt(arg)
+	if err != nil {
+		return fmt.Errorf("invalid watchpoint id %q: %w", arg, err)
+	}
+	r.ensureDebugger()
+	if !r.debugger.Clear(id) {
+		return fmt.Errorf("watchpoint %d not found", id)
+	}
+	fmt.Fprintf(r.out, "watchpoint %d cleared\n", id)
+	return nil
+}
+
 func (r *REPL) debug(ctx context.Context, scanner *bufio.Scanner) error {
 	if len(r.instrs) == 0 {
 		fmt.Fprintln(r.out, "(empty)")
 		return nil
 	}
 
+	// dbg copies the enabled points of r.debugger under IDs of its own; live
+	// maps each r.debugger ID to its copy so clear and unwatch reach both.
 	dbg := debug.NewDebugger()
+	live := make(map[int]int)
 	if r.debugger != nil {
 		for _, bp := range r.debugger.Breakpoints() {
 			if bp.Enabled {
-				dbg.Break(bp.Func, bp.IP)
+				live[bp.ID] = dbg.Break(bp.Func, bp.IP)
+			}
+		}
+		for _, w := range r.debugger.Watchpoints() {
+			if w.Enabled {
+				live[w.ID] = dbg.Watch(w)
 			}
 		}
 	}
@@ -524,7 +592,7 @@ func (r *REPL) debug(ctx context.Context, scanner *bufio.Scanner) error {
 		err := vm.Run(ctx)
 		if errors.Is(err, debug.ErrStopped) {
 			r.showStop(dbg.Stop(), vm)
-			done, loopErr := r.debugLoop(ctx, scanner, vm, dbg)
+			done, loopErr := r.debugLoop(ctx, scanner, vm, dbg, live)
 			if loopErr != nil {
 				return loopErr
 			}
@@ -543,7 +611,7 @@ func (r *REPL) debug(ctx context.Context, scanner *bufio.Scanner) error {
 	return nil
 }
 
-func (r *REPL) debugLoop(ctx context.Context, scanner *bufio.Scanner, vm *interp.Interpreter, dbg *debug.Debugger) (done bool, err error) {
+func (r *REPL) debugLoop(ctx context.Context, scanner *bufio.Scanner, vm *interp.Interpreter, dbg *debug.Debugger, live map[int]int) (done bool, err error) {
 	for {
 		fmt.Fprint(r.out, debugPrompt)
 		if !scanner.Scan() {
@@ -570,9 +638,9 @@ func (r *REPL) debugLoop(ctx context.Context, scanner *bufio.Scanner, vm *interp
 		case "stack":
 			printStack(r.out, vm)
 		case "locals":
-			printIndexed(r.out, vm, "local", vm.Local)
+			printIndexed(r.out, vm, "local", vm.Local, vm.Debug(vm.Func()).Local)
 		case "globals":
-			printIndexed(r.out, vm, "global", vm.Global)
+			printIndexed(r.out, vm, "global", vm.Global, vm.GlobalName)
 		case "frames":
 			printFrames(r.out, vm)
 		case "breaks":
@@ -589,35 +657,58 @@ func (r *REPL) debugLoop(ctx context.Context, scanner *bufio.Scanner, vm *interp
 			}
 			r.ensureDebugger()
 			rid := r.debugger.Break(fn, ip)
-			dbg.Break(fn, ip)
+			live[rid] = dbg.Break(fn, ip)
 			fmt.Fprintf(r.out, "breakpoint %d set at func=%d ip=%d\n", rid, fn, ip)
 		case "clear":
 			if arg == "" {
 				r.printErr(fmt.Errorf("usage: clear <id>"))
 				continue
 			}
-			id, perr := parseInt(arg)
+			if err := r.clearBreakpoint(arg); err != nil {
+				r.printErr(err)
+				continue
+			}
+			unmirror(dbg, live, arg)
+		case "watch":
+			w, perr := parseWatchSpec(arg)
 			if perr != nil {
-				r.printErr(fmt.Errorf("invalid breakpoint id %q: %w", arg, perr))
+				r.printErr(perr)
 				continue
 			}
 			r.ensureDebugger()
-			if !r.debugger.Clear(id) {
-				r.printErr(fmt.Errorf("breakpoint %d not found", id))
+			rid := r.debugger.Watch(w)
+			live[rid] = dbg.Watch(w)
+			fmt.Fprintf(r.out, "watchpoint %d set on %s\n", rid, formatWatch(w))
+		case "unwatch":
+			if err := r.unwatch(arg); err != nil {
+				r.printErr(err)
 				continue
 			}
-			fmt.Fprintf(r.out, "breakpoint %d cleared\n", id)
+			unmirror(dbg, live, arg)
 		case "quit", "exit", "q":
 			return true, nil
 		case "":
 			// empty line: re-print current location
 			r.showStop(dbg.Stop(), vm)
 		default:
-			fmt.Fprintf(r.out, "unknown debug command: %q (step/next/finish/continue/stack/locals/globals/frames/breaks/break/clear/quit)\n", line)
+			fmt.Fprintf(r.out, "unknown debug command: %q (step/next/finish/continue/stack/locals/globals/frames/breaks/break/clear/watch/unwatch/quit)\n", line)
 		}
 	}
 }
 
+// unmirror clears from dbg the copy of the point arg names, which the caller
+// has already cleared from the REPL's debugger.
+func unmirror(dbg *debug.Debugger, live map[int]int, arg string) {
+	id, err := parseInt(arg)
+	if err != nil {
+		return
+	}
+	if lid, ok := live[id]; ok {
+		dbg.Clear(lid)
+		delete(live, id)
+	}
+}
+
 func (r *REPL) showBreakpoints() {
 	if r.debugger == nil {
 		fmt.Fprintln(r.out, "no breakpoints")
@@ -637,17 +728,42 @@ func (r *REPL) showBreakpoints() {
 	}
 }
 
+func (r *REPL) showWatchpoints() {
+	var ws []debug.Watchpoint
+	if r.debugger != nil {
+		ws = r.debugger.Watchpoints()
+	}
+	if len(ws) == 0 {
+		fmt.Fprintln(r.out, "no watchpoints")
+		return
+	}
+	for _, w := range ws {
+		state := "enabled"
+		if !w.Enabled {
+			state = "disabled"
+		}
+		fmt.Fprintf(r.out, "watchpoint %d: %s %s hits=%d\n", w.ID, formatWatch(w), state, w.Hits)
+	}
+}
+
 func (r *REPL) showStop(stop debug.Stop, vm *interp.Interpreter) {
-	if stop.Breakpoint != 0 {
+	switch {
+	case stop.Breakpoint != 0:
 		fmt.Fprintf(r.out, "breakpoint %d at func=%d ip=%04d", stop.Breakpoint, stop.Func, stop.IP)
-	} else {
+	case stop.Watchpoint != 0:
+		fmt.Fprintf(r.out, "watchpoint %d at func=%d ip=%04d", stop.Watchpoint, stop.Func, stop.IP)
+	default:
 		fmt.Fprintf(r.out, "stopped at func=%d ip=%04d", stop.Func, stop.IP)
 	}
+	fmt.Fprint(r.out, formatSource(stop.Name, stop.Pos))
 	if op, err := vm.Opcode(); err == nil {
 		if typ := instr.TypeOf(op); typ.Mnemonic != "" {
 			fmt.Fprintf(r.out, " (%s)", typ.Mnemonic)
 		}
 	}
+	if stop.Watchpoint != 0 {
+		fmt.Fprintf(r.out, " old=%s new=%s", formatValue(stop.Old, vm), formatValue(stop.New, vm))
+	}
 	fmt.Fprintln(r.out)
 }
 
@@ -662,6 +778,7 @@ func (r *REPL) build(extra ...instr.Instruction) *program.Program {
 		append(r.instrs, extra...),
 		program.WithConstants(r.constants...),
 		program.WithTypes(r.types...),
+		program.WithDebug(r.info),
 	)
 }
 
@@ -689,13 +806,18 @@ func formatPercent(value, total uint64) string {
 	return fmt.Sprintf("%.1f%%", float64(value)/float64(total)*100)
 }
 
-func printIndexed(out io.Writer, vm *interp.Interpreter, label string, get func(int) (types.Boxed, error)) {
+// printIndexed prints every slot get yields, naming each one name reports.
+func printIndexed(out io.Writer, vm *interp.Interpreter, label string, get func(int) (types.Boxed, error), name func(int) string) {
 	var parts []string
 	for i := 0; ; i++ {
 		v, err := get(i)
 		if err != nil {
 			break
 		}
+		if n := name(i); n != "" {
+			parts = append(parts, fmt.Sprintf("%s[%d] %s=%s", label, i, n, formatValue(v, vm)))
+			continue
+		}
 		parts = append(parts, fmt.Sprintf("%s[%d]=%s", label, i, formatValue(v, vm)))
 	}
 	if len(parts) == 0 {
@@ -720,8 +842,32 @@ func printFrames(out io.Writer, vm *interp.Interpreter) {
 		if n == 0 {
 			marker = ">"
 		}
-		fmt.Fprintf(out, "%s frame[%d] func=%d ip=%04d\n", marker, n, fn, ip)
+		var source string
+		if d := vm.Debug(fn); d != nil {
+			// A caller's IP already points past its CALL, so its source is
+			// the call site's.
+			at := ip
+			if n > 0 {
+				at--
+			}
+			pos, _ := d.Position(at)
+			source = formatSource(d.Name, pos)
+		}
+		fmt.Fprintf(out, "%s frame[%d] func=%d ip=%04d%s\n", marker, n, fn, ip, source)
+	}
+}
+
+// formatSource renders a function name and source position from debug info as
+// a suffix for a location line, or "" when neither is known.
+func formatSource(name string, pos types.Position) string {
+	var s string
+	if name != "" {
+		s += " " + name
+	}
+	if pos.IsValid() {
+		s += " at " + pos.String()
 	}
+	return s
 }
 
 // normalize converts "@N" absolute byte targets in branch instructions to relative
@@ -806,6 +952,75 @@ func parseBreakSpec(spec string) (fn, ip int, err error) {
 	return fn, ip, nil
 }
 
+// parseWatchSpec parses "<global|local|heap> <slot> [changed]". A local slot
+// may name its function as <fn>:<idx> and a heap slot its element as
+// <addr>:<idx>; a bare heap address watches the whole object.
+func parseWatchSpec(spec string) (debug.Watchpoint, error) {
+	var w debug.Watchpoint
+	fields := strings.Fields(spec)
+	if len(fields) == 3 && fields[2] == "changed" {
+		w.Changed = true
+		fields = fields[:2]
+	}
+	if len(fields) != 2 {
+		return w, fmt.Errorf("usage: .watch <global|local|heap> <slot> [changed]")
+	}
+
+	slot := fields[1]
+	switch fields[0] {
+	case "global":
+		w.Kind = debug.WatchGlobal
+		idx, err := parseInt(slot)
+		if err != nil {
+			return w, fmt.Errorf("invalid global index: %w", err)
+		}
+		w.Index = idx
+	case "local":
+		w.Kind = debug.WatchLocal
+		fn, idx, err := parseBreakSpec(slot)
+		if err != nil {
+			return w, err
+		}
+		w.Func, w.Index = fn, idx
+	case "heap":
+		w.Kind = debug.WatchHeap
+		w.Index = -1
+		addr, idx, ok := strings.Cut(slot, ":")
+		a, err := parseInt(addr)
+		if err != nil {
+			return w, fmt.Errorf("invalid heap address: %w", err)
+		}
+		w.Addr = a
+		if ok {
+			if w.Index, err = parseInt(idx); err != nil {
+				return w, fmt.Errorf("invalid element index: %w", err)
+			}
+		}
+	default:
+		return w, fmt.Errorf("unknown watch target %q (global/local/heap)", fields[0])
+	}
+	return w, nil
+}
+
+func formatWatch(w debug.Watchpoint) string {
+	var s string
+	switch w.Kind {
+	case debug.WatchGlobal:
+		s = fmt.Sprintf("global %d", w.Index)
+	case debug.WatchLocal:
+		s = fmt.Sprintf("local %d:%d", w.Func, w.Index)
+	default:
+		s = fmt.Sprintf("heap %d", w.Addr)
+		if w.Index >= 0 {
+			s += fmt.Sprintf(":%d", w.Index)
+		}
+	}
+	if w.Changed {
+		s += " changed"
+	}
+	return s
+}
+
 func parseInt(s string) (int, error) {
 	v, err := strconv.ParseInt(s, 0, 64)
 	if err != nil {
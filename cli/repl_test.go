@@ -289,6 +289,45 @@ func TestREPL_Run(t *testing.T) {
 			input:    ".break 0\n.reset\n.breaks\n.quit\n",
 			contains: []string{"reset.", "no breakpoints"},
 		},
+		{
+			// .watch with no watchpoints set
+			input:    ".watch\n.quit\n",
+			contains: []string{"no watchpoints"},
+		},
+		{
+			// .watch sets a watchpoint, .watch lists it, .unwatch removes it
+			input:    ".watch heap 3:1 changed\n.watch\n.unwatch 1\n.watch\n.quit\n",
+			contains: []string{"watchpoint 1 set on heap 3:1 changed", "watchpoint 1: heap 3:1 changed enabled hits=0", "watchpoint 1 cleared", "no watchpoints"},
+		},
+		{
+			// watchpoint command errors stay in the REPL
+			input:    ".watch global\n.watch frob 1\n.watch global x\n.watch local a:1\n.watch heap x\n.watch heap 1:x\n.unwatch\n.unwatch x\n.unwatch 9\n.quit\n",
+			contains: []string{"usage: .watch", "unknown watch target", "invalid global index", "invalid function index", "invalid heap address", "invalid element index", "usage: .unwatch", "invalid watchpoint id", "watchpoint 9 not found"},
+		},
+		{
+			// .debug stops before a watched local is written
+			input:    ".const\nfunc(i32) i32\ni32.const 9\nlocal.set 0\nlocal.get 0\nreturn\n\ni32.const 1\nconst.get 0\ncall\n.watch local 1:0\n.debug\ncontinue\ncontinue\n.quit\n",
+			contains: []string{"watchpoint 1 at func=1 ip=0005 (local.set) old=1 new=9"},
+			excludes: []string{"error:"},
+		},
+		{
+			// unwatch in the debug sub-loop stops the live session watching too
+			input:    ".const\nfunc(i32) i32\ni32.const 9\nlocal.set 0\nlocal.get 0\nreturn\n\ni32.const 1\nconst.get 0\ncall\n.watch local 1:0\n.debug\nunwatch 1\ncontinue\n.quit\n",
+			contains: []string{"watchpoint 1 cleared", "9"},
+			excludes: []string{"watchpoint 1 at", "error:"},
+		},
+		{
+			// watch then unwatch in the debug sub-loop, then continue
+			input:    ".const\nfunc(i32) i32\ni32.const 9\nlocal.set 0\nlocal.get 0\nreturn\n\ni32.const 1\nconst.get 0\ncall\n.debug\nwatch local 1:0\nunwatch 1\ncontinue\n.quit\n",
+			contains: []string{"watchpoint 1 set on local 1:0", "watchpoint 1 cleared"},
+			excludes: []string{"watchpoint 1 at", "error:"},
+		},
+		{
+			// clear in the debug sub-loop stops the live session breaking too
+			input:    "i32.const 42\ni32.const 8\n.break 5\n.debug\nclear 1\ncontinue\n.quit\n",
+			contains: []string{"breakpoint 1 cleared", "8"},
+			excludes: []string{"breakpoint 1 at", "error:"},
+		},
 		{
 			// .debug stops at first instruction in step mode
 			input:    "i32.const 42\n.debug\nstep\n.quit\n",
@@ -463,6 +502,32 @@ func TestREPL_Run(t *testing.T) {
 		require.NotContains(t, output, "i32.const 0x00000001")
 	})
 
+	t.Run("load keeps debug info", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "debug.mvm")
+		src := `.code
+const.get 0
+call
+.constants
+0000:	func() i32
+	i32.const 7
+	return
+.debug
+	code name "main"
+	code line 0 "main.mvm" 1 1
+	const 0 name "seven"
+	const 0 line 0 "lib.mvm" 2 3
+`
+		require.NoError(t, os.WriteFile(path, []byte(src), 0o644))
+
+		var out bytes.Buffer
+		r := cli.NewREPL(strings.NewReader(".load "+path+"\n.debug\nstep\nstep\nframes\nquit\n.quit\n"), &out, cli.OS())
+		require.NoError(t, r.Run(context.Background()))
+		output := out.String()
+		require.Contains(t, output, "stopped at func=0 ip=0000 main at main.mvm:1:1")
+		require.Contains(t, output, "ip=0000 seven at lib.mvm:2:3\n")
+		require.Contains(t, output, "frame[1] func=0 ip=0004 main at main.mvm:1:1\n")
+	})
+
 	t.Run("load reports parse errors", func(t *testing.T) {
 		path := filepath.Join(t.TempDir(), "broken.mvm")
 		require.NoError(t, os.WriteFile(path, []byte("not-an-instruction xyz\n"), 0o644))
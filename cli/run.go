@@ -1,17 +1,24 @@
 package cli
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"strings"
 
 	"github.com/siyul-park/minivm/interp"
+	"github.com/siyul-park/minivm/lang"
 	"github.com/siyul-park/minivm/program"
 	"github.com/spf13/cobra"
 )
 
 // NewRunCommand returns the `minivm run <file>` subcommand. It loads
-// <file> from fsys, parses it as a Program.String() dump, runs it to
-// completion, and prints the final operand stack.
+// <file> from fsys, either as a binary module written by program.Encode,
+// as source compiled by lang.Compile when it ends in lang.Ext, or as a
+// Program.String() dump, runs it to completion, and prints the final
+// operand stack.
 //
 // fsys is the standard io/fs.FS so callers may pass os.DirFS, embed.FS,
 // or fstest.MapFS without adapter wrappers.
@@ -25,19 +32,9 @@ func NewRunCommand(fsys fs.FS) *cobra.Command {
 	cmd.RunE = func(cmd *cobra.Command, args []string) error {
 		path := args[0]
 
-		file, err := fsys.Open(path)
+		prog, err := loadProgram(fsys, path)
 		if err != nil {
-			return fmt.Errorf("open %s: %w", path, err)
-		}
-		defer file.Close()
-
-		prog, err := program.Parse(file)
-		if err != nil {
-			return fmt.Errorf("parse %s: %w", path, err)
-		}
-
-		if err := program.Verify(prog); err != nil {
-			return fmt.Errorf("verify %s: %w", path, err)
+			return err
 		}
 
 		vm := interp.New(prog)
@@ -52,3 +49,76 @@ func NewRunCommand(fsys fs.FS) *cobra.Command {
 	}
 	return cmd
 }
+
+// loadProgram reads path from fsys with readFile and verifies the result,
+// locating a verification failure in the source when the program carries
+// debug info.
+func loadProgram(fsys fs.FS, path string) (*program.Program, error) {
+	prog, err := readFile(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	if err := program.Verify(prog); err != nil {
+		return nil, fmt.Errorf("verify %s: %w", locate(prog, path, err), err)
+	}
+	return prog, nil
+}
+
+// readFile opens path in fsys and compiles it with lang.Compile when it is a
+// source file or reads it with readProgram otherwise. Only lang.Compile
+// verifies, and readFile locates its failures as loadProgram does.
+func readFile(fsys fs.FS, path string) (*program.Program, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var prog *program.Program
+	if strings.HasSuffix(path, lang.Ext) {
+		prog, err = lang.Compile(path, file)
+		var lerr *lang.Error
+		if errors.As(err, &lerr) && lerr.Prog != nil {
+			return nil, fmt.Errorf("verify %s: %w", locate(lerr.Prog, path, lerr.Err), lerr.Err)
+		}
+	} else {
+		prog, err = readProgram(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return prog, nil
+}
+
+// locate names where a program.VerifyError points: the source position of
+// the failing instruction when debug info has one, or path, followed by the
+// name of the function it is in when it has one.
+func locate(prog *program.Program, path string, err error) string {
+	var verr *program.VerifyError
+	if !errors.As(err, &verr) {
+		return path
+	}
+	loc := path
+	if fn := functions(prog)[verr.Slot]; fn != nil {
+		if pos, ok := fn.Debug.Position(verr.IP); ok {
+			loc = pos.String()
+			if pos.File == "" {
+				loc = path + ":" + loc
+			}
+		}
+	}
+	if name := prog.FuncName(verr.Slot); name != "" {
+		loc += " (" + name + ")"
+	}
+	return loc
+}
+
+// readProgram decodes r as a binary module when it starts with
+// program.Magic and parses it as a Program.String() dump otherwise.
+func readProgram(r io.Reader) (*program.Program, error) {
+	br := bufio.NewReader(r)
+	if head, _ := br.Peek(len(program.Magic)); string(head) == program.Magic {
+		return program.Decode(br)
+	}
+	return program.Parse(br)
+}
@@ -8,6 +8,8 @@ import (
 	"testing/fstest"
 
 	cli "github.com/siyul-park/minivm/cli"
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/program"
 	"github.com/stretchr/testify/require"
 )
 
@@ -26,6 +28,55 @@ func TestNewRunCommand(t *testing.T) {
 		require.Contains(t, out.String(), "3")
 	})
 
+	t.Run("runs binary program", func(t *testing.T) {
+		var data bytes.Buffer
+		require.NoError(t, program.Encode(&data, program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 6),
+			instr.New(instr.I32_CONST, 7),
+			instr.New(instr.I32_MUL),
+		})))
+		fsys := fstest.MapFS{
+			"mul.mvmb": &fstest.MapFile{Data: data.Bytes()},
+		}
+		var out bytes.Buffer
+		cmd := cli.NewRunCommand(fsys)
+		cmd.SetOut(&out)
+		cmd.SetErr(&out)
+		cmd.SetArgs([]string{"mul.mvmb"})
+
+		require.NoError(t, cmd.ExecuteContext(context.Background()))
+		require.Equal(t, "42\n", out.String())
+	})
+
+	t.Run("runs source program", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"fib.mvl": &fstest.MapFile{Data: []byte("fn fib(n: i32) -> i32 {\n\tif n < 2 { return n; }\n\treturn fib(n - 1) + fib(n - 2);\n}\nlet n = 10;\nfib(n)\n")},
+		}
+		var out bytes.Buffer
+		cmd := cli.NewRunCommand(fsys)
+		cmd.SetOut(&out)
+		cmd.SetErr(&out)
+		cmd.SetArgs([]string{"fib.mvl"})
+
+		require.NoError(t, cmd.ExecuteContext(context.Background()))
+		require.Equal(t, "55\n", out.String())
+	})
+
+	t.Run("source error reports position", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"bad.mvl": &fstest.MapFile{Data: []byte("let x: i32 = \"a\";\n")},
+		}
+		var out bytes.Buffer
+		cmd := cli.NewRunCommand(fsys)
+		cmd.SetOut(&out)
+		cmd.SetErr(&out)
+		cmd.SetArgs([]string{"bad.mvl"})
+
+		err := cmd.ExecuteContext(context.Background())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "parse bad.mvl: bad.mvl:1:")
+	})
+
 	t.Run("empty stack produces no output", func(t *testing.T) {
 		fsys := fstest.MapFS{
 			"nop.mvm": &fstest.MapFile{Data: []byte("0000:\tnop\n")},
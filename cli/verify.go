@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/siyul-park/minivm/analysis"
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/pass"
+	"github.com/siyul-park/minivm/program"
+	"github.com/spf13/cobra"
+)
+
+// NewVerifyCommand returns the `minivm verify <file>` subcommand. It loads
+// <file> from fsys as `run` does, which verifies it, and prints "ok". With
+// --cost it also prints the worst-case cost of one call to each function:
+// instructions, allocations, and weight, where --weight mnemonic=n prices an
+// opcode and every other opcode weighs 1. A function whose cost has no static
+// bound is reported unbounded, with the loops, recursions, and dynamic calls
+// responsible.
+func NewVerifyCommand(fsys fs.FS) *cobra.Command {
+	var cost bool
+	var weights map[string]int64
+	cmd := &cobra.Command{
+		Use:          "verify <file>",
+		Short:        "Verify a MiniVM program",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+	}
+	cmd.Flags().BoolVar(&cost, "cost", false, "print the worst-case cost of each function")
+	cmd.Flags().StringToInt64Var(&weights, "weight", nil, "weigh an opcode by mnemonic, as in call=10")
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		path := args[0]
+
+		prog, err := loadProgram(fsys, path)
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintln(out, "ok")
+		if !cost {
+			return nil
+		}
+
+		table, err := weightTable(weights)
+		if err != nil {
+			return err
+		}
+		m := pass.NewManager()
+		pass.Register(m, analysis.NewBlocksAnalysis())
+		pass.Register(m, analysis.NewCostAnalysis(table))
+		costs, err := pass.GetResult[[]*analysis.Cost](m, prog)
+		if err != nil {
+			return fmt.Errorf("cost %s: %w", path, err)
+		}
+		printCosts(out, prog, costs)
+		return nil
+	}
+	return cmd
+}
+
+// weightTable builds the per-opcode weights --weight describes, or nil when
+// it describes none.
+func weightTable(weights map[string]int64) (*[256]uint64, error) {
+	if len(weights) == 0 {
+		return nil, nil
+	}
+	ops := make(map[string]instr.Opcode)
+	for code := 0; code < 256; code++ {
+		if typ := instr.TypeOf(instr.Opcode(code)); typ.Mnemonic != "" {
+			ops[typ.Mnemonic] = instr.Opcode(code)
+		}
+	}
+	table := &[256]uint64{}
+	for code := range table {
+		table[code] = 1
+	}
+	for name, w := range weights {
+		op, ok := ops[name]
+		if !ok {
+			return nil, fmt.Errorf("weight: unknown opcode %q", name)
+		}
+		if w < 0 {
+			return nil, fmt.Errorf("weight: negative weight for %s", name)
+		}
+		table[op] = uint64(w)
+	}
+	return table, nil
+}
+
+// printCosts writes one tab-separated line per function slot with a cost.
+func printCosts(out io.Writer, prog *program.Program, costs []*analysis.Cost) {
+	fmt.Fprintln(out, "func\tname\tinstructions\tallocations\tweight")
+	for slot, c := range costs {
+		if c == nil {
+			continue
+		}
+		name := funcName(prog, slot)
+		if len(c.Unbounded) == 0 {
+			fmt.Fprintf(out, "%d\t%s\t%d\t%d\t%d\n", slot, name, c.Instructions, c.Allocations, c.Weight)
+			continue
+		}
+		sites := make([]string, len(c.Unbounded))
+		for k, u := range c.Unbounded {
+			sites[k] = fmt.Sprintf("%s at %d:%04d", u.Kind, u.Slot, u.IP)
+		}
+		fmt.Fprintf(out, "%d\t%s\tunbounded\t%s\n", slot, name, strings.Join(sites, ", "))
+	}
+}
+
+// funcName names function slot as Program.FuncName does, or returns "-"
+// when nothing names it.
+func funcName(prog *program.Program, slot int) string {
+	if name := prog.FuncName(slot); name != "" {
+		return name
+	}
+	return "-"
+}
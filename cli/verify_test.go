@@ -0,0 +1,94 @@
+package cli_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"testing/fstest"
+
+	cli "github.com/siyul-park/minivm/cli"
+	"github.com/siyul-park/minivm/program"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewVerifyCommand(t *testing.T) {
+	verify := func(t *testing.T, fsys fstest.MapFS, args ...string) (string, error) {
+		t.Helper()
+		var out bytes.Buffer
+		cmd := cli.NewVerifyCommand(fsys)
+		cmd.SetOut(&out)
+		cmd.SetErr(&out)
+		cmd.SetArgs(args)
+		err := cmd.ExecuteContext(context.Background())
+		return out.String(), err
+	}
+
+	t.Run("prints ok for a valid program", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"add.mvm": &fstest.MapFile{Data: []byte("0000:\ti32.const 0x00000001\n0005:\ti32.const 0x00000002\n0010:\ti32.add\n")},
+		}
+
+		out, err := verify(t, fsys, "add.mvm")
+		require.NoError(t, err)
+		require.Equal(t, "ok\n", out)
+	})
+
+	t.Run("rejects an invalid program", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"bad.mvm": &fstest.MapFile{Data: []byte("0000:\ti32.add\n")},
+		}
+
+		_, err := verify(t, fsys, "bad.mvm")
+		require.ErrorIs(t, err, program.ErrStackUnderflow)
+	})
+
+	t.Run("locates a failure by debug info", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"bad.mvm": &fstest.MapFile{Data: []byte(".code\n0000:\ti32.add\n.debug\n\tcode name \"main\"\n\tcode line 0 \"bad.src\" 3 1\n")},
+		}
+
+		_, err := verify(t, fsys, "bad.mvm")
+		require.ErrorIs(t, err, program.ErrStackUnderflow)
+		require.ErrorContains(t, err, "verify bad.src:3:1 (main): ")
+	})
+
+	t.Run("prints costs", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"add.mvl": &fstest.MapFile{Data: []byte("fn add(a: i32, b: i32) -> i32 { return a + b; }\nadd(1, 2)\n")},
+		}
+
+		out, err := verify(t, fsys, "--cost", "add.mvl")
+		require.NoError(t, err)
+		require.Contains(t, out, "func\tname\tinstructions\tallocations\tweight\n")
+		require.Contains(t, out, "\tadd\t4\t0\t4\n")
+	})
+
+	t.Run("weighs opcodes", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"add.mvl": &fstest.MapFile{Data: []byte("fn add(a: i32, b: i32) -> i32 { return a + b; }\nadd(1, 2)\n")},
+		}
+
+		out, err := verify(t, fsys, "--cost", "--weight", "i32.add=10", "add.mvl")
+		require.NoError(t, err)
+		require.Contains(t, out, "\tadd\t4\t0\t13\n")
+	})
+
+	t.Run("reports unbounded functions", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"fib.mvl": &fstest.MapFile{Data: []byte("fn fib(n: i32) -> i32 {\n\tif n < 2 { return n; }\n\treturn fib(n - 1) + fib(n - 2);\n}\nfib(10)\n")},
+		}
+
+		out, err := verify(t, fsys, "--cost", "fib.mvl")
+		require.NoError(t, err)
+		require.Regexp(t, `\tfib\tunbounded\trecursion at \d+:\d{4}`, out)
+	})
+
+	t.Run("rejects an unknown opcode weight", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"add.mvm": &fstest.MapFile{Data: []byte("0000:\ti32.const 0x00000001\n")},
+		}
+
+		_, err := verify(t, fsys, "--cost", "--weight", "nope=1", "add.mvm")
+		require.ErrorContains(t, err, `unknown opcode "nope"`)
+	})
+}
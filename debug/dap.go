@@ -0,0 +1,943 @@
+package debug
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/siyul-park/minivm/interp"
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
+)
+
+// Server speaks the Debug Adapter Protocol to one client at a time, driving a
+// Debugger over an interpreter so editors can set breakpoints, step, and
+// inspect frames, slots, and heap values. The guest runs on its own goroutine;
+// inspection requests are answered only while it is stopped.
+type Server struct {
+	load func(path string) (*program.Program, error)
+}
+
+type session struct {
+	load func(path string) (*program.Program, error)
+
+	in  *bufio.Reader
+	out io.Writer
+	wmu sync.Mutex
+	seq int
+
+	mu      sync.Mutex
+	state   sessionState
+	prog    *program.Program
+	vm      *interp.Interpreter
+	dbg     *Debugger
+	reason  string
+	from    *stepPoint
+	handles []handle
+	debug   []funcDebug
+	sources map[string][]int
+	instrs  []int
+
+	configured bool
+	pausing    atomic.Bool
+	resume     chan struct{}
+	done       chan struct{}
+	cancel     context.CancelFunc
+}
+
+// maxMessage bounds the Content-Length of a request, so a bad header cannot
+// make read allocate without limit.
+const maxMessage = 16 << 20
+
+type sessionState int
+
+const (
+	sessionIdle sessionState = iota
+	sessionLaunched
+	sessionRunning
+	sessionStopped
+	sessionExited
+)
+
+// stepPoint is the source line a line-granular step started from. The run
+// loop keeps stepping while the guest stays on it.
+type stepPoint struct {
+	fn   int
+	pos  types.Position
+	next bool
+}
+
+// funcDebug pairs a function's heap address with its debug info.
+type funcDebug struct {
+	addr  int
+	debug *types.Debug
+}
+
+// handle is what a variablesReference names: a scope of the stopped frame or
+// the heap object at addr.
+type handle struct {
+	kind handleKind
+	addr int
+}
+
+type handleKind int
+
+const (
+	handleLocals handleKind = iota
+	handleStack
+	handleGlobals
+	handleHeap
+)
+
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+type dapResponse struct {
+	Seq        int    `json:"seq"`
+	Type       string `json:"type"`
+	RequestSeq int    `json:"request_seq"`
+	Success    bool   `json:"success"`
+	Command    string `json:"command"`
+	Message    string `json:"message,omitempty"`
+	Body       any    `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int    `json:"seq"`
+	Type  string `json:"type"`
+	Event string `json:"event"`
+	Body  any    `json:"body,omitempty"`
+}
+
+type dapSource struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type dapBreakpoint struct {
+	ID       int        `json:"id,omitempty"`
+	Verified bool       `json:"verified"`
+	Message  string     `json:"message,omitempty"`
+	Source   *dapSource `json:"source,omitempty"`
+	Line     int        `json:"line,omitempty"`
+}
+
+type dapFrame struct {
+	ID                          int        `json:"id"`
+	Name                        string     `json:"name"`
+	Source                      *dapSource `json:"source,omitempty"`
+	Line                        int        `json:"line"`
+	Column                      int        `json:"column"`
+	InstructionPointerReference string     `json:"instructionPointerReference,omitempty"`
+}
+
+type dapScope struct {
+	Name               string `json:"name"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	Type               string `json:"type,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+var (
+	errNotLaunched = errors.New("no program launched")
+	errNotStopped  = errors.New("program is not stopped")
+)
+
+// NewServer returns a Server that resolves a launch request's "program"
+// argument through load.
+func NewServer(load func(path string) (*program.Program, error)) *Server {
+	return &Server{load: load}
+}
+
+// Serve answers requests read from conn until the client disconnects, conn
+// reaches EOF, or ctx is done. A conn that is also an io.Closer is closed when
+// ctx is done, which unblocks a pending read. It stops the guest before
+// returning.
+func (s *Server) Serve(ctx context.Context, conn io.ReadWriter) error {
+	if c, ok := conn.(io.Closer); ok {
+		stop := context.AfterFunc(ctx, func() { _ = c.Close() })
+		defer stop()
+	}
+
+	ss := &session{
+		load:    s.load,
+		in:      bufio.NewReader(conn),
+		out:     conn,
+		sources: map[string][]int{},
+		resume:  make(chan struct{}, 1),
+	}
+	defer ss.close()
+
+	for ctx.Err() == nil {
+		req, err := ss.read()
+		if errors.Is(err, io.EOF) || ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if req.Type != "request" {
+			continue
+		}
+		if done := ss.handle(ctx, req); done {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *session) handle(ctx context.Context, req *dapRequest) bool {
+	var body any
+	var err error
+	switch req.Command {
+	case "initialize":
+		body = map[string]any{
+			"supportsConfigurationDoneRequest": true,
+			"supportsInstructionBreakpoints":   true,
+			"supportsTerminateRequest":         true,
+		}
+	case "launch":
+		err = s.launch(req.Arguments)
+		if err == nil {
+			s.respond(req, nil, nil)
+			s.event("initialized", nil)
+			return false
+		}
+	case "setBreakpoints":
+		body, err = s.setBreakpoints(req.Arguments)
+	case "setInstructionBreakpoints":
+		body, err = s.setInstructionBreakpoints(req.Arguments)
+	case "setExceptionBreakpoints":
+		body = map[string]any{"breakpoints": []dapBreakpoint{}}
+	case "configurationDone":
+		err = s.configurationDone(ctx)
+	case "threads":
+		body = map[string]any{"threads": []map[string]any{{"id": 1, "name": "main"}}}
+	case "stackTrace":
+		body, err = s.stackTrace(req.Arguments)
+	case "scopes":
+		body, err = s.scopes(req.Arguments)
+	case "variables":
+		body, err = s.variables(req.Arguments)
+	case "continue", "next", "stepIn", "stepOut":
+		if err = s.step(req.Command, req.Arguments); err == nil {
+			if req.Command == "continue" {
+				body = map[string]any{"allThreadsContinued": true}
+			}
+			s.respond(req, body, nil)
+			s.resume <- struct{}{}
+			return false
+		}
+	case "pause":
+		s.pause()
+	case "terminate":
+		s.terminate()
+	case "disconnect":
+		s.close()
+		s.respond(req, nil, nil)
+		return true
+	default:
+		err = fmt.Errorf("unsupported request %q", req.Command)
+	}
+	s.respond(req, body, err)
+	return false
+}
+
+func (s *session) launch(raw json.RawMessage) error {
+	var args struct {
+		Program     string `json:"program"`
+		StopOnEntry bool   `json:"stopOnEntry"`
+	}
+	if err := unmarshal(raw, &args); err != nil {
+		return err
+	}
+	if args.Program == "" {
+		return errors.New("launch requires a program")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state != sessionIdle {
+		return errors.New("program already launched")
+	}
+	prog, err := s.load(args.Program)
+	if err != nil {
+		return err
+	}
+
+	s.dbg = NewDebugger()
+	if args.StopOnEntry {
+		s.dbg.Step()
+		s.reason = "entry"
+	}
+	s.prog = prog
+	s.vm = interp.New(prog, interp.WithHook(s.hook), interp.WithTick(1), interp.WithThreshold(-1))
+	s.state = sessionLaunched
+
+	// Breakpoints are placed while the guest runs, when its heap is off
+	// limits, so gather the debug info of the module and its function
+	// constants up front.
+	addrs := []int{0}
+	for idx := 0; ; idx++ {
+		c, err := s.vm.Const(idx)
+		if err != nil {
+			break
+		}
+		if c.Kind() == types.KindRef {
+			addrs = append(addrs, c.Ref())
+		}
+	}
+	for _, addr := range addrs {
+		if d := s.vm.Debug(addr); d != nil {
+			s.debug = append(s.debug, funcDebug{addr: addr, debug: d})
+		}
+	}
+	return nil
+}
+
+func (s *session) configurationDone(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == sessionIdle {
+		return errNotLaunched
+	}
+	if s.configured {
+		return nil
+	}
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.configured = true
+	s.state = sessionRunning
+	s.done = make(chan struct{})
+	go s.run(ctx)
+	return nil
+}
+
+func (s *session) setBreakpoints(raw json.RawMessage) (any, error) {
+	var args struct {
+		Source      dapSource `json:"source"`
+		Breakpoints []struct {
+			Line      int    `json:"line"`
+			Condition string `json:"condition"`
+		} `json:"breakpoints"`
+	}
+	if err := unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dbg == nil {
+		return nil, errNotLaunched
+	}
+
+	path := args.Source.Path
+	if path == "" {
+		path = args.Source.Name
+	}
+	for _, id := range s.sources[path] {
+		s.dbg.Clear(id)
+	}
+	delete(s.sources, path)
+
+	out := make([]dapBreakpoint, 0, len(args.Breakpoints))
+	for _, b := range args.Breakpoints {
+		bp := dapBreakpoint{Source: &args.Source, Line: b.Line}
+		fn, ip, ok := s.locate(path, b.Line)
+		switch {
+		case b.Condition != "":
+			bp.Message = "conditions are not supported"
+		case !ok:
+			bp.Message = "no code at this line"
+		default:
+			bp.ID = s.dbg.Break(fn, ip)
+			bp.Verified = true
+			s.sources[path] = append(s.sources[path], bp.ID)
+		}
+		out = append(out, bp)
+	}
+	return map[string]any{"breakpoints": out}, nil
+}
+
+func (s *session) setInstructionBreakpoints(raw json.RawMessage) (any, error) {
+	var args struct {
+		Breakpoints []struct {
+			InstructionReference string `json:"instructionReference"`
+			Offset               int    `json:"offset"`
+		} `json:"breakpoints"`
+	}
+	if err := unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dbg == nil {
+		return nil, errNotLaunched
+	}
+
+	for _, id := range s.instrs {
+		s.dbg.Clear(id)
+	}
+	s.instrs = nil
+
+	out := make([]dapBreakpoint, 0, len(args.Breakpoints))
+	for _, b := range args.Breakpoints {
+		var bp dapBreakpoint
+		if fn, ip, err := parseReference(b.InstructionReference); err != nil {
+			bp.Message = err.Error()
+		} else {
+			bp.ID = s.dbg.Break(fn, ip+b.Offset)
+			bp.Verified = true
+			s.instrs = append(s.instrs, bp.ID)
+		}
+		out = append(out, bp)
+	}
+	return map[string]any{"breakpoints": out}, nil
+}
+
+func (s *session) stackTrace(raw json.RawMessage) (any, error) {
+	var args struct {
+		StartFrame int `json:"startFrame"`
+		Levels     int `json:"levels"`
+	}
+	if err := unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state != sessionStopped {
+		return nil, errNotStopped
+	}
+
+	depth := s.vm.FP()
+	frames := []dapFrame{}
+	for n := args.StartFrame; n < depth; n++ {
+		if args.Levels > 0 && len(frames) == args.Levels {
+			break
+		}
+		fn, ip, _, err := s.vm.Frame(n)
+		if err != nil {
+			break
+		}
+		// A caller's IP already points past its CALL, so its source is the
+		// call site's.
+		if n > 0 {
+			ip--
+		}
+		frame := dapFrame{
+			ID:                          n + 1,
+			Name:                        fmt.Sprintf("func%d", fn),
+			InstructionPointerReference: formatReference(fn, ip),
+		}
+		if d := s.vm.Debug(fn); d != nil {
+			if d.Name != "" {
+				frame.Name = d.Name
+			}
+			if pos, ok := d.Position(ip); ok {
+				frame.Source = &dapSource{Name: filepath.Base(pos.File), Path: pos.File}
+				frame.Line = pos.Line
+				frame.Column = pos.Column
+			}
+		}
+		frames = append(frames, frame)
+	}
+	return map[string]any{"stackFrames": frames, "totalFrames": depth}, nil
+}
+
+func (s *session) scopes(raw json.RawMessage) (any, error) {
+	var args struct {
+		FrameID int `json:"frameId"`
+	}
+	if err := unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state != sessionStopped {
+		return nil, errNotStopped
+	}
+
+	// The interpreter exposes slots of the innermost frame only, so outer
+	// frames see globals alone.
+	var scopes []dapScope
+	if args.FrameID == 1 {
+		scopes = append(scopes,
+			dapScope{Name: "Locals", VariablesReference: s.reference(handle{kind: handleLocals})},
+			dapScope{Name: "Stack", VariablesReference: s.reference(handle{kind: handleStack})},
+		)
+	}
+	scopes = append(scopes, dapScope{Name: "Globals", VariablesReference: s.reference(handle{kind: handleGlobals})})
+	return map[string]any{"scopes": scopes}, nil
+}
+
+func (s *session) variables(raw json.RawMessage) (any, error) {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	if err := unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state != sessionStopped {
+		return nil, errNotStopped
+	}
+	ref := args.VariablesReference
+	if ref <= 0 || ref > len(s.handles) {
+		return nil, fmt.Errorf("unknown variables reference %d", ref)
+	}
+
+	vars := []dapVariable{}
+	h := s.handles[ref-1]
+	switch h.kind {
+	case handleLocals:
+		fn := s.vm.Func()
+		names := s.vm.Debug(fn)
+		for idx := 0; idx < s.slots(fn); idx++ {
+			v, err := s.vm.Local(idx)
+			if err != nil {
+				break
+			}
+			vars = append(vars, s.variable(slotName("local", idx, names.Local(idx)), v))
+		}
+	case handleStack:
+		// The frame's operands sit above its slots.
+		fn, _, bp, err := s.vm.Frame(0)
+		if err != nil {
+			return nil, err
+		}
+		n := s.vm.Len() - bp - s.slots(fn)
+		for k := 0; k < n; k++ {
+			v, err := s.vm.Peek(n - 1 - k)
+			if err != nil {
+				break
+			}
+			vars = append(vars, s.variable(fmt.Sprintf("stack[%d]", k), v))
+		}
+	case handleGlobals:
+		for idx := 0; ; idx++ {
+			v, err := s.vm.Global(idx)
+			if err != nil {
+				break
+			}
+			vars = append(vars, s.variable(slotName("global", idx, s.vm.GlobalName(idx)), v))
+		}
+	case handleHeap:
+		val, err := s.vm.Load(h.addr)
+		if err != nil {
+			return nil, err
+		}
+		switch val := val.(type) {
+		case *types.Array:
+			for k, v := range val.Elems {
+				vars = append(vars, s.variable(fmt.Sprintf("[%d]", k), v))
+			}
+		case *types.Struct:
+			for k, f := range val.Typ.Fields {
+				name := f.Name
+				if name == "" {
+					name = fmt.Sprintf("[%d]", k)
+				}
+				vars = append(vars, s.variable(name, val.Field(k)))
+			}
+		}
+	}
+	return map[string]any{"variables": vars}, nil
+}
+
+func (s *session) step(command string, raw json.RawMessage) error {
+	var args struct {
+		Granularity string `json:"granularity"`
+	}
+	if err := unmarshal(raw, &args); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state != sessionStopped {
+		return errNotStopped
+	}
+
+	stop := s.dbg.Stop()
+	s.from = nil
+	s.reason = "step"
+	switch command {
+	case "continue":
+		s.reason = ""
+		s.dbg.Continue()
+	case "next":
+		s.dbg.Next()
+	case "stepIn":
+		s.dbg.Step()
+	case "stepOut":
+		s.dbg.Finish()
+	}
+	if command != "continue" && command != "stepOut" && args.Granularity != "instruction" && stop.Pos.IsValid() {
+		s.from = &stepPoint{fn: stop.Func, pos: stop.Pos, next: command == "next"}
+	}
+	s.state = sessionRunning
+	s.handles = nil
+	return nil
+}
+
+func (s *session) pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state != sessionRunning {
+		return
+	}
+	s.reason = "pause"
+	s.from = nil
+	s.pausing.Store(true)
+}
+
+// hook runs before every guest instruction. It serializes the Debugger with
+// requests that edit breakpoints while the guest runs.
+func (s *session) hook(i *interp.Interpreter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pausing.Swap(false) {
+		s.dbg.Step()
+	}
+	return s.dbg.Hook(i)
+}
+
+func (s *session) run(ctx context.Context) {
+	defer close(s.done)
+	for {
+		err := s.vm.Run(ctx)
+		if errors.Is(err, ErrStopped) {
+			if s.stopped() {
+				select {
+				case <-s.resume:
+				case <-ctx.Done():
+					s.exit(-1)
+					return
+				}
+			}
+			continue
+		}
+
+		code := 0
+		if ctx.Err() != nil {
+			code = -1
+		} else if err != nil {
+			code = 1
+			s.event("output", map[string]any{"category": "stderr", "output": err.Error() + "\n"})
+		} else if out := formatStack(s.vm); out != "" {
+			s.event("output", map[string]any{"category": "stdout", "output": out + "\n"})
+		}
+		s.exit(code)
+		return
+	}
+}
+
+// stopped records a Debugger stop and reports it, unless the stop only moved
+// within the line a step started from, in which case it steps again and
+// returns false.
+func (s *session) stopped() bool {
+	s.mu.Lock()
+	stop := s.dbg.Stop()
+	if from := s.from; from != nil && stop.Breakpoint == 0 && stop.Func == from.fn && stop.Pos == from.pos {
+		if from.next {
+			s.dbg.Next()
+		} else {
+			s.dbg.Step()
+		}
+		s.mu.Unlock()
+		return false
+	}
+	reason := s.reason
+	body := map[string]any{"threadId": 1, "allThreadsStopped": true}
+	if stop.Breakpoint != 0 {
+		reason = "breakpoint"
+		body["hitBreakpointIds"] = []int{stop.Breakpoint}
+	}
+	if reason == "" {
+		reason = "step"
+	}
+	body["reason"] = reason
+	s.state = sessionStopped
+	s.from = nil
+	s.mu.Unlock()
+
+	s.event("stopped", body)
+	return true
+}
+
+// exit reports the end of the guest. A negative code marks a guest stopped by
+// terminate or disconnect, which has no exit status to report.
+func (s *session) exit(code int) {
+	s.mu.Lock()
+	s.state = sessionExited
+	s.mu.Unlock()
+	if code >= 0 {
+		s.event("exited", map[string]any{"exitCode": code})
+	}
+	s.event("terminated", nil)
+}
+
+// terminate stops the guest and waits for its goroutine to report the end.
+func (s *session) terminate() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	if s.done != nil {
+		<-s.done
+	}
+}
+
+func (s *session) close() {
+	s.terminate()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.vm != nil {
+		_ = s.vm.Close()
+		s.vm = nil
+	}
+}
+
+// locate returns the first instruction whose debug info places it on line of
+// the source at path, searching the module before its function constants.
+func (s *session) locate(path string, line int) (fn, ip int, ok bool) {
+	for _, f := range s.debug {
+		for _, l := range f.debug.Lines {
+			if l.Pos.Line == line && sameFile(path, l.Pos.File) {
+				return f.addr, l.IP, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// slots returns how many params and declared locals the function at addr
+// has; Local reaches past them into the operand stack.
+func (s *session) slots(addr int) int {
+	if addr == 0 {
+		return len(s.prog.Locals)
+	}
+	val, err := s.vm.Load(addr)
+	if err != nil {
+		return 0
+	}
+	fn, ok := val.(*types.Function)
+	if !ok {
+		return 0
+	}
+	return len(fn.Declared())
+}
+
+func (s *session) reference(h handle) int {
+	s.handles = append(s.handles, h)
+	return len(s.handles)
+}
+
+func (s *session) variable(name string, v types.Boxed) dapVariable {
+	out := dapVariable{Name: name, Value: formatValue(v, s.vm), Type: kindName(v.Kind())}
+	if v.Kind() != types.KindRef {
+		return out
+	}
+	val, err := s.vm.Load(v.Ref())
+	if err != nil || val == nil {
+		return out
+	}
+	out.Type = val.Type().String()
+	switch val := val.(type) {
+	case *types.Array:
+		if len(val.Elems) > 0 {
+			out.VariablesReference = s.reference(handle{kind: handleHeap, addr: v.Ref()})
+		}
+	case *types.Struct:
+		if len(val.Typ.Fields) > 0 {
+			out.VariablesReference = s.reference(handle{kind: handleHeap, addr: v.Ref()})
+		}
+	}
+	return out
+}
+
+func (s *session) read() (*dapRequest, error) {
+	tp := textproto.NewReader(s.in)
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		if errors.Is(err, io.EOF) && len(header) == 0 {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	size, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+	}
+	if size > maxMessage {
+		return nil, fmt.Errorf("message of %d bytes exceeds the %d byte limit", size, maxMessage)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(s.in, data); err != nil {
+		return nil, err
+	}
+	var req dapRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+func (s *session) respond(req *dapRequest, body any, err error) {
+	resp := &dapResponse{
+		Type:       "response",
+		RequestSeq: req.Seq,
+		Success:    err == nil,
+		Command:    req.Command,
+		Body:       body,
+	}
+	if err != nil {
+		resp.Message = err.Error()
+		resp.Body = nil
+	}
+	s.write(func(seq int) any {
+		resp.Seq = seq
+		return resp
+	})
+}
+
+func (s *session) event(name string, body any) {
+	s.write(func(seq int) any {
+		return &dapEvent{Seq: seq, Type: "event", Event: name, Body: body}
+	})
+}
+
+func (s *session) write(msg func(seq int) any) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.seq++
+	data, err := json.Marshal(msg(s.seq))
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+func unmarshal(raw json.RawMessage, v any) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// sameFile reports whether a client's source path names file, a path recorded
+// in debug info that may be relative to wherever the program was assembled.
+func sameFile(path, file string) bool {
+	if path == file {
+		return true
+	}
+	path, file = filepath.ToSlash(path), filepath.ToSlash(file)
+	return file != "" && strings.HasSuffix(path, "/"+strings.TrimPrefix(file, "./"))
+}
+
+// formatReference renders an instruction as the "fn:ip" reference stack
+// frames carry and instruction breakpoints take.
+func formatReference(fn, ip int) string {
+	return fmt.Sprintf("%d:%d", fn, ip)
+}
+
+func parseReference(ref string) (fn, ip int, err error) {
+	f, i, ok := strings.Cut(ref, ":")
+	if ok {
+		if fn, err = strconv.Atoi(f); err == nil {
+			if ip, err = strconv.Atoi(i); err == nil {
+				return fn, ip, nil
+			}
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid instruction reference %q", ref)
+}
+
+func slotName(label string, idx int, name string) string {
+	if name != "" {
+		return name
+	}
+	return fmt.Sprintf("%s[%d]", label, idx)
+}
+
+func kindName(k types.Kind) string {
+	switch k {
+	case types.KindI1:
+		return "i1"
+	case types.KindI8:
+		return "i8"
+	case types.KindI32:
+		return "i32"
+	case types.KindI64:
+		return "i64"
+	case types.KindF32:
+		return "f32"
+	case types.KindF64:
+		return "f64"
+	case types.KindRef:
+		return "ref"
+	default:
+		return ""
+	}
+}
+
+func formatValue(v types.Boxed, vm *interp.Interpreter) string {
+	switch v.Kind() {
+	case types.KindI1:
+		return strconv.FormatBool(v.Bool())
+	case types.KindI8:
+		return strconv.Itoa(int(v.I8()))
+	case types.KindI32:
+		return strconv.Itoa(int(v.I32()))
+	case types.KindI64:
+		return strconv.FormatInt(v.I64(), 10)
+	case types.KindF32:
+		return strconv.FormatFloat(float64(v.F32()), 'g', -1, 32)
+	case types.KindF64:
+		return strconv.FormatFloat(v.F64(), 'g', -1, 64)
+	case types.KindRef:
+		val, err := vm.Load(v.Ref())
+		if err != nil || val == nil {
+			return "null"
+		}
+		s := val.String()
+		if i := strings.IndexByte(s, '\n'); i >= 0 {
+			s = s[:i]
+		}
+		return s
+	default:
+		return "<invalid>"
+	}
+}
+
+func formatStack(vm *interp.Interpreter) string {
+	n := vm.Len()
+	parts := make([]string, n)
+	for k := 0; k < n; k++ {
+		v, _ := vm.Peek(k)
+		parts[n-1-k] = formatValue(v, vm)
+	}
+	return strings.Join(parts, " ")
+}
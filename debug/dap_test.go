@@ -0,0 +1,306 @@
+package debug_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	debug "github.com/siyul-park/minivm/debug"
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewServer(t *testing.T) {
+	require.NotNil(t, debug.NewServer(nil))
+}
+
+func TestServer_Serve(t *testing.T) {
+	pos := func(line int) types.Position { return types.Position{File: "main.mvm", Line: line, Column: 1} }
+
+	t.Run("runs to completion", func(t *testing.T) {
+		c := serve(t, program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1), instr.New(instr.I32_CONST, 2), instr.New(instr.I32_ADD),
+		}))
+
+		resp := c.request("initialize", nil)
+		require.Equal(t, true, resp["body"].(map[string]any)["supportsConfigurationDoneRequest"])
+		c.succeed("launch", map[string]any{"program": "main.mvm"})
+		c.event("initialized")
+		c.succeed("configurationDone", nil)
+
+		require.Equal(t, "3\n", c.event("output")["output"])
+		require.Equal(t, float64(0), c.event("exited")["exitCode"])
+		c.event("terminated")
+		c.succeed("disconnect", nil)
+	})
+
+	t.Run("stops at source breakpoints", func(t *testing.T) {
+		c := serve(t, program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1), instr.New(instr.I32_CONST, 2), instr.New(instr.I32_ADD),
+		}, program.WithDebug(&program.Debug{Code: &types.Debug{Name: "main", Lines: []types.Line{
+			{IP: 0, Pos: pos(1)}, {IP: 10, Pos: pos(2)}, {IP: 11},
+		}}})))
+
+		c.succeed("initialize", nil)
+		c.succeed("launch", map[string]any{"program": "main.mvm"})
+		body := c.succeed("setBreakpoints", map[string]any{
+			"source":      map[string]any{"path": "/src/main.mvm"},
+			"breakpoints": []map[string]any{{"line": 2}, {"line": 7}},
+		})
+		bps := body["breakpoints"].([]any)
+		require.Equal(t, true, bps[0].(map[string]any)["verified"])
+		require.Equal(t, false, bps[1].(map[string]any)["verified"])
+		c.succeed("configurationDone", nil)
+
+		stopped := c.event("stopped")
+		require.Equal(t, "breakpoint", stopped["reason"])
+
+		frames := c.succeed("stackTrace", map[string]any{"threadId": 1})["stackFrames"].([]any)
+		require.Len(t, frames, 1)
+		frame := frames[0].(map[string]any)
+		require.Equal(t, "main", frame["name"])
+		require.Equal(t, float64(2), frame["line"])
+		require.Equal(t, "0:10", frame["instructionPointerReference"])
+
+		scopes := c.succeed("scopes", map[string]any{"frameId": frame["id"]})["scopes"].([]any)
+		require.Len(t, scopes, 3)
+		stack := c.variables(scopes[1].(map[string]any)["variablesReference"])
+		require.Equal(t, []string{"stack[0]=1", "stack[1]=2"}, stack)
+
+		c.succeed("continue", map[string]any{"threadId": 1})
+		require.Equal(t, "3\n", c.event("output")["output"])
+		c.event("terminated")
+	})
+
+	t.Run("steps by line", func(t *testing.T) {
+		c := serve(t, program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1), instr.New(instr.I32_CONST, 2), instr.New(instr.I32_ADD), instr.New(instr.DROP),
+		}, program.WithDebug(&program.Debug{Code: &types.Debug{Lines: []types.Line{
+			{IP: 0, Pos: pos(1)}, {IP: 10, Pos: pos(2)},
+		}}})))
+
+		c.succeed("initialize", nil)
+		c.succeed("launch", map[string]any{"program": "main.mvm", "stopOnEntry": true})
+		c.succeed("configurationDone", nil)
+		require.Equal(t, "entry", c.event("stopped")["reason"])
+
+		c.succeed("next", map[string]any{"threadId": 1})
+		require.Equal(t, "step", c.event("stopped")["reason"])
+		frame := c.succeed("stackTrace", nil)["stackFrames"].([]any)[0].(map[string]any)
+		require.Equal(t, "0:10", frame["instructionPointerReference"])
+
+		c.succeed("next", map[string]any{"threadId": 1, "granularity": "instruction"})
+		c.event("stopped")
+		frame = c.succeed("stackTrace", nil)["stackFrames"].([]any)[0].(map[string]any)
+		require.Equal(t, "0:11", frame["instructionPointerReference"])
+	})
+
+	t.Run("inspects locals and heap values", func(t *testing.T) {
+		array := types.NewArray(types.NewArrayType(types.TypeI32), types.BoxI32(4), types.BoxI32(5))
+		code := []instr.Instruction{
+			instr.New(instr.I32_CONST, 6), instr.New(instr.LOCAL_SET, 0), instr.New(instr.CONST_GET, 0), instr.New(instr.NOP),
+		}
+		nop := len(instr.Marshal(code)) - 1
+		c := serve(t, program.New(code, program.WithLocals(types.TypeI32), program.WithConstants(array),
+			program.WithDebug(&program.Debug{Code: &types.Debug{Locals: []string{"x"}}})))
+
+		c.succeed("initialize", nil)
+		c.succeed("launch", map[string]any{"program": "main.mvm"})
+		c.succeed("setInstructionBreakpoints", map[string]any{
+			"breakpoints": []map[string]any{{"instructionReference": fmt.Sprintf("0:%d", nop-1), "offset": 1}},
+		})
+		c.succeed("configurationDone", nil)
+		require.Equal(t, "breakpoint", c.event("stopped")["reason"])
+
+		scopes := c.succeed("scopes", map[string]any{"frameId": 1})["scopes"].([]any)
+		require.Equal(t, []string{"x=6"}, c.variables(scopes[0].(map[string]any)["variablesReference"]))
+
+		vars := c.succeed("variables", map[string]any{"variablesReference": scopes[1].(map[string]any)["variablesReference"]})["variables"].([]any)
+		require.Len(t, vars, 1)
+		ref := vars[0].(map[string]any)["variablesReference"]
+		require.NotZero(t, ref)
+		require.Equal(t, []string{"[0]=4", "[1]=5"}, c.variables(ref))
+	})
+
+	t.Run("pauses a running program", func(t *testing.T) {
+		loop := types.NewFunctionBuilder(nil)
+		top := loop.Label()
+		loop.Bind(top).Br(top)
+		c := serve(t, program.New(instr.Unmarshal(loop.MustBuild().Code)))
+
+		c.succeed("initialize", nil)
+		c.succeed("launch", map[string]any{"program": "main.mvm"})
+		c.succeed("configurationDone", nil)
+		c.succeed("pause", map[string]any{"threadId": 1})
+		require.Equal(t, "pause", c.event("stopped")["reason"])
+
+		c.succeed("terminate", nil)
+		c.event("terminated")
+		c.succeed("disconnect", nil)
+	})
+
+	t.Run("rejects requests out of order", func(t *testing.T) {
+		c := serve(t, program.New(nil))
+
+		resp := c.request("stackTrace", nil)
+		require.Equal(t, false, resp["success"])
+		resp = c.request("launch", nil)
+		require.Equal(t, false, resp["success"])
+		resp = c.request("evaluate", nil)
+		require.Equal(t, false, resp["success"])
+	})
+
+	t.Run("rejects oversized messages", func(t *testing.T) {
+		s := debug.NewServer(func(string) (*program.Program, error) { return program.New(nil), nil })
+		conn := struct {
+			io.Reader
+			io.Writer
+		}{strings.NewReader("Content-Length: 1073741824\r\n\r\n{}"), io.Discard}
+
+		err := s.Serve(context.Background(), conn)
+		require.ErrorContains(t, err, "message of 1073741824 bytes exceeds")
+	})
+
+	t.Run("stops when the context is done", func(t *testing.T) {
+		s := debug.NewServer(func(string) (*program.Program, error) { return program.New(nil), nil })
+		client, server := net.Pipe()
+		defer client.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- s.Serve(ctx, server) }()
+		cancel()
+
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("server did not stop")
+		}
+	})
+}
+
+type dapClient struct {
+	t      *testing.T
+	conn   net.Conn
+	seq    int
+	msgs   chan map[string]any
+	events []map[string]any
+}
+
+// serve starts a Server over an in-memory connection that launches prog for
+// any program path.
+func serve(t *testing.T, prog *program.Program) *dapClient {
+	t.Helper()
+	client, server := net.Pipe()
+	s := debug.NewServer(func(string) (*program.Program, error) { return prog, nil })
+
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(context.Background(), server) }()
+
+	c := &dapClient{t: t, conn: client, msgs: make(chan map[string]any, 64)}
+	go func() {
+		defer close(c.msgs)
+		r := bufio.NewReader(client)
+		for {
+			header, err := textproto.NewReader(r).ReadMIMEHeader()
+			if err != nil {
+				return
+			}
+			size, _ := strconv.Atoi(header.Get("Content-Length"))
+			data := make([]byte, size)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return
+			}
+			var msg map[string]any
+			if err := json.Unmarshal(data, &msg); err != nil {
+				return
+			}
+			c.msgs <- msg
+		}
+	}()
+
+	t.Cleanup(func() {
+		_ = client.Close()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Error("server did not stop")
+		}
+	})
+	return c
+}
+
+func (c *dapClient) request(command string, args any) map[string]any {
+	c.t.Helper()
+	c.seq++
+	data, err := json.Marshal(map[string]any{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	require.NoError(c.t, err)
+	_, err = fmt.Fprintf(c.conn, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	require.NoError(c.t, err)
+
+	for {
+		msg := c.next()
+		if msg["type"] == "response" && msg["request_seq"] == float64(c.seq) {
+			return msg
+		}
+		c.events = append(c.events, msg)
+	}
+}
+
+func (c *dapClient) succeed(command string, args any) map[string]any {
+	c.t.Helper()
+	resp := c.request(command, args)
+	require.Equal(c.t, true, resp["success"], "%s: %v", command, resp["message"])
+	body, _ := resp["body"].(map[string]any)
+	return body
+}
+
+func (c *dapClient) event(name string) map[string]any {
+	c.t.Helper()
+	for {
+		var msg map[string]any
+		if len(c.events) > 0 {
+			msg, c.events = c.events[0], c.events[1:]
+		} else {
+			msg = c.next()
+		}
+		if msg["type"] == "event" && msg["event"] == name {
+			body, _ := msg["body"].(map[string]any)
+			return body
+		}
+	}
+}
+
+// variables lists the children of ref as "name=value" pairs.
+func (c *dapClient) variables(ref any) []string {
+	c.t.Helper()
+	var out []string
+	for _, v := range c.succeed("variables", map[string]any{"variablesReference": ref})["variables"].([]any) {
+		v := v.(map[string]any)
+		out = append(out, fmt.Sprintf("%s=%s", v["name"], v["value"]))
+	}
+	return out
+}
+
+func (c *dapClient) next() map[string]any {
+	c.t.Helper()
+	select {
+	case msg, ok := <-c.msgs:
+		require.True(c.t, ok, "connection closed")
+		return msg
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timed out waiting for a message")
+		return nil
+	}
+}
@@ -5,12 +5,22 @@ import (
 	"sort"
 
 	"github.com/siyul-park/minivm/interp"
+	"github.com/siyul-park/minivm/types"
 )
 
+// Stop describes where a debugger paused. Name and Pos come from the program's
+// debug info and stay empty when it has none for the stopped function. A stop
+// on a watchpoint happens before the write, with Old the value it replaces
+// (zero when unreadable) and New the value it stores.
 type Stop struct {
 	Func       int
 	IP         int
 	Breakpoint int
+	Watchpoint int
+	Old        types.Boxed
+	New        types.Boxed
+	Name       string
+	Pos        types.Position
 }
 
 type Breakpoint struct {
@@ -26,6 +36,7 @@ type Debugger struct {
 	mode debugMode
 
 	breakpoints map[int]*Breakpoint
+	watchpoints map[int]*Watchpoint
 
 	stop       *Stop
 	skip       *skipPoint
@@ -69,19 +80,26 @@ func (d *Debugger) Hook(i *interp.Interpreter) error {
 
 	if bp := d.breakpoint(i, fn, ip); bp != nil {
 		bp.Hits++
-		return d.pause(fn, ip, fp, bp.ID)
+		return d.pause(i, fn, ip, fp, bp.ID)
+	}
+
+	if wp, w := d.watchpoint(i, fn); wp != nil {
+		wp.Hits++
+		err := d.pause(i, fn, ip, fp, 0)
+		d.stop.Watchpoint, d.stop.Old, d.stop.New = wp.ID, w.old, w.new
+		return err
 	}
 
 	switch d.mode {
 	case debugStep:
-		return d.pause(fn, ip, fp, 0)
+		return d.pause(i, fn, ip, fp, 0)
 	case debugNext:
 		if fp <= d.depth {
-			return d.pause(fn, ip, fp, 0)
+			return d.pause(i, fn, ip, fp, 0)
 		}
 	case debugFinish:
 		if fp < d.depth {
-			return d.pause(fn, ip, fp, 0)
+			return d.pause(i, fn, ip, fp, 0)
 		}
 	}
 	return nil
@@ -136,21 +154,28 @@ func (d *Debugger) BreakIf(fn, ip int, cond func(*interp.Interpreter) bool) int
 
 func (d *Debugger) Clear(id int) bool {
 	d.init()
-	if _, ok := d.breakpoints[id]; !ok {
-		return false
+	if _, ok := d.breakpoints[id]; ok {
+		delete(d.breakpoints, id)
+		return true
+	}
+	if _, ok := d.watchpoints[id]; ok {
+		delete(d.watchpoints, id)
+		return true
 	}
-	delete(d.breakpoints, id)
-	return true
+	return false
 }
 
 func (d *Debugger) Enable(id int, enabled bool) bool {
 	d.init()
-	bp := d.breakpoints[id]
-	if bp == nil {
-		return false
+	if bp := d.breakpoints[id]; bp != nil {
+		bp.Enabled = enabled
+		return true
 	}
-	bp.Enabled = enabled
-	return true
+	if wp := d.watchpoints[id]; wp != nil {
+		wp.Enabled = enabled
+		return true
+	}
+	return false
 }
 
 func (d *Debugger) Breakpoints() []Breakpoint {
@@ -182,12 +207,16 @@ func (d *Debugger) breakpoint(i *interp.Interpreter, fn, ip int) *Breakpoint {
 	return hit
 }
 
-func (d *Debugger) pause(fn, ip, depth, bp int) error {
+func (d *Debugger) pause(i *interp.Interpreter, fn, ip, depth, bp int) error {
 	d.stop = &Stop{
 		Func:       fn,
 		IP:         ip,
 		Breakpoint: bp,
 	}
+	if debug := i.Debug(fn); debug != nil {
+		d.stop.Name = debug.Name
+		d.stop.Pos, _ = debug.Position(ip)
+	}
 	d.pauseDepth = depth
 	d.mode = debugContinue
 	return ErrStopped
@@ -216,6 +245,9 @@ func (d *Debugger) init() {
 	if d.breakpoints == nil {
 		d.breakpoints = make(map[int]*Breakpoint)
 	}
+	if d.watchpoints == nil {
+		d.watchpoints = make(map[int]*Watchpoint)
+	}
 	if d.next == 0 {
 		d.next = 1
 	}
@@ -38,6 +38,21 @@ func TestDebugger_Stop(t *testing.T) {
 
 	require.ErrorIs(t, vm.Run(context.Background()), debug.ErrStopped)
 	require.Equal(t, debug.Stop{Func: 0, IP: 0, Breakpoint: id}, dbg.Stop())
+
+	t.Run("reports debug info", func(t *testing.T) {
+		dbg := debug.NewDebugger()
+		id := dbg.Break(0, 1)
+		pos := types.Position{File: "main.mvm", Line: 3, Column: 2}
+		prog := program.New(
+			[]instr.Instruction{instr.New(instr.NOP), instr.New(instr.NOP)},
+			program.WithDebug(&program.Debug{Code: &types.Debug{Name: "main", Lines: []types.Line{{IP: 1, Pos: pos}}}}),
+		)
+		vm := interp.New(prog, interp.WithHook(dbg.Hook), interp.WithTick(1), interp.WithThreshold(-1))
+		defer vm.Close()
+
+		require.ErrorIs(t, vm.Run(context.Background()), debug.ErrStopped)
+		require.Equal(t, debug.Stop{Func: 0, IP: 1, Breakpoint: id, Name: "main", Pos: pos}, dbg.Stop())
+	})
 }
 
 func TestDebugger_Continue(t *testing.T) {
@@ -164,6 +179,10 @@ func TestDebugger_Clear(t *testing.T) {
 	require.True(t, dbg.Clear(id))
 	require.False(t, dbg.Clear(id))
 	require.Empty(t, dbg.Breakpoints())
+
+	id = dbg.Watch(debug.Watchpoint{Kind: debug.WatchGlobal})
+	require.True(t, dbg.Clear(id))
+	require.Empty(t, dbg.Watchpoints())
 }
 
 func TestDebugger_Enable(t *testing.T) {
@@ -174,6 +193,134 @@ func TestDebugger_Enable(t *testing.T) {
 	require.False(t, dbg.Enable(99, false))
 	require.True(t, dbg.Enable(id, true))
 	require.True(t, dbg.Breakpoints()[0].Enabled)
+
+	id = dbg.Watch(debug.Watchpoint{Kind: debug.WatchGlobal})
+	require.True(t, dbg.Enable(id, false))
+	require.False(t, dbg.Watchpoints()[0].Enabled)
+}
+
+func TestDebugger_Watch(t *testing.T) {
+	// global.set 0 <- 7 at 5, global.set 0 <- 7 at 13, global.tee 0 <- 8 at 21.
+	globals := program.New([]instr.Instruction{
+		instr.New(instr.I32_CONST, 7), instr.New(instr.GLOBAL_SET, 0),
+		instr.New(instr.I32_CONST, 7), instr.New(instr.GLOBAL_SET, 0),
+		instr.New(instr.I32_CONST, 8), instr.New(instr.GLOBAL_TEE, 0), instr.New(instr.DROP),
+	}, program.WithGlobals(types.TypeI32))
+
+	run := func(t *testing.T, prog *program.Program, w debug.Watchpoint) (*debug.Debugger, *interp.Interpreter) {
+		dbg := debug.NewDebugger()
+		dbg.Watch(w)
+		vm := interp.New(prog, interp.WithHook(dbg.Hook), interp.WithTick(1), interp.WithThreshold(-1))
+		t.Cleanup(func() { _ = vm.Close() })
+		return dbg, vm
+	}
+
+	t.Run("stops before every write", func(t *testing.T) {
+		dbg, vm := run(t, globals, debug.Watchpoint{Kind: debug.WatchGlobal, Index: 0})
+
+		require.ErrorIs(t, vm.Run(context.Background()), debug.ErrStopped)
+		stop := dbg.Stop()
+		require.Equal(t, 5, stop.IP)
+		require.Equal(t, 1, stop.Watchpoint)
+		require.Equal(t, types.BoxI32(0), stop.Old)
+		require.Equal(t, types.BoxI32(7), stop.New)
+		v, err := vm.Global(0)
+		require.NoError(t, err)
+		require.Equal(t, types.BoxI32(0), v)
+
+		dbg.Continue()
+		require.ErrorIs(t, vm.Run(context.Background()), debug.ErrStopped)
+		require.Equal(t, 13, dbg.Stop().IP)
+		dbg.Continue()
+		require.ErrorIs(t, vm.Run(context.Background()), debug.ErrStopped)
+		require.Equal(t, 21, dbg.Stop().IP)
+		dbg.Continue()
+		require.NoError(t, vm.Run(context.Background()))
+		require.Equal(t, uint64(3), dbg.Watchpoints()[0].Hits)
+	})
+
+	t.Run("skips writes that keep the value", func(t *testing.T) {
+		dbg, vm := run(t, globals, debug.Watchpoint{Kind: debug.WatchGlobal, Index: 0, Changed: true})
+
+		require.ErrorIs(t, vm.Run(context.Background()), debug.ErrStopped)
+		require.Equal(t, 5, dbg.Stop().IP)
+		dbg.Continue()
+		require.ErrorIs(t, vm.Run(context.Background()), debug.ErrStopped)
+		require.Equal(t, 21, dbg.Stop().IP)
+		require.Equal(t, types.BoxI32(7), dbg.Stop().Old)
+	})
+
+	t.Run("filters by predicate", func(t *testing.T) {
+		dbg, vm := run(t, globals, debug.Watchpoint{Kind: debug.WatchGlobal, Index: 0, Cond: func(_, v types.Boxed) bool {
+			return v.I32() == 8
+		}})
+
+		require.ErrorIs(t, vm.Run(context.Background()), debug.ErrStopped)
+		require.Equal(t, 21, dbg.Stop().IP)
+	})
+
+	t.Run("ignores other slots", func(t *testing.T) {
+		_, vm := run(t, globals, debug.Watchpoint{Kind: debug.WatchGlobal, Index: 1})
+		require.NoError(t, vm.Run(context.Background()))
+	})
+
+	t.Run("watches locals of a function", func(t *testing.T) {
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 6), instr.New(instr.LOCAL_SET, 0),
+		}, program.WithLocals(types.TypeI32))
+
+		dbg, vm := run(t, prog, debug.Watchpoint{Kind: debug.WatchLocal, Func: 0, Index: 0})
+		require.ErrorIs(t, vm.Run(context.Background()), debug.ErrStopped)
+		require.Equal(t, types.BoxI32(6), dbg.Stop().New)
+
+		_, vm = run(t, prog, debug.Watchpoint{Kind: debug.WatchLocal, Func: 1, Index: 0})
+		require.NoError(t, vm.Run(context.Background()))
+	})
+
+	t.Run("watches heap elements", func(t *testing.T) {
+		array := types.NewArray(types.NewArrayType(types.TypeI32), types.BoxI32(4), types.BoxI32(5))
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.CONST_GET, 0), instr.New(instr.I32_CONST, 1), instr.New(instr.I32_CONST, 9), instr.New(instr.ARRAY_SET),
+		}, program.WithConstants(array))
+
+		for _, tt := range []struct {
+			index int
+			stop  bool
+		}{{index: 1, stop: true}, {index: -1, stop: true}, {index: 0}} {
+			dbg := debug.NewDebugger()
+			vm := interp.New(prog, interp.WithHook(dbg.Hook), interp.WithTick(1), interp.WithThreshold(-1))
+			ref, err := vm.Const(0)
+			require.NoError(t, err)
+			dbg.Watch(debug.Watchpoint{Kind: debug.WatchHeap, Addr: ref.Ref(), Index: tt.index})
+
+			err = vm.Run(context.Background())
+			if !tt.stop {
+				require.NoError(t, err, "index=%d", tt.index)
+				require.NoError(t, vm.Close())
+				continue
+			}
+			require.ErrorIs(t, err, debug.ErrStopped, "index=%d", tt.index)
+			require.Equal(t, types.BoxI32(5), dbg.Stop().Old)
+			require.Equal(t, types.BoxI32(9), dbg.Stop().New)
+			require.NoError(t, vm.Close())
+		}
+	})
+}
+
+func TestDebugger_Watchpoints(t *testing.T) {
+	var dbg debug.Debugger
+	first := dbg.Watch(debug.Watchpoint{Kind: debug.WatchGlobal, Index: 2, Hits: 5})
+	bp := dbg.Break(0, 0)
+	second := dbg.Watch(debug.Watchpoint{Kind: debug.WatchHeap, Addr: 3, Index: -1})
+	require.NotEqual(t, first, bp)
+	watchpoints := dbg.Watchpoints()
+	require.Equal(t, []debug.Watchpoint{
+		{ID: first, Kind: debug.WatchGlobal, Index: 2, Enabled: true},
+		{ID: second, Kind: debug.WatchHeap, Addr: 3, Index: -1, Enabled: true},
+	}, watchpoints)
+
+	watchpoints[0].Enabled = false
+	require.True(t, dbg.Watchpoints()[0].Enabled)
 }
 
 func TestDebugger_Breakpoints(t *testing.T) {
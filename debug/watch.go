@@ -0,0 +1,269 @@
+package debug
+
+import (
+	"sort"
+
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/interp"
+	"github.com/siyul-park/minivm/types"
+)
+
+// WatchKind selects the storage a Watchpoint observes.
+type WatchKind int
+
+const (
+	// WatchGlobal watches global slot Index.
+	WatchGlobal WatchKind = iota
+	// WatchLocal watches slot Index of every frame running the function at
+	// Func, numbered like Function.Declared.
+	WatchLocal
+	// WatchHeap watches the object at heap address Addr: array element or
+	// struct field Index, or every element when Index is negative. A map
+	// matches integer keys against Index, and a ref cell has only the whole.
+	WatchHeap
+)
+
+// Watchpoint stops a Debugger before an instruction writes the location it
+// watches. With Changed set it stops only when the write changes the stored
+// value, and with Cond only when Cond accepts the old and new values.
+type Watchpoint struct {
+	ID      int
+	Kind    WatchKind
+	Func    int
+	Addr    int
+	Index   int
+	Changed bool
+	Enabled bool
+	Hits    uint64
+	Cond    func(old, new types.Boxed) bool
+}
+
+// write is the location and values of the store the current instruction is
+// about to make.
+type write struct {
+	kind  WatchKind
+	fn    int
+	addr  int
+	index int
+	key   bool
+	old   types.Boxed
+	known bool
+	new   types.Boxed
+}
+
+// Watch installs w, enabled, and returns its ID. IDs are shared with
+// breakpoints, so Clear and Enable take either.
+func (d *Debugger) Watch(w Watchpoint) int {
+	d.init()
+	w.ID = d.next
+	w.Enabled = true
+	w.Hits = 0
+	d.next++
+	d.watchpoints[w.ID] = &w
+	return w.ID
+}
+
+func (d *Debugger) Watchpoints() []Watchpoint {
+	d.init()
+	out := make([]Watchpoint, 0, len(d.watchpoints))
+	for _, w := range d.watchpoints {
+		out = append(out, *w)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+func (d *Debugger) watchpoint(i *interp.Interpreter, fn int) (*Watchpoint, *write) {
+	if len(d.watchpoints) == 0 {
+		return nil, nil
+	}
+	w, ok := store(i, fn)
+	if !ok {
+		return nil, nil
+	}
+	var hit *Watchpoint
+	for _, wp := range d.watchpoints {
+		if !wp.Enabled || !wp.matches(w) {
+			continue
+		}
+		if wp.Changed && w.known && w.old == w.new {
+			continue
+		}
+		if wp.Cond != nil && !wp.Cond(w.old, w.new) {
+			continue
+		}
+		if hit == nil || wp.ID < hit.ID {
+			hit = wp
+		}
+	}
+	return hit, w
+}
+
+func (wp *Watchpoint) matches(w *write) bool {
+	if wp.Kind != w.kind {
+		return false
+	}
+	switch w.kind {
+	case WatchGlobal:
+		return wp.Index == w.index
+	case WatchLocal:
+		return wp.Func == w.fn && wp.Index == w.index
+	default:
+		return wp.Addr == w.addr && (wp.Index < 0 || w.key && wp.Index == w.index)
+	}
+}
+
+// store decodes the write the current instruction makes, reading the value
+// it replaces when that can be done without touching reference counts.
+func store(i *interp.Interpreter, fn int) (*write, bool) {
+	inst, err := i.Instruction()
+	if err != nil {
+		return nil, false
+	}
+	val, err := i.Peek(0)
+	if err != nil {
+		return nil, false
+	}
+	w := &write{new: val}
+
+	switch inst.Opcode() {
+	case instr.GLOBAL_SET, instr.GLOBAL_TEE:
+		w.kind, w.index = WatchGlobal, int(inst.Operand(0))
+		w.old, err = i.Global(w.index)
+		w.known = err == nil
+	case instr.LOCAL_SET, instr.LOCAL_TEE:
+		w.kind, w.fn, w.index = WatchLocal, fn, int(inst.Operand(0))
+		w.old, err = i.Local(w.index)
+		w.known = err == nil
+	case instr.REF_SET:
+		ref, err := i.Peek(1)
+		if err != nil || ref.Kind() != types.KindRef {
+			return nil, false
+		}
+		w.kind, w.addr = WatchHeap, ref.Ref()
+		if obj, err := i.Load(w.addr); err == nil {
+			w.old, w.known = box(obj)
+		}
+	case instr.ARRAY_SET, instr.STRUCT_SET, instr.MAP_SET:
+		key, err := i.Peek(1)
+		if err != nil {
+			return nil, false
+		}
+		ref, err := i.Peek(2)
+		if err != nil || ref.Kind() != types.KindRef {
+			return nil, false
+		}
+		w.kind, w.addr = WatchHeap, ref.Ref()
+		switch key.Kind() {
+		case types.KindI1, types.KindI8, types.KindI32:
+			w.key, w.index = true, int(key.I32())
+		}
+		if obj, err := i.Load(w.addr); err == nil {
+			w.old, w.known = element(i, obj, key)
+		}
+	default:
+		return nil, false
+	}
+	return w, true
+}
+
+// element reads key of a container without retaining what it finds.
+func element(i *interp.Interpreter, obj types.Value, key types.Boxed) (types.Boxed, bool) {
+	at := int(key.I32())
+	switch obj := obj.(type) {
+	case *types.Array:
+		if at < 0 || at >= len(obj.Elems) {
+			return 0, false
+		}
+		return obj.Elems[at], true
+	case types.TypedArray[bool]:
+		return index(obj, at, types.BoxI1)
+	case types.TypedArray[int8]:
+		return index(obj, at, types.BoxI8)
+	case types.TypedArray[int32]:
+		return index(obj, at, types.BoxI32)
+	case types.TypedArray[int64]:
+		if at < 0 || at >= len(obj) || !types.IsBoxable(obj[at]) {
+			return 0, false
+		}
+		return types.BoxI64(obj[at]), true
+	case types.TypedArray[float32]:
+		return index(obj, at, types.BoxF32)
+	case types.TypedArray[float64]:
+		return index(obj, at, types.BoxF64)
+	case *types.Struct:
+		if at < 0 || at >= len(obj.Typ.Fields) {
+			return 0, false
+		}
+		return obj.Field(at), true
+	case *types.TypedMap[bool]:
+		return obj.Get(key.Bool())
+	case *types.TypedMap[int8]:
+		return obj.Get(key.I8())
+	case *types.TypedMap[int32]:
+		return obj.Get(key.I32())
+	case *types.TypedMap[int64]:
+		if key.Kind() != types.KindI64 {
+			return 0, false
+		}
+		return obj.Get(key.I64())
+	case *types.TypedMap[float32]:
+		return obj.Get(key.F32())
+	case *types.TypedMap[float64]:
+		return obj.Get(key.F64())
+	case *types.TypedMap[string]:
+		s, ok := text(i, key)
+		if !ok {
+			return 0, false
+		}
+		return obj.Get(s)
+	case *types.Map:
+		return i.Lookup(obj, key)
+	default:
+		return 0, false
+	}
+}
+
+func index[T any](arr []T, at int, box func(T) types.Boxed) (types.Boxed, bool) {
+	if at < 0 || at >= len(arr) {
+		return 0, false
+	}
+	return box(arr[at]), true
+}
+
+func text(i *interp.Interpreter, key types.Boxed) (string, bool) {
+	if key.Kind() != types.KindRef {
+		return "", false
+	}
+	val, err := i.Load(key.Ref())
+	if err != nil {
+		return "", false
+	}
+	s, ok := val.(types.String)
+	return string(s), ok
+}
+
+// box returns the boxed form of a ref cell's primitive payload.
+func box(v types.Value) (types.Boxed, bool) {
+	switch v := v.(type) {
+	case types.I1:
+		return types.BoxI1(bool(v)), true
+	case types.I8:
+		return types.BoxI8(int8(v)), true
+	case types.I32:
+		return types.BoxI32(int32(v)), true
+	case types.I64:
+		if !types.IsBoxable(int64(v)) {
+			return 0, false
+		}
+		return types.BoxI64(int64(v)), true
+	case types.F32:
+		return types.BoxF32(float32(v)), true
+	case types.F64:
+		return types.BoxF64(float64(v)), true
+	default:
+		return 0, false
+	}
+}
@@ -165,6 +165,12 @@ func (b *Builder) resolve(pos []int, l Label) (int, error) {
 
 func (b *Builder) branch(op Opcode, l Label) *Builder {
 	b.instrs = append(b.instrs, New(op, 0))
-	b.fixups = append(b.fixups, fixup{branch: len(b.instrs) - 1, operand: 0, label: l})
+	b.patch(0, l)
 	return b
 }
+
+// patch records that operand of the most recently appended instruction is a
+// branch offset to l, resolved by Assemble.
+func (b *Builder) patch(operand int, l Label) {
+	b.fixups = append(b.fixups, fixup{branch: len(b.instrs) - 1, operand: operand, label: l})
+}
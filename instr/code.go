@@ -52,6 +52,56 @@ func Format(code []byte) string {
 	return sb.String()
 }
 
+// FormatLabels is like Format but names every branch target with a label,
+// "L" followed by the target's byte offset, and writes branch operands as
+// those names. ParseAll reads the listing back into the same code, and the
+// branches stay correct when instructions are inserted into or removed from
+// it. A target that does not fall on an instruction boundary keeps its
+// numeric offset.
+func FormatLabels(code []byte) string {
+	instrs := Unmarshal(code)
+	boundary := make(map[int]bool, len(instrs)+1)
+	var targets []int
+	ip := 0
+	for _, inst := range instrs {
+		boundary[ip] = true
+		targets = append(targets, Targets(code, ip)...)
+		ip += len(inst)
+	}
+	boundary[ip] = true
+
+	labels := map[int]bool{}
+	for _, target := range targets {
+		if boundary[target] {
+			labels[target] = true
+		}
+	}
+
+	var sb strings.Builder
+	ip = 0
+	for _, inst := range instrs {
+		if labels[ip] {
+			sb.WriteString(fmt.Sprintf("L%04d:\n", ip))
+		}
+		fields := strings.Fields(inst.String())
+		first := 1
+		if inst.Opcode() == BR_TABLE {
+			first = 2
+		}
+		for i, target := range Targets(code, ip) {
+			if labels[target] {
+				fields[first+i] = fmt.Sprintf("L%04d", target)
+			}
+		}
+		sb.WriteString(fmt.Sprintf("%04d:\t%s\n", ip, strings.Join(fields, " ")))
+		ip += len(inst)
+	}
+	if labels[ip] {
+		sb.WriteString(fmt.Sprintf("L%04d:\n", ip))
+	}
+	return sb.String()
+}
+
 func Unmarshal(code []byte) []Instruction {
 	var instrs []Instruction
 	for ip := 0; ip < len(code); {
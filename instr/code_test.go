@@ -1,6 +1,7 @@
 package instr_test
 
 import (
+	"strings"
 	"testing"
 
 	instr "github.com/siyul-park/minivm/instr"
@@ -25,6 +26,48 @@ func TestFormat(t *testing.T) {
 	require.Equal(t, "0000:\ti32.const 0x00000001\n0005:\ti32.const 0x00000002\n0010:\ti32.add\n", assembly)
 }
 
+func TestFormatLabels(t *testing.T) {
+	t.Run("names branch targets", func(t *testing.T) {
+		b := instr.NewBuilder()
+		loop, end := b.Label(), b.Label()
+		b.Bind(loop).Emit(instr.I32_CONST, 0).BrIf(end).Br(loop).Bind(end)
+		instrs, err := b.Assemble()
+		require.NoError(t, err)
+
+		assembly := instr.FormatLabels(instr.Marshal(instrs))
+		require.Equal(t, "L0000:\n0000:\ti32.const 0x00000000\n0005:\tbr_if L0011\n0008:\tbr L0000\nL0011:\n", assembly)
+	})
+
+	t.Run("names branch table targets", func(t *testing.T) {
+		b := instr.NewBuilder()
+		first, def := b.Label(), b.Label()
+		b.BrTable(def, first).Bind(first).Emit(instr.NOP).Bind(def).Emit(instr.RETURN)
+		instrs, err := b.Assemble()
+		require.NoError(t, err)
+
+		assembly := instr.FormatLabels(instr.Marshal(instrs))
+		require.Equal(t, "0000:\tbr_table 0x01 L0006 L0007\nL0006:\n0006:\tnop\nL0007:\n0007:\treturn\n", assembly)
+	})
+
+	t.Run("keeps offsets inside an instruction", func(t *testing.T) {
+		code := instr.Marshal([]instr.Instruction{instr.New(instr.BR, 1), instr.New(instr.I32_CONST, 0)})
+
+		require.Equal(t, "0000:\tbr 0x0001\n0003:\ti32.const 0x00000000\n", instr.FormatLabels(code))
+	})
+
+	t.Run("round-trip with ParseAll", func(t *testing.T) {
+		b := instr.NewBuilder()
+		loop, end := b.Label(), b.Label()
+		b.Bind(loop).Emit(instr.I32_CONST, 1).BrIf(end).Br(loop).Bind(end).Emit(instr.RETURN)
+		original, err := b.Assemble()
+		require.NoError(t, err)
+
+		got, err := instr.ParseAll(strings.NewReader(instr.FormatLabels(instr.Marshal(original))))
+		require.NoError(t, err)
+		require.Equal(t, original, got)
+	})
+}
+
 func TestTargets(t *testing.T) {
 	t.Run("branch", func(t *testing.T) {
 		b := instr.NewBuilder()
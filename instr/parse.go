@@ -5,10 +5,27 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"slices"
 	"strconv"
 	"strings"
 )
 
+// assembler turns assembly source into instructions, resolving label and
+// symbol operands through a Builder.
+type assembler struct {
+	builder *Builder
+	symbols map[string]int
+	labels  map[string]*namedLabel
+}
+
+// namedLabel tracks a label defined in source: its builder handle, whether a definition has
+// been seen, and the line of that definition or of its first use.
+type namedLabel struct {
+	id    Label
+	bound bool
+	line  int
+}
+
 const maxParseLineBytes = 1 << 20 // 1 MiB
 
 var mnemonicMap map[string]Opcode
@@ -91,19 +108,28 @@ func ParseU32(code []byte, offset int) int {
 // ParseAll reads from r line by line and parses each non-empty line as an
 // assembly instruction. It returns the first error encountered with the line
 // number for context.
+//
+// A line may open with a label definition, "name:", which binds name to the
+// next instruction the way Builder.Bind does. br, br_if, and br_table accept a
+// label name wherever they take a branch offset, before or after the label is
+// defined; ParseAll resolves every such operand to its relative offset.
 func ParseAll(r io.Reader) ([]Instruction, error) {
-	var instrs []Instruction
+	return ParseSymbols(r, nil)
+}
+
+// ParseSymbols is like ParseAll but also resolves "$name" operands through
+// symbols, which maps each name to a constant pool index. const.get accepts
+// "$name" as its operand, and "call $name" and "return_call $name" expand to a
+// const.get of the named constant followed by the call.
+func ParseSymbols(r io.Reader, symbols map[string]int) ([]Instruction, error) {
+	a := &assembler{builder: NewBuilder(), symbols: symbols, labels: map[string]*namedLabel{}}
 	scanner := bufio.NewScanner(r)
 	scanner.Buffer(make([]byte, 0, 64*1024), maxParseLineBytes)
 	line := 1
 	for ; scanner.Scan(); line++ {
-		inst, err := Parse(scanner.Text())
-		if err != nil {
+		if err := a.line(scanner.Text(), line); err != nil {
 			return nil, fmt.Errorf("line %d: %w", line, err)
 		}
-		if inst != nil {
-			instrs = append(instrs, inst)
-		}
 	}
 	if err := scanner.Err(); err != nil {
 		if strings.Contains(err.Error(), "token too long") {
@@ -111,7 +137,7 @@ func ParseAll(r io.Reader) ([]Instruction, error) {
 		}
 		return nil, fmt.Errorf("line %d: %w", line, err)
 	}
-	return instrs, nil
+	return a.assemble()
 }
 
 // Parse parses a single assembly instruction line.
@@ -119,22 +145,7 @@ func ParseAll(r io.Reader) ([]Instruction, error) {
 // produced by Format ("0000:  i32.const 0x2a"). Returns nil, nil for
 // blank lines.
 func Parse(line string) (Instruction, error) {
-	// Strip optional offset prefix of the form "NNNN:" or "NNNN:\t"
-	if idx := strings.IndexByte(line, ':'); idx >= 0 {
-		prefix := strings.TrimSpace(line[:idx])
-		allDigits := len(prefix) > 0
-		for _, c := range prefix {
-			if c < '0' || c > '9' {
-				allDigits = false
-				break
-			}
-		}
-		if allDigits {
-			line = line[idx+1:]
-		}
-	}
-
-	line = strings.TrimSpace(line)
+	line = strings.TrimSpace(trimOffset(line))
 	if line == "" {
 		return nil, nil
 	}
@@ -156,6 +167,117 @@ func Parse(line string) (Instruction, error) {
 	return New(op, operands...), nil
 }
 
+// line assembles one source line: an optional label definition followed by an
+// optional instruction.
+func (a *assembler) line(text string, line int) error {
+	text = strings.TrimSpace(trimOffset(text))
+	if name, rest, ok := strings.Cut(text, ":"); ok && isLabel(name) {
+		l := a.label(name, line)
+		if l.bound {
+			return fmt.Errorf("label %q already defined on line %d", name, l.line)
+		}
+		a.builder.Bind(l.id)
+		l.bound, l.line = true, line
+		text = strings.TrimSpace(rest)
+	}
+	if text == "" {
+		return nil
+	}
+
+	fields := strings.Fields(text)
+	op, ok := mnemonicMap[fields[0]]
+	if !ok {
+		return fmt.Errorf("unknown mnemonic: %q", fields[0])
+	}
+
+	switch op {
+	case CALL, RETURN_CALL:
+		if len(fields) == 2 && strings.HasPrefix(fields[1], "$") {
+			idx, err := a.symbol(fields[1])
+			if err != nil {
+				return fmt.Errorf("%s: %w", fields[0], err)
+			}
+			a.builder.Emit(CONST_GET, uint64(idx)).Emit(op)
+			return nil
+		}
+	case CONST_GET:
+		if len(fields) == 2 && strings.HasPrefix(fields[1], "$") {
+			idx, err := a.symbol(fields[1])
+			if err != nil {
+				return fmt.Errorf("%s: %w", fields[0], err)
+			}
+			a.builder.Emit(CONST_GET, uint64(idx))
+			return nil
+		}
+	case BR, BR_IF, BR_TABLE:
+		// Operand i of a branch is field i+1; the br_table count is never a
+		// label.
+		first := 1
+		if op == BR_TABLE {
+			first = 2
+		}
+		var targets []fixup
+		for i := first; i < len(fields); i++ {
+			if !isLabel(fields[i]) {
+				continue
+			}
+			targets = append(targets, fixup{operand: i - 1, label: a.label(fields[i], line).id})
+			fields[i] = "0"
+		}
+		inst, err := Parse(strings.Join(fields, " "))
+		if err != nil {
+			return err
+		}
+		a.builder.Append(inst)
+		for _, fx := range targets {
+			a.builder.patch(fx.operand, fx.label)
+		}
+		return nil
+	}
+
+	inst, err := Parse(text)
+	if err != nil {
+		return err
+	}
+	a.builder.Append(inst)
+	return nil
+}
+
+// label returns the label named name, allocating it on first use.
+func (a *assembler) label(name string, line int) *namedLabel {
+	l, ok := a.labels[name]
+	if !ok {
+		l = &namedLabel{id: a.builder.Label(), line: line}
+		a.labels[name] = l
+	}
+	return l
+}
+
+func (a *assembler) symbol(token string) (int, error) {
+	idx, ok := a.symbols[token[1:]]
+	if !ok {
+		return 0, fmt.Errorf("undefined symbol %q", token)
+	}
+	return idx, nil
+}
+
+// assemble resolves every label operand, reporting a label that was used but
+// never defined by name rather than by its builder index.
+func (a *assembler) assemble() ([]Instruction, error) {
+	var undefined []string
+	for name, l := range a.labels {
+		if !l.bound {
+			undefined = append(undefined, name)
+		}
+	}
+	if len(undefined) > 0 {
+		slices.SortFunc(undefined, func(x, y string) int { return a.labels[x].line - a.labels[y].line })
+		l := a.labels[undefined[0]]
+		return nil, fmt.Errorf("line %d: %w: %q", l.line, ErrUnboundLabel, undefined[0])
+	}
+	return a.builder.Assemble()
+}
+
 func parseOperands(fields []string, widths []int) ([]uint64, error) {
 	var operands []uint64
 	fi := 0
@@ -239,3 +361,40 @@ func parseOperand(s string, width int) (uint64, error) {
 	}
 	return uint64(v), nil
 }
+
+// trimOffset strips an optional offset prefix of the form "NNNN:" or
+// "NNNN:\t" that Format writes before each instruction.
+func trimOffset(line string) string {
+	idx := strings.IndexByte(line, ':')
+	if idx < 0 {
+		return line
+	}
+	prefix := strings.TrimSpace(line[:idx])
+	if prefix == "" {
+		return line
+	}
+	for _, c := range prefix {
+		if c < '0' || c > '9' {
+			return line
+		}
+	}
+	return line[idx+1:]
+}
+
+// isLabel reports whether s is a label name: a letter or underscore followed
+// by letters, digits, and underscores. Offsets and numeric operands never
+// start with a letter, so a label cannot be mistaken for either.
+func isLabel(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case i > 0 && c >= '0' && c <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -138,6 +138,38 @@ func TestParseAll(t *testing.T) {
 		require.Equal(t, original, got)
 	})
 
+	t.Run("label operands", func(t *testing.T) {
+		source := "loop:\ni32.const 1\nbr_if end\nbr loop\nend: return"
+		got, err := instr.ParseAll(strings.NewReader(source))
+		require.NoError(t, err)
+
+		b := instr.NewBuilder()
+		loop, end := b.Label(), b.Label()
+		b.Bind(loop).Emit(instr.I32_CONST, 1).BrIf(end).Br(loop).Bind(end).Emit(instr.RETURN)
+		expected, err := b.Assemble()
+		require.NoError(t, err)
+		require.Equal(t, expected, got)
+	})
+
+	t.Run("br_table label operands", func(t *testing.T) {
+		got, err := instr.ParseAll(strings.NewReader("br_table 2 a 0x0001 b\na: nop\nnop\nb: return"))
+		require.NoError(t, err)
+		require.Equal(t, []instr.Instruction{instr.New(instr.BR_TABLE, 2, 0, 1, 2), instr.New(instr.NOP), instr.New(instr.NOP), instr.New(instr.RETURN)}, got)
+	})
+
+	t.Run("undefined label", func(t *testing.T) {
+		_, err := instr.ParseAll(strings.NewReader("nop\nbr missing"))
+		require.ErrorIs(t, err, instr.ErrUnboundLabel)
+		require.Contains(t, err.Error(), "line 2")
+		require.Contains(t, err.Error(), `"missing"`)
+	})
+
+	t.Run("duplicate label", func(t *testing.T) {
+		_, err := instr.ParseAll(strings.NewReader("top:\nnop\ntop:\nreturn"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "already defined")
+	})
+
 	t.Run("round-trip br_table", func(t *testing.T) {
 		original := []instr.Instruction{instr.New(instr.BR_TABLE, 2, 0, 1, 0)}
 		got, err := instr.ParseAll(strings.NewReader(instr.Format(instr.Marshal(original))))
@@ -146,6 +178,38 @@ func TestParseAll(t *testing.T) {
 	})
 }
 
+func TestParseSymbols(t *testing.T) {
+	symbols := map[string]int{"fib": 3}
+
+	t.Run("const.get symbol", func(t *testing.T) {
+		got, err := instr.ParseSymbols(strings.NewReader("const.get $fib"), symbols)
+		require.NoError(t, err)
+		require.Equal(t, []instr.Instruction{instr.New(instr.CONST_GET, 3)}, got)
+	})
+
+	t.Run("call symbol", func(t *testing.T) {
+		got, err := instr.ParseSymbols(strings.NewReader("i32.const 10\ncall $fib\nreturn_call $fib"), symbols)
+		require.NoError(t, err)
+		require.Equal(t, []instr.Instruction{
+			instr.New(instr.I32_CONST, 10),
+			instr.New(instr.CONST_GET, 3), instr.New(instr.CALL),
+			instr.New(instr.CONST_GET, 3), instr.New(instr.RETURN_CALL),
+		}, got)
+	})
+
+	t.Run("labels after expansion", func(t *testing.T) {
+		got, err := instr.ParseSymbols(strings.NewReader("call $fib\nbr end\nnop\nend: return"), symbols)
+		require.NoError(t, err)
+		require.Equal(t, instr.New(instr.BR, 1), got[2])
+	})
+
+	t.Run("undefined symbol", func(t *testing.T) {
+		_, err := instr.ParseSymbols(strings.NewReader("call $missing"), symbols)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `"$missing"`)
+	})
+}
+
 func TestParse(t *testing.T) {
 	tests := []struct {
 		line    string
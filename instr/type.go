@@ -33,6 +33,11 @@ const (
 	FlagLocalWrite
 	// FlagContainerStore writes an element or field into a heap container.
 	FlagContainerStore
+	// FlagAlloc creates a new heap object.
+	FlagAlloc
+	// FlagTypeIndex names an entry of the program's type table with its first
+	// operand.
+	FlagTypeIndex
 )
 
 // IsCall reports whether op transfers control into another function.
@@ -45,6 +50,13 @@ func WritesLocal(op Opcode) bool { return TypeOf(op).Flags&FlagLocalWrite != 0 }
 // container.
 func StoresContainer(op Opcode) bool { return TypeOf(op).Flags&FlagContainerStore != 0 }
 
+// Allocates reports whether op creates a new heap object.
+func Allocates(op Opcode) bool { return TypeOf(op).Flags&FlagAlloc != 0 }
+
+// IndexesType reports whether op's first operand indexes the program's type
+// table.
+func IndexesType(op Opcode) bool { return TypeOf(op).Flags&FlagTypeIndex != 0 }
+
 var types = map[Opcode]Type{
 	NOP:         {Mnemonic: "nop"},
 	UNREACHABLE: {Mnemonic: "unreachable"},
@@ -80,8 +92,8 @@ var types = map[Opcode]Type{
 
 	REF_NULL: {Mnemonic: "ref.null", Push: []Kind{KindRef}},
 
-	REF_TEST: {Mnemonic: "ref.test", Widths: []int{2}, Pop: []Kind{KindAny}, Push: []Kind{KindI1}},
-	REF_CAST: {Mnemonic: "ref.cast", Widths: []int{2}, Pop: []Kind{KindAny}, Push: []Kind{KindAny}},
+	REF_TEST: {Mnemonic: "ref.test", Widths: []int{2}, Pop: []Kind{KindAny}, Push: []Kind{KindI1}, Flags: FlagTypeIndex},
+	REF_CAST: {Mnemonic: "ref.cast", Widths: []int{2}, Pop: []Kind{KindAny}, Push: []Kind{KindAny}, Flags: FlagTypeIndex},
 
 	REF_IS_NULL: {Mnemonic: "ref.is_null", Pop: []Kind{KindRef}, Push: []Kind{KindI1}},
 	REF_EQ:      {Mnemonic: "ref.eq", Pop: []Kind{KindRef, KindRef}, Push: []Kind{KindI1}},
@@ -251,10 +263,10 @@ var types = map[Opcode]Type{
 
 	F64_REINTERPRET_I64: {Mnemonic: "f64.reinterpret_i64", Pop: []Kind{KindI64}, Push: []Kind{KindF64}},
 
-	STRING_NEW_UTF32: {Mnemonic: "string.new_utf32", Pop: []Kind{KindRef}, Push: []Kind{KindRef}},
+	STRING_NEW_UTF32: {Mnemonic: "string.new_utf32", Pop: []Kind{KindRef}, Push: []Kind{KindRef}, Flags: FlagAlloc},
 
 	STRING_LEN:    {Mnemonic: "string.len", Pop: []Kind{KindRef}, Push: []Kind{KindI32}},
-	STRING_CONCAT: {Mnemonic: "string.concat", Pop: []Kind{KindRef, KindRef}, Push: []Kind{KindRef}},
+	STRING_CONCAT: {Mnemonic: "string.concat", Pop: []Kind{KindRef, KindRef}, Push: []Kind{KindRef}, Flags: FlagAlloc},
 
 	STRING_EQ: {Mnemonic: "string.eq", Pop: []Kind{KindRef, KindRef}, Push: []Kind{KindI1}},
 	STRING_NE: {Mnemonic: "string.ne", Pop: []Kind{KindRef, KindRef}, Push: []Kind{KindI1}},
@@ -263,11 +275,11 @@ var types = map[Opcode]Type{
 	STRING_LE: {Mnemonic: "string.le", Pop: []Kind{KindRef, KindRef}, Push: []Kind{KindI1}},
 	STRING_GE: {Mnemonic: "string.ge", Pop: []Kind{KindRef, KindRef}, Push: []Kind{KindI1}},
 
-	STRING_ENCODE_UTF32: {Mnemonic: "string.encode_utf32", Pop: []Kind{KindRef}, Push: []Kind{KindRef}},
-	STRING_ITER:         {Mnemonic: "string.iter", Pop: []Kind{KindRef}, Push: []Kind{KindRef}},
+	STRING_ENCODE_UTF32: {Mnemonic: "string.encode_utf32", Pop: []Kind{KindRef}, Push: []Kind{KindRef}, Flags: FlagAlloc},
+	STRING_ITER:         {Mnemonic: "string.iter", Pop: []Kind{KindRef}, Push: []Kind{KindRef}, Flags: FlagAlloc},
 
-	ARRAY_NEW:         {Mnemonic: "array.new", Widths: []int{2}, Pop: []Kind{KindI32, KindAny}, Push: []Kind{KindRef}},
-	ARRAY_NEW_DEFAULT: {Mnemonic: "array.new_default", Widths: []int{2}, Pop: []Kind{KindI32}, Push: []Kind{KindRef}},
+	ARRAY_NEW:         {Mnemonic: "array.new", Widths: []int{2}, Pop: []Kind{KindI32, KindAny}, Push: []Kind{KindRef}, Flags: FlagAlloc | FlagTypeIndex},
+	ARRAY_NEW_DEFAULT: {Mnemonic: "array.new_default", Widths: []int{2}, Pop: []Kind{KindI32}, Push: []Kind{KindRef}, Flags: FlagAlloc | FlagTypeIndex},
 
 	ARRAY_LEN:    {Mnemonic: "array.len", Pop: []Kind{KindRef}, Push: []Kind{KindI32}},
 	ARRAY_GET:    {Mnemonic: "array.get", Pop: []Kind{KindI32, KindRef}, Push: []Kind{KindAny}},
@@ -276,16 +288,16 @@ var types = map[Opcode]Type{
 	ARRAY_COPY:   {Mnemonic: "array.copy", Pop: []Kind{KindI32, KindI32, KindRef, KindI32, KindRef}},
 	ARRAY_APPEND: {Mnemonic: "array.append"},
 	ARRAY_DELETE: {Mnemonic: "array.delete", Pop: []Kind{KindI32, KindRef}, Push: []Kind{KindAny}},
-	ARRAY_SLICE:  {Mnemonic: "array.slice", Pop: []Kind{KindI32, KindI32, KindRef}, Push: []Kind{KindRef}},
+	ARRAY_SLICE:  {Mnemonic: "array.slice", Pop: []Kind{KindI32, KindI32, KindRef}, Push: []Kind{KindRef}, Flags: FlagAlloc},
 
-	STRUCT_NEW:         {Mnemonic: "struct.new", Widths: []int{2}},
-	STRUCT_NEW_DEFAULT: {Mnemonic: "struct.new_default", Widths: []int{2}, Push: []Kind{KindRef}},
+	STRUCT_NEW:         {Mnemonic: "struct.new", Widths: []int{2}, Flags: FlagAlloc | FlagTypeIndex},
+	STRUCT_NEW_DEFAULT: {Mnemonic: "struct.new_default", Widths: []int{2}, Push: []Kind{KindRef}, Flags: FlagAlloc | FlagTypeIndex},
 
 	STRUCT_GET: {Mnemonic: "struct.get", Pop: []Kind{KindI32, KindRef}, Push: []Kind{KindAny}},
 	STRUCT_SET: {Mnemonic: "struct.set", Pop: []Kind{KindAny, KindI32, KindRef}, Flags: FlagContainerStore},
 
-	MAP_NEW:         {Mnemonic: "map.new", Widths: []int{2}},
-	MAP_NEW_DEFAULT: {Mnemonic: "map.new_default", Widths: []int{2}, Pop: []Kind{KindI32}, Push: []Kind{KindRef}},
+	MAP_NEW:         {Mnemonic: "map.new", Widths: []int{2}, Flags: FlagAlloc | FlagTypeIndex},
+	MAP_NEW_DEFAULT: {Mnemonic: "map.new_default", Widths: []int{2}, Pop: []Kind{KindI32}, Push: []Kind{KindRef}, Flags: FlagAlloc | FlagTypeIndex},
 
 	MAP_LEN:    {Mnemonic: "map.len", Pop: []Kind{KindRef}, Push: []Kind{KindI32}},
 	MAP_GET:    {Mnemonic: "map.get", Pop: []Kind{KindAny, KindRef}, Push: []Kind{KindAny}},
@@ -293,18 +305,18 @@ var types = map[Opcode]Type{
 	MAP_SET:    {Mnemonic: "map.set", Pop: []Kind{KindAny, KindAny, KindRef}},
 	MAP_DELETE: {Mnemonic: "map.delete", Pop: []Kind{KindAny, KindRef}},
 	MAP_CLEAR:  {Mnemonic: "map.clear", Pop: []Kind{KindRef}},
-	MAP_KEYS:   {Mnemonic: "map.keys", Pop: []Kind{KindRef}, Push: []Kind{KindRef}},
-	MAP_ITER:   {Mnemonic: "map.iter", Pop: []Kind{KindRef}, Push: []Kind{KindRef}},
+	MAP_KEYS:   {Mnemonic: "map.keys", Pop: []Kind{KindRef}, Push: []Kind{KindRef}, Flags: FlagAlloc},
+	MAP_ITER:   {Mnemonic: "map.iter", Pop: []Kind{KindRef}, Push: []Kind{KindRef}, Flags: FlagAlloc},
 
-	REF_NEW: {Mnemonic: "ref.new", Pop: []Kind{KindAny}, Push: []Kind{KindRef}},
+	REF_NEW: {Mnemonic: "ref.new", Pop: []Kind{KindAny}, Push: []Kind{KindRef}, Flags: FlagAlloc},
 	REF_GET: {Mnemonic: "ref.get", Pop: []Kind{KindRef}, Push: []Kind{KindAny}},
 	REF_SET: {Mnemonic: "ref.set", Pop: []Kind{KindAny, KindRef}},
 
-	CLOSURE_NEW: {Mnemonic: "closure.new"},
+	CLOSURE_NEW: {Mnemonic: "closure.new", Flags: FlagAlloc},
 
 	THROW: {Mnemonic: "throw", Pop: []Kind{KindAny}},
 
-	ERROR_NEW:  {Mnemonic: "error.new", Pop: []Kind{KindI32, KindAny}, Push: []Kind{KindRef}},
+	ERROR_NEW:  {Mnemonic: "error.new", Pop: []Kind{KindI32, KindAny}, Push: []Kind{KindRef}, Flags: FlagAlloc},
 	ERROR_GET:  {Mnemonic: "error.get", Pop: []Kind{KindRef}, Push: []Kind{KindAny}},
 	ERROR_CODE: {Mnemonic: "error.code", Pop: []Kind{KindRef}, Push: []Kind{KindI32}},
 
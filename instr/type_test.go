@@ -40,3 +40,24 @@ func TestValid(t *testing.T) {
 		require.False(t, instr.Valid(instr.Opcode(code)), "opcode %d is registered past STRING_ITER", code)
 	}
 }
+
+func TestAllocates(t *testing.T) {
+	require.True(t, instr.Allocates(instr.ARRAY_NEW))
+	require.True(t, instr.Allocates(instr.STRING_CONCAT))
+	require.True(t, instr.Allocates(instr.CLOSURE_NEW))
+	require.False(t, instr.Allocates(instr.ARRAY_GET))
+	require.False(t, instr.Allocates(instr.I32_ADD))
+}
+
+func TestIndexesType(t *testing.T) {
+	for _, op := range []instr.Opcode{
+		instr.REF_TEST, instr.REF_CAST,
+		instr.ARRAY_NEW, instr.ARRAY_NEW_DEFAULT,
+		instr.STRUCT_NEW, instr.STRUCT_NEW_DEFAULT,
+		instr.MAP_NEW, instr.MAP_NEW_DEFAULT,
+	} {
+		require.True(t, instr.IndexesType(op), instr.TypeOf(op).Mnemonic)
+	}
+	require.False(t, instr.IndexesType(instr.CONST_GET))
+	require.False(t, instr.IndexesType(instr.ARRAY_GET))
+}
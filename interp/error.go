@@ -15,9 +15,13 @@ type RuntimeError struct {
 	Frames []FrameInfo
 }
 
+// FrameInfo locates one frame of a RuntimeError. Name and Pos come from the
+// program's debug info and stay empty when it has none for the frame.
 type FrameInfo struct {
 	Func int
 	IP   int
+	Name string
+	Pos  types.Position
 }
 
 // escape carries a guest throw that found no handler out of the dispatch loop.
@@ -59,9 +63,14 @@ var (
 	ErrFuelExhausted       = errors.New("fuel exhausted")
 	ErrHeapExhausted       = errors.New("heap exhausted")
 	ErrYield               = errors.New("yield")
+	ErrPending             = errors.New("pending host call")
 	ErrCoroutineDone       = errors.New("coroutine done")
 	ErrInterpreterBusy     = errors.New("interpreter busy")
 	ErrUncaughtException   = errors.New("uncaught exception")
+	ErrUnresolvedImport    = errors.New("unresolved import")
+	ErrUnknownExport       = errors.New("unknown export")
+	ErrInvalidSnapshot     = errors.New("invalid snapshot")
+	ErrUnportableValue     = errors.New("value has no snapshot form")
 )
 
 var errorCodes = []struct {
@@ -89,11 +98,20 @@ var errorCodes = []struct {
 // the next Run call resumes exactly after the YIELD.
 var errYield = errors.New("yield")
 
+// errPending is the panic value an async host call raises to suspend Run on a
+// handle that is not yet completed. Run returns it as ErrPending, and the next
+// Run after completion runs the call again to collect its results.
+var errPending = errors.New("pending host call")
+
+// errPreempted is what Run returns when a Scheduler's slice runs out. Like a
+// fuel stop it leaves all state in place, so the next Run resumes there.
+var errPreempted = errors.New("preempted")
+
 func ErrorCode(err error) types.ErrorCode {
 	if err == nil {
 		return types.ErrorCodeNone
 	}
-	if errors.Is(err, ErrYield) {
+	if errors.Is(err, ErrYield) || errors.Is(err, ErrPending) {
 		return types.ErrorCodeNone
 	}
 	var exc *types.Error
@@ -128,10 +146,17 @@ func (e *RuntimeError) Error() string {
 	b.WriteString(msg)
 	for idx, f := range e.Frames {
 		if idx == 0 {
-			fmt.Fprintf(&b, ": fn=%d ip=%d", f.Func, f.IP)
-			continue
+			b.WriteString(": ")
+		} else {
+			b.WriteString(" <- ")
+		}
+		fmt.Fprintf(&b, "fn=%d ip=%d", f.Func, f.IP)
+		if f.Name != "" {
+			fmt.Fprintf(&b, " %s", f.Name)
+		}
+		if f.Pos.IsValid() {
+			fmt.Fprintf(&b, " at %s", f.Pos)
 		}
-		fmt.Fprintf(&b, " <- fn=%d ip=%d", f.Func, f.IP)
 	}
 	return b.String()
 }
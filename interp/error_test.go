@@ -64,6 +64,17 @@ func TestRuntimeError_Error(t *testing.T) {
 			},
 			want: "divide by zero: fn=2 ip=7 <- fn=1 ip=3",
 		},
+		{
+			name: "frames with debug info",
+			err: &interp.RuntimeError{
+				Err: interp.ErrDivideByZero,
+				Frames: []interp.FrameInfo{
+					{Func: 2, IP: 7, Name: "div", Pos: types.Position{File: "lib.mvm", Line: 2, Column: 3}},
+					{Func: 1, IP: 3, Name: "main"},
+				},
+			},
+			want: "divide by zero: fn=2 ip=7 div at lib.mvm:2:3 <- fn=1 ip=3 main",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -0,0 +1,176 @@
+package interp
+
+import (
+	"math"
+
+	"github.com/siyul-park/minivm/instr"
+)
+
+// CostTable prices guest execution for WithCosts. Each executed instruction
+// costs its Opcodes entry, each byte the heap grows by (see MemoryUsage) costs
+// Byte, and each host function call costs its Hosts entry, or Call when it has
+// none, on top of the CALL instruction that made it.
+type CostTable struct {
+	Opcodes [256]uint64
+	Byte    uint64
+	Call    uint64
+	Hosts   map[*HostFunction]uint64
+}
+
+// costs is a CostTable as the interpreter charges it: copied, so the host may
+// keep editing its table, and clamped to int64, so the tank arithmetic cannot
+// wrap.
+type costs struct {
+	opcodes [256]int64
+	byte    int64
+	call    int64
+	hosts   map[*HostFunction]int64
+}
+
+// NewCostTable returns a table that charges base for every opcode and nothing
+// for allocation or host calls.
+func NewCostTable(base uint64) *CostTable {
+	t := &CostTable{}
+	for op := range t.Opcodes {
+		t.Opcodes[op] = base
+	}
+	return t
+}
+
+func newCosts(t *CostTable) *costs {
+	if t == nil {
+		return nil
+	}
+	c := &costs{byte: clamp(t.Byte), call: clamp(t.Call)}
+	for op, cost := range t.Opcodes {
+		c.opcodes[op] = clamp(cost)
+	}
+	if len(t.Hosts) > 0 {
+		c.hosts = make(map[*HostFunction]int64, len(t.Hosts))
+		for fn, cost := range t.Hosts {
+			c.hosts[fn] = clamp(cost)
+		}
+	}
+	return c
+}
+
+// host is what one call to fn costs beyond its CALL instruction.
+func (c *costs) host(fn *HostFunction) int64 {
+	if cost, ok := c.hosts[fn]; ok {
+		return cost
+	}
+	return c.call
+}
+
+// bytes is what growing the heap by n bytes costs.
+func (c *costs) bytes(n int) int64 {
+	if n <= 0 || c.byte == 0 {
+		return 0
+	}
+	if int64(n) > math.MaxInt64/c.byte {
+		return math.MaxInt64
+	}
+	return int64(n) * c.byte
+}
+
+// Fuel reports the fuel left before the next instruction fails with
+// ErrFuelExhausted, or math.MaxUint64 when no budget was set.
+func (i *Interpreter) Fuel() uint64 {
+	if i.fuel < 0 {
+		return math.MaxUint64
+	}
+	return uint64(max(i.tank, 0))
+}
+
+// FuelConsumed reports the fuel charged since New or the last Reset. Without
+// a cost table fuel is charged a tick at a time, so it rounds up to WithTick.
+func (i *Interpreter) FuelConsumed() uint64 {
+	return i.spent
+}
+
+// Refuel adds n to the fuel left, first paying off any debt an allocation or
+// host call ran up past the budget. An interpreter without a budget ignores
+// it.
+func (i *Interpreter) Refuel(n uint64) {
+	if i.fuel < 0 {
+		return
+	}
+	i.tank = saturate(i.tank, clamp(n))
+}
+
+// refill restores the budget New was given and forgets what was spent.
+func (i *Interpreter) refill() {
+	i.tank, i.spent = i.fuel, 0
+	if i.fuel < 0 {
+		i.tank = math.MaxInt64
+	}
+}
+
+// meter makes each handler of a compiled table spend its instruction's cost
+// before running, so exhaustion stops on the instruction it could not pay
+// for, with nothing of it done, and the next Run after a Refuel resumes there.
+func (i *Interpreter) meter(code []byte, compiled []func(*Interpreter)) []func(*Interpreter) {
+	if i.costs == nil {
+		return compiled
+	}
+	for ip := 0; ip < len(code); ip += instr.Instruction(code[ip:]).Width() {
+		fn := compiled[ip]
+		cost := i.costs.opcodes[code[ip]]
+		compiled[ip] = func(i *Interpreter) {
+			i.spend(cost)
+			fn(i)
+		}
+	}
+	return compiled
+}
+
+// spend takes cost from the tank, or fails with ErrFuelExhausted when the tank
+// cannot cover it.
+func (i *Interpreter) spend(cost int64) {
+	if i.tank < cost {
+		panic(ErrFuelExhausted)
+	}
+	i.tank -= cost
+	i.spent += uint64(cost)
+}
+
+// owe takes cost from the tank even past empty. Allocation and host calls are
+// charged after the instruction is already committed to them, so the debt
+// stops the next instruction instead.
+func (i *Interpreter) owe(cost int64) {
+	if cost <= 0 {
+		return
+	}
+	i.tank = saturate(i.tank, -cost)
+	i.spent += uint64(cost)
+}
+
+// refund returns cost charged for work that will be charged again.
+func (i *Interpreter) refund(cost int64) {
+	i.tank = saturate(i.tank, cost)
+	i.spent -= uint64(cost)
+}
+
+// settle takes back the tank native code left in the journal.
+func (i *Interpreter) settle() {
+	if i.costs == nil {
+		return
+	}
+	tank := int64(i.journal[journalFuel])
+	i.spent += uint64(i.tank - tank)
+	i.tank = tank
+}
+
+func clamp(n uint64) int64 {
+	return int64(min(n, math.MaxInt64))
+}
+
+func saturate(a, b int64) int64 {
+	switch {
+	case b > 0 && a > math.MaxInt64-b:
+		return math.MaxInt64
+	case b < 0 && a < math.MinInt64-b:
+		return math.MinInt64
+	}
+	return a + b
+}
@@ -0,0 +1,105 @@
+package interp_test
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/siyul-park/minivm/instr"
+	interp "github.com/siyul-park/minivm/interp"
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCostTable(t *testing.T) {
+	costs := interp.NewCostTable(2)
+	for _, cost := range costs.Opcodes {
+		require.Equal(t, uint64(2), cost)
+	}
+	require.Zero(t, costs.Byte)
+	require.Zero(t, costs.Call)
+	require.Empty(t, costs.Hosts)
+}
+
+func TestInterpreter_Fuel(t *testing.T) {
+	prog := program.New([]instr.Instruction{instr.New(instr.I32_CONST, 1), instr.New(instr.I32_CONST, 2)})
+
+	t.Run("reports the fuel left", func(t *testing.T) {
+		i := interp.New(prog, interp.WithFuel(10), interp.WithCosts(interp.NewCostTable(3)))
+		defer i.Close()
+
+		require.Equal(t, uint64(10), i.Fuel())
+		require.NoError(t, i.Run(context.Background()))
+		require.Equal(t, uint64(4), i.Fuel())
+	})
+
+	t.Run("is unlimited without a budget", func(t *testing.T) {
+		i := interp.New(prog)
+		defer i.Close()
+
+		require.Equal(t, uint64(math.MaxUint64), i.Fuel())
+	})
+}
+
+func TestInterpreter_FuelConsumed(t *testing.T) {
+	prog := program.New([]instr.Instruction{instr.New(instr.I32_CONST, 1), instr.New(instr.I32_CONST, 2)})
+
+	t.Run("counts charged fuel until reset", func(t *testing.T) {
+		costs := interp.NewCostTable(1)
+		costs.Opcodes[instr.I32_CONST] = 5
+		i := interp.New(prog, interp.WithCosts(costs))
+		defer i.Close()
+
+		require.NoError(t, i.Run(context.Background()))
+		require.Equal(t, uint64(10), i.FuelConsumed())
+		i.Reset()
+		require.Zero(t, i.FuelConsumed())
+	})
+
+	t.Run("counts ticks without a cost table", func(t *testing.T) {
+		i := interp.New(prog, interp.WithTick(1), interp.WithFuel(10))
+		defer i.Close()
+
+		require.NoError(t, i.Run(context.Background()))
+		require.Equal(t, uint64(2), i.FuelConsumed())
+	})
+}
+
+func TestInterpreter_Refuel(t *testing.T) {
+	t.Run("resumes an exhausted run", func(t *testing.T) {
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1), instr.New(instr.I32_CONST, 2), instr.New(instr.I32_ADD),
+		})
+		i := interp.New(prog, interp.WithFuel(2), interp.WithCosts(interp.NewCostTable(1)))
+		defer i.Close()
+
+		require.ErrorIs(t, i.Run(context.Background()), interp.ErrFuelExhausted)
+		i.Refuel(1)
+		require.NoError(t, i.Run(context.Background()))
+		val, err := i.Pop()
+		require.NoError(t, err)
+		require.Equal(t, types.I32(3), val)
+	})
+
+	t.Run("pays off debt first", func(t *testing.T) {
+		fn := interp.NewHostFunction(&types.FunctionType{}, func(*interp.Interpreter, []types.Boxed) ([]types.Boxed, error) {
+			return nil, nil
+		})
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.CONST_GET, 0), instr.New(instr.CALL), instr.New(instr.NOP),
+		}, program.WithConstants(fn))
+		costs := interp.NewCostTable(1)
+		costs.Call = 10
+		i := interp.New(prog, interp.WithFuel(5), interp.WithCosts(costs))
+		defer i.Close()
+
+		require.ErrorIs(t, i.Run(context.Background()), interp.ErrFuelExhausted)
+		require.Zero(t, i.Fuel())
+		i.Refuel(7)
+		require.Zero(t, i.Fuel())
+		i.Refuel(1)
+		require.Equal(t, uint64(1), i.Fuel())
+		require.NoError(t, i.Run(context.Background()))
+	})
+}
@@ -5,11 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"math"
 	"reflect"
 	"slices"
 	"sync/atomic"
 	"unsafe"
+	"weak"
 
 	"github.com/siyul-park/minivm/asm"
 	"github.com/siyul-park/minivm/instr"
@@ -24,12 +26,15 @@ type Interpreter struct {
 	tracer      *tracer
 	hook        func(*Interpreter) error
 	codec       Codec
+	resolver    Resolver
 	speculative bool
 
 	compiler *compiler
 	cache    *cache
 	profiler *prof.Profiler
+	symtab   int
 	samples  *prof.Collector
+	unwound  []prof.Frame
 	exits    map[anchor]func(*Interpreter)
 	stubs    []func(*Interpreter)
 	natives  []unsafe.Pointer
@@ -48,6 +53,8 @@ type Interpreter struct {
 	misses      []uint8
 	coros       []bool
 	handlers    [][]instr.Handler
+	prog        *program.Program
+	debug       *program.Debug
 	module      *types.Function
 	dynamic     map[int]bool
 
@@ -66,6 +73,14 @@ type Interpreter struct {
 	arrays  pool[*types.Array]
 	structs pool[*types.Struct]
 
+	// sizes is the byte size each slot was last charged, and memory their sum.
+	// Slots are charged when they are filled and recharged when an opcode grows
+	// the value in place, so one slot holding a ten-million element array
+	// weighs what it holds instead of counting as one entry.
+	sizes  []int
+	memory int
+	peak   int
+
 	// enc and dec are the scratch a host value converts through. A conversion
 	// takes a pointer, so building one per field access would allocate on every
 	// read; the interpreter executing the access owns it instead, which also
@@ -83,13 +98,34 @@ type Interpreter struct {
 	fp  int
 	sp  int
 	gen int
-	gas int64
+
+	// tank is the fuel left, in the units of WithFuel: negative once an
+	// allocation or host call overdraws it, and unused without a budget unless
+	// a cost table is metering. spent is everything charged since New or Reset.
+	tank  int64
+	spent uint64
+	costs *costs
+
+	// waiting is the async host call Run is suspended on, and awaited the
+	// function that made it (see await).
+	waiting *Pending
+	awaited *HostFunction
+
+	// slice is the share of execution a Scheduler granted the current Run,
+	// and nested whether Call is running a guest that must return to it.
+	slice  *slice
+	nested bool
 
 	threshold int64
 	trigger   uint64
 	tick      int
 	fuel      int64
 	limit     int
+	maxMemory int
+
+	// rejected is why WithAdmission refused the program. Run and Call fail
+	// with it before running any guest code.
+	rejected error
 }
 
 type frame struct {
@@ -116,12 +152,18 @@ type option struct {
 	profiler  *prof.Profiler
 	threshold int
 
-	frame   int
-	stack   int
-	heap    int
-	maxHeap int
-	tick    int
-	fuel    uint64
+	frame    int
+	stack    int
+	heap     int
+	maxHeap  int
+	memory   int
+	tick     int
+	fuel     uint64
+	costs    *CostTable
+	imports  map[string]*HostFunction
+	resolver Resolver
+	stats    *program.Stats
+	admit    *program.Stats
 }
 
 const heapRunway = 64
@@ -177,6 +219,21 @@ func WithStack(val int) func(*option) {
 	return func(o *option) { o.stack = val }
 }
 
+// WithStats sizes the operand stack and the frame stack to the static bounds
+// in s, as computed by program.Analyze for the same program. A bound that is
+// program.Unbounded keeps the size WithStack or WithFrame configures.
+func WithStats(s *program.Stats) func(*option) {
+	return func(o *option) { o.stats = s }
+}
+
+// WithAdmission rejects a program whose static bounds in s, as computed by
+// program.Analyze, are unbounded or exceed the configured stack and frame
+// sizes. A rejected interpreter fails every Run and Call with an error
+// wrapping ErrStackOverflow or ErrFrameOverflow before running guest code.
+func WithAdmission(s *program.Stats) func(*option) {
+	return func(o *option) { o.admit = s }
+}
+
 func WithHeap(val int) func(*option) {
 	return func(o *option) { o.heap = val }
 }
@@ -185,6 +242,13 @@ func WithHeapLimit(val int) func(*option) {
 	return func(o *option) { o.maxHeap = val }
 }
 
+// WithMemoryLimit bounds the approximate bytes the heap holds. An allocation
+// or in-place growth past it, once a collection cannot make room, fails with
+// ErrHeapExhausted. Values <= 0 mean unlimited.
+func WithMemoryLimit(val int) func(*option) {
+	return func(o *option) { o.memory = val }
+}
+
 func WithTick(val int) func(*option) {
 	return func(o *option) { o.tick = val }
 }
@@ -193,10 +257,43 @@ func WithThreshold(val int) func(*option) {
 	return func(o *option) { o.threshold = val }
 }
 
+// WithFuel bounds the fuel a run may spend; once it runs out, Run fails with
+// ErrFuelExhausted and a later Run, after a Refuel, picks up where it stopped.
+// Without WithCosts fuel counts instructions and is checked every tick. Zero
+// means unlimited.
 func WithFuel(val uint64) func(*option) {
 	return func(o *option) { o.fuel = val }
 }
 
+// WithCosts meters execution against t instead of counting instructions: every
+// instruction, allocation, and host call is charged exactly, native code
+// included, and exhaustion stops on the first instruction the remaining fuel
+// cannot pay for. Fused handlers are disabled, as under WithTick(1), so each
+// instruction is charged on its own.
+func WithCosts(t *CostTable) func(*option) {
+	return func(o *option) { o.costs = t }
+}
+
+// WithImports supplies the host functions that satisfy prog's imports, keyed by
+// import name. Repeated options merge. An import left unresolved, or resolved
+// by a function whose type differs from the declared signature, is bound to a
+// stub that fails with ErrUnresolvedImport when the guest calls it.
+func WithImports(fns map[string]*HostFunction) func(*option) {
+	return func(o *option) {
+		if o.imports == nil {
+			o.imports = make(map[string]*HostFunction, len(fns))
+		}
+		maps.Copy(o.imports, fns)
+	}
+}
+
+// WithResolver installs the Resolver that Snapshot and Restore use to carry
+// values that have no portable form, such as host views and host functions
+// the program did not import.
+func WithResolver(r Resolver) func(*option) {
+	return func(o *option) { o.resolver = r }
+}
+
 func withCache(c *cache) func(*option) {
 	return func(o *option) { o.cache = c }
 }
@@ -221,6 +318,14 @@ func New(prog *program.Program, opts ...func(*option)) *Interpreter {
 		o(&opt)
 	}
 
+	if s := opt.stats; s != nil {
+		if s.Stack != program.Unbounded {
+			opt.stack = s.Stack
+		}
+		if s.Frames != program.Unbounded {
+			opt.frame = s.Frames
+		}
+	}
 	if opt.frame <= 0 {
 		opt.frame = 1
 	}
@@ -247,8 +352,7 @@ func New(prog *program.Program, opts ...func(*option)) *Interpreter {
 
 	var fuel int64 = -1
 	if opt.fuel > 0 {
-		ticks := (opt.fuel-1)/uint64(opt.tick) + 1
-		fuel = int64(min(ticks, 1<<63-1))
+		fuel = clamp(opt.fuel)
 	}
 
 	// threshold counts hot events - one per call into a function, one per
@@ -267,6 +371,7 @@ func New(prog *program.Program, opts ...func(*option)) *Interpreter {
 		tracer:      tracer,
 		hook:        opt.hook,
 		codec:       activeCodec,
+		resolver:    opt.resolver,
 		cache:       opt.cache,
 		profiler:    opt.profiler,
 		samples:     samples,
@@ -284,6 +389,8 @@ func New(prog *program.Program, opts ...func(*option)) *Interpreter {
 		misses:      make([]uint8, len(prog.Constants)+1),
 		coros:       make([]bool, len(prog.Constants)+1),
 		handlers:    make([][]instr.Handler, len(prog.Constants)+1),
+		prog:        prog,
+		debug:       prog.Debug,
 		exits:       map[anchor]func(*Interpreter){},
 		stubs:       make([]func(*Interpreter), len(prog.Constants)+1),
 		natives:     make([]unsafe.Pointer, len(prog.Constants)+1),
@@ -296,11 +403,14 @@ func New(prog *program.Program, opts ...func(*option)) *Interpreter {
 		owners:      make(map[types.Value]int),
 		free:        make([]int, 0, opt.heap),
 		rc:          make([]int, 0, opt.heap),
+		sizes:       make([]int, 0, opt.heap),
 		tick:        opt.tick,
 		fuel:        fuel,
-		gas:         fuel,
 		limit:       opt.maxHeap,
 	}
+	if opt.admit != nil {
+		i.rejected = admit(opt.admit, opt.stack, opt.frame)
+	}
 	i.alloc(types.Null)
 
 	// Retain each constant root and nested edge as it becomes visible because a
@@ -310,8 +420,9 @@ func New(prog *program.Program, opts ...func(*option)) *Interpreter {
 	// dedup gives identical string literals one shared cell. Nothing depends on
 	// that sharing - every string comparison and string map key compares content
 	// - so it is a load-time pool economy only, and the index dies with the loop.
+	constants := link(prog, opt.imports)
 	dedup := make(map[string]types.Ref)
-	for j, v := range prog.Constants {
+	for j, v := range constants {
 		var val types.Boxed
 		switch v := v.(type) {
 		case types.Boxed:
@@ -366,12 +477,27 @@ func New(prog *program.Program, opts ...func(*option)) *Interpreter {
 
 	i.base = len(i.heap)
 	i.recount()
+	// The constant pool is charged like any other slot, but the limit only
+	// starts binding once it is loaded: a program whose literals alone exceed
+	// the budget fails on its first allocation rather than in New.
+	i.maxMemory = opt.memory
+	// Loading is not billed either: metering starts with the program.
+	i.costs = newCosts(opt.costs)
+	i.refill()
 	i.target = max(cap(i.heap), i.base+heapRunway)
 	if i.limit > 0 {
 		i.target = max(min(i.target, i.limit), i.base)
 	}
 
 	i.module = &types.Function{Typ: &types.FunctionType{}, Locals: prog.Locals, Code: prog.Code, Handlers: prog.Handlers}
+	if prog.Debug != nil {
+		i.module.Debug = prog.Debug.Code
+	}
+	if i.profiler != nil {
+		// A weak key lets interpreters of one program, such as a Pool's,
+		// share a module number without the profiler keeping it alive.
+		i.symtab = i.profiler.Symbolize(weak.Make(prog), i.symbols())
+	}
 	i.instrs[0] = prog.Code
 	i.handlers[0] = prog.Handlers
 	i.coros[0] = i.yields(prog.Code)
@@ -382,9 +508,9 @@ func New(prog *program.Program, opts ...func(*option)) *Interpreter {
 
 	i.backedges[0] = nativeBackend && i.threshold >= 0
 	c := i.threader(i.backedges[0])
-	i.code[0] = c.Compile(prog.Code, i.module.Slots(), i.module.Declared(), types.Kinds(i.module.Captures), i.module.Captures)
+	i.code[0] = i.meter(prog.Code, c.Compile(prog.Code, i.module.Slots(), i.module.Declared(), types.Kinds(i.module.Captures), i.module.Captures))
 
-	for j, v := range prog.Constants {
+	for j, v := range constants {
 		if fn, ok := v.(*types.Function); ok {
 			i.bind(i.constants[j].Ref(), fn, false)
 		}
@@ -407,6 +533,12 @@ func New(prog *program.Program, opts ...func(*option)) *Interpreter {
 }
 
 func (i *Interpreter) Run(ctx context.Context) error {
+	if i.rejected != nil {
+		return i.rejected
+	}
+	if i.waiting != nil && !i.waiting.ready() {
+		return ErrPending
+	}
 	i.ctx = ctx
 	i.done = nil
 	if ctx != nil {
@@ -436,6 +568,35 @@ func (i *Interpreter) Run(ctx context.Context) error {
 	}
 }
 
+// Call invokes a guest callable with args and returns its results. fn selects
+// the callee as an export name (string), a constant pool index (int), a heap
+// reference (types.Ref or a ref types.Boxed), or a callable value: a *types.Function, *types.Closure, or
+// *HostFunction. args must match the callee's parameter types; a wrong count
+// or an argument the parameter type does not accept fails with
+// ErrTypeMismatch before any guest code runs.
+//
+// Call consumes args whether or not it succeeds, and the caller owns the
+// returned boxes: release each ref result once it is no longer needed. The
+// interpreter must be idle, as it is between runs or after Pool.Get;
+// otherwise Call fails with ErrInterpreterBusy.
+func (i *Interpreter) Call(ctx context.Context, fn any, args ...types.Boxed) ([]types.Boxed, error) {
+	err := i.rejected
+	var val types.Value
+	if err == nil {
+		val, err = i.callee(fn)
+	}
+	if err == nil {
+		err = i.check(val, args)
+	}
+	if err != nil {
+		for _, arg := range args {
+			i.releaseBox(arg)
+		}
+		return nil, err
+	}
+	return i.invoke(ctx, val, args)
+}
+
 func (i *Interpreter) Marshal(v any) (val types.Value, err error) {
 	defer i.guard(&err)
 	return i.codec.Marshal(i, v)
@@ -461,6 +622,21 @@ func (i *Interpreter) Opcode() (instr.Opcode, error) {
 	return instr.Opcode(i.instrs[fn][ip]), nil
 }
 
+// Instruction returns the current instruction with its immediates. The result
+// shares the function's code and must not be modified.
+func (i *Interpreter) Instruction() (instr.Instruction, error) {
+	fn, ip := i.Func(), i.IP()
+	if fn < 0 || fn >= len(i.instrs) || ip < 0 || ip >= len(i.instrs[fn]) {
+		return nil, ErrSegmentationFault
+	}
+	inst := instr.Instruction(i.instrs[fn][ip:])
+	width := inst.Width()
+	if width > len(inst) {
+		return nil, ErrSegmentationFault
+	}
+	return inst[:width:width], nil
+}
+
 func (i *Interpreter) Func() int {
 	return i.fr.addr
 }
@@ -477,6 +653,21 @@ func (i *Interpreter) Frame(n int) (fn, ip, bp int, err error) {
 	return f.addr, f.ip, f.bp, nil
 }
 
+// Debug returns the debug info of the function at addr, the function address
+// Func and Frame report, or nil when the program carries none for it.
+func (i *Interpreter) Debug(addr int) *types.Debug {
+	fn, ok := i.function(addr)
+	if !ok {
+		return nil
+	}
+	return fn.Debug
+}
+
+// GlobalName returns the debug name of global slot idx, or "" when it has none.
+func (i *Interpreter) GlobalName(idx int) string {
+	return i.debug.Global(idx)
+}
+
 func (i *Interpreter) Const(idx int) (types.Boxed, error) {
 	if idx < 0 || idx >= len(i.constants) {
 		return 0, ErrSegmentationFault
@@ -565,6 +756,28 @@ func (i *Interpreter) Load(addr int) (types.Value, error) {
 	return val, nil
 }
 
+// Lookup returns the value m holds under key, matching key the way the map
+// opcodes do. The key is borrowed: a reference it names is neither consumed
+// nor retained.
+func (i *Interpreter) Lookup(m *types.Map, key types.Boxed) (types.Boxed, bool) {
+	switch key.Kind() {
+	case types.KindI1, types.KindI8, types.KindI32, types.KindI64, types.KindF32, types.KindF64:
+	case types.KindRef:
+		if !i.alive(key.Ref()) {
+			return 0, false
+		}
+		i.retain(key.Ref())
+	default:
+		return 0, false
+	}
+	k, owned := i.mapKey(key)
+	if owned.Kind() == types.KindRef {
+		i.release(owned.Ref())
+	}
+	entry, ok := m.Get(k)
+	return entry.Value, ok
+}
+
 // Store replaces the value at addr. Concrete values transfer unique slot
 // ownership; an existing heap ref is accepted only when it already names addr.
 func (i *Interpreter) Store(addr int, val types.Value) (err error) {
@@ -603,12 +816,14 @@ func (i *Interpreter) Store(addr int, val types.Value) (err error) {
 	case *types.Function, *types.Closure, *coroutine:
 		return ErrTypeMismatch
 	}
+	i.reserve(sizeof(val) - i.sizes[addr])
 	i.heap[addr] = val
 	i.own(addr, val)
 	i.dispose(addr, old)
 	if fn, ok := val.(*types.Function); ok {
 		i.bind(addr, fn, true)
 	}
+	i.resize(addr)
 	return nil
 }
 
@@ -720,6 +935,27 @@ func (i *Interpreter) Len() int {
 	return i.sp
 }
 
+// MemoryStats is a point-in-time view of the heap's byte accounting. Used and
+// Peak are approximate payload sizes, not Go runtime measurements; Peak is the
+// high-water mark since New or the last Reset.
+type MemoryStats struct {
+	Used  int
+	Peak  int
+	Limit int
+	Slots int
+}
+
+// MemoryUsage reports the heap's byte accounting, the figures
+// WithMemoryLimit is enforced against.
+func (i *Interpreter) MemoryUsage() MemoryStats {
+	return MemoryStats{
+		Used:  i.memory,
+		Peak:  i.peak,
+		Limit: i.maxMemory,
+		Slots: len(i.heap) - len(i.free),
+	}
+}
+
 func (i *Interpreter) Close() error {
 	i.flush()
 	i.Reset()
@@ -785,8 +1021,6 @@ func (i *Interpreter) Reset() {
 		i.sp += locals
 	}
 
-	i.gas = i.fuel
-
 	heap := i.heap[:cap(i.heap)]
 	clear(heap[i.base:])
 	i.heap = heap[:i.base]
@@ -794,11 +1028,15 @@ func (i *Interpreter) Reset() {
 	clear(rc[i.base:])
 	i.rc = rc[:i.base]
 	i.recount()
+	i.peak = 0
+	i.measure()
 	i.free = i.free[:0]
 	i.tail = nil
 
 	i.seed()
 	i.pace()
+	i.refill()
+	i.waiting, i.awaited = nil, nil
 }
 
 // seed restores each global from its declaration rather than its previous value.
@@ -820,6 +1058,26 @@ func (i *Interpreter) dispatch() (caught bool, err error) {
 				err = ErrYield
 				return
 			}
+			if r == errPending {
+				err = ErrPending
+				return
+			}
+			// Running out of fuel is the host's limit, not the guest's error,
+			// so no guest handler may catch it.
+			if r == ErrFuelExhausted {
+				err = ErrFuelExhausted
+				return
+			}
+			// Neither may a guest catch running out of heap: the handler would
+			// start with no room to allocate anything.
+			if r == ErrHeapExhausted {
+				err = i.fault(r)
+				return
+			}
+			if r == errPreempted {
+				err = errPreempted
+				return
+			}
 			if i.handle(r) {
 				caught = true
 				return
@@ -828,6 +1086,9 @@ func (i *Interpreter) dispatch() (caught bool, err error) {
 		}
 	}()
 
+	if i.waiting != nil {
+		i.resume()
+	}
 	f := i.fr
 	code := f.code
 	// Tiering up is driven by the entry and back-edge hooks compiled into the
@@ -835,8 +1096,8 @@ func (i *Interpreter) dispatch() (caught bool, err error) {
 	// runs with no per-instruction accounting at all whether or not the JIT is
 	// enabled. The countdown below survives only for what genuinely needs an
 	// instruction-grained cadence: cancellation, fuel, the user hook, the user
-	// profiler, and a pool's shared-module handshake.
-	if i.done == nil && i.gas < 0 && i.hook == nil && i.profiler == nil && i.cache == nil {
+	// profiler, a pool's shared-module handshake, and a scheduler's slice.
+	if i.done == nil && (i.fuel < 0 || i.costs != nil) && i.hook == nil && i.profiler == nil && i.cache == nil && i.slice == nil {
 		for f.ip < len(code) {
 			code[f.ip](i)
 			f = i.fr
@@ -865,16 +1126,62 @@ func (i *Interpreter) dispatch() (caught bool, err error) {
 }
 
 func (i *Interpreter) invoke(ctx context.Context, val types.Value, params []types.Boxed) (returns []types.Boxed, err error) {
-	if i.ctx != nil || i.fp != 1 {
+	if i.ctx != nil || i.fp != 1 || i.waiting != nil {
+		for _, param := range params {
+			i.releaseBox(param)
+		}
 		return nil, ErrInterpreterBusy
 	}
+	base := i.sp
+	saved := *i.fr
+	if err := i.enter(val, params); err != nil {
+		return nil, err
+	}
+	i.nested = true
+	defer func() {
+		i.nested = false
+		if err != nil {
+			for i.fp > 1 {
+				f := &i.frames[i.fp-1]
+				if f.release {
+					i.release(f.ref)
+				}
+				i.fp--
+			}
+			for _, value := range i.stack[base:i.sp] {
+				i.releaseBox(value)
+			}
+		}
+		i.sp = base
+		i.fr = &i.frames[0]
+		*i.fr = saved
+	}()
+
+	if err = i.Run(ctx); err != nil {
+		return nil, err
+	}
+	returns = append([]types.Boxed(nil), i.stack[base:i.sp]...)
+	return returns, nil
+}
+
+// enter pushes params and the callee val above the stack top and points the
+// top frame at a trampoline that calls it, so the next Run makes the call and
+// leaves its results where params were. It consumes params even when it fails.
+func (i *Interpreter) enter(val types.Value, params []types.Boxed) (err error) {
+	defer func() {
+		if err != nil {
+			for _, param := range params {
+				i.releaseBox(param)
+			}
+		}
+	}()
 	target, ok := i.callable(val)
 	if !ok {
-		return nil, ErrTypeMismatch
+		return ErrTypeMismatch
 	}
 	base := i.sp
 	if base+len(params)+1 > len(i.stack) {
-		return nil, ErrStackOverflow
+		return ErrStackOverflow
 	}
 	copy(i.stack[base:], params)
 	i.sp += len(params)
@@ -892,44 +1199,105 @@ func (i *Interpreter) invoke(ctx context.Context, val types.Value, params []type
 			i.retain(addr)
 			break
 		}
-		addr, err = i.Alloc(target)
-		if err != nil {
+		if addr, err = i.Alloc(target); err != nil {
 			i.sp = base
-			return nil, err
+			return err
 		}
 	}
 	i.stack[i.sp] = types.BoxRef(addr)
 	i.sp++
 
-	saved := *i.fr
-	defer func() {
-		if err != nil {
-			for i.fp > 1 {
-				f := &i.frames[i.fp-1]
-				if f.release {
-					i.release(f.ref)
-				}
-				i.fp--
-			}
-			for _, value := range i.stack[base:i.sp] {
-				i.releaseBox(value)
-			}
-		}
-		i.sp = base
-		i.fr = &i.frames[0]
-		*i.fr = saved
-	}()
-
 	// The trampoline runs one CALL and nothing else, so it needs no program
 	// context - but it must still count the callee it dispatches, or a function
 	// only ever reached from a host callback never becomes hot.
 	i.fr.code = []func(*Interpreter){threaded[instr.CALL](&threader{entry: (*Interpreter).entered})}
 	i.fr.ip = 0
-	if err = i.Run(ctx); err != nil {
-		return nil, err
+	return nil
+}
+
+// admit checks the static bounds in s against a stack of stack slots and
+// frames frames.
+func admit(s *program.Stats, stack, frames int) error {
+	check := func(err error, bound, limit int) error {
+		if bound == program.Unbounded {
+			return fmt.Errorf("%w: no static bound", err)
+		}
+		if bound > limit {
+			return fmt.Errorf("%w: static bound %d exceeds %d", err, bound, limit)
+		}
+		return nil
 	}
-	returns = append([]types.Boxed(nil), i.stack[base:i.sp]...)
-	return returns, nil
+	if err := check(ErrFrameOverflow, s.Frames, frames); err != nil {
+		return err
+	}
+	return check(ErrStackOverflow, s.Stack, stack)
+}
+
+// callee resolves the fn argument of Call to the value invoke dispatches.
+func (i *Interpreter) callee(fn any) (types.Value, error) {
+	switch fn := fn.(type) {
+	case string:
+		e, ok := i.prog.Export(fn)
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownExport, fn)
+		}
+		var boxed types.Boxed
+		var err error
+		if e.Kind == program.ExportGlobal {
+			boxed, err = i.Global(e.Index)
+		} else {
+			boxed, err = i.Const(e.Index)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return boxed, nil
+	case int:
+		boxed, err := i.Const(fn)
+		if err != nil {
+			return nil, err
+		}
+		return boxed, nil
+	case types.Ref:
+		return types.BoxRef(int(fn)), nil
+	case types.Value:
+		return fn, nil
+	default:
+		return nil, fmt.Errorf("%w: cannot call %T", ErrTypeMismatch, fn)
+	}
+}
+
+// check reports whether args fit the parameters of the callable val.
+func (i *Interpreter) check(val types.Value, args []types.Boxed) error {
+	target, ok := i.callable(val)
+	if !ok {
+		return ErrTypeMismatch
+	}
+	typ, ok := target.Type().(*types.FunctionType)
+	if !ok {
+		return ErrTypeMismatch
+	}
+	if len(args) != len(typ.Params) {
+		return fmt.Errorf("%w: got %d args, want %d", ErrTypeMismatch, len(args), len(typ.Params))
+	}
+	for idx, arg := range args {
+		param := typ.Params[idx]
+		if arg == types.BoxedNull && param.Kind() == types.KindRef {
+			continue
+		}
+		actual := arg.Type()
+		if arg.Kind() == types.KindRef {
+			v, err := i.Load(arg.Ref())
+			if err != nil {
+				return fmt.Errorf("arg %d: %w", idx, err)
+			}
+			actual = v.Type()
+		}
+		if actual == nil || !param.Cast(actual) {
+			return fmt.Errorf("%w: arg %d is %v, want %s", ErrTypeMismatch, idx, actual, param)
+		}
+	}
+	return nil
 }
 
 func (i *Interpreter) callable(val types.Value) (types.Value, bool) {
@@ -1082,11 +1450,16 @@ func (i *Interpreter) safepoint() error {
 		}
 	}
 
-	if i.gas >= 0 {
-		if i.gas == 0 {
+	if i.slice != nil && i.slice.over(i) {
+		return errPreempted
+	}
+
+	if i.fuel >= 0 && i.costs == nil {
+		if i.tank <= 0 {
 			return ErrFuelExhausted
 		}
-		i.gas--
+		i.tank -= int64(i.tick)
+		i.spent += uint64(i.tick)
 	}
 
 	f := i.fr
@@ -1324,6 +1697,7 @@ func (i *Interpreter) call(root anchor, entry native, stats counters, wd *watchd
 			if err := entry.callable.Call(ctx); err != nil {
 				panic(err)
 			}
+			i.settle()
 
 			if i.journal[journalTrap] == trapNone {
 				i.popFrame()
@@ -1432,6 +1806,7 @@ func (i *Interpreter) start(root anchor, entry native, stats counters, wd *watch
 			if err := entry.callable.Call(ctx); err != nil {
 				panic(err)
 			}
+			i.settle()
 
 			i.sp = int(i.journal[journalSP])
 			if i.journal[journalTrap] == trapNone {
@@ -1490,6 +1865,7 @@ func (i *Interpreter) loop(root anchor, entry native, stats counters, wd *watchd
 			if err := entry.callable.Call(ctx); err != nil {
 				panic(err)
 			}
+			i.settle()
 			i.sp = int(i.journal[journalSP])
 			if i.journal[journalTrap] == trapNone {
 				if root.addr == 0 {
@@ -1679,11 +2055,49 @@ func (i *Interpreter) function(addr int) (*types.Function, bool) {
 	return fn, ok
 }
 
-// sample records one profile hit for the frame's current instruction. It feeds
-// the user's profiler only; tiering up is driven by the entry and back-edge
-// hooks (see entered and backedge).
+// symbols returns a profile symbol lookup over the debug info of the module
+// and its function constants, or nil when none has any. The lookup holds the
+// debug tables only, so a profiler outliving the interpreter keeps nothing
+// else alive.
+func (i *Interpreter) symbols() func(fn, ip int) (prof.Symbol, bool) {
+	debug := map[int]*types.Debug{}
+	for addr := range i.instrs {
+		if d := i.Debug(addr); d != nil {
+			debug[addr] = d
+		}
+	}
+	if len(debug) == 0 {
+		return nil
+	}
+	return func(fn, ip int) (prof.Symbol, bool) {
+		d := debug[fn]
+		if d == nil {
+			return prof.Symbol{}, false
+		}
+		pos, _ := d.Position(ip)
+		return prof.Symbol{Name: d.Name, File: pos.File, Line: pos.Line}, d.Name != "" || pos.IsValid()
+	}
+}
+
+// sample records one profile hit for the frame's current instruction and the
+// call stack above it. It feeds the user's profiler only; tiering up is driven
+// by the entry and back-edge hooks (see entered and backedge).
 func (i *Interpreter) sample(f *frame) {
 	i.samples.Add(f.addr, f.ip, i.instrs[f.addr][f.ip])
+
+	// A caller's IP already points past its CALL, so each caller frame is
+	// reported at the call site itself.
+	stack := append(i.unwound[:0], prof.Frame{Module: i.symtab, Func: f.addr, IP: f.ip, Op: i.instrs[f.addr][f.ip]})
+	for k := i.fp - 2; k >= 0; k-- {
+		caller := &i.frames[k]
+		ip := caller.ip - 1
+		if caller.addr < 0 || caller.addr >= len(i.instrs) || ip < 0 || ip >= len(i.instrs[caller.addr]) {
+			continue
+		}
+		stack = append(stack, prof.Frame{Module: i.symtab, Func: caller.addr, IP: ip, Op: i.instrs[caller.addr][ip]})
+	}
+	i.unwound = stack
+	i.samples.AddStack(stack)
 }
 
 // cool permanently stops instrumenting addr once every compilation root has
@@ -1757,7 +2171,7 @@ func (i *Interpreter) rethread(addr int, backedge bool) {
 	}
 	c := i.threader(backedge)
 	installed := i.code[addr]
-	compiled := c.Compile(fn.Code, fn.Slots(), fn.Declared(), types.Kinds(fn.Captures), fn.Captures)
+	compiled := i.meter(fn.Code, c.Compile(fn.Code, fn.Slots(), fn.Declared(), types.Kinds(fn.Captures), fn.Captures))
 	// Rethreading replaces only interpreted handlers. Installed native entries
 	// stay live while their saved fallbacks advance to the rebuilt table.
 	for root := range i.exits {
@@ -1889,6 +2303,7 @@ func (i *Interpreter) journalPtr() unsafe.Pointer {
 	i.journal[journalEntry] = 0
 	i.journal[journalBudget] = uint64(i.tick)
 	i.journal[journalActive] = 0
+	i.journal[journalFuel] = uint64(i.tank)
 	return unsafe.Pointer(&i.journal[0])
 }
 
@@ -1994,7 +2409,14 @@ func (i *Interpreter) discard(f *frame) {
 
 // wrap allocates a heap Error wrapping a Go failure so a recovered trap or
 // host error becomes a catchable guest value while staying errors.Is/As aware.
+// It allocates outside the heap and memory limits: it runs inside dispatch's
+// recover, where a trap of its own would escape to the host. The Error's null
+// payload is a counted ref like any other, so it is retained here.
 func (i *Interpreter) wrap(err error) types.Boxed {
+	limit, memory := i.limit, i.maxMemory
+	i.limit, i.maxMemory = 0, 0
+	defer func() { i.limit, i.maxMemory = limit, memory }()
+	i.retain(0)
 	return types.BoxRef(i.alloc(types.WrapError(ErrorCode(err), err)))
 }
 
@@ -2031,7 +2453,18 @@ func (i *Interpreter) stacktrace() []FrameInfo {
 	frames := make([]FrameInfo, 0, i.fp)
 	for idx := i.fp - 1; idx >= 0; idx-- {
 		f := i.frames[idx]
-		frames = append(frames, FrameInfo{Func: f.addr, IP: f.ip})
+		info := FrameInfo{Func: f.addr, IP: f.ip}
+		if d := i.Debug(f.addr); d != nil {
+			// A caller's IP already points past its CALL, so its position is
+			// looked up at the call site itself.
+			ip := f.ip
+			if idx < i.fp-1 {
+				ip--
+			}
+			info.Name = d.Name
+			info.Pos, _ = d.Position(ip)
+		}
+		frames = append(frames, info)
 	}
 	return frames
 }
@@ -2375,12 +2808,15 @@ func (i *Interpreter) unbox(val types.Boxed) types.Value {
 }
 
 func (i *Interpreter) alloc(val types.Value) int {
+	size := sizeof(val)
 	collected := i.target > 0 && len(i.heap)-len(i.free) >= i.target
 	if collected {
 		i.gc()
 	}
+	i.reserve(size)
 	if addr, ok := i.reuse(val); ok {
 		i.track(val)
+		i.charge(addr, size)
 		return addr
 	}
 
@@ -2390,6 +2826,7 @@ func (i *Interpreter) alloc(val types.Value) int {
 		i.gc()
 		if addr, ok := i.reuse(val); ok {
 			i.track(val)
+			i.charge(addr, size)
 			return addr
 		}
 	}
@@ -2409,14 +2846,176 @@ func (i *Interpreter) alloc(val types.Value) int {
 		rc := make([]int, len(i.rc), c)
 		copy(rc, i.rc)
 		i.rc = rc
+
+		sizes := make([]int, len(i.sizes), c)
+		copy(sizes, i.sizes)
+		i.sizes = sizes
 	}
 
 	i.heap = append(i.heap, val)
 	i.rc = append(i.rc, 1)
+	i.sizes = append(i.sizes, 0)
 	i.track(val)
+	i.charge(len(i.heap)-1, size)
 	return len(i.heap) - 1
 }
 
+// reserve makes room for n more bytes under the memory limit, collecting once
+// before it gives up. Opcodes that size a value from an operand call it before
+// building the value, so an oversized request traps before the Go runtime is
+// asked for the memory.
+func (i *Interpreter) reserve(n int) {
+	if i.maxMemory <= 0 || i.memory+n <= i.maxMemory {
+		return
+	}
+	i.gc()
+	if i.memory+n > i.maxMemory {
+		panic(ErrHeapExhausted)
+	}
+}
+
+// resize recharges addr after an opcode grew or shrank its value in place, and
+// reports whether growth left memory within the limit, collecting once before
+// it gives up. A caller that gets false undoes the growth, resizes again, and
+// traps, so the operands it moved are still the stack's to release.
+func (i *Interpreter) resize(addr int) bool {
+	size := sizeof(i.heap[addr])
+	grown := size > i.sizes[addr]
+	i.charge(addr, size)
+	if grown && i.maxMemory > 0 && i.memory > i.maxMemory {
+		i.gc()
+		return i.memory <= i.maxMemory
+	}
+	return true
+}
+
+// unset removes key from the map at addr without releasing it or its value,
+// undoing a MAP_SET that inserted it.
+func (i *Interpreter) unset(addr int, key types.Boxed) {
+	switch m := i.heap[addr].(type) {
+	case *types.TypedMap[bool]:
+		m.Delete(key.Bool())
+	case *types.TypedMap[int8]:
+		m.Delete(key.I8())
+	case *types.TypedMap[int32]:
+		m.Delete(key.I32())
+	case *types.TypedMap[int64]:
+		m.Delete(i.unboxI64(key))
+	case *types.TypedMap[float32]:
+		m.Delete(key.F32())
+	case *types.TypedMap[float64]:
+		m.Delete(key.F64())
+	case *types.TypedMap[string]:
+		m.Delete(string(unboxRef[types.String](i, key)))
+	case *types.Map:
+		k, _ := i.mapKey(key)
+		m.Delete(k)
+	}
+}
+
+// grow makes room in s for n more elements, reserving the capacity it adds
+// first, so an append that does not fit traps before anything moves.
+func grow[T any](i *Interpreter, s []T, n int) []T {
+	if cap(s)-len(s) >= n {
+		return s
+	}
+	g := slices.Grow(s, n)
+	i.reserve(elemBytes[T](cap(g) - cap(s)))
+	return g
+}
+
+func (i *Interpreter) charge(addr, size int) {
+	if i.costs != nil {
+		i.owe(i.costs.bytes(size - i.sizes[addr]))
+	}
+	i.memory += size - i.sizes[addr]
+	i.sizes[addr] = size
+	i.peak = max(i.peak, i.memory)
+}
+
+// measure recharges every live slot from scratch after the heap was rebuilt
+// wholesale.
+func (i *Interpreter) measure() {
+	i.sizes = slices.Grow(i.sizes[:0], len(i.heap))[:len(i.heap)]
+	i.memory = 0
+	for addr, val := range i.heap {
+		size := 0
+		if i.rc[addr] > 0 {
+			size = sizeof(val)
+		}
+		i.sizes[addr] = size
+		i.memory += size
+	}
+	i.peak = max(i.peak, i.memory)
+}
+
+// sizeof approximates the bytes v keeps reachable: its header plus the storage
+// behind it. Slices are measured by capacity, since that is what the runtime
+// reserved, and maps by entry. Strings a map holds as keys and memory a host
+// value owns are not counted.
+func sizeof(v types.Value) int {
+	switch v := v.(type) {
+	case nil:
+		return 0
+	case types.String:
+		return slotBytes + len(v)
+	case types.TypedArray[bool]:
+		return slotBytes + elemBytes[bool](cap(v))
+	case types.TypedArray[int8]:
+		return slotBytes + elemBytes[int8](cap(v))
+	case types.TypedArray[int32]:
+		return slotBytes + elemBytes[int32](cap(v))
+	case types.TypedArray[int64]:
+		return slotBytes + elemBytes[int64](cap(v))
+	case types.TypedArray[float32]:
+		return slotBytes + elemBytes[float32](cap(v))
+	case types.TypedArray[float64]:
+		return slotBytes + elemBytes[float64](cap(v))
+	case *types.Array:
+		return slotBytes + int(unsafe.Sizeof(*v)) + elemBytes[types.Boxed](cap(v.Elems))
+	case *types.Struct:
+		size := slotBytes + int(unsafe.Sizeof(*v))
+		if len(v.Data) > 4 {
+			size += elemBytes[uint64](cap(v.Data))
+		}
+		return size
+	case *types.Closure:
+		return slotBytes + int(unsafe.Sizeof(*v)) + elemBytes[types.Boxed](cap(v.Upvals))
+	case *types.TypedMap[bool]:
+		return slotBytes + mapBytes(v)
+	case *types.TypedMap[int8]:
+		return slotBytes + mapBytes(v)
+	case *types.TypedMap[int32]:
+		return slotBytes + mapBytes(v)
+	case *types.TypedMap[int64]:
+		return slotBytes + mapBytes(v)
+	case *types.TypedMap[float32]:
+		return slotBytes + mapBytes(v)
+	case *types.TypedMap[float64]:
+		return slotBytes + mapBytes(v)
+	case *types.TypedMap[string]:
+		return slotBytes + mapBytes(v)
+	case *types.Map:
+		return slotBytes + int(unsafe.Sizeof(*v)) + v.Len()*int(unsafe.Sizeof(types.MapKey{})+unsafe.Sizeof(types.MapEntry{}))
+	default:
+		return slotBytes
+	}
+}
+
+// slotBytes is what every occupied slot costs before its payload: the value
+// interface, its reference count, and its charged size.
+const slotBytes = int(unsafe.Sizeof(types.Value(nil)) + 2*unsafe.Sizeof(0))
+
+func elemBytes[T any](n int) int {
+	var zero T
+	return n * int(unsafe.Sizeof(zero))
+}
+
+func mapBytes[K comparable](m *types.TypedMap[K]) int {
+	var key K
+	return int(unsafe.Sizeof(*m)) + m.Len()*int(unsafe.Sizeof(key)+unsafe.Sizeof(types.Boxed(0)))
+}
+
 func (i *Interpreter) track(v types.Value) {
 	switch v := v.(type) {
 	case *types.Struct:
@@ -2511,7 +3110,7 @@ func (i *Interpreter) bind(addr int, fn *types.Function, dynamic bool) {
 	}
 	i.instrs[addr] = fn.Code
 	i.handlers[addr] = fn.Handlers
-	i.code[addr] = c.Compile(fn.Code, fn.Slots(), fn.Declared(), types.Kinds(fn.Captures), fn.Captures)
+	i.code[addr] = i.meter(fn.Code, c.Compile(fn.Code, fn.Slots(), fn.Declared(), types.Kinds(fn.Captures), fn.Captures))
 	if dynamic {
 		i.dynamic[addr] = true
 	}
@@ -2562,7 +3161,7 @@ func (i *Interpreter) threader(backedge bool) *threader {
 		coros:       i.coros,
 		globals:     i.globalDecls(),
 		globalTypes: i.globalTypes,
-		exact:       i.tick == 1,
+		exact:       i.tick == 1 || i.costs != nil,
 		entry:       (*Interpreter).entered,
 	}
 	if backedge {
@@ -2845,6 +3444,7 @@ func (i *Interpreter) reclaim(addr int, v types.Value) {
 		i.arrays.remove()
 	}
 	i.heap[addr] = nil
+	i.charge(addr, 0)
 	i.free = append(i.free, addr)
 }
 
@@ -2919,3 +3519,33 @@ func unboxRef[T types.Value](i *Interpreter, val types.Boxed) T {
 	i.release(addr)
 	return v
 }
+
+// link returns prog's constant pool with every import's declaration replaced
+// by the host function that satisfies it, or by a stub that reports why none
+// does. prog itself is left untouched so pooled interpreters can share it.
+func link(prog *program.Program, hosts map[string]*HostFunction) []types.Value {
+	if len(prog.Imports) == 0 {
+		return prog.Constants
+	}
+	constants := slices.Clone(prog.Constants)
+	for _, imp := range prog.Imports {
+		if imp.Const < 0 || imp.Const >= len(constants) || imp.Typ == nil {
+			continue
+		}
+		fn, ok := hosts[imp.Name]
+		var err error
+		switch {
+		case !ok || fn == nil:
+			err = fmt.Errorf("%w: %q", ErrUnresolvedImport, imp.Name)
+		case fn.Typ == nil || !fn.Typ.Equals(imp.Typ):
+			err = fmt.Errorf("%w: %q: host is %v, import wants %s", ErrUnresolvedImport, imp.Name, fn.Typ, imp.Typ)
+		}
+		if err != nil {
+			fn = NewHostFunction(imp.Typ, func(*Interpreter, []types.Boxed) ([]types.Boxed, error) {
+				return nil, err
+			})
+		}
+		constants[imp.Const] = fn
+	}
+	return constants
+}
@@ -2005,6 +2005,36 @@ func TestInterpreter_Run(t *testing.T) {
 		require.NoError(t, i.Run(context.Background()))
 	})
 
+	t.Run("dropping a caught trap keeps the null slot alive", func(t *testing.T) {
+		// A wrapped trap holds a null payload; dropping it releases that ref, so
+		// the wrap must have retained it or slot 0 is reclaimed and reused.
+		b := program.NewBuilder()
+		loop, done := b.Label(), b.Label()
+		start, end, catch, next := b.Label(), b.Label(), b.Label(), b.Label()
+		b.Locals(types.TypeI32)
+		b.Emit(instr.I32_CONST, 0).Emit(instr.LOCAL_SET, 0)
+		b.Bind(loop)
+		b.Emit(instr.LOCAL_GET, 0).Emit(instr.I32_CONST, 4*heapRunway).Emit(instr.I32_GE_S).BrIf(done)
+		b.Bind(start).Emit(instr.I32_CONST, 1).Emit(instr.I32_CONST, 0).Emit(instr.I32_DIV_S).Emit(instr.DROP)
+		b.Bind(end).Br(next)
+		b.Bind(catch).Emit(instr.DROP)
+		b.Bind(next)
+		b.Emit(instr.LOCAL_GET, 0).Emit(instr.I32_CONST, 1).Emit(instr.I32_ADD).Emit(instr.LOCAL_SET, 0)
+		b.Br(loop)
+		b.Bind(done)
+		b.Try(start, end, catch, 1)
+		prog, err := b.Build()
+		require.NoError(t, err)
+		require.NoError(t, program.Verify(prog))
+
+		i := New(prog, WithHeapLimit(heapRunway))
+		defer i.Close()
+
+		require.NoError(t, i.Run(context.Background()))
+		require.True(t, i.alive(0))
+		require.Equal(t, types.Null, i.heap[0])
+	})
+
 	t.Run("string.concat reads the result after releasing both last operand references", func(t *testing.T) {
 		prog := program.New([]instr.Instruction{instr.New(instr.STRING_CONCAT)})
 		i := New(prog, WithThreshold(-1))
@@ -2347,6 +2377,7 @@ func TestInterpreter_Run(t *testing.T) {
 	}{
 		{name: "standalone", opts: []func(*option){WithTick(1), WithThreshold(-1)}},
 		{name: "fused", opts: []func(*option){WithThreshold(-1)}},
+		{name: "jit", opts: []func(*option){WithTick(1), WithThreshold(1)}},
 	}
 	for _, tt := range runTests {
 		for _, mode := range modes {
@@ -4035,6 +4066,199 @@ func TestInterpreter_Run(t *testing.T) {
 	})
 }
 
+func TestInterpreter_Call(t *testing.T) {
+	add := types.NewFunctionBuilder(&types.FunctionType{Params: []types.Type{types.TypeI32, types.TypeI32}, Returns: []types.Type{types.TypeI32}}).
+		Emit(instr.New(instr.LOCAL_GET, 0), instr.New(instr.LOCAL_GET, 1), instr.New(instr.I32_ADD), instr.New(instr.RETURN)).
+		MustBuild()
+
+	t.Run("constant index", func(t *testing.T) {
+		i := New(program.New(nil, program.WithConstants(add)))
+		defer i.Close()
+
+		returns, err := i.Call(context.Background(), 0, types.BoxI32(2), types.BoxI32(3))
+		require.NoError(t, err)
+		require.Equal(t, []types.Boxed{types.BoxI32(5)}, returns)
+	})
+
+	t.Run("heap ref", func(t *testing.T) {
+		i := New(program.New(nil, program.WithConstants(add)))
+		defer i.Close()
+
+		fn, err := i.Const(0)
+		require.NoError(t, err)
+		returns, err := i.Call(context.Background(), types.Ref(fn.Ref()), types.BoxI32(4), types.BoxI32(5))
+		require.NoError(t, err)
+		require.Equal(t, []types.Boxed{types.BoxI32(9)}, returns)
+
+		returns, err = i.Call(context.Background(), fn, types.BoxI32(1), types.BoxI32(1))
+		require.NoError(t, err)
+		require.Equal(t, []types.Boxed{types.BoxI32(2)}, returns)
+	})
+
+	t.Run("host function", func(t *testing.T) {
+		i := New(program.New(nil))
+		defer i.Close()
+
+		double := NewHostFunction(&types.FunctionType{Params: []types.Type{types.TypeI64}, Returns: []types.Type{types.TypeI64}}, func(_ *Interpreter, params []types.Boxed) ([]types.Boxed, error) {
+			return []types.Boxed{types.BoxI64(params[0].I64() * 2)}, nil
+		})
+		returns, err := i.Call(context.Background(), double, types.BoxI64(21))
+		require.NoError(t, err)
+		require.Equal(t, []types.Boxed{types.BoxI64(42)}, returns)
+	})
+
+	t.Run("repeated entry points after run", func(t *testing.T) {
+		sub := types.NewFunctionBuilder(&types.FunctionType{Params: []types.Type{types.TypeI32, types.TypeI32}, Returns: []types.Type{types.TypeI32}}).
+			Emit(instr.New(instr.LOCAL_GET, 0), instr.New(instr.LOCAL_GET, 1), instr.New(instr.I32_SUB), instr.New(instr.RETURN)).
+			MustBuild()
+		i := New(program.New([]instr.Instruction{instr.New(instr.NOP)}, program.WithConstants(add, sub)))
+		defer i.Close()
+
+		require.NoError(t, i.Run(context.Background()))
+		for n := range 100 {
+			returns, err := i.Call(context.Background(), n%2, types.BoxI32(10), types.BoxI32(4))
+			require.NoError(t, err)
+			require.Equal(t, []types.Boxed{types.BoxI32([]int32{14, 6}[n%2])}, returns)
+		}
+	})
+
+	t.Run("export name", func(t *testing.T) {
+		i := New(program.New(nil,
+			program.WithConstants(add),
+			program.WithExports(program.Export{Name: "add", Kind: program.ExportFunction, Index: 0}),
+		))
+		defer i.Close()
+
+		returns, err := i.Call(context.Background(), "add", types.BoxI32(2), types.BoxI32(3))
+		require.NoError(t, err)
+		require.Equal(t, []types.Boxed{types.BoxI32(5)}, returns)
+	})
+
+	t.Run("exported global", func(t *testing.T) {
+		i := New(program.New(nil,
+			program.WithConstants(add),
+			program.WithGlobals(types.TypeAny),
+			program.WithExports(program.Export{Name: "op", Kind: program.ExportGlobal, Index: 0}),
+		))
+		defer i.Close()
+
+		fn, err := i.Const(0)
+		require.NoError(t, err)
+		_, err = i.Retain(fn.Ref())
+		require.NoError(t, err)
+		require.NoError(t, i.SetGlobal(0, fn))
+
+		returns, err := i.Call(context.Background(), "op", types.BoxI32(7), types.BoxI32(8))
+		require.NoError(t, err)
+		require.Equal(t, []types.Boxed{types.BoxI32(15)}, returns)
+	})
+
+	t.Run("rejects unknown export", func(t *testing.T) {
+		i := New(program.New(nil))
+		defer i.Close()
+
+		_, err := i.Call(context.Background(), "missing", types.BoxI32(1))
+		require.ErrorIs(t, err, ErrUnknownExport)
+	})
+
+	t.Run("rejects wrong arg count", func(t *testing.T) {
+		i := New(program.New(nil, program.WithConstants(add)))
+		defer i.Close()
+
+		_, err := i.Call(context.Background(), 0, types.BoxI32(1))
+		require.ErrorIs(t, err, ErrTypeMismatch)
+	})
+
+	t.Run("rejects wrong arg type", func(t *testing.T) {
+		i := New(program.New(nil, program.WithConstants(add)))
+		defer i.Close()
+
+		_, err := i.Call(context.Background(), 0, types.BoxI32(1), types.BoxF64(1))
+		require.ErrorIs(t, err, ErrTypeMismatch)
+	})
+
+	t.Run("rejects non callable", func(t *testing.T) {
+		i := New(program.New(nil, program.WithConstants(types.I32(1))))
+		defer i.Close()
+
+		_, err := i.Call(context.Background(), 0)
+		require.ErrorIs(t, err, ErrTypeMismatch)
+	})
+
+	t.Run("rejects unknown constant", func(t *testing.T) {
+		i := New(program.New(nil))
+		defer i.Close()
+
+		_, err := i.Call(context.Background(), 3)
+		require.ErrorIs(t, err, ErrSegmentationFault)
+	})
+
+	t.Run("releases args on failure", func(t *testing.T) {
+		takes := types.NewFunctionBuilder(&types.FunctionType{Params: []types.Type{types.TypeString}}).
+			Emit(instr.New(instr.RETURN)).
+			MustBuild()
+		i := New(program.New(nil, program.WithConstants(takes)))
+		defer i.Close()
+
+		addr, err := i.Alloc(types.String("x"))
+		require.NoError(t, err)
+		_, err = i.Call(context.Background(), 0, types.BoxRef(addr), types.BoxI32(1))
+		require.ErrorIs(t, err, ErrTypeMismatch)
+		_, err = i.Load(addr)
+		require.ErrorIs(t, err, ErrSegmentationFault)
+	})
+
+	t.Run("releases args on stack overflow", func(t *testing.T) {
+		takes := types.NewFunctionBuilder(&types.FunctionType{Params: []types.Type{types.TypeString}}).
+			Emit(instr.New(instr.RETURN)).
+			MustBuild()
+		i := New(program.New(nil, program.WithConstants(takes)), WithStack(1))
+		defer i.Close()
+
+		addr, err := i.Alloc(types.String("x"))
+		require.NoError(t, err)
+		_, err = i.Call(context.Background(), 0, types.BoxRef(addr))
+		require.ErrorIs(t, err, ErrStackOverflow)
+		_, err = i.Load(addr)
+		require.ErrorIs(t, err, ErrSegmentationFault)
+	})
+
+	t.Run("releases args when busy", func(t *testing.T) {
+		takes := types.NewFunctionBuilder(&types.FunctionType{Params: []types.Type{types.TypeString}}).
+			Emit(instr.New(instr.RETURN)).
+			MustBuild()
+		var inner, load error
+		host := NewHostFunction(&types.FunctionType{}, func(i *Interpreter, _ []types.Boxed) ([]types.Boxed, error) {
+			addr, err := i.Alloc(types.String("x"))
+			if err != nil {
+				return nil, err
+			}
+			_, inner = i.Call(context.Background(), 0, types.BoxRef(addr))
+			_, load = i.Load(addr)
+			return nil, nil
+		})
+		i := New(program.New([]instr.Instruction{instr.New(instr.CONST_GET, 1), instr.New(instr.CALL)}, program.WithConstants(takes, host)))
+		defer i.Close()
+
+		require.NoError(t, i.Run(context.Background()))
+		require.ErrorIs(t, inner, ErrInterpreterBusy)
+		require.ErrorIs(t, load, ErrSegmentationFault)
+	})
+
+	t.Run("busy during host callback", func(t *testing.T) {
+		var inner error
+		host := NewHostFunction(&types.FunctionType{}, func(i *Interpreter, _ []types.Boxed) ([]types.Boxed, error) {
+			_, inner = i.Call(context.Background(), 0, types.BoxI32(1), types.BoxI32(2))
+			return nil, nil
+		})
+		i := New(program.New([]instr.Instruction{instr.New(instr.CONST_GET, 1), instr.New(instr.CALL)}, program.WithConstants(add, host)))
+		defer i.Close()
+
+		require.NoError(t, i.Run(context.Background()))
+		require.ErrorIs(t, inner, ErrInterpreterBusy)
+	})
+}
+
 func TestInterpreter_Marshal(t *testing.T) {
 	// Marshal forwards to the installed codec, so the conversion contract is
 	// owned by TestRegistry_Marshal and only the delegation is checked here.
@@ -4107,6 +4331,17 @@ func TestInterpreter_Opcode(t *testing.T) {
 	require.Equal(t, instr.NOP, op)
 }
 
+func TestInterpreter_Instruction(t *testing.T) {
+	prog := program.New([]instr.Instruction{instr.New(instr.I32_CONST, 1), instr.New(instr.YIELD), instr.New(instr.GLOBAL_SET, 1)}, program.WithGlobals(types.TypeI32, types.TypeI32))
+	i := New(prog)
+	defer i.Close()
+
+	require.ErrorIs(t, i.Run(context.Background()), ErrYield)
+	inst, err := i.Instruction()
+	require.NoError(t, err)
+	require.Equal(t, instr.New(instr.GLOBAL_SET, 1), inst)
+}
+
 func TestInterpreter_Frame(t *testing.T) {
 	prog := program.New([]instr.Instruction{instr.New(instr.I32_CONST, 1), instr.New(instr.YIELD), instr.New(instr.NOP)})
 	i := New(prog)
@@ -4120,6 +4355,49 @@ func TestInterpreter_Frame(t *testing.T) {
 	require.Equal(t, 0, bp)
 }
 
+func TestInterpreter_Debug(t *testing.T) {
+	fn := types.NewFunctionBuilder(&types.FunctionType{Returns: []types.Type{types.TypeI32}}).
+		Emit(instr.New(instr.I32_CONST, 1), instr.New(instr.I32_CONST, 0), instr.New(instr.I32_DIV_S), instr.New(instr.RETURN)).
+		MustBuild()
+	fn.Debug = &types.Debug{Name: "div", Lines: []types.Line{{IP: 0, Pos: types.Position{File: "lib.mvm", Line: 2, Column: 3}}}}
+	prog := program.New(
+		[]instr.Instruction{instr.New(instr.NOP), instr.New(instr.CONST_GET, 0), instr.New(instr.CALL), instr.New(instr.DROP)},
+		program.WithConstants(fn),
+		program.WithDebug(&program.Debug{Code: &types.Debug{Name: "main", Lines: []types.Line{
+			{IP: 0, Pos: types.Position{File: "main.mvm", Line: 1}},
+			{IP: 1, Pos: types.Position{File: "main.mvm", Line: 4, Column: 9}},
+		}}}),
+	)
+	i := New(prog, WithThreshold(-1))
+	defer i.Close()
+
+	require.Equal(t, "main", i.Debug(0).Name)
+	addr := i.constants[0].Ref()
+	require.Equal(t, "div", i.Debug(addr).Name)
+	require.Nil(t, i.Debug(-1))
+
+	err := i.Run(context.Background())
+	var runtimeErr *RuntimeError
+	require.ErrorAs(t, err, &runtimeErr)
+	require.Equal(t, []FrameInfo{
+		{Func: addr, IP: 10, Name: "div", Pos: types.Position{File: "lib.mvm", Line: 2, Column: 3}},
+		{Func: 0, IP: 5, Name: "main", Pos: types.Position{File: "main.mvm", Line: 4, Column: 9}},
+	}, runtimeErr.Frames)
+}
+
+func TestInterpreter_GlobalName(t *testing.T) {
+	prog := program.New(nil, program.WithGlobals(types.TypeI32, types.TypeI32), program.WithDebug(&program.Debug{Globals: []string{"count"}}))
+	i := New(prog)
+	defer i.Close()
+
+	require.Equal(t, "count", i.GlobalName(0))
+	require.Equal(t, "", i.GlobalName(1))
+
+	bare := New(program.New(nil, program.WithGlobals(types.TypeI32)))
+	defer bare.Close()
+	require.Equal(t, "", bare.GlobalName(0))
+}
+
 func TestInterpreter_Const(t *testing.T) {
 	i := New(program.New(nil, program.WithConstants(types.I32(9))))
 	defer i.Close()
@@ -4289,6 +4567,47 @@ func TestInterpreter_Load(t *testing.T) {
 	require.Equal(t, types.I32(5), v)
 }
 
+func TestInterpreter_Lookup(t *testing.T) {
+	t.Run("matches a string key by content", func(t *testing.T) {
+		i := New(program.New(nil))
+		defer i.Close()
+
+		m := types.NewMap(types.NewMapType(types.TypeString, types.TypeI32))
+		m.Set(types.MapKey{Kind: types.KindText, Text: "a"}, types.MapEntry{Value: types.BoxI32(1)})
+
+		addr, err := i.Alloc(types.String("a"))
+		require.NoError(t, err)
+		v, ok := i.Lookup(m, types.BoxRef(addr))
+		require.True(t, ok)
+		require.Equal(t, types.BoxI32(1), v)
+
+		require.NoError(t, i.Release(addr))
+		_, err = i.Load(addr)
+		require.ErrorIs(t, err, ErrSegmentationFault)
+	})
+
+	t.Run("folds negative zero", func(t *testing.T) {
+		i := New(program.New(nil))
+		defer i.Close()
+
+		m := types.NewMap(types.NewMapType(types.TypeF64, types.TypeI32))
+		m.Set(types.MapKey{Kind: types.KindF64}, types.MapEntry{Value: types.BoxI32(1)})
+
+		v, ok := i.Lookup(m, types.BoxF64(math.Copysign(0, -1)))
+		require.True(t, ok)
+		require.Equal(t, types.BoxI32(1), v)
+	})
+
+	t.Run("misses a dead reference", func(t *testing.T) {
+		i := New(program.New(nil))
+		defer i.Close()
+
+		m := types.NewMap(types.NewMapType(types.TypeString, types.TypeI32))
+		_, ok := i.Lookup(m, types.BoxRef(1<<20))
+		require.False(t, ok)
+	})
+}
+
 func TestInterpreter_Store(t *testing.T) {
 	t.Run("replaces scalar", func(t *testing.T) {
 		i := New(program.New(nil))
@@ -4700,6 +5019,25 @@ func TestInterpreter_Len(t *testing.T) {
 	require.Equal(t, 1, i.Len())
 }
 
+func TestInterpreter_MemoryUsage(t *testing.T) {
+	i := New(program.New(nil), WithMemoryLimit(1<<20))
+	defer i.Close()
+
+	before := i.MemoryUsage()
+	require.Equal(t, 1<<20, before.Limit)
+
+	addr, err := i.Alloc(make(types.TypedArray[int64], 1000))
+	require.NoError(t, err)
+	grown := i.MemoryUsage()
+	require.GreaterOrEqual(t, grown.Used-before.Used, 8000)
+	require.Equal(t, before.Slots+1, grown.Slots)
+
+	require.NoError(t, i.Release(addr))
+	released := i.MemoryUsage()
+	require.Equal(t, before.Used, released.Used)
+	require.Equal(t, grown.Used, released.Peak)
+}
+
 func TestInterpreter_Close(t *testing.T) {
 	i := New(program.New(nil))
 	value := &trackedValue{}
@@ -4957,18 +5295,111 @@ func TestWithProfiler(t *testing.T) {
 		require.Equal(t, float64(3), total)
 	})
 
+	t.Run("samples call stacks", func(t *testing.T) {
+		fn, err := types.NewFunctionBuilder(nil).
+			Returns(types.TypeI32).
+			Emit(instr.New(instr.I32_CONST, 1)).
+			Emit(instr.New(instr.I32_CONST, 2)).
+			Emit(instr.New(instr.I32_ADD)).
+			Emit(instr.New(instr.RETURN)).
+			Build()
+		require.NoError(t, err)
+
+		b := program.NewBuilder()
+		b.Const(fn)
+		b.ConstGet(fn).Emit(instr.CALL)
+		prog, err := b.Build()
+		require.NoError(t, err)
+
+		p := prof.New()
+		i := New(prog, WithProfiler(p), WithTick(1), WithThreshold(-1))
+		require.NoError(t, i.Run(context.Background()))
+		require.NoError(t, i.Close())
+
+		var nested []prof.Frame
+		p.Stacks(func(stack []prof.Frame, count uint64) {
+			if len(stack) == 2 && stack[0].Op == byte(instr.I32_ADD) {
+				nested = append(nested, stack...)
+			}
+		})
+		require.Len(t, nested, 2)
+		require.Equal(t, byte(instr.CALL), nested[1].Op)
+		require.Equal(t, 0, nested[1].Func)
+	})
+
+	t.Run("symbolizes debug info", func(t *testing.T) {
+		fn := types.NewFunctionBuilder(nil).
+			Returns(types.TypeI32).
+			Emit(instr.New(instr.I32_CONST, 1), instr.New(instr.RETURN)).
+			MustBuild()
+		fn.Debug = &types.Debug{Name: "one", Lines: []types.Line{{IP: 0, Pos: types.Position{File: "lib.mvm", Line: 7}}}}
+		prog := program.New([]instr.Instruction{instr.New(instr.CONST_GET, 0), instr.New(instr.CALL)}, program.WithConstants(fn))
+
+		i := New(prog, WithProfiler(prof.New()))
+		defer i.Close()
+
+		symbols := i.symbols()
+		require.NotNil(t, symbols)
+		sym, ok := symbols(i.constants[0].Ref(), 5)
+		require.True(t, ok)
+		require.Equal(t, prof.Symbol{Name: "one", File: "lib.mvm", Line: 7}, sym)
+		_, ok = symbols(0, 0)
+		require.False(t, ok)
+
+		bare := New(program.New([]instr.Instruction{instr.New(instr.NOP)}), WithProfiler(prof.New()))
+		defer bare.Close()
+		require.Nil(t, bare.symbols())
+	})
+
+	t.Run("numbers the programs sharing a profiler apart", func(t *testing.T) {
+		build := func(name string) *program.Program {
+			fn := types.NewFunctionBuilder(nil).
+				Returns(types.TypeI32).
+				Emit(instr.New(instr.I32_CONST, 1), instr.New(instr.RETURN)).
+				MustBuild()
+			fn.Debug = &types.Debug{Name: name}
+			return program.New([]instr.Instruction{instr.New(instr.CONST_GET, 0), instr.New(instr.CALL)}, program.WithConstants(fn))
+		}
+		p := prof.New()
+		one, two := build("one"), build("two")
+
+		a := New(one, WithProfiler(p), WithTick(1))
+		defer a.Close()
+		b := New(two, WithProfiler(p), WithTick(1))
+		defer b.Close()
+		again := New(one, WithProfiler(p))
+		defer again.Close()
+
+		require.NotEqual(t, a.symtab, b.symtab)
+		require.Equal(t, a.symtab, again.symtab)
+		require.Equal(t, a.constants[0].Ref(), b.constants[0].Ref())
+
+		require.NoError(t, a.Run(context.Background()))
+		require.NoError(t, b.Run(context.Background()))
+		require.NoError(t, a.Close())
+		require.NoError(t, b.Close())
+
+		modules := map[int]bool{}
+		p.Stacks(func(stack []prof.Frame, _ uint64) {
+			for _, f := range stack {
+				modules[f.Module] = true
+			}
+		})
+		require.Equal(t, map[int]bool{a.symtab: true, b.symtab: true}, modules)
+	})
+
 	t.Run("records compilation and native entry", func(t *testing.T) {
 		p := prof.New()
 		prog := program.New([]instr.Instruction{instr.New(instr.NOP)})
 		i := New(prog, WithProfiler(p), WithTick(1), WithThreshold(0))
 		require.NoError(t, i.Run(context.Background()))
-		if runtime.GOARCH == "arm64" {
+		if nativeBackend {
 			i.Reset()
 			require.NoError(t, i.Run(context.Background()))
 		}
 		require.NoError(t, i.Close())
 
-		if runtime.GOARCH == "arm64" {
+		if nativeBackend {
 			value, ok := p.Metric("vm_jit_compiles_total",
 				prof.Label{Key: "func", Value: "0"}, prof.Label{Key: "ip", Value: "0"},
 				prof.Label{Key: "trigger", Value: "hot"}, prof.Label{Key: "frontend", Value: "static"},
@@ -5365,6 +5796,119 @@ func TestWithStack(t *testing.T) {
 	})
 }
 
+func TestWithStats(t *testing.T) {
+	unary := &types.FunctionType{Params: []types.Type{types.TypeI32}, Returns: []types.Type{types.TypeI32}}
+	inc := types.NewFunctionBuilder(unary).Emit(
+		instr.New(instr.LOCAL_GET, 0),
+		instr.New(instr.I32_CONST, 1),
+		instr.New(instr.I32_ADD),
+		instr.New(instr.RETURN),
+	).MustBuild()
+
+	t.Run("sizes stack and frames to the static bound", func(t *testing.T) {
+		twice := types.NewFunctionBuilder(unary).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.CONST_GET, 1),
+			instr.New(instr.CALL),
+			instr.New(instr.CONST_GET, 1),
+			instr.New(instr.CALL),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+		}, program.WithConstants(twice, inc))
+		stats, err := program.Analyze(prog)
+		require.NoError(t, err)
+
+		i := New(prog, WithStack(1), WithFrame(1), WithStats(stats))
+		defer i.Close()
+
+		require.NoError(t, i.Run(context.Background()))
+		v, err := i.Pop()
+		require.NoError(t, err)
+		require.Equal(t, types.I32(3), v)
+	})
+
+	t.Run("keeps configured sizes when unbounded", func(t *testing.T) {
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.LOCAL_SET, 0),
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.CALL),
+		}, program.WithConstants(inc), program.WithLocals(unary))
+		stats, err := program.Analyze(prog)
+		require.NoError(t, err)
+
+		i := New(prog, WithStats(stats), WithFrame(1))
+		defer i.Close()
+
+		require.ErrorIs(t, i.Run(context.Background()), ErrFrameOverflow)
+	})
+}
+
+func TestWithAdmission(t *testing.T) {
+	unary := &types.FunctionType{Params: []types.Type{types.TypeI32}, Returns: []types.Type{types.TypeI32}}
+	inc := types.NewFunctionBuilder(unary).Emit(
+		instr.New(instr.LOCAL_GET, 0),
+		instr.New(instr.I32_CONST, 1),
+		instr.New(instr.I32_ADD),
+		instr.New(instr.RETURN),
+	).MustBuild()
+
+	t.Run("admits a program within the limits", func(t *testing.T) {
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+		}, program.WithConstants(inc))
+		stats, err := program.Analyze(prog)
+		require.NoError(t, err)
+
+		i := New(prog, WithAdmission(stats), WithStack(stats.Stack), WithFrame(stats.Frames))
+		defer i.Close()
+
+		require.NoError(t, i.Run(context.Background()))
+	})
+
+	t.Run("rejects a stack bound over the limit", func(t *testing.T) {
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+		}, program.WithConstants(inc))
+		stats, err := program.Analyze(prog)
+		require.NoError(t, err)
+
+		i := New(prog, WithAdmission(stats), WithStack(stats.Stack-1))
+		defer i.Close()
+
+		require.ErrorIs(t, i.Run(context.Background()), ErrStackOverflow)
+		require.Equal(t, 0, i.Len())
+	})
+
+	t.Run("rejects recursion", func(t *testing.T) {
+		self := types.NewFunctionBuilder(&types.FunctionType{}).Emit(
+			instr.New(instr.CONST_GET, 0), instr.New(instr.CALL), instr.New(instr.RETURN),
+		).MustBuild()
+		prog := program.New(nil,
+			program.WithConstants(self),
+			program.WithExports(program.Export{Name: "self", Kind: program.ExportFunction, Index: 0}),
+		)
+		stats, err := program.Analyze(prog)
+		require.NoError(t, err)
+
+		i := New(prog, WithAdmission(stats))
+		defer i.Close()
+
+		require.ErrorIs(t, i.Run(context.Background()), ErrFrameOverflow)
+		_, err = i.Call(context.Background(), "self")
+		require.ErrorIs(t, err, ErrFrameOverflow)
+	})
+}
+
 func TestWithHeap(t *testing.T) {
 	t.Run("initial capacity grows", func(t *testing.T) {
 		prog := program.New([]instr.Instruction{
@@ -5541,6 +6085,29 @@ func TestWithHeapLimit(t *testing.T) {
 		require.ErrorIs(t, i.Run(context.Background()), ErrHeapExhausted)
 	})
 
+	t.Run("delivers a trap to a handler at the limit", func(t *testing.T) {
+		b := program.NewBuilder()
+		start, end, catch := b.Label(), b.Label(), b.Label()
+		b.Bind(start).Emit(instr.I32_CONST, 1).Emit(instr.I32_CONST, 0).Emit(instr.I32_DIV_S)
+		b.Bind(end).Emit(instr.RETURN)
+		b.Bind(catch).Emit(instr.ERROR_CODE)
+		b.Try(start, end, catch, 0)
+		prog, err := b.Build()
+		require.NoError(t, err)
+
+		i := New(prog, WithHeapLimit(4))
+		defer i.Close()
+
+		for err == nil {
+			_, err = i.Alloc(types.String("full"))
+		}
+		require.ErrorIs(t, err, ErrHeapExhausted)
+		require.NoError(t, i.Run(context.Background()))
+		code, err := i.Pop()
+		require.NoError(t, err)
+		require.Equal(t, types.I32(ErrorCode(ErrDivideByZero)), code)
+	})
+
 	t.Run("preserves host-owned reference", func(t *testing.T) {
 		i := New(program.New(nil), WithHeap(2), WithHeapLimit(2))
 		defer i.Close()
@@ -5733,6 +6300,108 @@ func TestWithHeapLimit(t *testing.T) {
 	})
 }
 
+func TestWithMemoryLimit(t *testing.T) {
+	t.Run("rejects an oversized array before allocating it", func(t *testing.T) {
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 10_000_000),
+			instr.New(instr.ARRAY_NEW_DEFAULT, 0),
+		}, program.WithTypes(types.TypeI32Array))
+		i := New(prog, WithMemoryLimit(1<<20))
+		defer i.Close()
+
+		require.ErrorIs(t, i.Run(context.Background()), ErrHeapExhausted)
+		require.LessOrEqual(t, i.MemoryUsage().Peak, 1<<20)
+	})
+
+	t.Run("rejects growth past the limit", func(t *testing.T) {
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 0),
+			instr.New(instr.ARRAY_NEW_DEFAULT, 0),
+			instr.New(instr.I32_CONST, 7),
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.ARRAY_APPEND),
+			instr.New(instr.BR, uint64(uint16(-14+1<<16))),
+		}, program.WithTypes(types.TypeI32Array))
+		i := New(prog, WithMemoryLimit(1<<16))
+		defer i.Close()
+
+		require.ErrorIs(t, i.Run(context.Background()), ErrHeapExhausted)
+	})
+
+	t.Run("collects garbage before trapping", func(t *testing.T) {
+		var code []instr.Instruction
+		for range 64 {
+			code = append(code,
+				instr.New(instr.I32_CONST, 1024),
+				instr.New(instr.ARRAY_NEW_DEFAULT, 0),
+				instr.New(instr.DROP),
+			)
+		}
+		prog := program.New(code, program.WithTypes(types.TypeI32Array))
+		i := New(prog, WithMemoryLimit(16<<10))
+		defer i.Close()
+
+		require.NoError(t, i.Run(context.Background()))
+	})
+
+	t.Run("handlers cannot catch exhaustion", func(t *testing.T) {
+		b := program.NewBuilder()
+		typ := b.Type(types.TypeI32Array)
+		start, end, catch, loop := b.Label(), b.Label(), b.Label(), b.Label()
+		b.Emit(instr.I32_CONST, 0).Emit(instr.ARRAY_NEW_DEFAULT, uint64(typ))
+		b.Bind(start).Bind(loop)
+		b.Emit(instr.I32_CONST, 7).Emit(instr.I32_CONST, 1).Emit(instr.ARRAY_APPEND)
+		b.Br(loop)
+		b.Bind(end).Emit(instr.NOP)
+		b.Bind(catch).Emit(instr.DROP).Emit(instr.I32_CONST, 1)
+		b.Try(start, end, catch, 1)
+		prog, err := b.Build()
+		require.NoError(t, err)
+
+		i := New(prog, WithMemoryLimit(1<<16))
+		defer i.Close()
+
+		require.ErrorIs(t, i.Run(context.Background()), ErrHeapExhausted)
+	})
+
+	t.Run("rolls back a map insert that does not fit", func(t *testing.T) {
+		b := program.NewBuilder()
+		m := types.NewMapType(types.TypeI32, types.TypeI32)
+		typ := b.Type(m)
+		b.Locals(types.TypeI32, m)
+		loop := b.Label()
+		b.Emit(instr.I32_CONST, 0).Emit(instr.MAP_NEW_DEFAULT, uint64(typ)).Emit(instr.LOCAL_SET, 1)
+		b.Bind(loop)
+		b.Emit(instr.LOCAL_GET, 1).Emit(instr.LOCAL_GET, 0).Emit(instr.LOCAL_GET, 0).Emit(instr.MAP_SET)
+		b.Emit(instr.LOCAL_GET, 0).Emit(instr.I32_CONST, 1).Emit(instr.I32_ADD).Emit(instr.LOCAL_SET, 0)
+		b.Br(loop)
+		prog, err := b.Build()
+		require.NoError(t, err)
+
+		i := New(prog, WithMemoryLimit(1<<16))
+		defer i.Close()
+
+		require.ErrorIs(t, i.Run(context.Background()), ErrHeapExhausted)
+		_, err = i.Pop()
+		require.NoError(t, err)
+		key, err := i.Pop()
+		require.NoError(t, err)
+		val, err := i.Pop()
+		require.NoError(t, err)
+		require.Equal(t, int(key.(types.I32)), val.(*types.TypedMap[int32]).Len())
+	})
+
+	t.Run("rejects host allocation past the limit", func(t *testing.T) {
+		i := New(program.New(nil), WithMemoryLimit(1<<10))
+		defer i.Close()
+
+		_, err := i.Alloc(types.String(strings.Repeat("x", 1<<10)))
+		require.ErrorIs(t, err, ErrHeapExhausted)
+		_, err = i.Alloc(types.String("fits"))
+		require.NoError(t, err)
+	})
+}
+
 func TestWithTick(t *testing.T) {
 	calls := 0
 	prog := program.New([]instr.Instruction{
@@ -8103,6 +8772,164 @@ func TestWithFuel(t *testing.T) {
 	require.ErrorIs(t, i.Run(context.Background()), ErrFuelExhausted)
 }
 
+func TestWithCosts(t *testing.T) {
+	t.Run("stops on the instruction it cannot pay for", func(t *testing.T) {
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1), instr.New(instr.I32_CONST, 2), instr.New(instr.I32_ADD),
+		})
+		costs := NewCostTable(1)
+		costs.Opcodes[instr.I32_ADD] = 10
+		i := New(prog, WithFuel(5), WithCosts(costs))
+		defer i.Close()
+
+		require.ErrorIs(t, i.Run(context.Background()), ErrFuelExhausted)
+		require.Equal(t, uint64(2), i.FuelConsumed())
+		require.Equal(t, uint64(3), i.Fuel())
+
+		i.Refuel(7)
+		require.NoError(t, i.Run(context.Background()))
+		val, err := i.Pop()
+		require.NoError(t, err)
+		require.Equal(t, types.I32(3), val)
+		require.Equal(t, uint64(12), i.FuelConsumed())
+		require.Zero(t, i.Fuel())
+
+		i.Reset()
+		require.Zero(t, i.FuelConsumed())
+		require.Equal(t, uint64(5), i.Fuel())
+	})
+
+	t.Run("charges host calls", func(t *testing.T) {
+		sig := &types.FunctionType{}
+		fn := NewHostFunction(sig, func(*Interpreter, []types.Boxed) ([]types.Boxed, error) {
+			return nil, nil
+		})
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.CONST_GET, 0), instr.New(instr.CALL),
+			instr.New(instr.CONST_GET, 0), instr.New(instr.CALL),
+		}, program.WithConstants(fn))
+		costs := NewCostTable(1)
+		costs.Hosts = map[*HostFunction]uint64{fn: 100}
+		i := New(prog, WithFuel(50), WithCosts(costs))
+		defer i.Close()
+
+		require.ErrorIs(t, i.Run(context.Background()), ErrFuelExhausted)
+		require.Equal(t, uint64(102), i.FuelConsumed())
+
+		i.Refuel(200)
+		require.NoError(t, i.Run(context.Background()))
+		require.Equal(t, uint64(204), i.FuelConsumed())
+	})
+
+	t.Run("charges heap growth", func(t *testing.T) {
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1), instr.New(instr.REF_NEW),
+		})
+		costs := NewCostTable(0)
+		costs.Byte = 1
+		i := New(prog, WithCosts(costs))
+		defer i.Close()
+
+		before := i.MemoryUsage().Used
+		require.NoError(t, i.Run(context.Background()))
+		require.Equal(t, uint64(i.MemoryUsage().Used-before), i.FuelConsumed())
+		require.NotZero(t, i.FuelConsumed())
+	})
+
+	t.Run("guest handlers cannot catch exhaustion", func(t *testing.T) {
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 99),
+			instr.New(instr.THROW),
+			instr.New(instr.I32_CONST, 0),
+		}, program.WithHandlers(instr.Handler{Start: 0, End: 6, Catch: 11, Depth: 0}))
+		costs := NewCostTable(1)
+		costs.Opcodes[instr.THROW] = 10
+		i := New(prog, WithFuel(5), WithCosts(costs))
+		defer i.Close()
+
+		require.ErrorIs(t, i.Run(context.Background()), ErrFuelExhausted)
+		require.Equal(t, uint64(1), i.FuelConsumed())
+	})
+
+}
+
+func TestWithImports(t *testing.T) {
+	sig := &types.FunctionType{Params: []types.Type{types.TypeI32}, Returns: []types.Type{types.TypeI32}}
+	build := func(t *testing.T) *program.Program {
+		b := program.NewBuilder()
+		inc := b.Import("env.inc", sig)
+		b.Globals(types.TypeI32).
+			Emit(instr.I32_CONST, 41).
+			Emit(instr.CONST_GET, uint64(inc)).
+			Emit(instr.CALL).
+			Emit(instr.GLOBAL_SET, 0)
+		prog, err := b.Build()
+		require.NoError(t, err)
+		require.NoError(t, program.Verify(prog))
+		return prog
+	}
+	inc := NewHostFunction(sig, func(_ *Interpreter, params []types.Boxed) ([]types.Boxed, error) {
+		return []types.Boxed{types.BoxI32(params[0].I32() + 1)}, nil
+	})
+
+	t.Run("binds host function", func(t *testing.T) {
+		prog := build(t)
+		i := New(prog, WithImports(map[string]*HostFunction{"env.inc": inc}))
+		defer i.Close()
+
+		require.NoError(t, i.Run(context.Background()))
+		g, err := i.Global(0)
+		require.NoError(t, err)
+		require.Equal(t, types.BoxI32(42), g)
+		require.IsType(t, &types.Function{}, prog.Constants[0])
+	})
+
+	t.Run("merges repeated options", func(t *testing.T) {
+		i := New(build(t), WithImports(map[string]*HostFunction{"env.other": inc}), WithImports(map[string]*HostFunction{"env.inc": inc}))
+		defer i.Close()
+
+		require.NoError(t, i.Run(context.Background()))
+	})
+
+	t.Run("unresolved import traps when called", func(t *testing.T) {
+		i := New(build(t))
+		defer i.Close()
+
+		require.ErrorIs(t, i.Run(context.Background()), ErrUnresolvedImport)
+	})
+
+	t.Run("signature mismatch traps when called", func(t *testing.T) {
+		wrong := NewHostFunction(&types.FunctionType{Params: []types.Type{types.TypeI64}, Returns: []types.Type{types.TypeI32}}, inc.Fn)
+		i := New(build(t), WithImports(map[string]*HostFunction{"env.inc": wrong}))
+		defer i.Close()
+
+		require.ErrorIs(t, i.Run(context.Background()), ErrUnresolvedImport)
+	})
+}
+
+func TestWithResolver(t *testing.T) {
+	prog := program.New([]instr.Instruction{instr.New(instr.I32_CONST, 1), instr.New(instr.YIELD)})
+	fn := NewHostFunction(&types.FunctionType{}, nil)
+	resolver := resolverFunc(func(val types.Value) (string, bool) { return "fn", val == fn })
+
+	i := New(prog, WithResolver(resolver))
+	defer i.Close()
+
+	require.ErrorIs(t, i.Run(context.Background()), ErrYield)
+	_, err := i.Alloc(fn)
+	require.NoError(t, err)
+	require.NoError(t, i.Snapshot(&strings.Builder{}))
+}
+
+// resolverFunc names host values through a function and resolves none.
+type resolverFunc func(types.Value) (string, bool)
+
+func (f resolverFunc) Name(val types.Value) (string, bool) { return f(val) }
+
+func (f resolverFunc) Resolve(name string) (types.Value, error) {
+	return nil, ErrUnportableValue
+}
+
 func i32operand(v int32) uint64 {
 	return uint64(uint32(v))
 }
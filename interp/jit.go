@@ -6,6 +6,7 @@ import (
 	"unsafe"
 
 	"github.com/siyul-park/minivm/asm"
+	"github.com/siyul-park/minivm/instr"
 	"github.com/siyul-park/minivm/prof"
 	"github.com/siyul-park/minivm/types"
 )
@@ -78,6 +79,7 @@ type lowering struct {
 	globals   []types.Kind
 	heap      []types.Value
 	scratch   []asm.PReg
+	costs     *costs
 	head      asm.Label
 	back      asm.Label
 	budget    asm.VReg
@@ -109,6 +111,18 @@ type lowering struct {
 		n       asm.VReg
 		live    bool
 	}
+
+	// meter is the fuel the block being lowered has paid for up front (see
+	// arm64Lowerer.charge): at is the step being lowered, or len(ops) once the
+	// terminator is, and the charge covers ops up to end, plus the terminator
+	// at a cost of tail when end reaches it. An exit refunds the part it skips.
+	meter struct {
+		ops  []step
+		term terminator
+		at   int
+		end  int
+		tail int64
+	}
 }
 
 // value is one typed operand: a register plus the runtime kind the trace
@@ -198,6 +212,7 @@ type sideExit struct {
 	frames []activation
 	resume int
 	id     int
+	refund int64
 }
 
 // noSpillArch wraps an asm.Arch to force Build to reject spilling instead of
@@ -217,6 +232,10 @@ type elemShape struct {
 	raw   bool
 }
 
+// flushMode selects whether a flush only snapshots VM stack state for a cold
+// path or commits it, clearing dirty marks, before control leaves native code.
+type flushMode uint8
+
 const (
 	scratchStack = iota
 	scratchGlobals
@@ -247,6 +266,7 @@ const (
 	journalHeap           // &i.heap[0]; read-only for heap object fast paths
 	journalNatives        // &i.natives[0]; atomic per-function entry slots
 	journalExitID         // fallback descriptor ID + 1; zero means none
+	journalFuel           // fuel left under WithCosts; native read/write
 	journalHead           // first frame record cell
 )
 
@@ -271,6 +291,25 @@ const (
 	trapBridge
 )
 
+const (
+	flushSnapshot flushMode = iota
+	flushCommit
+)
+
+// Boxing masks used by scalar lowering.
+const (
+	maskI32 = uint64(0xFFFFFFFF)
+	maskI64 = uint64(0x0001_FFFF_FFFF_FFFF)
+
+	boxableWidth = uint8(49)
+)
+
+const branchTableLimit = 32
+
+// workLimit bounds work growth from learned continuations; existing work
+// reuses its native label, while new states keep the deopt fallback.
+const workLimit = 256
+
 // nativeFrameLimit caps generated call depth to the stack space reserved by
 // the ARM64 invoke trampoline. Deeper calls trap before moving SP.
 const nativeFrameLimit = 128
@@ -316,6 +355,18 @@ var (
 	heapCoroutine  = itab((*coroutine)(nil))
 )
 
+// Boxing tags used by scalar lowering, derived from the Kind
+// tag layout so they track any reordering of the Kind enum. i1/i8 share the i32
+// representation and box through tagI32.
+var (
+	tagI1  = types.Tag(types.KindI1)
+	tagI8  = types.Tag(types.KindI8)
+	tagI32 = types.Tag(types.KindI32)
+	tagI64 = types.Tag(types.KindI64)
+	tagF32 = types.Tag(types.KindF32)
+	tagRef = types.Tag(types.KindRef)
+)
+
 // elemShapes is the one place the element storage layout is written down.
 // arrayGet, arraySet, arrayLen, and the planner's hoist eligibility all resolve
 // through it, so a new element kind is one row rather than an edit to each.
@@ -577,6 +628,7 @@ func (c *compiler) newLowering(input *compileInput, arch asm.Arch) *lowering {
 		globals:   input.globals,
 		heap:      input.heap,
 		scratch:   c.scratchRegs[:scratchCount],
+		costs:     input.costs,
 		head:      asmb.Label(),
 		addr:      input.address,
 	}
@@ -648,7 +700,8 @@ func newWatchdog(entry native) *watchdog {
 // recording predicted the program wrong, and a trace cut says the code knowingly
 // stopped mid-function; each pays full bailout and re-entry for nothing. A loop
 // exit is how a loop normally ends and a terminal op is a deopt the plan
-// intended, so neither counts.
+// intended, so neither counts, and running out of fuel says nothing about the
+// code at all.
 func givesUp(reason prof.ExitReason) bool {
 	switch reason {
 	case prof.ExitTraceCut, prof.ExitColdBranch,
@@ -759,11 +812,79 @@ func (ctx *lowering) queueExit(values []value, resume int, reason prof.ExitReaso
 	ctx.descriptors = append(ctx.descriptors, exitDescriptor{reason: reason, opcode: opcode})
 	ctx.exits = append(ctx.exits, sideExit{
 		label: label, values: stack, frames: frames, resume: resume,
-		id: id,
+		id: id, refund: ctx.refund(resume),
 	})
 	return label
 }
 
+// segment returns where the fuel charge opened at ops[from] ends and what the
+// ops it covers cost. A charge runs to the end of the block or just past the
+// next call, since a callee charges for itself and must not find the caller's
+// remaining ops already taken from the tank.
+func (ctx *lowering) segment(ops []step, from int) (int, int64) {
+	end := from
+	var cost int64
+	for end < len(ops) {
+		op := ops[end].op
+		cost = saturate(cost, ctx.costs.opcodes[op])
+		end++
+		if instr.IsCall(op) {
+			break
+		}
+	}
+	return end, cost
+}
+
+// price is what a terminator costs when native code runs it. Only a branch or
+// return the bytecode spells out costs anything: a fallthrough, the implicit
+// return at a function's end, and a trace cut run no instruction, and a
+// fallback or bridge leaves its instruction to the metered interpreter.
+func (ctx *lowering) price(t terminator) int64 {
+	if ctx.costs == nil || t.implicit {
+		return 0
+	}
+	switch t.kind {
+	case terminateBranch:
+		return ctx.costs.opcodes[instr.BR]
+	case terminateBranchIf:
+		return ctx.costs.opcodes[instr.BR_IF]
+	case terminateBranchTable:
+		return ctx.costs.opcodes[instr.BR_TABLE]
+	case terminateReturn:
+		return ctx.costs.opcodes[instr.RETURN]
+	default:
+		return 0
+	}
+}
+
+// refund is the fuel an exit resuming at resume hands back: the charged ops it
+// skips, counting the step being lowered when the exit runs it again, and the
+// terminator when the charge covered it and the exit does not pass it.
+func (ctx *lowering) refund(resume int) int64 {
+	m := &ctx.meter
+	if ctx.costs == nil {
+		return 0
+	}
+	if m.at >= len(m.ops) {
+		if resume == m.term.ip {
+			return m.tail
+		}
+		return 0
+	}
+	from := m.at
+	if op := m.ops[from]; from < m.end && (op.ip != resume || op.fn != ctx.frame().addr) {
+		from++
+	}
+	var n int64
+	for _, op := range m.ops[from:max(from, m.end)] {
+		n = saturate(n, ctx.costs.opcodes[op.op])
+	}
+	if m.end == len(m.ops) {
+		n = saturate(n, m.tail)
+	}
+	return n
+}
+
 // snapshot deep-copies operand and frame state for a deferred branch. Callers
 // must flush VM stack slots first; re-entry reloads locals on demand, so stale
 // register and local-loaded state must stay dropped.
@@ -819,3 +940,13 @@ func reasonPriority(reason prof.CompileReason) int {
 		return 0
 	}
 }
+
+func appendTail(steps, tail []int) []int {
+	if len(steps) == 0 {
+		return tail
+	}
+	if len(tail) == 0 {
+		return steps
+	}
+	return append(append([]int(nil), steps...), tail...)
+}
@@ -0,0 +1,1549 @@
+package interp
+
+import (
+	"slices"
+
+	"github.com/siyul-park/minivm/asm"
+	"github.com/siyul-park/minivm/asm/amd64"
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/prof"
+	"github.com/siyul-park/minivm/types"
+)
+
+// amd64Lowerer is the x86-64 JIT lowerer. It lowers the scalar subset of the
+// plans jit_plan.go produces: integer and float arithmetic, locals, globals,
+// branches, and back-edge safepoints. A plan that touches a reference, a call,
+// or an opcode outside that subset is rejected as a whole and its anchor stays
+// threaded; guards and terminal ops deopt through the same journal contract the
+// ARM64 lowerer uses.
+type amd64Lowerer struct{}
+
+const nativeBackend = true
+
+func newCompiler() (*compiler, error) {
+	buffer, err := asm.NewBuffer(4096)
+	if err != nil {
+		return nil, err
+	}
+	return &compiler{
+		arch:        amd64.New(),
+		buffer:      buffer,
+		scratchRegs: []asm.PReg{amd64.RBX, amd64.R12, amd64.R13, amd64.R14, amd64.R15},
+	}, nil
+}
+
+// enter mirrors the journal header into the pinned scratch registers and
+// dispatches an external bridge re-entry to its resume block. The subset has
+// no calls, so head is only ever reached by falling through from the entry.
+func (l amd64Lowerer) enter(ctx *lowering) {
+	a := ctx.assembler
+	a.Emit(amd64.MOV(ctx.scratch[scratchCtrl], amd64.RDI))
+	vCtrl := ctx.pin(scratchCtrl)
+	a.Emit(
+		amd64.LOAD(ctx.scratch[scratchStack], vCtrl, int32(journalStack*8)),
+		amd64.LOAD(ctx.scratch[scratchGlobals], vCtrl, int32(journalGlobals*8)),
+		amd64.LOAD(ctx.scratch[scratchBP], vCtrl, int32(journalBP*8)),
+		amd64.LOAD(ctx.scratch[scratchSP], vCtrl, int32(journalSP*8)),
+	)
+	l.dispatch(ctx, vCtrl)
+	a.Bind(ctx.head)
+	l.zeroLocals(ctx)
+}
+
+// dispatch branches a bridge re-entry to its resume block (see
+// arm64Lowerer.dispatch); a zero entry IP falls through to the anchor.
+func (l amd64Lowerer) dispatch(ctx *lowering, vCtrl asm.VReg) {
+	var resumable []int
+	for id, block := range ctx.blocks {
+		if block.bridge {
+			resumable = append(resumable, id)
+		}
+	}
+	if len(resumable) == 0 {
+		return
+	}
+	a := ctx.assembler
+	entry := a.Reg(asm.RegTypeInt, asm.Width64)
+	a.Emit(amd64.LOAD(entry, vCtrl, int32(journalEntry*8)))
+	done := a.Label()
+	a.Emit(amd64.CMPI(entry, 0), amd64.JCCLabel(amd64.CondE, done))
+	for _, id := range resumable {
+		a.Emit(
+			amd64.CMPI(entry, int32(ctx.blocks[id].anchor.ip)),
+			amd64.JCCLabel(amd64.CondE, ctx.labels[id]),
+		)
+	}
+	a.Bind(done)
+}
+
+// emitExits emits every queued cold stub. The subset holds no refs, so a
+// stub only publishes the budget and traps with its flushed snapshot.
+func (l amd64Lowerer) emitExits(ctx *lowering) bool {
+	for _, exit := range ctx.exits {
+		ctx.values = exit.values
+		ctx.frames = exit.frames
+		ctx.assembler.Bind(exit.label)
+		if ctx.budget.Width() != asm.WidthUndefined {
+			ctx.assembler.Emit(amd64.STORE(ctx.budget, ctx.pin(scratchCtrl), int32(journalBudget*8)))
+		}
+		l.repay(ctx, exit.refund)
+		l.trapFlushed(ctx, trapFallback, exit.resume, exit.id)
+	}
+	return true
+}
+
+func (l amd64Lowerer) emitBlock(ctx *lowering, id int, tail []int) bool {
+	if id < 0 || id >= len(ctx.blocks) {
+		return false
+	}
+	block := ctx.blocks[id]
+	if block.state != nil {
+		ctx.values = ctx.values[:0]
+		for _, slot := range block.state {
+			if slot.kind == types.KindRef {
+				return false
+			}
+			ctx.values = append(ctx.values, value{kind: slot.kind, backing: slot.backing, slot: slot.slot})
+		}
+		l.clearLocals(ctx)
+		l.reload(ctx)
+	}
+	ctx.meter.term = block.term
+	if !l.charge(ctx, block.steps, 0) {
+		return false
+	}
+	done, ok := l.steps(ctx, block.steps)
+	if !ok {
+		return false
+	}
+	if done {
+		return true
+	}
+	if block.term.kind == terminateFallthrough && len(tail) > 0 {
+		return l.follow(ctx, tail)
+	}
+	return l.term(ctx, block, tail)
+}
+
+func (l amd64Lowerer) term(ctx *lowering, block block, tail []int) bool {
+	switch block.term.kind {
+	case terminateFallthrough:
+		return true
+	case terminateBranch:
+		if len(block.term.edges) != 1 {
+			return false
+		}
+		target := block.term.edges[0]
+		if block.term.hot == 0 {
+			return l.next(ctx, block.anchor, target, tail, int(instr.BR))
+		}
+		if !l.flush(ctx, flushSnapshot) {
+			return false
+		}
+		return l.path(ctx, block.anchor, target, tail, int(instr.BR))
+	case terminateBranchIf:
+		return l.conditional(ctx, block, tail)
+	case terminateBranchTable:
+		return l.table(ctx, block, tail)
+	case terminateReturn:
+		if len(ctx.frames) > 1 {
+			return false
+		}
+		return l.ret(ctx)
+	case terminateComplete:
+		return l.complete(ctx)
+	case terminateFallback:
+		return l.exit(ctx, block.term.ip, prof.ExitTraceCut, prof.OpcodeNone)
+	case terminateBridge:
+		return l.bridge(ctx, block.term.ip)
+	default:
+		return false
+	}
+}
+
+func (l amd64Lowerer) conditional(ctx *lowering, block block, tail []int) bool {
+	if len(block.term.edges) != 2 || ctx.count() < 1 || !l.kinds(ctx, types.KindI32, 1) {
+		return false
+	}
+	cond := ctx.pop()
+	if block.term.hot >= 0 && block.term.hot < len(block.term.edges) {
+		cold := 1 - block.term.hot
+		clean := l.clean(ctx)
+		if !clean && !l.flush(ctx, flushSnapshot) {
+			return false
+		}
+		target := block.term.edges[cold]
+		label, ok := l.label(ctx, target, appendTail(target.tail, tail), int(instr.BR_IF))
+		if !ok {
+			return false
+		}
+		ctx.assembler.Emit(amd64.CMPI(l.narrow32(cond.reg), 0))
+		if block.term.hot == 1 {
+			ctx.assembler.Emit(amd64.JCCLabel(amd64.CondNE, label))
+		} else {
+			ctx.assembler.Emit(amd64.JCCLabel(amd64.CondE, label))
+		}
+		return l.next(ctx, block.anchor, block.term.edges[block.term.hot], tail, int(instr.BR_IF))
+	}
+
+	if !l.flush(ctx, flushSnapshot) {
+		return false
+	}
+	taken := ctx.assembler.Label()
+	ctx.assembler.Emit(amd64.CMPI(l.narrow32(cond.reg), 0), amd64.JCCLabel(amd64.CondNE, taken))
+	if !l.path(ctx, block.anchor, block.term.edges[1], tail, int(instr.BR_IF)) {
+		return false
+	}
+	ctx.assembler.Bind(taken)
+	return l.path(ctx, block.anchor, block.term.edges[0], tail, int(instr.BR_IF))
+}
+
+func (l amd64Lowerer) table(ctx *lowering, block block, tail []int) bool {
+	if len(block.term.edges) == 0 || len(block.term.edges)-1 > branchTableLimit || ctx.count() < 1 || !l.kinds(ctx, types.KindI32, 1) {
+		return false
+	}
+	cond := ctx.pop()
+	if !l.flush(ctx, flushSnapshot) {
+		return false
+	}
+	labels := make([]asm.Label, len(block.term.edges))
+	for idx := range labels {
+		labels[idx] = ctx.assembler.Label()
+	}
+	for idx := 0; idx < len(labels)-1; idx++ {
+		ctx.assembler.Emit(amd64.CMPI(l.narrow32(cond.reg), int32(idx)))
+		ctx.assembler.Emit(amd64.JCCLabel(amd64.CondE, labels[idx]))
+	}
+	ctx.assembler.Emit(amd64.JMPLabel(labels[len(labels)-1]))
+	for idx, label := range labels {
+		ctx.assembler.Bind(label)
+		if !l.path(ctx, block.anchor, block.term.edges[idx], tail, int(instr.BR_TABLE)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (l amd64Lowerer) next(ctx *lowering, from anchor, target edge, tail []int, opcode int) bool {
+	tail = appendTail(target.tail, tail)
+	target.tail = nil
+	if target.anchor.addr == from.addr && target.anchor.ip <= from.ip {
+		if !l.flush(ctx, flushCommit) {
+			return false
+		}
+		if ctx.nativeLoop && target.block == ctx.loopRoot && len(ctx.frames) == 1 && ctx.count() == 0 {
+			return l.back(ctx, ctx.back, target.anchor.ip)
+		}
+		return l.path(ctx, from, target, tail, opcode)
+	}
+	if target.block == noBlock {
+		reason := prof.ExitColdBranch
+		if ctx.kind == entryLoop {
+			reason = prof.ExitLoop
+		}
+		return l.exit(ctx, target.anchor.ip, reason, opcode)
+	}
+	return l.emitBlock(ctx, target.block, tail)
+}
+
+func (l amd64Lowerer) follow(ctx *lowering, tail []int) bool {
+	if len(tail) == 0 {
+		return true
+	}
+	if !l.flush(ctx, flushSnapshot) {
+		return false
+	}
+	id := tail[0]
+	if id < 0 || id >= len(ctx.blocks) || !ctx.blocks[id].tail {
+		return false
+	}
+	values, frames := ctx.snapshot()
+	label, _ := l.schedule(ctx, work{block: id, tail: tail[1:], values: values, frames: frames}, 0)
+	ctx.assembler.Emit(amd64.JMPLabel(label))
+	return true
+}
+
+func (l amd64Lowerer) path(ctx *lowering, from anchor, target edge, tail []int, opcode int) bool {
+	tail = appendTail(target.tail, tail)
+	target.tail = nil
+	label, ok := l.label(ctx, target, tail, opcode)
+	if !ok {
+		return false
+	}
+	if target.anchor.addr == from.addr && target.anchor.ip <= from.ip {
+		return l.back(ctx, label, target.anchor.ip)
+	}
+	ctx.assembler.Emit(amd64.JMPLabel(label))
+	return true
+}
+
+// back decrements the safepoint budget and continues at label while work
+// remains. Native loops keep the budget in a register; chained loops update
+// its journal cell.
+func (l amd64Lowerer) back(ctx *lowering, label asm.Label, resume int) bool {
+	a := ctx.assembler
+	vCtrl := ctx.pin(scratchCtrl)
+	budget := ctx.budget
+	if budget.Width() == asm.WidthUndefined {
+		budget = a.Reg(asm.RegTypeInt, asm.Width64)
+		a.Emit(amd64.LOAD(budget, vCtrl, int32(journalBudget*8)))
+	}
+	a.Emit(amd64.SUBI(budget, budget, 1))
+	if ctx.budget.Width() == asm.WidthUndefined {
+		a.Emit(amd64.STORE(budget, vCtrl, int32(journalBudget*8)))
+	}
+	a.Emit(amd64.CMPI(budget, 0), amd64.JCCLabel(amd64.CondNE, label))
+	if ctx.budget.Width() != asm.WidthUndefined {
+		a.Emit(amd64.STORE(budget, vCtrl, int32(journalBudget*8)))
+	}
+	l.trapFlushed(ctx, trapYield, resume, -1)
+	return true
+}
+
+func (l amd64Lowerer) label(ctx *lowering, target edge, tail []int, opcode int) (asm.Label, bool) {
+	if target.block == noBlock {
+		reason := prof.ExitColdBranch
+		if ctx.kind == entryLoop {
+			reason = prof.ExitLoop
+		}
+		return ctx.queueExit(nil, target.anchor.ip, reason, opcode), true
+	}
+	if target.block < 0 || target.block >= len(ctx.blocks) {
+		return 0, false
+	}
+	block := ctx.blocks[target.block]
+	if block.state != nil {
+		return ctx.labels[target.block], true
+	}
+	values, frames := ctx.snapshot()
+	label, ok := l.schedule(ctx, work{block: target.block, tail: tail, values: values, frames: frames}, workLimit)
+	if !ok {
+		return ctx.queueExit(nil, target.anchor.ip, prof.ExitColdBranch, opcode), true
+	}
+	return label, true
+}
+
+func (l amd64Lowerer) schedule(ctx *lowering, next work, limit int) (asm.Label, bool) {
+	for _, prior := range ctx.work {
+		if l.same(prior, next) {
+			return prior.label, true
+		}
+	}
+	if limit > 0 && len(ctx.work) >= limit {
+		return 0, false
+	}
+	next.label = ctx.assembler.Label()
+	ctx.work = append(ctx.work, next)
+	return next.label, true
+}
+
+// same reports whether two work items resume the same canonical state.
+func (l amd64Lowerer) same(a, b work) bool {
+	if a.block != b.block || !slices.Equal(a.tail, b.tail) || !slices.Equal(a.values, b.values) || len(a.frames) != len(b.frames) {
+		return false
+	}
+	for i := range a.frames {
+		x, y := a.frames[i], b.frames[i]
+		if x.addr != y.addr || x.base != y.base || x.opBase != y.opBase || x.end != y.end || x.returns != y.returns ||
+			len(x.locals) != len(y.locals) || len(x.upvals) != len(y.upvals) {
+			return false
+		}
+	}
+	return true
+}
+
+// steps emits the ordinary operations of one normalized block. An opcode
+// outside the scalar subset rejects the plan.
+func (l amd64Lowerer) steps(ctx *lowering, ops []step) (bool, bool) {
+	for idx := 0; idx < len(ops); idx++ {
+		op := ops[idx]
+		if op.fn != ctx.frame().addr {
+			return false, false
+		}
+		ctx.meter.at = idx
+		ok := false
+		switch op.op {
+		case instr.NOP:
+			ok = true
+		case instr.I32_CONST, instr.I64_CONST, instr.F32_CONST, instr.F64_CONST:
+			ok = l.constant(ctx, op)
+		case instr.LOCAL_GET:
+			ok = l.localGet(ctx, op)
+		case instr.LOCAL_SET:
+			ok = l.localSet(ctx, op, true)
+		case instr.LOCAL_TEE:
+			ok = l.localSet(ctx, op, false)
+		case instr.GLOBAL_GET:
+			ok = l.globalGet(ctx, op)
+		case instr.GLOBAL_SET:
+			ok = l.globalSet(ctx, op, true)
+		case instr.GLOBAL_TEE:
+			ok = l.globalSet(ctx, op, false)
+		case instr.DROP:
+			ok = l.drop(ctx)
+		case instr.DUP:
+			ok = l.dup(ctx)
+		case instr.SWAP:
+			ok = l.swap(ctx)
+		case instr.SELECT:
+			ok = l.selectOp(ctx)
+		case instr.I32_ADD:
+			ok = l.i32Binary(ctx, amd64.ADD)
+		case instr.I32_SUB:
+			ok = l.i32Binary(ctx, amd64.SUB)
+		case instr.I32_MUL:
+			ok = l.i32Binary(ctx, amd64.IMUL)
+		case instr.I32_AND:
+			ok = l.i32Bitwise(ctx, amd64.AND)
+		case instr.I32_OR:
+			ok = l.i32Bitwise(ctx, amd64.OR)
+		case instr.I32_XOR:
+			ok = l.i32Bitwise(ctx, amd64.XOR)
+		case instr.I32_EQZ:
+			ok = l.i32Eqz(ctx)
+		case instr.I32_EQ:
+			ok = l.i32Cmp(ctx, amd64.CondE)
+		case instr.I32_NE:
+			ok = l.i32Cmp(ctx, amd64.CondNE)
+		case instr.I32_LT_S:
+			ok = l.i32Cmp(ctx, amd64.CondL)
+		case instr.I32_LE_S:
+			ok = l.i32Cmp(ctx, amd64.CondLE)
+		case instr.I32_GT_S:
+			ok = l.i32Cmp(ctx, amd64.CondG)
+		case instr.I32_GE_S:
+			ok = l.i32Cmp(ctx, amd64.CondGE)
+		case instr.I32_LT_U:
+			ok = l.i32Cmp(ctx, amd64.CondB)
+		case instr.I32_LE_U:
+			ok = l.i32Cmp(ctx, amd64.CondBE)
+		case instr.I32_GT_U:
+			ok = l.i32Cmp(ctx, amd64.CondA)
+		case instr.I32_GE_U:
+			ok = l.i32Cmp(ctx, amd64.CondAE)
+		case instr.I32_DIV_S:
+			ok = l.i32Divide(ctx, op, amd64.IDIV, l.sign32)
+		case instr.I32_DIV_U:
+			ok = l.i32Divide(ctx, op, amd64.DIV, l.zero32)
+		case instr.I32_REM_S:
+			ok = l.i32Divide(ctx, op, amd64.IREM, l.sign32)
+		case instr.I32_REM_U:
+			ok = l.i32Divide(ctx, op, amd64.REM, l.zero32)
+		case instr.I32_SHL:
+			ok = l.i32Shift(ctx, amd64.SHL)
+		case instr.I32_SHR_S:
+			ok = l.i32Shift(ctx, amd64.SAR)
+		case instr.I32_SHR_U:
+			ok = l.i32Shift(ctx, amd64.SHR)
+		case instr.I64_ADD:
+			ok = l.i64Binary(ctx, op, amd64.ADD, true)
+		case instr.I64_SUB:
+			ok = l.i64Binary(ctx, op, amd64.SUB, true)
+		case instr.I64_MUL:
+			ok = l.i64Binary(ctx, op, amd64.IMUL, true)
+		case instr.I64_AND:
+			ok = l.i64Binary(ctx, op, amd64.AND, false)
+		case instr.I64_OR:
+			ok = l.i64Binary(ctx, op, amd64.OR, false)
+		case instr.I64_XOR:
+			ok = l.i64Binary(ctx, op, amd64.XOR, false)
+		case instr.I64_EQZ:
+			ok = l.i64Eqz(ctx)
+		case instr.I64_EQ:
+			ok = l.i64Cmp(ctx, amd64.CondE)
+		case instr.I64_NE:
+			ok = l.i64Cmp(ctx, amd64.CondNE)
+		case instr.I64_LT_S:
+			ok = l.i64Cmp(ctx, amd64.CondL)
+		case instr.I64_LE_S:
+			ok = l.i64Cmp(ctx, amd64.CondLE)
+		case instr.I64_GT_S:
+			ok = l.i64Cmp(ctx, amd64.CondG)
+		case instr.I64_GE_S:
+			ok = l.i64Cmp(ctx, amd64.CondGE)
+		case instr.I64_LT_U:
+			ok = l.i64Cmp(ctx, amd64.CondB)
+		case instr.I64_LE_U:
+			ok = l.i64Cmp(ctx, amd64.CondBE)
+		case instr.I64_GT_U:
+			ok = l.i64Cmp(ctx, amd64.CondA)
+		case instr.I64_GE_U:
+			ok = l.i64Cmp(ctx, amd64.CondAE)
+		case instr.I64_DIV_S:
+			ok = l.i64Divide(ctx, op, amd64.IDIV)
+		case instr.I64_DIV_U:
+			ok = l.i64Divide(ctx, op, amd64.DIV)
+		case instr.I64_REM_S:
+			ok = l.i64Divide(ctx, op, amd64.IREM)
+		case instr.I64_REM_U:
+			ok = l.i64Divide(ctx, op, amd64.REM)
+		case instr.I64_SHL:
+			ok = l.i64Shift(ctx, op, amd64.SHL, true)
+		case instr.I64_SHR_S:
+			ok = l.i64Shift(ctx, op, amd64.SAR, false)
+		case instr.I64_SHR_U:
+			ok = l.i64Shift(ctx, op, amd64.SHR, true)
+		case instr.F32_ADD:
+			ok = l.floatBinary(ctx, types.KindF32, amd64.FADD)
+		case instr.F32_SUB:
+			ok = l.floatBinary(ctx, types.KindF32, amd64.FSUB)
+		case instr.F32_MUL:
+			ok = l.floatBinary(ctx, types.KindF32, amd64.FMUL)
+		case instr.F32_DIV:
+			ok = l.floatBinary(ctx, types.KindF32, amd64.FDIV)
+		case instr.F32_EQ:
+			ok = l.floatEq(ctx, types.KindF32, false)
+		case instr.F32_NE:
+			ok = l.floatEq(ctx, types.KindF32, true)
+		case instr.F32_LT:
+			ok = l.floatCmp(ctx, types.KindF32, amd64.CondA, true)
+		case instr.F32_GT:
+			ok = l.floatCmp(ctx, types.KindF32, amd64.CondA, false)
+		case instr.F32_LE:
+			ok = l.floatCmp(ctx, types.KindF32, amd64.CondAE, true)
+		case instr.F32_GE:
+			ok = l.floatCmp(ctx, types.KindF32, amd64.CondAE, false)
+		case instr.F64_ADD:
+			ok = l.floatBinary(ctx, types.KindF64, amd64.FADD)
+		case instr.F64_SUB:
+			ok = l.floatBinary(ctx, types.KindF64, amd64.FSUB)
+		case instr.F64_MUL:
+			ok = l.floatBinary(ctx, types.KindF64, amd64.FMUL)
+		case instr.F64_DIV:
+			ok = l.floatBinary(ctx, types.KindF64, amd64.FDIV)
+		case instr.F64_EQ:
+			ok = l.floatEq(ctx, types.KindF64, false)
+		case instr.F64_NE:
+			ok = l.floatEq(ctx, types.KindF64, true)
+		case instr.F64_LT:
+			ok = l.floatCmp(ctx, types.KindF64, amd64.CondA, true)
+		case instr.F64_GT:
+			ok = l.floatCmp(ctx, types.KindF64, amd64.CondA, false)
+		case instr.F64_LE:
+			ok = l.floatCmp(ctx, types.KindF64, amd64.CondAE, true)
+		case instr.F64_GE:
+			ok = l.floatCmp(ctx, types.KindF64, amd64.CondAE, false)
+		case instr.I32_TO_I64_S:
+			ok = l.i32ToI64(ctx, l.sign32)
+		case instr.I32_TO_I64_U:
+			ok = l.i32ToI64(ctx, l.zero32)
+		case instr.I64_TO_I32:
+			ok = l.i64ToI32(ctx)
+		case instr.I32_TO_F64_S:
+			ok = l.toFloat(ctx, types.KindI32, types.KindF64, l.sign32)
+		case instr.I32_TO_F64_U:
+			ok = l.toFloat(ctx, types.KindI32, types.KindF64, l.zero32)
+		case instr.I32_TO_F32_S:
+			ok = l.toFloat(ctx, types.KindI32, types.KindF32, l.sign32)
+		case instr.I32_TO_F32_U:
+			ok = l.toFloat(ctx, types.KindI32, types.KindF32, l.zero32)
+		case instr.I64_TO_F64_S:
+			ok = l.toFloat(ctx, types.KindI64, types.KindF64, nil)
+		case instr.I64_TO_F32_S:
+			ok = l.toFloat(ctx, types.KindI64, types.KindF32, nil)
+		case instr.F64_REM, instr.F64_MOD, instr.F32_REM, instr.F32_MOD, instr.UNREACHABLE:
+			// Terminal for the same reason as on ARM64: the threaded handler
+			// owns the operation and performs its own IP advance.
+			if !l.exit(ctx, op.ip, prof.ExitTerminalOp, int(op.op)) {
+				return false, false
+			}
+			return true, idx == len(ops)-1
+		case instr.RETURN:
+			if len(ctx.frames) > 1 || !l.ret(ctx) {
+				return false, false
+			}
+			return true, idx == len(ops)-1
+		}
+		if !ok {
+			return false, false
+		}
+	}
+	ctx.meter.at = len(ops)
+	return false, true
+}
+
+// charge takes the block's fuel up front (see arm64Lowerer.charge). The
+// subset has no calls, so one charge covers every step and the terminator.
+func (l amd64Lowerer) charge(ctx *lowering, ops []step, from int) bool {
+	m := &ctx.meter
+	m.ops, m.at, m.end, m.tail = ops, from, from, 0
+	if ctx.costs == nil {
+		m.end = len(ops)
+		return true
+	}
+	end, cost := ctx.segment(ops, from)
+	var tail int64
+	if end == len(ops) {
+		tail = ctx.price(m.term)
+		cost = saturate(cost, tail)
+	}
+	if cost > 0 {
+		resume, opcode := m.term.ip, prof.OpcodeNone
+		if from < len(ops) {
+			resume, opcode = ops[from].ip, int(ops[from].op)
+		}
+		fail, ok := l.sideExit(ctx, ctx.pre(), resume, prof.ExitFuel, opcode)
+		if !ok {
+			return false
+		}
+		a := ctx.assembler
+		vCtrl := ctx.pin(scratchCtrl)
+		fuel := a.Reg(asm.RegTypeInt, asm.Width64)
+		price := a.Reg(asm.RegTypeInt, asm.Width64)
+		a.Emit(amd64.LOAD(fuel, vCtrl, int32(journalFuel*8)))
+		a.Emit(amd64.MOVI(price, cost))
+		a.Emit(amd64.CMP(fuel, price), amd64.JCCLabel(amd64.CondL, fail))
+		a.Emit(amd64.SUB(fuel, fuel, price), amd64.STORE(fuel, vCtrl, int32(journalFuel*8)))
+	}
+	m.end, m.tail = end, tail
+	return true
+}
+
+// repay returns n fuel an exit was charged for but leaves to the interpreter.
+func (l amd64Lowerer) repay(ctx *lowering, n int64) {
+	if n == 0 {
+		return
+	}
+	a := ctx.assembler
+	vCtrl := ctx.pin(scratchCtrl)
+	fuel := a.Reg(asm.RegTypeInt, asm.Width64)
+	amount := a.Reg(asm.RegTypeInt, asm.Width64)
+	a.Emit(amd64.LOAD(fuel, vCtrl, int32(journalFuel*8)))
+	a.Emit(amd64.MOVI(amount, n))
+	a.Emit(amd64.ADD(fuel, fuel, amount), amd64.STORE(fuel, vCtrl, int32(journalFuel*8)))
+}
+
+// constant pushes an immediate operand. Integer constants keep their known
+// compile-time value for downstream folding; floats stay raw bits only.
+func (l amd64Lowerer) constant(ctx *lowering, op step) bool {
+	out := value{kind: types.KindI32, raw: true}
+	bits := op.args[0]
+	switch op.op {
+	case instr.I32_CONST:
+		bits = uint64(uint32(bits))
+		out.known, out.imm = true, int64(int32(bits))
+	case instr.I64_CONST:
+		if !types.IsBoxable(int64(bits)) {
+			return false
+		}
+		out.kind = types.KindI64
+		out.known, out.imm = true, int64(bits)
+	case instr.F32_CONST:
+		out.kind = types.KindF32
+		bits = uint64(uint32(bits))
+	case instr.F64_CONST:
+		out.kind = types.KindF64
+	}
+	out.reg = ctx.assembler.Reg(asm.RegTypeInt, asm.Width64)
+	ctx.assembler.Emit(amd64.MOVI(out.reg, int64(bits)))
+	ctx.push(out)
+	return true
+}
+
+func (l amd64Lowerer) localGet(ctx *lowering, op step) bool {
+	f := ctx.frame()
+	idx := int(op.args[0])
+	if idx >= len(f.kinds) || !l.loadLocal(ctx, f, idx, op.ip) {
+		return false
+	}
+	ctx.push(f.locals[idx])
+	return true
+}
+
+func (l amd64Lowerer) localSet(ctx *lowering, op step, pop bool) bool {
+	f := ctx.frame()
+	idx := int(op.args[0])
+	if idx >= len(f.kinds) || ctx.count() < 1 {
+		return false
+	}
+	v := ctx.values[len(ctx.values)-1]
+	if v.kind == types.KindRef || v.kind.Repr() != f.kinds[idx].Repr() || !v.raw {
+		return false
+	}
+	f.locals[idx] = v
+	f.state[idx] = f.state[idx]&^localStored | localLoaded | localDirty
+	if pop {
+		ctx.pop()
+	}
+	return true
+}
+
+// globalGet loads a scalar global directly from the globals base.
+func (l amd64Lowerer) globalGet(ctx *lowering, op step) bool {
+	idx, kind, ok := l.global(ctx, op)
+	if !ok {
+		return false
+	}
+	base := ctx.pin(scratchGlobals)
+	dst := ctx.assembler.Reg(asm.RegTypeInt, asm.Width64)
+	ctx.assembler.Emit(amd64.LOAD(dst, base, int32(idx*8)))
+	if kind == types.KindI64 {
+		if !l.guardI64(ctx, dst, op.ip) {
+			return false
+		}
+		dst = l.sign64(ctx, dst)
+	}
+	ctx.push(value{reg: dst, kind: kind, raw: true})
+	return true
+}
+
+// globalSet boxes the top value and stores it to the global.
+func (l amd64Lowerer) globalSet(ctx *lowering, op step, pop bool) bool {
+	idx, kind, ok := l.global(ctx, op)
+	if !ok || ctx.count() < 1 {
+		return false
+	}
+	v := ctx.values[len(ctx.values)-1]
+	if v.kind != kind || !v.raw {
+		return false
+	}
+	boxed, ok := l.box(ctx, v)
+	if !ok {
+		return false
+	}
+	ctx.assembler.Emit(amd64.STORE(boxed, ctx.pin(scratchGlobals), int32(idx*8)))
+	if pop {
+		ctx.pop()
+	}
+	return true
+}
+
+// global decodes the global index and returns its statically observed kind.
+// Ref globals carry a reference count the subset does not manage.
+func (l amd64Lowerer) global(ctx *lowering, op step) (int, types.Kind, bool) {
+	idx := int(op.args[0])
+	if idx >= len(ctx.globals) {
+		return 0, 0, false
+	}
+	kind := ctx.globals[idx]
+	switch kind {
+	case types.KindI32, types.KindI64, types.KindF32, types.KindF64:
+		return idx, kind, true
+	}
+	return 0, 0, false
+}
+
+func (l amd64Lowerer) drop(ctx *lowering) bool {
+	if ctx.count() < 1 || ctx.values[len(ctx.values)-1].kind == types.KindRef {
+		return false
+	}
+	ctx.pop()
+	return true
+}
+
+func (l amd64Lowerer) dup(ctx *lowering) bool {
+	if ctx.count() < 1 || ctx.values[len(ctx.values)-1].kind == types.KindRef {
+		return false
+	}
+	ctx.push(ctx.values[len(ctx.values)-1])
+	return true
+}
+
+func (l amd64Lowerer) swap(ctx *lowering) bool {
+	if ctx.count() < 2 {
+		return false
+	}
+	last := len(ctx.values) - 1
+	ctx.values[last], ctx.values[last-1] = ctx.values[last-1], ctx.values[last]
+	return true
+}
+
+func (l amd64Lowerer) selectOp(ctx *lowering) bool {
+	if ctx.count() < 3 {
+		return false
+	}
+	cond := ctx.pop()
+	v2 := ctx.pop()
+	v1 := ctx.pop()
+	if cond.kind.Repr() != types.KindI32 || v1.kind != v2.kind || v1.kind == types.KindRef {
+		return false
+	}
+	ctx.assembler.Emit(amd64.CMPI(l.narrow32(cond.reg), 0))
+	dst := ctx.assembler.Reg(asm.RegTypeInt, asm.Width64)
+	ctx.assembler.Emit(amd64.CMOV(dst, v1.reg, v2.reg, amd64.CondNE))
+	ctx.push(value{reg: dst, kind: v1.kind, raw: true})
+	return true
+}
+
+// clean reports whether a branch can skip the hot-path flush: no live operand
+// or dirty local will be reloaded from VM stack slots later in the trace.
+func (l amd64Lowerer) clean(ctx *lowering) bool {
+	if ctx.count() != 0 {
+		return false
+	}
+	for fi := range ctx.frames {
+		for _, state := range ctx.frames[fi].state {
+			if state&localDirty != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (l amd64Lowerer) i32Binary(ctx *lowering, op func(dst, src1, src2 asm.Reg) asm.Instruction) bool {
+	b, a, ok := l.operands(ctx, types.KindI32)
+	if !ok {
+		return false
+	}
+	dst := ctx.assembler.Reg(asm.RegTypeInt, asm.Width64)
+	ctx.assembler.Emit(op(dst, a.reg, b.reg))
+	ctx.push(value{reg: dst, kind: types.KindI32, raw: true})
+	return true
+}
+
+// i32Bitwise lowers a width-closed bitwise op; the result keeps a shared
+// narrow kind and widens to i32 only for a mixed pair (see
+// arm64Lowerer.i32Bitwise).
+func (l amd64Lowerer) i32Bitwise(ctx *lowering, op func(dst, src1, src2 asm.Reg) asm.Instruction) bool {
+	b, a, ok := l.operands(ctx, types.KindI32)
+	if !ok {
+		return false
+	}
+	dst := ctx.assembler.Reg(asm.RegTypeInt, asm.Width64)
+	ctx.assembler.Emit(op(dst, a.reg, b.reg))
+	ctx.push(value{reg: dst, kind: a.kind & b.kind, raw: true})
+	return true
+}
+
+// i32Divide extends both lanes with prep and divides at 64 bits, where
+// MinInt32 / -1 cannot fault and truncates to the wrapped i32 result.
+func (l amd64Lowerer) i32Divide(
+	ctx *lowering,
+	op step,
+	div func(dst, src1, src2 asm.Reg) asm.Instruction,
+	prep func(*lowering, asm.VReg) asm.VReg,
+) bool {
+	if ctx.count() < 2 || !l.kinds(ctx, types.KindI32, 2) {
+		return false
+	}
+	b := prep(ctx, ctx.values[len(ctx.values)-1].reg)
+	a := prep(ctx, ctx.values[len(ctx.values)-2].reg)
+
+	top := ctx.values[len(ctx.values)-1]
+	observed := uint64(0)
+	if op.arg.Kind().Repr() == types.KindI32 {
+		observed = uint64(uint32(op.arg.I32()))
+	}
+	if !l.guardDivisor(ctx, top, l.narrow32(b), observed, op.ip) {
+		return false
+	}
+
+	ctx.pop()
+	ctx.pop()
+	dst := ctx.assembler.Reg(asm.RegTypeInt, asm.Width64)
+	ctx.assembler.Emit(div(dst, a, b))
+	ctx.push(value{reg: dst, kind: types.KindI32, raw: true})
+	return true
+}
+
+// i32Shift shifts the 32-bit register views, so the hardware masks the count
+// to five bits and the logical and arithmetic forms read only the i32 lane.
+func (l amd64Lowerer) i32Shift(ctx *lowering, shiftOp func(dst, src1, src2 asm.Reg) asm.Instruction) bool {
+	b, a, ok := l.operands(ctx, types.KindI32)
+	if !ok {
+		return false
+	}
+	dst := ctx.assembler.Reg(asm.RegTypeInt, asm.Width64)
+	ctx.assembler.Emit(shiftOp(l.narrow32(dst), l.narrow32(a.reg), b.reg))
+	ctx.push(value{reg: dst, kind: types.KindI32, raw: true})
+	return true
+}
+
+func (l amd64Lowerer) i32Eqz(ctx *lowering) bool {
+	if ctx.count() < 1 || !l.kinds(ctx, types.KindI32, 1) {
+		return false
+	}
+	a := ctx.pop()
+	ctx.assembler.Emit(amd64.CMPI(l.narrow32(a.reg), 0))
+	l.setBool(ctx, amd64.CondE)
+	return true
+}
+
+// i32Cmp compares the 32-bit register views: raw upper bits never
+// participate, so signed and unsigned conditions both read correct flags.
+func (l amd64Lowerer) i32Cmp(ctx *lowering, cond uint8) bool {
+	b, a, ok := l.operands(ctx, types.KindI32)
+	if !ok {
+		return false
+	}
+	ctx.assembler.Emit(amd64.CMP(l.narrow32(a.reg), l.narrow32(b.reg)))
+	l.setBool(ctx, cond)
+	return true
+}
+
+// i64Binary lowers an i64 arithmetic opcode on the full signed value; checked
+// ops guard that the result still fits the boxable range and deopt with the
+// operands intact when it overflows.
+func (l amd64Lowerer) i64Binary(ctx *lowering, op step, opfn func(dst, src1, src2 asm.Reg) asm.Instruction, checked bool) bool {
+	if ctx.count() < 2 || !l.kinds(ctx, types.KindI64, 2) {
+		return false
+	}
+	b := ctx.values[len(ctx.values)-1].reg
+	a := ctx.values[len(ctx.values)-2].reg
+	raw := ctx.assembler.Reg(asm.RegTypeInt, asm.Width64)
+	ctx.assembler.Emit(opfn(raw, a, b))
+	if checked && !l.boxableI64(ctx, raw, op.ip) {
+		return false
+	}
+	ctx.pop()
+	ctx.pop()
+	ctx.push(value{reg: raw, kind: types.KindI64, raw: true})
+	return true
+}
+
+// i64Divide divides raw i64 values. Both are boxable, so MinInt64 / -1 never
+// reaches IDIV; a zero divisor exits before it.
+func (l amd64Lowerer) i64Divide(ctx *lowering, op step, div func(dst, src1, src2 asm.Reg) asm.Instruction) bool {
+	if ctx.count() < 2 || !l.kinds(ctx, types.KindI64, 2) {
+		return false
+	}
+	b := ctx.values[len(ctx.values)-1].reg
+	a := ctx.values[len(ctx.values)-2].reg
+
+	top := ctx.values[len(ctx.values)-1]
+	observed := uint64(0)
+	if op.arg.Kind() == types.KindI64 {
+		observed = uint64(op.arg.I64())
+	}
+	if !l.guardDivisor(ctx, top, b, observed, op.ip) {
+		return false
+	}
+
+	raw := ctx.assembler.Reg(asm.RegTypeInt, asm.Width64)
+	ctx.assembler.Emit(div(raw, a, b))
+	if !l.boxableI64(ctx, raw, op.ip) {
+		return false
+	}
+	ctx.pop()
+	ctx.pop()
+	ctx.push(value{reg: raw, kind: types.KindI64, raw: true})
+	return true
+}
+
+// i64Shift relies on the hardware masking a 64-bit shift count to six bits.
+func (l amd64Lowerer) i64Shift(ctx *lowering, op step, opfn func(dst, src1, src2 asm.Reg) asm.Instruction, checked bool) bool {
+	if ctx.count() < 2 || !l.kinds(ctx, types.KindI64, 2) {
+		return false
+	}
+	b := ctx.values[len(ctx.values)-1].reg
+	a := ctx.values[len(ctx.values)-2].reg
+	raw := ctx.assembler.Reg(asm.RegTypeInt, asm.Width64)
+	ctx.assembler.Emit(opfn(raw, a, b))
+	if checked && !l.boxableI64(ctx, raw, op.ip) {
+		return false
+	}
+	ctx.pop()
+	ctx.pop()
+	ctx.push(value{reg: raw, kind: types.KindI64, raw: true})
+	return true
+}
+
+func (l amd64Lowerer) i64Eqz(ctx *lowering) bool {
+	if ctx.count() < 1 || !l.kinds(ctx, types.KindI64, 1) {
+		return false
+	}
+	a := ctx.pop()
+	ctx.assembler.Emit(amd64.CMPI(a.reg, 0))
+	l.setBool(ctx, amd64.CondE)
+	return true
+}
+
+func (l amd64Lowerer) i64Cmp(ctx *lowering, cond uint8) bool {
+	b, a, ok := l.operands(ctx, types.KindI64)
+	if !ok {
+		return false
+	}
+	ctx.assembler.Emit(amd64.CMP(a.reg, b.reg))
+	l.setBool(ctx, cond)
+	return true
+}
+
+// floatBinary moves both raw operands into XMM registers of the kind's width,
+// applies op, and moves the result back. An f32 travels through the 32-bit
+// register views so its upper lane is zero.
+func (l amd64Lowerer) floatBinary(ctx *lowering, kind types.Kind, op func(dst, src1, src2 asm.Reg) asm.Instruction) bool {
+	b, a, ok := l.operands(ctx, kind)
+	if !ok {
+		return false
+	}
+	fa, fb := l.float(ctx, kind, a.reg), l.float(ctx, kind, b.reg)
+	fr := ctx.assembler.Reg(asm.RegTypeFloat, fa.Width())
+	ctx.assembler.Emit(op(fr, fa, fb))
+	dst := ctx.assembler.Reg(asm.RegTypeInt, asm.Width64)
+	ctx.assembler.Emit(amd64.FMOV(l.lane(kind, dst), fr))
+	ctx.push(value{reg: dst, kind: kind, raw: true})
+	return true
+}
+
+// floatCmp lowers an ordered float compare. UCOMIS sets CF on an unordered
+// result, so the above conditions are false for NaN; swap compares b with a
+// to express less-than through them.
+func (l amd64Lowerer) floatCmp(ctx *lowering, kind types.Kind, cond uint8, swap bool) bool {
+	b, a, ok := l.operands(ctx, kind)
+	if !ok {
+		return false
+	}
+	fa, fb := l.float(ctx, kind, a.reg), l.float(ctx, kind, b.reg)
+	if swap {
+		fa, fb = fb, fa
+	}
+	ctx.assembler.Emit(amd64.UCOMIS(fa, fb))
+	l.setBool(ctx, cond)
+	return true
+}
+
+// floatEq lowers EQ and NE. ZF alone also reports an unordered compare, so
+// equality additionally requires PF clear and inequality accepts PF set.
+func (l amd64Lowerer) floatEq(ctx *lowering, kind types.Kind, negate bool) bool {
+	b, a, ok := l.operands(ctx, kind)
+	if !ok {
+		return false
+	}
+	fa, fb := l.float(ctx, kind, a.reg), l.float(ctx, kind, b.reg)
+	a1 := ctx.assembler
+	a1.Emit(amd64.UCOMIS(fa, fb))
+	zero := a1.Reg(asm.RegTypeInt, asm.Width64)
+	parity := a1.Reg(asm.RegTypeInt, asm.Width64)
+	flag := a1.Reg(asm.RegTypeInt, asm.Width64)
+	if negate {
+		a1.Emit(amd64.SETCC(zero, amd64.CondNE), amd64.SETCC(parity, amd64.CondP), amd64.OR(flag, zero, parity))
+	} else {
+		a1.Emit(amd64.SETCC(zero, amd64.CondE), amd64.SETCC(parity, amd64.CondNP), amd64.AND(flag, zero, parity))
+	}
+	ctx.push(value{reg: flag, kind: types.KindI1, raw: true})
+	return true
+}
+
+// float moves the raw bits of a kind-typed value into a fresh XMM register.
+func (l amd64Lowerer) float(ctx *lowering, kind types.Kind, v asm.VReg) asm.VReg {
+	width := asm.Width64
+	if kind == types.KindF32 {
+		width = asm.Width32
+	}
+	f := ctx.assembler.Reg(asm.RegTypeFloat, width)
+	ctx.assembler.Emit(amd64.FMOV(f, l.lane(kind, v)))
+	return f
+}
+
+// lane returns the view of v that holds a raw value of kind.
+func (l amd64Lowerer) lane(kind types.Kind, v asm.VReg) asm.VReg {
+	if kind == types.KindF32 {
+		return l.narrow32(v)
+	}
+	return v
+}
+
+// toFloat converts a raw integer to a raw float. prep sign- or zero-extends an
+// i32 lane first; the signed 64-bit conversion is then exact for both.
+func (l amd64Lowerer) toFloat(ctx *lowering, from, to types.Kind, prep func(*lowering, asm.VReg) asm.VReg) bool {
+	if ctx.count() < 1 || !l.kinds(ctx, from, 1) {
+		return false
+	}
+	a := ctx.pop()
+	val := a.reg
+	if prep != nil {
+		val = prep(ctx, val)
+	}
+	width := asm.Width64
+	if to == types.KindF32 {
+		width = asm.Width32
+	}
+	fr := ctx.assembler.Reg(asm.RegTypeFloat, width)
+	ctx.assembler.Emit(amd64.CVTSI2S(fr, val))
+	dst := ctx.assembler.Reg(asm.RegTypeInt, asm.Width64)
+	ctx.assembler.Emit(amd64.FMOV(l.lane(to, dst), fr))
+	ctx.push(value{reg: dst, kind: to, raw: true})
+	return true
+}
+
+// i32ToI64 widens a raw i32 to a raw i64; the i32 range is within the
+// boxable i64 range so no guard is needed.
+func (l amd64Lowerer) i32ToI64(ctx *lowering, prep func(*lowering, asm.VReg) asm.VReg) bool {
+	if ctx.count() < 1 || !l.kinds(ctx, types.KindI32, 1) {
+		return false
+	}
+	a := ctx.pop()
+	ctx.push(value{reg: prep(ctx, a.reg), kind: types.KindI64, raw: true})
+	return true
+}
+
+func (l amd64Lowerer) i64ToI32(ctx *lowering) bool {
+	if ctx.count() < 1 || !l.kinds(ctx, types.KindI64, 1) {
+		return false
+	}
+	a := ctx.pop()
+	ctx.push(value{reg: l.zero32(ctx, a.reg), kind: types.KindI32, raw: true})
+	return true
+}
+
+// operands pops a typed binary-op pair after checking both kinds.
+func (l amd64Lowerer) operands(ctx *lowering, kind types.Kind) (value, value, bool) {
+	if ctx.count() < 2 || !l.kinds(ctx, kind, 2) {
+		return value{}, value{}, false
+	}
+	b := ctx.pop()
+	a := ctx.pop()
+	return b, a, true
+}
+
+func (l amd64Lowerer) kinds(ctx *lowering, kind types.Kind, n int) bool {
+	for k := 0; k < n; k++ {
+		v := ctx.values[len(ctx.values)-1-k]
+		if v.kind.Repr() != kind.Repr() || !v.raw {
+			return false
+		}
+	}
+	return true
+}
+
+// setBool pushes a comparison result as i1 (see arm64Lowerer.setBool).
+func (l amd64Lowerer) setBool(ctx *lowering, cond uint8) {
+	flag := ctx.assembler.Reg(asm.RegTypeInt, asm.Width64)
+	ctx.assembler.Emit(amd64.SETCC(flag, cond))
+	ctx.push(value{reg: flag, kind: types.KindI1, raw: true})
+}
+
+// guardDivisor deopts before a divide by zero, which faults on x86. When the
+// trace recorded a non-zero divisor, guardRaw owns the mismatch exit.
+func (l amd64Lowerer) guardDivisor(ctx *lowering, divisor value, reg asm.VReg, observed uint64, ip int) bool {
+	guarded := false
+	if !divisor.known && observed != 0 {
+		if !l.guardRaw(ctx, reg, observed, ip) {
+			return false
+		}
+		guarded = true
+	}
+	if divisor.known && divisor.imm != 0 || guarded {
+		return true
+	}
+	fail, ok := l.sideExit(ctx, ctx.values, ip, prof.ExitGuardValue, ctx.opcode(ip))
+	if !ok {
+		return false
+	}
+	ctx.assembler.Emit(amd64.CMPI(reg, 0), amd64.JCCLabel(amd64.CondE, fail))
+	return true
+}
+
+// guardRaw keeps observed narrow inputs speculative: a different runtime value
+// exits before the opcode, so the threaded handler owns the general case.
+func (l amd64Lowerer) guardRaw(ctx *lowering, got asm.VReg, val uint64, ip int) bool {
+	fail, ok := l.sideExit(ctx, ctx.values, ip, prof.ExitGuardValue, ctx.opcode(ip))
+	if !ok {
+		return false
+	}
+	want := ctx.assembler.Reg(asm.RegTypeInt, asm.Width64)
+	ctx.assembler.Emit(amd64.MOVI(want, int64(val)))
+	if got.Width() == asm.Width32 {
+		want = l.narrow32(want)
+	}
+	ctx.assembler.Emit(amd64.CMP(got, want), amd64.JCCLabel(amd64.CondNE, fail))
+	return true
+}
+
+// boxableI64 keeps raw i64 values within the boxed 49-bit lane.
+func (l amd64Lowerer) boxableI64(ctx *lowering, raw asm.VReg, ip int) bool {
+	fail, ok := l.sideExit(ctx, ctx.values, ip, prof.ExitGuardValue, ctx.opcode(ip))
+	if !ok {
+		return false
+	}
+	ext := l.sign64(ctx, raw)
+	ctx.assembler.Emit(amd64.CMP(ext, raw), amd64.JCCLabel(amd64.CondNE, fail))
+	return true
+}
+
+// guardI64 deopts when a boxed i64 was promoted to the heap.
+func (l amd64Lowerer) guardI64(ctx *lowering, v asm.VReg, ip int) bool {
+	fail, ok := l.sideExit(ctx, ctx.values, ip, prof.ExitGuardKind, ctx.opcode(ip))
+	if !ok {
+		return false
+	}
+	tag := ctx.assembler.Reg(asm.RegTypeInt, asm.Width64)
+	ctx.assembler.Emit(amd64.SHRI(tag, v, uint8(types.VBits)))
+	ctx.assembler.Emit(amd64.CMPI(tag, int32(tagI64>>types.VBits)), amd64.JCCLabel(amd64.CondNE, fail))
+	return true
+}
+
+// sign64 sign-extends the 49-bit value lane of a boxed i64 to a full raw
+// i64 value.
+func (l amd64Lowerer) sign64(ctx *lowering, v asm.VReg) asm.VReg {
+	out := ctx.assembler.Reg(asm.RegTypeInt, asm.Width64)
+	ctx.assembler.Emit(
+		amd64.SHLI(out, v, 64-boxableWidth),
+		amd64.SARI(out, out, 64-boxableWidth),
+	)
+	return out
+}
+
+func (l amd64Lowerer) sign32(ctx *lowering, v asm.VReg) asm.VReg {
+	out := ctx.assembler.Reg(asm.RegTypeInt, asm.Width64)
+	ctx.assembler.Emit(amd64.MOVSXD(out, l.narrow32(v)))
+	return out
+}
+
+// zero32 zero-extends the i32 lane: a 32-bit move clears the upper half.
+func (l amd64Lowerer) zero32(ctx *lowering, v asm.VReg) asm.VReg {
+	out := ctx.assembler.Reg(asm.RegTypeInt, asm.Width64)
+	ctx.assembler.Emit(amd64.MOV(l.narrow32(out), l.narrow32(v)))
+	return out
+}
+
+func (amd64Lowerer) narrow32(v asm.VReg) asm.VReg {
+	return asm.NewVReg(v.ID(), v.Type(), asm.Width32)
+}
+
+// zeroLocals clears the non-parameter locals of a function entry, matching
+// the threaded CALL's frame setup.
+func (l amd64Lowerer) zeroLocals(ctx *lowering) {
+	if ctx.kind != entryFunction || len(ctx.frames) == 0 {
+		return
+	}
+	kinds := ctx.frames[0].kinds
+	if len(kinds) <= ctx.params {
+		return
+	}
+	a := ctx.assembler
+	base := l.base(ctx, ctx.pin(scratchStack))
+	for idx := ctx.params; idx < len(kinds); idx++ {
+		reg := a.Reg(asm.RegTypeInt, asm.Width64)
+		a.Emit(amd64.MOVI(reg, int64(types.Zero(kinds[idx]))))
+		a.Emit(amd64.STORE(reg, base, int32(idx*8)))
+	}
+}
+
+// ret writes the entry frame's boxed results to the caller-visible result
+// slots. The subset holds no ref locals, so the frame releases nothing.
+func (l amd64Lowerer) ret(ctx *lowering) bool {
+	if ctx.count() < ctx.returns {
+		return false
+	}
+	a := ctx.assembler
+	addr := l.base(ctx, ctx.pin(scratchStack))
+	for idx := 0; idx < ctx.returns; idx++ {
+		boxed, ok := l.box(ctx, ctx.values[len(ctx.values)-ctx.returns+idx])
+		if !ok {
+			return false
+		}
+		a.Emit(amd64.STORE(boxed, addr, int32(idx*8)))
+	}
+	a.Emit(amd64.RET())
+	return true
+}
+
+// complete finishes top-level module code: live locals and operands are boxed
+// back to the VM stack, SP is published, and the wrapper marks the frame done.
+func (l amd64Lowerer) complete(ctx *lowering) bool {
+	if !l.flush(ctx, flushSnapshot) {
+		return false
+	}
+	a := ctx.assembler
+	vCtrl := ctx.pin(scratchCtrl)
+	sp := a.Reg(asm.RegTypeInt, asm.Width64)
+	a.Emit(amd64.ADDI(sp, ctx.pin(scratchBP), int32(ctx.sp())))
+	a.Emit(amd64.STORE(sp, vCtrl, int32(journalSP*8)))
+	l.report(ctx, vCtrl, trapNone, ctx.frame().end)
+	a.Emit(amd64.RET())
+	return true
+}
+
+// reload pulls operands back from VM stack slots after a continuation.
+func (l amd64Lowerer) reload(ctx *lowering) {
+	if len(ctx.values) == 0 {
+		return
+	}
+	a := ctx.assembler
+	addr := l.base(ctx, ctx.pin(scratchStack))
+	for j := range ctx.values {
+		v := &ctx.values[j]
+		reg := a.Reg(asm.RegTypeInt, asm.Width64)
+		a.Emit(amd64.LOAD(reg, addr, int32(ctx.slot(j)*8)))
+		v.reg = reg
+		if v.kind == types.KindI64 {
+			v.reg = l.sign64(ctx, reg)
+		}
+		v.raw = true
+	}
+}
+
+// loadLocal materializes scalar local idx from the VM stack on first use. A
+// boxed i32 keeps its value in the low lane and a boxed f64 is its own bit
+// pattern, so only an i64 needs a guard and a sign extension.
+func (l amd64Lowerer) loadLocal(ctx *lowering, f *activation, idx, ip int) bool {
+	if f.isLoadedAt(idx) {
+		return true
+	}
+	kind := f.kinds[idx]
+	switch kind {
+	case types.KindI1, types.KindI8, types.KindI32, types.KindF32, types.KindF64, types.KindI64:
+	default:
+		return false
+	}
+	addr := l.base(ctx, ctx.pin(scratchStack))
+	reg := ctx.assembler.Reg(asm.RegTypeInt, asm.Width64)
+	ctx.assembler.Emit(amd64.LOAD(reg, addr, int32((f.base+idx)*8)))
+	if kind == types.KindI64 {
+		if !l.guardI64(ctx, reg, ip) {
+			return false
+		}
+		reg = l.sign64(ctx, reg)
+	}
+	f.locals[idx] = value{reg: reg, kind: kind, raw: true}
+	f.state[idx] |= localLoaded
+	return true
+}
+
+// exit deopts to the threaded interpreter at resume.
+func (l amd64Lowerer) exit(ctx *lowering, resume int, reason prof.ExitReason, opcode int) bool {
+	return l.trap(ctx, trapFallback, resume, reason, opcode)
+}
+
+// trap flushes every live value boxed and returns to the Go wrapper with the
+// trap kind reported (see arm64Lowerer.trap).
+func (l amd64Lowerer) trap(ctx *lowering, kind, resume int, reason prof.ExitReason, opcode int) bool {
+	if !l.flush(ctx, flushSnapshot) {
+		return false
+	}
+	id := -1
+	if kind == trapFallback {
+		id = len(ctx.descriptors)
+		ctx.descriptors = append(ctx.descriptors, exitDescriptor{reason: reason, opcode: opcode})
+	}
+	l.repay(ctx, ctx.refund(resume))
+	l.trapFlushed(ctx, kind, resume, id)
+	return true
+}
+
+// bridge deopts one opcode the plan left to the threaded interpreter; the
+// wrapper re-enters this callable at the closure's new IP.
+func (l amd64Lowerer) bridge(ctx *lowering, ip int) bool {
+	return l.trap(ctx, trapBridge, ip, prof.ExitNone, prof.OpcodeNone)
+}
+
+func (l amd64Lowerer) trapFlushed(ctx *lowering, kind, resume, exitID int) {
+	a := ctx.assembler
+	vCtrl := ctx.pin(scratchCtrl)
+	sp := a.Reg(asm.RegTypeInt, asm.Width64)
+	a.Emit(amd64.ADDI(sp, ctx.pin(scratchBP), int32(ctx.sp())))
+	a.Emit(amd64.STORE(sp, vCtrl, int32(journalSP*8)))
+	l.unwind(ctx, vCtrl, resume)
+	vExit := a.Reg(asm.RegTypeInt, asm.Width64)
+	a.Emit(amd64.MOVI(vExit, int64(exitID+1)))
+	a.Emit(amd64.STORE(vExit, vCtrl, int32(journalExitID*8)))
+	l.report(ctx, vCtrl, kind, resume)
+	a.Emit(amd64.RET())
+}
+
+// unwind appends one journal frame record per live symbolic frame,
+// innermost first so deopt rebuilds the chain in interpreter order.
+func (l amd64Lowerer) unwind(ctx *lowering, vCtrl asm.VReg, resume int) {
+	for k := len(ctx.frames) - 1; k >= 0; k-- {
+		f := &ctx.frames[k]
+		ip := f.resume
+		if k == len(ctx.frames)-1 {
+			ip = resume
+		}
+		l.save(ctx, vCtrl, f, ip)
+	}
+}
+
+func (l amd64Lowerer) save(ctx *lowering, vCtrl asm.VReg, f *activation, ip int) {
+	a := ctx.assembler
+	depth := a.Reg(asm.RegTypeInt, asm.Width64)
+	a.Emit(amd64.LOAD(depth, vCtrl, int32(journalDepth*8)))
+	off := a.Reg(asm.RegTypeInt, asm.Width64)
+	a.Emit(amd64.SHLI(off, depth, 5))
+	base := a.Reg(asm.RegTypeInt, asm.Width64)
+	a.Emit(amd64.ADD(base, vCtrl, off))
+
+	field := a.Reg(asm.RegTypeInt, asm.Width64)
+	a.Emit(amd64.MOVI(field, int64(f.addr)))
+	a.Emit(amd64.STORE(field, base, int32((journalHead+recordAddr)*8)))
+	bp := ctx.pin(scratchBP)
+	if f.base != 0 {
+		shifted := a.Reg(asm.RegTypeInt, asm.Width64)
+		a.Emit(amd64.ADDI(shifted, bp, int32(f.base)))
+		bp = shifted
+	}
+	a.Emit(amd64.STORE(bp, base, int32((journalHead+recordBP)*8)))
+	a.Emit(amd64.MOVI(field, int64(ip)))
+	a.Emit(amd64.STORE(field, base, int32((journalHead+recordIP)*8)))
+	a.Emit(amd64.MOVI(field, int64(f.returns)))
+	a.Emit(amd64.STORE(field, base, int32((journalHead+recordReturns)*8)))
+
+	a.Emit(amd64.ADDI(depth, depth, 1))
+	a.Emit(amd64.STORE(depth, vCtrl, int32(journalDepth*8)))
+}
+
+func (l amd64Lowerer) report(ctx *lowering, vCtrl asm.VReg, trap, nextIP int) {
+	a := ctx.assembler
+	v := a.Reg(asm.RegTypeInt, asm.Width64)
+	a.Emit(amd64.MOVI(v, int64(trap)))
+	a.Emit(amd64.STORE(v, vCtrl, int32(journalTrap*8)))
+	a.Emit(amd64.MOVI(v, int64(nextIP)))
+	a.Emit(amd64.STORE(v, vCtrl, int32(journalNextIP*8)))
+}
+
+func (l amd64Lowerer) sideExit(ctx *lowering, pre []value, resume int, reason prof.ExitReason, opcode int) (asm.Label, bool) {
+	ctx.values = append(ctx.values[:0], pre...)
+	if !l.flush(ctx, flushSnapshot) {
+		return 0, false
+	}
+	label := ctx.queueExit(nil, resume, reason, opcode)
+	ctx.values = append(ctx.values[:0], pre...)
+	return label, true
+}
+
+// flush writes dirty locals and live operands to their VM stack slots in
+// boxed form. Snapshot flushes remember fixed local homes so later guards do
+// not repeat unchanged local stores; definitions clear that mark.
+func (l amd64Lowerer) flush(ctx *lowering, mode flushMode) bool {
+	a := ctx.assembler
+	var addr asm.VReg
+	for fi := range ctx.frames {
+		f := &ctx.frames[fi]
+		for idx := range f.kinds {
+			if f.state[idx]&localDirty == 0 {
+				continue
+			}
+			if f.state[idx]&localStored == 0 {
+				boxed, ok := l.box(ctx, f.locals[idx])
+				if !ok {
+					return false
+				}
+				if addr.Width() == asm.WidthUndefined {
+					addr = l.base(ctx, ctx.pin(scratchStack))
+				}
+				a.Emit(amd64.STORE(boxed, addr, int32((f.base+idx)*8)))
+				f.state[idx] |= localStored
+			}
+			if mode == flushCommit {
+				f.state[idx] &^= localDirty | localStored
+			}
+		}
+	}
+	for j, v := range ctx.values {
+		boxed, ok := l.box(ctx, v)
+		if !ok {
+			return false
+		}
+		if addr.Width() == asm.WidthUndefined {
+			addr = l.base(ctx, ctx.pin(scratchStack))
+		}
+		a.Emit(amd64.STORE(boxed, addr, int32(ctx.slot(j)*8)))
+	}
+	return true
+}
+
+// clearLocals invalidates every local cache; the next read reloads its slot.
+func (l amd64Lowerer) clearLocals(ctx *lowering) {
+	for idx := range ctx.frames {
+		clear(ctx.frames[idx].state)
+	}
+}
+
+// base returns &stack[bp] in a register. A leaf caches it in the scratch SP
+// register; the subset is always a leaf.
+func (l amd64Lowerer) base(ctx *lowering, vStack asm.VReg) asm.VReg {
+	addr := ctx.pin(scratchSP)
+	ctx.assembler.Emit(
+		amd64.SHLI(addr, ctx.pin(scratchBP), 3),
+		amd64.ADD(addr, vStack, addr),
+	)
+	return addr
+}
+
+// box produces the boxed form of a raw scalar in a fresh register.
+func (l amd64Lowerer) box(ctx *lowering, v value) (asm.VReg, bool) {
+	a := ctx.assembler
+	var tag uint64
+	switch v.kind {
+	case types.KindI1:
+		tag = tagI1
+	case types.KindI8:
+		tag = tagI8
+	case types.KindI32:
+		tag = tagI32
+	case types.KindF32:
+		tag = tagF32
+	case types.KindI64:
+		// Raw i64 is the full signed value and boxable by invariant; mask the
+		// 49-bit lane and tag.
+		lo := a.Reg(asm.RegTypeInt, asm.Width64)
+		a.Emit(amd64.MOVI(lo, int64(maskI64)), amd64.AND(lo, lo, v.reg))
+		t := a.Reg(asm.RegTypeInt, asm.Width64)
+		a.Emit(amd64.MOVI(t, int64(tagI64)), amd64.OR(lo, lo, t))
+		return lo, true
+	case types.KindF64:
+		return v.reg, true
+	default:
+		return asm.VReg{}, false
+	}
+	if !v.raw {
+		return v.reg, true
+	}
+	lo := l.zero32(ctx, v.reg)
+	t := a.Reg(asm.RegTypeInt, asm.Width64)
+	a.Emit(amd64.MOVI(t, int64(tag)), amd64.OR(lo, lo, t))
+	return lo, true
+}
+
+// lower emits one plan through the common block pipeline. Loop-carried
+// locals stay in their VM slots: every callee-saved register the trampoline
+// preserves already holds journal state, so plan.carried is ignored and a
+// back-edge commits dirty locals instead. A hoisted container is a ref and
+// is likewise left to per-access derivation, which the subset rejects.
+func lower(ctx *lowering, plan plan) bool {
+	l := amd64Lowerer{}
+	ctx.leaf = true
+	if len(ctx.frames) != 1 {
+		return false
+	}
+	for _, kind := range ctx.frames[0].kinds {
+		if kind == types.KindRef {
+			return false
+		}
+	}
+	ctx.blocks = plan.blocks
+	ctx.kind = plan.kind
+	for id, block := range ctx.blocks {
+		if !block.tail && block.state != nil {
+			ctx.labels[id] = ctx.assembler.Label()
+		}
+	}
+	l.enter(ctx)
+	root := plan.root
+	ctx.loopRoot = root
+	if _, ok := ctx.labels[root]; !ok {
+		ctx.labels[root] = ctx.assembler.Label()
+	}
+	ctx.back = ctx.labels[root]
+	if ctx.nativeLoop {
+		ctx.budget = ctx.assembler.Reg(asm.RegTypeInt, asm.Width64)
+		ctx.assembler.Emit(amd64.LOAD(ctx.budget, ctx.pin(scratchCtrl), int32(journalBudget*8)))
+	}
+	ctx.assembler.Bind(ctx.back)
+	if !l.emitBlock(ctx, root, nil) {
+		return false
+	}
+	for id, block := range ctx.blocks {
+		if id == root || block.tail || block.state == nil {
+			continue
+		}
+		ctx.assembler.Bind(ctx.labels[id])
+		if !l.emitBlock(ctx, id, nil) {
+			return false
+		}
+	}
+	for n := 0; n < len(ctx.work); n++ {
+		work := ctx.work[n]
+		ctx.values = work.values
+		ctx.frames = work.frames
+		ctx.assembler.Bind(work.label)
+		l.clearLocals(ctx)
+		l.reload(ctx)
+		if !l.emitBlock(ctx, work.block, work.tail) {
+			return false
+		}
+	}
+	return l.emitExits(ctx)
+}
@@ -0,0 +1,288 @@
+package interp
+
+import (
+	"context"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/prof"
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
+	"github.com/stretchr/testify/require"
+)
+
+// Loop runs scalar loop bodies natively and checks every result against
+// the threaded interpreter. Local 0 is the counter and local 1 the
+// accumulator the body updates; the program leaves local 1 on the stack.
+func TestAMD64_Loop(t *testing.T) {
+	f64 := func(v float64) uint64 { return math.Float64bits(v) }
+	f32 := func(v float32) uint64 { return uint64(math.Float32bits(v)) }
+
+	tests := []struct {
+		name  string
+		acc   types.Type
+		init  instr.Instruction
+		limit int32
+		body  func(b *program.Builder)
+	}{
+		{
+			name:  "i32 sum",
+			acc:   types.TypeI32,
+			init:  instr.New(instr.I32_CONST, 0),
+			limit: 100,
+			body: func(b *program.Builder) {
+				b.Emit(instr.LOCAL_GET, 1).Emit(instr.LOCAL_GET, 0).Emit(instr.I32_ADD).Emit(instr.LOCAL_SET, 1)
+			},
+		},
+		{
+			name:  "i32 mixed",
+			acc:   types.TypeI32,
+			init:  instr.New(instr.I32_CONST, 7),
+			limit: 64,
+			body: func(b *program.Builder) {
+				b.Emit(instr.LOCAL_GET, 1).Emit(instr.I32_CONST, 31).Emit(instr.I32_MUL).
+					Emit(instr.LOCAL_GET, 0).Emit(instr.I32_SHL).
+					Emit(instr.LOCAL_GET, 0).Emit(instr.I32_CONST, 3).Emit(instr.I32_REM_U).
+					Emit(instr.I32_XOR).
+					Emit(instr.I32_CONST, uint64(uint32(0x7fff_ffff))).Emit(instr.I32_AND).
+					Emit(instr.I32_CONST, 3).Emit(instr.I32_DIV_S).
+					Emit(instr.LOCAL_GET, 0).Emit(instr.I32_SHR_U).
+					Emit(instr.LOCAL_SET, 1)
+			},
+		},
+		{
+			name:  "i32 compare",
+			acc:   types.TypeI32,
+			init:  instr.New(instr.I32_CONST, 0),
+			limit: 64,
+			body: func(b *program.Builder) {
+				b.Emit(instr.LOCAL_GET, 1).
+					Emit(instr.LOCAL_GET, 0).Emit(instr.I32_CONST, uint64(uint32(0xffff_fff0))).Emit(instr.I32_LT_U).
+					Emit(instr.I32_ADD).
+					Emit(instr.LOCAL_GET, 0).Emit(instr.I32_CONST, uint64(uint32(0xffff_fff0))).Emit(instr.I32_GT_S).
+					Emit(instr.I32_ADD).
+					Emit(instr.LOCAL_SET, 1)
+			},
+		},
+		{
+			name:  "i64 overflow",
+			acc:   types.TypeI64,
+			init:  instr.New(instr.I64_CONST, 1),
+			limit: 40,
+			body: func(b *program.Builder) {
+				b.Emit(instr.LOCAL_GET, 1).Emit(instr.I64_CONST, 3).Emit(instr.I64_MUL).Emit(instr.LOCAL_SET, 1)
+			},
+		},
+		{
+			name:  "i64 divide",
+			acc:   types.TypeI64,
+			init:  instr.New(instr.I64_CONST, 1<<40),
+			limit: 64,
+			body: func(b *program.Builder) {
+				b.Emit(instr.LOCAL_GET, 1).
+					Emit(instr.LOCAL_GET, 0).Emit(instr.I32_TO_I64_S).Emit(instr.I64_CONST, 1).Emit(instr.I64_ADD).
+					Emit(instr.I64_REM_S).
+					Emit(instr.LOCAL_GET, 1).Emit(instr.I64_CONST, 2).Emit(instr.I64_DIV_S).
+					Emit(instr.I64_ADD).
+					Emit(instr.LOCAL_SET, 1)
+			},
+		},
+		{
+			name:  "f64 recurrence",
+			acc:   types.TypeF64,
+			init:  instr.New(instr.F64_CONST, f64(1)),
+			limit: 64,
+			body: func(b *program.Builder) {
+				b.Emit(instr.LOCAL_GET, 1).Emit(instr.F64_CONST, f64(1.5)).Emit(instr.F64_MUL).
+					Emit(instr.LOCAL_GET, 0).Emit(instr.I32_TO_F64_S).Emit(instr.F64_SUB).
+					Emit(instr.F64_CONST, f64(3)).Emit(instr.F64_DIV).
+					Emit(instr.LOCAL_SET, 1)
+			},
+		},
+		{
+			name:  "f32 recurrence",
+			acc:   types.TypeF32,
+			init:  instr.New(instr.F32_CONST, f32(2)),
+			limit: 64,
+			body: func(b *program.Builder) {
+				b.Emit(instr.LOCAL_GET, 1).Emit(instr.F32_CONST, f32(0.75)).Emit(instr.F32_MUL).
+					Emit(instr.LOCAL_GET, 0).Emit(instr.I32_TO_F32_U).Emit(instr.F32_ADD).
+					Emit(instr.LOCAL_SET, 1)
+			},
+		},
+		{
+			name:  "nan compare",
+			acc:   types.TypeI32,
+			init:  instr.New(instr.I32_CONST, 0),
+			limit: 64,
+			body: func(b *program.Builder) {
+				nan := func(op instr.Opcode) {
+					b.Emit(instr.F64_CONST, f64(math.NaN())).Emit(instr.LOCAL_GET, 0).Emit(instr.I32_TO_F64_S).Emit(op)
+				}
+				b.Emit(instr.LOCAL_GET, 1)
+				for _, op := range []instr.Opcode{instr.F64_EQ, instr.F64_LT, instr.F64_LE, instr.F64_GT, instr.F64_GE} {
+					nan(op)
+					b.Emit(instr.I32_ADD)
+				}
+				nan(instr.F64_NE)
+				b.Emit(instr.I32_CONST, 10).Emit(instr.I32_MUL).Emit(instr.I32_ADD).Emit(instr.LOCAL_SET, 1)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := program.NewBuilder()
+			b.Locals(types.TypeI32, tt.acc)
+			loop := b.Label()
+			done := b.Label()
+			b.Emit(instr.I32_CONST, 0).Emit(instr.LOCAL_SET, 0)
+			b.Emit(tt.init.Opcode(), tt.init.Operands()...).Emit(instr.LOCAL_SET, 1)
+			b.Bind(loop)
+			b.Emit(instr.LOCAL_GET, 0).Emit(instr.I32_CONST, uint64(uint32(tt.limit))).Emit(instr.I32_GE_S).BrIf(done)
+			tt.body(b)
+			b.Emit(instr.LOCAL_GET, 0).Emit(instr.I32_CONST, 1).Emit(instr.I32_ADD).Emit(instr.LOCAL_SET, 0).Br(loop)
+			b.Bind(done).Emit(instr.LOCAL_GET, 1)
+			prog, err := b.Build()
+			require.NoError(t, err)
+
+			threaded := New(prog, WithThreshold(-1))
+			defer threaded.Close()
+			require.NoError(t, threaded.Run(context.Background()))
+			want, err := threaded.Pop()
+			require.NoError(t, err)
+
+			profile := prof.New()
+			jit := New(prog, WithTick(1), WithThreshold(0), WithProfiler(profile))
+			for iteration := 0; iteration < 8; iteration++ {
+				require.NoError(t, jit.Run(context.Background()))
+				got, err := jit.Pop()
+				require.NoError(t, err)
+				require.Equal(t, want, got)
+				jit.Reset()
+			}
+			require.NoError(t, jit.Close())
+
+			var entries float64
+			for _, metric := range profile.Metrics() {
+				if metric.Name == "vm_jit_native_entries_total" {
+					entries += metric.Value
+				}
+			}
+			require.Greater(t, entries, float64(0))
+		})
+	}
+}
+
+// Yield protects the back-edge safepoint: a loop longer than one budget
+// traps with its counter committed and resumes natively afterward.
+func TestAMD64_Yield(t *testing.T) {
+	const limit = int32(loopBudget + 3)
+	b := program.NewBuilder()
+	b.Locals(types.TypeI32)
+	loop := b.Label()
+	done := b.Label()
+	b.Emit(instr.I32_CONST, 0).Emit(instr.LOCAL_SET, 0)
+	b.Bind(loop)
+	b.Emit(instr.LOCAL_GET, 0).Emit(instr.I32_CONST, uint64(uint32(limit))).Emit(instr.I32_GE_S).BrIf(done)
+	b.Emit(instr.LOCAL_GET, 0).Emit(instr.I32_CONST, 1).Emit(instr.I32_ADD).Emit(instr.LOCAL_SET, 0).Br(loop)
+	b.Bind(done).Emit(instr.LOCAL_GET, 0)
+	prog, err := b.Build()
+	require.NoError(t, err)
+
+	profile := prof.New()
+	jit := New(prog, WithTick(1), WithThreshold(0), WithProfiler(profile))
+	for iteration := 0; iteration < 4; iteration++ {
+		require.NoError(t, jit.Run(context.Background()))
+		got, err := jit.PopBoxed()
+		require.NoError(t, err)
+		require.Equal(t, types.BoxI32(limit), got)
+		jit.Reset()
+	}
+	require.NoError(t, jit.Close())
+
+	var yields float64
+	for _, metric := range profile.Metrics() {
+		if metric.Name == "vm_jit_native_yields_total" {
+			yields += metric.Value
+		}
+	}
+	require.Greater(t, yields, float64(0))
+}
+
+// DivideByZero keeps the x86 divide fault out of native code: the zero
+// divisor exits before IDIV and the threaded handler reports the error.
+func TestAMD64_DivideByZero(t *testing.T) {
+	b := program.NewBuilder()
+	b.Locals(types.TypeI32, types.TypeI32)
+	loop := b.Label()
+	b.Emit(instr.I32_CONST, 8).Emit(instr.LOCAL_SET, 0)
+	b.Bind(loop)
+	b.Emit(instr.I32_CONST, 64).Emit(instr.LOCAL_GET, 0).Emit(instr.I32_DIV_S).Emit(instr.LOCAL_SET, 1)
+	b.Emit(instr.LOCAL_GET, 0).Emit(instr.I32_CONST, 1).Emit(instr.I32_SUB).Emit(instr.LOCAL_TEE, 0)
+	b.Emit(instr.I32_CONST, uint64(uint32(0xffff_ffff))).Emit(instr.I32_GT_S).BrIf(loop)
+	prog, err := b.Build()
+	require.NoError(t, err)
+
+	threaded := New(prog, WithThreshold(-1))
+	defer threaded.Close()
+	want := threaded.Run(context.Background())
+	require.Error(t, want)
+
+	jit := New(prog, WithTick(1), WithThreshold(0))
+	defer jit.Close()
+	require.EqualError(t, jit.Run(context.Background()), want.Error())
+}
+
+// Fuel charges native blocks what the threaded handlers charge: the run
+// stops on the same instruction with the same fuel spent, and a Refuel
+// finishes it with the same result.
+func TestAMD64_Fuel(t *testing.T) {
+	b := program.NewBuilder()
+	b.Locals(types.TypeI32, types.TypeI32)
+	loop := b.Label()
+	b.Emit(instr.I32_CONST, 1000).Emit(instr.LOCAL_SET, 0)
+	b.Bind(loop)
+	b.Emit(instr.LOCAL_GET, 1).Emit(instr.LOCAL_GET, 0).Emit(instr.I32_CONST, 3).Emit(instr.I32_MUL).Emit(instr.I32_ADD).Emit(instr.LOCAL_SET, 1)
+	b.Emit(instr.LOCAL_GET, 0).Emit(instr.I32_CONST, 1).Emit(instr.I32_SUB).Emit(instr.LOCAL_TEE, 0)
+	b.Emit(instr.I32_CONST, 0).Emit(instr.I32_GT_S).BrIf(loop)
+	b.Emit(instr.LOCAL_GET, 1)
+	prog, err := b.Build()
+	require.NoError(t, err)
+
+	costs := NewCostTable(1)
+	costs.Opcodes[instr.I32_MUL] = 5
+	costs.Opcodes[instr.BR_IF] = 2
+
+	profile := prof.New()
+	threaded := New(prog, WithThreshold(-1), WithFuel(3333), WithCosts(costs))
+	defer threaded.Close()
+	jit := New(prog, WithThreshold(0), WithFuel(3333), WithCosts(costs), WithProfiler(profile))
+
+	for _, i := range []*Interpreter{threaded, jit} {
+		require.ErrorIs(t, i.Run(context.Background()), ErrFuelExhausted)
+	}
+	require.Equal(t, threaded.FuelConsumed(), jit.FuelConsumed())
+	require.Equal(t, threaded.fr.ip, jit.fr.ip)
+
+	for _, i := range []*Interpreter{threaded, jit} {
+		i.Refuel(1 << 20)
+		require.NoError(t, i.Run(context.Background()))
+	}
+	want, err := threaded.PopBoxed()
+	require.NoError(t, err)
+	got, err := jit.PopBoxed()
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+	require.Equal(t, threaded.FuelConsumed(), jit.FuelConsumed())
+	require.NoError(t, jit.Close())
+
+	var exits float64
+	for _, metric := range profile.Metrics() {
+		if metric.Name == "vm_jit_native_exits_total" && slices.Contains(metric.Labels, prof.Label{Key: "reason", Value: "fuel"}) {
+			exits += metric.Value
+		}
+	}
+	require.Greater(t, exits, float64(0))
+}
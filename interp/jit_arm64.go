@@ -15,21 +15,6 @@ import (
 // arm64Lowerer is the AArch64 JIT lowerer.
 type arm64Lowerer struct{}
 
-type flushMode uint8
-
-const (
-	flushSnapshot flushMode = iota
-	flushCommit
-)
-
-// Boxing masks used by scalar lowering.
-const (
-	maskI32 = uint64(0xFFFFFFFF)
-	maskI64 = uint64(0x0001_FFFF_FFFF_FFFF)
-
-	boxableWidth = uint8(49)
-)
-
 const (
 	sliceData       = 0
 	sliceLen        = 8
@@ -50,26 +35,8 @@ const (
 	coroDone        = int(unsafe.Offsetof(coroutine{}.done))
 )
 
-const branchTableLimit = 32
-
-// workLimit bounds work growth from learned continuations; existing work
-// reuses its native label, while new states keep the deopt fallback.
-const workLimit = 256
-
 const nativeBackend = true
 
-// Boxing tags used by scalar lowering, derived from the Kind
-// tag layout so they track any reordering of the Kind enum. i1/i8 share the i32
-// representation and box through tagI32.
-var (
-	tagI1  = types.Tag(types.KindI1)
-	tagI8  = types.Tag(types.KindI8)
-	tagI32 = types.Tag(types.KindI32)
-	tagI64 = types.Tag(types.KindI64)
-	tagF32 = types.Tag(types.KindF32)
-	tagRef = types.Tag(types.KindRef)
-)
-
 func newCompiler() (*compiler, error) {
 	buffer, err := asm.NewBuffer(4096)
 	if err != nil {
@@ -193,6 +160,7 @@ func (l arm64Lowerer) emitExits(ctx *lowering) bool {
 				ctx.assembler.Emit(arm64.STRR(rc, rcBase, refAddr))
 			}
 		}
+		l.repay(ctx, exit.refund)
 		l.trapFlushed(ctx, trapFallback, exit.resume, exit.id)
 	}
 	return true
@@ -217,6 +185,10 @@ func (l arm64Lowerer) emitBlock(ctx *lowering, id int, tail []int) bool {
 		l.clearLocals(ctx)
 		l.reload(ctx)
 	}
+	ctx.meter.term = block.term
+	if !l.charge(ctx, block.steps, 0) {
+		return false
+	}
 	done, ok := l.steps(ctx, block.steps)
 	if !ok {
 		return false
@@ -345,6 +317,7 @@ func (l arm64Lowerer) steps(ctx *lowering, ops []step) (bool, bool) {
 		if op.fn != f.addr {
 			return false, false
 		}
+		ctx.meter.at = idx
 		consumed := l.fuse(ctx, ops, idx)
 		if consumed > 0 {
 			idx += consumed - 1
@@ -795,10 +768,68 @@ func (l arm64Lowerer) steps(ctx *lowering, ops []step) (bool, bool) {
 		if !ok {
 			return false, false
 		}
+		if ctx.costs != nil && ctx.meter.end == idx+1 && !l.charge(ctx, ops, idx+1) {
+			return false, false
+		}
 	}
+	ctx.meter.at = len(ops)
 	return false, true
 }
 
+// charge opens the fuel segment starting at ops[from] (see lowering.segment),
+// or the terminator's own when from is past the last step: it takes the
+// segment's cost out of the journal's fuel cell up front, or exits to the
+// interpreter at the segment's first instruction when the tank cannot cover
+// it. Nothing is charged yet when that exit is taken, so it refunds nothing.
+func (l arm64Lowerer) charge(ctx *lowering, ops []step, from int) bool {
+	m := &ctx.meter
+	m.ops, m.at, m.end, m.tail = ops, from, from, 0
+	if ctx.costs == nil {
+		m.end = len(ops)
+		return true
+	}
+	end, cost := ctx.segment(ops, from)
+	var tail int64
+	if end == len(ops) {
+		tail = ctx.price(m.term)
+		cost = saturate(cost, tail)
+	}
+	if cost > 0 {
+		resume, opcode := m.term.ip, prof.OpcodeNone
+		if from < len(ops) {
+			resume, opcode = ops[from].ip, int(ops[from].op)
+		}
+		fail, ok := l.sideExit(ctx, ctx.pre(), resume, prof.ExitFuel, opcode)
+		if !ok {
+			return false
+		}
+		a := ctx.assembler
+		vCtrl := ctx.pin(scratchCtrl)
+		fuel := a.Reg(asm.RegTypeInt, asm.Width64)
+		price := a.Reg(asm.RegTypeInt, asm.Width64)
+		a.Emit(arm64.LDR(fuel, vCtrl, int16(journalFuel*8)))
+		a.Emit(arm64.LDI(price, uint64(cost))...)
+		a.Emit(arm64.CMP(fuel, price), arm64.BCondLabel(arm64.OpBLT, fail))
+		a.Emit(arm64.SUB(fuel, fuel, price), arm64.STR(fuel, vCtrl, int16(journalFuel*8)))
+	}
+	m.end, m.tail = end, tail
+	return true
+}
+
+// repay returns n fuel an exit was charged for but leaves to the interpreter.
+func (l arm64Lowerer) repay(ctx *lowering, n int64) {
+	if n == 0 {
+		return
+	}
+	a := ctx.assembler
+	vCtrl := ctx.pin(scratchCtrl)
+	fuel := a.Reg(asm.RegTypeInt, asm.Width64)
+	amount := a.Reg(asm.RegTypeInt, asm.Width64)
+	a.Emit(arm64.LDR(fuel, vCtrl, int16(journalFuel*8)))
+	a.Emit(arm64.LDI(amount, uint64(n))...)
+	a.Emit(arm64.ADD(fuel, fuel, amount), arm64.STR(fuel, vCtrl, int16(journalFuel*8)))
+}
+
 // fuse lowers an adjacent constant function load and call as one marker.
 // It returns the number of source steps consumed; a miss leaves standalone
 // lowering untouched.
@@ -4032,6 +4063,7 @@ func (l arm64Lowerer) trap(ctx *lowering, kind, resume int, reason prof.ExitReas
 		id = len(ctx.descriptors)
 		ctx.descriptors = append(ctx.descriptors, exitDescriptor{reason: reason, opcode: opcode})
 	}
+	l.repay(ctx, ctx.refund(resume))
 	l.trapFlushed(ctx, kind, resume, id)
 	return true
 }
@@ -4689,13 +4721,3 @@ func (l arm64Lowerer) hoist(ctx *lowering, h hoist, resume int) bool {
 	ctx.hoist.slot, ctx.hoist.want, ctx.hoist.live = slot, h.want, true
 	return true
 }
-
-func appendTail(steps, tail []int) []int {
-	if len(steps) == 0 {
-		return tail
-	}
-	if len(tail) == 0 {
-		return steps
-	}
-	return append(append([]int(nil), steps...), tail...)
-}
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"testing"
@@ -4422,3 +4423,55 @@ func TestARM64_HostStructLoop(t *testing.T) {
 		}
 	})
 }
+
+// Fuel charges native blocks what the threaded handlers charge: the run
+// stops on the same instruction with the same fuel spent, and a Refuel
+// finishes it with the same result.
+func TestARM64_Fuel(t *testing.T) {
+	b := program.NewBuilder()
+	b.Locals(types.TypeI32, types.TypeI32)
+	loop := b.Label()
+	b.Emit(instr.I32_CONST, 1000).Emit(instr.LOCAL_SET, 0)
+	b.Bind(loop)
+	b.Emit(instr.LOCAL_GET, 1).Emit(instr.LOCAL_GET, 0).Emit(instr.I32_CONST, 3).Emit(instr.I32_MUL).Emit(instr.I32_ADD).Emit(instr.LOCAL_SET, 1)
+	b.Emit(instr.LOCAL_GET, 0).Emit(instr.I32_CONST, 1).Emit(instr.I32_SUB).Emit(instr.LOCAL_TEE, 0)
+	b.Emit(instr.I32_CONST, 0).Emit(instr.I32_GT_S).BrIf(loop)
+	b.Emit(instr.LOCAL_GET, 1)
+	prog, err := b.Build()
+	require.NoError(t, err)
+
+	costs := NewCostTable(1)
+	costs.Opcodes[instr.I32_MUL] = 5
+	costs.Opcodes[instr.BR_IF] = 2
+
+	profile := prof.New()
+	threaded := New(prog, WithThreshold(-1), WithFuel(3333), WithCosts(costs))
+	defer threaded.Close()
+	jit := New(prog, WithThreshold(0), WithFuel(3333), WithCosts(costs), WithProfiler(profile))
+
+	for _, i := range []*Interpreter{threaded, jit} {
+		require.ErrorIs(t, i.Run(context.Background()), ErrFuelExhausted)
+	}
+	require.Equal(t, threaded.FuelConsumed(), jit.FuelConsumed())
+	require.Equal(t, threaded.fr.ip, jit.fr.ip)
+
+	for _, i := range []*Interpreter{threaded, jit} {
+		i.Refuel(1 << 20)
+		require.NoError(t, i.Run(context.Background()))
+	}
+	want, err := threaded.PopBoxed()
+	require.NoError(t, err)
+	got, err := jit.PopBoxed()
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+	require.Equal(t, threaded.FuelConsumed(), jit.FuelConsumed())
+	require.NoError(t, jit.Close())
+
+	var exits float64
+	for _, metric := range profile.Metrics() {
+		if metric.Name == "vm_jit_native_exits_total" && slices.Contains(metric.Labels, prof.Label{Key: "reason", Value: "fuel"}) {
+			exits += metric.Value
+		}
+	}
+	require.Greater(t, exits, float64(0))
+}
@@ -53,6 +53,7 @@ type compileInput struct {
 	// decl is the program's declared-type table, indexed by the type operand
 	// of STRUCT_NEW and REF_CAST (see program.WithTypes).
 	decl      []types.Type
+	costs     *costs
 	installed bool
 }
 
@@ -116,6 +117,10 @@ type terminator struct {
 	ip    int
 	hot   int
 	edges []edge
+	// implicit marks a branch or return no instruction stands behind: a block
+	// falling into its successor, a function running off its end, or a trace
+	// rejoining its loop header. It costs no fuel (see lowering.price).
+	implicit bool
 }
 
 type terminatorKind uint8
@@ -164,6 +169,7 @@ func input(i *Interpreter, addr int) (*compileInput, bool) {
 		globals:   i.globalKinds(),
 		heap:      i.heap,
 		decl:      i.types,
+		costs:     i.costs,
 		installed: i.stub(addr) != nil,
 	}, true
 }
@@ -356,10 +362,10 @@ func staticPlan(input *compileInput) ([]plan, error) {
 				if input.address == 0 {
 					target.term = terminator{kind: terminateComplete, ip: source.End}
 				} else {
-					target.term = terminator{kind: terminateReturn, ip: source.End}
+					target.term = terminator{kind: terminateReturn, ip: source.End, implicit: true}
 				}
 			} else {
-				target.term = terminator{kind: terminateBranch, ip: source.End, hot: -1, edges: []edge{jump(input.address, source.End)}}
+				target.term = terminator{kind: terminateBranch, ip: source.End, hot: -1, edges: []edge{jump(input.address, source.End)}, implicit: true}
 			}
 		}
 		result.blocks = append(result.blocks, target)
@@ -509,7 +515,9 @@ func headers(blocks []block) []int {
 // excluded even though the backend cannot lower them either: suspension
 // cannot resume mid-frame into native code (see docs/jit-internals.md,
 // Suspension), so they keep their unconditional terminal-fallback treatment
-// in arm64Lowerer.steps instead of becoming a bridge.
+// in arm64Lowerer.steps instead of becoming a bridge. Every opcode that
+// allocates or grows a heap value is listed, which is also what keeps native
+// code inside WithMemoryLimit: the bridged closure charges the growth.
 func bridgeable(op instr.Opcode) bool {
 	switch op {
 	case instr.ARRAY_NEW, instr.ARRAY_NEW_DEFAULT, instr.ARRAY_SLICE, instr.ARRAY_DELETE,
@@ -871,7 +879,7 @@ func split(p *plan, tr *trace, input *compileInput) []block {
 			// of a deopt round trip. Cuts inside an inlined frame keep the
 			// fallback — the root block expects the anchor frame only.
 			if rejoins(op) {
-				current.term = terminator{kind: terminateBranch, ip: op.target, hot: 0, edges: []edge{jump(op.fn, op.target)}}
+				current.term = terminator{kind: terminateBranch, ip: op.target, hot: 0, edges: []edge{jump(op.fn, op.target)}, implicit: true}
 			} else {
 				current.term = terminator{kind: terminateFallback, ip: op.target, hot: -1}
 			}
@@ -963,7 +971,7 @@ func split(p *plan, tr *trace, input *compileInput) []block {
 		}
 		current.term = terminator{kind: terminateFallback, ip: resume, hot: -1}
 	case loop:
-		current.term = terminator{kind: terminateBranch, ip: tr.anchor.ip, hot: 0, edges: []edge{{anchor: tr.anchor, block: local(0)}}}
+		current.term = terminator{kind: terminateBranch, ip: tr.anchor.ip, hot: 0, edges: []edge{{anchor: tr.anchor, block: local(0)}}, implicit: true}
 	case aborted:
 		return nil
 	default:
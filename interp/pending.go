@@ -0,0 +1,138 @@
+package interp
+
+import (
+	"sync"
+
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/types"
+)
+
+// Pending is the result of an asynchronous host call, delivered later. The
+// interpreter that made the call suspends until the host completes it; a
+// completed handle's results belong to that interpreter once it resumes.
+type Pending struct {
+	mu      sync.Mutex
+	done    chan struct{}
+	out     []types.Boxed
+	err     error
+	settled bool
+	waiters []func()
+}
+
+// NewPending returns a handle to complete later with Complete.
+func NewPending() *Pending {
+	return &Pending{done: make(chan struct{})}
+}
+
+// Complete delivers the call's results, or the error it fails with, and
+// reports whether it did: only the first Complete counts. It may be called
+// from any goroutine, including from the host function before it returns.
+// Like a HostFunction's results, out transfers ownership of its refs.
+func (p *Pending) Complete(out []types.Boxed, err error) bool {
+	p.mu.Lock()
+	if p.settled {
+		p.mu.Unlock()
+		return false
+	}
+	p.out, p.err, p.settled = out, err, true
+	close(p.done)
+	waiters := p.waiters
+	p.waiters = nil
+	p.mu.Unlock()
+
+	for _, fn := range waiters {
+		fn()
+	}
+	return true
+}
+
+// Done returns a channel closed once the handle is completed.
+func (p *Pending) Done() <-chan struct{} {
+	return p.done
+}
+
+// notify calls fn once the handle is completed: on the completing goroutine,
+// or right away when it already is.
+func (p *Pending) notify(fn func()) {
+	p.mu.Lock()
+	if !p.settled {
+		p.waiters = append(p.waiters, fn)
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+	fn()
+}
+
+func (p *Pending) ready() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// NewAsyncHostFunction builds a host function whose results arrive later.
+// When fn returns a handle that is not yet completed, Run unwinds as it does
+// for a root YIELD and returns ErrPending with the call still in place; once
+// the host completes the handle, the next Run finishes the call and carries
+// on. A nil handle completes the call with no results. Call has to hand
+// results back, so a guest it runs waits on the handle instead.
+func NewAsyncHostFunction(typ *types.FunctionType, fn func(i *Interpreter, params []types.Boxed) (*Pending, error)) *HostFunction {
+	f := &HostFunction{Typ: typ}
+	f.Fn = func(i *Interpreter, params []types.Boxed) ([]types.Boxed, error) {
+		return i.await(f, fn, params)
+	}
+	return f
+}
+
+// Waiting returns the handle Run is suspended on, or nil when it is not.
+func (i *Interpreter) Waiting() *Pending {
+	return i.waiting
+}
+
+// await runs an async host call to completion or suspends on it. The call
+// handler runs again on resume with its arguments untouched, so a waiting
+// handle answers that second run instead of calling fn anew.
+func (i *Interpreter) await(f *HostFunction, fn func(*Interpreter, []types.Boxed) (*Pending, error), params []types.Boxed) ([]types.Boxed, error) {
+	p := i.waiting
+	if p == nil {
+		var err error
+		if p, err = fn(i, params); err != nil || p == nil {
+			return nil, err
+		}
+	}
+	if !p.ready() {
+		if i.nested {
+			select {
+			case <-p.done:
+			case <-i.done:
+				return nil, i.ctx.Err()
+			}
+		} else {
+			i.waiting, i.awaited = p, f
+			panic(errPending)
+		}
+	}
+	i.waiting, i.awaited = nil, nil
+	return p.out, p.err
+}
+
+// resume runs the suspended call handler again so it collects the completed
+// results. It bypasses the safepoint, so hooks and tick fuel see the call
+// once, and refunds what the handler charges a second time.
+func (i *Interpreter) resume() {
+	f := i.fr
+	handler := f.code[f.ip]
+	if f.ip == 0 && i.owns(f) {
+		if stub := i.stub(f.addr); stub != nil {
+			handler = stub
+		}
+	}
+	if i.costs != nil {
+		op := instr.Opcode(i.instrs[f.addr][f.ip])
+		i.refund(saturate(i.costs.opcodes[op], i.costs.host(i.awaited)))
+	}
+	handler(i)
+}
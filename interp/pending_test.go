@@ -0,0 +1,259 @@
+package interp_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/siyul-park/minivm/instr"
+	interp "github.com/siyul-park/minivm/interp"
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
+	"github.com/stretchr/testify/require"
+)
+
+// deferred is an async host function over i32 -> i32 that hands every call's
+// argument and handle to the test, which completes it when it likes.
+type deferred struct {
+	fn    *interp.HostFunction
+	calls chan call
+}
+
+type call struct {
+	arg     int32
+	pending *interp.Pending
+}
+
+func newDeferred() *deferred {
+	d := &deferred{calls: make(chan call, 16)}
+	sig := &types.FunctionType{Params: []types.Type{types.TypeI32}, Returns: []types.Type{types.TypeI32}}
+	d.fn = interp.NewAsyncHostFunction(sig, func(_ *interp.Interpreter, params []types.Boxed) (*interp.Pending, error) {
+		p := interp.NewPending()
+		d.calls <- call{arg: params[0].I32(), pending: p}
+		return p, nil
+	})
+	return d
+}
+
+// answer completes the next call with its argument plus one.
+func (d *deferred) answer(t *testing.T) {
+	t.Helper()
+	c := <-d.calls
+	require.True(t, c.pending.Complete([]types.Boxed{types.BoxI32(c.arg + 1)}, nil))
+}
+
+func TestNewAsyncHostFunction(t *testing.T) {
+	build := func(fn *interp.HostFunction) *program.Program {
+		return program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 41),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_ADD),
+		}, program.WithConstants(fn))
+	}
+
+	t.Run("suspends until the handle is completed", func(t *testing.T) {
+		d := newDeferred()
+		i := interp.New(build(d.fn))
+		defer i.Close()
+
+		require.ErrorIs(t, i.Run(context.Background()), interp.ErrPending)
+		require.ErrorIs(t, i.Run(context.Background()), interp.ErrPending)
+		d.answer(t)
+		require.NoError(t, i.Run(context.Background()))
+		val, err := i.Pop()
+		require.NoError(t, err)
+		require.Equal(t, types.I32(43), val)
+		require.Empty(t, d.calls)
+	})
+
+	t.Run("suspends inside a guest function", func(t *testing.T) {
+		d := newDeferred()
+		fn := types.NewFunctionBuilder(&types.FunctionType{
+			Params:  []types.Type{types.TypeI32},
+			Returns: []types.Type{types.TypeI32},
+		}).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.CONST_GET, 1),
+			instr.New(instr.CALL),
+			instr.New(instr.I32_CONST, 2),
+			instr.New(instr.I32_MUL),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 20),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+		}, program.WithConstants(fn, d.fn))
+		i := interp.New(prog)
+		defer i.Close()
+
+		require.ErrorIs(t, i.Run(context.Background()), interp.ErrPending)
+		require.Equal(t, 2, i.FP())
+		d.answer(t)
+		require.NoError(t, i.Run(context.Background()))
+		val, err := i.Pop()
+		require.NoError(t, err)
+		require.Equal(t, types.I32(42), val)
+	})
+
+	t.Run("runs on when completed before returning", func(t *testing.T) {
+		sig := &types.FunctionType{Params: []types.Type{types.TypeI32}, Returns: []types.Type{types.TypeI32}}
+		fn := interp.NewAsyncHostFunction(sig, func(_ *interp.Interpreter, params []types.Boxed) (*interp.Pending, error) {
+			p := interp.NewPending()
+			p.Complete([]types.Boxed{types.BoxI32(params[0].I32() + 1)}, nil)
+			return p, nil
+		})
+		i := interp.New(build(fn))
+		defer i.Close()
+
+		require.NoError(t, i.Run(context.Background()))
+		val, err := i.Pop()
+		require.NoError(t, err)
+		require.Equal(t, types.I32(43), val)
+	})
+
+	t.Run("fails the call with the completion error", func(t *testing.T) {
+		d := newDeferred()
+		i := interp.New(build(d.fn))
+		defer i.Close()
+
+		require.ErrorIs(t, i.Run(context.Background()), interp.ErrPending)
+		c := <-d.calls
+		failure := errors.New("lookup failed")
+		c.pending.Complete(nil, failure)
+		require.ErrorIs(t, i.Run(context.Background()), failure)
+	})
+
+	t.Run("charges the call once", func(t *testing.T) {
+		sig := &types.FunctionType{Params: []types.Type{types.TypeI32}, Returns: []types.Type{types.TypeI32}}
+		sync := interp.NewHostFunction(sig, func(_ *interp.Interpreter, params []types.Boxed) ([]types.Boxed, error) {
+			return []types.Boxed{types.BoxI32(params[0].I32() + 1)}, nil
+		})
+		d := newDeferred()
+		costs := interp.NewCostTable(1)
+		costs.Call = 10
+
+		want := interp.New(build(sync), interp.WithCosts(costs))
+		defer want.Close()
+		require.NoError(t, want.Run(context.Background()))
+
+		i := interp.New(build(d.fn), interp.WithCosts(costs))
+		defer i.Close()
+		require.ErrorIs(t, i.Run(context.Background()), interp.ErrPending)
+		d.answer(t)
+		require.NoError(t, i.Run(context.Background()))
+		require.Equal(t, want.FuelConsumed(), i.FuelConsumed())
+	})
+
+	t.Run("resumes a hot loop", func(t *testing.T) {
+		d := newDeferred()
+		b := program.NewBuilder()
+		b.Locals(types.TypeI32, types.TypeI32)
+		loop := b.Label()
+		b.Bind(loop)
+		b.Emit(instr.LOCAL_GET, 1).Emit(instr.LOCAL_GET, 0).ConstGet(d.fn).Emit(instr.CALL).Emit(instr.I32_ADD).Emit(instr.LOCAL_SET, 1)
+		b.Emit(instr.LOCAL_GET, 0).Emit(instr.I32_CONST, 1).Emit(instr.I32_ADD).Emit(instr.LOCAL_TEE, 0)
+		b.Emit(instr.I32_CONST, 200).Emit(instr.I32_LT_S).BrIf(loop)
+		b.Emit(instr.LOCAL_GET, 1)
+		prog, err := b.Build()
+		require.NoError(t, err)
+
+		i := interp.New(prog, interp.WithThreshold(0))
+		defer i.Close()
+
+		err = i.Run(context.Background())
+		for ; errors.Is(err, interp.ErrPending); err = i.Run(context.Background()) {
+			d.answer(t)
+		}
+		require.NoError(t, err)
+		val, err := i.Pop()
+		require.NoError(t, err)
+		require.Equal(t, types.I32(200*201/2), val)
+	})
+
+	t.Run("waits inside Call", func(t *testing.T) {
+		d := newDeferred()
+		i := interp.New(program.New(nil))
+		defer i.Close()
+
+		go func() {
+			c := <-d.calls
+			c.pending.Complete([]types.Boxed{types.BoxI32(c.arg + 1)}, nil)
+		}()
+		out, err := i.Call(context.Background(), d.fn, types.BoxI32(1))
+		require.NoError(t, err)
+		require.Equal(t, []types.Boxed{types.BoxI32(2)}, out)
+	})
+
+	t.Run("suspends pooled interpreters without holding goroutines", func(t *testing.T) {
+		const n = 8
+		d := newDeferred()
+		pool := interp.NewPool(build(d.fn), n)
+		defer pool.Close()
+
+		suspended := make([]*interp.Interpreter, 0, n)
+		for range n {
+			i, err := pool.Get(context.Background())
+			require.NoError(t, err)
+			require.ErrorIs(t, i.Run(context.Background()), interp.ErrPending)
+			suspended = append(suspended, i)
+		}
+		for range n {
+			d.answer(t)
+		}
+		for _, i := range suspended {
+			require.NoError(t, i.Run(context.Background()))
+			val, err := i.Pop()
+			require.NoError(t, err)
+			require.Equal(t, types.I32(43), val)
+			pool.Put(i)
+		}
+	})
+}
+
+func TestNewPending(t *testing.T) {
+	p := interp.NewPending()
+	select {
+	case <-p.Done():
+		t.Fatal("a new handle is already completed")
+	default:
+	}
+}
+
+func TestPending_Complete(t *testing.T) {
+	p := interp.NewPending()
+	require.True(t, p.Complete([]types.Boxed{types.BoxI32(1)}, nil))
+	require.False(t, p.Complete(nil, errors.New("late")))
+}
+
+func TestPending_Done(t *testing.T) {
+	p := interp.NewPending()
+	done := p.Done()
+	p.Complete(nil, nil)
+	<-done
+}
+
+func TestInterpreter_Waiting(t *testing.T) {
+	d := newDeferred()
+	prog := program.New([]instr.Instruction{
+		instr.New(instr.I32_CONST, 1),
+		instr.New(instr.CONST_GET, 0),
+		instr.New(instr.CALL),
+	}, program.WithConstants(d.fn))
+	i := interp.New(prog)
+	defer i.Close()
+
+	require.Nil(t, i.Waiting())
+	require.ErrorIs(t, i.Run(context.Background()), interp.ErrPending)
+	c := <-d.calls
+	require.Same(t, c.pending, i.Waiting())
+	require.ErrorIs(t, i.Snapshot(&bytes.Buffer{}), interp.ErrUnportableValue)
+	_, err := i.Call(context.Background(), d.fn, types.BoxI32(1))
+	require.ErrorIs(t, err, interp.ErrInterpreterBusy)
+
+	i.Reset()
+	require.Nil(t, i.Waiting())
+}
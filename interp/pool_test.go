@@ -46,6 +46,40 @@ func TestNewPool(t *testing.T) {
 }
 
 func TestPool_Get(t *testing.T) {
+	t.Run("calls entry points on borrowed interpreters", func(t *testing.T) {
+		square := types.NewFunctionBuilder(&types.FunctionType{Params: []types.Type{types.TypeI32}, Returns: []types.Type{types.TypeI32}}).
+			Emit(instr.New(instr.LOCAL_GET, 0), instr.New(instr.LOCAL_GET, 0), instr.New(instr.I32_MUL), instr.New(instr.RETURN)).
+			MustBuild()
+		p := interp.NewPool(program.New(nil, program.WithConstants(square)), 4)
+		defer p.Close()
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 16)
+		for n := range 16 {
+			wg.Go(func() {
+				i, err := p.Get(context.Background())
+				if err != nil {
+					errs <- err
+					return
+				}
+				defer p.Put(i)
+
+				returns, err := i.Call(context.Background(), 0, types.BoxI32(int32(n)))
+				if err != nil {
+					errs <- err
+					return
+				}
+				if len(returns) != 1 || returns[0] != types.BoxI32(int32(n*n)) {
+					errs <- fmt.Errorf("square(%d) = %v", n, returns)
+				}
+			})
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			require.NoError(t, err)
+		}
+	})
 
 	t.Run("reuses an idle interpreter", func(t *testing.T) {
 		prog := program.New([]instr.Instruction{instr.New(instr.NOP)})
@@ -0,0 +1,585 @@
+package interp
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
+)
+
+// Scheduler multiplexes many tasks over a few worker goroutines. Each task
+// runs on an Interpreter borrowed from a Pool for its program, one slice at a
+// time: when its slice runs out the task is preempted at a safepoint, queued
+// again behind the tasks of its priority, and later resumed where it stopped.
+// A task suspended on an async host call gives its worker back until the
+// handle is completed, so tasks waiting on the host hold interpreters but no
+// goroutines. A program's Pool, and so its JIT cache, is shared by every task
+// that runs it while any is unfinished, and closed once the last finishes.
+//
+// Queued tasks wait in one of two queues: ready holds tasks that already hold
+// an interpreter and pending those that have yet to be seated, so a full limit
+// skips pending without scanning it. A task whose tenant is at its Running
+// quota waits in that tenant's own queues until one of its turns ends.
+type Scheduler struct {
+	slice   uint64
+	quantum time.Duration
+	limit   int
+	quotas  map[string]Quota
+	opts    []func(*option)
+
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	ready   queue
+	pending queue
+	pools   map[*program.Program]*lease
+	tenants map[string]*usage
+	jobs    map[*job]struct{}
+	seated  int
+	seq     uint64
+	errs    []error
+	closed  bool
+}
+
+// Task is one unit of work for a Scheduler. Entry selects what runs: nil runs
+// the program's top level, and anything else is a callee as Call accepts it,
+// applied to Args. Higher Priority runs first; tasks of equal priority take
+// turns. Callback, when set, receives the Result on the worker that finished
+// the task, before it is sent on the channel Submit returned.
+type Task struct {
+	Program  *program.Program
+	Entry    any
+	Args     []types.Value
+	Priority int
+	Tenant   string
+	Callback func(Result)
+}
+
+// Result is how a task ended. Values are what it left on the stack, detached
+// as Pop detaches them; a struct or array is copied out of the interpreter,
+// which is reset for the next task, so refs it holds no longer resolve.
+type Result struct {
+	Values []types.Value
+	Err    error
+}
+
+// Quota bounds what one tenant may hold of a Scheduler. Running caps its tasks
+// in a slice at once, and Tasks its tasks submitted and not yet finished;
+// Submit past Tasks fails with ErrQuotaExceeded. Zero means unbounded.
+type Quota struct {
+	Running int
+	Tasks   int
+}
+
+type scheduling struct {
+	workers int
+	slice   uint64
+	quantum time.Duration
+	limit   int
+	quotas  map[string]Quota
+	opts    []func(*option)
+}
+
+// job is a submitted task and the interpreter it runs on once started.
+type job struct {
+	task   Task
+	out    chan Result
+	pool   *Pool
+	interp *Interpreter
+	base   int
+	seated bool
+	seq    uint64
+}
+
+type queue []*job
+
+// lease is a program's pool and the unfinished tasks that share it.
+type lease struct {
+	pool *Pool
+	jobs int
+}
+
+// usage is what one tenant holds. ready and pending park its queued tasks
+// while it is at its Running quota.
+type usage struct {
+	running int
+	tasks   int
+	ready   queue
+	pending queue
+}
+
+// slice is one turn of a scheduled task. It runs out once the task spends
+// fuel instructions, or fuel units under a cost table, or once deadline
+// passes; the safepoint checks it every tick, so a turn overruns by up to a
+// tick.
+type slice struct {
+	fuel     uint64
+	ticks    uint64
+	start    uint64
+	deadline time.Time
+}
+
+var (
+	ErrSchedulerClosed = errors.New("scheduler closed")
+	ErrQuotaExceeded   = errors.New("quota exceeded")
+)
+
+// WithWorkers sets how many goroutines run tasks. Values <= 0 mean one.
+func WithWorkers(n int) func(*scheduling) {
+	return func(s *scheduling) { s.workers = n }
+}
+
+// WithSlice sets how many instructions a task runs per turn before it is
+// preempted, or how much fuel under WithCosts. Zero leaves turns unbounded
+// unless WithQuantum bounds them.
+func WithSlice(n uint64) func(*scheduling) {
+	return func(s *scheduling) { s.slice = n }
+}
+
+// WithQuantum sets how long a task runs per turn before it is preempted. Zero
+// leaves turns unbounded unless WithSlice bounds them.
+func WithQuantum(d time.Duration) func(*scheduling) {
+	return func(s *scheduling) { s.quantum = d }
+}
+
+// WithLimit caps the tasks that hold an interpreter at once: started and not
+// finished, whether running, queued for another turn, or waiting on the host.
+// Tasks past it wait in the queue without one. Values <= 0 mean 1024.
+func WithLimit(n int) func(*scheduling) {
+	return func(s *scheduling) { s.limit = n }
+}
+
+// WithQuota bounds what tenant may hold of the scheduler. Repeated options
+// for the same tenant replace each other.
+func WithQuota(tenant string, q Quota) func(*scheduling) {
+	return func(s *scheduling) {
+		if s.quotas == nil {
+			s.quotas = map[string]Quota{}
+		}
+		s.quotas[tenant] = q
+	}
+}
+
+// WithOptions supplies the options every scheduled interpreter is built with,
+// such as WithProfiler to aggregate all tasks into one profile.
+func WithOptions(opts ...func(*option)) func(*scheduling) {
+	return func(s *scheduling) { s.opts = append(s.opts, opts...) }
+}
+
+// NewScheduler starts a scheduler's workers. Close stops them.
+func NewScheduler(opts ...func(*scheduling)) *Scheduler {
+	cfg := scheduling{
+		workers: 1,
+		slice:   10_000,
+		limit:   1024,
+	}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	if cfg.workers <= 0 {
+		cfg.workers = 1
+	}
+	if cfg.limit <= 0 {
+		cfg.limit = 1024
+	}
+
+	s := &Scheduler{
+		slice:   cfg.slice,
+		quantum: cfg.quantum,
+		limit:   cfg.limit,
+		quotas:  cfg.quotas,
+		opts:    cfg.opts,
+		done:    make(chan struct{}),
+		pools:   map[*program.Program]*lease{},
+		tenants: map[string]*usage{},
+		jobs:    map[*job]struct{}{},
+	}
+	s.cond = sync.NewCond(&s.mu)
+	s.wg.Add(cfg.workers)
+	for range cfg.workers {
+		go s.work()
+	}
+	return s
+}
+
+// Submit queues t and returns the channel its Result arrives on. It fails with
+// ErrQuotaExceeded when t's tenant already has as many unfinished tasks as its
+// quota allows, and with ErrSchedulerClosed once the scheduler is closed.
+func (s *Scheduler) Submit(t Task) (<-chan Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrSchedulerClosed
+	}
+	u := s.tenants[t.Tenant]
+	if u == nil {
+		u = &usage{}
+	}
+	if q := s.quotas[t.Tenant]; q.Tasks > 0 && u.tasks >= q.Tasks {
+		return nil, fmt.Errorf("%w: tenant %q has %d tasks", ErrQuotaExceeded, t.Tenant, u.tasks)
+	}
+	u.tasks++
+	s.tenants[t.Tenant] = u
+
+	l, ok := s.pools[t.Program]
+	if !ok {
+		// Seated tasks never outnumber the limit, so no pool is ever asked
+		// for more interpreters than it may lend and Get never blocks.
+		l = &lease{pool: NewPool(t.Program, s.limit, s.opts...)}
+		s.pools[t.Program] = l
+	}
+	l.jobs++
+	j := &job{task: t, out: make(chan Result, 1), pool: l.pool}
+	s.jobs[j] = struct{}{}
+	s.push(j)
+	return j.out, nil
+}
+
+// Close stops the workers, preempting the tasks they run, and fails every
+// unfinished task with ErrSchedulerClosed. Close is idempotent; errors from
+// closing the pools, including those closed earlier as their last task
+// finished, are aggregated via errors.Join.
+func (s *Scheduler) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	jobs := s.jobs
+	s.jobs = nil
+	s.ready, s.pending = nil, nil
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	close(s.done)
+	s.wg.Wait()
+
+	for j := range jobs {
+		if j.interp != nil {
+			j.pool.Put(j.interp)
+			j.interp = nil
+		}
+		s.deliver(j, Result{Err: ErrSchedulerClosed})
+	}
+	errs := s.errs
+	for _, l := range s.pools {
+		if err := l.pool.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// work runs turns until the scheduler closes. Its context, passed to every
+// turn, is cancelled by Close to preempt the turn in progress.
+func (s *Scheduler) work() {
+	defer s.wg.Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		j := s.next()
+		if j == nil {
+			return
+		}
+		s.turn(ctx, j)
+	}
+}
+
+// next waits for the first queued task whose tenant and the limit let it run,
+// or returns nil once the scheduler is closed.
+func (s *Scheduler) next() *job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for !s.closed {
+		if j := s.pick(); j != nil {
+			s.tenants[j.task.Tenant].running++
+			if !j.seated {
+				j.seated = true
+				s.seated++
+			}
+			return j
+		}
+		s.cond.Wait()
+	}
+	return nil
+}
+
+// pick pops the first task that may run. Pending tasks are only considered
+// below the limit; a task whose tenant is at its quota is parked with the
+// tenant instead.
+func (s *Scheduler) pick() *job {
+	for {
+		q := &s.ready
+		if s.pending.Len() > 0 && s.seated < s.limit && (q.Len() == 0 || before(s.pending[0], s.ready[0])) {
+			q = &s.pending
+		}
+		if q.Len() == 0 {
+			return nil
+		}
+		j := heap.Pop(q).(*job)
+		if !s.throttled(j.task.Tenant) {
+			return j
+		}
+		s.park(j)
+	}
+}
+
+// throttled reports whether tenant is at its Running quota.
+func (s *Scheduler) throttled(tenant string) bool {
+	q := s.quotas[tenant]
+	return q.Running > 0 && s.tenants[tenant].running >= q.Running
+}
+
+// park queues j with its tenant until one of the tenant's turns ends.
+func (s *Scheduler) park(j *job) {
+	u := s.tenants[j.task.Tenant]
+	if j.seated {
+		heap.Push(&u.ready, j)
+	} else {
+		heap.Push(&u.pending, j)
+	}
+}
+
+// unpark moves the first ready and the first pending task parked with u back
+// to the scheduler's queues. Either may take the running slot just freed; the
+// one that loses is parked again when it is picked.
+func (s *Scheduler) unpark(u *usage) {
+	if u.ready.Len() > 0 {
+		heap.Push(&s.ready, heap.Pop(&u.ready))
+	}
+	if u.pending.Len() > 0 {
+		heap.Push(&s.pending, heap.Pop(&u.pending))
+	}
+}
+
+// turn runs j for one slice, then queues it again, parks it on the host call
+// it suspended on, or finishes it.
+func (s *Scheduler) turn(ctx context.Context, j *job) {
+	if j.interp == nil {
+		if err := s.start(ctx, j); err != nil {
+			s.leave(j)
+			s.finish(j, err)
+			return
+		}
+	}
+
+	i := j.interp
+	i.slice = &slice{fuel: s.slice, start: i.spent}
+	if s.quantum > 0 {
+		i.slice.deadline = time.Now().Add(s.quantum)
+	}
+	err := i.Run(ctx)
+	i.slice = nil
+	s.leave(j)
+
+	switch {
+	case err == errPreempted || errors.Is(err, ErrYield):
+		s.requeue(j)
+	case errors.Is(err, ErrPending):
+		i.waiting.notify(func() { s.requeue(j) })
+	default:
+		s.finish(j, err)
+	}
+}
+
+// leave ends j's turn, giving its tenant's running slot back.
+func (s *Scheduler) leave(j *job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.tenants[j.task.Tenant]
+	u.running--
+	if !s.closed {
+		s.unpark(u)
+	}
+	s.cond.Broadcast()
+}
+
+// start borrows an interpreter for j and, for an entry call, sets up the call
+// so the first Run makes it.
+func (s *Scheduler) start(ctx context.Context, j *job) error {
+	i, err := j.pool.Get(ctx)
+	if err != nil {
+		return err
+	}
+	j.interp = i
+	j.base = i.sp
+
+	t := j.task
+	if t.Entry == nil {
+		if len(t.Args) > 0 {
+			return fmt.Errorf("%w: got %d args, want 0", ErrTypeMismatch, len(t.Args))
+		}
+		return nil
+	}
+	val, err := i.callee(t.Entry)
+	if err != nil {
+		return err
+	}
+	for _, arg := range t.Args {
+		if err := i.Push(arg); err != nil {
+			return err
+		}
+	}
+	args := slices.Clone(i.stack[j.base:i.sp])
+	i.sp = j.base
+	if err := i.check(val, args); err != nil {
+		return err
+	}
+	return i.enter(val, args)
+}
+
+func (s *Scheduler) push(j *job) {
+	s.seq++
+	j.seq = s.seq
+	switch {
+	case s.throttled(j.task.Tenant):
+		s.park(j)
+		return
+	case j.seated:
+		heap.Push(&s.ready, j)
+	default:
+		heap.Push(&s.pending, j)
+	}
+	s.cond.Signal()
+}
+
+func (s *Scheduler) requeue(j *job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.push(j)
+	}
+}
+
+// finish returns j's interpreter to its pool and delivers its result, unless
+// Close already failed it.
+func (s *Scheduler) finish(j *job, err error) {
+	var values []types.Value
+	if i := j.interp; i != nil {
+		if err == nil {
+			values = i.results(j.base)
+		}
+		j.pool.Put(i)
+		j.interp = nil
+	}
+
+	s.mu.Lock()
+	_, ok := s.jobs[j]
+	delete(s.jobs, j)
+	if j.seated {
+		s.seated--
+	}
+	if u := s.tenants[j.task.Tenant]; u != nil {
+		if u.tasks--; u.tasks == 0 && u.running == 0 {
+			delete(s.tenants, j.task.Tenant)
+		}
+	}
+	var idle *Pool
+	if l := s.pools[j.task.Program]; l != nil && !s.closed {
+		if l.jobs--; l.jobs == 0 {
+			delete(s.pools, j.task.Program)
+			idle = l.pool
+		}
+	}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	if idle != nil {
+		if err := idle.Close(); err != nil {
+			s.mu.Lock()
+			s.errs = append(s.errs, err)
+			s.mu.Unlock()
+		}
+	}
+	if ok {
+		s.deliver(j, Result{Values: values, Err: err})
+	}
+}
+
+func (s *Scheduler) deliver(j *job, r Result) {
+	if j.task.Callback != nil {
+		j.task.Callback(r)
+	}
+	j.out <- r
+	close(j.out)
+}
+
+// results takes what a finished task left above base off the stack.
+func (i *Interpreter) results(base int) []types.Value {
+	values := make([]types.Value, 0, i.sp-base)
+	for _, boxed := range i.stack[base:i.sp] {
+		val := i.unbox(boxed)
+		// Structs and arrays go back to the interpreter's free lists when it
+		// is reset, so the caller gets a copy of the live one.
+		switch v := val.(type) {
+		case *types.Struct:
+			c := &types.Struct{}
+			c.Reset(v.Typ)
+			copy(c.Data, v.Data)
+			val = c
+		case *types.Array:
+			val = types.NewArray(v.Typ, slices.Clone(v.Elems)...)
+		}
+		values = append(values, val)
+	}
+	i.sp = base
+	return values
+}
+
+// over reports whether the slice has run out.
+func (s *slice) over(i *Interpreter) bool {
+	if s.fuel > 0 {
+		used := i.spent - s.start
+		if i.costs == nil {
+			s.ticks += uint64(i.tick)
+			used = s.ticks
+		}
+		if used >= s.fuel {
+			return true
+		}
+	}
+	return !s.deadline.IsZero() && !time.Now().Before(s.deadline)
+}
+
+func (q queue) Len() int { return len(q) }
+
+func (q queue) Less(a, b int) bool { return before(q[a], q[b]) }
+
+func (q queue) Swap(a, b int) { q[a], q[b] = q[b], q[a] }
+
+func (q *queue) Push(x any) { *q = append(*q, x.(*job)) }
+
+func (q *queue) Pop() any {
+	old := *q
+	j := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return j
+}
+
+// before orders jobs by priority, then by when they were queued.
+func before(a, b *job) bool {
+	if a.task.Priority != b.task.Priority {
+		return a.task.Priority > b.task.Priority
+	}
+	return a.seq < b.seq
+}
@@ -0,0 +1,467 @@
+package interp_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/siyul-park/minivm/instr"
+	interp "github.com/siyul-park/minivm/interp"
+	"github.com/siyul-park/minivm/prof"
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
+	"github.com/stretchr/testify/require"
+)
+
+// counting builds a program that counts to n in a loop and leaves n.
+func counting(t *testing.T, n int32) *program.Program {
+	t.Helper()
+	b := program.NewBuilder()
+	b.Locals(types.TypeI32)
+	loop := b.Label()
+	b.Bind(loop)
+	b.Emit(instr.LOCAL_GET, 0).Emit(instr.I32_CONST, 1).Emit(instr.I32_ADD).Emit(instr.LOCAL_TEE, 0)
+	b.Emit(instr.I32_CONST, uint64(n)).Emit(instr.I32_LT_S).BrIf(loop)
+	b.Emit(instr.LOCAL_GET, 0)
+	prog, err := b.Build()
+	require.NoError(t, err)
+	return prog
+}
+
+// order records the tags of finished tasks in the order their callbacks ran.
+type order struct {
+	mu   sync.Mutex
+	tags []string
+}
+
+func (o *order) done(tag string) func(interp.Result) {
+	return func(interp.Result) {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		o.tags = append(o.tags, tag)
+	}
+}
+
+func TestNewScheduler(t *testing.T) {
+	t.Run("runs the top level", func(t *testing.T) {
+		s := interp.NewScheduler()
+		defer s.Close()
+
+		out, err := s.Submit(interp.Task{Program: counting(t, 1000)})
+		require.NoError(t, err)
+		r := <-out
+		require.NoError(t, r.Err)
+		require.Equal(t, []types.Value{types.I32(1000)}, r.Values)
+	})
+
+	t.Run("calls an entry point", func(t *testing.T) {
+		square := types.NewFunctionBuilder(&types.FunctionType{Params: []types.Type{types.TypeI32}, Returns: []types.Type{types.TypeI32}}).
+			Emit(instr.New(instr.LOCAL_GET, 0), instr.New(instr.LOCAL_GET, 0), instr.New(instr.I32_MUL), instr.New(instr.RETURN)).
+			MustBuild()
+		prog := program.New(nil, program.WithConstants(square))
+		s := interp.NewScheduler(interp.WithWorkers(2))
+		defer s.Close()
+
+		outs := make([]<-chan interp.Result, 0, 16)
+		for n := range 16 {
+			out, err := s.Submit(interp.Task{Program: prog, Entry: 0, Args: []types.Value{types.I32(n)}})
+			require.NoError(t, err)
+			outs = append(outs, out)
+		}
+		for n, out := range outs {
+			r := <-out
+			require.NoError(t, r.Err)
+			require.Equal(t, []types.Value{types.I32(n * n)}, r.Values)
+		}
+	})
+
+	t.Run("copies structs out of the interpreter", func(t *testing.T) {
+		typ := types.NewStructType(types.NewStructField(types.TypeI32), types.NewStructField(types.TypeI32))
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_CONST, 2),
+			instr.New(instr.STRUCT_NEW, 0),
+		}, program.WithTypes(typ))
+		s := interp.NewScheduler()
+		defer s.Close()
+
+		var values []types.Value
+		for range 2 {
+			out, err := s.Submit(interp.Task{Program: prog})
+			require.NoError(t, err)
+			r := <-out
+			require.NoError(t, r.Err)
+			values = append(values, r.Values...)
+		}
+		require.NotSame(t, values[0], values[1])
+		require.Equal(t, types.BoxI32(2), values[0].(*types.Struct).Field(1))
+	})
+
+	t.Run("fails a task its program rejects", func(t *testing.T) {
+		s := interp.NewScheduler()
+		defer s.Close()
+
+		out, err := s.Submit(interp.Task{Program: program.New(nil), Entry: "missing"})
+		require.NoError(t, err)
+		require.ErrorIs(t, (<-out).Err, interp.ErrUnknownExport)
+	})
+
+	t.Run("parks tasks waiting on the host", func(t *testing.T) {
+		d := newDeferred()
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 41),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+		}, program.WithConstants(d.fn))
+		s := interp.NewScheduler()
+		defer s.Close()
+
+		const n = 8
+		outs := make([]<-chan interp.Result, 0, n)
+		for range n {
+			out, err := s.Submit(interp.Task{Program: prog})
+			require.NoError(t, err)
+			outs = append(outs, out)
+		}
+		// One worker reaches every call, so none of them holds it.
+		for range n {
+			d.answer(t)
+		}
+		for _, out := range outs {
+			r := <-out
+			require.NoError(t, r.Err)
+			require.Equal(t, []types.Value{types.I32(42)}, r.Values)
+		}
+	})
+
+	t.Run("parks an async entry point", func(t *testing.T) {
+		d := newDeferred()
+		s := interp.NewScheduler()
+		defer s.Close()
+
+		out, err := s.Submit(interp.Task{Program: program.New(nil), Entry: d.fn, Args: []types.Value{types.I32(1)}})
+		require.NoError(t, err)
+		d.answer(t)
+		r := <-out
+		require.NoError(t, r.Err)
+		require.Equal(t, []types.Value{types.I32(2)}, r.Values)
+	})
+}
+
+func TestWithWorkers(t *testing.T) {
+	var running, peak atomic.Int32
+	gate := make(chan struct{})
+	fn := interp.NewHostFunction(&types.FunctionType{}, func(*interp.Interpreter, []types.Boxed) ([]types.Boxed, error) {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		<-gate
+		running.Add(-1)
+		return nil, nil
+	})
+	prog := program.New([]instr.Instruction{instr.New(instr.CONST_GET, 0), instr.New(instr.CALL)}, program.WithConstants(fn))
+	s := interp.NewScheduler(interp.WithWorkers(3))
+	defer s.Close()
+
+	outs := make([]<-chan interp.Result, 0, 3)
+	for range 3 {
+		out, err := s.Submit(interp.Task{Program: prog})
+		require.NoError(t, err)
+		outs = append(outs, out)
+	}
+	require.Eventually(t, func() bool { return peak.Load() == 3 }, time.Second, time.Millisecond)
+	close(gate)
+	for _, out := range outs {
+		require.NoError(t, (<-out).Err)
+	}
+}
+
+func TestWithSlice(t *testing.T) {
+	s := interp.NewScheduler(interp.WithSlice(1000))
+	defer s.Close()
+
+	var o order
+	long, err := s.Submit(interp.Task{Program: counting(t, 1_000_000), Callback: o.done("long")})
+	require.NoError(t, err)
+	short, err := s.Submit(interp.Task{Program: counting(t, 10), Callback: o.done("short")})
+	require.NoError(t, err)
+
+	require.NoError(t, (<-short).Err)
+	r := <-long
+	require.NoError(t, r.Err)
+	require.Equal(t, []types.Value{types.I32(1_000_000)}, r.Values)
+	require.Equal(t, []string{"short", "long"}, o.tags)
+}
+
+func TestWithQuantum(t *testing.T) {
+	s := interp.NewScheduler(interp.WithSlice(0), interp.WithQuantum(time.Millisecond))
+	defer s.Close()
+
+	var o order
+	long, err := s.Submit(interp.Task{Program: counting(t, 50_000_000), Callback: o.done("long")})
+	require.NoError(t, err)
+	short, err := s.Submit(interp.Task{Program: counting(t, 10), Callback: o.done("short")})
+	require.NoError(t, err)
+
+	require.NoError(t, (<-short).Err)
+	require.NoError(t, (<-long).Err)
+	require.Equal(t, []string{"short", "long"}, o.tags)
+}
+
+func TestWithLimit(t *testing.T) {
+	d := newDeferred()
+	prog := program.New([]instr.Instruction{
+		instr.New(instr.I32_CONST, 1),
+		instr.New(instr.CONST_GET, 0),
+		instr.New(instr.CALL),
+	}, program.WithConstants(d.fn))
+	s := interp.NewScheduler(interp.WithLimit(1))
+	defer s.Close()
+
+	var o order
+	parked, err := s.Submit(interp.Task{Program: prog, Callback: o.done("parked")})
+	require.NoError(t, err)
+	c := <-d.calls
+
+	low, err := s.Submit(interp.Task{Program: counting(t, 10), Callback: o.done("low")})
+	require.NoError(t, err)
+	high, err := s.Submit(interp.Task{Program: counting(t, 10), Priority: 1, Callback: o.done("high")})
+	require.NoError(t, err)
+	select {
+	case <-high:
+		t.Fatal("a task started past the limit")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	c.pending.Complete([]types.Boxed{types.BoxI32(2)}, nil)
+	require.NoError(t, (<-parked).Err)
+	require.NoError(t, (<-high).Err)
+	require.NoError(t, (<-low).Err)
+	require.Equal(t, []string{"parked", "high", "low"}, o.tags)
+}
+
+func TestWithQuota(t *testing.T) {
+	t.Run("caps unfinished tasks", func(t *testing.T) {
+		d := newDeferred()
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+		}, program.WithConstants(d.fn))
+		s := interp.NewScheduler(interp.WithQuota("a", interp.Quota{Tasks: 1}))
+		defer s.Close()
+
+		out, err := s.Submit(interp.Task{Program: prog, Tenant: "a"})
+		require.NoError(t, err)
+		_, err = s.Submit(interp.Task{Program: prog, Tenant: "a"})
+		require.ErrorIs(t, err, interp.ErrQuotaExceeded)
+		other, err := s.Submit(interp.Task{Program: counting(t, 10), Tenant: "b"})
+		require.NoError(t, err)
+		require.NoError(t, (<-other).Err)
+
+		d.answer(t)
+		require.NoError(t, (<-out).Err)
+		out, err = s.Submit(interp.Task{Program: counting(t, 10), Tenant: "a"})
+		require.NoError(t, err)
+		require.NoError(t, (<-out).Err)
+	})
+
+	t.Run("caps running tasks", func(t *testing.T) {
+		var running, peak atomic.Int32
+		fn := interp.NewHostFunction(&types.FunctionType{}, func(*interp.Interpreter, []types.Boxed) ([]types.Boxed, error) {
+			n := running.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			running.Add(-1)
+			return nil, nil
+		})
+		prog := program.New([]instr.Instruction{instr.New(instr.CONST_GET, 0), instr.New(instr.CALL)}, program.WithConstants(fn))
+		s := interp.NewScheduler(interp.WithWorkers(4), interp.WithQuota("a", interp.Quota{Running: 1}))
+		defer s.Close()
+
+		outs := make([]<-chan interp.Result, 0, 8)
+		for range 8 {
+			out, err := s.Submit(interp.Task{Program: prog, Tenant: "a"})
+			require.NoError(t, err)
+			outs = append(outs, out)
+		}
+		for _, out := range outs {
+			require.NoError(t, (<-out).Err)
+		}
+		require.Equal(t, int32(1), peak.Load())
+	})
+
+	t.Run("runs a throttled tenant's tasks by priority while others proceed", func(t *testing.T) {
+		entered, release := make(chan struct{}), make(chan struct{})
+		fn := interp.NewHostFunction(&types.FunctionType{}, func(*interp.Interpreter, []types.Boxed) ([]types.Boxed, error) {
+			close(entered)
+			<-release
+			return nil, nil
+		})
+		prog := program.New([]instr.Instruction{instr.New(instr.CONST_GET, 0), instr.New(instr.CALL)}, program.WithConstants(fn))
+		s := interp.NewScheduler(interp.WithWorkers(2), interp.WithQuota("a", interp.Quota{Running: 1}))
+		defer s.Close()
+
+		var o order
+		first, err := s.Submit(interp.Task{Program: prog, Tenant: "a", Callback: o.done("first")})
+		require.NoError(t, err)
+		<-entered
+		outs := make([]<-chan interp.Result, 0, 2)
+		for _, task := range []interp.Task{
+			{Program: counting(t, 10), Tenant: "a", Priority: -1, Callback: o.done("low")},
+			{Program: counting(t, 10), Tenant: "a", Priority: 1, Callback: o.done("high")},
+		} {
+			out, err := s.Submit(task)
+			require.NoError(t, err)
+			outs = append(outs, out)
+		}
+		other, err := s.Submit(interp.Task{Program: counting(t, 10), Tenant: "b"})
+		require.NoError(t, err)
+		require.NoError(t, (<-other).Err)
+
+		close(release)
+		require.NoError(t, (<-first).Err)
+		for _, out := range outs {
+			require.NoError(t, (<-out).Err)
+		}
+		require.Equal(t, []string{"first", "high", "low"}, o.tags)
+	})
+
+	t.Run("frees the running slot of a task that fails to start", func(t *testing.T) {
+		s := interp.NewScheduler(interp.WithQuota("a", interp.Quota{Running: 1}))
+		defer s.Close()
+
+		out, err := s.Submit(interp.Task{Program: counting(t, 10), Tenant: "a", Args: []types.Value{types.I32(1)}})
+		require.NoError(t, err)
+		require.ErrorIs(t, (<-out).Err, interp.ErrTypeMismatch)
+
+		out, err = s.Submit(interp.Task{Program: counting(t, 10), Tenant: "a"})
+		require.NoError(t, err)
+		select {
+		case r := <-out:
+			require.NoError(t, r.Err)
+		case <-time.After(time.Second):
+			require.Fail(t, "task never ran")
+		}
+	})
+}
+
+func TestWithOptions(t *testing.T) {
+	p := prof.New()
+	prog := program.New([]instr.Instruction{
+		instr.New(instr.I32_CONST, 1), instr.New(instr.I32_CONST, 2), instr.New(instr.I32_ADD),
+	})
+	s := interp.NewScheduler(interp.WithOptions(interp.WithProfiler(p), interp.WithTick(1)))
+
+	for range 2 {
+		out, err := s.Submit(interp.Task{Program: prog})
+		require.NoError(t, err)
+		require.NoError(t, (<-out).Err)
+	}
+	require.NoError(t, s.Close())
+
+	total, ok := p.Metric("vm_samples_total")
+	require.True(t, ok)
+	require.Equal(t, float64(6), total)
+}
+
+func TestScheduler_Submit(t *testing.T) {
+	t.Run("runs higher priorities first", func(t *testing.T) {
+		d := newDeferred()
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+		}, program.WithConstants(d.fn))
+		s := interp.NewScheduler(interp.WithLimit(1))
+		defer s.Close()
+
+		var o order
+		first, err := s.Submit(interp.Task{Program: prog, Callback: o.done("first")})
+		require.NoError(t, err)
+		c := <-d.calls
+		outs := make([]<-chan interp.Result, 0, 3)
+		for _, task := range []interp.Task{
+			{Program: counting(t, 10), Priority: -1, Callback: o.done("low")},
+			{Program: counting(t, 10), Callback: o.done("normal")},
+			{Program: counting(t, 10), Priority: 1, Callback: o.done("high")},
+		} {
+			out, err := s.Submit(task)
+			require.NoError(t, err)
+			outs = append(outs, out)
+		}
+
+		c.pending.Complete(nil, nil)
+		require.NoError(t, (<-first).Err)
+		for _, out := range outs {
+			require.NoError(t, (<-out).Err)
+		}
+		require.Equal(t, []string{"first", "high", "normal", "low"}, o.tags)
+	})
+
+	t.Run("closes a program's pool once its last task finishes", func(t *testing.T) {
+		var seen []*interp.Interpreter
+		fn := interp.NewHostFunction(&types.FunctionType{}, func(i *interp.Interpreter, _ []types.Boxed) ([]types.Boxed, error) {
+			seen = append(seen, i)
+			return nil, nil
+		})
+		prog := program.New([]instr.Instruction{instr.New(instr.CONST_GET, 0), instr.New(instr.CALL)}, program.WithConstants(fn))
+		s := interp.NewScheduler()
+		defer s.Close()
+
+		for range 2 {
+			out, err := s.Submit(interp.Task{Program: prog})
+			require.NoError(t, err)
+			require.NoError(t, (<-out).Err)
+		}
+		require.Len(t, seen, 2)
+		require.NotSame(t, seen[0], seen[1])
+	})
+
+	t.Run("delivers through the callback and the channel", func(t *testing.T) {
+		s := interp.NewScheduler()
+		defer s.Close()
+
+		results := make(chan interp.Result, 1)
+		out, err := s.Submit(interp.Task{Program: counting(t, 10), Callback: func(r interp.Result) { results <- r }})
+		require.NoError(t, err)
+		require.Equal(t, <-results, <-out)
+		_, ok := <-out
+		require.False(t, ok)
+	})
+}
+
+func TestScheduler_Close(t *testing.T) {
+	d := newDeferred()
+	prog := program.New([]instr.Instruction{
+		instr.New(instr.I32_CONST, 1),
+		instr.New(instr.CONST_GET, 0),
+		instr.New(instr.CALL),
+	}, program.WithConstants(d.fn))
+	s := interp.NewScheduler()
+
+	parked, err := s.Submit(interp.Task{Program: prog})
+	require.NoError(t, err)
+	c := <-d.calls
+	running, err := s.Submit(interp.Task{Program: counting(t, 1<<30)})
+	require.NoError(t, err)
+
+	require.NoError(t, s.Close())
+	require.NoError(t, s.Close())
+	require.ErrorIs(t, (<-parked).Err, interp.ErrSchedulerClosed)
+	require.ErrorIs(t, (<-running).Err, interp.ErrSchedulerClosed)
+	c.pending.Complete(nil, nil)
+
+	_, err = s.Submit(interp.Task{Program: prog})
+	require.ErrorIs(t, err, interp.ErrSchedulerClosed)
+}
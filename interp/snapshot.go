@@ -0,0 +1,683 @@
+package interp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
+)
+
+// Resolver carries the values a snapshot has no encoding for. Snapshot asks it
+// to name each one and Restore asks it for the value behind the name, so host
+// state survives a restart as long as both sides agree on the names.
+type Resolver interface {
+	// Name returns the name val is saved under, or false when val cannot be
+	// saved.
+	Name(val types.Value) (string, bool)
+	// Resolve returns the value saved under name.
+	Resolve(name string) (types.Value, error)
+}
+
+// heapSlot tags one heap slot of a snapshot.
+type heapSlot byte
+
+// snapshotWriter collects the body of a snapshot. Values the binary program
+// format already encodes travel in values and the types of maps and coroutines
+// in types, both written as one embedded program.
+type snapshotWriter struct {
+	buf    []byte
+	values []types.Value
+	types  []types.Type
+	index  map[types.Type]int
+}
+
+type snapshotReader struct {
+	data []byte
+	off  int
+	err  error
+}
+
+// snapshotMagic opens every snapshot. Like program.Magic it starts with a NUL,
+// so neither format is mistaken for the other or for text.
+const snapshotMagic = "\x00mvs"
+
+const snapshotVersion = 1
+
+const (
+	// slotFree is an unallocated slot on the free list.
+	slotFree heapSlot = iota + 1
+	// slotKept is a program-owned slot Restore takes from the program itself:
+	// the null slot and the function and host function constants.
+	slotKept
+	// slotValue is a value in the embedded program's constants.
+	slotValue
+	slotMap
+	slotCoroutine
+	// slotHost is a value the Resolver named.
+	slotHost
+)
+
+// Map forms record which concrete map a slot held, since the element type alone
+// does not say whether a string key was indexed by content.
+const (
+	formI1 byte = iota + 1
+	formI8
+	formI32
+	formI64
+	formF32
+	formF64
+	formString
+	formGeneric
+)
+
+// Snapshot writes the interpreter's suspended state to w: the heap with its
+// reference counts and free list, the operand stack, the frames, the globals,
+// and every live closure and coroutine. Restore rebuilds an interpreter that
+// continues exactly where this one stopped, typically at a root-frame YIELD
+// after Run returned ErrYield.
+//
+// The interpreter must be idle. A value with no portable form, such as a host
+// view or an iterator, fails with ErrUnportableValue unless the Resolver
+// installed with WithResolver names it. Function and host function constants
+// are not written; Restore takes them from the program.
+func (i *Interpreter) Snapshot(w io.Writer) error {
+	if i.ctx != nil {
+		return ErrInterpreterBusy
+	}
+	if i.waiting != nil {
+		return fmt.Errorf("%w: pending host call", ErrUnportableValue)
+	}
+
+	s := &snapshotWriter{index: map[types.Type]int{}}
+	s.uvarint(len(i.heap))
+	for addr, val := range i.heap {
+		s.uvarint(i.rc[addr])
+		if err := i.snapshotSlot(s, addr, val); err != nil {
+			return err
+		}
+	}
+	s.uvarint(len(i.free))
+	for _, addr := range i.free {
+		s.uvarint(addr)
+	}
+	s.boxes(i.globals)
+	s.boxes(i.stack[:i.sp])
+	s.uvarint(i.fp)
+	for _, f := range i.frames[:i.fp] {
+		s.uvarint(f.addr)
+		s.uvarint(f.ref)
+		s.bool(f.release)
+		s.uvarint(f.coro)
+		s.uvarint(f.ip)
+		s.uvarint(f.bp)
+		s.uvarint(f.returns)
+	}
+
+	var mod bytes.Buffer
+	if err := program.Encode(&mod, &program.Program{Types: s.types, Constants: s.values}); err != nil {
+		return fmt.Errorf("%w: %w", ErrUnportableValue, err)
+	}
+
+	head := &snapshotWriter{buf: append([]byte(snapshotMagic), snapshotVersion)}
+	head.uvarint(i.base)
+	head.uvarint(len(i.globals))
+	head.u64(i.digest())
+	head.bytes(mod.Bytes())
+	if _, err := w.Write(head.buf); err != nil {
+		return err
+	}
+	_, err := w.Write(s.buf)
+	return err
+}
+
+// Restore builds an interpreter for prog from a snapshot written by Snapshot,
+// stopped where the snapshotted interpreter stopped, so the next Run resumes
+// there. prog must be the program the snapshot was taken from; any other fails
+// with ErrInvalidSnapshot, as does a damaged snapshot. opts configure the new
+// interpreter as they do for New, and a snapshot holding values a Resolver
+// named needs one installed with WithResolver.
+func Restore(prog *program.Program, r io.Reader, opts ...func(*option)) (*Interpreter, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(snapshotMagic) || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, fmt.Errorf("%w: missing magic", ErrInvalidSnapshot)
+	}
+	d := &snapshotReader{data: data, off: len(snapshotMagic)}
+	if v := d.byte(); d.err == nil && v != snapshotVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidSnapshot, v)
+	}
+
+	i := New(prog, opts...)
+	if err := i.restoreSnapshot(d); err != nil {
+		_ = i.Close()
+		return nil, err
+	}
+	return i, nil
+}
+
+func (i *Interpreter) snapshotSlot(s *snapshotWriter, addr int, val types.Value) error {
+	if addr == 0 {
+		s.slot(slotKept)
+		return nil
+	}
+	switch val := val.(type) {
+	case nil:
+		s.slot(slotFree)
+	case types.I1, types.I8, types.I32, types.I64, types.F32, types.F64, types.String,
+		*types.Closure, *types.Array, *types.Struct, *types.Error,
+		types.TypedArray[bool], types.TypedArray[int8], types.TypedArray[int32],
+		types.TypedArray[int64], types.TypedArray[float32], types.TypedArray[float64]:
+		s.value(val)
+	case *types.Function:
+		if addr < i.base {
+			s.slot(slotKept)
+			return nil
+		}
+		s.value(val)
+	case *HostFunction:
+		if addr < i.base {
+			s.slot(slotKept)
+			return nil
+		}
+		return i.snapshotHost(s, addr, val)
+	case *types.TypedMap[bool]:
+		writeMap(s, formI1, val, s.bool)
+	case *types.TypedMap[int8]:
+		writeMap(s, formI8, val, func(k int8) { s.u64(uint64(k)) })
+	case *types.TypedMap[int32]:
+		writeMap(s, formI32, val, func(k int32) { s.u64(uint64(k)) })
+	case *types.TypedMap[int64]:
+		writeMap(s, formI64, val, func(k int64) { s.u64(uint64(k)) })
+	case *types.TypedMap[float32]:
+		writeMap(s, formF32, val, func(k float32) { s.u64(uint64(math.Float32bits(k))) })
+	case *types.TypedMap[float64]:
+		writeMap(s, formF64, val, func(k float64) { s.u64(math.Float64bits(k)) })
+	case *types.TypedMap[string]:
+		writeMap(s, formString, val, s.string)
+	case *types.Map:
+		s.slot(slotMap)
+		s.buf = append(s.buf, formGeneric)
+		s.uvarint(s.typ(val.Typ))
+		s.uvarint(val.Len())
+		val.Range(func(key types.MapKey, entry types.MapEntry) {
+			s.buf = append(s.buf, byte(key.Kind))
+			s.u64(key.Bits)
+			s.string(key.Text)
+			s.u64(uint64(entry.Key))
+			s.u64(uint64(entry.Value))
+		})
+	case *coroutine:
+		s.slot(slotCoroutine)
+		if val.typ == nil {
+			s.uvarint(0)
+		} else {
+			s.uvarint(s.typ(val.typ) + 1)
+		}
+		s.boxes(val.image)
+		s.u64(uint64(val.value))
+		s.uvarint(val.addr)
+		s.uvarint(val.ref)
+		s.uvarint(val.returns)
+		s.uvarint(val.ip)
+		s.bool(val.release)
+		s.bool(val.done)
+	default:
+		return i.snapshotHost(s, addr, val)
+	}
+	return nil
+}
+
+func (i *Interpreter) snapshotHost(s *snapshotWriter, addr int, val types.Value) error {
+	if i.resolver != nil {
+		if name, ok := i.resolver.Name(val); ok {
+			s.slot(slotHost)
+			s.string(name)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %T at %d", ErrUnportableValue, val, addr)
+}
+
+// restoreSnapshot replaces the state New built with the one d holds. The
+// constants stay where New boxed them, so every address in the snapshot means
+// what it meant when it was written.
+func (i *Interpreter) restoreSnapshot(d *snapshotReader) error {
+	base, globals, digest := d.int(), d.int(), d.u64()
+	payload := d.bytes()
+	if d.err != nil {
+		return d.err
+	}
+	if base != i.base || globals != len(i.globals) || digest != i.digest() {
+		return fmt.Errorf("%w: taken from another program", ErrInvalidSnapshot)
+	}
+	mod, err := program.Decode(bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSnapshot, err)
+	}
+
+	n := d.count(2)
+	if d.err == nil && n < i.base {
+		d.fail("heap of %d slots below %d constants", n, i.base)
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if i.limit > 0 && n > i.limit {
+		return ErrHeapExhausted
+	}
+	heap := make([]types.Value, n, max(n, cap(i.heap)))
+	copy(heap, i.heap)
+	rc := make([]int, n, cap(heap))
+	hosts := map[int]bool{}
+	for addr := range n {
+		rc[addr] = d.int()
+		switch tag := heapSlot(d.byte()); tag {
+		case slotFree:
+			if _, ok := heap[addr].(*types.Function); ok {
+				i.remove(addr)
+			}
+			heap[addr] = nil
+		case slotKept:
+			switch heap[addr].(type) {
+			case *types.Function, *HostFunction:
+			default:
+				if addr != 0 {
+					d.fail("slot %d is not a program constant", addr)
+				}
+			}
+		case slotValue:
+			idx := d.int()
+			if d.err == nil && idx >= len(mod.Constants) {
+				d.fail("slot %d names missing value %d", addr, idx)
+			}
+			if d.err == nil {
+				heap[addr] = mod.Constants[idx]
+			}
+		case slotMap:
+			heap[addr] = d.mapValue(mod.Types)
+		case slotCoroutine:
+			heap[addr] = d.coroutine(mod.Types)
+		case slotHost:
+			name := d.string()
+			if d.err != nil {
+				break
+			}
+			if i.resolver == nil {
+				return fmt.Errorf("%w: %q at %d", ErrUnportableValue, name, addr)
+			}
+			val, err := i.resolver.Resolve(name)
+			if err != nil {
+				return fmt.Errorf("resolve %q: %w", name, err)
+			}
+			heap[addr] = val
+			hosts[addr] = true
+		default:
+			d.fail("unknown slot tag %d", tag)
+		}
+		if d.err == nil && (heap[addr] == nil) != (rc[addr] == 0) {
+			d.fail("slot %d has reference count %d", addr, rc[addr])
+		}
+		if d.err != nil {
+			return d.err
+		}
+	}
+
+	free := make([]int, d.count(1))
+	for j := range free {
+		free[j] = d.int()
+		if d.err == nil && (free[j] <= 0 || free[j] >= n || heap[free[j]] != nil) {
+			d.fail("free slot %d is in use", free[j])
+		}
+	}
+	vals := d.boxes()
+	stack := d.boxes()
+	fp := d.count(7)
+	frames := make([]frame, fp)
+	for j := range frames {
+		f := &frames[j]
+		f.addr = d.int()
+		f.ref = d.int()
+		f.release = d.bool()
+		f.coro = d.int()
+		f.ip = d.int()
+		f.bp = d.int()
+		f.returns = d.int()
+	}
+	if d.err == nil && d.off != len(d.data) {
+		d.fail("trailing bytes")
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if len(vals) != len(i.globals) {
+		return fmt.Errorf("%w: %d globals, want %d", ErrInvalidSnapshot, len(vals), len(i.globals))
+	}
+	if len(stack) > len(i.stack) {
+		return ErrStackOverflow
+	}
+	if fp == 0 || fp > len(i.frames) {
+		return ErrFrameOverflow
+	}
+
+	i.heap, i.rc, i.free = heap, rc, free
+	for addr, val := range heap {
+		if val == nil {
+			continue
+		}
+		for _, ref := range i.refs(val) {
+			if !i.alive(int(ref)) {
+				return fmt.Errorf("%w: slot %d refers to free slot %d", ErrInvalidSnapshot, addr, ref)
+			}
+		}
+	}
+	for _, v := range append(vals[:len(vals):len(vals)], stack...) {
+		if v.Kind() == types.KindRef && !i.alive(v.Ref()) {
+			return fmt.Errorf("%w: reference to free slot %d", ErrInvalidSnapshot, v.Ref())
+		}
+	}
+	for j, f := range frames {
+		var fn, co bool
+		if f.addr < n && f.coro < n {
+			_, fn = heap[f.addr].(*types.Function)
+			_, co = heap[f.coro].(*coroutine)
+		}
+		switch {
+		case f.addr != 0 && !fn, f.coro != 0 && !co, f.ref != 0 && !i.alive(f.ref), f.bp > len(stack):
+			return fmt.Errorf("%w: frame %d", ErrInvalidSnapshot, j)
+		}
+	}
+
+	for addr := i.base; addr < n; addr++ {
+		switch val := heap[addr].(type) {
+		case nil:
+			continue
+		case *types.Function:
+			i.bind(addr, val, true)
+		case *coroutine:
+			if cl, ok := heap[val.ref].(*types.Closure); ok && val.ref > 0 && int(cl.Fn) == val.addr {
+				val.upvals = cl.Upvals
+			}
+		}
+		if hosts[addr] {
+			i.own(addr, heap[addr])
+		}
+		i.track(heap[addr])
+	}
+
+	copy(i.globals, vals)
+	clear(i.stack)
+	i.sp = copy(i.stack, stack)
+	for j := range frames {
+		f := &i.frames[j]
+		*f = frames[j]
+		i.restore(f, f.addr)
+	}
+	i.fp = fp
+	i.fr = &i.frames[fp-1]
+	i.tail = nil
+	i.measure()
+	i.pace()
+	return nil
+}
+
+// digest fingerprints the code a snapshot depends on, the module body and each
+// function constant, so Restore refuses a snapshot of another program.
+func (i *Interpreter) digest() uint64 {
+	h := fnv.New64a()
+	var n [binary.MaxVarintLen64]byte
+	write := func(code []byte) {
+		_, _ = h.Write(n[:binary.PutUvarint(n[:], uint64(len(code)))])
+		_, _ = h.Write(code)
+	}
+	write(i.module.Code)
+	for _, c := range i.constants {
+		if c.Kind() != types.KindRef || c.Ref() >= len(i.heap) {
+			continue
+		}
+		if fn, ok := i.heap[c.Ref()].(*types.Function); ok {
+			write(fn.Code)
+		}
+	}
+	return h.Sum64()
+}
+
+func writeMap[K comparable](s *snapshotWriter, form byte, m *types.TypedMap[K], key func(K)) {
+	s.slot(slotMap)
+	s.buf = append(s.buf, form)
+	s.uvarint(s.typ(m.Typ))
+	s.uvarint(m.Len())
+	m.Range(func(k K, v types.Boxed) {
+		key(k)
+		s.u64(uint64(v))
+	})
+}
+
+func readMap[K comparable](d *snapshotReader, typ *types.MapType, key func() K) types.Value {
+	n := d.count(9)
+	m := types.NewTypedMap[K](typ, n)
+	for range n {
+		k := key()
+		m.Set(k, types.Boxed(d.u64()))
+	}
+	return m
+}
+
+func (s *snapshotWriter) slot(tag heapSlot) {
+	s.buf = append(s.buf, byte(tag))
+}
+
+// value stores v among the values encoded as program constants.
+func (s *snapshotWriter) value(v types.Value) {
+	s.slot(slotValue)
+	s.uvarint(len(s.values))
+	s.values = append(s.values, v)
+}
+
+// typ returns the index of t in the type table, adding it on first use.
+func (s *snapshotWriter) typ(t types.Type) int {
+	idx, ok := s.index[t]
+	if !ok {
+		idx = len(s.types)
+		s.index[t] = idx
+		s.types = append(s.types, t)
+	}
+	return idx
+}
+
+func (s *snapshotWriter) boxes(vs []types.Boxed) {
+	s.uvarint(len(vs))
+	for _, v := range vs {
+		s.u64(uint64(v))
+	}
+}
+
+func (s *snapshotWriter) uvarint(v int) {
+	s.buf = binary.AppendUvarint(s.buf, uint64(v))
+}
+
+func (s *snapshotWriter) u64(v uint64) {
+	s.buf = binary.LittleEndian.AppendUint64(s.buf, v)
+}
+
+func (s *snapshotWriter) bool(v bool) {
+	if v {
+		s.buf = append(s.buf, 1)
+	} else {
+		s.buf = append(s.buf, 0)
+	}
+}
+
+func (s *snapshotWriter) bytes(b []byte) {
+	s.uvarint(len(b))
+	s.buf = append(s.buf, b...)
+}
+
+func (s *snapshotWriter) string(v string) {
+	s.uvarint(len(v))
+	s.buf = append(s.buf, v...)
+}
+
+func (d *snapshotReader) mapValue(table []types.Type) types.Value {
+	form := d.byte()
+	idx := d.int()
+	if d.err != nil {
+		return nil
+	}
+	var typ *types.MapType
+	if idx < len(table) {
+		typ, _ = table[idx].(*types.MapType)
+	}
+	if typ == nil {
+		d.fail("map type %d is not a map type", idx)
+		return nil
+	}
+	switch form {
+	case formI1:
+		return readMap(d, typ, d.bool)
+	case formI8:
+		return readMap(d, typ, func() int8 { return int8(d.u64()) })
+	case formI32:
+		return readMap(d, typ, func() int32 { return int32(d.u64()) })
+	case formI64:
+		return readMap(d, typ, func() int64 { return int64(d.u64()) })
+	case formF32:
+		return readMap(d, typ, func() float32 { return math.Float32frombits(uint32(d.u64())) })
+	case formF64:
+		return readMap(d, typ, func() float64 { return math.Float64frombits(d.u64()) })
+	case formString:
+		return readMap(d, typ, d.string)
+	case formGeneric:
+		n := d.count(26)
+		m := types.NewMapWithCapacity(typ, n)
+		for range n {
+			key := types.MapKey{Kind: types.Kind(d.byte()), Bits: d.u64(), Text: d.string()}
+			m.Set(key, types.MapEntry{Key: types.Boxed(d.u64()), Value: types.Boxed(d.u64())})
+		}
+		return m
+	default:
+		d.fail("unknown map form %d", form)
+		return nil
+	}
+}
+
+func (d *snapshotReader) coroutine(table []types.Type) *coroutine {
+	co := &coroutine{}
+	if idx := d.int(); idx > 0 && d.err == nil {
+		if idx <= len(table) {
+			co.typ, _ = table[idx-1].(*types.FunctionType)
+		}
+		if co.typ == nil {
+			d.fail("coroutine type %d is not a function type", idx-1)
+		}
+	}
+	co.image = d.boxes()
+	co.value = types.Boxed(d.u64())
+	co.addr = d.int()
+	co.ref = d.int()
+	co.returns = d.int()
+	co.ip = d.int()
+	co.release = d.bool()
+	co.done = d.bool()
+	return co
+}
+
+func (d *snapshotReader) fail(format string, args ...any) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s", ErrInvalidSnapshot, fmt.Sprintf(format, args...))
+	}
+}
+
+func (d *snapshotReader) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if d.off >= len(d.data) {
+		d.fail("unexpected end of input")
+		return 0
+	}
+	b := d.data[d.off]
+	d.off++
+	return b
+}
+
+func (d *snapshotReader) int() int {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data[d.off:])
+	if n <= 0 || v > math.MaxInt32 {
+		d.fail("malformed integer")
+		return 0
+	}
+	d.off += n
+	return int(v)
+}
+
+// count reads a length whose items take at least width bytes each, rejecting
+// one the remaining input cannot hold before anything is allocated for it.
+func (d *snapshotReader) count(width int) int {
+	n := d.int()
+	if d.err == nil && n > (len(d.data)-d.off)/width {
+		d.fail("length %d exceeds input", n)
+		return 0
+	}
+	return n
+}
+
+func (d *snapshotReader) u64() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data)-d.off < 8 {
+		d.fail("unexpected end of input")
+		return 0
+	}
+	v := binary.LittleEndian.Uint64(d.data[d.off:])
+	d.off += 8
+	return v
+}
+
+func (d *snapshotReader) bool() bool {
+	switch b := d.byte(); b {
+	case 0:
+		return false
+	case 1:
+		return true
+	default:
+		d.fail("invalid bool %d", b)
+		return false
+	}
+}
+
+func (d *snapshotReader) bytes() []byte {
+	n := d.count(1)
+	if d.err != nil {
+		return nil
+	}
+	b := d.data[d.off : d.off+n]
+	d.off += n
+	return b
+}
+
+func (d *snapshotReader) string() string {
+	return string(d.bytes())
+}
+
+func (d *snapshotReader) boxes() []types.Boxed {
+	n := d.count(8)
+	if d.err != nil {
+		return nil
+	}
+	vs := make([]types.Boxed, n)
+	for j := range vs {
+		vs[j] = types.Boxed(d.u64())
+	}
+	return vs
+}
@@ -0,0 +1,235 @@
+package interp_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/siyul-park/minivm/instr"
+	interp "github.com/siyul-park/minivm/interp"
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
+	"github.com/stretchr/testify/require"
+)
+
+// names is a Resolver over a fixed set of host values.
+type names map[string]types.Value
+
+func (n names) Name(val types.Value) (string, bool) {
+	for name, v := range n {
+		if v == val {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (n names) Resolve(name string) (types.Value, error) {
+	if v, ok := n[name]; ok {
+		return v, nil
+	}
+	return nil, errors.New("unknown host value")
+}
+
+func TestInterpreter_Snapshot(t *testing.T) {
+	prog := program.New([]instr.Instruction{
+		instr.New(instr.I32_CONST, 1),
+		instr.New(instr.YIELD),
+	})
+
+	t.Run("writes a suspended interpreter", func(t *testing.T) {
+		i := interp.New(prog)
+		defer i.Close()
+
+		require.ErrorIs(t, i.Run(context.Background()), interp.ErrYield)
+		var buf bytes.Buffer
+		require.NoError(t, i.Snapshot(&buf))
+		require.NotZero(t, buf.Len())
+	})
+
+	t.Run("rejects a running interpreter", func(t *testing.T) {
+		var err error
+		i := interp.New(prog, interp.WithTick(1), interp.WithHook(func(i *interp.Interpreter) error {
+			err = i.Snapshot(&bytes.Buffer{})
+			return nil
+		}))
+		defer i.Close()
+
+		require.ErrorIs(t, i.Run(context.Background()), interp.ErrYield)
+		require.ErrorIs(t, err, interp.ErrInterpreterBusy)
+	})
+
+	t.Run("rejects host values without a resolver", func(t *testing.T) {
+		fn := interp.NewHostFunction(&types.FunctionType{}, nil)
+		i := interp.New(prog)
+		defer i.Close()
+
+		_, err := i.Alloc(fn)
+		require.NoError(t, err)
+		require.ErrorIs(t, i.Snapshot(&bytes.Buffer{}), interp.ErrUnportableValue)
+	})
+}
+
+func TestRestore(t *testing.T) {
+	suspend := func(t *testing.T, prog *program.Program) *bytes.Buffer {
+		t.Helper()
+		i := interp.New(prog)
+		defer i.Close()
+		require.ErrorIs(t, i.Run(context.Background()), interp.ErrYield)
+		var buf bytes.Buffer
+		require.NoError(t, i.Snapshot(&buf))
+		return &buf
+	}
+
+	t.Run("resumes at the pending yield", func(t *testing.T) {
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 40),
+			instr.New(instr.REF_NEW),
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.GLOBAL_SET, 0),
+			instr.New(instr.I32_CONST, 7),
+			instr.New(instr.YIELD),
+			instr.New(instr.DROP),
+			instr.New(instr.REF_GET),
+			instr.New(instr.GLOBAL_GET, 0),
+			instr.New(instr.I32_ADD),
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_ADD),
+		}, program.WithGlobals(types.TypeI32))
+		snapshot := suspend(t, prog)
+
+		i, err := interp.Restore(prog, snapshot)
+		require.NoError(t, err)
+		defer i.Close()
+
+		top, err := i.Peek(0)
+		require.NoError(t, err)
+		require.Equal(t, types.BoxI32(7), top)
+		require.NoError(t, i.Run(context.Background()))
+		val, err := i.Pop()
+		require.NoError(t, err)
+		require.Equal(t, types.I32(42), val)
+	})
+
+	t.Run("restores closures and coroutines", func(t *testing.T) {
+		fn := types.NewFunctionBuilder(&types.FunctionType{
+			Returns: []types.Type{types.TypeI32},
+		}).Captures(types.TypeAny).Emit(
+			instr.New(instr.UPVAL_GET, 0),
+			instr.New(instr.REF_GET),
+			instr.New(instr.YIELD),
+			instr.New(instr.UPVAL_GET, 0),
+			instr.New(instr.REF_GET),
+			instr.New(instr.I32_ADD),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 10),
+			instr.New(instr.REF_NEW),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CLOSURE_NEW),
+			instr.New(instr.CALL),
+			instr.New(instr.DUP),
+			instr.New(instr.CORO_VALUE),
+			instr.New(instr.YIELD),
+			instr.New(instr.I32_CONST, 5),
+			instr.New(instr.I32_ADD),
+			instr.New(instr.RESUME),
+			instr.New(instr.CORO_VALUE),
+		}, program.WithConstants(fn))
+
+		want := interp.New(prog)
+		defer want.Close()
+		require.ErrorIs(t, want.Run(context.Background()), interp.ErrYield)
+		require.NoError(t, want.Run(context.Background()))
+		expected, err := want.Pop()
+		require.NoError(t, err)
+
+		i, err := interp.Restore(prog, suspend(t, prog))
+		require.NoError(t, err)
+		defer i.Close()
+
+		require.NoError(t, i.Run(context.Background()))
+		val, err := i.Pop()
+		require.NoError(t, err)
+		require.Equal(t, expected, val)
+		require.Equal(t, types.I32(25), val)
+	})
+
+	t.Run("restores heap containers", func(t *testing.T) {
+		typ := types.NewArrayType(types.TypeI32)
+		build := func() *program.Program {
+			return program.New([]instr.Instruction{
+				instr.New(instr.CONST_GET, 0),
+				instr.New(instr.I32_CONST, 1),
+				instr.New(instr.I32_CONST, 9),
+				instr.New(instr.ARRAY_SET),
+				instr.New(instr.I32_CONST, 0),
+				instr.New(instr.YIELD),
+				instr.New(instr.DROP),
+				instr.New(instr.CONST_GET, 0),
+				instr.New(instr.I32_CONST, 1),
+				instr.New(instr.ARRAY_GET),
+			}, program.WithConstants(types.NewArray(typ, types.BoxI32(1), types.BoxI32(2))))
+		}
+		snapshot := suspend(t, build())
+
+		i, err := interp.Restore(build(), snapshot)
+		require.NoError(t, err)
+		defer i.Close()
+
+		require.NoError(t, i.Run(context.Background()))
+		val, err := i.Pop()
+		require.NoError(t, err)
+		require.Equal(t, types.I32(9), val)
+	})
+
+	t.Run("resolves host values", func(t *testing.T) {
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.YIELD),
+		})
+		fn := interp.NewHostFunction(&types.FunctionType{}, nil)
+		resolver := names{"fn": fn}
+
+		i := interp.New(prog, interp.WithResolver(resolver))
+		defer i.Close()
+		require.ErrorIs(t, i.Run(context.Background()), interp.ErrYield)
+		addr, err := i.Alloc(fn)
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		require.NoError(t, i.Snapshot(&buf))
+
+		_, err = interp.Restore(prog, bytes.NewReader(buf.Bytes()))
+		require.ErrorIs(t, err, interp.ErrUnportableValue)
+
+		restored, err := interp.Restore(prog, bytes.NewReader(buf.Bytes()), interp.WithResolver(resolver))
+		require.NoError(t, err)
+		defer restored.Close()
+		val, err := restored.Load(addr)
+		require.NoError(t, err)
+		require.Same(t, fn, val)
+	})
+
+	t.Run("rejects another program", func(t *testing.T) {
+		prog := program.New([]instr.Instruction{instr.New(instr.I32_CONST, 1), instr.New(instr.YIELD)})
+		snapshot := suspend(t, prog)
+
+		other := program.New([]instr.Instruction{instr.New(instr.I32_CONST, 2), instr.New(instr.YIELD)})
+		_, err := interp.Restore(other, snapshot)
+		require.ErrorIs(t, err, interp.ErrInvalidSnapshot)
+	})
+
+	t.Run("rejects damaged input", func(t *testing.T) {
+		prog := program.New([]instr.Instruction{instr.New(instr.I32_CONST, 1), instr.New(instr.YIELD)})
+		data := suspend(t, prog).Bytes()
+
+		_, err := interp.Restore(prog, bytes.NewReader([]byte("snapshot")))
+		require.ErrorIs(t, err, interp.ErrInvalidSnapshot)
+		for n := len(data) - 1; n > 0; n-- {
+			_, err := interp.Restore(prog, bytes.NewReader(data[:n]))
+			require.ErrorIs(t, err, interp.ErrInvalidSnapshot, "truncated to %d bytes", n)
+		}
+	})
+}
@@ -309,6 +309,9 @@ var (
 							panic(ErrStackOverflow)
 						}
 						args := i.stack[i.sp-params-1 : i.sp-1]
+						if i.costs != nil {
+							i.owe(i.costs.host(fn))
+						}
 						out, err := fn.Fn(i, args)
 						if err != nil {
 							panic(err)
@@ -604,6 +607,9 @@ var (
 							panic(ErrStackOverflow)
 						}
 						args := i.stack[i.sp-params-1 : i.sp-1]
+						if i.costs != nil {
+							i.owe(i.costs.host(fn))
+						}
 						out, err := fn.Fn(i, args)
 						if err != nil {
 							panic(err)
@@ -3600,6 +3606,7 @@ var (
 					if size < 0 {
 						panic(ErrSegmentationFault)
 					}
+					i.reserve(elemBytes[bool](int(size)))
 					val := make(types.TypedArray[bool], size)
 					i.stack[i.sp-1] = types.BoxRef(i.alloc(val))
 					i.fr.ip += 3
@@ -3613,6 +3620,7 @@ var (
 					if size < 0 {
 						panic(ErrSegmentationFault)
 					}
+					i.reserve(elemBytes[int8](int(size)))
 					val := make(types.TypedArray[int8], size)
 					i.stack[i.sp-1] = types.BoxRef(i.alloc(val))
 					i.fr.ip += 3
@@ -3626,6 +3634,7 @@ var (
 					if size < 0 {
 						panic(ErrSegmentationFault)
 					}
+					i.reserve(elemBytes[int32](int(size)))
 					val := make(types.TypedArray[int32], size)
 					i.stack[i.sp-1] = types.BoxRef(i.alloc(val))
 					i.fr.ip += 3
@@ -3639,6 +3648,7 @@ var (
 					if size < 0 {
 						panic(ErrSegmentationFault)
 					}
+					i.reserve(elemBytes[int64](int(size)))
 					val := make(types.TypedArray[int64], size)
 					i.stack[i.sp-1] = types.BoxRef(i.alloc(val))
 					i.fr.ip += 3
@@ -3652,6 +3662,7 @@ var (
 					if size < 0 {
 						panic(ErrSegmentationFault)
 					}
+					i.reserve(elemBytes[float32](int(size)))
 					val := make(types.TypedArray[float32], size)
 					i.stack[i.sp-1] = types.BoxRef(i.alloc(val))
 					i.fr.ip += 3
@@ -3665,6 +3676,7 @@ var (
 					if size < 0 {
 						panic(ErrSegmentationFault)
 					}
+					i.reserve(elemBytes[float64](int(size)))
 					val := make(types.TypedArray[float64], size)
 					i.stack[i.sp-1] = types.BoxRef(i.alloc(val))
 					i.fr.ip += 3
@@ -3678,6 +3690,7 @@ var (
 					if size < 0 {
 						panic(ErrSegmentationFault)
 					}
+					i.reserve(elemBytes[types.Boxed](int(size)))
 					val := i.newArraySized(typ, int(size))
 					for j := range val.Elems {
 						val.Elems[j] = types.BoxedNull
@@ -4099,36 +4112,43 @@ var (
 				base := i.sp - n - 1
 				switch arr := i.heap[addr].(type) {
 				case types.TypedArray[bool]:
+					arr = grow(i, arr, n)
 					for k := 0; k < n; k++ {
 						arr = append(arr, i.stack[base+k].Bool())
 					}
 					i.heap[addr] = arr
 				case types.TypedArray[int8]:
+					arr = grow(i, arr, n)
 					for k := 0; k < n; k++ {
 						arr = append(arr, int8(i.stack[base+k].I32()))
 					}
 					i.heap[addr] = arr
 				case types.TypedArray[int32]:
+					arr = grow(i, arr, n)
 					for k := 0; k < n; k++ {
 						arr = append(arr, i.stack[base+k].I32())
 					}
 					i.heap[addr] = arr
 				case types.TypedArray[int64]:
+					arr = grow(i, arr, n)
 					for k := 0; k < n; k++ {
 						arr = append(arr, i.unboxI64(i.stack[base+k]))
 					}
 					i.heap[addr] = arr
 				case types.TypedArray[float32]:
+					arr = grow(i, arr, n)
 					for k := 0; k < n; k++ {
 						arr = append(arr, i.stack[base+k].F32())
 					}
 					i.heap[addr] = arr
 				case types.TypedArray[float64]:
+					arr = grow(i, arr, n)
 					for k := 0; k < n; k++ {
 						arr = append(arr, i.stack[base+k].F64())
 					}
 					i.heap[addr] = arr
 				case *types.Array:
+					arr.Elems = grow(i, arr.Elems, n)
 					for k := 0; k < n; k++ {
 						arr.Elems = append(arr.Elems, i.stack[base+k])
 					}
@@ -4139,6 +4159,7 @@ var (
 				default:
 					panic(ErrTypeMismatch)
 				}
+				i.resize(addr)
 				i.sp -= n + 1
 				i.fr.ip++
 			}
@@ -4596,6 +4617,7 @@ var (
 				if capacity < 0 {
 					panic(ErrIndexOutOfRange)
 				}
+				i.reserve(elemBytes[types.MapEntry](capacity))
 				i.stack[i.sp-1] = types.BoxRef(i.alloc(types.NewMapForType(typ, capacity)))
 				i.fr.ip += 3
 			}
@@ -4878,6 +4900,11 @@ var (
 				default:
 					panic(ErrTypeMismatch)
 				}
+				if !i.resize(addr) {
+					i.unset(addr, key)
+					i.resize(addr)
+					panic(ErrHeapExhausted)
+				}
 				i.release(addr)
 				i.sp -= 3
 				i.fr.ip++
@@ -59947,6 +59974,9 @@ var (
 							panic(ErrStackOverflow)
 						}
 						args := i.stack[i.sp-params : i.sp]
+						if i.costs != nil {
+							i.owe(i.costs.host(fn))
+						}
 						out, err := fn.Fn(i, args)
 						if err != nil {
 							panic(err)
@@ -60190,6 +60220,9 @@ var (
 							panic(ErrStackOverflow)
 						}
 						args := i.stack[i.sp-params : i.sp]
+						if i.costs != nil {
+							i.owe(i.costs.host(fn))
+						}
 						out, err := fn.Fn(i, args)
 						if err != nil {
 							panic(err)
@@ -321,6 +321,7 @@ func (t *tracer) clone(i *Interpreter) Interpreter {
 	out.heap = slices.Clone(i.heap)
 	out.free = slices.Clone(i.free)
 	out.rc = slices.Clone(i.rc)
+	out.sizes = slices.Clone(i.sizes)
 	out.trial = nil
 	out.work = nil
 	out.refbuf = nil
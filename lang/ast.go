@@ -0,0 +1,302 @@
+package lang
+
+import "github.com/siyul-park/minivm/types"
+
+// file is a parsed source file: its declarations and top-level statements in
+// source order. result is the trailing expression left on the operand stack,
+// or nil when the file ends with a statement.
+type file struct {
+	decls  []decl
+	stmts  []stmt
+	result expr
+}
+
+type decl interface{ decl() }
+
+type stmt interface{ stmt() }
+
+type expr interface{ expr() }
+
+type typeExpr interface{ typeExpr() }
+
+// param is one named, typed parameter or struct field.
+type param struct {
+	pos  types.Position
+	name string
+	typ  typeExpr
+}
+
+// signature is a function's parameter list and optional result type.
+type signature struct {
+	params []param
+	result typeExpr
+}
+
+type funcDecl struct {
+	pos    types.Position
+	export bool
+	name   string
+	sig    signature
+	body   *block
+	fn     *function
+}
+
+type importDecl struct {
+	pos    types.Position
+	name   string
+	symbol string
+	sig    signature
+}
+
+type structDecl struct {
+	pos    types.Position
+	name   string
+	fields []param
+}
+
+type block struct {
+	pos   types.Position
+	stmts []stmt
+}
+
+type letStmt struct {
+	pos  types.Position
+	name string
+	typ  typeExpr
+	init expr
+	to   *binding
+}
+
+// assignStmt stores value into target. op is the binary operator of a
+// compound assignment such as +=, or "" for plain =. temps are the hidden
+// slots that hold a compound target's container and key so they are evaluated
+// once.
+type assignStmt struct {
+	pos    types.Position
+	target expr
+	op     string
+	value  expr
+	temps  []int
+}
+
+type exprStmt struct {
+	pos types.Position
+	x   expr
+}
+
+type ifStmt struct {
+	pos  types.Position
+	cond expr
+	then *block
+	els  stmt
+}
+
+type whileStmt struct {
+	pos  types.Position
+	cond expr
+	body *block
+}
+
+// forStmt iterates an array's elements, a map's keys, or a string's code
+// points. seq and idx are the hidden slots holding the array being walked and
+// the cursor into it.
+type forStmt struct {
+	pos  types.Position
+	name string
+	x    expr
+	body *block
+	to   *binding
+	seq  int
+	idx  int
+}
+
+type branchStmt struct {
+	pos types.Position
+	tok string
+}
+
+type returnStmt struct {
+	pos types.Position
+	x   expr
+}
+
+type throwStmt struct {
+	pos types.Position
+	x   expr
+}
+
+type tryStmt struct {
+	pos   types.Position
+	body  *block
+	name  string
+	catch *block
+	to    *binding
+}
+
+type ident struct {
+	pos  types.Position
+	name string
+	to   *binding
+}
+
+type literal struct {
+	pos  types.Position
+	kind kind
+	text string
+}
+
+type unary struct {
+	pos types.Position
+	op  string
+	x   expr
+}
+
+type binary struct {
+	pos types.Position
+	op  string
+	x   expr
+	y   expr
+}
+
+type cast struct {
+	pos types.Position
+	x   expr
+	to  typeExpr
+}
+
+// call applies fn to args. builtin names the intrinsic the call resolved to,
+// or is "" for a call through a function value.
+type call struct {
+	pos     types.Position
+	fn      expr
+	args    []expr
+	builtin string
+}
+
+type index struct {
+	pos types.Position
+	x   expr
+	key expr
+}
+
+type selector struct {
+	pos   types.Position
+	x     expr
+	name  string
+	field int
+}
+
+type arrayLit struct {
+	pos   types.Position
+	elems []expr
+}
+
+type mapLit struct {
+	pos  types.Position
+	typ  *mapType
+	keys []expr
+	vals []expr
+}
+
+// structLit builds a struct. vals is reordered by the checker into
+// declaration order, which is also the order the initializers run in.
+type structLit struct {
+	pos    types.Position
+	name   string
+	fields []string
+	vals   []expr
+}
+
+type funcLit struct {
+	pos  types.Position
+	sig  signature
+	body *block
+	fn   *function
+}
+
+type typeName struct {
+	pos  types.Position
+	name string
+}
+
+type arrayType struct {
+	pos  types.Position
+	elem typeExpr
+}
+
+type mapType struct {
+	pos  types.Position
+	key  typeExpr
+	elem typeExpr
+}
+
+type funcType struct {
+	pos    types.Position
+	params []typeExpr
+	result typeExpr
+}
+
+func (*funcDecl) decl()   {}
+func (*importDecl) decl() {}
+func (*structDecl) decl() {}
+
+func (*block) stmt()      {}
+func (*letStmt) stmt()    {}
+func (*assignStmt) stmt() {}
+func (*exprStmt) stmt()   {}
+func (*ifStmt) stmt()     {}
+func (*whileStmt) stmt()  {}
+func (*forStmt) stmt()    {}
+func (*branchStmt) stmt() {}
+func (*returnStmt) stmt() {}
+func (*throwStmt) stmt()  {}
+func (*tryStmt) stmt()    {}
+
+func (*ident) expr()     {}
+func (*literal) expr()   {}
+func (*unary) expr()     {}
+func (*binary) expr()    {}
+func (*cast) expr()      {}
+func (*call) expr()      {}
+func (*index) expr()     {}
+func (*selector) expr()  {}
+func (*arrayLit) expr()  {}
+func (*mapLit) expr()    {}
+func (*structLit) expr() {}
+func (*funcLit) expr()   {}
+
+func (*typeName) typeExpr()  {}
+func (*arrayType) typeExpr() {}
+func (*mapType) typeExpr()   {}
+func (*funcType) typeExpr()  {}
+
+// position returns where e starts.
+func position(e expr) types.Position {
+	switch e := e.(type) {
+	case *ident:
+		return e.pos
+	case *literal:
+		return e.pos
+	case *unary:
+		return e.pos
+	case *binary:
+		return e.pos
+	case *cast:
+		return e.pos
+	case *call:
+		return e.pos
+	case *index:
+		return e.pos
+	case *selector:
+		return e.pos
+	case *arrayLit:
+		return e.pos
+	case *mapLit:
+		return e.pos
+	case *structLit:
+		return e.pos
+	case *funcLit:
+		return e.pos
+	}
+	return types.Position{}
+}
@@ -0,0 +1,953 @@
+package lang
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/siyul-park/minivm/types"
+)
+
+// checker resolves every name in a parsed file and type-checks it. It
+// annotates the tree in place: identifiers with the binding they denote,
+// functions with their frame layout, and hidden slots on the statements that
+// need them. Types are recorded per expression for the generator.
+type checker struct {
+	module  *scope
+	top     *function
+	funcs   []*function
+	imports []*importDecl
+	globals []*binding
+	structs map[string]*types.StructType
+	named   map[*types.StructType]string
+	types   map[expr]types.Type
+
+	fn    *function
+	loops int
+}
+
+// binding is what a name denotes: a frame slot, a global, a captured upvalue,
+// or a function constant.
+type binding struct {
+	name  string
+	place place
+	index int
+	typ   types.Type
+	owner *function
+	from  *binding
+}
+
+type place int
+
+// function is one frame layout: the signature, the slot types (params first),
+// their debug names, and the captured upvalues in capture order. Each capture
+// is an upval binding whose from is what the enclosing function loads to
+// create the closure.
+type function struct {
+	name     string
+	typ      *types.FunctionType
+	slots    []types.Type
+	names    []string
+	captures []*binding
+	upvals   map[*binding]*binding
+	parent   *function
+	index    int
+}
+
+// scope is one lexical block. fn is the function whose frame holds the
+// block's locals; the module scope has none.
+type scope struct {
+	parent *scope
+	fn     *function
+	names  map[string]*binding
+}
+
+const (
+	placeLocal place = iota
+	placeGlobal
+	placeUpval
+	placeConst
+)
+
+var builtins = map[string]bool{
+	"code": true, "delete": true, "error": true, "has": true, "len": true, "push": true,
+}
+
+var primitives = map[string]types.Type{
+	"bool":   types.TypeI1,
+	"error":  types.TypeError,
+	"f32":    types.TypeF32,
+	"f64":    types.TypeF64,
+	"i32":    types.TypeI32,
+	"i64":    types.TypeI64,
+	"string": types.TypeString,
+}
+
+func newChecker() *checker {
+	module := &scope{names: map[string]*binding{}}
+	return &checker{
+		module:  module,
+		top:     &function{name: "main", typ: &types.FunctionType{}, upvals: map[*binding]*binding{}, index: -1},
+		structs: map[string]*types.StructType{},
+		named:   map[*types.StructType]string{},
+		types:   map[expr]types.Type{},
+	}
+}
+
+func (c *checker) check(f *file) error {
+	for _, d := range f.decls {
+		if d, ok := d.(*structDecl); ok {
+			if err := c.structDecl(d); err != nil {
+				return err
+			}
+		}
+	}
+	var decls []*funcDecl
+	for _, d := range f.decls {
+		switch d := d.(type) {
+		case *importDecl:
+			typ, err := c.signature(d.sig)
+			if err != nil {
+				return err
+			}
+			if err := c.declare(c.module, d.pos, d.name, &binding{place: placeConst, typ: typ, index: len(c.imports) + len(c.funcs)}); err != nil {
+				return err
+			}
+			c.imports = append(c.imports, d)
+		case *funcDecl:
+			typ, err := c.signature(d.sig)
+			if err != nil {
+				return err
+			}
+			d.fn = c.function(d.name, typ, nil)
+			d.fn.index = len(c.imports) + len(c.funcs)
+			if err := c.declare(c.module, d.pos, d.name, &binding{place: placeConst, typ: typ, index: d.fn.index}); err != nil {
+				return err
+			}
+			c.funcs = append(c.funcs, d.fn)
+			decls = append(decls, d)
+		}
+	}
+
+	c.fn = c.top
+	top := &scope{parent: c.module, names: map[string]*binding{}}
+	for _, s := range f.stmts {
+		if err := c.stmt(top, s); err != nil {
+			return err
+		}
+	}
+	if f.result != nil {
+		typ, err := c.value(top, f.result, nil)
+		if err != nil {
+			return err
+		}
+		c.top.typ.Returns = []types.Type{typ}
+	}
+
+	for _, d := range decls {
+		if err := c.body(c.module, d.fn, d.sig, d.body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *checker) structDecl(d *structDecl) error {
+	if _, ok := c.structs[d.name]; ok || primitives[d.name] != nil {
+		return errorf(d.pos, ErrType, "%s redeclared", d.name)
+	}
+	t := types.NewStructType()
+	c.structs[d.name] = t
+	c.named[t] = d.name
+	seen := map[string]bool{}
+	for _, f := range d.fields {
+		if seen[f.name] {
+			return errorf(f.pos, ErrType, "duplicate field %s", f.name)
+		}
+		seen[f.name] = true
+		typ, err := c.resolve(f.typ)
+		if err != nil {
+			return err
+		}
+		t.Fields = append(t.Fields, types.NewStructField(typ, types.FieldWithName(f.name)))
+	}
+	return nil
+}
+
+func (c *checker) signature(sig signature) (*types.FunctionType, error) {
+	typ := &types.FunctionType{}
+	for _, p := range sig.params {
+		t, err := c.resolve(p.typ)
+		if err != nil {
+			return nil, err
+		}
+		typ.Params = append(typ.Params, t)
+	}
+	if sig.result != nil {
+		t, err := c.resolve(sig.result)
+		if err != nil {
+			return nil, err
+		}
+		typ.Returns = []types.Type{t}
+	}
+	return typ, nil
+}
+
+func (c *checker) function(name string, typ *types.FunctionType, parent *function) *function {
+	return &function{name: name, typ: typ, parent: parent, upvals: map[*binding]*binding{}, index: -1}
+}
+
+// body checks a function body in a fresh frame whose params are bound in a
+// scope nested in outer.
+func (c *checker) body(outer *scope, fn *function, sig signature, body *block) error {
+	fn0, loops := c.fn, c.loops
+	c.fn, c.loops = fn, 0
+	defer func() { c.fn, c.loops = fn0, loops }()
+
+	s := &scope{parent: outer, fn: fn, names: map[string]*binding{}}
+	for i, p := range sig.params {
+		if err := c.declare(s, p.pos, p.name, c.slot(p.name, fn.typ.Params[i])); err != nil {
+			return err
+		}
+	}
+	if err := c.block(s, body); err != nil {
+		return err
+	}
+	if len(fn.typ.Returns) > 0 && !terminates(body) {
+		return errorf(body.pos, ErrType, "missing return in %s", fn.name)
+	}
+	return nil
+}
+
+// slot allocates a frame slot in the current function. An empty name marks a
+// hidden temporary.
+func (c *checker) slot(name string, typ types.Type) *binding {
+	fn := c.fn
+	fn.slots = append(fn.slots, typ)
+	fn.names = append(fn.names, name)
+	return &binding{name: name, place: placeLocal, index: len(fn.slots) - 1, typ: typ, owner: fn}
+}
+
+func (c *checker) declare(s *scope, pos types.Position, name string, b *binding) error {
+	if _, ok := s.names[name]; ok {
+		return errorf(pos, ErrType, "%s redeclared", name)
+	}
+	b.name = name
+	s.names[name] = b
+	return nil
+}
+
+// lookup resolves name from s outward, capturing it when it lives in an
+// enclosing function's frame.
+func (c *checker) lookup(s *scope, name string) *binding {
+	for sc := s; sc != nil; sc = sc.parent {
+		if b, ok := sc.names[name]; ok {
+			return c.capture(c.fn, b)
+		}
+	}
+	return nil
+}
+
+// capture returns how fn reaches b: b itself when fn owns it or it is not a
+// frame slot, otherwise an upvalue threaded through every enclosing closure.
+func (c *checker) capture(fn *function, b *binding) *binding {
+	if (b.place != placeLocal && b.place != placeUpval) || b.owner == fn {
+		return b
+	}
+	if up, ok := fn.upvals[b]; ok {
+		return up
+	}
+	from := c.capture(fn.parent, b)
+	up := &binding{name: b.name, place: placeUpval, index: len(fn.captures), typ: b.typ, owner: fn, from: from}
+	fn.captures = append(fn.captures, up)
+	fn.upvals[b] = up
+	return up
+}
+
+func (c *checker) block(outer *scope, b *block) error {
+	s := &scope{parent: outer, fn: c.fn, names: map[string]*binding{}}
+	for _, st := range b.stmts {
+		if err := c.stmt(s, st); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *checker) stmt(s *scope, st stmt) error {
+	switch st := st.(type) {
+	case *block:
+		return c.block(s, st)
+	case *letStmt:
+		return c.let(s, st)
+	case *assignStmt:
+		return c.assign(s, st)
+	case *exprStmt:
+		_, err := c.expr(s, st.x, nil)
+		return err
+	case *ifStmt:
+		if err := c.cond(s, st.cond); err != nil {
+			return err
+		}
+		if err := c.block(s, st.then); err != nil {
+			return err
+		}
+		if st.els != nil {
+			return c.stmt(s, st.els)
+		}
+		return nil
+	case *whileStmt:
+		if err := c.cond(s, st.cond); err != nil {
+			return err
+		}
+		c.loops++
+		defer func() { c.loops-- }()
+		return c.block(s, st.body)
+	case *forStmt:
+		return c.forStmt(s, st)
+	case *branchStmt:
+		if c.loops == 0 {
+			return errorf(st.pos, ErrType, "%s outside a loop", st.tok)
+		}
+		return nil
+	case *returnStmt:
+		return c.returnStmt(s, st)
+	case *throwStmt:
+		typ, err := c.value(s, st.x, types.TypeError)
+		if err != nil {
+			return err
+		}
+		if typ != types.TypeError {
+			return errorf(position(st.x), ErrType, "cannot throw %s", c.typeString(typ))
+		}
+		return nil
+	case *tryStmt:
+		if err := c.block(s, st.body); err != nil {
+			return err
+		}
+		inner := &scope{parent: s, fn: c.fn, names: map[string]*binding{}}
+		st.to = c.slot(st.name, types.TypeError)
+		if err := c.declare(inner, st.pos, st.name, st.to); err != nil {
+			return err
+		}
+		return c.block(inner, st.catch)
+	}
+	return nil
+}
+
+func (c *checker) let(s *scope, st *letStmt) error {
+	var want types.Type
+	if st.typ != nil {
+		t, err := c.resolve(st.typ)
+		if err != nil {
+			return err
+		}
+		want = t
+	}
+	typ, err := c.value(s, st.init, want)
+	if err != nil {
+		return err
+	}
+	if want != nil {
+		if err := c.assignable(position(st.init), typ, want); err != nil {
+			return err
+		}
+		typ = want
+	}
+
+	if s.parent == c.module {
+		st.to = &binding{place: placeGlobal, index: len(c.globals), typ: typ}
+		if err := c.declare(c.module, st.pos, st.name, st.to); err != nil {
+			return err
+		}
+		c.globals = append(c.globals, st.to)
+		return nil
+	}
+	st.to = c.slot(st.name, typ)
+	return c.declare(s, st.pos, st.name, st.to)
+}
+
+func (c *checker) assign(s *scope, st *assignStmt) error {
+	var want types.Type
+	switch target := st.target.(type) {
+	case *ident:
+		b := c.lookup(s, target.name)
+		if b == nil {
+			return errorf(target.pos, ErrType, "undefined: %s", target.name)
+		}
+		switch b.place {
+		case placeUpval:
+			return errorf(target.pos, ErrType, "cannot assign to captured variable %s", target.name)
+		case placeConst:
+			return errorf(target.pos, ErrType, "cannot assign to function %s", target.name)
+		}
+		target.to = b
+		want = b.typ
+		c.types[target] = want
+	case *index:
+		typ, err := c.expr(s, target, nil)
+		if err != nil {
+			return err
+		}
+		want = typ
+		if st.op != "" {
+			st.temps = []int{c.slot("", c.types[target.x]).index, c.slot("", c.types[target.key]).index}
+		}
+	case *selector:
+		typ, err := c.expr(s, target, nil)
+		if err != nil {
+			return err
+		}
+		want = typ
+		if st.op != "" {
+			st.temps = []int{c.slot("", c.types[target.x]).index}
+		}
+	default:
+		return errorf(position(st.target), ErrType, "cannot assign to expression")
+	}
+
+	if st.op != "" {
+		typ, err := c.binary(s, &binary{pos: st.pos, op: st.op, x: st.target, y: st.value})
+		if err != nil {
+			return err
+		}
+		return c.assignable(st.pos, typ, want)
+	}
+	typ, err := c.value(s, st.value, want)
+	if err != nil {
+		return err
+	}
+	return c.assignable(position(st.value), typ, want)
+}
+
+func (c *checker) forStmt(s *scope, st *forStmt) error {
+	typ, err := c.value(s, st.x, nil)
+	if err != nil {
+		return err
+	}
+	var seq, elem types.Type
+	switch t := typ.(type) {
+	case *types.ArrayType:
+		seq, elem = t, t.Elem
+	case *types.MapType:
+		seq, elem = types.NewArrayType(t.Key), t.Key
+	default:
+		if typ != types.TypeString {
+			return errorf(position(st.x), ErrType, "cannot range over %s", c.typeString(typ))
+		}
+		seq, elem = types.TypeI32Array, types.TypeI32
+	}
+	st.seq = c.slot("", seq).index
+	st.idx = c.slot("", types.TypeI32).index
+
+	inner := &scope{parent: s, fn: c.fn, names: map[string]*binding{}}
+	st.to = c.slot(st.name, elem)
+	if err := c.declare(inner, st.pos, st.name, st.to); err != nil {
+		return err
+	}
+	c.loops++
+	defer func() { c.loops-- }()
+	return c.block(inner, st.body)
+}
+
+func (c *checker) returnStmt(s *scope, st *returnStmt) error {
+	if c.fn == c.top {
+		return errorf(st.pos, ErrType, "return outside a function")
+	}
+	var want types.Type
+	if len(c.fn.typ.Returns) > 0 {
+		want = c.fn.typ.Returns[0]
+	}
+	if st.x == nil {
+		if want != nil {
+			return errorf(st.pos, ErrType, "missing return value")
+		}
+		return nil
+	}
+	if want == nil {
+		return errorf(position(st.x), ErrType, "unexpected return value")
+	}
+	typ, err := c.value(s, st.x, want)
+	if err != nil {
+		return err
+	}
+	return c.assignable(position(st.x), typ, want)
+}
+
+func (c *checker) cond(s *scope, x expr) error {
+	typ, err := c.value(s, x, types.TypeI1)
+	if err != nil {
+		return err
+	}
+	if typ != types.TypeI1 {
+		return errorf(position(x), ErrType, "non-bool %s used as condition", c.typeString(typ))
+	}
+	return nil
+}
+
+// value checks an expression that must produce a value.
+func (c *checker) value(s *scope, x expr, want types.Type) (types.Type, error) {
+	typ, err := c.expr(s, x, want)
+	if err != nil {
+		return nil, err
+	}
+	if typ == nil {
+		return nil, errorf(position(x), ErrType, "expression has no value")
+	}
+	return typ, nil
+}
+
+// expr checks x and records its type; a nil type means no value. want is the
+// type the context expects, which lets untyped literals take it.
+func (c *checker) expr(s *scope, x expr, want types.Type) (types.Type, error) {
+	typ, err := c.infer(s, x, want)
+	if err != nil {
+		return nil, err
+	}
+	c.types[x] = typ
+	return typ, nil
+}
+
+func (c *checker) infer(s *scope, x expr, want types.Type) (types.Type, error) {
+	switch x := x.(type) {
+	case *ident:
+		b := c.lookup(s, x.name)
+		if b == nil {
+			return nil, errorf(x.pos, ErrType, "undefined: %s", x.name)
+		}
+		x.to = b
+		return b.typ, nil
+	case *literal:
+		return c.literal(x, want)
+	case *unary:
+		typ, err := c.value(s, x.x, want)
+		if err != nil {
+			return nil, err
+		}
+		if x.op == "!" && typ != types.TypeI1 || x.op == "-" && !numeric(typ) {
+			return nil, errorf(x.pos, ErrType, "invalid operation %s%s", x.op, c.typeString(typ))
+		}
+		return typ, nil
+	case *binary:
+		return c.binary(s, x)
+	case *cast:
+		from, err := c.value(s, x.x, nil)
+		if err != nil {
+			return nil, err
+		}
+		to, err := c.resolve(x.to)
+		if err != nil {
+			return nil, err
+		}
+		if !numeric(from) || !numeric(to) {
+			return nil, errorf(x.pos, ErrType, "cannot convert %s to %s", c.typeString(from), c.typeString(to))
+		}
+		return to, nil
+	case *call:
+		return c.call(s, x)
+	case *index:
+		container, err := c.value(s, x.x, nil)
+		if err != nil {
+			return nil, err
+		}
+		switch t := container.(type) {
+		case *types.ArrayType:
+			if err := c.operand(s, x.key, types.TypeI32); err != nil {
+				return nil, err
+			}
+			return t.Elem, nil
+		case *types.MapType:
+			if err := c.operand(s, x.key, t.Key); err != nil {
+				return nil, err
+			}
+			return t.Elem, nil
+		}
+		return nil, errorf(x.pos, ErrType, "cannot index %s", c.typeString(container))
+	case *selector:
+		typ, err := c.value(s, x.x, nil)
+		if err != nil {
+			return nil, err
+		}
+		t, ok := typ.(*types.StructType)
+		if !ok {
+			return nil, errorf(x.pos, ErrType, "%s has no field %s", c.typeString(typ), x.name)
+		}
+		x.field = t.FieldIndex(x.name)
+		if x.field < 0 {
+			return nil, errorf(x.pos, ErrType, "%s has no field %s", c.typeString(typ), x.name)
+		}
+		return t.Fields[x.field].Type, nil
+	case *arrayLit:
+		var elem types.Type
+		if t, ok := want.(*types.ArrayType); ok {
+			elem = t.Elem
+		}
+		if len(x.elems) == 0 {
+			if elem == nil {
+				return nil, errorf(x.pos, ErrType, "cannot infer the type of an empty array")
+			}
+			return want, nil
+		}
+		for _, e := range x.elems {
+			if elem == nil {
+				typ, err := c.value(s, e, nil)
+				if err != nil {
+					return nil, err
+				}
+				elem = typ
+				continue
+			}
+			if err := c.operand(s, e, elem); err != nil {
+				return nil, err
+			}
+		}
+		return types.NewArrayType(elem), nil
+	case *mapLit:
+		typ, err := c.resolve(x.typ)
+		if err != nil {
+			return nil, err
+		}
+		t := typ.(*types.MapType)
+		for i := range x.keys {
+			if err := c.operand(s, x.keys[i], t.Key); err != nil {
+				return nil, err
+			}
+			if err := c.operand(s, x.vals[i], t.Elem); err != nil {
+				return nil, err
+			}
+		}
+		return t, nil
+	case *structLit:
+		return c.structLit(s, x)
+	case *funcLit:
+		typ, err := c.signature(x.sig)
+		if err != nil {
+			return nil, err
+		}
+		x.fn = c.function(c.fn.name+".func", typ, c.fn)
+		if err := c.body(s, x.fn, x.sig, x.body); err != nil {
+			return nil, err
+		}
+		return typ, nil
+	}
+	return nil, errorf(position(x), ErrType, "unsupported expression")
+}
+
+func (c *checker) literal(x *literal, want types.Type) (types.Type, error) {
+	switch x.kind {
+	case tokInt:
+		v, err := strconv.ParseInt(strings.ReplaceAll(x.text, "_", ""), 10, 64)
+		if err != nil {
+			return nil, errorf(x.pos, ErrType, "integer %s overflows i64", x.text)
+		}
+		switch {
+		case want == types.TypeI64, want == types.TypeF32, want == types.TypeF64:
+			return want, nil
+		case v < math.MinInt32 || v > math.MaxInt32:
+			return types.TypeI64, nil
+		}
+		return types.TypeI32, nil
+	case tokFloat:
+		if want == types.TypeF32 {
+			return want, nil
+		}
+		return types.TypeF64, nil
+	case tokString:
+		return types.TypeString, nil
+	}
+	return types.TypeI1, nil
+}
+
+func (c *checker) binary(s *scope, x *binary) (types.Type, error) {
+	left, err := c.value(s, x.x, nil)
+	if err != nil {
+		return nil, err
+	}
+	right, err := c.value(s, x.y, left)
+	if err != nil {
+		return nil, err
+	}
+	if !identical(left, right) {
+		return nil, errorf(x.pos, ErrType, "mismatched types %s and %s", c.typeString(left), c.typeString(right))
+	}
+	ok := false
+	switch x.op {
+	case "+":
+		ok = numeric(left) || left == types.TypeString
+	case "-", "*", "/", "%":
+		ok = numeric(left)
+	case "&", "|", "^", "<<", ">>":
+		ok = integer(left)
+	case "==", "!=":
+		ok = numeric(left) || left == types.TypeString || left == types.TypeI1
+	case "<", "<=", ">", ">=":
+		ok = numeric(left) || left == types.TypeString
+	case "&&", "||":
+		ok = left == types.TypeI1
+	}
+	if !ok {
+		return nil, errorf(x.pos, ErrType, "operator %s not defined on %s", x.op, c.typeString(left))
+	}
+	if precedence[x.op] <= precedence["<="] {
+		return types.TypeI1, nil
+	}
+	return left, nil
+}
+
+func (c *checker) call(s *scope, x *call) (types.Type, error) {
+	if id, ok := x.fn.(*ident); ok && builtins[id.name] && c.lookup(s, id.name) == nil {
+		x.builtin = id.name
+		return c.builtin(s, x)
+	}
+	typ, err := c.value(s, x.fn, nil)
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := typ.(*types.FunctionType)
+	if !ok {
+		return nil, errorf(x.pos, ErrType, "cannot call %s", c.typeString(typ))
+	}
+	if len(x.args) != len(fn.Params) {
+		return nil, errorf(x.pos, ErrType, "want %d arguments, got %d", len(fn.Params), len(x.args))
+	}
+	for i, arg := range x.args {
+		if err := c.operand(s, arg, fn.Params[i]); err != nil {
+			return nil, err
+		}
+	}
+	if len(fn.Returns) == 0 {
+		return nil, nil
+	}
+	return fn.Returns[0], nil
+}
+
+// builtin checks a call to one of the intrinsic functions.
+func (c *checker) builtin(s *scope, x *call) (types.Type, error) {
+	arity := map[string]int{"code": 1, "delete": 2, "error": 2, "has": 2, "len": 1, "push": 2}[x.builtin]
+	if len(x.args) != arity {
+		return nil, errorf(x.pos, ErrType, "%s wants %d arguments, got %d", x.builtin, arity, len(x.args))
+	}
+	switch x.builtin {
+	case "error":
+		if err := c.operand(s, x.args[0], types.TypeI32); err != nil {
+			return nil, err
+		}
+		if err := c.operand(s, x.args[1], types.TypeString); err != nil {
+			return nil, err
+		}
+		return types.TypeError, nil
+	case "code":
+		if err := c.operand(s, x.args[0], types.TypeError); err != nil {
+			return nil, err
+		}
+		return types.TypeI32, nil
+	}
+
+	typ, err := c.value(s, x.args[0], nil)
+	if err != nil {
+		return nil, err
+	}
+	switch t := typ.(type) {
+	case *types.ArrayType:
+		switch x.builtin {
+		case "len":
+			return types.TypeI32, nil
+		case "push":
+			return nil, c.operand(s, x.args[1], t.Elem)
+		}
+	case *types.MapType:
+		switch x.builtin {
+		case "len":
+			return types.TypeI32, nil
+		case "has":
+			return types.TypeI1, c.operand(s, x.args[1], t.Key)
+		case "delete":
+			return nil, c.operand(s, x.args[1], t.Key)
+		}
+	default:
+		if typ == types.TypeString && x.builtin == "len" {
+			return types.TypeI32, nil
+		}
+	}
+	return nil, errorf(x.pos, ErrType, "invalid argument %s for %s", c.typeString(typ), x.builtin)
+}
+
+func (c *checker) structLit(s *scope, x *structLit) (types.Type, error) {
+	t, ok := c.structs[x.name]
+	if !ok {
+		return nil, errorf(x.pos, ErrType, "undefined struct %s", x.name)
+	}
+	vals := make([]expr, len(t.Fields))
+	for i, name := range x.fields {
+		idx := t.FieldIndex(name)
+		if idx < 0 {
+			return nil, errorf(position(x.vals[i]), ErrType, "%s has no field %s", x.name, name)
+		}
+		if vals[idx] != nil {
+			return nil, errorf(position(x.vals[i]), ErrType, "duplicate field %s", name)
+		}
+		vals[idx] = x.vals[i]
+	}
+	for i, v := range vals {
+		if v == nil {
+			return nil, errorf(x.pos, ErrType, "missing field %s in %s", t.Fields[i].Name, x.name)
+		}
+		if err := c.operand(s, v, t.Fields[i].Type); err != nil {
+			return nil, err
+		}
+	}
+	x.vals = vals
+	return t, nil
+}
+
+// operand checks x against the type its context requires.
+func (c *checker) operand(s *scope, x expr, want types.Type) error {
+	typ, err := c.value(s, x, want)
+	if err != nil {
+		return err
+	}
+	return c.assignable(position(x), typ, want)
+}
+
+func (c *checker) assignable(pos types.Position, got, want types.Type) error {
+	if !identical(got, want) {
+		return errorf(pos, ErrType, "cannot use %s as %s", c.typeString(got), c.typeString(want))
+	}
+	return nil
+}
+
+// resolve turns a type expression into a VM type.
+func (c *checker) resolve(t typeExpr) (types.Type, error) {
+	switch t := t.(type) {
+	case *typeName:
+		if typ, ok := primitives[t.name]; ok {
+			return typ, nil
+		}
+		if typ, ok := c.structs[t.name]; ok {
+			return typ, nil
+		}
+		return nil, errorf(t.pos, ErrType, "undefined type %s", t.name)
+	case *arrayType:
+		elem, err := c.resolve(t.elem)
+		if err != nil {
+			return nil, err
+		}
+		return types.NewArrayType(elem), nil
+	case *mapType:
+		key, err := c.resolve(t.key)
+		if err != nil {
+			return nil, err
+		}
+		if !numeric(key) && key != types.TypeI1 && key != types.TypeString {
+			return nil, errorf(t.pos, ErrType, "invalid map key type %s", c.typeString(key))
+		}
+		elem, err := c.resolve(t.elem)
+		if err != nil {
+			return nil, err
+		}
+		return types.NewMapType(key, elem), nil
+	case *funcType:
+		typ := &types.FunctionType{}
+		for _, p := range t.params {
+			pt, err := c.resolve(p)
+			if err != nil {
+				return nil, err
+			}
+			typ.Params = append(typ.Params, pt)
+		}
+		if t.result != nil {
+			rt, err := c.resolve(t.result)
+			if err != nil {
+				return nil, err
+			}
+			typ.Returns = []types.Type{rt}
+		}
+		return typ, nil
+	}
+	return nil, nil
+}
+
+// typeString spells t the way source code does.
+func (c *checker) typeString(t types.Type) string {
+	switch t := t.(type) {
+	case nil:
+		return "no value"
+	case *types.StructType:
+		return c.named[t]
+	case *types.ArrayType:
+		return "[" + c.typeString(t.Elem) + "]"
+	case *types.MapType:
+		return "map[" + c.typeString(t.Key) + "]" + c.typeString(t.Elem)
+	case *types.FunctionType:
+		params := make([]string, len(t.Params))
+		for i, p := range t.Params {
+			params[i] = c.typeString(p)
+		}
+		s := "fn(" + strings.Join(params, ", ") + ")"
+		if len(t.Returns) > 0 {
+			s += " -> " + c.typeString(t.Returns[0])
+		}
+		return s
+	}
+	for name, typ := range primitives {
+		if typ == t {
+			return name
+		}
+	}
+	return t.String()
+}
+
+// identical reports whether a and b are the same type. Structs are nominal:
+// each declaration is its own type however its fields line up.
+func identical(a, b types.Type) bool {
+	switch a := a.(type) {
+	case *types.StructType:
+		return a == b
+	case *types.ArrayType:
+		o, ok := b.(*types.ArrayType)
+		return ok && identical(a.Elem, o.Elem)
+	case *types.MapType:
+		o, ok := b.(*types.MapType)
+		return ok && identical(a.Key, o.Key) && identical(a.Elem, o.Elem)
+	case *types.FunctionType:
+		o, ok := b.(*types.FunctionType)
+		if !ok || len(a.Params) != len(o.Params) || len(a.Returns) != len(o.Returns) {
+			return false
+		}
+		for i := range a.Params {
+			if !identical(a.Params[i], o.Params[i]) {
+				return false
+			}
+		}
+		for i := range a.Returns {
+			if !identical(a.Returns[i], o.Returns[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a != nil && b != nil && a.Equals(b)
+}
+
+// terminates reports whether control cannot fall off the end of s.
+func terminates(s stmt) bool {
+	switch s := s.(type) {
+	case *returnStmt, *throwStmt:
+		return true
+	case *block:
+		return len(s.stmts) > 0 && terminates(s.stmts[len(s.stmts)-1])
+	case *ifStmt:
+		return s.els != nil && terminates(s.then) && terminates(s.els)
+	case *tryStmt:
+		return terminates(s.body) && terminates(s.catch)
+	}
+	return false
+}
+
+func numeric(t types.Type) bool {
+	return t == types.TypeI32 || t == types.TypeI64 || t == types.TypeF32 || t == types.TypeF64
+}
+
+func integer(t types.Type) bool {
+	return t == types.TypeI32 || t == types.TypeI64
+}
@@ -0,0 +1,77 @@
+// Package lang compiles a small typed expression and statement language to
+// verified programs, so rules can ship as source text instead of assembly.
+package lang
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
+)
+
+// Error reports the first problem found in a source file, located by its
+// position. Err wraps ErrSyntax or ErrType, or is the *program.VerifyError of
+// a generated program that failed verification. Prog is that program, set
+// only in the last case, so the caller can map the failure to a source
+// position through its debug info.
+type Error struct {
+	Pos  types.Position
+	Err  error
+	Prog *program.Program
+}
+
+// Ext is the file extension of source files.
+const Ext = ".mvl"
+
+var (
+	ErrSyntax = errors.New("syntax error")
+	ErrType   = errors.New("type error")
+)
+
+// Compile parses, type-checks, and compiles the source read from r into a
+// verified program. name is recorded as the file of every debug position.
+// When the generated program fails verification, the *Error it returns
+// carries the program in Prog.
+//
+// Top-level statements become the body of a function the program's code
+// calls, and the outermost top-level let bindings become globals. Each fn
+// declaration becomes a function constant, exported under its name when
+// marked export, and each import fn declaration becomes an import the host
+// binds with interp.WithImports. A trailing expression without a semicolon is
+// returned, leaving it alone on the operand stack as the result.
+func Compile(name string, r io.Reader) (*program.Program, error) {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	f, err := parse(name, string(src))
+	if err != nil {
+		return nil, err
+	}
+	c := newChecker()
+	if err := c.check(f); err != nil {
+		return nil, err
+	}
+	prog, err := newGenerator(c).generate(f)
+	if err != nil {
+		return nil, err
+	}
+	if err := program.Verify(prog); err != nil {
+		return nil, &Error{Pos: types.Position{File: name}, Err: err, Prog: prog}
+	}
+	return prog, nil
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v", e.Pos, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func errorf(pos types.Position, kind error, format string, args ...any) error {
+	return &Error{Pos: pos, Err: fmt.Errorf("%w: %s", kind, fmt.Sprintf(format, args...))}
+}
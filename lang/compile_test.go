@@ -0,0 +1,171 @@
+package lang_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/siyul-park/minivm/interp"
+	"github.com/siyul-park/minivm/lang"
+	"github.com/siyul-park/minivm/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   types.Value
+	}{
+		{name: "arithmetic", source: `1 + 2 * 3`, want: types.I32(7)},
+		{name: "cast", source: `let x = 3; let y: i64 = 4; (x as i64) * y`, want: types.I64(12)},
+		{name: "float", source: `1.5 * 2.0`, want: types.F64(3)},
+		{name: "string", source: `"mini" + "vm"`, want: types.String("minivm")},
+		{name: "logic", source: `"a" < "b" && !(1 == 2)`, want: types.I1(true)},
+		{
+			name:   "recursion",
+			source: "fn fib(n: i32) -> i32 {\n\tif n < 2 { return n; }\n\treturn fib(n - 1) + fib(n - 2);\n}\nfib(20)",
+			want:   types.I32(6765),
+		},
+		{
+			name:   "while",
+			source: `let i = 0; let n = 0; while true { i += 1; if i > 10 { break; } if i % 2 == 0 { continue; } n += i; } n`,
+			want:   types.I32(25),
+		},
+		{name: "for array", source: `let total = 0; for x in [1, 2, 3] { total += x; } total`, want: types.I32(6)},
+		{name: "for string", source: `let s = 0; for c in "abc" { s += c; } s`, want: types.I32(294)},
+		{
+			name:   "map",
+			source: `let m = map[string]i32{"a": 1, "b": 2}; let n = 0; for k in m { n += m[k]; } m["c"] = 5; delete(m, "a"); n * 10 + len(m)`,
+			want:   types.I32(32),
+		},
+		{
+			name:   "struct",
+			source: `struct P { x: i32, y: i32 } let p = P { y: 2, x: 1 }; p.x += 10; p.x * 100 + p.y`,
+			want:   types.I32(1102),
+		},
+		{
+			name:   "closure",
+			source: `fn make(k: i32) -> fn(i32) -> i32 { return fn(x: i32) -> i32 { return x + k; }; } let add = make(5); add(10)`,
+			want:   types.I32(15),
+		},
+		{name: "global in closure", source: `let k = 1; let f = fn() -> i32 { return k; }; k = 5; f()`, want: types.I32(5)},
+		{name: "push", source: `let a: [string] = []; push(a, "x"); push(a, "y"); a[0] + a[1]`, want: types.String("xy")},
+		{name: "throw", source: `let r = 0; try { throw error(7, "boom"); } catch e { r = code(e); } r`, want: types.I32(7)},
+		{name: "trap", source: `let r = 0; try { let a = [1]; r = a[5]; } catch e { r = -1; } r`, want: types.I32(-1)},
+		{
+			name:   "array before try",
+			source: `fn b() -> i32 { let arr = [1, 2, 3, 4]; try { } catch e { } return arr[0] + arr[1]; } b()`,
+			want:   types.I32(3),
+		},
+		{name: "nested for array", source: `let n = 0; for i in [1, 2] { for j in [1, 2] { n += j; } } n`, want: types.I32(6)},
+		{
+			name:   "closures capture loop variables",
+			source: `let fs: [fn() -> i32] = []; for x in [1, 2, 3] { push(fs, fn() -> i32 { return x; }); } fs[0]() * 100 + fs[1]() * 10 + fs[2]()`,
+			want:   types.I32(123),
+		},
+		{name: "for map", source: `let m = map[i32]i32{1: 10, 2: 20}; let n = 0; for k in m { n += k * m[k]; } n`, want: types.I32(50)},
+		{name: "for string break", source: `let n = 0; for c in "hello" { if c == 108 { break; } n += 1; } n`, want: types.I32(2)},
+		{
+			name:   "try in for array",
+			source: `let n = 0; for i in [1, 0, 2] { try { n += 10 / i; } catch e { n += 100; } } n`,
+			want:   types.I32(115),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prog, err := lang.Compile("test.mvl", strings.NewReader(tt.source))
+			require.NoError(t, err)
+
+			i := interp.New(prog)
+			defer i.Close()
+
+			require.NoError(t, i.Run(context.Background()))
+			require.Equal(t, 1, i.Len())
+			got, err := i.Pop()
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("imports and exports", func(t *testing.T) {
+		source := "import \"env.scale\" fn scale(x: i32) -> i32;\nexport fn rule(x: i32) -> i32 {\n\treturn scale(x) + 1;\n}\n"
+		prog, err := lang.Compile("rule.mvl", strings.NewReader(source))
+		require.NoError(t, err)
+
+		scale := interp.NewHostFunction(
+			&types.FunctionType{Params: []types.Type{types.TypeI32}, Returns: []types.Type{types.TypeI32}},
+			func(_ *interp.Interpreter, params []types.Boxed) ([]types.Boxed, error) {
+				return []types.Boxed{types.BoxI32(params[0].I32() * 10)}, nil
+			},
+		)
+		i := interp.New(prog, interp.WithImports(map[string]*interp.HostFunction{"env.scale": scale}))
+		defer i.Close()
+
+		require.NoError(t, i.Run(context.Background()))
+		got, err := i.Call(context.Background(), "rule", types.BoxI32(4))
+		require.NoError(t, err)
+		require.Equal(t, []types.Boxed{types.BoxI32(41)}, got)
+	})
+
+	t.Run("debug positions", func(t *testing.T) {
+		prog, err := lang.Compile("pos.mvl", strings.NewReader("fn f() -> i32 {\n\treturn 1;\n}\nf()"))
+		require.NoError(t, err)
+
+		fn, ok := prog.Constants[0].(*types.Function)
+		require.True(t, ok)
+		require.Equal(t, "f", fn.Debug.Name)
+		pos, ok := fn.Debug.Position(0)
+		require.True(t, ok)
+		require.Equal(t, types.Position{File: "pos.mvl", Line: 2, Column: 2}, pos)
+	})
+
+	errs := []struct {
+		name   string
+		source string
+		kind   error
+		msg    string
+	}{
+		{name: "unterminated string", source: `"abc`, kind: lang.ErrSyntax, msg: "test.mvl:1:1"},
+		{name: "missing semicolon", source: "let x = 1\nlet y = 2;", kind: lang.ErrSyntax, msg: "test.mvl:2:1"},
+		{name: "mismatched let", source: `let x: i32 = "a";`, kind: lang.ErrType, msg: "test.mvl:1:14"},
+		{name: "undefined", source: `y + 1`, kind: lang.ErrType, msg: "undefined: y"},
+		{name: "missing return", source: `fn f(x: i32) -> i32 { if x > 0 { return 1; } }`, kind: lang.ErrType, msg: "missing return"},
+		{name: "break outside loop", source: `break;`, kind: lang.ErrType, msg: "break"},
+		{name: "mismatched assignment", source: `let x = 1; x = "a";`, kind: lang.ErrType, msg: "cannot use string as i32"},
+		{name: "wrong arity", source: `fn f(a: i32) -> i32 { return a; } f(1, 2)`, kind: lang.ErrType, msg: "want 1 arguments, got 2"},
+		{name: "non-bool condition", source: `if 1 { }`, kind: lang.ErrType, msg: "non-bool i32 used as condition"},
+		{name: "undefined field", source: `struct P { x: i32 } let p = P { x: 1 }; p.y`, kind: lang.ErrType, msg: "has no field y"},
+		{
+			name:   "assign to captured loop variable",
+			source: `for x in [1] { let f = fn() -> i32 { x = 2; return x; }; }`,
+			kind:   lang.ErrType,
+			msg:    "cannot assign to captured variable x",
+		},
+		{name: "range over scalar", source: `for x in 1 { }`, kind: lang.ErrType, msg: "cannot range over i32"},
+	}
+
+	for _, tt := range errs {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := lang.Compile("test.mvl", strings.NewReader(tt.source))
+			require.ErrorIs(t, err, tt.kind)
+			require.ErrorContains(t, err, tt.msg)
+		})
+	}
+}
+
+func TestError_Error(t *testing.T) {
+	err := &lang.Error{Pos: types.Position{File: "a.mvl", Line: 3, Column: 7}, Err: lang.ErrSyntax}
+	require.Equal(t, "a.mvl:3:7: syntax error", err.Error())
+
+	err = &lang.Error{Pos: types.Position{File: "a.mvl"}, Err: lang.ErrType}
+	require.Equal(t, "a.mvl: type error", err.Error())
+}
+
+func TestError_Unwrap(t *testing.T) {
+	err := &lang.Error{Pos: types.Position{File: "a.mvl", Line: 1, Column: 1}, Err: lang.ErrType}
+	require.True(t, errors.Is(err, lang.ErrType))
+	require.Equal(t, lang.ErrType, err.Unwrap())
+}
@@ -0,0 +1,648 @@
+package lang
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
+)
+
+// generator lowers a checked file to bytecode, interning the constant and
+// type pools shared by every function it emits.
+type generator struct {
+	c         *checker
+	constants []types.Value
+	typs      []types.Type
+	err       error
+}
+
+// emitter writes one function body. It tracks the byte offset of the next
+// instruction so source positions can be recorded as debug lines while the
+// code is still symbolic.
+type emitter struct {
+	g     *generator
+	fn    *function
+	code  *instr.Builder
+	off   int
+	lines []types.Line
+	loops []loop
+}
+
+// loop holds the branch targets of break and continue in the innermost loop.
+type loop struct {
+	brk  instr.Label
+	cont instr.Label
+}
+
+// arith selects the opcode of each binary operator per operand type.
+var arith = map[types.Type]map[string]instr.Opcode{
+	types.TypeI32: {
+		"+": instr.I32_ADD, "-": instr.I32_SUB, "*": instr.I32_MUL, "/": instr.I32_DIV_S, "%": instr.I32_REM_S,
+		"&": instr.I32_AND, "|": instr.I32_OR, "^": instr.I32_XOR, "<<": instr.I32_SHL, ">>": instr.I32_SHR_S,
+		"==": instr.I32_EQ, "!=": instr.I32_NE, "<": instr.I32_LT_S, "<=": instr.I32_LE_S, ">": instr.I32_GT_S, ">=": instr.I32_GE_S,
+	},
+	types.TypeI64: {
+		"+": instr.I64_ADD, "-": instr.I64_SUB, "*": instr.I64_MUL, "/": instr.I64_DIV_S, "%": instr.I64_REM_S,
+		"&": instr.I64_AND, "|": instr.I64_OR, "^": instr.I64_XOR, "<<": instr.I64_SHL, ">>": instr.I64_SHR_S,
+		"==": instr.I64_EQ, "!=": instr.I64_NE, "<": instr.I64_LT_S, "<=": instr.I64_LE_S, ">": instr.I64_GT_S, ">=": instr.I64_GE_S,
+	},
+	types.TypeF32: {
+		"+": instr.F32_ADD, "-": instr.F32_SUB, "*": instr.F32_MUL, "/": instr.F32_DIV, "%": instr.F32_REM,
+		"==": instr.F32_EQ, "!=": instr.F32_NE, "<": instr.F32_LT, "<=": instr.F32_LE, ">": instr.F32_GT, ">=": instr.F32_GE,
+	},
+	types.TypeF64: {
+		"+": instr.F64_ADD, "-": instr.F64_SUB, "*": instr.F64_MUL, "/": instr.F64_DIV, "%": instr.F64_REM,
+		"==": instr.F64_EQ, "!=": instr.F64_NE, "<": instr.F64_LT, "<=": instr.F64_LE, ">": instr.F64_GT, ">=": instr.F64_GE,
+	},
+	types.TypeString: {
+		"+":  instr.STRING_CONCAT,
+		"==": instr.STRING_EQ, "!=": instr.STRING_NE, "<": instr.STRING_LT, "<=": instr.STRING_LE, ">": instr.STRING_GT, ">=": instr.STRING_GE,
+	},
+	types.TypeI1: {
+		"==": instr.I32_EQ, "!=": instr.I32_NE,
+	},
+}
+
+// conversions selects the opcode converting between two numeric types.
+var conversions = map[[2]types.Type]instr.Opcode{
+	{types.TypeI32, types.TypeI64}: instr.I32_TO_I64_S,
+	{types.TypeI32, types.TypeF32}: instr.I32_TO_F32_S,
+	{types.TypeI32, types.TypeF64}: instr.I32_TO_F64_S,
+	{types.TypeI64, types.TypeI32}: instr.I64_TO_I32,
+	{types.TypeI64, types.TypeF32}: instr.I64_TO_F32_S,
+	{types.TypeI64, types.TypeF64}: instr.I64_TO_F64_S,
+	{types.TypeF32, types.TypeI32}: instr.F32_TO_I32_S,
+	{types.TypeF32, types.TypeI64}: instr.F32_TO_I64_S,
+	{types.TypeF32, types.TypeF64}: instr.F32_TO_F64,
+	{types.TypeF64, types.TypeI32}: instr.F64_TO_I32_S,
+	{types.TypeF64, types.TypeI64}: instr.F64_TO_I64_S,
+	{types.TypeF64, types.TypeF32}: instr.F64_TO_F32,
+}
+
+func newGenerator(c *checker) *generator {
+	return &generator{c: c}
+}
+
+func (g *generator) generate(f *file) (*program.Program, error) {
+	c := g.c
+	g.constants = make([]types.Value, len(c.imports)+len(c.funcs))
+
+	var imports []program.Import
+	for i, d := range c.imports {
+		typ := c.module.names[d.name].typ.(*types.FunctionType)
+		g.constants[i] = program.NewDeclaration(typ)
+		imports = append(imports, program.Import{Name: d.symbol, Typ: typ, Const: i})
+	}
+
+	var exports []program.Export
+	for _, d := range f.decls {
+		d, ok := d.(*funcDecl)
+		if !ok {
+			continue
+		}
+		fn, err := g.function(d.fn, d.body)
+		if err != nil {
+			return nil, err
+		}
+		g.constants[d.fn.index] = fn
+		if d.export {
+			exports = append(exports, program.Export{Name: d.name, Kind: program.ExportFunction, Index: d.fn.index})
+		}
+	}
+
+	main := &block{stmts: f.stmts}
+	if f.result != nil {
+		main.stmts = append(main.stmts, &returnStmt{pos: position(f.result), x: f.result})
+	}
+	c.top.index = len(g.constants)
+	g.constants = append(g.constants, nil)
+	fn, err := g.function(c.top, main)
+	if err != nil {
+		return nil, err
+	}
+	g.constants[c.top.index] = fn
+	if g.err != nil {
+		return nil, g.err
+	}
+
+	globals := make([]types.Type, len(c.globals))
+	names := make([]string, len(c.globals))
+	for i, b := range c.globals {
+		globals[i], names[i] = b.typ, b.name
+	}
+	code := []instr.Instruction{
+		instr.New(instr.CONST_GET, uint64(c.top.index)),
+		instr.New(instr.CALL),
+	}
+	return program.New(code,
+		program.WithGlobals(globals...),
+		program.WithConstants(g.constants...),
+		program.WithTypes(g.typs...),
+		program.WithExports(exports...),
+		program.WithImports(imports...),
+		program.WithDebug(&program.Debug{Globals: names}),
+	), nil
+}
+
+// function emits a function body as a constant. Control reaching the end of a
+// function without a result returns; one with a result has been proven to
+// return on every path, so its end is unreachable.
+func (g *generator) function(fn *function, body *block) (*types.Function, error) {
+	e := g.emitter(fn)
+	e.stmt(body)
+	if len(fn.typ.Returns) == 0 {
+		e.emit(instr.RETURN)
+	} else {
+		e.emit(instr.UNREACHABLE)
+	}
+	code, err := e.code.Assemble()
+	if err != nil {
+		return nil, err
+	}
+	captures := make([]types.Type, len(fn.captures))
+	for i, b := range fn.captures {
+		captures[i] = b.typ
+	}
+	params := len(fn.typ.Params)
+	return &types.Function{
+		Typ:      fn.typ,
+		Locals:   fn.slots[params:],
+		Captures: captures,
+		Code:     instr.Marshal(code),
+		Handlers: e.code.Handlers(),
+		Debug:    e.debug(),
+	}, nil
+}
+
+func (g *generator) emitter(fn *function) *emitter {
+	return &emitter{g: g, fn: fn, code: instr.NewBuilder()}
+}
+
+// constant interns a literal value into the constant pool.
+func (g *generator) constant(v types.Value) int {
+	for i, c := range g.constants {
+		if c == v {
+			return i
+		}
+	}
+	g.constants = append(g.constants, v)
+	return len(g.constants) - 1
+}
+
+// typ interns t into the type pool. Struct types are matched by identity so
+// two declarations with the same shape keep their own field names.
+func (g *generator) typ(t types.Type) int {
+	for i, typ := range g.typs {
+		if identical(typ, t) {
+			return i
+		}
+	}
+	g.typs = append(g.typs, t)
+	return len(g.typs) - 1
+}
+
+func (e *emitter) stmt(s stmt) {
+	switch s := s.(type) {
+	case *block:
+		for _, st := range s.stmts {
+			e.stmt(st)
+		}
+	case *letStmt:
+		e.mark(s.pos)
+		e.expr(s.init)
+		e.store(s.to)
+	case *assignStmt:
+		e.mark(s.pos)
+		e.assign(s)
+	case *exprStmt:
+		e.mark(s.pos)
+		e.expr(s.x)
+		if e.g.c.types[s.x] != nil {
+			e.emit(instr.DROP)
+		}
+	case *ifStmt:
+		e.mark(s.pos)
+		els, end := e.code.Label(), e.code.Label()
+		e.expr(s.cond)
+		e.emit(instr.I32_EQZ)
+		e.brIf(els)
+		e.stmt(s.then)
+		if s.els != nil {
+			e.br(end)
+		}
+		e.code.Bind(els)
+		if s.els != nil {
+			e.stmt(s.els)
+		}
+		e.code.Bind(end)
+	case *whileStmt:
+		top, end := e.code.Label(), e.code.Label()
+		e.code.Bind(top)
+		e.mark(s.pos)
+		e.expr(s.cond)
+		e.emit(instr.I32_EQZ)
+		e.brIf(end)
+		e.loop(loop{brk: end, cont: top}, s.body)
+		e.br(top)
+		e.code.Bind(end)
+	case *forStmt:
+		e.forStmt(s)
+	case *branchStmt:
+		e.mark(s.pos)
+		l := e.loops[len(e.loops)-1]
+		if s.tok == "break" {
+			e.br(l.brk)
+		} else {
+			e.br(l.cont)
+		}
+	case *returnStmt:
+		e.mark(s.pos)
+		if s.x != nil {
+			e.expr(s.x)
+		}
+		e.emit(instr.RETURN)
+	case *throwStmt:
+		e.mark(s.pos)
+		e.expr(s.x)
+		e.emit(instr.THROW)
+	case *tryStmt:
+		e.tryStmt(s)
+	}
+}
+
+func (e *emitter) assign(s *assignStmt) {
+	c := e.g.c
+	switch target := s.target.(type) {
+	case *ident:
+		if s.op != "" {
+			e.load(target.to)
+		}
+		e.expr(s.value)
+		if s.op != "" {
+			e.binop(s.op, c.types[target])
+		}
+		e.store(target.to)
+	case *index:
+		set, get := instr.ARRAY_SET, instr.ARRAY_GET
+		if _, ok := c.types[target.x].(*types.MapType); ok {
+			set, get = instr.MAP_SET, instr.MAP_GET
+		}
+		if s.op == "" {
+			e.expr(target.x)
+			e.expr(target.key)
+			e.expr(s.value)
+			e.emit(set)
+			return
+		}
+		e.expr(target.x)
+		e.emit(instr.LOCAL_SET, uint64(s.temps[0]))
+		e.expr(target.key)
+		e.emit(instr.LOCAL_SET, uint64(s.temps[1]))
+		for range 2 {
+			e.emit(instr.LOCAL_GET, uint64(s.temps[0]))
+			e.emit(instr.LOCAL_GET, uint64(s.temps[1]))
+		}
+		e.emit(get)
+		e.expr(s.value)
+		e.binop(s.op, c.types[target])
+		e.emit(set)
+	case *selector:
+		if s.op == "" {
+			e.expr(target.x)
+			e.emit(instr.I32_CONST, uint64(target.field))
+			e.expr(s.value)
+			e.emit(instr.STRUCT_SET)
+			return
+		}
+		e.expr(target.x)
+		e.emit(instr.LOCAL_SET, uint64(s.temps[0]))
+		for range 2 {
+			e.emit(instr.LOCAL_GET, uint64(s.temps[0]))
+			e.emit(instr.I32_CONST, uint64(target.field))
+		}
+		e.emit(instr.STRUCT_GET)
+		e.expr(s.value)
+		e.binop(s.op, c.types[target])
+		e.emit(instr.STRUCT_SET)
+	}
+}
+
+// forStmt walks an array by index. A map is walked through a snapshot of its
+// keys and a string through its code points.
+func (e *emitter) forStmt(s *forStmt) {
+	e.mark(s.pos)
+	e.expr(s.x)
+	switch typ := e.g.c.types[s.x]; typ.(type) {
+	case *types.MapType:
+		e.emit(instr.MAP_KEYS)
+	case *types.ArrayType:
+	default:
+		e.emit(instr.STRING_ENCODE_UTF32)
+	}
+	e.emit(instr.LOCAL_SET, uint64(s.seq))
+	e.emit(instr.I32_CONST, 0)
+	e.emit(instr.LOCAL_SET, uint64(s.idx))
+
+	top, next, end := e.code.Label(), e.code.Label(), e.code.Label()
+	e.code.Bind(top)
+	e.emit(instr.LOCAL_GET, uint64(s.idx))
+	e.emit(instr.LOCAL_GET, uint64(s.seq))
+	e.emit(instr.ARRAY_LEN)
+	e.emit(instr.I32_GE_S)
+	e.brIf(end)
+	e.emit(instr.LOCAL_GET, uint64(s.seq))
+	e.emit(instr.LOCAL_GET, uint64(s.idx))
+	e.emit(instr.ARRAY_GET)
+	e.emit(instr.LOCAL_SET, uint64(s.to.index))
+	e.loop(loop{brk: end, cont: next}, s.body)
+	e.code.Bind(next)
+	e.emit(instr.LOCAL_GET, uint64(s.idx))
+	e.emit(instr.I32_CONST, 1)
+	e.emit(instr.I32_ADD)
+	e.emit(instr.LOCAL_SET, uint64(s.idx))
+	e.br(top)
+	e.code.Bind(end)
+}
+
+// tryStmt protects the body with a handler that stores the caught error and
+// runs the catch block. Statements leave no operands behind, so the region's
+// entry depth is the frame's slot count.
+func (e *emitter) tryStmt(s *tryStmt) {
+	e.mark(s.pos)
+	start, end, catch, done := e.code.Label(), e.code.Label(), e.code.Label(), e.code.Label()
+	e.code.Bind(start)
+	off := e.off
+	e.stmt(s.body)
+	if e.off == off {
+		e.emit(instr.NOP)
+	}
+	e.code.Bind(end)
+	e.br(done)
+	e.code.Bind(catch)
+	e.emit(instr.LOCAL_SET, uint64(s.to.index))
+	e.stmt(s.catch)
+	e.code.Bind(done)
+	e.code.Try(start, end, catch, len(e.fn.slots))
+}
+
+func (e *emitter) loop(l loop, body *block) {
+	e.loops = append(e.loops, l)
+	e.stmt(body)
+	e.loops = e.loops[:len(e.loops)-1]
+}
+
+func (e *emitter) expr(x expr) {
+	c := e.g.c
+	switch x := x.(type) {
+	case *ident:
+		e.load(x.to)
+	case *literal:
+		e.literal(x, c.types[x])
+	case *unary:
+		typ := c.types[x]
+		switch {
+		case x.op == "!":
+			e.expr(x.x)
+			e.emit(instr.I32_EQZ)
+		case typ == types.TypeI32:
+			e.emit(instr.I32_CONST, 0)
+			e.expr(x.x)
+			e.emit(instr.I32_SUB)
+		case typ == types.TypeI64:
+			e.emit(instr.I64_CONST, 0)
+			e.expr(x.x)
+			e.emit(instr.I64_SUB)
+		case typ == types.TypeF32:
+			e.expr(x.x)
+			e.emit(instr.F32_NEG)
+		default:
+			e.expr(x.x)
+			e.emit(instr.F64_NEG)
+		}
+	case *binary:
+		e.binary(x)
+	case *cast:
+		e.expr(x.x)
+		if op, ok := conversions[[2]types.Type{c.types[x.x], c.types[x]}]; ok {
+			e.emit(op)
+		}
+	case *call:
+		e.call(x)
+	case *index:
+		e.expr(x.x)
+		e.expr(x.key)
+		if _, ok := c.types[x.x].(*types.MapType); ok {
+			e.emit(instr.MAP_GET)
+		} else {
+			e.emit(instr.ARRAY_GET)
+		}
+	case *selector:
+		e.expr(x.x)
+		e.emit(instr.I32_CONST, uint64(x.field))
+		e.emit(instr.STRUCT_GET)
+	case *arrayLit:
+		for _, elem := range x.elems {
+			e.expr(elem)
+		}
+		e.emit(instr.I32_CONST, uint64(len(x.elems)))
+		if len(x.elems) == 0 {
+			e.emit(instr.ARRAY_NEW_DEFAULT, uint64(e.g.typ(c.types[x])))
+		} else {
+			e.emit(instr.ARRAY_NEW, uint64(e.g.typ(c.types[x])))
+		}
+	case *mapLit:
+		for i := range x.keys {
+			e.expr(x.keys[i])
+			e.expr(x.vals[i])
+		}
+		e.emit(instr.I32_CONST, uint64(len(x.keys)))
+		e.emit(instr.MAP_NEW, uint64(e.g.typ(c.types[x])))
+	case *structLit:
+		for _, v := range x.vals {
+			e.expr(v)
+		}
+		e.emit(instr.STRUCT_NEW, uint64(e.g.typ(c.types[x])))
+	case *funcLit:
+		e.closure(x)
+	}
+}
+
+func (e *emitter) literal(x *literal, typ types.Type) {
+	switch x.kind {
+	case tokString:
+		e.emit(instr.CONST_GET, uint64(e.g.constant(types.String(x.text))))
+		return
+	case tokKeyword:
+		e.emit(instr.CONST_GET, uint64(e.g.constant(types.I1(x.text == "true"))))
+		return
+	}
+	text := strings.ReplaceAll(x.text, "_", "")
+	switch typ {
+	case types.TypeI32:
+		v, _ := strconv.ParseInt(text, 10, 32)
+		e.emit(instr.I32_CONST, uint64(uint32(int32(v))))
+	case types.TypeI64:
+		v, _ := strconv.ParseInt(text, 10, 64)
+		e.emit(instr.I64_CONST, uint64(v))
+	case types.TypeF32:
+		v, _ := strconv.ParseFloat(text, 32)
+		e.emit(instr.F32_CONST, uint64(math.Float32bits(float32(v))))
+	default:
+		v, _ := strconv.ParseFloat(text, 64)
+		e.emit(instr.F64_CONST, math.Float64bits(v))
+	}
+}
+
+// binary evaluates both operands, except that && and || skip the right one
+// once the left decides the result.
+func (e *emitter) binary(x *binary) {
+	if x.op == "&&" || x.op == "||" {
+		end := e.code.Label()
+		e.expr(x.x)
+		e.emit(instr.DUP)
+		if x.op == "&&" {
+			e.emit(instr.I32_EQZ)
+		}
+		e.brIf(end)
+		e.emit(instr.DROP)
+		e.expr(x.y)
+		e.code.Bind(end)
+		return
+	}
+	e.expr(x.x)
+	e.expr(x.y)
+	e.binop(x.op, e.g.c.types[x.x])
+}
+
+func (e *emitter) binop(op string, typ types.Type) {
+	e.emit(arith[typ][op])
+}
+
+func (e *emitter) call(x *call) {
+	e.mark(x.pos)
+	switch x.builtin {
+	case "":
+		for _, arg := range x.args {
+			e.expr(arg)
+		}
+		e.expr(x.fn)
+		e.emit(instr.CALL)
+		return
+	case "error":
+		e.expr(x.args[0])
+		e.expr(x.args[1])
+		e.emit(instr.SWAP)
+		e.emit(instr.ERROR_NEW)
+		return
+	case "code":
+		e.expr(x.args[0])
+		e.emit(instr.ERROR_CODE)
+		return
+	}
+
+	for _, arg := range x.args {
+		e.expr(arg)
+	}
+	switch typ := e.g.c.types[x.args[0]]; typ.(type) {
+	case *types.ArrayType:
+		switch x.builtin {
+		case "len":
+			e.emit(instr.ARRAY_LEN)
+		case "push":
+			e.emit(instr.I32_CONST, 1)
+			e.emit(instr.ARRAY_APPEND)
+			e.emit(instr.DROP)
+		}
+	case *types.MapType:
+		switch x.builtin {
+		case "len":
+			e.emit(instr.MAP_LEN)
+		case "has":
+			e.emit(instr.MAP_LOOKUP)
+			e.emit(instr.SWAP)
+			e.emit(instr.DROP)
+		case "delete":
+			e.emit(instr.MAP_DELETE)
+		}
+	default:
+		e.emit(instr.STRING_LEN)
+	}
+}
+
+// closure pushes a function literal. One that captures nothing is a plain
+// function constant; otherwise its captured values are copied into a closure.
+func (e *emitter) closure(x *funcLit) {
+	fn, err := e.g.function(x.fn, x.body)
+	if err != nil {
+		e.g.err = err
+		return
+	}
+	x.fn.index = len(e.g.constants)
+	e.g.constants = append(e.g.constants, fn)
+	for _, b := range x.fn.captures {
+		e.load(b.from)
+	}
+	e.emit(instr.CONST_GET, uint64(x.fn.index))
+	if len(x.fn.captures) > 0 {
+		e.emit(instr.CLOSURE_NEW)
+	}
+}
+
+func (e *emitter) load(b *binding) {
+	switch b.place {
+	case placeLocal:
+		e.emit(instr.LOCAL_GET, uint64(b.index))
+	case placeGlobal:
+		e.emit(instr.GLOBAL_GET, uint64(b.index))
+	case placeUpval:
+		e.emit(instr.UPVAL_GET, uint64(b.index))
+	case placeConst:
+		e.emit(instr.CONST_GET, uint64(b.index))
+	}
+}
+
+func (e *emitter) store(b *binding) {
+	if b.place == placeGlobal {
+		e.emit(instr.GLOBAL_SET, uint64(b.index))
+		return
+	}
+	e.emit(instr.LOCAL_SET, uint64(b.index))
+}
+
+func (e *emitter) emit(op instr.Opcode, operands ...uint64) {
+	inst := instr.New(op, operands...)
+	e.code.Append(inst)
+	e.off += inst.Width()
+}
+
+func (e *emitter) br(l instr.Label) {
+	e.code.Br(l)
+	e.off += instr.New(instr.BR, 0).Width()
+}
+
+func (e *emitter) brIf(l instr.Label) {
+	e.code.BrIf(l)
+	e.off += instr.New(instr.BR_IF, 0).Width()
+}
+
+// mark starts a debug line at the next instruction. A later mark at the same
+// offset replaces an earlier one, and a repeated position is skipped.
+func (e *emitter) mark(pos types.Position) {
+	if n := len(e.lines); n > 0 {
+		if e.lines[n-1].IP == e.off {
+			e.lines[n-1].Pos = pos
+			return
+		}
+		if e.lines[n-1].Pos == pos {
+			return
+		}
+	}
+	e.lines = append(e.lines, types.Line{IP: e.off, Pos: pos})
+}
+
+func (e *emitter) debug() *types.Debug {
+	return &types.Debug{Name: e.fn.name, Locals: e.fn.names, Lines: e.lines}
+}
@@ -0,0 +1,690 @@
+package lang
+
+import (
+	"github.com/siyul-park/minivm/types"
+)
+
+// parser is a recursive-descent parser over a scanned token stream.
+type parser struct {
+	toks []token
+	pos  int
+}
+
+// precedence ranks the binary operators; higher binds tighter.
+var precedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3,
+	"<": 4, "<=": 4, ">": 4, ">=": 4,
+	"|":  5,
+	"^":  6,
+	"&":  7,
+	"<<": 8, ">>": 8,
+	"+": 9, "-": 9,
+	"*": 10, "/": 10, "%": 10,
+}
+
+// compounds maps each compound assignment operator to its binary operator.
+var compounds = map[string]string{
+	"+=": "+", "-=": "-", "*=": "*", "/=": "/", "%=": "%",
+	"&=": "&", "|=": "|", "^=": "^", "<<=": "<<", ">>=": ">>",
+}
+
+func parse(name, src string) (*file, error) {
+	toks, err := newScanner(name, src).scan()
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	return p.file()
+}
+
+func (p *parser) file() (*file, error) {
+	f := &file{}
+	for !p.at(tokEOF, "") {
+		switch {
+		case p.at(tokKeyword, "fn") && p.peek(1).kind == tokIdent, p.at(tokKeyword, "export"):
+			d, err := p.funcDecl()
+			if err != nil {
+				return nil, err
+			}
+			f.decls = append(f.decls, d)
+		case p.at(tokKeyword, "import"):
+			d, err := p.importDecl()
+			if err != nil {
+				return nil, err
+			}
+			f.decls = append(f.decls, d)
+		case p.at(tokKeyword, "struct"):
+			d, err := p.structDecl()
+			if err != nil {
+				return nil, err
+			}
+			f.decls = append(f.decls, d)
+		default:
+			s, result, err := p.stmt(true)
+			if err != nil {
+				return nil, err
+			}
+			if result != nil {
+				if !p.at(tokEOF, "") {
+					return nil, p.unexpected()
+				}
+				f.result = result
+				break
+			}
+			f.stmts = append(f.stmts, s)
+		}
+	}
+	return f, nil
+}
+
+func (p *parser) funcDecl() (*funcDecl, error) {
+	d := &funcDecl{pos: p.tok().pos}
+	if p.accept(tokKeyword, "export") {
+		d.export = true
+	}
+	if _, err := p.expect(tokKeyword, "fn"); err != nil {
+		return nil, err
+	}
+	name, err := p.expect(tokIdent, "")
+	if err != nil {
+		return nil, err
+	}
+	d.name = name.text
+	if d.sig, err = p.signature(); err != nil {
+		return nil, err
+	}
+	if d.body, err = p.block(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (p *parser) importDecl() (*importDecl, error) {
+	d := &importDecl{pos: p.next().pos}
+	if p.at(tokString, "") {
+		d.symbol = p.next().text
+	}
+	if _, err := p.expect(tokKeyword, "fn"); err != nil {
+		return nil, err
+	}
+	name, err := p.expect(tokIdent, "")
+	if err != nil {
+		return nil, err
+	}
+	d.name = name.text
+	if d.symbol == "" {
+		d.symbol = d.name
+	}
+	if d.sig, err = p.signature(); err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokPunct, ";"); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (p *parser) structDecl() (*structDecl, error) {
+	d := &structDecl{pos: p.next().pos}
+	name, err := p.expect(tokIdent, "")
+	if err != nil {
+		return nil, err
+	}
+	d.name = name.text
+	if _, err := p.expect(tokPunct, "{"); err != nil {
+		return nil, err
+	}
+	for !p.accept(tokPunct, "}") {
+		f, err := p.param()
+		if err != nil {
+			return nil, err
+		}
+		d.fields = append(d.fields, f)
+		if !p.at(tokPunct, "}") {
+			if _, err := p.expect(tokPunct, ","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return d, nil
+}
+
+func (p *parser) signature() (signature, error) {
+	var sig signature
+	if _, err := p.expect(tokPunct, "("); err != nil {
+		return sig, err
+	}
+	for !p.accept(tokPunct, ")") {
+		prm, err := p.param()
+		if err != nil {
+			return sig, err
+		}
+		sig.params = append(sig.params, prm)
+		if !p.at(tokPunct, ")") {
+			if _, err := p.expect(tokPunct, ","); err != nil {
+				return sig, err
+			}
+		}
+	}
+	if p.accept(tokPunct, "->") {
+		t, err := p.typ()
+		if err != nil {
+			return sig, err
+		}
+		sig.result = t
+	}
+	return sig, nil
+}
+
+func (p *parser) param() (param, error) {
+	name, err := p.expect(tokIdent, "")
+	if err != nil {
+		return param{}, err
+	}
+	if _, err := p.expect(tokPunct, ":"); err != nil {
+		return param{}, err
+	}
+	t, err := p.typ()
+	if err != nil {
+		return param{}, err
+	}
+	return param{pos: name.pos, name: name.text, typ: t}, nil
+}
+
+func (p *parser) typ() (typeExpr, error) {
+	tok := p.tok()
+	switch {
+	case tok.kind == tokIdent:
+		p.next()
+		return &typeName{pos: tok.pos, name: tok.text}, nil
+	case p.accept(tokPunct, "["):
+		elem, err := p.typ()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokPunct, "]"); err != nil {
+			return nil, err
+		}
+		return &arrayType{pos: tok.pos, elem: elem}, nil
+	case p.at(tokKeyword, "map"):
+		return p.mapType()
+	case p.accept(tokKeyword, "fn"):
+		t := &funcType{pos: tok.pos}
+		if _, err := p.expect(tokPunct, "("); err != nil {
+			return nil, err
+		}
+		for !p.accept(tokPunct, ")") {
+			prm, err := p.typ()
+			if err != nil {
+				return nil, err
+			}
+			t.params = append(t.params, prm)
+			if !p.at(tokPunct, ")") {
+				if _, err := p.expect(tokPunct, ","); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if p.accept(tokPunct, "->") {
+			r, err := p.typ()
+			if err != nil {
+				return nil, err
+			}
+			t.result = r
+		}
+		return t, nil
+	}
+	return nil, p.unexpected()
+}
+
+func (p *parser) mapType() (*mapType, error) {
+	t := &mapType{pos: p.next().pos}
+	if _, err := p.expect(tokPunct, "["); err != nil {
+		return nil, err
+	}
+	key, err := p.typ()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokPunct, "]"); err != nil {
+		return nil, err
+	}
+	elem, err := p.typ()
+	if err != nil {
+		return nil, err
+	}
+	t.key, t.elem = key, elem
+	return t, nil
+}
+
+func (p *parser) block() (*block, error) {
+	open, err := p.expect(tokPunct, "{")
+	if err != nil {
+		return nil, err
+	}
+	b := &block{pos: open.pos}
+	for !p.accept(tokPunct, "}") {
+		if p.at(tokEOF, "") {
+			return nil, p.unexpected()
+		}
+		s, _, err := p.stmt(false)
+		if err != nil {
+			return nil, err
+		}
+		b.stmts = append(b.stmts, s)
+	}
+	return b, nil
+}
+
+// stmt parses one statement. At the top level an expression statement may
+// omit its semicolon when it ends the file; it is then returned as result.
+func (p *parser) stmt(top bool) (stmt, expr, error) {
+	tok := p.tok()
+	switch {
+	case p.at(tokPunct, "{"):
+		b, err := p.block()
+		return b, nil, err
+	case p.accept(tokKeyword, "let"):
+		s := &letStmt{pos: tok.pos}
+		name, err := p.expect(tokIdent, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		s.name = name.text
+		if p.accept(tokPunct, ":") {
+			if s.typ, err = p.typ(); err != nil {
+				return nil, nil, err
+			}
+		}
+		if _, err := p.expect(tokPunct, "="); err != nil {
+			return nil, nil, err
+		}
+		if s.init, err = p.expr(false); err != nil {
+			return nil, nil, err
+		}
+		return s, nil, p.semi()
+	case p.accept(tokKeyword, "if"):
+		s, err := p.ifStmt(tok.pos)
+		return s, nil, err
+	case p.accept(tokKeyword, "while"):
+		cond, err := p.expr(true)
+		if err != nil {
+			return nil, nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, nil, err
+		}
+		return &whileStmt{pos: tok.pos, cond: cond, body: body}, nil, nil
+	case p.accept(tokKeyword, "for"):
+		name, err := p.expect(tokIdent, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := p.expect(tokKeyword, "in"); err != nil {
+			return nil, nil, err
+		}
+		x, err := p.expr(true)
+		if err != nil {
+			return nil, nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, nil, err
+		}
+		return &forStmt{pos: tok.pos, name: name.text, x: x, body: body}, nil, nil
+	case p.accept(tokKeyword, "break"), p.accept(tokKeyword, "continue"):
+		return &branchStmt{pos: tok.pos, tok: tok.text}, nil, p.semi()
+	case p.accept(tokKeyword, "return"):
+		s := &returnStmt{pos: tok.pos}
+		if !p.at(tokPunct, ";") {
+			x, err := p.expr(false)
+			if err != nil {
+				return nil, nil, err
+			}
+			s.x = x
+		}
+		return s, nil, p.semi()
+	case p.accept(tokKeyword, "throw"):
+		x, err := p.expr(false)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &throwStmt{pos: tok.pos, x: x}, nil, p.semi()
+	case p.accept(tokKeyword, "try"):
+		body, err := p.block()
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := p.expect(tokKeyword, "catch"); err != nil {
+			return nil, nil, err
+		}
+		name, err := p.expect(tokIdent, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		catch, err := p.block()
+		if err != nil {
+			return nil, nil, err
+		}
+		return &tryStmt{pos: tok.pos, body: body, name: name.text, catch: catch}, nil, nil
+	}
+
+	x, err := p.expr(false)
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.accept(tokPunct, "=") {
+		value, err := p.expr(false)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &assignStmt{pos: tok.pos, target: x, value: value}, nil, p.semi()
+	}
+	if op, ok := compounds[p.tok().text]; ok && p.tok().kind == tokPunct {
+		p.next()
+		value, err := p.expr(false)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &assignStmt{pos: tok.pos, target: x, op: op, value: value}, nil, p.semi()
+	}
+	if top && p.at(tokEOF, "") {
+		return nil, x, nil
+	}
+	return &exprStmt{pos: tok.pos, x: x}, nil, p.semi()
+}
+
+func (p *parser) ifStmt(pos types.Position) (*ifStmt, error) {
+	cond, err := p.expr(true)
+	if err != nil {
+		return nil, err
+	}
+	then, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	s := &ifStmt{pos: pos, cond: cond, then: then}
+	if !p.accept(tokKeyword, "else") {
+		return s, nil
+	}
+	if tok := p.tok(); p.accept(tokKeyword, "if") {
+		s.els, err = p.ifStmt(tok.pos)
+	} else {
+		s.els, err = p.block()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// expr parses an expression. bare is set in statement headers, where a `{`
+// opens the body rather than a struct literal.
+func (p *parser) expr(bare bool) (expr, error) {
+	return p.binary(1, bare)
+}
+
+func (p *parser) binary(min int, bare bool) (expr, error) {
+	x, err := p.unary(bare)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.tok()
+		prec, ok := precedence[tok.text]
+		if tok.kind != tokPunct || !ok || prec < min {
+			return x, nil
+		}
+		p.next()
+		y, err := p.binary(prec+1, bare)
+		if err != nil {
+			return nil, err
+		}
+		x = &binary{pos: tok.pos, op: tok.text, x: x, y: y}
+	}
+}
+
+func (p *parser) unary(bare bool) (expr, error) {
+	tok := p.tok()
+	if tok.kind == tokPunct && (tok.text == "-" || tok.text == "!") {
+		p.next()
+		x, err := p.unary(bare)
+		if err != nil {
+			return nil, err
+		}
+		return &unary{pos: tok.pos, op: tok.text, x: x}, nil
+	}
+	x, err := p.postfix(bare)
+	if err != nil {
+		return nil, err
+	}
+	for p.at(tokKeyword, "as") {
+		pos := p.next().pos
+		to, err := p.typ()
+		if err != nil {
+			return nil, err
+		}
+		x = &cast{pos: pos, x: x, to: to}
+	}
+	return x, nil
+}
+
+func (p *parser) postfix(bare bool) (expr, error) {
+	x, err := p.primary(bare)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.tok()
+		switch {
+		case p.accept(tokPunct, "("):
+			c := &call{pos: tok.pos, fn: x}
+			for !p.accept(tokPunct, ")") {
+				arg, err := p.expr(false)
+				if err != nil {
+					return nil, err
+				}
+				c.args = append(c.args, arg)
+				if !p.at(tokPunct, ")") {
+					if _, err := p.expect(tokPunct, ","); err != nil {
+						return nil, err
+					}
+				}
+			}
+			x = c
+		case p.accept(tokPunct, "["):
+			key, err := p.expr(false)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect(tokPunct, "]"); err != nil {
+				return nil, err
+			}
+			x = &index{pos: tok.pos, x: x, key: key}
+		case p.accept(tokPunct, "."):
+			name, err := p.expect(tokIdent, "")
+			if err != nil {
+				return nil, err
+			}
+			x = &selector{pos: name.pos, x: x, name: name.text}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *parser) primary(bare bool) (expr, error) {
+	tok := p.tok()
+	switch {
+	case tok.kind == tokInt, tok.kind == tokFloat, tok.kind == tokString:
+		p.next()
+		return &literal{pos: tok.pos, kind: tok.kind, text: tok.text}, nil
+	case p.at(tokKeyword, "true"), p.at(tokKeyword, "false"):
+		p.next()
+		return &literal{pos: tok.pos, kind: tokKeyword, text: tok.text}, nil
+	case tok.kind == tokIdent:
+		p.next()
+		if !bare && p.at(tokPunct, "{") {
+			return p.structLit(tok)
+		}
+		return &ident{pos: tok.pos, name: tok.text}, nil
+	case p.accept(tokPunct, "("):
+		x, err := p.expr(false)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokPunct, ")"); err != nil {
+			return nil, err
+		}
+		return x, nil
+	case p.accept(tokPunct, "["):
+		lit := &arrayLit{pos: tok.pos}
+		for !p.accept(tokPunct, "]") {
+			elem, err := p.expr(false)
+			if err != nil {
+				return nil, err
+			}
+			lit.elems = append(lit.elems, elem)
+			if !p.at(tokPunct, "]") {
+				if _, err := p.expect(tokPunct, ","); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return lit, nil
+	case p.at(tokKeyword, "map"):
+		t, err := p.mapType()
+		if err != nil {
+			return nil, err
+		}
+		lit := &mapLit{pos: tok.pos, typ: t}
+		if _, err := p.expect(tokPunct, "{"); err != nil {
+			return nil, err
+		}
+		for !p.accept(tokPunct, "}") {
+			key, err := p.expr(false)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect(tokPunct, ":"); err != nil {
+				return nil, err
+			}
+			val, err := p.expr(false)
+			if err != nil {
+				return nil, err
+			}
+			lit.keys = append(lit.keys, key)
+			lit.vals = append(lit.vals, val)
+			if !p.at(tokPunct, "}") {
+				if _, err := p.expect(tokPunct, ","); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return lit, nil
+	case p.accept(tokKeyword, "fn"):
+		sig, err := p.signature()
+		if err != nil {
+			return nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		return &funcLit{pos: tok.pos, sig: sig, body: body}, nil
+	}
+	return nil, p.unexpected()
+}
+
+func (p *parser) structLit(name token) (*structLit, error) {
+	lit := &structLit{pos: name.pos, name: name.text}
+	p.next()
+	for !p.accept(tokPunct, "}") {
+		field, err := p.expect(tokIdent, "")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokPunct, ":"); err != nil {
+			return nil, err
+		}
+		val, err := p.expr(false)
+		if err != nil {
+			return nil, err
+		}
+		lit.fields = append(lit.fields, field.text)
+		lit.vals = append(lit.vals, val)
+		if !p.at(tokPunct, "}") {
+			if _, err := p.expect(tokPunct, ","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return lit, nil
+}
+
+// semi consumes the semicolon ending a simple statement.
+func (p *parser) semi() error {
+	_, err := p.expect(tokPunct, ";")
+	return err
+}
+
+func (p *parser) tok() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) peek(n int) token {
+	if p.pos+n >= len(p.toks) {
+		return p.toks[len(p.toks)-1]
+	}
+	return p.toks[p.pos+n]
+}
+
+func (p *parser) next() token {
+	tok := p.toks[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// at reports whether the current token has kind k and, when text is not
+// empty, that text.
+func (p *parser) at(k kind, text string) bool {
+	tok := p.tok()
+	return tok.kind == k && (text == "" || tok.text == text)
+}
+
+func (p *parser) accept(k kind, text string) bool {
+	if p.at(k, text) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(k kind, text string) (token, error) {
+	if !p.at(k, text) {
+		if text != "" {
+			return token{}, errorf(p.tok().pos, ErrSyntax, "expected %q, found %s", text, p.tok())
+		}
+		return token{}, errorf(p.tok().pos, ErrSyntax, "expected %s, found %s", names[k], p.tok())
+	}
+	return p.next(), nil
+}
+
+func (p *parser) unexpected() error {
+	return errorf(p.tok().pos, ErrSyntax, "unexpected %s", p.tok())
+}
+
+var names = map[kind]string{
+	tokEOF:     "end of file",
+	tokIdent:   "identifier",
+	tokInt:     "integer",
+	tokFloat:   "float",
+	tokString:  "string",
+	tokPunct:   "operator",
+	tokKeyword: "keyword",
+}
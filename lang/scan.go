@@ -0,0 +1,221 @@
+package lang
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/siyul-park/minivm/types"
+)
+
+// token is one lexical element: its class, its source text, and the position
+// of its first byte.
+type token struct {
+	kind kind
+	text string
+	pos  types.Position
+}
+
+type kind int
+
+// scanner splits source text into tokens. Whitespace and // line comments
+// separate tokens and are otherwise discarded.
+type scanner struct {
+	file string
+	src  string
+	off  int
+	line int
+	col  int
+}
+
+const (
+	tokEOF kind = iota
+	tokIdent
+	tokInt
+	tokFloat
+	tokString
+	tokPunct
+	tokKeyword
+)
+
+var keywords = map[string]bool{
+	"as": true, "break": true, "catch": true, "continue": true, "else": true,
+	"export": true, "false": true, "fn": true, "for": true, "if": true,
+	"import": true, "in": true, "let": true, "map": true, "return": true,
+	"struct": true, "throw": true, "true": true, "try": true, "while": true,
+}
+
+// puncts lists the operators and delimiters, longest first so the scanner
+// takes the longest match.
+var puncts = []string{
+	"<<=", ">>=",
+	"->", "==", "!=", "<=", ">=", "&&", "||", "<<", ">>",
+	"+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=",
+	"(", ")", "{", "}", "[", "]", ",", ";", ":", ".",
+	"=", "<", ">", "+", "-", "*", "/", "%", "&", "|", "^", "!",
+}
+
+func newScanner(file, src string) *scanner {
+	return &scanner{file: file, src: src, line: 1, col: 1}
+}
+
+// scan returns every token of the source followed by a final tokEOF.
+func (s *scanner) scan() ([]token, error) {
+	var toks []token
+	for {
+		tok, err := s.next()
+		if err != nil {
+			return nil, err
+		}
+		toks = append(toks, tok)
+		if tok.kind == tokEOF {
+			return toks, nil
+		}
+	}
+}
+
+func (s *scanner) next() (token, error) {
+	s.skip()
+	pos := s.pos()
+	if s.off >= len(s.src) {
+		return token{kind: tokEOF, pos: pos}, nil
+	}
+
+	start := s.off
+	r, _ := utf8.DecodeRuneInString(s.src[s.off:])
+	switch {
+	case r == '_' || unicode.IsLetter(r):
+		for s.off < len(s.src) {
+			r, _ := utf8.DecodeRuneInString(s.src[s.off:])
+			if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				break
+			}
+			s.advance()
+		}
+		text := s.src[start:s.off]
+		if keywords[text] {
+			return token{kind: tokKeyword, text: text, pos: pos}, nil
+		}
+		return token{kind: tokIdent, text: text, pos: pos}, nil
+	case r >= '0' && r <= '9':
+		return s.number(pos)
+	case r == '"':
+		return s.string(pos)
+	}
+
+	for _, p := range puncts {
+		if strings.HasPrefix(s.src[s.off:], p) {
+			for range p {
+				s.advance()
+			}
+			return token{kind: tokPunct, text: p, pos: pos}, nil
+		}
+	}
+	return token{}, errorf(pos, ErrSyntax, "unexpected character %q", r)
+}
+
+func (s *scanner) number(pos types.Position) (token, error) {
+	start := s.off
+	kind := tokInt
+	digits := func() {
+		for s.off < len(s.src) && (isDigit(s.src[s.off]) || s.src[s.off] == '_') {
+			s.advance()
+		}
+	}
+	digits()
+	if s.off+1 < len(s.src) && s.src[s.off] == '.' && isDigit(s.src[s.off+1]) {
+		kind = tokFloat
+		s.advance()
+		digits()
+	}
+	if s.off < len(s.src) && (s.src[s.off] == 'e' || s.src[s.off] == 'E') {
+		kind = tokFloat
+		s.advance()
+		if s.off < len(s.src) && (s.src[s.off] == '+' || s.src[s.off] == '-') {
+			s.advance()
+		}
+		digits()
+	}
+	text := s.src[start:s.off]
+	if s.off < len(s.src) {
+		if r, _ := utf8.DecodeRuneInString(s.src[s.off:]); r == '_' || unicode.IsLetter(r) {
+			return token{}, errorf(pos, ErrSyntax, "malformed number %s%c", text, r)
+		}
+	}
+	return token{kind: kind, text: text, pos: pos}, nil
+}
+
+func (s *scanner) string(pos types.Position) (token, error) {
+	start := s.off
+	s.advance()
+	for s.off < len(s.src) {
+		switch s.src[s.off] {
+		case '\\':
+			s.advance()
+			if s.off < len(s.src) {
+				s.advance()
+			}
+			continue
+		case '\n':
+			return token{}, errorf(pos, ErrSyntax, "unterminated string")
+		case '"':
+			s.advance()
+			text, err := strconv.Unquote(s.src[start:s.off])
+			if err != nil {
+				return token{}, errorf(pos, ErrSyntax, "malformed string %s", s.src[start:s.off])
+			}
+			return token{kind: tokString, text: text, pos: pos}, nil
+		}
+		s.advance()
+	}
+	return token{}, errorf(pos, ErrSyntax, "unterminated string")
+}
+
+// skip moves past whitespace and comments.
+func (s *scanner) skip() {
+	for s.off < len(s.src) {
+		switch {
+		case strings.HasPrefix(s.src[s.off:], "//"):
+			for s.off < len(s.src) && s.src[s.off] != '\n' {
+				s.advance()
+			}
+		case s.src[s.off] == ' ' || s.src[s.off] == '\t' || s.src[s.off] == '\r' || s.src[s.off] == '\n':
+			s.advance()
+		default:
+			return
+		}
+	}
+}
+
+// advance consumes one rune, tracking its line and column.
+func (s *scanner) advance() {
+	r, size := utf8.DecodeRuneInString(s.src[s.off:])
+	s.off += size
+	if r == '\n' {
+		s.line++
+		s.col = 1
+		return
+	}
+	s.col++
+}
+
+func (s *scanner) pos() types.Position {
+	return types.Position{File: s.file, Line: s.line, Column: s.col}
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of file"
+	case tokString:
+		return strconv.Quote(t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
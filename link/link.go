@@ -0,0 +1,442 @@
+// Package link merges separately compiled programs into one.
+package link
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/pass"
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/transform"
+	"github.com/siyul-park/minivm/types"
+)
+
+type option struct {
+	external map[string]bool
+}
+
+// linker accumulates the merged pools of the programs being linked. Every index
+// it holds is already relative to the merged pools.
+type linker struct {
+	external map[string]bool
+
+	code      []byte
+	constants []types.Value
+	typs      []types.Type
+	globals   []types.Type
+	names     []string
+
+	exports []program.Export
+	imports []program.Import
+	pending []program.Import
+	alias   map[int]int
+}
+
+// base is where one input program's pools start in the merged pools.
+type base struct {
+	constant int
+	typ      int
+	global   int
+}
+
+// maxIndex is the largest pool index a two-byte operand can encode.
+const maxIndex = math.MaxUint16
+
+var (
+	ErrUnresolvedSymbol  = errors.New("unresolved symbol")
+	ErrConflictingSymbol = errors.New("conflicting symbol")
+	ErrLibraryCode       = errors.New("library program has top-level code")
+	ErrIndexOverflow     = errors.New("merged pool exceeds operand range")
+)
+
+// WithExternal names imports the host supplies. Link keeps them as imports of
+// the linked program instead of failing with ErrUnresolvedSymbol when no input
+// exports them.
+func WithExternal(names ...string) func(*option) {
+	return func(o *option) {
+		if o.external == nil {
+			o.external = make(map[string]bool, len(names))
+		}
+		for _, name := range names {
+			o.external[name] = true
+		}
+	}
+}
+
+// Link merges progs into one verified program. The first program is the entry:
+// its top-level code, locals, and handlers become the result's. The rest are
+// libraries that contribute functions, globals, and exports; one with top-level
+// code fails with ErrLibraryCode.
+//
+// Constant, type, and global pools are concatenated and every CONST_GET, type
+// operand, and GLOBAL_* index is rewritten into the merged pools. An import is
+// bound to the function another program exports under its name; one nobody
+// exports fails with ErrUnresolvedSymbol unless WithExternal names it.
+// Structurally identical functions collapse into one, so a helper compiled into
+// several programs is linked once, and two programs may export the same name
+// only when it resolves to that one function. Any other clash fails with
+// ErrConflictingSymbol. The merged pools are then compacted by
+// transform.DedupPass.
+func Link(progs []*program.Program, opts ...func(*option)) (*program.Program, error) {
+	var opt option
+	for _, o := range opts {
+		o(&opt)
+	}
+	if len(progs) == 0 {
+		return program.New(nil), nil
+	}
+
+	for k, p := range progs {
+		if err := program.Verify(p); err != nil {
+			return nil, fmt.Errorf("program %d: %w", k, err)
+		}
+	}
+
+	l := &linker{external: opt.external, alias: map[int]int{}}
+	if err := l.merge(progs); err != nil {
+		return nil, err
+	}
+	if err := l.resolve(); err != nil {
+		return nil, err
+	}
+	l.rewrite(l.find)
+
+	classes := l.classes()
+	l.rewrite(func(idx int) int { return classes[idx] })
+	exports, err := l.dedupExports(classes)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := progs[0]
+	prog := &program.Program{
+		Code:      l.code,
+		Locals:    entry.Locals,
+		Globals:   l.globals,
+		Constants: l.constants,
+		Types:     l.typs,
+		Handlers:  entry.Handlers,
+		Exports:   exports,
+		Imports:   l.imports,
+		Debug:     l.debug(entry),
+	}
+	if _, err := transform.NewDedupPass().Run(pass.NewManager(), prog); err != nil {
+		return nil, err
+	}
+	if err := program.Verify(prog); err != nil {
+		return nil, err
+	}
+	return prog, nil
+}
+
+// merge concatenates the pools of progs and relocates their code into them.
+// Function constants are copied first so the inputs stay untouched.
+func (l *linker) merge(progs []*program.Program) error {
+	bases := make([]base, len(progs))
+	for k, p := range progs {
+		if k > 0 && len(p.Code) > 0 {
+			return fmt.Errorf("%w: program %d", ErrLibraryCode, k)
+		}
+		b := base{constant: len(l.constants), typ: len(l.typs), global: len(l.globals)}
+		bases[k] = b
+		for _, v := range p.Constants {
+			if fn, ok := v.(*types.Function); ok {
+				clone := *fn
+				clone.Code = slices.Clone(fn.Code)
+				v = &clone
+			}
+			l.constants = append(l.constants, v)
+		}
+		l.typs = append(l.typs, p.Types...)
+		l.globals = append(l.globals, p.Globals...)
+		for j := range p.Globals {
+			var name string
+			if p.Debug != nil {
+				name = p.Debug.Global(j)
+			}
+			l.names = append(l.names, name)
+		}
+
+		for _, e := range p.Exports {
+			if e.Kind == program.ExportGlobal {
+				e.Index += b.global
+			} else {
+				e.Index += b.constant
+			}
+			l.exports = append(l.exports, e)
+		}
+		for _, imp := range p.Imports {
+			imp.Const += b.constant
+			l.pending = append(l.pending, imp)
+		}
+	}
+	if len(l.constants) > maxIndex+1 || len(l.typs) > maxIndex+1 || len(l.globals) > maxIndex+1 {
+		return ErrIndexOverflow
+	}
+
+	l.code = slices.Clone(progs[0].Code)
+	relocate(l.code, bases[0])
+	for k, p := range progs {
+		b := bases[k]
+		for j := range p.Constants {
+			if fn, ok := l.constants[b.constant+j].(*types.Function); ok {
+				relocate(fn.Code, b)
+			}
+		}
+	}
+	return nil
+}
+
+// debug returns the linked program's debug-info section: the entry's top-level
+// debug info and the names of the merged globals, or nil when neither exists.
+// Function constants keep their own debug info through the copy in merge.
+func (l *linker) debug(entry *program.Program) *program.Debug {
+	var code *types.Debug
+	if entry.Debug != nil {
+		code = entry.Debug.Code
+	}
+	globals := l.names
+	if !slices.ContainsFunc(globals, func(name string) bool { return name != "" }) {
+		globals = nil
+	}
+	if code == nil && globals == nil {
+		return nil
+	}
+	return &program.Debug{Code: code, Globals: globals}
+}
+
+// resolve binds every import either to the function exported under its name or
+// to a single external import of the linked program.
+func (l *linker) resolve() error {
+	externals := map[string]int{}
+	for _, imp := range l.pending {
+		idx := slices.IndexFunc(l.exports, func(e program.Export) bool { return e.Name == imp.Name })
+		if idx >= 0 {
+			e := l.exports[idx]
+			if e.Kind != program.ExportFunction {
+				return fmt.Errorf("%w: %q: imported as a function, exported as a global", ErrConflictingSymbol, imp.Name)
+			}
+			fn := l.constants[e.Index].(*types.Function)
+			if fn.Typ == nil || !fn.Typ.Equals(imp.Typ) {
+				return fmt.Errorf("%w: %q: exported as %v, imported as %s", ErrConflictingSymbol, imp.Name, fn.Typ, imp.Typ)
+			}
+			l.alias[imp.Const] = e.Index
+			continue
+		}
+		if !l.external[imp.Name] {
+			return fmt.Errorf("%w: %q", ErrUnresolvedSymbol, imp.Name)
+		}
+		if n, ok := externals[imp.Name]; ok {
+			if !l.imports[n].Typ.Equals(imp.Typ) {
+				return fmt.Errorf("%w: %q: imported as %s and %s", ErrConflictingSymbol, imp.Name, l.imports[n].Typ, imp.Typ)
+			}
+			l.alias[imp.Const] = l.imports[n].Const
+			continue
+		}
+		externals[imp.Name] = len(l.imports)
+		l.imports = append(l.imports, imp)
+	}
+
+	// An export may name an import that is itself bound to another program's
+	// export; following a chain back to where it started means no program
+	// defines the function.
+	for idx := range l.alias {
+		if l.find(idx) < 0 {
+			return fmt.Errorf("%w: %q", ErrUnresolvedSymbol, l.name(idx))
+		}
+	}
+	for i, e := range l.exports {
+		if e.Kind == program.ExportFunction {
+			l.exports[i].Index = l.find(e.Index)
+		}
+	}
+	for i, imp := range l.imports {
+		l.imports[i].Const = l.find(imp.Const)
+	}
+	return nil
+}
+
+// find follows import bindings from idx to the constant that defines it, or
+// returns -1 when the bindings form a cycle.
+func (l *linker) find(idx int) int {
+	for range len(l.alias) + 1 {
+		next, ok := l.alias[idx]
+		if !ok {
+			return idx
+		}
+		idx = next
+	}
+	return -1
+}
+
+func (l *linker) name(idx int) string {
+	for _, imp := range l.pending {
+		if imp.Const == idx {
+			return imp.Name
+		}
+	}
+	return strconv.Itoa(idx)
+}
+
+// rewrite maps every CONST_GET operand in the merged code through fn.
+func (l *linker) rewrite(fn func(int) int) {
+	each := func(code []byte) {
+		for ip := 0; ip < len(code); {
+			inst := instr.Instruction(code[ip:])
+			if inst.Opcode() == instr.CONST_GET {
+				inst.SetOperand(0, uint64(fn(int(inst.Operand(0)))))
+			}
+			ip += inst.Width()
+		}
+	}
+	each(l.code)
+	for _, v := range l.constants {
+		if fn, ok := v.(*types.Function); ok {
+			each(fn.Code)
+		}
+	}
+}
+
+// classes maps every constant to the lowest index of a constant it can be
+// merged with. Plain values are grouped the way DedupPass would group them, so
+// functions that load equal literals from different programs still match.
+// Functions start in one class per shape (signature, locals,
+// captures, handlers, and code with constant operands masked) and are split by
+// the classes of the constants they reference until nothing changes, so
+// mutually recursive helpers duplicated across programs still collapse.
+func (l *linker) classes() []int {
+	typeClass := make([]int, len(l.typs))
+	for i, t := range l.typs {
+		typeClass[i] = i
+		for j := range i {
+			if l.typs[j].Equals(t) {
+				typeClass[i] = typeClass[j]
+				break
+			}
+		}
+	}
+
+	shapes := make([]string, len(l.constants))
+	class := make([]int, len(l.constants))
+	keys := map[string]int{}
+	values := map[types.Value]int{}
+	for i, v := range l.constants {
+		class[i] = i
+		switch v := v.(type) {
+		case *types.Function:
+			if len(v.Code) == 0 {
+				continue
+			}
+			shapes[i] = shape(v, typeClass)
+			if j, ok := keys[shapes[i]]; ok {
+				class[i] = j
+			} else {
+				keys[shapes[i]] = i
+			}
+		default:
+			if typ := reflect.TypeOf(v); typ != nil && !typ.Comparable() {
+				continue
+			}
+			if j, ok := values[v]; ok {
+				class[i] = j
+			} else {
+				values[v] = i
+			}
+		}
+	}
+
+	for {
+		next := slices.Clone(class)
+		keys := map[string]int{}
+		for i, v := range l.constants {
+			if shapes[i] == "" {
+				continue
+			}
+			var sb strings.Builder
+			sb.WriteString(strconv.Itoa(class[i]))
+			code := v.(*types.Function).Code
+			for ip := 0; ip < len(code); {
+				inst := instr.Instruction(code[ip:])
+				if inst.Opcode() == instr.CONST_GET {
+					sb.WriteByte(',')
+					sb.WriteString(strconv.Itoa(class[inst.Operand(0)]))
+				}
+				ip += inst.Width()
+			}
+			key := sb.String()
+			if j, ok := keys[key]; ok {
+				next[i] = j
+			} else {
+				keys[key] = i
+			}
+		}
+		if slices.Equal(next, class) {
+			return class
+		}
+		class = next
+	}
+}
+
+// dedupExports keeps one export per name. Two programs exporting the same name
+// conflict unless both name the same merged function.
+func (l *linker) dedupExports(classes []int) ([]program.Export, error) {
+	var exports []program.Export
+	for _, e := range l.exports {
+		if e.Kind == program.ExportFunction {
+			e.Index = classes[e.Index]
+		}
+		idx := slices.IndexFunc(exports, func(other program.Export) bool { return other.Name == e.Name })
+		if idx < 0 {
+			exports = append(exports, e)
+			continue
+		}
+		if other := exports[idx]; other.Kind != program.ExportFunction || other != e {
+			return nil, fmt.Errorf("%w: %q: exported by more than one program", ErrConflictingSymbol, e.Name)
+		}
+	}
+	return exports, nil
+}
+
+// relocate shifts every pool index in code by the program's base.
+func relocate(code []byte, b base) {
+	for ip := 0; ip < len(code); {
+		inst := instr.Instruction(code[ip:])
+		switch op := inst.Opcode(); {
+		case op == instr.CONST_GET:
+			inst.SetOperand(0, inst.Operand(0)+uint64(b.constant))
+		case instr.IndexesType(op):
+			inst.SetOperand(0, inst.Operand(0)+uint64(b.typ))
+		case op == instr.GLOBAL_GET || op == instr.GLOBAL_SET || op == instr.GLOBAL_TEE:
+			inst.SetOperand(0, inst.Operand(0)+uint64(b.global))
+		default:
+		}
+		ip += inst.Width()
+	}
+}
+
+// shape renders everything about fn that must match for two functions to be
+// merged, except which constants it references.
+func shape(fn *types.Function, typeClass []int) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%v|%v|%v|%v|", fn.Typ, fn.Locals, fn.Captures, fn.Handlers)
+	for ip := 0; ip < len(fn.Code); {
+		inst := instr.Instruction(fn.Code[ip:])
+		w := inst.Width()
+		switch op := inst.Opcode(); {
+		case op == instr.CONST_GET:
+			sb.WriteString("c;")
+		case instr.IndexesType(op):
+			fmt.Fprintf(&sb, "%d:t%d;", op, typeClass[inst.Operand(0)])
+		default:
+			fmt.Fprintf(&sb, "%x;", fn.Code[ip:ip+w])
+		}
+		ip += w
+	}
+	return sb.String()
+}
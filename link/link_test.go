@@ -0,0 +1,264 @@
+package link_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/interp"
+	"github.com/siyul-park/minivm/link"
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithExternal(t *testing.T) {
+	sig := &types.FunctionType{Params: []types.Type{types.TypeI32}, Returns: []types.Type{types.TypeI32}}
+	main := program.NewBuilder()
+	inc := main.Import("env.inc", sig)
+	main.Emit(instr.I32_CONST, 1).Emit(instr.CONST_GET, uint64(inc)).Emit(instr.CALL)
+	entry, err := main.Build()
+	require.NoError(t, err)
+
+	_, err = link.Link([]*program.Program{entry})
+	require.ErrorIs(t, err, link.ErrUnresolvedSymbol)
+	require.ErrorContains(t, err, `"env.inc"`)
+
+	prog, err := link.Link([]*program.Program{entry}, link.WithExternal("env.inc"))
+	require.NoError(t, err)
+	require.Len(t, prog.Imports, 1)
+	require.Equal(t, "env.inc", prog.Imports[0].Name)
+}
+
+func TestLink(t *testing.T) {
+	i32 := []types.Type{types.TypeI32}
+	sig := &types.FunctionType{Params: i32, Returns: i32}
+
+	// double is the shared helper every library compiles in.
+	double := func() *types.Function {
+		return types.NewFunctionBuilder(sig).
+			Emit(
+				instr.New(instr.LOCAL_GET, 0),
+				instr.New(instr.LOCAL_GET, 0),
+				instr.New(instr.I32_ADD),
+				instr.New(instr.RETURN),
+			).
+			MustBuild()
+	}
+
+	// library builds a program exporting name as double applied to its input
+	// plus offset.
+	library := func(t *testing.T, name string, offset uint64) *program.Program {
+		b := program.NewBuilder()
+		b.Const(types.String("padding"))
+		helper := b.Const(double())
+		fn := b.Const(types.NewFunctionBuilder(sig).
+			Emit(
+				instr.New(instr.LOCAL_GET, 0),
+				instr.New(instr.CONST_GET, uint64(helper)),
+				instr.New(instr.CALL),
+				instr.New(instr.I32_CONST, offset),
+				instr.New(instr.I32_ADD),
+				instr.New(instr.RETURN),
+			).
+			MustBuild())
+		b.Export(name, program.ExportFunction, fn)
+		prog, err := b.Build()
+		require.NoError(t, err)
+		return prog
+	}
+
+	entry := func(t *testing.T, names ...string) *program.Program {
+		b := program.NewBuilder()
+		b.Globals(types.TypeI32)
+		b.Emit(instr.I32_CONST, 0)
+		for _, name := range names {
+			idx := b.Import(name, sig)
+			b.Emit(instr.CONST_GET, uint64(idx)).Emit(instr.CALL)
+		}
+		b.Emit(instr.GLOBAL_SET, 0)
+		prog, err := b.Build()
+		require.NoError(t, err)
+		return prog
+	}
+
+	t.Run("resolves imports across programs", func(t *testing.T) {
+		prog, err := link.Link([]*program.Program{entry(t, "f", "g"), library(t, "f", 1), library(t, "g", 2)})
+		require.NoError(t, err)
+		require.Empty(t, prog.Imports)
+
+		vm := interp.New(prog)
+		defer vm.Close()
+		require.NoError(t, vm.Run(context.Background()))
+		g, err := vm.Global(0)
+		require.NoError(t, err)
+		require.Equal(t, types.BoxI32(2*(2*0+1)+2), g)
+
+		returns, err := vm.Call(context.Background(), "g", types.BoxI32(5))
+		require.NoError(t, err)
+		require.Equal(t, []types.Boxed{types.BoxI32(12)}, returns)
+	})
+
+	t.Run("merges duplicated helpers", func(t *testing.T) {
+		prog, err := link.Link([]*program.Program{entry(t, "f", "g"), library(t, "f", 1), library(t, "g", 2)})
+		require.NoError(t, err)
+
+		var fns int
+		for _, v := range prog.Constants {
+			if _, ok := v.(*types.Function); ok {
+				fns++
+			}
+		}
+		require.Equal(t, 3, fns, "one double plus f and g")
+		require.NotContains(t, prog.Constants, types.String("padding"))
+	})
+
+	t.Run("merges identical exports", func(t *testing.T) {
+		prog, err := link.Link([]*program.Program{entry(t, "f"), library(t, "f", 1), library(t, "f", 1)})
+		require.NoError(t, err)
+		require.Len(t, prog.Exports, 1)
+	})
+
+	t.Run("merges mutually recursive helpers", func(t *testing.T) {
+		recursive := func(t *testing.T, name string) *program.Program {
+			b := program.NewBuilder()
+			even := &types.Function{Typ: sig}
+			odd := &types.Function{Typ: sig}
+			e, o := b.Const(even), b.Const(odd)
+			body := func(other int, base uint64) *types.Function {
+				fb := types.NewFunctionBuilder(sig)
+				done := fb.Label()
+				fb.Emit(instr.New(instr.LOCAL_GET, 0), instr.New(instr.I32_EQZ))
+				fb.BrIf(done)
+				fb.Emit(
+					instr.New(instr.LOCAL_GET, 0),
+					instr.New(instr.I32_CONST, 1),
+					instr.New(instr.I32_SUB),
+					instr.New(instr.CONST_GET, uint64(other)),
+					instr.New(instr.RETURN_CALL),
+				)
+				fb.Bind(done)
+				fb.Emit(instr.New(instr.I32_CONST, base), instr.New(instr.RETURN))
+				return fb.MustBuild()
+			}
+			*even, *odd = *body(o, 1), *body(e, 0)
+			b.Export(name, program.ExportFunction, e)
+			prog, err := b.Build()
+			require.NoError(t, err)
+			require.NoError(t, program.Verify(prog))
+			return prog
+		}
+
+		prog, err := link.Link([]*program.Program{program.New(nil), recursive(t, "a"), recursive(t, "b")})
+		require.NoError(t, err)
+		require.Len(t, prog.Constants, 2)
+		require.Equal(t, prog.Exports[0].Index, prog.Exports[1].Index)
+
+		vm := interp.New(prog)
+		defer vm.Close()
+		returns, err := vm.Call(context.Background(), "b", types.BoxI32(7))
+		require.NoError(t, err)
+		require.Equal(t, []types.Boxed{types.BoxI32(0)}, returns)
+	})
+
+	t.Run("relocates globals", func(t *testing.T) {
+		counter := func(t *testing.T, name string) *program.Program {
+			b := program.NewBuilder()
+			b.Globals(types.TypeI32)
+			fn := b.Const(types.NewFunctionBuilder(sig).
+				Emit(
+					instr.New(instr.GLOBAL_GET, 0),
+					instr.New(instr.LOCAL_GET, 0),
+					instr.New(instr.I32_ADD),
+					instr.New(instr.GLOBAL_TEE, 0),
+					instr.New(instr.RETURN),
+				).
+				MustBuild())
+			b.Export(name, program.ExportFunction, fn).Export(name+".total", program.ExportGlobal, 0)
+			prog, err := b.Build()
+			require.NoError(t, err)
+			return prog
+		}
+
+		prog, err := link.Link([]*program.Program{entry(t), counter(t, "a"), counter(t, "b")})
+		require.NoError(t, err)
+		require.Len(t, prog.Globals, 3)
+
+		vm := interp.New(prog)
+		defer vm.Close()
+		_, err = vm.Call(context.Background(), "a", types.BoxI32(3))
+		require.NoError(t, err)
+		_, err = vm.Call(context.Background(), "b", types.BoxI32(4))
+		require.NoError(t, err)
+
+		a, _ := prog.Export("a.total")
+		b, _ := prog.Export("b.total")
+		ga, err := vm.Global(a.Index)
+		require.NoError(t, err)
+		gb, err := vm.Global(b.Index)
+		require.NoError(t, err)
+		require.Equal(t, types.BoxI32(3), ga)
+		require.Equal(t, types.BoxI32(4), gb)
+	})
+
+	t.Run("carries debug info", func(t *testing.T) {
+		main := entry(t, "f")
+		main.Debug = &program.Debug{Code: &types.Debug{Name: "main"}}
+		lib := library(t, "f", 1)
+		lib.Globals = []types.Type{types.TypeI32}
+		lib.Debug = &program.Debug{Globals: []string{"hits"}}
+		f, _ := lib.Export("f")
+		lib.Constants[f.Index].(*types.Function).Debug = &types.Debug{Name: "f"}
+
+		prog, err := link.Link([]*program.Program{main, lib})
+		require.NoError(t, err)
+		require.Equal(t, &program.Debug{Code: main.Debug.Code, Globals: []string{"", "hits"}}, prog.Debug)
+
+		f, _ = prog.Export("f")
+		require.Equal(t, "f", prog.Constants[f.Index].(*types.Function).Debug.Name)
+	})
+
+	t.Run("leaves inputs untouched", func(t *testing.T) {
+		lib := library(t, "f", 1)
+		before := lib.String()
+		_, err := link.Link([]*program.Program{entry(t, "f"), lib})
+		require.NoError(t, err)
+		require.Equal(t, before, lib.String())
+	})
+
+	t.Run("rejects conflicting exports", func(t *testing.T) {
+		_, err := link.Link([]*program.Program{entry(t, "f"), library(t, "f", 1), library(t, "f", 2)})
+		require.ErrorIs(t, err, link.ErrConflictingSymbol)
+		require.ErrorContains(t, err, `"f"`)
+	})
+
+	t.Run("rejects signature mismatch", func(t *testing.T) {
+		b := program.NewBuilder()
+		idx := b.Import("f", &types.FunctionType{Params: i32})
+		b.Emit(instr.I32_CONST, 0).Emit(instr.CONST_GET, uint64(idx)).Emit(instr.CALL)
+		main, err := b.Build()
+		require.NoError(t, err)
+
+		_, err = link.Link([]*program.Program{main, library(t, "f", 1)})
+		require.ErrorIs(t, err, link.ErrConflictingSymbol)
+		require.ErrorContains(t, err, `"f"`)
+	})
+
+	t.Run("rejects unresolved import", func(t *testing.T) {
+		_, err := link.Link([]*program.Program{entry(t, "missing"), library(t, "f", 1)})
+		require.ErrorIs(t, err, link.ErrUnresolvedSymbol)
+		require.ErrorContains(t, err, `"missing"`)
+	})
+
+	t.Run("rejects library code", func(t *testing.T) {
+		lib := program.New([]instr.Instruction{instr.New(instr.NOP)})
+		_, err := link.Link([]*program.Program{entry(t), lib})
+		require.ErrorIs(t, err, link.ErrLibraryCode)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		prog, err := link.Link(nil)
+		require.NoError(t, err)
+		require.Equal(t, program.New(nil), prog)
+	})
+}
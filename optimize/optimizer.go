@@ -32,6 +32,10 @@ func New(level Level) *Optimizer {
 
 	pass.Register(o.manager, analysis.NewBlocksAnalysis())
 	pass.Register(o.manager, analysis.NewGVNAnalysis())
+	pass.Register(o.manager, analysis.NewDominatorsAnalysis())
+	pass.Register(o.manager, analysis.NewLoopsAnalysis())
+	pass.Register(o.manager, analysis.NewSCCPAnalysis())
+	pass.Register(o.manager, analysis.NewLivenessAnalysis())
 	for _, p := range o.transforms() {
 		o.pipeline.Add(p)
 	}
@@ -53,8 +57,11 @@ func (o *Optimizer) Add(p pass.Pass[*program.Program]) {
 }
 
 // transforms returns the cumulative transform pipeline for the optimizer level:
-// O1 runs cheap local rewrites, O2 adds CFG-based passes, O3 adds cross-block
-// global value numbering (which subsumes block-local CSE) on top.
+// O1 runs cheap local rewrites, O2 adds CFG-based passes, O3 first inlines small
+// callees so the later passes see through them, then propagates constants
+// across blocks, and adds loop-invariant code motion, cross-block global value
+// numbering (which subsumes block-local CSE), and tail-call and dead-store
+// cleanup on top.
 func (o *Optimizer) transforms() []pass.Pass[*program.Program] {
 	switch o.level {
 	case O1:
@@ -71,9 +78,13 @@ func (o *Optimizer) transforms() []pass.Pass[*program.Program] {
 		}
 	case O3:
 		return []pass.Pass[*program.Program]{
+			transform.NewInlinePass(),
+			transform.NewSCCPPass(),
 			transform.NewFoldPass(),
 			transform.NewAlgebraicPass(),
+			transform.NewLICMPass(),
 			transform.NewGVNPass(),
+			transform.NewTailCallPass(),
 			transform.NewDedupPass(),
 			transform.NewDCEPass(),
 		}
@@ -2,10 +2,12 @@ package optimize_test
 
 import (
 	"context"
+	"strings"
 	"testing"
 
 	"github.com/siyul-park/minivm/instr"
 	"github.com/siyul-park/minivm/interp"
+	"github.com/siyul-park/minivm/lang"
 	"github.com/siyul-park/minivm/optimize"
 	"github.com/siyul-park/minivm/program"
 	"github.com/siyul-park/minivm/transform"
@@ -357,6 +359,56 @@ func TestOptimizer_Optimize(t *testing.T) {
 		require.Equal(t, beforeValue, optimizedValue)
 	})
 
+	t.Run("O3 output verifies after a try arm is decided away", func(t *testing.T) {
+		src := "fn g() -> i32 { let acc = 0; if acc == 0 { acc = 1; } else { try { acc = 2; } catch e { acc = 3; } } return acc; } g()"
+		prog, err := lang.Compile("dead.mvl", strings.NewReader(src))
+		require.NoError(t, err)
+
+		optimized, err := optimize.New(optimize.O3).Optimize(prog)
+		require.NoError(t, err)
+		require.NoError(t, program.Verify(optimized))
+
+		vm := interp.New(optimized)
+		defer vm.Close()
+		require.NoError(t, vm.Run(context.Background()))
+		value, err := vm.Pop()
+		require.NoError(t, err)
+		require.Equal(t, types.I32(1), value)
+	})
+
+	t.Run("O3 preserves export and import identity", func(t *testing.T) {
+		sig := &types.FunctionType{Params: []types.Type{types.TypeI32}, Returns: []types.Type{types.TypeI32}}
+		b := program.NewBuilder()
+		b.Const(types.I32(7))
+		inc := b.Import("env.inc", sig)
+		twice := b.Const(types.NewFunctionBuilder(sig).
+			Emit(
+				instr.New(instr.LOCAL_GET, 0),
+				instr.New(instr.CONST_GET, uint64(inc)),
+				instr.New(instr.CALL),
+				instr.New(instr.CONST_GET, uint64(inc)),
+				instr.New(instr.CALL),
+				instr.New(instr.RETURN),
+			).
+			MustBuild())
+		b.Export("twice", program.ExportFunction, twice)
+		prog, err := b.Build()
+		require.NoError(t, err)
+
+		optimized, err := optimize.New(optimize.O3).Optimize(prog)
+		require.NoError(t, err)
+		require.NoError(t, program.Verify(optimized))
+
+		host := interp.NewHostFunction(sig, func(_ *interp.Interpreter, params []types.Boxed) ([]types.Boxed, error) {
+			return []types.Boxed{types.BoxI32(params[0].I32() + 1)}, nil
+		})
+		vm := interp.New(optimized, interp.WithImports(map[string]*interp.HostFunction{"env.inc": host}))
+		defer vm.Close()
+		returns, err := vm.Call(context.Background(), "twice", types.BoxI32(40))
+		require.NoError(t, err)
+		require.Equal(t, []types.Boxed{types.BoxI32(42)}, returns)
+	})
+
 	parity := []struct {
 		name string
 		prog *program.Program
@@ -1,16 +1,39 @@
 package prof
 
-import "strconv"
+import (
+	"cmp"
+	"encoding/binary"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxStacks bounds the distinct call stacks a Collector keeps, so a program
+// that recurses through ever-new stacks cannot grow it without limit.
+const maxStacks = 1 << 16
 
 // Collector records execution samples and named metrics.
 type Collector struct {
 	total   uint64
 	funcs   []samples
 	ops     [256]uint64
+	stacks  map[string]*uint64
+	key     []byte
 	metrics []Metric
 	jit     jitMetrics
 }
 
+// Frame is one activation of a sampled call stack: the module its function
+// belongs to as Profiler.Symbolize numbered it (0 when unknown), the function
+// address, the instruction offset it is executing (the call site for a
+// caller), and the opcode at that offset.
+type Frame struct {
+	Module int
+	Func   int
+	IP     int
+	Op     byte
+}
+
 type samples struct {
 	count uint64
 	ips   []uint64
@@ -85,6 +108,74 @@ func (c *Collector) Add(fn, ip int, op byte) {
 	c.ops[op]++
 }
 
+// AddStack records one call-stack sample, leaf frame first. Stacks are keyed
+// by their encoded frames, so a repeated stack costs one map lookup and no
+// allocation. Once the collector holds 65536 distinct stacks, samples of a
+// stack it has not seen are dropped.
+func (c *Collector) AddStack(stack []Frame) {
+	if len(stack) == 0 {
+		return
+	}
+	key := c.key[:0]
+	for _, f := range stack {
+		key = binary.AppendUvarint(key, uint64(f.Module))
+		key = binary.AppendUvarint(key, uint64(f.Func))
+		key = binary.AppendUvarint(key, uint64(f.IP))
+		key = append(key, f.Op)
+	}
+	c.key = key
+	if n, ok := c.stacks[string(key)]; ok {
+		*n++
+		return
+	}
+	if len(c.stacks) >= maxStacks {
+		return
+	}
+	if c.stacks == nil {
+		c.stacks = make(map[string]*uint64)
+	}
+	n := uint64(1)
+	c.stacks[string(key)] = &n
+}
+
+// Stacks calls fn for every recorded call stack with its sample count, the
+// most sampled first and ties in a fixed order. The stack slice is reused
+// between calls.
+func (c *Collector) Stacks(fn func(stack []Frame, count uint64)) {
+	keys := make([]string, 0, len(c.stacks))
+	for key, n := range c.stacks {
+		if *n > 0 {
+			keys = append(keys, key)
+		}
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		if d := cmp.Compare(*c.stacks[b], *c.stacks[a]); d != 0 {
+			return d
+		}
+		return strings.Compare(a, b)
+	})
+	var stack []Frame
+	for _, key := range keys {
+		stack = decodeStack(stack[:0], key)
+		fn(stack, *c.stacks[key])
+	}
+}
+
+func decodeStack(stack []Frame, key string) []Frame {
+	data := []byte(key)
+	for len(data) > 0 {
+		m, n := binary.Uvarint(data)
+		data = data[n:]
+		fn, n := binary.Uvarint(data)
+		data = data[n:]
+		ip, n := binary.Uvarint(data)
+		data = data[n:]
+		stack = append(stack, Frame{Module: int(m), Func: int(fn), IP: int(ip), Op: data[0]})
+		data = data[1:]
+	}
+	return stack
+}
+
 func (c *Collector) AddMetric(name string, value float64, labels ...Label) {
 	for i := range c.metrics {
 		if c.metrics[i].Name == name && sameLabels(c.metrics[i].Labels, labels) {
@@ -153,6 +244,23 @@ func (c *Collector) merge(o *Collector) {
 	for code, n := range o.ops {
 		c.ops[code] += n
 	}
+	for key, n := range o.stacks {
+		if *n == 0 {
+			continue
+		}
+		if m, ok := c.stacks[key]; ok {
+			*m += *n
+			continue
+		}
+		if len(c.stacks) >= maxStacks {
+			continue
+		}
+		if c.stacks == nil {
+			c.stacks = make(map[string]*uint64)
+		}
+		m := *n
+		c.stacks[key] = &m
+	}
 	for _, m := range o.metrics {
 		c.AddMetric(m.Name, m.Value, m.Labels...)
 	}
@@ -185,9 +293,10 @@ func (c *Collector) grow(fn, ip int) {
 }
 
 // reset clears every recorded sample while keeping the backing arrays c.funcs
-// and each function's ips grew to. A Pool flushes (and so resets) its local
-// collector on every Put, so nil-ing these out here would defeat the
-// geometric growth in grow and force a fresh allocation on the next borrow.
+// and each function's ips grew to, and the stack keys already seen. A Pool
+// flushes (and so resets) its local collector on every Put, so nil-ing these
+// out here would defeat the geometric growth in grow and force a fresh
+// allocation on the next borrow.
 func (c *Collector) reset() {
 	c.total = 0
 	for i := range c.funcs {
@@ -195,6 +304,9 @@ func (c *Collector) reset() {
 		clear(c.funcs[i].ips)
 	}
 	clear(c.ops[:])
+	for _, n := range c.stacks {
+		*n = 0
+	}
 	c.metrics = c.metrics[:0]
 	c.jit.reset()
 }
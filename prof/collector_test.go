@@ -25,6 +25,72 @@ func TestCollector_Add(t *testing.T) {
 	require.Equal(t, uint64(2), collector.Samples(0))
 }
 
+func TestCollector_AddStack(t *testing.T) {
+	t.Run("aggregates repeated stacks", func(t *testing.T) {
+		collector := prof.NewCollector()
+		stack := []prof.Frame{
+			{Func: 2, IP: 300, Op: byte(instr.I32_ADD)},
+			{Func: 0, IP: 4, Op: byte(instr.CALL)},
+		}
+		collector.AddStack(stack)
+		collector.AddStack(stack)
+		collector.AddStack(stack[1:])
+		collector.AddStack(nil)
+
+		got := map[int]uint64{}
+		collector.Stacks(func(s []prof.Frame, count uint64) {
+			if len(s) == 2 {
+				require.Equal(t, stack, s)
+			}
+			got[len(s)] = count
+		})
+		require.Equal(t, map[int]uint64{1: 1, 2: 2}, got)
+	})
+
+	t.Run("reuses stack keys", func(t *testing.T) {
+		collector := prof.NewCollector()
+		stack := []prof.Frame{{Func: 1, IP: 7, Op: byte(instr.NOP)}}
+		collector.AddStack(stack)
+		allocs := testing.AllocsPerRun(100, func() {
+			collector.AddStack(stack)
+		})
+		require.Zero(t, allocs)
+	})
+}
+
+func TestCollector_Stacks(t *testing.T) {
+	t.Run("orders by count", func(t *testing.T) {
+		collector := prof.NewCollector()
+		a := []prof.Frame{{Func: 1, IP: 0, Op: byte(instr.NOP)}}
+		b := []prof.Frame{{Func: 2, IP: 0, Op: byte(instr.NOP)}}
+		c := []prof.Frame{{Func: 3, IP: 0, Op: byte(instr.NOP)}}
+		collector.AddStack(a)
+		for range 3 {
+			collector.AddStack(c)
+			collector.AddStack(b)
+		}
+
+		var got [][]prof.Frame
+		var counts []uint64
+		collector.Stacks(func(s []prof.Frame, count uint64) {
+			got = append(got, append([]prof.Frame(nil), s...))
+			counts = append(counts, count)
+		})
+		require.Equal(t, [][]prof.Frame{b, c, a}, got)
+		require.Equal(t, []uint64{3, 3, 1}, counts)
+	})
+
+	t.Run("caps distinct stacks", func(t *testing.T) {
+		collector := prof.NewCollector()
+		for ip := range 1<<16 + 1 {
+			collector.AddStack([]prof.Frame{{Func: 0, IP: ip, Op: byte(instr.NOP)}})
+		}
+		n := 0
+		collector.Stacks(func([]prof.Frame, uint64) { n++ })
+		require.Equal(t, 1<<16, n)
+	})
+}
+
 func TestCollector_AddMetric(t *testing.T) {
 	collector := prof.NewCollector()
 	collector.AddMetric("custom", 2, prof.Label{Key: "mode", Value: "jit"})
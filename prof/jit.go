@@ -97,6 +97,7 @@ const (
 	ExitTraceCut
 	ExitTerminalOp
 	ExitLoop
+	ExitFuel
 )
 
 // OpcodeNone marks an exit that cannot be attributed to an opcode.
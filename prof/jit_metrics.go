@@ -113,6 +113,7 @@ var (
 		ExitTraceCut:    "trace-cut",
 		ExitTerminalOp:  "terminal-op",
 		ExitLoop:        "loop-exit",
+		ExitFuel:        "fuel",
 	}
 )
 
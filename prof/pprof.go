@@ -0,0 +1,252 @@
+package prof
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"slices"
+	"strconv"
+)
+
+// WritePprof writes the aggregated samples to w as a gzip-compressed
+// profile.proto, the format read by `go tool pprof`.
+//
+// Every distinct (function, IP, opcode) triple becomes one synthetic
+// location. Its innermost line names the opcode and its outer line names the
+// guest function, both at line number IP, so flame graphs end each guest
+// stack in the instruction that was executing. Addresses are synthetic:
+// the function address in the upper 32 bits and the IP in the lower.
+//
+// When Symbolize installed a lookup for a frame's module, the outer line
+// instead carries the guest function's source name, file, and line wherever
+// the lookup knows them.
+//
+// Call stacks come from AddStack. A profile that recorded only leaf samples
+// through Add is written as one single-frame stack per sampled IP, without
+// opcode lines.
+func (p *Profiler) WritePprof(w io.Writer) error {
+	p.mu.Lock()
+	data := p.encode()
+	p.mu.Unlock()
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// pprof field numbers from github.com/google/pprof/proto/profile.proto.
+const (
+	pprofSampleType    = 1
+	pprofSample        = 2
+	pprofMapping       = 3
+	pprofLocation      = 4
+	pprofFunction      = 5
+	pprofStringTable   = 6
+	pprofPeriodType    = 11
+	pprofPeriod        = 12
+	pprofDefaultSample = 14
+)
+
+type pprofLoc struct {
+	module int
+	fn, ip int
+	op     byte
+	opcode bool
+}
+
+// pprofFunc is one function entry of the profile.
+type pprofFunc struct {
+	name, file string
+}
+
+type pprofBuilder struct {
+	out       protoBuffer
+	strings   []string
+	stringIDs map[string]int
+	funcs     map[pprofFunc]uint64
+	locs      map[pprofLoc]uint64
+	locOrder  []pprofLoc
+	funcOrder []pprofFunc
+}
+
+func (p *Profiler) encode() []byte {
+	b := &pprofBuilder{
+		stringIDs: map[string]int{},
+		funcs:     map[pprofFunc]uint64{},
+		locs:      map[pprofLoc]uint64{},
+	}
+	b.string("")
+
+	samples := b.string("samples")
+	count := b.string("count")
+	b.out.message(pprofSampleType, func(m *protoBuffer) {
+		m.varint(1, uint64(samples))
+		m.varint(2, uint64(count))
+	})
+
+	var ids []uint64
+	if len(p.data.stacks) > 0 {
+		keys := make([]string, 0, len(p.data.stacks))
+		for key, n := range p.data.stacks {
+			if *n > 0 {
+				keys = append(keys, key)
+			}
+		}
+		slices.Sort(keys)
+		var stack []Frame
+		for _, key := range keys {
+			stack = decodeStack(stack[:0], key)
+			ids = ids[:0]
+			for _, f := range stack {
+				ids = append(ids, b.location(pprofLoc{module: f.Module, fn: f.Func, ip: f.IP, op: f.Op, opcode: true}))
+			}
+			b.sample(ids, *p.data.stacks[key])
+		}
+	} else {
+		for fn, fd := range p.data.funcs {
+			for ip, n := range fd.ips {
+				if n == 0 {
+					continue
+				}
+				b.sample([]uint64{b.location(pprofLoc{fn: fn, ip: ip})}, n)
+			}
+		}
+	}
+
+	b.out.message(pprofMapping, func(m *protoBuffer) {
+		m.varint(1, 1)
+		m.varint(3, 1<<63)
+		m.varint(5, uint64(b.string("minivm")))
+		m.varint(7, 1)
+		m.varint(9, 1)
+	})
+	for _, loc := range b.locOrder {
+		b.out.message(pprofLocation, func(m *protoBuffer) {
+			m.varint(1, b.locs[loc])
+			m.varint(2, 1)
+			m.varint(3, uint64(loc.fn)<<32|uint64(uint32(loc.ip)))
+			if loc.opcode {
+				op := b.function(pprofFunc{name: opcodeLabel(loc.op)})
+				m.message(4, func(l *protoBuffer) {
+					l.varint(1, op)
+					l.varint(2, uint64(loc.ip))
+				})
+			}
+			f, line := pprofFunc{name: funcName(loc.fn)}, loc.ip
+			if sym, ok := p.symbol(loc.module, loc.fn, loc.ip); ok {
+				if sym.Name != "" {
+					f.name = sym.Name
+				}
+				f.file = sym.File
+				if sym.Line > 0 {
+					line = sym.Line
+				}
+			}
+			fn := b.function(f)
+			m.message(4, func(l *protoBuffer) {
+				l.varint(1, fn)
+				l.varint(2, uint64(line))
+			})
+		})
+	}
+	for _, f := range b.funcOrder {
+		id := b.string(f.name)
+		file := b.string(f.file)
+		b.out.message(pprofFunction, func(m *protoBuffer) {
+			m.varint(1, b.funcs[f])
+			m.varint(2, uint64(id))
+			m.varint(3, uint64(id))
+			if file != 0 {
+				m.varint(4, uint64(file))
+			}
+		})
+	}
+
+	b.out.message(pprofPeriodType, func(m *protoBuffer) {
+		m.varint(1, uint64(samples))
+		m.varint(2, uint64(count))
+	})
+	b.out.varint(pprofPeriod, 1)
+	b.out.varint(pprofDefaultSample, uint64(samples))
+	for _, s := range b.strings {
+		b.out.bytes(pprofStringTable, []byte(s))
+	}
+	return b.out.data
+}
+
+func (b *pprofBuilder) sample(locs []uint64, n uint64) {
+	b.out.message(pprofSample, func(m *protoBuffer) {
+		m.packed(1, locs)
+		m.packed(2, []uint64{n})
+	})
+}
+
+func (b *pprofBuilder) location(loc pprofLoc) uint64 {
+	if id, ok := b.locs[loc]; ok {
+		return id
+	}
+	id := uint64(len(b.locOrder) + 1)
+	b.locs[loc] = id
+	b.locOrder = append(b.locOrder, loc)
+	return id
+}
+
+func (b *pprofBuilder) function(f pprofFunc) uint64 {
+	if id, ok := b.funcs[f]; ok {
+		return id
+	}
+	id := uint64(len(b.funcOrder) + 1)
+	b.funcs[f] = id
+	b.funcOrder = append(b.funcOrder, f)
+	return id
+}
+
+func (b *pprofBuilder) string(s string) int {
+	if id, ok := b.stringIDs[s]; ok {
+		return id
+	}
+	id := len(b.strings)
+	b.stringIDs[s] = id
+	b.strings = append(b.strings, s)
+	return id
+}
+
+func funcName(fn int) string {
+	return "func" + strconv.Itoa(fn)
+}
+
+// protoBuffer appends protobuf wire-format fields.
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) tag(field, wire int) {
+	b.data = binary.AppendUvarint(b.data, uint64(field)<<3|uint64(wire))
+}
+
+func (b *protoBuffer) varint(field int, v uint64) {
+	b.tag(field, 0)
+	b.data = binary.AppendUvarint(b.data, v)
+}
+
+func (b *protoBuffer) bytes(field int, v []byte) {
+	b.tag(field, 2)
+	b.data = binary.AppendUvarint(b.data, uint64(len(v)))
+	b.data = append(b.data, v...)
+}
+
+func (b *protoBuffer) packed(field int, vs []uint64) {
+	var body []byte
+	for _, v := range vs {
+		body = binary.AppendUvarint(body, v)
+	}
+	b.bytes(field, body)
+}
+
+func (b *protoBuffer) message(field int, fn func(*protoBuffer)) {
+	var m protoBuffer
+	fn(&m)
+	b.bytes(field, m.data)
+}
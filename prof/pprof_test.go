@@ -0,0 +1,97 @@
+package prof_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/prof"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfiler_WritePprof(t *testing.T) {
+	t.Run("writes call stacks", func(t *testing.T) {
+		local := prof.NewCollector()
+		stack := []prof.Frame{
+			{Func: 1, IP: 3, Op: byte(instr.I32_ADD)},
+			{Func: 0, IP: 9, Op: byte(instr.CALL)},
+		}
+		local.AddStack(stack)
+		local.AddStack(stack)
+		local.AddStack(stack[1:])
+		profiler := prof.New()
+		profiler.Flush(local)
+
+		var buf bytes.Buffer
+		require.NoError(t, profiler.WritePprof(&buf))
+		fields := decodePprof(t, buf.Bytes())
+
+		require.Len(t, fields[2], 2)
+		require.Len(t, fields[4], 2)
+		require.Len(t, fields[5], 4)
+		strs := texts(fields[6])
+		require.Contains(t, strs, "func0")
+		require.Contains(t, strs, "func1")
+		require.Contains(t, strs, "i32.add")
+		require.Contains(t, strs, "call")
+	})
+
+	t.Run("falls back to leaf samples", func(t *testing.T) {
+		local := prof.NewCollector()
+		local.Add(4, 2, byte(instr.NOP))
+		profiler := prof.New()
+		profiler.Flush(local)
+
+		var buf bytes.Buffer
+		require.NoError(t, profiler.WritePprof(&buf))
+		fields := decodePprof(t, buf.Bytes())
+
+		require.Len(t, fields[2], 1)
+		require.Contains(t, texts(fields[6]), "func4")
+		require.NotContains(t, texts(fields[6]), "nop")
+	})
+}
+
+// decodePprof gunzips a profile and groups its top-level length-delimited
+// fields by field number.
+func decodePprof(t *testing.T, data []byte) map[uint64][][]byte {
+	t.Helper()
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	raw, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	fields := map[uint64][][]byte{}
+	for len(raw) > 0 {
+		tag, n := binary.Uvarint(raw)
+		require.Positive(t, n)
+		raw = raw[n:]
+		switch tag & 7 {
+		case 0:
+			_, n = binary.Uvarint(raw)
+			require.Positive(t, n)
+			raw = raw[n:]
+		case 2:
+			size, n := binary.Uvarint(raw)
+			require.Positive(t, n)
+			raw = raw[n:]
+			fields[tag>>3] = append(fields[tag>>3], raw[:size])
+			raw = raw[size:]
+		default:
+			t.Fatalf("unexpected wire type %d", tag&7)
+		}
+	}
+	return fields
+}
+
+// texts converts raw protobuf string fields to strings.
+func texts(values [][]byte) []string {
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = string(v)
+	}
+	return out
+}
@@ -1,11 +1,24 @@
 package prof
 
-import "sync"
+import (
+	"slices"
+	"sync"
+)
 
 // Profiler aggregates collector snapshots from interpreters.
 type Profiler struct {
-	data Collector
-	mu   sync.Mutex
+	data    Collector
+	modules map[any]int
+	symbols []func(fn, ip int) (Symbol, bool)
+	mu      sync.Mutex
+}
+
+// Symbol is the source-level identity of one guest instruction: the name of
+// its function and the file and line it came from. Empty fields are unknown.
+type Symbol struct {
+	Name string
+	File string
+	Line int
 }
 
 func New() *Profiler {
@@ -33,3 +46,66 @@ func (p *Profiler) Metrics() []Metric {
 	defer p.mu.Unlock()
 	return p.data.Metrics()
 }
+
+// Samples returns the samples aggregated for function address fn.
+func (p *Profiler) Samples(fn int) uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.data.Samples(fn)
+}
+
+// IP returns the samples aggregated at instruction offset ip of function
+// address fn.
+func (p *Profiler) IP(fn, ip int) uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.data.IP(fn, ip)
+}
+
+// Stacks calls fn for every aggregated call stack with its sample count, leaf
+// frame first, in the order Collector.Stacks gives. It copies the stacks out
+// before the first call, so fn may call back into p.
+func (p *Profiler) Stacks(fn func(stack []Frame, count uint64)) {
+	type entry struct {
+		stack []Frame
+		count uint64
+	}
+	var entries []entry
+	p.mu.Lock()
+	p.data.Stacks(func(stack []Frame, count uint64) {
+		entries = append(entries, entry{stack: slices.Clone(stack), count: count})
+	})
+	p.mu.Unlock()
+	for _, e := range entries {
+		fn(e.stack, e.count)
+	}
+}
+
+// Symbolize numbers the program key identifies and installs lookup, which may
+// be nil, as the one WritePprof names that program's functions and places
+// their instructions with. Sampled frames carry the number as Frame.Module, so
+// interpreters running different programs into one profiler keep their names
+// apart. key must be comparable; a key already numbered keeps its number and
+// first lookup. An interpreter created with WithProfiler registers its program
+// this way.
+func (p *Profiler) Symbolize(key any, lookup func(fn, ip int) (Symbol, bool)) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if m, ok := p.modules[key]; ok {
+		return m
+	}
+	if p.modules == nil {
+		p.modules = map[any]int{}
+	}
+	p.symbols = append(p.symbols, lookup)
+	p.modules[key] = len(p.symbols)
+	return len(p.symbols)
+}
+
+// symbol looks up the symbol of instruction ip of function fn in module m.
+func (p *Profiler) symbol(m, fn, ip int) (Symbol, bool) {
+	if m <= 0 || m > len(p.symbols) || p.symbols[m-1] == nil {
+		return Symbol{}, false
+	}
+	return p.symbols[m-1](fn, ip)
+}
@@ -1,6 +1,7 @@
 package prof_test
 
 import (
+	"bytes"
 	"sync"
 	"testing"
 
@@ -83,6 +84,57 @@ func TestProfiler_Flush(t *testing.T) {
 	})
 }
 
+func TestProfiler_Symbolize(t *testing.T) {
+	t.Run("names the functions of a module", func(t *testing.T) {
+		profiler := prof.New()
+		m := profiler.Symbolize("lib", func(fn, ip int) (prof.Symbol, bool) {
+			if fn != 1 {
+				return prof.Symbol{}, false
+			}
+			return prof.Symbol{Name: "add", File: "lib.mvm", Line: 12}, true
+		})
+		local := prof.NewCollector()
+		local.AddStack([]prof.Frame{
+			{Module: m, Func: 1, IP: 3, Op: byte(instr.I32_ADD)},
+			{Module: m, Func: 0, IP: 9, Op: byte(instr.CALL)},
+		})
+		profiler.Flush(local)
+
+		var buf bytes.Buffer
+		require.NoError(t, profiler.WritePprof(&buf))
+		fields := decodePprof(t, buf.Bytes())
+
+		strs := texts(fields[6])
+		require.Contains(t, strs, "add")
+		require.Contains(t, strs, "lib.mvm")
+		require.Contains(t, strs, "func0")
+		require.NotContains(t, strs, "func1")
+	})
+
+	t.Run("keeps modules apart", func(t *testing.T) {
+		profiler := prof.New()
+		named := func(name string) func(fn, ip int) (prof.Symbol, bool) {
+			return func(fn, ip int) (prof.Symbol, bool) { return prof.Symbol{Name: name}, true }
+		}
+		a := profiler.Symbolize("a", named("fa"))
+		b := profiler.Symbolize("b", named("fb"))
+		require.NotEqual(t, a, b)
+		require.Equal(t, a, profiler.Symbolize("a", named("other")))
+
+		local := prof.NewCollector()
+		local.AddStack([]prof.Frame{{Module: a, Func: 1, IP: 0, Op: byte(instr.NOP)}})
+		local.AddStack([]prof.Frame{{Module: b, Func: 1, IP: 0, Op: byte(instr.NOP)}})
+		profiler.Flush(local)
+
+		var buf bytes.Buffer
+		require.NoError(t, profiler.WritePprof(&buf))
+		strs := texts(decodePprof(t, buf.Bytes())[6])
+		require.Contains(t, strs, "fa")
+		require.Contains(t, strs, "fb")
+		require.NotContains(t, strs, "other")
+	})
+}
+
 func TestProfiler_Metrics(t *testing.T) {
 	local := prof.NewCollector()
 	local.Add(0, 0, byte(instr.I32_CONST))
@@ -91,6 +143,44 @@ func TestProfiler_Metrics(t *testing.T) {
 	require.Contains(t, profiler.Metrics(), prof.Metric{Name: "vm_samples_total", Value: 1})
 }
 
+func TestProfiler_Samples(t *testing.T) {
+	local := prof.NewCollector()
+	local.Add(2, 0, byte(instr.NOP))
+	local.Add(2, 1, byte(instr.NOP))
+	profiler := prof.New()
+	profiler.Flush(local)
+	require.Equal(t, uint64(2), profiler.Samples(2))
+	require.Zero(t, profiler.Samples(3))
+}
+
+func TestProfiler_IP(t *testing.T) {
+	local := prof.NewCollector()
+	local.Add(2, 5, byte(instr.NOP))
+	profiler := prof.New()
+	profiler.Flush(local)
+	require.Equal(t, uint64(1), profiler.IP(2, 5))
+	require.Zero(t, profiler.IP(2, 4))
+}
+
+func TestProfiler_Stacks(t *testing.T) {
+	local := prof.NewCollector()
+	leaf := []prof.Frame{{Func: 1, IP: 3, Op: byte(instr.NOP)}}
+	caller := []prof.Frame{{Func: 0, IP: 9, Op: byte(instr.CALL)}}
+	local.Add(1, 3, byte(instr.NOP))
+	local.AddStack(caller)
+	local.AddStack(leaf)
+	local.AddStack(leaf)
+	profiler := prof.New()
+	profiler.Flush(local)
+
+	var got [][]prof.Frame
+	profiler.Stacks(func(s []prof.Frame, count uint64) {
+		got = append(got, s)
+		require.Equal(t, uint64(1), profiler.Samples(1))
+	})
+	require.Equal(t, [][]prof.Frame{leaf, caller}, got)
+}
+
 func TestProfiler_Metric(t *testing.T) {
 	local := prof.NewCollector()
 	local.AddMetric("custom", 3)
@@ -18,6 +18,8 @@ type Builder struct {
 	typs      []types.Type
 	locals    []types.Type
 	globals   []types.Type
+	exports   []Export
+	imports   []Import
 }
 
 func NewBuilder() *Builder {
@@ -133,6 +135,22 @@ func (b *Builder) Globals(ts ...types.Type) *Builder {
 	return b
 }
 
+// Import declares a host function named name with signature typ and returns
+// the constant index its declaration occupies. Call it through that index like
+// any function constant; the interpreter binds the host function at New.
+func (b *Builder) Import(name string, typ *types.FunctionType) int {
+	idx := len(b.constants)
+	b.constants = append(b.constants, NewDeclaration(typ))
+	b.imports = append(b.imports, Import{Name: name, Typ: typ, Const: idx})
+	return idx
+}
+
+// Export publishes the function constant or global slot at index under name.
+func (b *Builder) Export(name string, kind ExportKind, index int) *Builder {
+	b.exports = append(b.exports, Export{Name: name, Kind: kind, Index: index})
+	return b
+}
+
 // Build resolves every branch and returns the assembled program with its
 // constant and type pools.
 func (b *Builder) Build() (*Program, error) {
@@ -147,5 +165,7 @@ func (b *Builder) Build() (*Program, error) {
 		Constants: b.constants,
 		Types:     b.typs,
 		Handlers:  b.code.Handlers(),
+		Exports:   b.exports,
+		Imports:   b.imports,
 	}, nil
 }
@@ -144,6 +144,35 @@ func TestBuilder_Globals(t *testing.T) {
 	require.Equal(t, []types.Type{types.TypeI32, types.NewArrayType(types.TypeF64)}, prog.Globals)
 }
 
+func TestBuilder_Import(t *testing.T) {
+	sig := &types.FunctionType{Params: []types.Type{types.TypeI32}}
+	b := program.NewBuilder()
+	b.Const(types.I32(1))
+	idx := b.Import("env.log", sig)
+	b.Emit(instr.I32_CONST, 1).Emit(instr.CONST_GET, uint64(idx)).Emit(instr.CALL)
+
+	prog, err := b.Build()
+	require.NoError(t, err)
+	require.Equal(t, 1, idx)
+	require.Equal(t, []program.Import{{Name: "env.log", Typ: sig, Const: 1}}, prog.Imports)
+	require.Equal(t, program.NewDeclaration(sig), prog.Constants[idx])
+	require.NoError(t, program.Verify(prog))
+}
+
+func TestBuilder_Export(t *testing.T) {
+	b := program.NewBuilder()
+	fn := b.Const(types.NewFunctionBuilder(&types.FunctionType{}).Emit(instr.New(instr.RETURN)).MustBuild())
+	b.Globals(types.TypeI32).Export("main", program.ExportFunction, fn).Export("count", program.ExportGlobal, 0)
+
+	prog, err := b.Build()
+	require.NoError(t, err)
+	require.Equal(t, []program.Export{
+		{Name: "main", Kind: program.ExportFunction, Index: fn},
+		{Name: "count", Kind: program.ExportGlobal, Index: 0},
+	}, prog.Exports)
+	require.NoError(t, program.Verify(prog))
+}
+
 func TestBuilder_Build(t *testing.T) {
 	t.Run("assembles code and pools", func(t *testing.T) {
 		b := program.NewBuilder()
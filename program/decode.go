@@ -0,0 +1,614 @@
+package program
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/types"
+)
+
+// maxTypeDepth bounds how deeply a decoded type may nest, so a hostile input
+// cannot exhaust the goroutine stack through recursive array or map types.
+const maxTypeDepth = 64
+
+// decoder reads the binary form of a program from data. The first failure is
+// latched in err; later reads return zero values, so a caller checks err once
+// per logical unit instead of after every field.
+type decoder struct {
+	data  []byte
+	off   int
+	depth int
+	err   error
+}
+
+// Decode reads a program written by Encode. It fails with ErrInvalidMagic when
+// the input does not start with Magic, ErrUnsupportedVersion for a format
+// revision it does not know, and ErrMalformed for any structural damage.
+// Decode does not verify the bytecode; run Verify on the result before
+// executing untrusted input.
+func Decode(r io.Reader) (*Program, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(Magic) || string(data[:len(Magic)]) != Magic {
+		return nil, ErrInvalidMagic
+	}
+	d := &decoder{data: data, off: len(Magic)}
+	if v := d.byte(); d.err != nil || v != Version {
+		if d.err != nil {
+			return nil, d.err
+		}
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, v)
+	}
+
+	prog := &Program{}
+	var last section
+	for d.off < len(d.data) {
+		id := section(d.byte())
+		payload := d.bytes()
+		if d.err != nil {
+			return nil, d.err
+		}
+		if id <= last {
+			return nil, fmt.Errorf("%w: section %d out of order", ErrMalformed, id)
+		}
+		last = id
+
+		s := &decoder{data: payload}
+		switch id {
+		case sectionCode:
+			prog.Code = payload
+			s.off = len(payload)
+		case sectionLocals:
+			prog.Locals = s.types()
+		case sectionGlobals:
+			prog.Globals = s.types()
+		case sectionConstants:
+			prog.Constants = s.values()
+		case sectionTypes:
+			prog.Types = s.types()
+		case sectionHandlers:
+			prog.Handlers = s.handlers()
+		case sectionExports:
+			prog.Exports = s.exports()
+		case sectionImports:
+			prog.Imports = s.imports()
+		case sectionDebug:
+			s.debug(prog)
+		default:
+			return nil, fmt.Errorf("%w: unknown section %d", ErrMalformed, id)
+		}
+		if s.err == nil && s.off != len(s.data) {
+			s.fail("trailing bytes")
+		}
+		if s.err != nil {
+			return nil, fmt.Errorf("section %d: %w", id, s.err)
+		}
+	}
+	return prog, nil
+}
+
+func (d *decoder) fail(format string, args ...any) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s at offset %d", ErrMalformed, fmt.Sprintf(format, args...), d.off)
+	}
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if d.off >= len(d.data) {
+		d.fail("unexpected end of input")
+		return 0
+	}
+	b := d.data[d.off]
+	d.off++
+	return b
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data[d.off:])
+	if n <= 0 {
+		d.fail("invalid uvarint")
+		return 0
+	}
+	d.off += n
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data[d.off:])
+	if n <= 0 {
+		d.fail("invalid varint")
+		return 0
+	}
+	d.off += n
+	return v
+}
+
+// count reads an element count and rejects one the remaining input cannot
+// possibly hold given that each element takes at least width bytes, so a
+// forged count never drives a large allocation.
+func (d *decoder) count(width int) int {
+	n := d.uvarint()
+	if d.err != nil {
+		return 0
+	}
+	if n > uint64(len(d.data)-d.off)/uint64(width) {
+		d.fail("count %d exceeds input", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) int() int {
+	v := d.varint()
+	if v < math.MinInt32 || v > math.MaxInt32 {
+		d.fail("integer %d out of range", v)
+		return 0
+	}
+	return int(v)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.count(1)
+	if d.err != nil {
+		return nil
+	}
+	b := d.data[d.off : d.off+n : d.off+n]
+	d.off += n
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) u32() uint32 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data)-d.off < 4 {
+		d.fail("unexpected end of input")
+		return 0
+	}
+	v := binary.LittleEndian.Uint32(d.data[d.off:])
+	d.off += 4
+	return v
+}
+
+func (d *decoder) u64() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data)-d.off < 8 {
+		d.fail("unexpected end of input")
+		return 0
+	}
+	v := binary.LittleEndian.Uint64(d.data[d.off:])
+	d.off += 8
+	return v
+}
+
+func (d *decoder) handlers() []instr.Handler {
+	n := d.count(4)
+	if n == 0 {
+		return nil
+	}
+	hs := make([]instr.Handler, n)
+	for i := range hs {
+		hs[i] = instr.Handler{Start: d.int(), End: d.int(), Catch: d.int(), Depth: d.int()}
+	}
+	if d.err != nil {
+		return nil
+	}
+	return hs
+}
+
+func (d *decoder) exports() []Export {
+	n := d.count(3)
+	if n == 0 {
+		return nil
+	}
+	es := make([]Export, n)
+	for i := range es {
+		name := d.string()
+		kind := ExportKind(d.byte())
+		if kind > ExportGlobal && d.err == nil {
+			d.fail("unknown export kind %d", kind)
+		}
+		es[i] = Export{Name: name, Kind: kind, Index: d.int()}
+	}
+	if d.err != nil {
+		return nil
+	}
+	return es
+}
+
+func (d *decoder) imports() []Import {
+	n := d.count(3)
+	if n == 0 {
+		return nil
+	}
+	is := make([]Import, n)
+	for i := range is {
+		name := d.string()
+		idx := d.int()
+		typ, ok := d.typ().(*types.FunctionType)
+		if !ok && d.err == nil {
+			d.fail("import type is not a function type")
+		}
+		is[i] = Import{Name: name, Typ: typ, Const: idx}
+	}
+	if d.err != nil {
+		return nil
+	}
+	return is
+}
+
+// debug reads the debug-info section into prog. It runs after the constants
+// section, so it attaches each function's debug info to its constant in place.
+func (d *decoder) debug(prog *Program) {
+	code := d.functionDebug()
+	var globals []string
+	if n := d.count(1); n > 0 {
+		globals = make([]string, n)
+		for i := range globals {
+			globals[i] = d.string()
+		}
+	}
+	if code != nil || len(globals) > 0 {
+		prog.Debug = &Debug{Code: code, Globals: globals}
+	}
+
+	n := d.count(2)
+	for range n {
+		idx := d.int()
+		fd := d.functionDebug()
+		if d.err != nil {
+			return
+		}
+		if idx < 0 || idx >= len(prog.Constants) {
+			d.fail("debug constant %d out of range", idx)
+			return
+		}
+		fn, ok := prog.Constants[idx].(*types.Function)
+		if !ok {
+			d.fail("debug constant %d is not a function", idx)
+			return
+		}
+		fn.Debug = fd
+	}
+}
+
+func (d *decoder) functionDebug() *types.Debug {
+	switch d.byte() {
+	case 0:
+		return nil
+	case 1:
+	default:
+		d.fail("invalid debug presence")
+		return nil
+	}
+	fd := &types.Debug{Name: d.string()}
+	if n := d.count(1); n > 0 {
+		fd.Locals = make([]string, n)
+		for i := range fd.Locals {
+			fd.Locals[i] = d.string()
+		}
+	}
+	if n := d.count(4); n > 0 {
+		fd.Lines = make([]types.Line, n)
+		for i := range fd.Lines {
+			ip := d.int()
+			if i > 0 && ip <= fd.Lines[i-1].IP && d.err == nil {
+				d.fail("debug line at %d is not after %d", ip, fd.Lines[i-1].IP)
+			}
+			fd.Lines[i] = types.Line{IP: ip, Pos: types.Position{File: d.string(), Line: d.int(), Column: d.int()}}
+		}
+	}
+	if d.err != nil {
+		return nil
+	}
+	return fd
+}
+
+func (d *decoder) types() []types.Type {
+	n := d.count(1)
+	if n == 0 {
+		return nil
+	}
+	ts := make([]types.Type, n)
+	for i := range ts {
+		ts[i] = d.typ()
+	}
+	if d.err != nil {
+		return nil
+	}
+	return ts
+}
+
+func (d *decoder) typ() types.Type {
+	if d.depth >= maxTypeDepth {
+		d.fail("type nesting exceeds %d", maxTypeDepth)
+		return nil
+	}
+	d.depth++
+	defer func() { d.depth-- }()
+
+	switch tag := d.byte(); tag {
+	case tagI1:
+		return types.TypeI1
+	case tagI8:
+		return types.TypeI8
+	case tagI32:
+		return types.TypeI32
+	case tagI64:
+		return types.TypeI64
+	case tagF32:
+		return types.TypeF32
+	case tagF64:
+		return types.TypeF64
+	case tagAny:
+		return types.TypeAny
+	case tagString:
+		return types.TypeString
+	case tagError:
+		return types.TypeError
+	case tagArray:
+		elem := d.typ()
+		if d.err != nil {
+			return nil
+		}
+		return types.NewArrayType(elem)
+	case tagIterator:
+		elem := d.typ()
+		if d.err != nil {
+			return nil
+		}
+		return types.NewIteratorType(elem)
+	case tagMap:
+		key := d.typ()
+		elem := d.typ()
+		if d.err != nil {
+			return nil
+		}
+		return types.NewMapType(key, elem)
+	case tagFunction:
+		return d.signature()
+	case tagStruct:
+		n := d.count(2)
+		var fields []types.StructField
+		for range n {
+			name := d.string()
+			typ := d.typ()
+			if d.err != nil {
+				return nil
+			}
+			fields = append(fields, types.NewStructField(typ, types.FieldWithName(name)))
+		}
+		if d.err != nil {
+			return nil
+		}
+		return types.NewStructType(fields...)
+	default:
+		if d.err == nil {
+			d.fail("unknown type tag %d", tag)
+		}
+		return nil
+	}
+}
+
+func (d *decoder) signature() *types.FunctionType {
+	params := d.types()
+	returns := d.types()
+	if d.err != nil {
+		return nil
+	}
+	return &types.FunctionType{Params: params, Returns: returns}
+}
+
+func (d *decoder) values() []types.Value {
+	n := d.count(1)
+	if n == 0 {
+		return nil
+	}
+	vs := make([]types.Value, n)
+	for i := range vs {
+		vs[i] = d.value()
+		if d.err != nil {
+			d.err = fmt.Errorf("constant %d: %w", i, d.err)
+			return nil
+		}
+	}
+	return vs
+}
+
+func (d *decoder) value() types.Value {
+	switch tag := d.byte(); tag {
+	case valueI1:
+		switch b := d.byte(); b {
+		case 0:
+			return types.I1(false)
+		case 1:
+			return types.I1(true)
+		default:
+			d.fail("invalid i1 %d", b)
+			return nil
+		}
+	case valueI8:
+		return types.I8(int8(d.byte()))
+	case valueI32:
+		v := d.varint()
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			d.fail("i32 %d out of range", v)
+			return nil
+		}
+		return types.I32(v)
+	case valueI64:
+		return types.I64(d.varint())
+	case valueF32:
+		return types.F32(math.Float32frombits(d.u32()))
+	case valueF64:
+		return types.F64(math.Float64frombits(d.u64()))
+	case valueRef:
+		return types.Ref(d.int())
+	case valueBoxed:
+		return types.Boxed(d.u64())
+	case valueString:
+		return types.String(d.string())
+	case valueFunction:
+		return d.function()
+	case valueClosure:
+		typ, ok := d.typ().(*types.FunctionType)
+		if !ok && d.err == nil {
+			d.fail("closure type is not a function type")
+		}
+		fn := types.Ref(d.int())
+		n := d.count(8)
+		var upvals []types.Boxed
+		for range n {
+			upvals = append(upvals, types.Boxed(d.u64()))
+		}
+		if d.err != nil {
+			return nil
+		}
+		return types.NewClosure(typ, fn, upvals)
+	case valueArray:
+		typ, ok := d.typ().(*types.ArrayType)
+		if !ok && d.err == nil {
+			d.fail("array type is not an array type")
+		}
+		n := d.count(8)
+		var elems []types.Boxed
+		for range n {
+			elems = append(elems, types.Boxed(d.u64()))
+		}
+		if d.err != nil {
+			return nil
+		}
+		return types.NewArray(typ, elems...)
+	case valueTypedArray:
+		return d.typedArray()
+	case valueStruct:
+		typ, ok := d.typ().(*types.StructType)
+		if !ok && d.err == nil {
+			d.fail("struct type is not a struct type")
+		}
+		if d.err != nil {
+			return nil
+		}
+		if len(typ.Fields) > (len(d.data)-d.off)/8 {
+			d.fail("struct data exceeds input")
+			return nil
+		}
+		s := types.NewStruct(typ)
+		for i := range typ.Fields {
+			s.SetRaw(i, d.u64())
+		}
+		return s
+	case valueError:
+		code := types.ErrorCode(d.int())
+		message := d.string()
+		value := types.Boxed(d.u64())
+		if d.err != nil {
+			return nil
+		}
+		return types.NewError(code, message, value)
+	default:
+		if d.err == nil {
+			d.fail("unknown value tag %d", tag)
+		}
+		return nil
+	}
+}
+
+func (d *decoder) function() *types.Function {
+	typ := d.signature()
+	locals := d.types()
+	captures := d.types()
+	code := d.bytes()
+	handlers := d.handlers()
+	if d.err != nil {
+		return nil
+	}
+	if len(code) == 0 {
+		code = nil
+	}
+	return &types.Function{Typ: typ, Locals: locals, Captures: captures, Code: code, Handlers: handlers}
+}
+
+func (d *decoder) typedArray() types.Value {
+	tag := d.byte()
+	switch tag {
+	case tagI1:
+		n := d.count(1)
+		out := make(types.TypedArray[bool], 0, n)
+		for range n {
+			b := d.byte()
+			if b > 1 {
+				d.fail("invalid i1 %d", b)
+			}
+			out = append(out, b == 1)
+		}
+		return typedResult(d, out)
+	case tagI8:
+		n := d.count(1)
+		out := make(types.TypedArray[int8], 0, n)
+		for range n {
+			out = append(out, int8(d.byte()))
+		}
+		return typedResult(d, out)
+	case tagI32:
+		n := d.count(4)
+		out := make(types.TypedArray[int32], 0, n)
+		for range n {
+			out = append(out, int32(d.u32()))
+		}
+		return typedResult(d, out)
+	case tagI64:
+		n := d.count(8)
+		out := make(types.TypedArray[int64], 0, n)
+		for range n {
+			out = append(out, int64(d.u64()))
+		}
+		return typedResult(d, out)
+	case tagF32:
+		n := d.count(4)
+		out := make(types.TypedArray[float32], 0, n)
+		for range n {
+			out = append(out, math.Float32frombits(d.u32()))
+		}
+		return typedResult(d, out)
+	case tagF64:
+		n := d.count(8)
+		out := make(types.TypedArray[float64], 0, n)
+		for range n {
+			out = append(out, math.Float64frombits(d.u64()))
+		}
+		return typedResult(d, out)
+	default:
+		if d.err == nil {
+			d.fail("unknown typed array element tag %d", tag)
+		}
+		return nil
+	}
+}
+
+func typedResult[T int8 | int32 | int64 | float32 | float64 | bool](d *decoder, out types.TypedArray[T]) types.Value {
+	if d.err != nil {
+		return nil
+	}
+	return out
+}
@@ -0,0 +1,77 @@
+package program_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecode(t *testing.T) {
+	encoded := func(t *testing.T, prog *program.Program) []byte {
+		var buf bytes.Buffer
+		require.NoError(t, program.Encode(&buf, prog))
+		return buf.Bytes()
+	}
+
+	t.Run("rejects text dump", func(t *testing.T) {
+		_, err := program.Decode(strings.NewReader(".code\n0000:\tnop\n"))
+		require.ErrorIs(t, err, program.ErrInvalidMagic)
+	})
+
+	t.Run("rejects unknown version", func(t *testing.T) {
+		_, err := program.Decode(strings.NewReader(program.Magic + "\x7f"))
+		require.ErrorIs(t, err, program.ErrUnsupportedVersion)
+	})
+
+	t.Run("rejects truncated section", func(t *testing.T) {
+		data := encoded(t, program.New([]instr.Instruction{instr.New(instr.I32_CONST, 1)}))
+		_, err := program.Decode(bytes.NewReader(data[:len(data)-1]))
+		require.ErrorIs(t, err, program.ErrMalformed)
+	})
+
+	t.Run("rejects duplicate section", func(t *testing.T) {
+		data := encoded(t, program.New([]instr.Instruction{instr.New(instr.NOP)}))
+		data = append(data, data[len(program.Magic)+1:]...)
+		_, err := program.Decode(bytes.NewReader(data))
+		require.ErrorIs(t, err, program.ErrMalformed)
+	})
+
+	t.Run("rejects unknown export kind", func(t *testing.T) {
+		data := []byte(program.Magic + "\x01")
+		data = append(data, 0x07, 0x05, 0x01, 0x01, 'x', 0x09, 0x00)
+		_, err := program.Decode(bytes.NewReader(data))
+		require.ErrorIs(t, err, program.ErrMalformed)
+	})
+
+	t.Run("rejects forged count", func(t *testing.T) {
+		data := []byte(program.Magic + "\x01")
+		data = append(data, 0x05, 0x05, 0xff, 0xff, 0xff, 0xff, 0x0f)
+		_, err := program.Decode(bytes.NewReader(data))
+		require.ErrorIs(t, err, program.ErrMalformed)
+	})
+
+	t.Run("rejects deeply nested type", func(t *testing.T) {
+		payload := append([]byte{0x01}, bytes.Repeat([]byte{0x0a}, 100)...)
+		payload = append(payload, 0x03)
+		data := append([]byte(program.Magic+"\x01"), 0x05, byte(len(payload)))
+		data = append(data, payload...)
+		_, err := program.Decode(bytes.NewReader(data))
+		require.ErrorIs(t, err, program.ErrMalformed)
+	})
+
+	t.Run("decoded program verifies and runs the same code", func(t *testing.T) {
+		p0 := program.New(
+			[]instr.Instruction{instr.New(instr.I32_CONST, 6), instr.New(instr.I32_CONST, 7), instr.New(instr.I32_MUL)},
+			program.WithGlobals(types.TypeI32),
+		)
+		p1, err := program.Decode(bytes.NewReader(encoded(t, p0)))
+		require.NoError(t, err)
+		require.NoError(t, program.Verify(p1))
+		require.Equal(t, p0.String(), p1.String())
+	})
+}
@@ -0,0 +1,486 @@
+package program
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/types"
+)
+
+// section identifies one top-level block of a binary program. Each section is
+// written as its id, a uvarint payload length, and the payload, in ascending id
+// order; empty sections are omitted.
+type section byte
+
+// encoder appends the binary form of a program to buf.
+type encoder struct {
+	buf []byte
+}
+
+// Magic opens every program in the binary module format. The leading NUL can
+// never start a textual dump, so a reader tells the two forms apart from the
+// first bytes alone.
+const Magic = "\x00mvm"
+
+// Version is the binary module format revision Encode writes and Decode
+// accepts.
+const Version = 1
+
+const (
+	sectionCode section = iota + 1
+	sectionLocals
+	sectionGlobals
+	sectionConstants
+	sectionTypes
+	sectionHandlers
+	sectionExports
+	sectionImports
+	sectionDebug
+)
+
+// Type and value tags are the format's own numbering. They are deliberately
+// independent of types.Kind, whose layout is runtime-only and may change.
+const (
+	tagI1 byte = iota + 1
+	tagI8
+	tagI32
+	tagI64
+	tagF32
+	tagF64
+	tagAny
+	tagString
+	tagError
+	tagArray
+	tagIterator
+	tagMap
+	tagFunction
+	tagStruct
+)
+
+const (
+	valueI1 byte = iota + 1
+	valueI8
+	valueI32
+	valueI64
+	valueF32
+	valueF64
+	valueRef
+	valueBoxed
+	valueString
+	valueFunction
+	valueClosure
+	valueArray
+	valueTypedArray
+	valueStruct
+	valueError
+)
+
+var (
+	ErrInvalidMagic       = errors.New("invalid binary program magic")
+	ErrUnsupportedVersion = errors.New("unsupported binary program version")
+	ErrMalformed          = errors.New("malformed binary program")
+	ErrUnsupportedValue   = errors.New("value has no binary form")
+)
+
+// Encode writes prog to w in the binary module format. Decode reads it back
+// into an equal program. Constants that only exist at run time, such as maps
+// and host values, have no binary form and fail with ErrUnsupportedValue, as
+// does a function without a type, which Decode could not reproduce.
+func Encode(w io.Writer, prog *Program) error {
+	e := &encoder{buf: append(make([]byte, 0, len(Magic)+1+len(prog.Code)), Magic...)}
+	e.buf = append(e.buf, Version)
+
+	if len(prog.Code) > 0 {
+		if err := e.section(sectionCode, func(e *encoder) error {
+			e.buf = append(e.buf, prog.Code...)
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	if len(prog.Locals) > 0 {
+		if err := e.section(sectionLocals, func(e *encoder) error { return e.types(prog.Locals) }); err != nil {
+			return fmt.Errorf("locals: %w", err)
+		}
+	}
+	if len(prog.Globals) > 0 {
+		if err := e.section(sectionGlobals, func(e *encoder) error { return e.types(prog.Globals) }); err != nil {
+			return fmt.Errorf("globals: %w", err)
+		}
+	}
+	if len(prog.Constants) > 0 {
+		if err := e.section(sectionConstants, func(e *encoder) error {
+			e.uvarint(len(prog.Constants))
+			for i, v := range prog.Constants {
+				if err := e.value(v); err != nil {
+					return fmt.Errorf("constant %d: %w", i, err)
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	if len(prog.Types) > 0 {
+		if err := e.section(sectionTypes, func(e *encoder) error { return e.types(prog.Types) }); err != nil {
+			return fmt.Errorf("types: %w", err)
+		}
+	}
+	if len(prog.Handlers) > 0 {
+		if err := e.section(sectionHandlers, func(e *encoder) error {
+			e.handlers(prog.Handlers)
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	if len(prog.Exports) > 0 {
+		if err := e.section(sectionExports, func(e *encoder) error {
+			e.uvarint(len(prog.Exports))
+			for _, ex := range prog.Exports {
+				e.string(ex.Name)
+				e.buf = append(e.buf, byte(ex.Kind))
+				e.varint(int64(ex.Index))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	if len(prog.Imports) > 0 {
+		if err := e.section(sectionImports, func(e *encoder) error {
+			e.uvarint(len(prog.Imports))
+			for i, imp := range prog.Imports {
+				e.string(imp.Name)
+				e.varint(int64(imp.Const))
+				if imp.Typ == nil {
+					return fmt.Errorf("import %d: %w: nil type", i, ErrUnsupportedValue)
+				}
+				if err := e.typ(imp.Typ); err != nil {
+					return fmt.Errorf("import %d: %w", i, err)
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	if prog.hasDebug() {
+		if err := e.section(sectionDebug, func(e *encoder) error {
+			e.debug(prog)
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(e.buf)
+	return err
+}
+
+// section writes one section: its id followed by the length-prefixed payload
+// body produces.
+func (e *encoder) section(id section, body func(*encoder) error) error {
+	payload := &encoder{}
+	if err := body(payload); err != nil {
+		return err
+	}
+	e.buf = append(e.buf, byte(id))
+	e.bytes(payload.buf)
+	return nil
+}
+
+func (e *encoder) uvarint(v int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(v))
+}
+
+func (e *encoder) varint(v int64) {
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(len(b))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) string(s string) {
+	e.uvarint(len(s))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) u32(v uint32) {
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, v)
+}
+
+func (e *encoder) u64(v uint64) {
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, v)
+}
+
+// debug writes the debug-info section: the top-level function's debug info,
+// the global names, and the debug info of each function constant keyed by its
+// constant index.
+func (e *encoder) debug(prog *Program) {
+	var code *types.Debug
+	var globals []string
+	if prog.Debug != nil {
+		code, globals = prog.Debug.Code, prog.Debug.Globals
+	}
+	e.functionDebug(code)
+	e.uvarint(len(globals))
+	for _, name := range globals {
+		e.string(name)
+	}
+
+	var consts []int
+	for i, v := range prog.Constants {
+		if fn, ok := v.(*types.Function); ok && fn.Debug != nil {
+			consts = append(consts, i)
+		}
+	}
+	e.uvarint(len(consts))
+	for _, i := range consts {
+		e.varint(int64(i))
+		e.functionDebug(prog.Constants[i].(*types.Function).Debug)
+	}
+}
+
+// functionDebug writes one function's debug info, led by a presence byte.
+func (e *encoder) functionDebug(d *types.Debug) {
+	if d == nil {
+		e.buf = append(e.buf, 0)
+		return
+	}
+	e.buf = append(e.buf, 1)
+	e.string(d.Name)
+	e.uvarint(len(d.Locals))
+	for _, name := range d.Locals {
+		e.string(name)
+	}
+	e.uvarint(len(d.Lines))
+	for _, l := range d.Lines {
+		e.varint(int64(l.IP))
+		e.string(l.Pos.File)
+		e.varint(int64(l.Pos.Line))
+		e.varint(int64(l.Pos.Column))
+	}
+}
+
+func (e *encoder) handlers(hs []instr.Handler) {
+	e.uvarint(len(hs))
+	for _, h := range hs {
+		e.varint(int64(h.Start))
+		e.varint(int64(h.End))
+		e.varint(int64(h.Catch))
+		e.varint(int64(h.Depth))
+	}
+}
+
+func (e *encoder) types(ts []types.Type) error {
+	e.uvarint(len(ts))
+	for _, t := range ts {
+		if err := e.typ(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) typ(t types.Type) error {
+	switch t := t.(type) {
+	case nil:
+		return fmt.Errorf("%w: nil type", ErrUnsupportedValue)
+	case *types.ArrayType:
+		e.buf = append(e.buf, tagArray)
+		return e.typ(t.Elem)
+	case *types.IteratorType:
+		e.buf = append(e.buf, tagIterator)
+		return e.typ(t.Elem)
+	case *types.MapType:
+		e.buf = append(e.buf, tagMap)
+		if err := e.typ(t.Key); err != nil {
+			return err
+		}
+		return e.typ(t.Elem)
+	case *types.FunctionType:
+		e.buf = append(e.buf, tagFunction)
+		if err := e.types(t.Params); err != nil {
+			return err
+		}
+		return e.types(t.Returns)
+	case *types.StructType:
+		e.buf = append(e.buf, tagStruct)
+		e.uvarint(len(t.Fields))
+		for _, f := range t.Fields {
+			e.string(f.Name)
+			if err := e.typ(f.Type); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	switch {
+	case t.Equals(types.TypeI1):
+		e.buf = append(e.buf, tagI1)
+	case t.Equals(types.TypeI8):
+		e.buf = append(e.buf, tagI8)
+	case t.Equals(types.TypeI32):
+		e.buf = append(e.buf, tagI32)
+	case t.Equals(types.TypeI64):
+		e.buf = append(e.buf, tagI64)
+	case t.Equals(types.TypeF32):
+		e.buf = append(e.buf, tagF32)
+	case t.Equals(types.TypeF64):
+		e.buf = append(e.buf, tagF64)
+	case t.Equals(types.TypeAny):
+		e.buf = append(e.buf, tagAny)
+	case t.Equals(types.TypeString):
+		e.buf = append(e.buf, tagString)
+	case t.Equals(types.TypeError):
+		e.buf = append(e.buf, tagError)
+	default:
+		return fmt.Errorf("%w: type %s", ErrUnsupportedValue, t)
+	}
+	return nil
+}
+
+func (e *encoder) value(v types.Value) error {
+	switch v := v.(type) {
+	case types.I1:
+		e.buf = append(e.buf, valueI1)
+		if v {
+			e.buf = append(e.buf, 1)
+		} else {
+			e.buf = append(e.buf, 0)
+		}
+	case types.I8:
+		e.buf = append(e.buf, valueI8, byte(v))
+	case types.I32:
+		e.buf = append(e.buf, valueI32)
+		e.varint(int64(v))
+	case types.I64:
+		e.buf = append(e.buf, valueI64)
+		e.varint(int64(v))
+	case types.F32:
+		e.buf = append(e.buf, valueF32)
+		e.u32(math.Float32bits(float32(v)))
+	case types.F64:
+		e.buf = append(e.buf, valueF64)
+		e.u64(math.Float64bits(float64(v)))
+	case types.Ref:
+		e.buf = append(e.buf, valueRef)
+		e.varint(int64(v))
+	case types.Boxed:
+		e.buf = append(e.buf, valueBoxed)
+		e.u64(uint64(v))
+	case types.String:
+		e.buf = append(e.buf, valueString)
+		e.string(string(v))
+	case *types.Function:
+		e.buf = append(e.buf, valueFunction)
+		return e.function(v)
+	case *types.Closure:
+		e.buf = append(e.buf, valueClosure)
+		if err := e.typ(v.Typ); err != nil {
+			return err
+		}
+		e.varint(int64(v.Fn))
+		e.uvarint(len(v.Upvals))
+		for _, u := range v.Upvals {
+			e.u64(uint64(u))
+		}
+	case *types.Array:
+		e.buf = append(e.buf, valueArray)
+		if err := e.typ(v.Typ); err != nil {
+			return err
+		}
+		e.uvarint(len(v.Elems))
+		for _, elem := range v.Elems {
+			e.u64(uint64(elem))
+		}
+	case types.TypedArray[bool]:
+		e.buf = append(e.buf, valueTypedArray, tagI1)
+		e.uvarint(len(v))
+		for _, x := range v {
+			if x {
+				e.buf = append(e.buf, 1)
+			} else {
+				e.buf = append(e.buf, 0)
+			}
+		}
+	case types.TypedArray[int8]:
+		e.buf = append(e.buf, valueTypedArray, tagI8)
+		e.uvarint(len(v))
+		for _, x := range v {
+			e.buf = append(e.buf, byte(x))
+		}
+	case types.TypedArray[int32]:
+		e.buf = append(e.buf, valueTypedArray, tagI32)
+		e.uvarint(len(v))
+		for _, x := range v {
+			e.u32(uint32(x))
+		}
+	case types.TypedArray[int64]:
+		e.buf = append(e.buf, valueTypedArray, tagI64)
+		e.uvarint(len(v))
+		for _, x := range v {
+			e.u64(uint64(x))
+		}
+	case types.TypedArray[float32]:
+		e.buf = append(e.buf, valueTypedArray, tagF32)
+		e.uvarint(len(v))
+		for _, x := range v {
+			e.u32(math.Float32bits(x))
+		}
+	case types.TypedArray[float64]:
+		e.buf = append(e.buf, valueTypedArray, tagF64)
+		e.uvarint(len(v))
+		for _, x := range v {
+			e.u64(math.Float64bits(x))
+		}
+	case *types.Struct:
+		e.buf = append(e.buf, valueStruct)
+		if err := e.typ(v.Typ); err != nil {
+			return err
+		}
+		for i := range v.Typ.Fields {
+			e.u64(v.Raw(i))
+		}
+	case *types.Error:
+		e.buf = append(e.buf, valueError)
+		e.varint(int64(v.Code()))
+		e.string(v.Error())
+		e.u64(uint64(v.Value()))
+	default:
+		if v == nil {
+			return fmt.Errorf("%w: nil", ErrUnsupportedValue)
+		}
+		return fmt.Errorf("%w: %T", ErrUnsupportedValue, v)
+	}
+	return nil
+}
+
+func (e *encoder) function(fn *types.Function) error {
+	if fn.Typ == nil {
+		return fmt.Errorf("%w: function without a type", ErrUnsupportedValue)
+	}
+	if err := e.types(fn.Typ.Params); err != nil {
+		return err
+	}
+	if err := e.types(fn.Typ.Returns); err != nil {
+		return err
+	}
+	if err := e.types(fn.Locals); err != nil {
+		return err
+	}
+	if err := e.types(fn.Captures); err != nil {
+		return err
+	}
+	e.bytes(fn.Code)
+	e.handlers(fn.Handlers)
+	return nil
+}
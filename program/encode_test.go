@@ -0,0 +1,143 @@
+package program_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode(t *testing.T) {
+	t.Run("round trip preserves all sections", func(t *testing.T) {
+		fn := types.NewFunctionBuilder(&types.FunctionType{
+			Params:  []types.Type{types.TypeI32},
+			Returns: []types.Type{types.TypeI64},
+		}).
+			Locals(types.TypeI32).
+			Captures(types.TypeF64).
+			Emit(instr.New(instr.I32_CONST, 7), instr.New(instr.I32_TO_I64_S), instr.New(instr.RETURN)).
+			MustBuild()
+		fn.Handlers = []instr.Handler{{Start: 0, End: 5, Catch: 5, Depth: 1}}
+
+		p0 := program.New(
+			[]instr.Instruction{instr.New(instr.CONST_GET, 0), instr.New(instr.CALL)},
+			program.WithLocals(types.TypeI32),
+			program.WithGlobals(types.TypeAny, types.TypeString),
+			program.WithConstants(
+				fn,
+				types.I1(true),
+				types.I8(-3),
+				types.I32(-42),
+				types.I64(1<<40),
+				types.F32(1.5),
+				types.F64(-2.25),
+				types.String("hello"),
+				types.TypedArray[int32]{1, 2, 3},
+				types.NewError(7, "boom", types.BoxedNull),
+			),
+			program.WithTypes(
+				types.NewArrayType(types.TypeI32),
+				types.NewMapType(types.TypeString, types.TypeF64),
+				types.NewIteratorType(types.TypeI32),
+				types.NewStructType(
+					types.NewStructField(types.TypeI32, types.FieldWithName("x")),
+					types.NewStructField(types.TypeError),
+				),
+			),
+			program.WithHandlers(instr.Handler{Start: 0, End: 3, Catch: 4, Depth: 0}),
+		)
+
+		var buf bytes.Buffer
+		require.NoError(t, program.Encode(&buf, p0))
+		require.True(t, bytes.HasPrefix(buf.Bytes(), []byte(program.Magic)))
+
+		p1, err := program.Decode(&buf)
+		require.NoError(t, err)
+		require.Equal(t, p0.Code, p1.Code)
+		require.Equal(t, p0.Locals, p1.Locals)
+		require.Equal(t, p0.Globals, p1.Globals)
+		require.Equal(t, p0.Constants, p1.Constants)
+		require.Equal(t, p0.Types, p1.Types)
+		require.Equal(t, p0.Handlers, p1.Handlers)
+	})
+
+	t.Run("round trip preserves struct values", func(t *testing.T) {
+		typ := types.NewStructType(types.NewStructField(types.TypeI32), types.NewStructField(types.TypeF64))
+		p0 := program.New(nil, program.WithConstants(types.NewStruct(typ, types.BoxI32(1), types.BoxF64(2))))
+
+		var buf bytes.Buffer
+		require.NoError(t, program.Encode(&buf, p0))
+		p1, err := program.Decode(&buf)
+		require.NoError(t, err)
+		require.Equal(t, p0.Constants[0].String(), p1.Constants[0].String())
+	})
+
+	t.Run("round trip preserves exports and imports", func(t *testing.T) {
+		sig := &types.FunctionType{Params: []types.Type{types.TypeString}, Returns: []types.Type{types.TypeI32}}
+		b := program.NewBuilder()
+		idx := b.Import("env.len", sig)
+		b.Globals(types.TypeI32).Export("len", program.ExportFunction, idx).Export("count", program.ExportGlobal, 0)
+		p0, err := b.Build()
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		require.NoError(t, program.Encode(&buf, p0))
+		p1, err := program.Decode(&buf)
+		require.NoError(t, err)
+		require.Equal(t, p0.Exports, p1.Exports)
+		require.Equal(t, p0.Imports, p1.Imports)
+		require.NoError(t, program.Verify(p1))
+	})
+
+	t.Run("round trip preserves debug info", func(t *testing.T) {
+		fn := types.NewFunctionBuilder(&types.FunctionType{Params: []types.Type{types.TypeI32}}).
+			Emit(instr.New(instr.LOCAL_GET, 0), instr.New(instr.DROP), instr.New(instr.RETURN)).
+			MustBuild()
+		fn.Debug = &types.Debug{
+			Name:   "f",
+			Locals: []string{"x"},
+			Lines:  []types.Line{{IP: 0, Pos: types.Position{File: "lib.mvm", Line: 3, Column: 5}}, {IP: 3}},
+		}
+		p0 := program.New(
+			[]instr.Instruction{instr.New(instr.CONST_GET, 1), instr.New(instr.DROP)},
+			program.WithGlobals(types.TypeI32),
+			program.WithConstants(types.I32(1), fn),
+			program.WithDebug(&program.Debug{
+				Code:    &types.Debug{Lines: []types.Line{{IP: 0, Pos: types.Position{File: "main.mvm", Line: 1}}}},
+				Globals: []string{"count"},
+			}),
+		)
+
+		var buf bytes.Buffer
+		require.NoError(t, program.Encode(&buf, p0))
+		p1, err := program.Decode(&buf)
+		require.NoError(t, err)
+		require.Equal(t, p0.Debug, p1.Debug)
+		require.Equal(t, fn.Debug, p1.Constants[1].(*types.Function).Debug)
+	})
+
+	t.Run("empty program", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, program.Encode(&buf, program.New(nil)))
+		require.Equal(t, program.Magic+string(rune(program.Version)), buf.String())
+
+		p1, err := program.Decode(&buf)
+		require.NoError(t, err)
+		require.Equal(t, &program.Program{}, p1)
+	})
+
+	t.Run("rejects map constant", func(t *testing.T) {
+		m := types.NewMap(types.NewMapType(types.TypeI32, types.TypeI32))
+		err := program.Encode(&bytes.Buffer{}, program.New(nil, program.WithConstants(m)))
+		require.ErrorIs(t, err, program.ErrUnsupportedValue)
+	})
+
+	t.Run("rejects function without a type", func(t *testing.T) {
+		fn := &types.Function{Code: instr.Marshal([]instr.Instruction{instr.New(instr.RETURN)})}
+		err := program.Encode(&bytes.Buffer{}, program.New(nil, program.WithConstants(fn)))
+		require.ErrorIs(t, err, program.ErrUnsupportedValue)
+	})
+}
@@ -1,6 +1,7 @@
 package program_test
 
 import (
+	"bytes"
 	"strings"
 	"testing"
 
@@ -44,3 +45,34 @@ func FuzzVerify(f *testing.F) {
 		_ = program.Verify(&program.Program{Code: code})
 	})
 }
+
+func FuzzDecode(f *testing.F) {
+	for _, prog := range []*program.Program{
+		program.New([]instr.Instruction{instr.New(instr.NOP)}),
+		program.New(
+			[]instr.Instruction{instr.New(instr.CONST_GET, 0), instr.New(instr.DROP)}, program.WithConstants(types.String("value"), types.I64(-1)), program.WithLocals(types.TypeI32), program.WithGlobals(types.TypeAny), program.WithTypes(types.NewArrayType(types.TypeI32)),
+		),
+	} {
+		var buf bytes.Buffer
+		require.NoError(f, program.Encode(&buf, prog))
+		f.Add(buf.Bytes())
+	}
+	f.Add([]byte(program.Magic))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) > 64<<10 {
+			t.Skip()
+		}
+		prog, err := program.Decode(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		var first bytes.Buffer
+		require.NoError(t, program.Encode(&first, prog))
+		roundTrip, err := program.Decode(bytes.NewReader(first.Bytes()))
+		require.NoError(t, err)
+		var second bytes.Buffer
+		require.NoError(t, program.Encode(&second, roundTrip))
+		require.Equal(t, first.Bytes(), second.Bytes())
+	})
+}
@@ -11,6 +11,14 @@ import (
 	"github.com/siyul-park/minivm/types"
 )
 
+// sectionText is one section of a sectioned dump: its header and the raw lines up to
+// the next header.
+type sectionText struct {
+	section string
+	line    int
+	lines   []string
+}
+
 const maxParseLineBytes = 1 << 20 // 1 MiB
 
 func Parse(r io.Reader) (*Program, error) {
@@ -39,13 +47,9 @@ func Parse(r io.Reader) (*Program, error) {
 
 func sections(text string) (*Program, error) {
 	lines := strings.Split(text, "\n")
-	prog := &Program{}
 	seen := map[string]int{}
 
-	var section string
-	var sectionLineStart int
-	var sectionLines []string
-
+	var blocks []sectionText
 	for i, rawLine := range lines {
 		lineNum := i + 1
 		line := strings.TrimSpace(rawLine)
@@ -55,40 +59,43 @@ func sections(text string) (*Program, error) {
 		}
 
 		if strings.HasPrefix(line, ".") {
-			if section != "" {
-				if err := parseSection(prog, section, sectionLineStart, sectionLines); err != nil {
-					return nil, err
-				}
-				sectionLines = nil
-			}
 			fields := strings.Fields(line)
-			section = fields[0]
-			sectionLineStart = lineNum
-
+			section := fields[0]
 			if prev := seen[section]; prev > 0 {
 				return nil, fmt.Errorf("line %d: duplicate section %s (first at line %d)", lineNum, section, prev)
 			}
 			seen[section] = lineNum
+			blocks = append(blocks, sectionText{section: section, line: lineNum})
 			continue
 		}
 
-		sectionLines = append(sectionLines, rawLine)
+		if len(blocks) > 0 {
+			blocks[len(blocks)-1].lines = append(blocks[len(blocks)-1].lines, rawLine)
+		}
 	}
 
-	if section != "" {
-		if err := parseSection(prog, section, sectionLineStart, sectionLines); err != nil {
-			return nil, err
+	// Constants are parsed first so that code anywhere in the file, including
+	// the constants' own function bodies, can name them.
+	prog := &Program{}
+	symbols := map[string]int{}
+	for _, constants := range []bool{true, false} {
+		for _, b := range blocks {
+			if (b.section == ".constants") != constants {
+				continue
+			}
+			if err := parseSection(prog, b.section, b.line, b.lines, symbols); err != nil {
+				return nil, err
+			}
 		}
 	}
-
 	return prog, nil
 }
 
-func parseSection(prog *Program, section string, lineStart int, lines []string) error {
+func parseSection(prog *Program, section string, lineStart int, lines []string, symbols map[string]int) error {
 	var err error
 	switch section {
 	case ".code":
-		code, err := instr.ParseAll(strings.NewReader(strings.Join(lines, "\n")))
+		code, err := instr.ParseSymbols(strings.NewReader(strings.Join(lines, "\n")), symbols)
 		if err != nil {
 			return fmt.Errorf("%s (line %d): %w", section, lineStart, err)
 		}
@@ -98,11 +105,17 @@ func parseSection(prog *Program, section string, lineStart int, lines []string)
 	case ".globals":
 		prog.Globals, err = parseTypes(lines)
 	case ".constants":
-		prog.Constants, err = parseConstants(lines)
+		prog.Constants, err = parseConstants(lines, symbols)
 	case ".types":
 		prog.Types, err = parseTypes(lines)
 	case ".handlers":
 		prog.Handlers, err = parseHandlers(lines)
+	case ".exports":
+		prog.Exports, err = parseExports(lines, symbols)
+	case ".imports":
+		prog.Imports, err = parseImports(lines, symbols)
+	case ".debug":
+		err = parseDebug(prog, lines, symbols)
 	default:
 		return fmt.Errorf("line %d: unknown section %s", lineStart, section)
 	}
@@ -228,7 +241,11 @@ func parseTypes(lines []string) ([]types.Type, error) {
 	return result, nil
 }
 
-func parseConstants(lines []string) ([]types.Value, error) {
+// parseConstants parses the .constants section. An entry opens with either
+// its index, "NNNN:", or a name, "$name:"; every name is added to symbols
+// before any function body is parsed, so bodies can refer to each other and to
+// themselves by name.
+func parseConstants(lines []string, symbols map[string]int) ([]types.Value, error) {
 	var entries [][]string
 	var current []string
 	hasCurrent := false
@@ -249,6 +266,14 @@ func parseConstants(lines []string) ([]types.Value, error) {
 				entries = append(entries, current)
 			}
 			content := trimmed
+			if idx := strings.IndexByte(trimmed, ':'); idx >= 0 {
+				if name, ok := strings.CutPrefix(trimmed[:idx], "$"); ok {
+					if prev, ok := symbols[name]; ok {
+						return nil, fmt.Errorf("constant %q already defined at %d", "$"+name, prev)
+					}
+					symbols[name] = len(entries)
+				}
+			}
 			if idx := strings.Index(trimmed, ":\t"); idx >= 0 {
 				content = trimmed[idx+2:]
 			} else if idx := strings.IndexByte(trimmed, ':'); idx >= 0 {
@@ -268,7 +293,7 @@ func parseConstants(lines []string) ([]types.Value, error) {
 			continue
 		}
 		if strings.HasPrefix(entry[0], "func(") {
-			v, err := types.ParseFunction(entry)
+			v, err := types.ParseFunctionSymbols(entry, symbols)
 			if err != nil {
 				return nil, err
 			}
@@ -328,6 +353,247 @@ func parseHandlers(lines []string) ([]instr.Handler, error) {
 	return handlers, nil
 }
 
+// parseExports parses "name func N" and "name global N" entries; a function
+// export may name its constant as $name.
+func parseExports(lines []string, symbols map[string]int) ([]Export, error) {
+	var exports []Export
+	for _, line := range lines {
+		trimmed := trimEntry(line)
+		if trimmed == "" {
+			continue
+		}
+		fields := strings.Fields(trimmed)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid export %q (expected name kind index)", trimmed)
+		}
+		e := Export{Name: fields[0]}
+		switch fields[1] {
+		case exportKinds[ExportFunction]:
+			e.Kind = ExportFunction
+		case exportKinds[ExportGlobal]:
+			e.Kind = ExportGlobal
+		default:
+			return nil, fmt.Errorf("unknown export kind %q", fields[1])
+		}
+		idx, err := parseIndex(fields[2], symbols)
+		if err != nil {
+			return nil, err
+		}
+		e.Index = idx
+		exports = append(exports, e)
+	}
+	return exports, nil
+}
+
+// parseImports parses "name const N signature" entries; the constant may be
+// given as $name.
+func parseImports(lines []string, symbols map[string]int) ([]Import, error) {
+	var imports []Import
+	for _, line := range lines {
+		trimmed := trimEntry(line)
+		if trimmed == "" {
+			continue
+		}
+		fields := strings.SplitN(trimmed, " ", 4)
+		if len(fields) != 4 || fields[1] != "const" {
+			return nil, fmt.Errorf("invalid import %q (expected name const index signature)", trimmed)
+		}
+		idx, err := parseIndex(fields[2], symbols)
+		if err != nil {
+			return nil, err
+		}
+		t, err := types.Parse(strings.TrimSpace(fields[3]))
+		if err != nil {
+			return nil, err
+		}
+		typ, ok := t.(*types.FunctionType)
+		if !ok {
+			return nil, fmt.Errorf("import %q: %s is not a function type", fields[0], t)
+		}
+		imports = append(imports, Import{Name: fields[0], Typ: typ, Const: idx})
+	}
+	return imports, nil
+}
+
+// parseDebug reads .debug directives. Each line names its scope, either "code"
+// for the top level, "const <index>" for a function constant, or "global", then
+// the item it describes:
+//
+//	code name "main"
+//	code local 0 "x"
+//	code line 0 "main.mvm" 1 1
+//	global 0 "counter"
+//	const 2 line 4 "lib.mvm" 3 5
+func parseDebug(prog *Program, lines []string, symbols map[string]int) error {
+	if prog.Debug == nil {
+		prog.Debug = &Debug{}
+	}
+	for _, line := range lines {
+		fields, err := splitQuoted(strings.TrimSpace(line))
+		if err != nil {
+			return err
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		var d **types.Debug
+		switch fields[0] {
+		case "global":
+			if len(fields) != 3 {
+				return fmt.Errorf("invalid global name %q (expected global index name)", line)
+			}
+			idx, err := strconv.Atoi(fields[1])
+			if err != nil || idx < 0 {
+				return fmt.Errorf("invalid global index %q", fields[1])
+			}
+			for len(prog.Debug.Globals) <= idx {
+				prog.Debug.Globals = append(prog.Debug.Globals, "")
+			}
+			prog.Debug.Globals[idx] = fields[2]
+			continue
+		case "code":
+			d = &prog.Debug.Code
+			fields = fields[1:]
+		case "const":
+			if len(fields) < 2 {
+				return fmt.Errorf("invalid debug scope %q", line)
+			}
+			idx, err := parseIndex(fields[1], symbols)
+			if err != nil {
+				return err
+			}
+			if idx < 0 || idx >= len(prog.Constants) {
+				return fmt.Errorf("debug constant %d out of range", idx)
+			}
+			fn, ok := prog.Constants[idx].(*types.Function)
+			if !ok {
+				return fmt.Errorf("debug constant %d is not a function", idx)
+			}
+			d = &fn.Debug
+			fields = fields[2:]
+		default:
+			return fmt.Errorf("unknown debug scope %q", fields[0])
+		}
+		if *d == nil {
+			*d = &types.Debug{}
+		}
+		if err := parseDebugEntry(*d, fields); err != nil {
+			return fmt.Errorf("%w in %q", err, strings.TrimSpace(line))
+		}
+	}
+	return nil
+}
+
+func parseDebugEntry(d *types.Debug, fields []string) error {
+	if len(fields) == 0 {
+		return fmt.Errorf("missing debug entry")
+	}
+	ints := func(fs []string) ([]int, error) {
+		out := make([]int, len(fs))
+		for i, f := range fs {
+			v, err := strconv.Atoi(f)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("invalid debug number %q", f)
+			}
+			out[i] = v
+		}
+		return out, nil
+	}
+	switch fields[0] {
+	case "name":
+		if len(fields) != 2 {
+			return fmt.Errorf("expected name <name>")
+		}
+		d.Name = fields[1]
+	case "local":
+		if len(fields) != 3 {
+			return fmt.Errorf("expected local <slot> <name>")
+		}
+		vs, err := ints(fields[1:2])
+		if err != nil {
+			return err
+		}
+		for len(d.Locals) <= vs[0] {
+			d.Locals = append(d.Locals, "")
+		}
+		d.Locals[vs[0]] = fields[2]
+	case "line":
+		if len(fields) != 5 {
+			return fmt.Errorf("expected line <ip> <file> <line> <column>")
+		}
+		ip, err := ints(fields[1:2])
+		if err != nil {
+			return err
+		}
+		pos, err := ints(fields[3:5])
+		if err != nil {
+			return err
+		}
+		if n := len(d.Lines); n > 0 && d.Lines[n-1].IP >= ip[0] {
+			return fmt.Errorf("debug line at %d is not after %d", ip[0], d.Lines[n-1].IP)
+		}
+		d.Lines = append(d.Lines, types.Line{IP: ip[0], Pos: types.Position{File: fields[2], Line: pos[0], Column: pos[1]}})
+	default:
+		return fmt.Errorf("unknown debug entry %q", fields[0])
+	}
+	return nil
+}
+
+// splitQuoted splits s on spaces, treating a Go-quoted string as one field.
+func splitQuoted(s string) ([]string, error) {
+	var fields []string
+	for {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return fields, nil
+		}
+		if s[0] == '"' {
+			q, err := strconv.QuotedPrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted string in %q", s)
+			}
+			v, _ := strconv.Unquote(q)
+			fields = append(fields, v)
+			s = s[len(q):]
+			continue
+		}
+		end := strings.IndexAny(s, " \t")
+		if end < 0 {
+			end = len(s)
+		}
+		fields = append(fields, s[:end])
+		s = s[end:]
+	}
+}
+
+// trimEntry strips an optional "NNNN:" index prefix from a section line.
+func trimEntry(line string) string {
+	trimmed := strings.TrimSpace(line)
+	if idx := strings.Index(trimmed, ":\t"); idx >= 0 {
+		trimmed = trimmed[idx+2:]
+	} else if idx := strings.IndexByte(trimmed, ':'); idx >= 0 {
+		trimmed = strings.TrimSpace(trimmed[idx+1:])
+	}
+	return strings.TrimSpace(trimmed)
+}
+
+// parseIndex reads a decimal index or a $name resolved through symbols.
+func parseIndex(s string, symbols map[string]int) (int, error) {
+	if name, ok := strings.CutPrefix(s, "$"); ok {
+		idx, ok := symbols[name]
+		if !ok {
+			return 0, fmt.Errorf("undefined constant %q", s)
+		}
+		return idx, nil
+	}
+	idx, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid index %q", s)
+	}
+	return idx, nil
+}
+
 func parseLiteral(s string) (types.Value, error) {
 	fields := strings.Fields(s)
 	if len(fields) < 2 {
@@ -136,6 +136,171 @@ func TestParse(t *testing.T) {
 		require.Contains(t, err.Error(), ".code")
 	})
 
+	t.Run("resolves labels", func(t *testing.T) {
+		input := ".code\nloop:\n\ti32.const 0\n\tbr_if done\n\tbr loop\ndone:\n\treturn\n"
+		p1, err := program.Parse(strings.NewReader(input))
+		require.NoError(t, err)
+
+		b := program.NewBuilder()
+		loop, done := b.Label(), b.Label()
+		b.Bind(loop).Emit(instr.I32_CONST, 0).BrIf(done).Br(loop).Bind(done).Emit(instr.RETURN)
+		expected, err := b.Build()
+		require.NoError(t, err)
+		require.Equal(t, expected.Code, p1.Code)
+	})
+
+	t.Run("resolves named constants", func(t *testing.T) {
+		input := `.code
+	i32.const 10
+	call $fib
+.constants
+$one:	i32 1
+$fib:	func(i32) i32
+	local.get 0
+	i32.const 2
+	i32.lt_s
+	br_if base
+	local.get 0
+	const.get $one
+	i32.sub
+	call $fib
+	local.get 0
+	i32.const 2
+	i32.sub
+	call $fib
+	i32.add
+	return
+	base:
+	local.get 0
+	return
+`
+		p1, err := program.Parse(strings.NewReader(input))
+		require.NoError(t, err)
+		require.NoError(t, program.Verify(p1))
+		require.Equal(t, []instr.Instruction{instr.New(instr.I32_CONST, 10), instr.New(instr.CONST_GET, 1), instr.New(instr.CALL)}, instr.Unmarshal(p1.Code))
+		require.Equal(t, types.I32(1), p1.Constants[0])
+
+		fn := p1.Constants[1].(*types.Function)
+		require.Contains(t, instr.Unmarshal(fn.Code), instr.New(instr.CONST_GET, 0))
+		require.Contains(t, instr.Unmarshal(fn.Code), instr.New(instr.CONST_GET, 1))
+	})
+
+	t.Run("rejects undefined constant name", func(t *testing.T) {
+		_, err := program.Parse(strings.NewReader(".code\ncall $missing\n"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `"$missing"`)
+	})
+
+	t.Run("rejects duplicate constant name", func(t *testing.T) {
+		_, err := program.Parse(strings.NewReader(".constants\n$a:\ti32 1\n$a:\ti32 2\n"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "already defined")
+	})
+
+	t.Run("parses exports and imports", func(t *testing.T) {
+		src := `.globals
+0000:	i32
+.constants
+$log:	func(i32)
+$main:	func()
+	return
+.exports
+main func $main
+count global 0
+.imports
+env.log const $log func(i32)
+`
+		prog, err := program.Parse(strings.NewReader(src))
+		require.NoError(t, err)
+		require.Equal(t, []program.Export{
+			{Name: "main", Kind: program.ExportFunction, Index: 1},
+			{Name: "count", Kind: program.ExportGlobal, Index: 0},
+		}, prog.Exports)
+		require.Len(t, prog.Imports, 1)
+		require.Equal(t, "env.log", prog.Imports[0].Name)
+		require.Equal(t, 0, prog.Imports[0].Const)
+		require.NoError(t, program.Verify(prog))
+
+		p1, err := program.Parse(strings.NewReader(prog.String()))
+		require.NoError(t, err)
+		require.Equal(t, prog.String(), p1.String())
+	})
+
+	t.Run("rejects unknown export kind", func(t *testing.T) {
+		_, err := program.Parse(strings.NewReader(".exports\nx table 0\n"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unknown export kind")
+	})
+
+	t.Run("rejects non-function import type", func(t *testing.T) {
+		_, err := program.Parse(strings.NewReader(".imports\nx const 0 i32\n"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "not a function type")
+	})
+
+	t.Run("parses debug info", func(t *testing.T) {
+		src := `.code
+const.get $add
+call
+.globals
+0000:	i32
+.constants
+$add:	func(i32) i32
+	local.get 0
+	return
+.debug
+	code name "main"
+	code line 0 "main.mvm" 1 1
+	code line 3 "main.mvm" 2 3
+	global 0 "count"
+	const $add name "add"
+	const $add local 0 "n"
+	const $add line 0 "lib mvm" 4 0
+`
+		prog, err := program.Parse(strings.NewReader(src))
+		require.NoError(t, err)
+		require.Equal(t, &program.Debug{
+			Code: &types.Debug{Name: "main", Lines: []types.Line{
+				{IP: 0, Pos: types.Position{File: "main.mvm", Line: 1, Column: 1}},
+				{IP: 3, Pos: types.Position{File: "main.mvm", Line: 2, Column: 3}},
+			}},
+			Globals: []string{"count"},
+		}, prog.Debug)
+		fn := prog.Constants[0].(*types.Function)
+		require.Equal(t, &types.Debug{
+			Name:   "add",
+			Locals: []string{"n"},
+			Lines:  []types.Line{{IP: 0, Pos: types.Position{File: "lib mvm", Line: 4}}},
+		}, fn.Debug)
+
+		p1, err := program.Parse(strings.NewReader(prog.String()))
+		require.NoError(t, err)
+		require.Equal(t, prog.Debug, p1.Debug)
+		require.Equal(t, fn.Debug, p1.Constants[0].(*types.Function).Debug)
+	})
+
+	t.Run("rejects debug lines out of order", func(t *testing.T) {
+		_, err := program.Parse(strings.NewReader(".debug\ncode line 4 \"a\" 1 0\ncode line 2 \"a\" 2 0\n"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "not after")
+	})
+
+	t.Run("round trip through synthesized labels", func(t *testing.T) {
+		b := program.NewBuilder()
+		loop, done := b.Label(), b.Label()
+		b.Bind(loop).Emit(instr.I32_CONST, 1).BrIf(done).Br(loop).Bind(done).Emit(instr.RETURN)
+		p0, err := b.Build()
+		require.NoError(t, err)
+
+		dump := p0.String()
+		require.Contains(t, dump, "br L0000")
+
+		p1, err := program.Parse(strings.NewReader(dump))
+		require.NoError(t, err)
+		require.Equal(t, p0.Code, p1.Code)
+		require.Equal(t, dump, p1.String())
+	})
+
 	t.Run("accepts legacy format (code only)", func(t *testing.T) {
 		p1, err := program.Parse(strings.NewReader("0000:\ti32.const 0x00000001\n0005:\ti32.const 0x00000002\n0010:\ti32.add\n"))
 		require.NoError(t, err)
@@ -16,6 +16,50 @@ type Program struct {
 	Constants []types.Value
 	Types     []types.Type
 	Handlers  []instr.Handler
+	Exports   []Export
+	Imports   []Import
+	Debug     *Debug
+}
+
+// Debug is a program's optional debug-info section. Code describes the
+// top-level body (slot 0) and Globals names the global slots by index; each
+// function constant carries its own types.Function.Debug. Nothing reads debug
+// info while executing, so stripping it never changes behavior.
+type Debug struct {
+	Code    *types.Debug
+	Globals []string
+}
+
+// Export names a function constant or a global slot so a host can address it
+// without hard-coding an index that optimization may renumber. Index is a
+// constant index for ExportFunction and a global index for ExportGlobal.
+type Export struct {
+	Name  string
+	Kind  ExportKind
+	Index int
+}
+
+// ExportKind selects the index space an Export points into.
+type ExportKind uint8
+
+// Import declares a host function the embedder supplies when it builds an
+// interpreter. The function occupies constant slot Const, which holds a
+// declaration until then: a *types.Function whose Typ equals Typ and whose
+// body is empty. Code calls an import like any function constant.
+type Import struct {
+	Name  string
+	Typ   *types.FunctionType
+	Const int
+}
+
+const (
+	ExportFunction ExportKind = iota
+	ExportGlobal
+)
+
+var exportKinds = map[ExportKind]string{
+	ExportFunction: "func",
+	ExportGlobal:   "global",
 }
 
 func WithConstants(consts ...types.Value) func(*Program) {
@@ -58,6 +102,28 @@ func WithHandlers(handlers ...instr.Handler) func(*Program) {
 	}
 }
 
+// WithExports attaches the program's export table.
+func WithExports(exports ...Export) func(*Program) {
+	return func(p *Program) {
+		p.Exports = exports
+	}
+}
+
+// WithImports attaches the program's import table. Each import's constant
+// slot must already hold its declaration; see NewDeclaration.
+func WithImports(imports ...Import) func(*Program) {
+	return func(p *Program) {
+		p.Imports = imports
+	}
+}
+
+// WithDebug attaches the program's debug-info section.
+func WithDebug(debug *Debug) func(*Program) {
+	return func(p *Program) {
+		p.Debug = debug
+	}
+}
+
 func New(instrs []instr.Instruction, options ...func(*Program)) *Program {
 	p := &Program{Code: instr.Marshal(instrs)}
 	for _, opt := range options {
@@ -66,10 +132,67 @@ func New(instrs []instr.Instruction, options ...func(*Program)) *Program {
 	return p
 }
 
+// NewDeclaration returns the body-less function an import's constant slot holds
+// until an interpreter binds the host function.
+func NewDeclaration(typ *types.FunctionType) *types.Function {
+	if typ == nil {
+		typ = &types.FunctionType{}
+	}
+	return &types.Function{Typ: typ}
+}
+
+// Global returns the debug name of global slot idx, or "" when it has none.
+func (d *Debug) Global(idx int) string {
+	if d == nil || idx < 0 || idx >= len(d.Globals) {
+		return ""
+	}
+	return d.Globals[idx]
+}
+
+// FuncName names function slot, 0 for the top-level code and j+1 for
+// constant j, by its debug name or else the name it is exported under. It
+// returns "" when neither names it.
+func (p *Program) FuncName(slot int) string {
+	var debug *types.Debug
+	switch {
+	case slot == 0:
+		if p.Debug != nil {
+			debug = p.Debug.Code
+		}
+	case slot > 0 && slot <= len(p.Constants):
+		fn, ok := p.Constants[slot-1].(*types.Function)
+		if !ok {
+			return ""
+		}
+		debug = fn.Debug
+	default:
+		return ""
+	}
+	if debug != nil && debug.Name != "" {
+		return debug.Name
+	}
+	for _, e := range p.Exports {
+		if e.Kind == ExportFunction && e.Index == slot-1 {
+			return e.Name
+		}
+	}
+	return ""
+}
+
+// Export returns the export named name.
+func (p *Program) Export(name string) (Export, bool) {
+	for _, e := range p.Exports {
+		if e.Name == name {
+			return e, true
+		}
+	}
+	return Export{}, false
+}
+
 func (p *Program) String() string {
 	var sb strings.Builder
 	sb.WriteString(".code\n")
-	sb.WriteString(instr.Format(p.Code))
+	sb.WriteString(instr.FormatLabels(p.Code))
 	if len(p.Locals) > 0 {
 		sb.WriteString(".locals\n")
 		writeIndexed(&sb, p.Locals)
@@ -104,9 +227,69 @@ func (p *Program) String() string {
 			sb.WriteString(fmt.Sprintf("%04d:\tstart=%d end=%d catch=%d depth=%d\n", i, h.Start, h.End, h.Catch, h.Depth))
 		}
 	}
+	if len(p.Exports) > 0 {
+		sb.WriteString(".exports\n")
+		for i, e := range p.Exports {
+			sb.WriteString(fmt.Sprintf("%04d:\t%s %s %d\n", i, e.Name, exportKinds[e.Kind], e.Index))
+		}
+	}
+	if len(p.Imports) > 0 {
+		sb.WriteString(".imports\n")
+		for i, imp := range p.Imports {
+			sb.WriteString(fmt.Sprintf("%04d:\t%s const %d %s\n", i, imp.Name, imp.Const, imp.Typ))
+		}
+	}
+	if p.hasDebug() {
+		sb.WriteString(".debug\n")
+		if p.Debug != nil {
+			writeDebug(&sb, "code", p.Debug.Code)
+			for i, name := range p.Debug.Globals {
+				if name != "" {
+					sb.WriteString(fmt.Sprintf("\tglobal %d %q\n", i, name))
+				}
+			}
+		}
+		for i, v := range p.Constants {
+			if fn, ok := v.(*types.Function); ok {
+				writeDebug(&sb, fmt.Sprintf("const %d", i), fn.Debug)
+			}
+		}
+	}
 	return sb.String()
 }
 
+// hasDebug reports whether any part of the program carries debug info.
+func (p *Program) hasDebug() bool {
+	if p.Debug != nil && (p.Debug.Code != nil || len(p.Debug.Globals) > 0) {
+		return true
+	}
+	for _, v := range p.Constants {
+		if fn, ok := v.(*types.Function); ok && fn.Debug != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// writeDebug writes one function's debug info as .debug directives prefixed
+// with scope, which names the function they belong to.
+func writeDebug(sb *strings.Builder, scope string, d *types.Debug) {
+	if d == nil {
+		return
+	}
+	if d.Name != "" {
+		sb.WriteString(fmt.Sprintf("\t%s name %q\n", scope, d.Name))
+	}
+	for i, name := range d.Locals {
+		if name != "" {
+			sb.WriteString(fmt.Sprintf("\t%s local %d %q\n", scope, i, name))
+		}
+	}
+	for _, l := range d.Lines {
+		sb.WriteString(fmt.Sprintf("\t%s line %d %q %d %d\n", scope, l.IP, l.Pos.File, l.Pos.Line, l.Pos.Column))
+	}
+}
+
 func writeIndexed[T fmt.Stringer](sb *strings.Builder, items []T) {
 	for i, item := range items {
 		head, tail, _ := strings.Cut(item.String(), "\n")
@@ -42,6 +42,43 @@ func TestWithHandlers(t *testing.T) {
 	require.Equal(t, h, prog.Handlers[0])
 }
 
+func TestWithExports(t *testing.T) {
+	e := program.Export{Name: "main", Kind: program.ExportFunction, Index: 0}
+	prog := program.New(nil, program.WithExports(e))
+	require.Equal(t, []program.Export{e}, prog.Exports)
+}
+
+func TestWithImports(t *testing.T) {
+	sig := &types.FunctionType{Params: []types.Type{types.TypeI32}}
+	imp := program.Import{Name: "env.log", Typ: sig, Const: 0}
+	prog := program.New(nil, program.WithConstants(program.NewDeclaration(sig)), program.WithImports(imp))
+	require.Equal(t, []program.Import{imp}, prog.Imports)
+}
+
+func TestWithDebug(t *testing.T) {
+	debug := &program.Debug{Code: &types.Debug{Name: "main"}, Globals: []string{"count"}}
+	prog := program.New(nil, program.WithDebug(debug))
+	require.Same(t, debug, prog.Debug)
+}
+
+func TestDebug_Global(t *testing.T) {
+	debug := &program.Debug{Globals: []string{"count", ""}}
+	require.Equal(t, "count", debug.Global(0))
+	require.Equal(t, "", debug.Global(1))
+	require.Equal(t, "", debug.Global(2))
+
+	var nilDebug *program.Debug
+	require.Equal(t, "", nilDebug.Global(0))
+}
+
+func TestNewDeclaration(t *testing.T) {
+	sig := &types.FunctionType{Returns: []types.Type{types.TypeI32}}
+	fn := program.NewDeclaration(sig)
+	require.Same(t, sig, fn.Typ)
+	require.Empty(t, fn.Code)
+	require.NotNil(t, program.NewDeclaration(nil).Typ)
+}
+
 func TestNew(t *testing.T) {
 	body := []instr.Instruction{instr.New(instr.I32_CONST, 42), instr.New(instr.DROP)}
 	prog := program.New(body, program.WithLocals(types.TypeI32), program.WithGlobals(types.TypeAny))
@@ -50,11 +87,49 @@ func TestNew(t *testing.T) {
 	require.Equal(t, []types.Type{types.TypeAny}, prog.Globals)
 }
 
+func TestProgram_Export(t *testing.T) {
+	prog := program.New(nil, program.WithGlobals(types.TypeI32), program.WithExports(
+		program.Export{Name: "counter", Kind: program.ExportGlobal, Index: 0},
+	))
+
+	e, ok := prog.Export("counter")
+	require.True(t, ok)
+	require.Equal(t, program.ExportGlobal, e.Kind)
+
+	_, ok = prog.Export("missing")
+	require.False(t, ok)
+}
+
+func TestProgram_FuncName(t *testing.T) {
+	typ := &types.FunctionType{}
+	prog := program.New(nil,
+		program.WithConstants(
+			&types.Function{Typ: typ, Debug: &types.Debug{Name: "named"}},
+			&types.Function{Typ: typ},
+			&types.Function{Typ: typ},
+			types.I32(1),
+		),
+		program.WithExports(program.Export{Name: "exported", Kind: program.ExportFunction, Index: 1}),
+		program.WithDebug(&program.Debug{Code: &types.Debug{Name: "main"}}),
+	)
+
+	require.Equal(t, "main", prog.FuncName(0))
+	require.Equal(t, "named", prog.FuncName(1))
+	require.Equal(t, "exported", prog.FuncName(2))
+	require.Equal(t, "", prog.FuncName(3))
+	require.Equal(t, "", prog.FuncName(4))
+	require.Equal(t, "", prog.FuncName(5))
+}
+
 func TestProgram_String(t *testing.T) {
 	t.Run("with code", func(t *testing.T) {
 		prog := program.New([]instr.Instruction{instr.New(instr.NOP)})
 		require.Equal(t, ".code\n0000:\tnop\n", prog.String())
 	})
+	t.Run("with branches", func(t *testing.T) {
+		prog := program.New([]instr.Instruction{instr.New(instr.BR, 1), instr.New(instr.NOP), instr.New(instr.RETURN)})
+		require.Equal(t, ".code\n0000:\tbr L0004\n0003:\tnop\nL0004:\n0004:\treturn\n", prog.String())
+	})
 	t.Run("empty", func(t *testing.T) {
 		prog := program.New(nil)
 		require.Equal(t, ".code\n", prog.String())
@@ -67,4 +142,18 @@ func TestProgram_String(t *testing.T) {
 		require.Contains(t, prog.String(), "catch=20")
 		require.Contains(t, prog.String(), "depth=1")
 	})
+	t.Run("with exports and imports", func(t *testing.T) {
+		sig := &types.FunctionType{Params: []types.Type{types.TypeI32}}
+		prog := program.New(nil,
+			program.WithGlobals(types.TypeI32),
+			program.WithConstants(program.NewDeclaration(sig)),
+			program.WithExports(
+				program.Export{Name: "log", Kind: program.ExportFunction, Index: 0},
+				program.Export{Name: "count", Kind: program.ExportGlobal, Index: 0},
+			),
+			program.WithImports(program.Import{Name: "env.log", Typ: sig, Const: 0}),
+		)
+		require.Contains(t, prog.String(), ".exports\n0000:\tlog func 0\n0001:\tcount global 0\n")
+		require.Contains(t, prog.String(), ".imports\n0000:\tenv.log const 0 func(i32)\n")
+	})
 }
@@ -0,0 +1,245 @@
+package program
+
+import (
+	"slices"
+
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/types"
+)
+
+// Stats is the static resource profile of a program, computed by Analyze:
+// how high each function's operand stack gets, which functions it calls, and
+// how many stack slots and call frames a run can need.
+type Stats struct {
+	// Funcs is indexed by function slot, as VerifyError.Slot: 0 is the
+	// top-level code and j+1 is constant j. Slots of constants that are not
+	// functions hold the zero FuncStats.
+	Funcs []FuncStats
+
+	// Stack and Frames bound the operand stack slots and call frames a Run
+	// needs, and a Call of an exported function on top of the top-level
+	// locals. Either is Unbounded when recursion, a dynamic call, or an
+	// indeterminate stack height leaves no static bound.
+	Stack  int
+	Frames int
+}
+
+// FuncStats is the static resource profile of one function slot.
+type FuncStats struct {
+	// Locals is the number of parameter and declared local slots.
+	Locals int
+	// Operands is the highest the operand stack gets above the locals, or
+	// Unbounded when the verifier cannot follow the stack to the end.
+	Operands int
+
+	// Calls and TailCalls are the slots the function reaches with CALL and
+	// RETURN_CALL on a function constant, ascending. Imports run on the host
+	// and are not listed.
+	Calls     []int
+	TailCalls []int
+	// Dynamic reports a call whose callee is only known at run time: a CALL
+	// or RETURN_CALL through anything but a function constant, or a RESUME.
+	Dynamic bool
+	// Recursive reports that the function can reach itself through calls
+	// that keep its frame. A cycle of tail calls replaces frames instead and
+	// is not recursive.
+	Recursive bool
+
+	// Stack and Frames bound the stack slots and frames a call into the
+	// function needs, its own included, or are Unbounded.
+	Stack  int
+	Frames int
+}
+
+// Unbounded marks a bound Analyze cannot establish statically.
+const Unbounded = -1
+
+// Analyze verifies prog like Verify and, when it is well-formed, returns its
+// static resource profile. A host function that calls back into the guest is
+// outside the call graph, so its callbacks are not counted.
+func Analyze(prog *Program) (*Stats, error) {
+	checkers, err := verify(prog)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Stats{Funcs: make([]FuncStats, len(checkers))}
+	for slot, c := range checkers {
+		if c == nil {
+			continue
+		}
+		f := &s.Funcs[slot]
+		f.Locals = len(c.locals)
+		f.Operands = c.peak
+		if c.partial {
+			f.Operands = Unbounded
+		}
+		f.Calls, f.TailCalls, f.Dynamic = calls(prog, c.code)
+	}
+	bound(s.Funcs, checkers)
+
+	root := s.Funcs[0]
+	s.Stack, s.Frames = root.Stack, root.Frames
+	for _, e := range prog.Exports {
+		if e.Kind != ExportFunction {
+			continue
+		}
+		f := s.Funcs[e.Index+1]
+		s.Stack = widest(s.Stack, grow(f.Stack, root.Locals))
+		s.Frames = widest(s.Frames, grow(f.Frames, 1))
+	}
+	return s, nil
+}
+
+// calls lists the function slots code calls and tail-calls directly, and
+// reports whether it also makes a call whose callee is only known at run
+// time. A callee is static only when a CONST_GET of a function with a body
+// immediately precedes the call and no branch lands between them.
+func calls(prog *Program, code []byte) (calls, tails []int, dynamic bool) {
+	targets := map[int]bool{}
+	for ip := 0; ip < len(code); ip += instr.Instruction(code[ip:]).Width() {
+		switch instr.Opcode(code[ip]) {
+		case instr.BR, instr.BR_IF, instr.BR_TABLE:
+			for _, target := range instr.Targets(code, ip) {
+				targets[target] = true
+			}
+		}
+	}
+
+	prev := -1
+	for ip := 0; ip < len(code); {
+		inst := instr.Instruction(code[ip:])
+		switch op := inst.Opcode(); op {
+		case instr.CALL, instr.RETURN_CALL:
+			if prev < 0 || targets[ip] || instr.Opcode(code[prev]) != instr.CONST_GET {
+				dynamic = true
+				break
+			}
+			j := int(instr.Instruction(code[prev:]).Operand(0))
+			fn, ok := prog.Constants[j].(*types.Function)
+			switch {
+			case !ok:
+				dynamic = true
+			case len(fn.Code) == 0:
+			case op == instr.CALL:
+				calls = append(calls, j+1)
+			default:
+				tails = append(tails, j+1)
+			}
+		case instr.RESUME:
+			dynamic = true
+		}
+		prev, ip = ip, ip+inst.Width()
+	}
+
+	slices.Sort(calls)
+	slices.Sort(tails)
+	return slices.Compact(calls), slices.Compact(tails), dynamic
+}
+
+// bound fills in Recursive, Stack, and Frames over the call graph. Tarjan's
+// algorithm finishes every strongly connected component after the components
+// it calls into, so each is bounded from final callee bounds. A component is
+// recursive when a CALL stays inside it; one joined only by tail calls shares
+// a single bound, since each of its frames replaces the last.
+func bound(funcs []FuncStats, checkers []*checker) {
+	index := make([]int, len(funcs))
+	low := make([]int, len(funcs))
+	comp := make([]int, len(funcs))
+	for k := range funcs {
+		index[k], comp[k] = -1, -1
+	}
+	var stack []int
+	next, comps := 0, 0
+
+	var visit func(v int)
+	visit = func(v int) {
+		index[v], low[v] = next, next
+		next++
+		stack = append(stack, v)
+		for _, w := range slices.Concat(funcs[v].Calls, funcs[v].TailCalls) {
+			switch {
+			case index[w] < 0:
+				visit(w)
+				low[v] = min(low[v], low[w])
+			case comp[w] < 0:
+				low[v] = min(low[v], index[w])
+			}
+		}
+		if low[v] != index[v] {
+			return
+		}
+
+		var members []int
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			comp[w] = comps
+			members = append(members, w)
+			if w == v {
+				break
+			}
+		}
+		id := comps
+		comps++
+		inside := func(w int) bool { return comp[w] == id }
+
+		recursive := false
+		for _, m := range members {
+			if slices.ContainsFunc(funcs[m].Calls, inside) {
+				recursive = true
+			}
+		}
+
+		frames, slots := 1, 0
+		if recursive {
+			frames, slots = Unbounded, Unbounded
+		}
+		for _, m := range members {
+			f := funcs[m]
+			if f.Dynamic {
+				frames, slots = Unbounded, Unbounded
+			}
+			own := grow(f.Operands, f.Locals)
+			slots = widest(slots, own)
+			for _, w := range f.Calls {
+				frames = widest(frames, grow(funcs[w].Frames, 1))
+				slots = widest(slots, grow(funcs[w].Stack, own))
+			}
+			for _, w := range f.TailCalls {
+				if inside(w) {
+					continue
+				}
+				frames = widest(frames, funcs[w].Frames)
+				slots = widest(slots, funcs[w].Stack)
+			}
+		}
+		for _, m := range members {
+			funcs[m].Recursive = recursive
+			funcs[m].Frames = frames
+			funcs[m].Stack = slots
+		}
+	}
+
+	for v, c := range checkers {
+		if c != nil && index[v] < 0 {
+			visit(v)
+		}
+	}
+}
+
+// widest is the larger of two bounds, Unbounded if either is.
+func widest(a, b int) int {
+	if a == Unbounded || b == Unbounded {
+		return Unbounded
+	}
+	return max(a, b)
+}
+
+// grow adds n to bound, which stays Unbounded.
+func grow(bound, n int) int {
+	if bound == Unbounded {
+		return Unbounded
+	}
+	return bound + n
+}
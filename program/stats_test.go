@@ -0,0 +1,202 @@
+package program_test
+
+import (
+	"testing"
+
+	"github.com/siyul-park/minivm/instr"
+	program "github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyze(t *testing.T) {
+	unary := &types.FunctionType{Params: []types.Type{types.TypeI32}, Returns: []types.Type{types.TypeI32}}
+
+	inc := types.NewFunctionBuilder(unary).Emit(
+		instr.New(instr.LOCAL_GET, 0),
+		instr.New(instr.I32_CONST, 1),
+		instr.New(instr.I32_ADD),
+		instr.New(instr.RETURN),
+	).MustBuild()
+
+	t.Run("straight-line code", func(t *testing.T) {
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_CONST, 2),
+			instr.New(instr.I32_CONST, 3),
+			instr.New(instr.I32_ADD),
+			instr.New(instr.I32_ADD),
+			instr.New(instr.LOCAL_SET, 0),
+		}, program.WithLocals(types.TypeI32))
+
+		s, err := program.Analyze(prog)
+		require.NoError(t, err)
+		require.Equal(t, program.FuncStats{Locals: 1, Operands: 3, Stack: 4, Frames: 1}, s.Funcs[0])
+		require.Equal(t, 4, s.Stack)
+		require.Equal(t, 1, s.Frames)
+	})
+
+	t.Run("call chain", func(t *testing.T) {
+		twice := types.NewFunctionBuilder(unary).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.CONST_GET, 1),
+			instr.New(instr.CALL),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+		}, program.WithConstants(twice, inc))
+
+		s, err := program.Analyze(prog)
+		require.NoError(t, err)
+		require.Equal(t, []int{1}, s.Funcs[0].Calls)
+		require.Equal(t, []int{2}, s.Funcs[1].Calls)
+		require.Empty(t, s.Funcs[2].Calls)
+		require.Equal(t, 3, s.Funcs[2].Stack)
+		require.Equal(t, 6, s.Funcs[1].Stack)
+		require.Equal(t, 2, s.Funcs[1].Frames)
+		require.Equal(t, 8, s.Stack)
+		require.Equal(t, 3, s.Frames)
+	})
+
+	t.Run("recursion is unbounded", func(t *testing.T) {
+		b := types.NewFunctionBuilder(unary)
+		base := b.Label()
+		fn := b.Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_EQZ),
+		).BrIf(base).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_SUB),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+			instr.New(instr.RETURN),
+		).Bind(base).Emit(
+			instr.New(instr.I32_CONST, 0),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 3),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+		}, program.WithConstants(fn))
+
+		s, err := program.Analyze(prog)
+		require.NoError(t, err)
+		require.True(t, s.Funcs[1].Recursive)
+		require.False(t, s.Funcs[0].Recursive)
+		require.Equal(t, program.Unbounded, s.Funcs[1].Frames)
+		require.Equal(t, program.Unbounded, s.Stack)
+		require.Equal(t, program.Unbounded, s.Frames)
+	})
+
+	t.Run("tail recursion is bounded", func(t *testing.T) {
+		b := types.NewFunctionBuilder(unary)
+		base := b.Label()
+		fn := b.Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_EQZ),
+		).BrIf(base).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_SUB),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.RETURN_CALL),
+		).Bind(base).Emit(
+			instr.New(instr.I32_CONST, 0),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 3),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+		}, program.WithConstants(fn))
+
+		s, err := program.Analyze(prog)
+		require.NoError(t, err)
+		require.Equal(t, []int{1}, s.Funcs[1].TailCalls)
+		require.False(t, s.Funcs[1].Recursive)
+		require.Equal(t, 1, s.Funcs[1].Frames)
+		require.Equal(t, 3, s.Funcs[1].Stack)
+		require.Equal(t, 2, s.Frames)
+		require.Equal(t, 5, s.Stack)
+	})
+
+	t.Run("dynamic call is unbounded", func(t *testing.T) {
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.LOCAL_SET, 0),
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.CALL),
+		}, program.WithConstants(inc), program.WithLocals(unary))
+
+		s, err := program.Analyze(prog)
+		require.NoError(t, err)
+		require.True(t, s.Funcs[0].Dynamic)
+		require.Empty(t, s.Funcs[0].Calls)
+		require.Equal(t, program.Unbounded, s.Stack)
+		require.Equal(t, program.Unbounded, s.Frames)
+	})
+
+	t.Run("imports are leaves", func(t *testing.T) {
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+		},
+			program.WithConstants(program.NewDeclaration(unary)),
+			program.WithImports(program.Import{Name: "env.inc", Typ: unary, Const: 0}),
+		)
+
+		s, err := program.Analyze(prog)
+		require.NoError(t, err)
+		require.Empty(t, s.Funcs[0].Calls)
+		require.False(t, s.Funcs[0].Dynamic)
+		require.Equal(t, 2, s.Stack)
+		require.Equal(t, 1, s.Frames)
+	})
+
+	t.Run("exports widen the program bound", func(t *testing.T) {
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.LOCAL_SET, 0),
+		},
+			program.WithConstants(inc),
+			program.WithLocals(types.TypeI32),
+			program.WithExports(program.Export{Name: "inc", Kind: program.ExportFunction, Index: 0}),
+		)
+
+		s, err := program.Analyze(prog)
+		require.NoError(t, err)
+		require.Equal(t, 2, s.Funcs[0].Stack)
+		require.Equal(t, 4, s.Stack)
+		require.Equal(t, 2, s.Frames)
+	})
+
+	t.Run("indeterminate stack is unbounded", func(t *testing.T) {
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 0),
+			instr.New(instr.MAP_NEW, 0),
+			instr.New(instr.DROP),
+		}, program.WithTypes(types.NewMapType(types.TypeI32, types.TypeI32)))
+
+		s, err := program.Analyze(prog)
+		require.NoError(t, err)
+		require.Equal(t, program.Unbounded, s.Funcs[0].Operands)
+		require.Equal(t, program.Unbounded, s.Stack)
+		require.Equal(t, 1, s.Frames)
+	})
+
+	t.Run("rejects malformed programs", func(t *testing.T) {
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_ADD),
+		})
+
+		_, err := program.Analyze(prog)
+		require.ErrorIs(t, err, program.ErrStackUnderflow)
+	})
+}
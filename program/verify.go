@@ -33,6 +33,12 @@ type checker struct {
 
 	slot    int
 	returns int
+
+	// peak is the highest operand stack the dataflow reached, and partial
+	// whether it stopped early on an indeterminate op, leaving peak a lower
+	// bound only.
+	peak    int
+	partial bool
 }
 
 // block is one node of the control-flow graph: a maximal straight-line run of
@@ -47,10 +53,13 @@ type block struct {
 // slot is one abstract operand-stack entry: its kind plus, when the entry
 // definitely holds a function or closure reference, that reference's signature.
 // The signature lets CALL recover a callee's arity statically even though the
-// bytecode carries no call type operand.
+// bytecode carries no call type operand. An i32 pushed by I32_CONST also keeps
+// its value in val, with known set, so ARRAY_NEW can read its element count.
 type slot struct {
-	kind types.Kind
-	typ  types.Type
+	kind  types.Kind
+	typ   types.Type
+	val   int32
+	known bool
 }
 
 // stack is the abstract operand stack threaded through a basic block.
@@ -76,6 +85,8 @@ var (
 	ErrInvalidJump     = errors.New("invalid jump")
 	ErrHandlerRange    = errors.New("invalid exception handler range")
 	ErrHandlerTarget   = errors.New("invalid exception handler target")
+	ErrInvalidExport   = errors.New("invalid export")
+	ErrInvalidImport   = errors.New("invalid import")
 )
 
 func newChecker(prog *Program, slot int, fn *types.Function) *checker {
@@ -89,22 +100,39 @@ func newChecker(prog *Program, slot int, fn *types.Function) *checker {
 }
 
 // Verify checks every function slot of prog and returns the first violation as
-// a *VerifyError, or nil when the program is well-formed.
+// a *VerifyError, or nil when the program is well-formed. A malformed export or
+// import table is reported first, wrapping ErrInvalidExport or ErrInvalidImport.
 func Verify(prog *Program) error {
+	_, err := verify(prog)
+	return err
+}
+
+// verify runs Verify and returns the finished checker of every function slot,
+// nil for slots whose constant is not a function.
+func verify(prog *Program) ([]*checker, error) {
+	if err := verifyImports(prog); err != nil {
+		return nil, err
+	}
+	if err := verifyExports(prog); err != nil {
+		return nil, err
+	}
+	checkers := make([]*checker, len(prog.Constants)+1)
 	top := &types.Function{Typ: &types.FunctionType{}, Locals: prog.Locals, Code: prog.Code, Handlers: prog.Handlers}
-	if err := newChecker(prog, 0, top).run(); err != nil {
-		return err
+	checkers[0] = newChecker(prog, 0, top)
+	if err := checkers[0].run(); err != nil {
+		return nil, err
 	}
 	for j, c := range prog.Constants {
 		fn, ok := c.(*types.Function)
 		if !ok {
 			continue
 		}
-		if err := newChecker(prog, j+1, fn).run(); err != nil {
-			return err
+		checkers[j+1] = newChecker(prog, j+1, fn)
+		if err := checkers[j+1].run(); err != nil {
+			return nil, err
 		}
 	}
-	return nil
+	return checkers, nil
 }
 
 func (e *VerifyError) Error() string {
@@ -179,18 +207,14 @@ func (c *checker) structure() error {
 
 func (c *checker) bounds(ip int, op instr.Opcode) error {
 	inst := instr.Instruction(c.code[ip:])
+	if instr.IndexesType(op) && int(inst.Operand(0)) >= len(c.prog.Types) {
+		return c.fail(ip, op, ErrIndexOutOfRange)
+	}
 	switch op {
 	case instr.CONST_GET:
 		if int(inst.Operand(0)) >= len(c.prog.Constants) {
 			return c.fail(ip, op, ErrIndexOutOfRange)
 		}
-	case instr.REF_TEST, instr.REF_CAST,
-		instr.ARRAY_NEW, instr.ARRAY_NEW_DEFAULT,
-		instr.STRUCT_NEW, instr.STRUCT_NEW_DEFAULT,
-		instr.MAP_NEW, instr.MAP_NEW_DEFAULT:
-		if int(inst.Operand(0)) >= len(c.prog.Types) {
-			return c.fail(ip, op, ErrIndexOutOfRange)
-		}
 	case instr.LOCAL_GET, instr.LOCAL_SET, instr.LOCAL_TEE:
 		if int(inst.Operand(0)) >= len(c.locals) {
 			return c.fail(ip, op, ErrIndexOutOfRange)
@@ -229,7 +253,7 @@ func (c *checker) blocks() ([]*block, error) {
 		inst := instr.Instruction(c.code[ip:])
 		next := ip + inst.Width()
 		switch inst.Opcode() {
-		case instr.UNREACHABLE, instr.RETURN, instr.THROW:
+		case instr.UNREACHABLE, instr.RETURN, instr.RETURN_CALL, instr.THROW:
 			if next < len(c.code) {
 				offsets = append(offsets, next)
 			}
@@ -273,7 +297,7 @@ func (c *checker) blocks() ([]*block, error) {
 	for j, b := range blocks {
 		op, ip := c.last(b)
 		switch op {
-		case instr.UNREACHABLE, instr.RETURN, instr.THROW:
+		case instr.UNREACHABLE, instr.RETURN, instr.RETURN_CALL, instr.THROW:
 		case instr.BR, instr.BR_IF, instr.BR_TABLE:
 			for _, target := range instr.Targets(c.code, ip) {
 				if err := c.link(blocks, j, target); err != nil {
@@ -358,8 +382,9 @@ func (c *checker) terminate(blocks []*block) error {
 // fixpoint, checking for underflow, operand type confusion, and height
 // disagreement at merges. When an instruction's stack effect cannot be
 // determined statically (a dynamic-arity CALL, a stack-counted MAP_NEW, an
-// extension op), it stops without a verdict: the structural passes already
-// hold, and the interpreter guards the rest at runtime.
+// ARRAY_NEW whose count is not a constant, an extension op), it stops without
+// a verdict: the structural passes already hold, and the interpreter guards
+// the rest at runtime.
 func (c *checker) flow(blocks []*block) error {
 	entries := make([]*stack, len(blocks))
 	entries[0] = &stack{}
@@ -406,6 +431,7 @@ func (c *checker) flow(blocks []*block) error {
 			return err
 		}
 		if done {
+			c.partial = true
 			return nil
 		}
 		for _, s := range blocks[i].succs {
@@ -429,6 +455,7 @@ func (c *checker) flow(blocks []*block) error {
 // exec simulates one block's stack effect, returning done when an
 // indeterminate op halts the dataflow.
 func (c *checker) exec(b *block, st *stack) (bool, error) {
+	c.peak = max(c.peak, st.len())
 	for ip := b.start; ip < b.end; {
 		op := instr.Opcode(c.code[ip])
 		done, err := c.step(st, instr.Instruction(c.code[ip:]), op, ip)
@@ -438,6 +465,7 @@ func (c *checker) exec(b *block, st *stack) (bool, error) {
 		if done {
 			return true, nil
 		}
+		c.peak = max(c.peak, st.len())
 		if op == instr.RETURN || op == instr.RETURN_CALL || op == instr.UNREACHABLE || op == instr.THROW {
 			return false, nil
 		}
@@ -484,6 +512,9 @@ func (c *checker) step(st *stack, inst instr.Instruction, op instr.Opcode, ip in
 		t := c.prog.Globals[inst.Operand(0)]
 		st.push(slot{kind: t.Kind(), typ: t})
 		return false, nil
+	case instr.I32_CONST:
+		st.push(slot{kind: types.KindI32, val: int32(inst.Operand(0)), known: true})
+		return false, nil
 	case instr.GLOBAL_SET:
 		if st.len() == 0 {
 			return false, c.fail(ip, op, ErrStackUnderflow)
@@ -544,6 +575,42 @@ func (c *checker) step(st *stack, inst instr.Instruction, op instr.Opcode, ip in
 		st.drop(len(t.Fields))
 		st.push(slot{kind: types.KindRef, typ: t})
 		return false, nil
+	case instr.ARRAY_NEW:
+		// ARRAY_NEW pops a runtime element count and then that many elements,
+		// so its effect is only static when the count is a constant.
+		if st.len() == 0 {
+			return false, c.fail(ip, op, ErrStackUnderflow)
+		}
+		n := st.top()
+		if !accepts(n.kind, types.KindI32) {
+			return false, c.fail(ip, op, ErrTypeMismatch)
+		}
+		if !n.known || n.val < 0 {
+			return true, nil
+		}
+		st.pop()
+		if st.len() < int(n.val) {
+			return false, c.fail(ip, op, ErrStackUnderflow)
+		}
+		t := c.prog.Types[inst.Operand(0)]
+		if a, ok := t.(*types.ArrayType); ok {
+			for _, elem := range st.slots[st.len()-int(n.val):] {
+				if !accepts(elem.kind, a.ElemKind) {
+					return false, c.fail(ip, op, ErrTypeMismatch)
+				}
+			}
+		}
+		st.drop(int(n.val))
+		st.push(slot{kind: types.KindRef, typ: t})
+		return false, nil
+	case instr.REF_CAST:
+		if st.len() == 0 {
+			return false, c.fail(ip, op, ErrStackUnderflow)
+		}
+		st.pop()
+		t := c.prog.Types[inst.Operand(0)]
+		st.push(slot{kind: t.Kind(), typ: t})
+		return false, nil
 	case instr.MAP_NEW, instr.CLOSURE_NEW:
 		return true, nil
 	}
@@ -671,6 +738,10 @@ func (s *stack) merge(other *stack) (changed, balanced bool) {
 			s.slots[i].typ = nil
 			changed = true
 		}
+		if s.slots[i].known && (!other.slots[i].known || s.slots[i].val != other.slots[i].val) {
+			s.slots[i].known = false
+			changed = true
+		}
 	}
 	return changed, true
 }
@@ -718,3 +789,63 @@ func bitwise(a, b types.Kind) types.Kind {
 	}
 	return types.KindI32
 }
+
+// verifyImports checks that every import has a unique name and a constant slot
+// of its own holding a body-less declaration of exactly the import's signature, so call
+// sites are verified against the type the host function must provide.
+func verifyImports(prog *Program) error {
+	seen := make(map[string]bool, len(prog.Imports))
+	slots := make(map[int]bool, len(prog.Imports))
+	for _, imp := range prog.Imports {
+		if imp.Name == "" || seen[imp.Name] {
+			return fmt.Errorf("%w: %q: empty or duplicate name", ErrInvalidImport, imp.Name)
+		}
+		seen[imp.Name] = true
+		if imp.Typ == nil {
+			return fmt.Errorf("%w: %q: missing signature", ErrInvalidImport, imp.Name)
+		}
+		if imp.Const < 0 || imp.Const >= len(prog.Constants) {
+			return fmt.Errorf("%w: %q: constant %d out of range", ErrInvalidImport, imp.Name, imp.Const)
+		}
+		fn, ok := prog.Constants[imp.Const].(*types.Function)
+		if !ok || len(fn.Code) > 0 {
+			return fmt.Errorf("%w: %q: constant %d is not a declaration", ErrInvalidImport, imp.Name, imp.Const)
+		}
+		if fn.Typ == nil || !fn.Typ.Equals(imp.Typ) {
+			return fmt.Errorf("%w: %q: declared as %v, imported as %s", ErrInvalidImport, imp.Name, fn.Typ, imp.Typ)
+		}
+		if slots[imp.Const] {
+			return fmt.Errorf("%w: %q: constant %d already imported", ErrInvalidImport, imp.Name, imp.Const)
+		}
+		slots[imp.Const] = true
+	}
+	return nil
+}
+
+// verifyExports checks that every export has a unique name and points at a
+// function constant or an existing global slot.
+func verifyExports(prog *Program) error {
+	seen := make(map[string]bool, len(prog.Exports))
+	for _, e := range prog.Exports {
+		if e.Name == "" || seen[e.Name] {
+			return fmt.Errorf("%w: %q: empty or duplicate name", ErrInvalidExport, e.Name)
+		}
+		seen[e.Name] = true
+		switch e.Kind {
+		case ExportFunction:
+			if e.Index < 0 || e.Index >= len(prog.Constants) {
+				return fmt.Errorf("%w: %q: constant %d out of range", ErrInvalidExport, e.Name, e.Index)
+			}
+			if _, ok := prog.Constants[e.Index].(*types.Function); !ok {
+				return fmt.Errorf("%w: %q: constant %d is not a function", ErrInvalidExport, e.Name, e.Index)
+			}
+		case ExportGlobal:
+			if e.Index < 0 || e.Index >= len(prog.Globals) {
+				return fmt.Errorf("%w: %q: global %d out of range", ErrInvalidExport, e.Name, e.Index)
+			}
+		default:
+			return fmt.Errorf("%w: %q: unknown kind %d", ErrInvalidExport, e.Name, e.Kind)
+		}
+	}
+	return nil
+}
@@ -112,6 +112,18 @@ func TestVerify(t *testing.T) {
 		require.NoError(t, program.Verify(prog))
 	})
 
+	t.Run("calls/cast callee arity", func(t *testing.T) {
+		// ref.cast pushes the type it casts to, so a call through a cast
+		// function reference is checked against that signature.
+		sig := &types.FunctionType{Params: []types.Type{types.TypeI32}, Returns: []types.Type{types.TypeI32}}
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.GLOBAL_GET, 0),
+			instr.New(instr.REF_CAST, 0),
+			instr.New(instr.CALL),
+		}, program.WithGlobals(types.TypeAny), program.WithTypes(sig))
+		require.ErrorIs(t, program.Verify(prog), program.ErrStackUnderflow)
+	})
+
 	t.Run("control/balanced merge", func(t *testing.T) {
 		b := program.NewBuilder()
 		els, end := b.Label(), b.Label()
@@ -182,6 +194,29 @@ func TestVerify(t *testing.T) {
 		require.NoError(t, program.Verify(prog))
 	})
 
+	t.Run("valid/array literal", func(t *testing.T) {
+		// ARRAY_NEW pops its constant count and then that many elements.
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_CONST, 2),
+			instr.New(instr.I32_CONST, 3),
+			instr.New(instr.I32_CONST, 3),
+			instr.New(instr.ARRAY_NEW, 0),
+			instr.New(instr.ARRAY_LEN),
+			instr.New(instr.DROP),
+		}, program.WithTypes(types.NewArrayType(types.TypeI32)))
+		require.NoError(t, program.Verify(prog))
+	})
+
+	t.Run("stack/array literal underflow", func(t *testing.T) {
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_CONST, 2),
+			instr.New(instr.ARRAY_NEW, 0),
+		}, program.WithTypes(types.NewArrayType(types.TypeI32)))
+		require.ErrorIs(t, program.Verify(prog), program.ErrStackUnderflow)
+	})
+
 	t.Run("stack/array delete underflow", func(t *testing.T) {
 		prog := program.New([]instr.Instruction{instr.New(instr.ARRAY_DELETE)})
 		require.ErrorIs(t, program.Verify(prog), program.ErrStackUnderflow)
@@ -299,6 +334,23 @@ func TestVerify(t *testing.T) {
 		require.Equal(t, 1, ve.Slot)
 	})
 
+	t.Run("control/tail call ends a block", func(t *testing.T) {
+		unary := &types.FunctionType{Params: []types.Type{types.TypeI32}, Returns: []types.Type{types.TypeI32}}
+		fn := &types.Function{
+			Typ: unary,
+			Code: instr.Marshal([]instr.Instruction{
+				instr.New(instr.LOCAL_GET, 0),
+				instr.New(instr.LOCAL_GET, 0),
+				instr.New(instr.BR_IF, 4),
+				instr.New(instr.CONST_GET, 0),
+				instr.New(instr.RETURN_CALL),
+				instr.New(instr.RETURN),
+			}),
+		}
+		prog := program.New([]instr.Instruction{instr.New(instr.NOP)}, program.WithConstants(fn))
+		require.NoError(t, program.Verify(prog))
+	})
+
 	t.Run("stack/unbalanced merge", func(t *testing.T) {
 		b := program.NewBuilder()
 		els, end := b.Label(), b.Label()
@@ -346,6 +398,72 @@ func TestVerify(t *testing.T) {
 		}, program.WithHandlers(instr.Handler{Start: 0, End: 99, Catch: 5}))
 		require.ErrorIs(t, program.Verify(prog), program.ErrHandlerRange)
 	})
+
+	t.Run("imports/call through declaration", func(t *testing.T) {
+		sig := &types.FunctionType{Params: []types.Type{types.TypeI32}, Returns: []types.Type{types.TypeI32}}
+		b := program.NewBuilder()
+		inc := b.Import("env.inc", sig)
+		b.Emit(instr.I32_CONST, 1).Emit(instr.CONST_GET, uint64(inc)).Emit(instr.CALL).Emit(instr.DROP)
+		prog, err := b.Build()
+		require.NoError(t, err)
+		require.NoError(t, program.Verify(prog))
+	})
+
+	t.Run("imports/signature mismatch", func(t *testing.T) {
+		sig := &types.FunctionType{Params: []types.Type{types.TypeI32}}
+		prog := program.New(nil,
+			program.WithConstants(program.NewDeclaration(&types.FunctionType{})),
+			program.WithImports(program.Import{Name: "env.log", Typ: sig, Const: 0}),
+		)
+		require.ErrorIs(t, program.Verify(prog), program.ErrInvalidImport)
+	})
+
+	t.Run("imports/constant with body", func(t *testing.T) {
+		fn := types.NewFunctionBuilder(&types.FunctionType{}).Emit(instr.New(instr.RETURN)).MustBuild()
+		prog := program.New(nil,
+			program.WithConstants(fn),
+			program.WithImports(program.Import{Name: "env.run", Typ: fn.Typ, Const: 0}),
+		)
+		require.ErrorIs(t, program.Verify(prog), program.ErrInvalidImport)
+	})
+
+	t.Run("imports/constant out of range", func(t *testing.T) {
+		prog := program.New(nil, program.WithImports(program.Import{Name: "env.run", Typ: &types.FunctionType{}, Const: 3}))
+		require.ErrorIs(t, program.Verify(prog), program.ErrInvalidImport)
+	})
+
+	t.Run("imports/shared constant", func(t *testing.T) {
+		sig := &types.FunctionType{}
+		prog := program.New(nil,
+			program.WithConstants(program.NewDeclaration(sig)),
+			program.WithImports(
+				program.Import{Name: "env.a", Typ: sig, Const: 0},
+				program.Import{Name: "env.b", Typ: sig, Const: 0},
+			),
+		)
+		require.ErrorIs(t, program.Verify(prog), program.ErrInvalidImport)
+	})
+
+	t.Run("exports/duplicate name", func(t *testing.T) {
+		prog := program.New(nil, program.WithGlobals(types.TypeI32), program.WithExports(
+			program.Export{Name: "x", Kind: program.ExportGlobal, Index: 0},
+			program.Export{Name: "x", Kind: program.ExportGlobal, Index: 0},
+		))
+		require.ErrorIs(t, program.Verify(prog), program.ErrInvalidExport)
+	})
+
+	t.Run("exports/non-function constant", func(t *testing.T) {
+		prog := program.New(nil,
+			program.WithConstants(types.I32(1)),
+			program.WithExports(program.Export{Name: "one", Kind: program.ExportFunction, Index: 0}),
+		)
+		require.ErrorIs(t, program.Verify(prog), program.ErrInvalidExport)
+	})
+
+	t.Run("exports/global out of range", func(t *testing.T) {
+		prog := program.New(nil, program.WithExports(program.Export{Name: "g", Kind: program.ExportGlobal, Index: 0}))
+		require.ErrorIs(t, program.Verify(prog), program.ErrInvalidExport)
+	})
 }
 
 func TestVerifyError_Error(t *testing.T) {
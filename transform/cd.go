@@ -30,19 +30,26 @@ func (p *DedupPass) Run(m *pass.Manager, prog *program.Program) (pass.Preserved,
 		ip := 0
 		for ip < len(code) {
 			inst := instr.Instruction(code[ip:])
-			switch inst.Opcode() {
-			case instr.CONST_GET:
+			switch op := inst.Opcode(); {
+			case op == instr.CONST_GET:
 				constUsed[inst.Operand(0)] = true
-			case instr.REF_TEST, instr.REF_CAST,
-				instr.ARRAY_NEW, instr.ARRAY_NEW_DEFAULT,
-				instr.STRUCT_NEW, instr.STRUCT_NEW_DEFAULT,
-				instr.MAP_NEW, instr.MAP_NEW_DEFAULT:
+			case instr.IndexesType(op):
 				typeUsed[inst.Operand(0)] = true
 			default:
 			}
 			ip += inst.Width()
 		}
 	}
+	// Exports and imports name their constants from outside the code, so they
+	// keep those constants alive and follow them through renumbering.
+	for _, e := range prog.Exports {
+		if e.Kind == program.ExportFunction {
+			constUsed[e.Index] = true
+		}
+	}
+	for _, imp := range prog.Imports {
+		constUsed[imp.Const] = true
+	}
 
 	constIndex, constSize := dedupValues(constants, constUsed)
 	typeIndex, typesSize := dedupTypes(typs, typeUsed)
@@ -72,14 +79,11 @@ func (p *DedupPass) Run(m *pass.Manager, prog *program.Program) (pass.Preserved,
 		ip := 0
 		for ip < len(code) {
 			inst := instr.Instruction(code[ip:])
-			switch inst.Opcode() {
-			case instr.CONST_GET:
+			switch op := inst.Opcode(); {
+			case op == instr.CONST_GET:
 				idx := inst.Operand(0)
 				inst.SetOperand(0, uint64(constIndex[idx]))
-			case instr.REF_TEST, instr.REF_CAST,
-				instr.ARRAY_NEW, instr.ARRAY_NEW_DEFAULT,
-				instr.STRUCT_NEW, instr.STRUCT_NEW_DEFAULT,
-				instr.MAP_NEW, instr.MAP_NEW_DEFAULT:
+			case instr.IndexesType(op):
 				idx := inst.Operand(0)
 				inst.SetOperand(0, uint64(typeIndex[idx]))
 			default:
@@ -88,6 +92,15 @@ func (p *DedupPass) Run(m *pass.Manager, prog *program.Program) (pass.Preserved,
 		}
 	}
 
+	for i, e := range prog.Exports {
+		if e.Kind == program.ExportFunction {
+			prog.Exports[i].Index = constIndex[e.Index]
+		}
+	}
+	for i, imp := range prog.Imports {
+		prog.Imports[i].Const = constIndex[imp.Const]
+	}
+
 	prog.Constants = constants
 	prog.Types = typs
 
@@ -136,6 +136,25 @@ func TestDedupPass_Run(t *testing.T) {
 		})
 	}
 
+	t.Run("keeps exported and imported constants", func(t *testing.T) {
+		sig := &types.FunctionType{}
+		main := types.NewFunctionBuilder(sig).Emit(instr.New(instr.RETURN)).MustBuild()
+		decl := program.NewDeclaration(sig)
+		prog := program.New(
+			[]instr.Instruction{instr.New(instr.CONST_GET, 2), instr.New(instr.DROP)},
+			program.WithConstants(types.I32(0), main, types.String("x"), decl),
+			program.WithExports(program.Export{Name: "main", Kind: program.ExportFunction, Index: 1}),
+			program.WithImports(program.Import{Name: "env.f", Typ: sig, Const: 3}),
+		)
+
+		_, err := transform.NewDedupPass().Run(pass.NewManager(), prog)
+		require.NoError(t, err)
+		require.Equal(t, []types.Value{main, types.String("x"), decl}, prog.Constants)
+		require.Equal(t, 0, prog.Exports[0].Index)
+		require.Equal(t, 2, prog.Imports[0].Const)
+		require.NoError(t, program.Verify(prog))
+	})
+
 	t.Run("preserves execution", func(t *testing.T) {
 		prog := program.New(
 			[]instr.Instruction{
@@ -2,11 +2,13 @@ package transform
 
 import (
 	"fmt"
+	"slices"
 
 	"github.com/siyul-park/minivm/analysis"
 	"github.com/siyul-park/minivm/instr"
 	"github.com/siyul-park/minivm/pass"
 	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
 )
 
 type DCEPass struct{}
@@ -25,15 +27,9 @@ func (p *DCEPass) Run(m *pass.Manager, prog *program.Program) (pass.Preserved, e
 		if err != nil {
 			return pass.PreserveNone(), err
 		}
-		catch := map[int]bool{}
-		for _, h := range fn.Handlers {
-			catch[h.Catch] = true
-		}
+		live := p.reachable(fn, blocks)
 		for i := 1; i < len(blocks); i++ {
-			blk := blocks[i]
-			// Catch blocks are entered out of band, so the CFG gives them no
-			// predecessors; they are live roots, not dead code.
-			if len(blk.Preds) == 0 && !catch[blk.Start] {
+			if blk := blocks[i]; !live[i] {
 				for j := blk.Start; j < blk.End; j++ {
 					code[j] = byte(instr.UNREACHABLE)
 				}
@@ -67,6 +63,8 @@ func (p *DCEPass) Run(m *pass.Manager, prog *program.Program) (pass.Preserved, e
 		read := 0
 		write = 0
 		for write < len(code) {
+			for ; read < len(offsets) && offsets[read] != write; read++ {
+			}
 			inst := instr.Instruction(code[write:])
 
 			switch inst.Opcode() {
@@ -91,23 +89,54 @@ func (p *DCEPass) Run(m *pass.Manager, prog *program.Program) (pass.Preserved, e
 			}
 
 			write += inst.Width()
-			for ; read < len(offsets) && offsets[read] != write; read++ {
-			}
 		}
 
 		handlers := p.rehandle(fn.Handlers, offsets, len(code))
 
 		fn.Code = code
 		fn.Handlers = handlers
+		fn.Debug = fn.Debug.Remap(func(ip int) int { return p.relocate(offsets, ip, len(code)) }, len(code))
 		if i == 0 {
-			prog.Code = code
-			prog.Handlers = handlers
+			unroot(prog, fn)
 		}
 	}
 
 	return pass.PreserveNone(), nil
 }
 
+// reachable marks the blocks control can reach from the entry block. Catch
+// blocks are entered out of band, so the CFG gives them no predecessors; a
+// catch block is live once any block its handler protects is, and dead with
+// the rest of a protected region control never enters.
+func (p *DCEPass) reachable(fn *types.Function, blocks []*analysis.BasicBlock) []bool {
+	starts := make(map[int]int, len(blocks))
+	for i, blk := range blocks {
+		starts[blk.Start] = i
+	}
+	live := make([]bool, len(blocks))
+	live[0] = true
+	work := []int{0}
+	for len(work) > 0 {
+		blk := blocks[work[len(work)-1]]
+		work = work[:len(work)-1]
+		succs := blk.Succs
+		for _, h := range fn.Handlers {
+			if h.Start < blk.End && blk.Start < h.End {
+				if s, ok := starts[h.Catch]; ok {
+					succs = append(slices.Clip(succs), s)
+				}
+			}
+		}
+		for _, s := range succs {
+			if !live[s] {
+				live[s] = true
+				work = append(work, s)
+			}
+		}
+	}
+	return live
+}
+
 // validate reports whether a branch target is safe to relocate: target must
 // stay in bounds, and the past-the-end virtual exit (target == size) is only
 // legal for top-level code (slot == 0), matching program.Verify. DCE runs
@@ -122,20 +151,24 @@ func (p *DCEPass) validate(target, size, slot int) error {
 
 // rehandle remaps an exception table through the compaction offset map: each
 // boundary moves to the first surviving instruction at or after its old offset,
-// so a region whose body was removed collapses cleanly. offsets[i] is the new
-// position of the instruction that began at old offset i, or -1 if removed.
+// so a region whose body was removed collapses, and its handler is dropped.
+// offsets[i] is the new position of the instruction that began at old offset
+// i, or -1 if removed.
 func (p *DCEPass) rehandle(handlers []instr.Handler, offsets []int, size int) []instr.Handler {
 	if len(handlers) == 0 {
 		return handlers
 	}
-	remapped := make([]instr.Handler, len(handlers))
-	for i, h := range handlers {
-		remapped[i] = instr.Handler{
+	var remapped []instr.Handler
+	for _, h := range handlers {
+		h = instr.Handler{
 			Start: p.relocate(offsets, h.Start, size),
 			End:   p.relocate(offsets, h.End, size),
 			Catch: p.relocate(offsets, h.Catch, size),
 			Depth: h.Depth,
 		}
+		if h.Start < h.End {
+			remapped = append(remapped, h)
+		}
 	}
 	return remapped
 }
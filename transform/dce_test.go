@@ -164,6 +164,45 @@ func TestDCEPass_Run(t *testing.T) {
 				},
 			),
 		},
+		{
+			program: program.New(
+				[]instr.Instruction{
+					instr.Marshal([]instr.Instruction{
+						instr.New(instr.BR, 9),
+						instr.New(instr.I32_CONST, 7),
+						instr.New(instr.BR, 0),
+						instr.New(instr.DROP),
+						instr.New(instr.I32_CONST, 42),
+					}),
+				},
+			),
+			expected: program.New(
+				[]instr.Instruction{
+					instr.Marshal([]instr.Instruction{
+						instr.New(instr.BR, 0),
+						instr.New(instr.I32_CONST, 42),
+					}),
+				},
+			),
+		},
+		{
+			program: program.New(
+				[]instr.Instruction{
+					instr.Marshal([]instr.Instruction{
+						instr.New(instr.NOP),
+						instr.New(instr.NOP),
+						instr.New(instr.BR, uint64(uint16(-5+1<<16))),
+					}),
+				},
+			),
+			expected: program.New(
+				[]instr.Instruction{
+					instr.Marshal([]instr.Instruction{
+						instr.New(instr.BR, uint64(uint16(-3+1<<16))),
+					}),
+				},
+			),
+		},
 	}
 
 	for _, tt := range tests {
@@ -193,6 +232,32 @@ func TestDCEPass_Run(t *testing.T) {
 		require.ErrorIs(t, err, analysis.ErrInvalidJump)
 	})
 
+	t.Run("remaps debug lines", func(t *testing.T) {
+		l1 := types.Position{File: "main.mvm", Line: 1}
+		l2 := types.Position{File: "main.mvm", Line: 2}
+		l3 := types.Position{File: "main.mvm", Line: 3}
+		prog := program.New(
+			[]instr.Instruction{
+				instr.New(instr.NOP),
+				instr.New(instr.I32_CONST, 1),
+				instr.New(instr.NOP),
+				instr.New(instr.I32_CONST, 2),
+			},
+			program.WithDebug(&program.Debug{
+				Code:    &types.Debug{Name: "main", Lines: []types.Line{{IP: 0, Pos: l1}, {IP: 6, Pos: l2}, {IP: 7, Pos: l3}}},
+				Globals: []string{"g"},
+			}),
+		)
+
+		m := pass.NewManager()
+		pass.Register[*types.Function, []*analysis.BasicBlock](m, analysis.NewBlocksAnalysis())
+		_, err := transform.NewDCEPass().Run(m, prog)
+		require.NoError(t, err)
+		require.Equal(t, "main", prog.Debug.Code.Name)
+		require.Equal(t, []types.Line{{IP: 0, Pos: l1}, {IP: 5, Pos: l3}}, prog.Debug.Code.Lines)
+		require.Equal(t, []string{"g"}, prog.Debug.Globals)
+	})
+
 	t.Run("preserves execution", func(t *testing.T) {
 		builder := program.NewBuilder()
 		live := builder.Label()
@@ -38,15 +38,11 @@ func (p *GVNPass) Run(m *pass.Manager, prog *program.Program) (pass.Preserved, e
 			continue
 		}
 
-		code, handlers, ok := p.eliminate(fn, gvn, i > 0)
-		if !ok {
+		if !p.eliminate(fn, gvn, i > 0) {
 			continue
 		}
-		fn.Code = code
-		fn.Handlers = handlers
 		if i == 0 {
-			prog.Code = code
-			prog.Handlers = handlers
+			unroot(prog, fn)
 		}
 	}
 	return pass.PreserveNone(), nil
@@ -55,9 +51,9 @@ func (p *GVNPass) Run(m *pass.Manager, prog *program.Program) (pass.Preserved, e
 // eliminate rewrites fn to drop the redundant expressions. allocate enables
 // fresh-slot capture; it is false for the top-level body. A captured value gets
 // one fresh local shared by all its uses, with a LOCAL_TEE inserted at every
-// definition so the slot holds the value on every path. It reports the new code
-// and handlers, or ok=false to leave fn unchanged.
-func (p *GVNPass) eliminate(fn *types.Function, gvn *analysis.GVN, allocate bool) ([]byte, []instr.Handler, bool) {
+// definition so the slot holds the value on every path. It reports whether fn
+// was rewritten; on false fn is left unchanged.
+func (p *GVNPass) eliminate(fn *types.Function, gvn *analysis.GVN, allocate bool) bool {
 	reds := make([]analysis.Redundancy, 0, len(gvn.Redundant))
 	for _, r := range gvn.Redundant {
 		reds = append(reds, r)
@@ -86,7 +82,7 @@ func (p *GVNPass) eliminate(fn *types.Function, gvn *analysis.GVN, allocate bool
 		slot, ok := slots[c.Def]
 		if !ok {
 			defs := gvn.Defs[c.Def]
-			t := p.kindType(c.Kind)
+			t := kindType(c.Kind)
 			idx := base + len(added)
 			if t == nil || idx > math.MaxUint8 || len(defs) == 0 || p.covered(chosen, defs) {
 				continue
@@ -102,18 +98,21 @@ func (p *GVNPass) eliminate(fn *types.Function, gvn *analysis.GVN, allocate bool
 		applied = true
 	}
 	if !applied {
-		return nil, nil, false
+		return false
 	}
 
 	code, handlers, ok := r.run()
 	if !ok {
-		return nil, nil, false
+		return false
 	}
 	for k := range handlers {
 		handlers[k].Depth += len(added)
 	}
 	fn.Locals = append(fn.Locals, added...)
-	return code, handlers, true
+	fn.Code = code
+	fn.Handlers = handlers
+	fn.Debug = r.debug
+	return true
 }
 
 // choose selects a non-overlapping subset of the redundant ranges, lowest offset
@@ -149,7 +148,7 @@ func (p *GVNPass) covered(chosen []analysis.Redundancy, offs []int) bool {
 
 // kindType maps a value kind to the primitive type used to declare a captured
 // local, or nil when the kind has no concrete slot type.
-func (p *GVNPass) kindType(k instr.Kind) types.Type {
+func kindType(k instr.Kind) types.Type {
 	switch k {
 	case instr.KindI32:
 		return types.TypeI32
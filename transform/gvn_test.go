@@ -57,6 +57,37 @@ func TestGVNPass_Run(t *testing.T) {
 		require.NoError(t, program.Verify(prog))
 	})
 
+	t.Run("remaps debug lines", func(t *testing.T) {
+		l1 := types.Position{File: "f.mvm", Line: 1}
+		l2 := types.Position{File: "f.mvm", Line: 2}
+		l3 := types.Position{File: "f.mvm", Line: 3}
+		fn := types.NewFunctionBuilder(i32t).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.I32_ADD),
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.I32_ADD),
+			instr.New(instr.I32_ADD),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		fn.Debug = &types.Debug{Name: "f", Lines: []types.Line{{IP: 0, Pos: l1}, {IP: 5, Pos: l2}, {IP: 10, Pos: l3}}}
+		prog := program.New(nil, program.WithConstants(fn))
+
+		manager := pass.NewManager()
+		pass.Register(manager, analysis.NewBlocksAnalysis())
+		pass.Register(manager, analysis.NewGVNAnalysis())
+		_, err := transform.NewGVNPass().Run(manager, prog)
+		require.NoError(t, err)
+
+		// The LOCAL_TEE capturing the first sum stays on line 1; the reload
+		// replacing the second sum and the final add keep their own lines.
+		require.Equal(t, []types.Line{{IP: 0, Pos: l1}, {IP: 7, Pos: l2}, {IP: 9, Pos: l3}}, fn.Debug.Lines)
+		pos, ok := fn.Debug.Position(5)
+		require.True(t, ok)
+		require.Equal(t, l1, pos)
+	})
+
 	t.Run("captures a value recomputed at a control-flow merge", func(t *testing.T) {
 		fb := types.NewFunctionBuilder(i32t)
 		then, merge := fb.Label(), fb.Label()
@@ -0,0 +1,457 @@
+package transform
+
+import (
+	"math"
+	"slices"
+
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/pass"
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
+)
+
+// InlinePass replaces calls of small function constants with the callee's
+// body. A CONST_GET of an eligible callee immediately followed by CALL becomes
+// a prologue that moves the arguments into fresh caller locals and zeroes the
+// callee's declared locals, followed by the callee's code with its locals
+// renumbered and every RETURN turned into a branch past the body. The callee's
+// handlers are merged ahead of the caller's, so they stay innermost, with
+// their depths rebased onto the operand stack at the call site.
+//
+// A callee is eligible when it is not an import, its code fits inlineBudget,
+// it never refers to its own constant, yields, tail-calls, or reads upvalues,
+// and its operand stack height is statically known wherever it returns.
+// Calls are inlined one level deep per run. In the top-level body, which gets
+// no fresh locals, only callees without params or locals are inlined.
+type InlinePass struct{}
+
+// inlinee is a callee lowered for inlining: its code with each RETURN
+// replaced by a branch past the end, and its handlers moved with it. It keeps
+// its own copy of the callee's locals, which grow if the callee is itself
+// rewritten as a caller later in the run.
+type inlinee struct {
+	fn       *types.Function
+	locals   []types.Type
+	code     []byte
+	handlers []instr.Handler
+}
+
+// inlineBudget is the largest callee body, in bytes, that InlinePass inlines.
+const inlineBudget = 64
+
+var _ pass.Pass[*program.Program] = (*InlinePass)(nil)
+
+func NewInlinePass() *InlinePass {
+	return &InlinePass{}
+}
+
+func (p *InlinePass) Run(_ *pass.Manager, prog *program.Program) (pass.Preserved, error) {
+	// Imports are declarations the host binds at instantiation, so their
+	// constants have no body to inline.
+	imported := map[int]bool{}
+	for _, imp := range prog.Imports {
+		imported[imp.Const] = true
+	}
+	callees := map[int]*inlinee{}
+	for idx, v := range prog.Constants {
+		if fn, ok := v.(*types.Function); ok && !imported[idx] {
+			if c := p.lower(prog, idx, fn); c != nil {
+				callees[idx] = c
+			}
+		}
+	}
+	if len(callees) == 0 {
+		return pass.PreserveAll(), nil
+	}
+
+	changed := false
+	for i, fn := range functions(prog) {
+		if !p.inline(prog, fn, callees, i > 0) {
+			continue
+		}
+		changed = true
+		if i == 0 {
+			unroot(prog, fn)
+		}
+	}
+	if !changed {
+		return pass.PreserveAll(), nil
+	}
+	return pass.PreserveNone(), nil
+}
+
+// lower prepares the function constant at idx for inlining, or returns nil
+// when it is not eligible.
+func (p *InlinePass) lower(prog *program.Program, idx int, fn *types.Function) *inlinee {
+	if fn.Typ == nil || len(fn.Code) > inlineBudget {
+		return nil
+	}
+	for ip := 0; ip < len(fn.Code); {
+		inst := instr.Instruction(fn.Code[ip:])
+		switch inst.Opcode() {
+		case instr.YIELD, instr.RETURN_CALL, instr.UPVAL_GET, instr.UPVAL_SET:
+			return nil
+		case instr.CONST_GET:
+			if int(inst.Operand(0)) == idx {
+				return nil
+			}
+		}
+		ip += inst.Width()
+	}
+
+	heights := p.heights(prog, fn)
+	if heights == nil {
+		return nil
+	}
+
+	returns := len(fn.Typ.Returns)
+	r := newRewriter(fn)
+	var exits []int
+	for ip := 0; ip < len(fn.Code); {
+		inst := instr.Instruction(fn.Code[ip:])
+		if inst.Opcode() == instr.RETURN {
+			excess := 0
+			if heights[ip] >= 0 {
+				excess = heights[ip] - returns
+			}
+			drops, ok := p.unwind(excess, returns)
+			if !ok {
+				return nil
+			}
+			if ip+1 == len(fn.Code) {
+				r.replace(ip, ip+1, drops...)
+			} else {
+				r.replace(ip, ip+1, append(drops, instr.New(instr.BR, 0))...)
+				exits = append(exits, ip)
+			}
+		}
+		ip += inst.Width()
+	}
+	code, handlers, ok := r.run()
+	if !ok {
+		return nil
+	}
+	// Each exit's branch ends where the instruction after its RETURN now starts.
+	width := len(instr.New(instr.BR, 0))
+	for _, ip := range exits {
+		at := r.remap[ip+1] - width
+		instr.Instruction(code[at:]).SetOperand(0, uint64(len(code)-at-width))
+	}
+	return &inlinee{fn: fn, locals: slices.Clone(fn.Locals), code: code, handlers: handlers}
+}
+
+// unwind returns the instructions that drop excess operands from beneath the
+// top returns values. It reports false when they cannot be reached.
+func (p *InlinePass) unwind(excess, returns int) ([]instr.Instruction, bool) {
+	if excess < 0 || (excess > 0 && returns > 1) {
+		return nil, false
+	}
+	var drops []instr.Instruction
+	for range excess {
+		if returns == 1 {
+			drops = append(drops, instr.New(instr.SWAP))
+		}
+		drops = append(drops, instr.New(instr.DROP))
+	}
+	return drops, true
+}
+
+// inline rewrites fn to inline every eligible call site. allocate enables
+// fresh locals; it is false for the top-level body. A callee inlined at several
+// sites shares one set of locals, since the prologue resets them on entry. It
+// reports whether fn was rewritten; on false fn is left unchanged.
+func (p *InlinePass) inline(prog *program.Program, fn *types.Function, callees map[int]*inlinee, allocate bool) bool {
+	type site struct {
+		at     int
+		body   int
+		height int
+		callee *inlinee
+	}
+
+	base := len(fn.Locals)
+	if fn.Typ != nil {
+		base += len(fn.Typ.Params)
+	}
+	bounds := bounds(fn)
+
+	r := newRewriter(fn)
+	slots := map[int]int{}
+	var added []types.Type
+	var sites []site
+	var heights []int
+	prev := -1
+	for ip := 0; ip < len(fn.Code); {
+		inst := instr.Instruction(fn.Code[ip:])
+		width := inst.Width()
+		if inst.Opcode() == instr.CALL && prev >= 0 && !bounds[ip] && instr.Instruction(fn.Code[prev:]).Opcode() == instr.CONST_GET {
+			idx := int(instr.Instruction(fn.Code[prev:]).Operand(0))
+			if c, ok := callees[idx]; ok && c.fn != fn {
+				height := 0
+				if len(c.handlers) > 0 {
+					if heights == nil {
+						heights = p.heights(prog, fn)
+					}
+					if heights != nil && heights[prev] >= 0 {
+						height = heights[prev] - len(c.fn.Typ.Params)
+					} else {
+						height = -1
+					}
+				}
+
+				slot, ok := slots[idx]
+				n := len(c.fn.Typ.Params) + len(c.locals)
+				if !ok && height >= 0 && (n == 0 || (allocate && base+len(added)+n <= math.MaxUint8+1)) {
+					slot = base + len(added)
+					added = append(added, c.fn.Typ.Params...)
+					added = append(added, c.locals...)
+					slots[idx] = slot
+					ok = true
+				}
+				if ok && height >= 0 {
+					code, body := p.expand(c, slot)
+					r.splice(prev, ip+width, code)
+					sites = append(sites, site{at: prev, body: body, height: height, callee: c})
+				}
+			}
+		}
+		prev = ip
+		ip += width
+	}
+	if len(sites) == 0 {
+		return false
+	}
+
+	code, handlers, ok := r.run()
+	if !ok {
+		return false
+	}
+	for k := range handlers {
+		handlers[k].Depth += len(added)
+	}
+	total := base + len(added)
+	var merged []instr.Handler
+	for _, s := range sites {
+		at := r.remap[s.at] + s.body
+		depth := total + s.height - len(s.callee.fn.Typ.Params) - len(s.callee.locals)
+		for _, h := range s.callee.handlers {
+			merged = append(merged, instr.Handler{
+				Start: at + h.Start,
+				End:   at + h.End,
+				Catch: at + h.Catch,
+				Depth: depth + h.Depth,
+			})
+		}
+	}
+	fn.Locals = append(fn.Locals, added...)
+	fn.Code = code
+	fn.Handlers = append(merged, handlers...)
+	fn.Debug = r.debug
+	return true
+}
+
+// expand returns the code inlined for a call of c whose locals start at slot,
+// and the offset at which the callee's body begins within it.
+func (p *InlinePass) expand(c *inlinee, slot int) ([]byte, int) {
+	params := len(c.fn.Typ.Params)
+
+	var prologue []instr.Instruction
+	for k := params - 1; k >= 0; k-- {
+		prologue = append(prologue, instr.New(instr.LOCAL_SET, uint64(slot+k)))
+	}
+	for k, t := range c.locals {
+		prologue = append(prologue, p.zero(t), instr.New(instr.LOCAL_SET, uint64(slot+params+k)))
+	}
+	code := instr.Marshal(prologue)
+	body := len(code)
+
+	code = append(code, c.code...)
+	for ip := body; ip < len(code); {
+		inst := instr.Instruction(code[ip:])
+		switch inst.Opcode() {
+		case instr.LOCAL_GET, instr.LOCAL_SET, instr.LOCAL_TEE:
+			inst.SetOperand(0, inst.Operand(0)+uint64(slot))
+		}
+		ip += inst.Width()
+	}
+	return code, body
+}
+
+// zero returns the instruction pushing the zero value of a local of type t.
+func (p *InlinePass) zero(t types.Type) instr.Instruction {
+	switch t.Kind().Repr() {
+	case types.KindI32:
+		return instr.New(instr.I32_CONST, 0)
+	case types.KindI64:
+		return instr.New(instr.I64_CONST, 0)
+	case types.KindF32:
+		return instr.New(instr.F32_CONST, 0)
+	case types.KindF64:
+		return instr.New(instr.F64_CONST, 0)
+	default:
+		return instr.New(instr.REF_NULL)
+	}
+}
+
+// heights returns the operand stack height, above the locals, before each
+// instruction of fn, or -1 where it is unreachable. It returns nil when a
+// reachable instruction's stack effect is not statically known, such as a
+// CALL whose callee is not named by the instruction right before it, or an
+// ARRAY_NEW whose count is not.
+func (p *InlinePass) heights(prog *program.Program, fn *types.Function) []int {
+	code := fn.Code
+	slots := len(fn.Locals)
+	if fn.Typ != nil {
+		slots += len(fn.Typ.Params)
+	}
+	bounds := bounds(fn)
+
+	prevs := make([]int, len(code)+1)
+	prev := -1
+	for ip := 0; ip < len(code); {
+		prevs[ip] = prev
+		prev = ip
+		ip += instr.Instruction(code[ip:]).Width()
+	}
+
+	heights := make([]int, len(code)+1)
+	for k := range heights {
+		heights[k] = -1
+	}
+	var work []int
+	visit := func(ip, height int) bool {
+		if ip < 0 || ip > len(code) || height < 0 {
+			return false
+		}
+		if heights[ip] < 0 {
+			heights[ip] = height
+			work = append(work, ip)
+		}
+		return heights[ip] == height
+	}
+	if len(code) > 0 {
+		visit(0, 0)
+	}
+	for _, h := range fn.Handlers {
+		if !visit(h.Catch, h.Depth-slots+1) {
+			return nil
+		}
+	}
+
+	for len(work) > 0 {
+		ip := work[len(work)-1]
+		work = work[:len(work)-1]
+		if ip == len(code) {
+			continue
+		}
+		inst := instr.Instruction(code[ip:])
+		op := inst.Opcode()
+		height := heights[ip]
+
+		switch op {
+		case instr.BR, instr.BR_IF, instr.BR_TABLE:
+			if op != instr.BR {
+				height--
+			}
+			for _, target := range instr.Targets(code, ip) {
+				if !visit(target, height) {
+					return nil
+				}
+			}
+			if op == instr.BR {
+				continue
+			}
+		case instr.RETURN, instr.RETURN_CALL, instr.THROW, instr.UNREACHABLE:
+			continue
+		case instr.NOP, instr.SWAP, instr.LOCAL_TEE, instr.GLOBAL_TEE:
+		case instr.DUP, instr.LOCAL_GET, instr.UPVAL_GET, instr.CONST_GET:
+			height++
+		case instr.SELECT:
+			height -= 2
+		case instr.CALL:
+			typ := p.callType(prog, code, prevs[ip])
+			if typ == nil || bounds[ip] {
+				return nil
+			}
+			height += len(typ.Returns) - len(typ.Params) - 1
+		case instr.STRUCT_NEW:
+			t, ok := prog.Types[inst.Operand(0)].(*types.StructType)
+			if !ok {
+				return nil
+			}
+			height += 1 - len(t.Fields)
+		case instr.ARRAY_NEW, instr.MAP_NEW:
+			// Both pop a count and then that many elements, or key and value
+			// pairs, so their effect is known only from an I32_CONST count.
+			n, ok := p.count(code, prevs[ip])
+			if !ok || bounds[ip] {
+				return nil
+			}
+			if op == instr.MAP_NEW {
+				n *= 2
+			}
+			height -= n
+		default:
+			t := inst.Type()
+			if t.Pop == nil && t.Push == nil {
+				return nil
+			}
+			height += len(t.Push) - len(t.Pop)
+		}
+		if !visit(ip+inst.Width(), height) {
+			return nil
+		}
+	}
+	return heights
+}
+
+// callType returns the signature of the callee the instruction at prev pushes
+// for a following CALL, or nil when it is not statically known.
+func (p *InlinePass) callType(prog *program.Program, code []byte, prev int) *types.FunctionType {
+	if prev < 0 {
+		return nil
+	}
+	inst := instr.Instruction(code[prev:])
+	switch inst.Opcode() {
+	case instr.CONST_GET:
+		if fn, ok := prog.Constants[inst.Operand(0)].(*types.Function); ok {
+			return fn.Typ
+		}
+	case instr.REF_CAST:
+		if typ, ok := prog.Types[inst.Operand(0)].(*types.FunctionType); ok {
+			return typ
+		}
+	}
+	return nil
+}
+
+// count returns the element count the instruction at prev pushes for a
+// following ARRAY_NEW or MAP_NEW, when it is an I32_CONST.
+func (p *InlinePass) count(code []byte, prev int) (int, bool) {
+	if prev < 0 {
+		return 0, false
+	}
+	inst := instr.Instruction(code[prev:])
+	if inst.Opcode() != instr.I32_CONST {
+		return 0, false
+	}
+	n := int(int32(inst.Operand(0)))
+	return n, n >= 0
+}
+
+// bounds returns the offsets control can reach other than by falling through:
+// branch targets and handler boundaries.
+func bounds(fn *types.Function) map[int]bool {
+	bounds := map[int]bool{}
+	for ip := 0; ip < len(fn.Code); {
+		for _, target := range instr.Targets(fn.Code, ip) {
+			bounds[target] = true
+		}
+		ip += instr.Instruction(fn.Code[ip:]).Width()
+	}
+	for _, h := range fn.Handlers {
+		bounds[h.Start] = true
+		bounds[h.End] = true
+		bounds[h.Catch] = true
+	}
+	return bounds
+}
@@ -0,0 +1,337 @@
+package transform_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/interp"
+	"github.com/siyul-park/minivm/pass"
+	"github.com/siyul-park/minivm/program"
+	transform "github.com/siyul-park/minivm/transform"
+	"github.com/siyul-park/minivm/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewInlinePass(t *testing.T) {
+	require.NotNil(t, transform.NewInlinePass())
+}
+
+func TestInlinePass_Run(t *testing.T) {
+	unary := &types.FunctionType{Params: []types.Type{types.TypeI32}, Returns: []types.Type{types.TypeI32}}
+
+	// caller builds a program whose top-level code passes arg to a function
+	// that calls callee, constant 0, with its own operand beneath the call.
+	caller := func(callee *types.Function, arg uint64) *program.Program {
+		outer := types.NewFunctionBuilder(unary).Emit(
+			instr.New(instr.I32_CONST, 100),
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+			instr.New(instr.I32_ADD),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		return program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, arg),
+			instr.New(instr.CONST_GET, 1),
+			instr.New(instr.CALL),
+		}, program.WithConstants(callee, outer))
+	}
+
+	t.Run("inlines a small callee", func(t *testing.T) {
+		inc := types.NewFunctionBuilder(unary).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_ADD),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		prog := caller(inc, 41)
+
+		_, err := transform.NewInlinePass().Run(pass.NewManager(), prog)
+		require.NoError(t, err)
+		require.NoError(t, program.Verify(prog))
+
+		outer := prog.Constants[1].(*types.Function)
+		require.Equal(t, instr.Format(instr.Marshal([]instr.Instruction{
+			instr.New(instr.I32_CONST, 100),
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_ADD),
+			instr.New(instr.I32_ADD),
+			instr.New(instr.RETURN),
+		})), instr.Format(outer.Code))
+		require.Equal(t, []types.Type{types.TypeI32}, outer.Locals)
+	})
+
+	t.Run("zeroes callee locals", func(t *testing.T) {
+		acc := types.NewFunctionBuilder(unary).Locals(types.TypeI32).Emit(
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_ADD),
+			instr.New(instr.LOCAL_TEE, 1),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		prog := caller(acc, 5)
+
+		_, err := transform.NewInlinePass().Run(pass.NewManager(), prog)
+		require.NoError(t, err)
+		require.NoError(t, program.Verify(prog))
+
+		outer := prog.Constants[1].(*types.Function)
+		require.Equal(t, instr.Format(instr.Marshal([]instr.Instruction{
+			instr.New(instr.I32_CONST, 100),
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.I32_CONST, 0),
+			instr.New(instr.LOCAL_SET, 2),
+			instr.New(instr.LOCAL_GET, 2),
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.I32_ADD),
+			instr.New(instr.LOCAL_TEE, 2),
+			instr.New(instr.I32_ADD),
+			instr.New(instr.RETURN),
+		})), instr.Format(outer.Code))
+		require.Equal(t, []types.Type{types.TypeI32, types.TypeI32}, outer.Locals)
+	})
+
+	t.Run("unwinds excess operands at an early return", func(t *testing.T) {
+		b := types.NewFunctionBuilder(unary)
+		positive := b.Label()
+		abs := b.Emit(
+			instr.New(instr.I32_CONST, 9),
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_CONST, 0),
+			instr.New(instr.I32_GE_S),
+		).BrIf(positive).Emit(
+			instr.New(instr.I32_CONST, 0),
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_SUB),
+			instr.New(instr.RETURN),
+		).Bind(positive).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.RETURN),
+		).MustBuild()
+
+		prog := caller(abs, 3)
+
+		_, err := transform.NewInlinePass().Run(pass.NewManager(), prog)
+		require.NoError(t, err)
+		require.NoError(t, program.Verify(prog))
+		require.Equal(t, instr.Format(instr.Marshal([]instr.Instruction{
+			instr.New(instr.I32_CONST, 100),
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.I32_CONST, 9),
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.I32_CONST, 0),
+			instr.New(instr.I32_GE_S),
+			instr.New(instr.BR_IF, 13),
+			instr.New(instr.I32_CONST, 0),
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.I32_SUB),
+			instr.New(instr.SWAP),
+			instr.New(instr.DROP),
+			instr.New(instr.BR, 4),
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.SWAP),
+			instr.New(instr.DROP),
+			instr.New(instr.I32_ADD),
+			instr.New(instr.RETURN),
+		})), instr.Format(prog.Constants[1].(*types.Function).Code))
+	})
+
+	t.Run("merges callee handlers", func(t *testing.T) {
+		b := types.NewFunctionBuilder(unary)
+		start, end, catch := b.Label(), b.Label(), b.Label()
+		safe := b.Bind(start).Emit(
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_DIV_S),
+		).Bind(end).Emit(
+			instr.New(instr.RETURN),
+		).Bind(catch).Emit(
+			instr.New(instr.DROP),
+			instr.New(instr.I32_CONST, 7),
+			instr.New(instr.RETURN),
+		).Try(start, end, catch, 1).MustBuild()
+		prog := caller(safe, 0)
+
+		_, err := transform.NewInlinePass().Run(pass.NewManager(), prog)
+		require.NoError(t, err)
+		require.NoError(t, program.Verify(prog))
+
+		outer := prog.Constants[1].(*types.Function)
+		require.Equal(t, instr.Format(instr.Marshal([]instr.Instruction{
+			instr.New(instr.I32_CONST, 100),
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.I32_DIV_S),
+			instr.New(instr.BR, 6),
+			instr.New(instr.DROP),
+			instr.New(instr.I32_CONST, 7),
+			instr.New(instr.I32_ADD),
+			instr.New(instr.RETURN),
+		})), instr.Format(outer.Code))
+		require.Equal(t, []instr.Handler{{Start: 9, End: 17, Catch: 20, Depth: 3}}, outer.Handlers)
+	})
+
+	t.Run("rebases handlers above an array literal", func(t *testing.T) {
+		b := types.NewFunctionBuilder(unary)
+		start, end, catch := b.Label(), b.Label(), b.Label()
+		safe := b.Bind(start).Emit(
+			instr.New(instr.I32_CONST, 10),
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_DIV_S),
+		).Bind(end).Emit(
+			instr.New(instr.RETURN),
+		).Bind(catch).Emit(
+			instr.New(instr.DROP),
+			instr.New(instr.I32_CONST, 7),
+			instr.New(instr.RETURN),
+		).Try(start, end, catch, 1).MustBuild()
+		outer := types.NewFunctionBuilder(unary).Emit(
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_CONST, 2),
+			instr.New(instr.I32_CONST, 2),
+			instr.New(instr.ARRAY_NEW, 0),
+			instr.New(instr.ARRAY_LEN),
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+			instr.New(instr.I32_ADD),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 0),
+			instr.New(instr.CONST_GET, 1),
+			instr.New(instr.CALL),
+		}, program.WithConstants(safe, outer), program.WithTypes(types.NewArrayType(types.TypeI32)))
+
+		_, err := transform.NewInlinePass().Run(pass.NewManager(), prog)
+		require.NoError(t, err)
+		require.NoError(t, program.Verify(prog))
+
+		outer = prog.Constants[1].(*types.Function)
+		require.Equal(t, instr.Format(instr.Marshal([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_CONST, 2),
+			instr.New(instr.I32_CONST, 2),
+			instr.New(instr.ARRAY_NEW, 0),
+			instr.New(instr.ARRAY_LEN),
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.I32_CONST, 10),
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.I32_DIV_S),
+			instr.New(instr.BR, 6),
+			instr.New(instr.DROP),
+			instr.New(instr.I32_CONST, 7),
+			instr.New(instr.I32_ADD),
+			instr.New(instr.RETURN),
+		})), instr.Format(outer.Code))
+		require.Equal(t, []instr.Handler{{Start: 23, End: 31, Catch: 34, Depth: 3}}, outer.Handlers)
+	})
+
+	t.Run("skips a recursive callee", func(t *testing.T) {
+		b := types.NewFunctionBuilder(unary)
+		done := b.Label()
+		down := b.Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_EQZ),
+		).BrIf(done).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_SUB),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+			instr.New(instr.RETURN),
+		).Bind(done).Emit(
+			instr.New(instr.I32_CONST, 0),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		prog := caller(down, 3)
+		before := instr.Format(prog.Constants[1].(*types.Function).Code)
+
+		preserved, err := transform.NewInlinePass().Run(pass.NewManager(), prog)
+		require.NoError(t, err)
+		require.Equal(t, pass.PreserveAll(), preserved)
+		require.Equal(t, before, instr.Format(prog.Constants[1].(*types.Function).Code))
+	})
+
+	t.Run("skips an import", func(t *testing.T) {
+		b := program.NewBuilder()
+		inc := b.Import("env.inc", unary)
+		b.Emit(instr.I32_CONST, 1).Emit(instr.CONST_GET, uint64(inc)).Emit(instr.CALL)
+		prog, err := b.Build()
+		require.NoError(t, err)
+		code := prog.Code
+
+		_, err = transform.NewInlinePass().Run(pass.NewManager(), prog)
+		require.NoError(t, err)
+		require.Equal(t, code, prog.Code)
+	})
+
+	t.Run("top level inlines only callees without slots", func(t *testing.T) {
+		answer := types.NewFunctionBuilder(&types.FunctionType{Returns: []types.Type{types.TypeI32}}).Emit(
+			instr.New(instr.I32_CONST, 42),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		inc := types.NewFunctionBuilder(unary).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_ADD),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+			instr.New(instr.CONST_GET, 1),
+			instr.New(instr.CALL),
+		}, program.WithConstants(answer, inc))
+
+		_, err := transform.NewInlinePass().Run(pass.NewManager(), prog)
+		require.NoError(t, err)
+		require.NoError(t, program.Verify(prog))
+		require.Equal(t, instr.Format(instr.Marshal([]instr.Instruction{
+			instr.New(instr.I32_CONST, 42),
+			instr.New(instr.CONST_GET, 1),
+			instr.New(instr.CALL),
+		})), instr.Format(prog.Code))
+	})
+
+	t.Run("preserves execution", func(t *testing.T) {
+		b := types.NewFunctionBuilder(unary)
+		start, end, catch := b.Label(), b.Label(), b.Label()
+		safe := b.Bind(start).Emit(
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_DIV_S),
+		).Bind(end).Emit(
+			instr.New(instr.RETURN),
+		).Bind(catch).Emit(
+			instr.New(instr.DROP),
+			instr.New(instr.I32_CONST, 7),
+			instr.New(instr.RETURN),
+		).Try(start, end, catch, 1).MustBuild()
+		prog := caller(safe, 0)
+		before := interp.New(prog, interp.WithTick(1), interp.WithThreshold(-1))
+		defer before.Close()
+		require.NoError(t, before.Run(context.Background()))
+		want, err := before.Pop()
+		require.NoError(t, err)
+		require.Equal(t, types.I32(107), want)
+
+		_, err = transform.NewInlinePass().Run(pass.NewManager(), prog)
+		require.NoError(t, err)
+		after := interp.New(prog, interp.WithTick(1), interp.WithThreshold(-1))
+		defer after.Close()
+		require.NoError(t, after.Run(context.Background()))
+		got, err := after.Pop()
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	})
+}
@@ -0,0 +1,267 @@
+package transform
+
+import (
+	"math"
+	"slices"
+
+	"github.com/siyul-park/minivm/analysis"
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/pass"
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
+)
+
+// LICMPass hoists loop-invariant computations out of loops. Within each loop
+// it finds maximal contiguous expressions of pure, non-trapping ops
+// (analysis.IsPure without analysis.MayTrap) over invariant leaves: constants,
+// locals the loop never writes, and globals it never writes when it neither
+// calls, yields, nor resumes. Each is computed once into a fresh local on the
+// way into the loop and reloaded inside it. Since the hoisted code can neither
+// trap nor write anything, running it when the loop body would not is safe.
+//
+// The hoisted code runs where the preheader enters the loop: at the header
+// when the preheader falls through into it, so back edges skip it, or before
+// the preheader's BR to the header. Loops entered any other way are left
+// alone. Inner loops are handled first and each expression leaves one loop per
+// run. The top-level body is skipped, as hoisting needs a fresh local.
+type LICMPass struct{}
+
+// span is a contiguous expression [start, end) producing a value of kind.
+type span struct {
+	start int
+	end   int
+	kind  instr.Kind
+}
+
+// invariant is one operand-stack entry while scanning a loop block.
+type invariant struct {
+	span
+	ok bool
+}
+
+var _ pass.Pass[*program.Program] = (*LICMPass)(nil)
+
+func NewLICMPass() *LICMPass {
+	return &LICMPass{}
+}
+
+func (p *LICMPass) Run(m *pass.Manager, prog *program.Program) (pass.Preserved, error) {
+	changed := false
+	for i, fn := range functions(prog) {
+		if i == 0 {
+			continue
+		}
+		loops, err := pass.GetResult[[]*analysis.Loop](m, fn)
+		if err != nil {
+			return pass.PreserveNone(), err
+		}
+		if len(loops) == 0 {
+			continue
+		}
+		blocks, err := pass.GetResult[[]*analysis.BasicBlock](m, fn)
+		if err != nil {
+			return pass.PreserveNone(), err
+		}
+		if p.hoist(fn, blocks, loops) {
+			changed = true
+		}
+	}
+	if !changed {
+		return pass.PreserveAll(), nil
+	}
+	return pass.PreserveNone(), nil
+}
+
+// hoist rewrites fn to move each loop's invariant expressions into fresh
+// locals. It reports whether fn was rewritten; on false fn is left unchanged.
+func (p *LICMPass) hoist(fn *types.Function, blocks []*analysis.BasicBlock, loops []*analysis.Loop) bool {
+	base := len(fn.Locals)
+	if fn.Typ != nil {
+		base += len(fn.Typ.Params)
+	}
+	bounds := bounds(fn)
+
+	inner := slices.Clone(loops)
+	slices.SortStableFunc(inner, func(a, b *analysis.Loop) int { return len(a.Blocks) - len(b.Blocks) })
+
+	r := newRewriter(fn)
+	var taken []span
+	var added []types.Type
+	for _, loop := range inner {
+		at, ok := p.entry(fn, blocks, loop, bounds)
+		if !ok {
+			continue
+		}
+		slots := map[string]int{}
+		var hoisted []byte
+		for _, e := range p.invariants(fn, blocks, loop) {
+			t := kindType(e.kind)
+			if t == nil || slices.ContainsFunc(taken, func(s span) bool { return s.start < e.end && e.start < s.end }) {
+				continue
+			}
+			key := string(fn.Code[e.start:e.end])
+			slot, ok := slots[key]
+			if !ok {
+				slot = base + len(added)
+				if slot > math.MaxUint8 {
+					continue
+				}
+				added = append(added, t)
+				slots[key] = slot
+				hoisted = append(hoisted, fn.Code[e.start:e.end]...)
+				hoisted = append(hoisted, instr.New(instr.LOCAL_SET, uint64(slot))...)
+			}
+			r.replace(e.start, e.end, instr.New(instr.LOCAL_GET, uint64(slot)))
+			taken = append(taken, e)
+		}
+		if len(hoisted) > 0 {
+			r.splice(at, at, hoisted)
+		}
+	}
+	if len(taken) == 0 {
+		return false
+	}
+
+	code, handlers, ok := r.run()
+	if !ok {
+		return false
+	}
+	for k := range handlers {
+		handlers[k].Depth += len(added)
+	}
+	fn.Locals = append(fn.Locals, added...)
+	fn.Code = code
+	fn.Handlers = handlers
+	fn.Debug = r.debug
+	return true
+}
+
+// entry returns the offset where code runs exactly once each time control
+// enters loop from its preheader, and reports false when there is none.
+func (p *LICMPass) entry(fn *types.Function, blocks []*analysis.BasicBlock, loop *analysis.Loop, bounds map[int]bool) (int, bool) {
+	if loop.Preheader < 0 {
+		return 0, false
+	}
+	header := blocks[loop.Header]
+	pre := blocks[loop.Preheader]
+
+	last := pre.Start
+	for ip := pre.Start; ip < pre.End; ip += instr.Instruction(fn.Code[ip:]).Width() {
+		last = ip
+	}
+	switch instr.Instruction(fn.Code[last:]).Opcode() {
+	case instr.BR:
+		// Code inserted before the BR is skipped by branches to the BR itself.
+		return last, !bounds[last]
+	case instr.BR_IF:
+		if instr.Targets(fn.Code, last)[0] == header.Start {
+			return 0, false
+		}
+	case instr.BR_TABLE, instr.RETURN, instr.RETURN_CALL, instr.THROW, instr.UNREACHABLE:
+		return 0, false
+	}
+	return header.Start, pre.End == header.Start
+}
+
+// invariants returns the maximal invariant expressions in loop's blocks, in
+// code order.
+func (p *LICMPass) invariants(fn *types.Function, blocks []*analysis.BasicBlock, loop *analysis.Loop) []span {
+	locals := map[uint64]bool{}
+	globals := map[uint64]bool{}
+	calls := false
+	for _, b := range loop.Blocks {
+		for ip := blocks[b].Start; ip < blocks[b].End; {
+			inst := instr.Instruction(fn.Code[ip:])
+			switch op := inst.Opcode(); {
+			case instr.WritesLocal(op):
+				locals[inst.Operand(0)] = true
+			case op == instr.GLOBAL_SET || op == instr.GLOBAL_TEE:
+				globals[inst.Operand(0)] = true
+			case instr.IsCall(op) || op == instr.YIELD || op == instr.RESUME:
+				calls = true
+			}
+			ip += inst.Width()
+		}
+	}
+
+	var spans []span
+	for _, b := range loop.Blocks {
+		var stack []invariant
+		push := func(ip, end int, kind instr.Kind, ok bool) {
+			stack = append(stack, invariant{span: span{start: ip, end: end, kind: kind}, ok: ok})
+		}
+	scan:
+		for ip := blocks[b].Start; ip < blocks[b].End; {
+			inst := instr.Instruction(fn.Code[ip:])
+			op := inst.Opcode()
+			end := ip + inst.Width()
+			t := inst.Type()
+
+			switch {
+			case op == instr.NOP:
+			case op == instr.I32_CONST || op == instr.I64_CONST || op == instr.F32_CONST || op == instr.F64_CONST || op == instr.REF_NULL:
+				push(ip, end, t.Push[0], true)
+			case op == instr.CONST_GET:
+				push(ip, end, instr.KindAny, true)
+			case op == instr.LOCAL_GET:
+				push(ip, end, instr.KindAny, !locals[inst.Operand(0)])
+			case op == instr.GLOBAL_GET:
+				push(ip, end, instr.KindAny, !calls && !globals[inst.Operand(0)])
+			case op == instr.LOCAL_TEE || op == instr.SWAP:
+				// Either way the top operands stop being contiguous expressions.
+				for k := max(len(stack)-2, 0); k < len(stack); k++ {
+					stack[k].ok = false
+				}
+			case op == instr.DUP:
+				push(ip, end, instr.KindAny, false)
+			case analysis.IsPure(op) && !analysis.MayTrap(op):
+				n := len(t.Pop)
+				if len(stack) < n {
+					break scan
+				}
+				args := stack[len(stack)-n:]
+				ok := true
+				for k, a := range args {
+					next := ip
+					if k+1 < n {
+						next = args[k+1].start
+					}
+					ok = ok && a.ok && a.end == next
+				}
+				start := args[0].start
+				stack = stack[:len(stack)-n]
+				push(start, end, t.Push[0], ok)
+				if ok {
+					spans = append(spans, span{start: start, end: end, kind: t.Push[0]})
+				}
+			default:
+				if op == instr.SELECT {
+					t = instr.Type{Pop: []instr.Kind{instr.KindI32, instr.KindAny, instr.KindAny}, Push: []instr.Kind{instr.KindAny}}
+				}
+				if (t.Pop == nil && t.Push == nil) || len(stack) < len(t.Pop) {
+					break scan
+				}
+				stack = stack[:len(stack)-len(t.Pop)]
+				for _, k := range t.Push {
+					push(ip, end, k, false)
+				}
+			}
+			ip = end
+		}
+	}
+
+	slices.SortFunc(spans, func(a, b span) int {
+		if a.start != b.start {
+			return a.start - b.start
+		}
+		return b.end - a.end
+	})
+	chosen := spans[:0]
+	for _, s := range spans {
+		if len(chosen) > 0 && s.start < chosen[len(chosen)-1].end {
+			continue
+		}
+		chosen = append(chosen, s)
+	}
+	return chosen
+}
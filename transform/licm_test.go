@@ -0,0 +1,217 @@
+package transform_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/siyul-park/minivm/analysis"
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/interp"
+	"github.com/siyul-park/minivm/pass"
+	"github.com/siyul-park/minivm/program"
+	transform "github.com/siyul-park/minivm/transform"
+	"github.com/siyul-park/minivm/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLICMPass(t *testing.T) {
+	require.NotNil(t, transform.NewLICMPass())
+}
+
+func TestLICMPass_Run(t *testing.T) {
+	binary := &types.FunctionType{Params: []types.Type{types.TypeI32, types.TypeI32}, Returns: []types.Type{types.TypeI32}}
+
+	// loop builds a function of (a, n) that adds the value body leaves to an
+	// accumulator n times, and a program calling it with a and n.
+	loop := func(a, n uint64, body ...instr.Instruction) *program.Program {
+		b := types.NewFunctionBuilder(binary).Locals(types.TypeI32)
+		head, done := b.Label(), b.Label()
+		fn := b.Emit(
+			instr.New(instr.I32_CONST, 0),
+			instr.New(instr.LOCAL_SET, 2),
+		).Bind(head).Emit(
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.I32_EQZ),
+		).BrIf(done).Emit(
+			instr.New(instr.LOCAL_GET, 2),
+		).Emit(body...).Emit(
+			instr.New(instr.I32_ADD),
+			instr.New(instr.LOCAL_SET, 2),
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_SUB),
+			instr.New(instr.LOCAL_SET, 1),
+		).Br(head).Bind(done).Emit(
+			instr.New(instr.LOCAL_GET, 2),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		return program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, a),
+			instr.New(instr.I32_CONST, n),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+		}, program.WithConstants(fn))
+	}
+
+	t.Run("hoists an invariant expression", func(t *testing.T) {
+		prog := loop(5, 4,
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_CONST, 3),
+			instr.New(instr.I32_MUL),
+		)
+
+		manager := pass.NewManager()
+		pass.Register(manager, analysis.NewBlocksAnalysis())
+		pass.Register(manager, analysis.NewDominatorsAnalysis())
+		pass.Register(manager, analysis.NewLoopsAnalysis())
+		preserved, err := transform.NewLICMPass().Run(manager, prog)
+		require.NoError(t, err)
+		require.Equal(t, pass.PreserveNone(), preserved)
+		require.NoError(t, program.Verify(prog))
+
+		fn := prog.Constants[0].(*types.Function)
+		require.Equal(t, instr.Format(instr.Marshal([]instr.Instruction{
+			instr.New(instr.I32_CONST, 0),
+			instr.New(instr.LOCAL_SET, 2),
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_CONST, 3),
+			instr.New(instr.I32_MUL),
+			instr.New(instr.LOCAL_SET, 3),
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.I32_EQZ),
+			instr.New(instr.BR_IF, 20),
+			instr.New(instr.LOCAL_GET, 2),
+			instr.New(instr.LOCAL_GET, 3),
+			instr.New(instr.I32_ADD),
+			instr.New(instr.LOCAL_SET, 2),
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_SUB),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.BR, uint64(uint16(0xFFE6))),
+			instr.New(instr.LOCAL_GET, 2),
+			instr.New(instr.RETURN),
+		})), instr.Format(fn.Code))
+		require.Equal(t, []types.Type{types.TypeI32, types.TypeI32}, fn.Locals)
+	})
+
+	t.Run("keeps a trapping division in the loop", func(t *testing.T) {
+		prog := loop(0, 2,
+			instr.New(instr.I32_CONST, 7),
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_DIV_S),
+		)
+		code := prog.Constants[0].(*types.Function).Code
+
+		manager := pass.NewManager()
+		pass.Register(manager, analysis.NewBlocksAnalysis())
+		pass.Register(manager, analysis.NewDominatorsAnalysis())
+		pass.Register(manager, analysis.NewLoopsAnalysis())
+		preserved, err := transform.NewLICMPass().Run(manager, prog)
+		require.NoError(t, err)
+		require.Equal(t, pass.PreserveAll(), preserved)
+		require.Equal(t, code, prog.Constants[0].(*types.Function).Code)
+	})
+
+	t.Run("keeps reads of a local the loop writes", func(t *testing.T) {
+		prog := loop(5, 4,
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.I32_CONST, 3),
+			instr.New(instr.I32_MUL),
+		)
+		code := prog.Constants[0].(*types.Function).Code
+
+		manager := pass.NewManager()
+		pass.Register(manager, analysis.NewBlocksAnalysis())
+		pass.Register(manager, analysis.NewDominatorsAnalysis())
+		pass.Register(manager, analysis.NewLoopsAnalysis())
+		preserved, err := transform.NewLICMPass().Run(manager, prog)
+		require.NoError(t, err)
+		require.Equal(t, pass.PreserveAll(), preserved)
+		require.Equal(t, code, prog.Constants[0].(*types.Function).Code)
+	})
+
+	t.Run("keeps reads of a global when the loop calls", func(t *testing.T) {
+		bump := types.NewFunctionBuilder(&types.FunctionType{}).Emit(
+			instr.New(instr.GLOBAL_GET, 0),
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_ADD),
+			instr.New(instr.GLOBAL_SET, 0),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		prog := loop(5, 4,
+			instr.New(instr.CONST_GET, 1),
+			instr.New(instr.CALL),
+			instr.New(instr.GLOBAL_GET, 0),
+			instr.New(instr.I32_CONST, 3),
+			instr.New(instr.I32_MUL),
+		)
+		prog.Constants = append(prog.Constants, bump)
+		prog.Globals = []types.Type{types.TypeI32}
+		prog.Code = instr.Marshal([]instr.Instruction{
+			instr.New(instr.I32_CONST, 0),
+			instr.New(instr.GLOBAL_SET, 0),
+			instr.New(instr.I32_CONST, 5),
+			instr.New(instr.I32_CONST, 4),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+		})
+		code := prog.Constants[0].(*types.Function).Code
+
+		manager := pass.NewManager()
+		pass.Register(manager, analysis.NewBlocksAnalysis())
+		pass.Register(manager, analysis.NewDominatorsAnalysis())
+		pass.Register(manager, analysis.NewLoopsAnalysis())
+		preserved, err := transform.NewLICMPass().Run(manager, prog)
+		require.NoError(t, err)
+		require.Equal(t, pass.PreserveAll(), preserved)
+		require.Equal(t, code, prog.Constants[0].(*types.Function).Code)
+	})
+
+	t.Run("skips the top level", func(t *testing.T) {
+		b := program.NewBuilder()
+		head, done := b.Label(), b.Label()
+		b.Emit(instr.I32_CONST, 3).Bind(head)
+		b.Emit(instr.I32_CONST, 2).Emit(instr.I32_CONST, 5).Emit(instr.I32_MUL).Emit(instr.I32_SUB)
+		b.Emit(instr.DUP).Emit(instr.I32_CONST, 0).Emit(instr.I32_LT_S).BrIf(done).Br(head).Bind(done)
+		prog, err := b.Build()
+		require.NoError(t, err)
+		code := prog.Code
+
+		manager := pass.NewManager()
+		pass.Register(manager, analysis.NewBlocksAnalysis())
+		pass.Register(manager, analysis.NewDominatorsAnalysis())
+		pass.Register(manager, analysis.NewLoopsAnalysis())
+		preserved, err := transform.NewLICMPass().Run(manager, prog)
+		require.NoError(t, err)
+		require.Equal(t, pass.PreserveAll(), preserved)
+		require.Equal(t, code, prog.Code)
+	})
+
+	t.Run("preserves execution", func(t *testing.T) {
+		prog := loop(5, 4,
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_CONST, 3),
+			instr.New(instr.I32_MUL),
+		)
+		before := interp.New(prog, interp.WithTick(1), interp.WithThreshold(-1))
+		defer before.Close()
+		require.NoError(t, before.Run(context.Background()))
+		want, err := before.Pop()
+		require.NoError(t, err)
+		require.Equal(t, types.I32(60), want)
+
+		manager := pass.NewManager()
+		pass.Register(manager, analysis.NewBlocksAnalysis())
+		pass.Register(manager, analysis.NewDominatorsAnalysis())
+		pass.Register(manager, analysis.NewLoopsAnalysis())
+		_, err = transform.NewLICMPass().Run(manager, prog)
+		require.NoError(t, err)
+		after := interp.New(prog, interp.WithTick(1), interp.WithThreshold(-1))
+		defer after.Close()
+		require.NoError(t, after.Run(context.Background()))
+		got, err := after.Pop()
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	})
+}
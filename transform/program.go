@@ -9,9 +9,14 @@ import (
 // prog.Code (carrying the program's top-level exception table) followed by every
 // *types.Function constant. The root lets length-changing passes repair the
 // top-level handlers' offsets through the rewriter; the caller writes the
-// repaired code and handlers back to prog for the i == 0 entry.
+// repaired code, handlers, and debug info back to prog for the i == 0 entry
+// with unroot.
 func functions(prog *program.Program) []*types.Function {
-	fns := []*types.Function{{Typ: &types.FunctionType{}, Locals: prog.Locals, Code: prog.Code, Handlers: prog.Handlers}}
+	root := &types.Function{Typ: &types.FunctionType{}, Locals: prog.Locals, Code: prog.Code, Handlers: prog.Handlers}
+	if prog.Debug != nil {
+		root.Debug = prog.Debug.Code
+	}
+	fns := []*types.Function{root}
 	for _, v := range prog.Constants {
 		if fn, ok := v.(*types.Function); ok {
 			fns = append(fns, fn)
@@ -19,3 +24,16 @@ func functions(prog *program.Program) []*types.Function {
 	}
 	return fns
 }
+
+// unroot writes the implicit root function's rewritten body back to prog.
+// Only code, handlers, and debug info are written back, never locals, so a
+// pass may read the root's declared locals but must not allocate new ones.
+func unroot(prog *program.Program, root *types.Function) {
+	prog.Code = root.Code
+	prog.Handlers = root.Handlers
+	if prog.Debug != nil {
+		debug := *prog.Debug
+		debug.Code = root.Debug
+		prog.Debug = &debug
+	}
+}
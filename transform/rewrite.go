@@ -12,11 +12,14 @@ import (
 // branch and handler offsets that the shift invalidates. Unlike the offset-
 // preserving peephole passes, it lets bytecode grow or shrink, then rewrites
 // every BR/BR_IF/BR_TABLE operand and exception-table boundary for the new
-// layout. Edits must be instruction-aligned and must not cover a branch.
+// layout. Edits must be instruction-aligned and must not cover a branch. A
+// successful run also moves the function's debug lines onto the new layout.
 type rewriter struct {
 	code     []byte
 	handlers []instr.Handler
+	debug    *types.Debug
 	edits    []edit
+	remap    []int
 }
 
 // edit replaces code[start:end) with bytes; an insertion is start == end.
@@ -27,12 +30,19 @@ type edit struct {
 }
 
 func newRewriter(fn *types.Function) *rewriter {
-	return &rewriter{code: fn.Code, handlers: fn.Handlers}
+	return &rewriter{code: fn.Code, handlers: fn.Handlers, debug: fn.Debug}
 }
 
 // replace schedules code[start:end) to be overwritten by instrs.
 func (r *rewriter) replace(start, end int, instrs ...instr.Instruction) {
-	r.edits = append(r.edits, edit{start: start, end: end, bytes: instr.Marshal(instrs)})
+	r.splice(start, end, instr.Marshal(instrs))
+}
+
+// splice schedules code[start:end) to be overwritten by already encoded bytes.
+// Branches inside bytes are left as they are, so they must be relative to bytes
+// itself.
+func (r *rewriter) splice(start, end int, bytes []byte) {
+	r.edits = append(r.edits, edit{start: start, end: end, bytes: bytes})
 }
 
 // insert schedules instrs to be spliced in at offset at.
@@ -42,7 +52,8 @@ func (r *rewriter) insert(at int, instrs ...instr.Instruction) {
 
 // run materializes the edited code and repaired handlers. It returns ok=false,
 // leaving the function untouched, when a branch can no longer reach its target
-// within the signed 16-bit operand range.
+// within the signed 16-bit operand range. After a successful run, remap maps
+// each old offset to its new one.
 func (r *rewriter) run() ([]byte, []instr.Handler, bool) {
 	if len(r.edits) == 0 {
 		return r.code, r.handlers, true
@@ -52,6 +63,8 @@ func (r *rewriter) run() ([]byte, []instr.Handler, bool) {
 	if !r.relink(code, remap) {
 		return nil, nil, false
 	}
+	r.remap = remap
+	r.debug = r.debug.Remap(func(ip int) int { return remap[min(max(ip, 0), len(r.code))] }, len(code))
 	return code, r.rehandle(remap), true
 }
 
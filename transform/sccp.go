@@ -0,0 +1,147 @@
+package transform
+
+import (
+	"github.com/siyul-park/minivm/analysis"
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/pass"
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
+)
+
+// SCCPPass applies sparse conditional constant propagation. Every LOCAL_GET
+// analysis.SCCP proves to read one constant is replaced by that constant, and
+// every BR_IF or BR_TABLE whose condition it decides is folded in place: into
+// a BR when the branch is always taken, or away when a BR_IF always falls
+// through. When the code computing the condition runs right before the branch
+// and has no side effects, it goes too; otherwise a DROP discards the
+// condition. The arms no longer entered lose their predecessors and are left
+// for DCEPass to remove.
+//
+// A BR_IF that is always taken but whose condition must stay has no room for
+// a DROP before its BR and is kept as it is.
+type SCCPPass struct{}
+
+var _ pass.Pass[*program.Program] = (*SCCPPass)(nil)
+
+func NewSCCPPass() *SCCPPass {
+	return &SCCPPass{}
+}
+
+func (p *SCCPPass) Run(m *pass.Manager, prog *program.Program) (pass.Preserved, error) {
+	// As in FoldPass, an i1 has no immediate, so a proven boolean is interned
+	// into the constant pool and read with CONST_GET.
+	trueIdx, falseIdx := -1, -1
+	boolConst := func(b bool) uint64 {
+		slot := &falseIdx
+		if b {
+			slot = &trueIdx
+		}
+		if *slot < 0 {
+			*slot = len(prog.Constants)
+			prog.Constants = append(prog.Constants, types.I1(b))
+		}
+		return uint64(*slot)
+	}
+
+	changed := false
+	for i, fn := range functions(prog) {
+		sccp, err := pass.GetResult[*analysis.SCCP](m, fn)
+		if err != nil {
+			return pass.PreserveNone(), err
+		}
+		if !p.propagate(fn, sccp, boolConst) {
+			continue
+		}
+		changed = true
+		if i == 0 {
+			unroot(prog, fn)
+		}
+	}
+	if !changed {
+		return pass.PreserveAll(), nil
+	}
+	return pass.PreserveNone(), nil
+}
+
+// propagate rewrites fn with sccp's facts and reports whether it changed.
+func (p *SCCPPass) propagate(fn *types.Function, sccp *analysis.SCCP, boolConst func(bool) uint64) bool {
+	changed := false
+
+	// Branches keep their width, so folding them first leaves every remaining
+	// branch where the rewriter below expects it.
+	for ip, d := range sccp.Branches {
+		if p.decide(fn.Code, ip, d) {
+			changed = true
+		}
+	}
+
+	var slots []types.Type
+	if fn.Typ != nil {
+		slots = append(slots, fn.Typ.Params...)
+	}
+	slots = append(slots, fn.Locals...)
+
+	r := newRewriter(fn)
+	for ip, c := range sccp.Values {
+		// A load folded away with a branch condition is now a NOP.
+		if instr.Opcode(fn.Code[ip]) != instr.LOCAL_GET {
+			continue
+		}
+		slot := int(instr.Instruction(fn.Code[ip:]).Operand(0))
+		if slot >= len(slots) || slots[slot] == nil || c.Kind.Repr() != slots[slot].Kind().Repr() {
+			continue
+		}
+		var inst instr.Instruction
+		switch slots[slot].Kind() {
+		case instr.KindI1:
+			inst = instr.New(instr.CONST_GET, boolConst(c.Bits != 0))
+		case instr.KindI32:
+			inst = instr.New(instr.I32_CONST, c.Bits)
+		case instr.KindI64:
+			inst = instr.New(instr.I64_CONST, c.Bits)
+		case instr.KindF32:
+			inst = instr.New(instr.F32_CONST, c.Bits)
+		case instr.KindF64:
+			inst = instr.New(instr.F64_CONST, c.Bits)
+		default:
+			continue
+		}
+		r.replace(ip, ip+2, inst)
+	}
+
+	code, handlers, ok := r.run()
+	if !ok || len(r.edits) == 0 {
+		return changed
+	}
+	fn.Code = code
+	fn.Handlers = handlers
+	fn.Debug = r.debug
+	return true
+}
+
+// decide folds the branch at ip, whose condition is decided by d, without
+// moving any other instruction. It reports whether the branch changed.
+func (p *SCCPPass) decide(code []byte, ip int, d analysis.Decision) bool {
+	inst := instr.Instruction(code[ip:])
+	end := ip + inst.Width()
+	from := ip
+	switch {
+	case d.Cond >= 0:
+		from = d.Cond
+	case inst.Opcode() == instr.BR_IF && d.Target != end:
+		return false
+	default:
+		code[ip] = byte(instr.DROP)
+		from = ip + 1
+	}
+
+	at := end
+	if d.Target != end {
+		at = end - instr.New(instr.BR, 0).Width()
+		copy(code[at:end], instr.New(instr.BR, uint64(d.Target-end)))
+	}
+	for k := from; k < at; k++ {
+		code[k] = byte(instr.NOP)
+	}
+	return true
+}
@@ -0,0 +1,260 @@
+package transform_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/siyul-park/minivm/analysis"
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/interp"
+	"github.com/siyul-park/minivm/pass"
+	"github.com/siyul-park/minivm/program"
+	transform "github.com/siyul-park/minivm/transform"
+	"github.com/siyul-park/minivm/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSCCPPass(t *testing.T) {
+	require.NotNil(t, transform.NewSCCPPass())
+}
+
+func TestSCCPPass_Run(t *testing.T) {
+	unary := &types.FunctionType{Params: []types.Type{types.TypeI32}, Returns: []types.Type{types.TypeI32}}
+
+	// call builds a program whose top-level code calls fn with arg.
+	call := func(fn *types.Function, arg uint64) *program.Program {
+		return program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, arg),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+		}, program.WithConstants(fn))
+	}
+
+	// diamond stores 10 or 20 into local 1 depending on cond and returns it.
+	diamond := func(cond ...instr.Instruction) *types.Function {
+		b := types.NewFunctionBuilder(unary).Locals(types.TypeI32)
+		other, merge := b.Label(), b.Label()
+		return b.Emit(cond...).BrIf(other).Emit(
+			instr.New(instr.I32_CONST, 10),
+			instr.New(instr.LOCAL_SET, 1),
+		).Br(merge).Bind(other).Emit(
+			instr.New(instr.I32_CONST, 20),
+			instr.New(instr.LOCAL_SET, 1),
+		).Bind(merge).Emit(
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.RETURN),
+		).MustBuild()
+	}
+
+	t.Run("replaces a constant load", func(t *testing.T) {
+		b := types.NewFunctionBuilder(unary).Locals(types.TypeI32)
+		next := b.Label()
+		fn := b.Emit(
+			instr.New(instr.I32_CONST, 5),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.LOCAL_GET, 0),
+		).BrIf(next).Bind(next).Emit(
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		prog := call(fn, 1)
+
+		manager := pass.NewManager()
+		pass.Register(manager, analysis.NewBlocksAnalysis())
+		pass.Register(manager, analysis.NewSCCPAnalysis())
+		preserved, err := transform.NewSCCPPass().Run(manager, prog)
+		require.NoError(t, err)
+		require.Equal(t, pass.PreserveNone(), preserved)
+		require.NoError(t, program.Verify(prog))
+		require.Equal(t, instr.Format(instr.Marshal([]instr.Instruction{
+			instr.New(instr.I32_CONST, 5),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.BR_IF, 0),
+			instr.New(instr.I32_CONST, 5),
+			instr.New(instr.RETURN),
+		})), instr.Format(fn.Code))
+	})
+
+	t.Run("folds a decided branch for DCE", func(t *testing.T) {
+		fn := diamond(
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.I32_EQZ),
+		)
+		prog := call(fn, 0)
+
+		manager := pass.NewManager()
+		pass.Register(manager, analysis.NewBlocksAnalysis())
+		pass.Register(manager, analysis.NewSCCPAnalysis())
+		_, err := transform.NewSCCPPass().Run(manager, prog)
+		require.NoError(t, err)
+		manager.Invalidate(pass.PreserveNone())
+		_, err = transform.NewDCEPass().Run(manager, prog)
+		require.NoError(t, err)
+		require.NoError(t, program.Verify(prog))
+		require.Equal(t, instr.Format(instr.Marshal([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.I32_CONST, 10),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.BR, 0),
+			instr.New(instr.I32_CONST, 10),
+			instr.New(instr.RETURN),
+		})), instr.Format(fn.Code))
+	})
+
+	t.Run("drops the handler of a try in a dead arm", func(t *testing.T) {
+		b := types.NewFunctionBuilder(unary).Locals(types.TypeI32)
+		other, merge := b.Label(), b.Label()
+		start, end, catch := b.Label(), b.Label(), b.Label()
+		fn := b.Emit(
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.I32_EQZ),
+		).BrIf(other).Emit(
+			instr.New(instr.I32_CONST, 10),
+			instr.New(instr.LOCAL_SET, 1),
+		).Br(merge).Bind(other).Bind(start).Emit(
+			instr.New(instr.I32_CONST, 20),
+			instr.New(instr.LOCAL_SET, 1),
+		).Bind(end).Br(merge).Bind(catch).Emit(
+			instr.New(instr.DROP),
+			instr.New(instr.I32_CONST, 30),
+			instr.New(instr.LOCAL_SET, 1),
+		).Bind(merge).Emit(
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.RETURN),
+		).Try(start, end, catch, 0).MustBuild()
+		prog := call(fn, 0)
+		require.NoError(t, program.Verify(prog))
+
+		manager := pass.NewManager()
+		pass.Register(manager, analysis.NewBlocksAnalysis())
+		pass.Register(manager, analysis.NewSCCPAnalysis())
+		_, err := transform.NewSCCPPass().Run(manager, prog)
+		require.NoError(t, err)
+		manager.Invalidate(pass.PreserveNone())
+		_, err = transform.NewDCEPass().Run(manager, prog)
+		require.NoError(t, err)
+		require.NoError(t, program.Verify(prog))
+		require.Empty(t, fn.Handlers)
+		require.Equal(t, instr.Format(instr.Marshal([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.I32_CONST, 10),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.BR, 0),
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.RETURN),
+		})), instr.Format(fn.Code))
+	})
+
+	t.Run("drops a condition it cannot remove", func(t *testing.T) {
+		fn := diamond(
+			instr.New(instr.I32_CONST, 0),
+			instr.New(instr.LOCAL_TEE, 1),
+		)
+		prog := call(fn, 0)
+
+		manager := pass.NewManager()
+		pass.Register(manager, analysis.NewBlocksAnalysis())
+		pass.Register(manager, analysis.NewSCCPAnalysis())
+		_, err := transform.NewSCCPPass().Run(manager, prog)
+		require.NoError(t, err)
+		require.NoError(t, program.Verify(prog))
+		require.Equal(t, instr.Format(instr.Marshal([]instr.Instruction{
+			instr.New(instr.I32_CONST, 0),
+			instr.New(instr.LOCAL_TEE, 1),
+			instr.New(instr.DROP),
+			instr.New(instr.NOP),
+			instr.New(instr.NOP),
+			instr.New(instr.I32_CONST, 10),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.BR, 7),
+			instr.New(instr.I32_CONST, 20),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.I32_CONST, 10),
+			instr.New(instr.RETURN),
+		})), instr.Format(fn.Code))
+	})
+
+	t.Run("keeps a taken branch whose condition stays", func(t *testing.T) {
+		fn := diamond(
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.LOCAL_TEE, 1),
+		)
+		prog := call(fn, 0)
+
+		manager := pass.NewManager()
+		pass.Register(manager, analysis.NewBlocksAnalysis())
+		pass.Register(manager, analysis.NewSCCPAnalysis())
+		_, err := transform.NewSCCPPass().Run(manager, prog)
+		require.NoError(t, err)
+		require.NoError(t, program.Verify(prog))
+		require.Equal(t, instr.Format(instr.Marshal([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.LOCAL_TEE, 1),
+			instr.New(instr.BR_IF, 10),
+			instr.New(instr.I32_CONST, 10),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.BR, 7),
+			instr.New(instr.I32_CONST, 20),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.I32_CONST, 20),
+			instr.New(instr.RETURN),
+		})), instr.Format(fn.Code))
+	})
+
+	t.Run("interns a boolean at the top level", func(t *testing.T) {
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_CONST, 2),
+			instr.New(instr.I32_LT_S),
+			instr.New(instr.LOCAL_SET, 0),
+			instr.New(instr.LOCAL_GET, 0),
+		}, program.WithLocals(types.TypeI1))
+
+		manager := pass.NewManager()
+		pass.Register(manager, analysis.NewBlocksAnalysis())
+		pass.Register(manager, analysis.NewSCCPAnalysis())
+		_, err := transform.NewSCCPPass().Run(manager, prog)
+		require.NoError(t, err)
+		require.NoError(t, program.Verify(prog))
+		require.Equal(t, []types.Value{types.I1(true)}, prog.Constants)
+		require.Equal(t, instr.Format(instr.Marshal([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.I32_CONST, 2),
+			instr.New(instr.I32_LT_S),
+			instr.New(instr.LOCAL_SET, 0),
+			instr.New(instr.CONST_GET, 0),
+		})), instr.Format(prog.Code))
+	})
+
+	t.Run("preserves execution", func(t *testing.T) {
+		fn := diamond(
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.LOCAL_TEE, 1),
+		)
+		prog := call(fn, 0)
+		before := interp.New(prog, interp.WithTick(1), interp.WithThreshold(-1))
+		defer before.Close()
+		require.NoError(t, before.Run(context.Background()))
+		want, err := before.Pop()
+		require.NoError(t, err)
+
+		manager := pass.NewManager()
+		pass.Register(manager, analysis.NewBlocksAnalysis())
+		pass.Register(manager, analysis.NewSCCPAnalysis())
+		_, err = transform.NewSCCPPass().Run(manager, prog)
+		require.NoError(t, err)
+		after := interp.New(prog, interp.WithTick(1), interp.WithThreshold(-1))
+		defer after.Close()
+		require.NoError(t, after.Run(context.Background()))
+		got, err := after.Pop()
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	})
+}
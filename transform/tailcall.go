@@ -0,0 +1,139 @@
+package transform
+
+import (
+	"slices"
+
+	"github.com/siyul-park/minivm/analysis"
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/pass"
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
+)
+
+// TailCallPass cleans up the call sites and local traffic code generators
+// leave behind. It rewrites in place, padding with NOP for DCEPass to compact:
+//
+//	CONST_GET f; CALL; RETURN  ->  CONST_GET f; RETURN_CALL
+//	LOCAL_SET x; LOCAL_GET x   ->  LOCAL_TEE x
+//	LOCAL_SET x (never read)   ->  DROP
+//	LOCAL_TEE x (never read)   ->  (removed)
+//
+// The one exception is the RETURN after a new tail call, which is cut out of
+// the code unless a branch or handler boundary still reaches it. A call
+// becomes a tail call only when f is a function constant returning exactly
+// the caller's result types, and never inside a handler's protected range,
+// where the caller's frame must stay to catch what the callee throws. Dead
+// stores come from analysis.Liveness. The top-level body keeps its calls and
+// its stores, since its locals outlive Run.
+type TailCallPass struct{}
+
+var _ pass.Pass[*program.Program] = (*TailCallPass)(nil)
+
+func NewTailCallPass() *TailCallPass {
+	return &TailCallPass{}
+}
+
+func (p *TailCallPass) Run(m *pass.Manager, prog *program.Program) (pass.Preserved, error) {
+	changed := false
+	for i, fn := range functions(prog) {
+		live, err := pass.GetResult[*analysis.Liveness](m, fn)
+		if err != nil {
+			return pass.PreserveNone(), err
+		}
+		if p.clean(prog, fn, live, i == 0) {
+			changed = true
+		}
+	}
+	if !changed {
+		return pass.PreserveAll(), nil
+	}
+	return pass.PreserveNone(), nil
+}
+
+// clean rewrites fn in place and reports whether it changed anything.
+func (p *TailCallPass) clean(prog *program.Program, fn *types.Function, live *analysis.Liveness, root bool) bool {
+	code := fn.Code
+	bounds := bounds(fn)
+	nop := func(start, end int) {
+		for k := start; k < end; k++ {
+			code[k] = byte(instr.NOP)
+		}
+	}
+
+	changed := false
+	prev := -1
+	var dead []int
+	for ip := 0; ip < len(code); {
+		inst := instr.Instruction(code[ip:])
+		next := ip + inst.Width()
+
+		switch inst.Opcode() {
+		case instr.CALL:
+			if !root && prev >= 0 && !bounds[ip] && next < len(code) && instr.Opcode(code[next]) == instr.RETURN &&
+				p.tail(prog, fn, instr.Instruction(code[prev:]), ip) {
+				code[ip] = byte(instr.RETURN_CALL)
+				if !bounds[next] {
+					dead = append(dead, next)
+				}
+				changed = true
+			}
+		case instr.LOCAL_SET:
+			slot := inst.Operand(0)
+			if next < len(code) && !bounds[next] && instr.Opcode(code[next]) == instr.LOCAL_GET && instr.Instruction(code[next:]).Operand(0) == slot {
+				end := next + instr.Instruction(code[next:]).Width()
+				if root || !live.Dead[next] {
+					copy(code[ip:], instr.New(instr.LOCAL_TEE, slot))
+					nop(ip+2, end)
+				} else {
+					// The load was the stored value's only reader, so the value
+					// can stay on the stack.
+					nop(ip, end)
+				}
+				changed = true
+				prev, ip = -1, end
+				continue
+			}
+			if !root && live.Dead[ip] {
+				code[ip] = byte(instr.DROP)
+				nop(ip+1, next)
+				changed = true
+			}
+		case instr.LOCAL_TEE:
+			if !root && live.Dead[ip] {
+				nop(ip, next)
+				changed = true
+			}
+		}
+		prev, ip = ip, next
+	}
+
+	// A tail call never falls through to the RETURN after it.
+	if len(dead) > 0 {
+		r := newRewriter(fn)
+		for _, at := range dead {
+			r.replace(at, at+1)
+		}
+		if code, handlers, ok := r.run(); ok {
+			fn.Code = code
+			fn.Handlers = handlers
+			fn.Debug = r.debug
+		}
+	}
+	return changed
+}
+
+// tail reports whether the CALL at ip, whose callee is loaded by callee, may
+// replace fn's frame.
+func (p *TailCallPass) tail(prog *program.Program, fn *types.Function, callee instr.Instruction, ip int) bool {
+	if callee.Opcode() != instr.CONST_GET || int(callee.Operand(0)) >= len(prog.Constants) {
+		return false
+	}
+	target, ok := prog.Constants[callee.Operand(0)].(*types.Function)
+	if !ok || target.Typ == nil || fn.Typ == nil {
+		return false
+	}
+	if !slices.EqualFunc(target.Typ.Returns, fn.Typ.Returns, func(a, b types.Type) bool { return a.Equals(b) }) {
+		return false
+	}
+	return !slices.ContainsFunc(fn.Handlers, func(h instr.Handler) bool { return h.Start <= ip && ip < h.End })
+}
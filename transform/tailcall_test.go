@@ -0,0 +1,263 @@
+package transform_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/siyul-park/minivm/analysis"
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/interp"
+	"github.com/siyul-park/minivm/pass"
+	"github.com/siyul-park/minivm/program"
+	transform "github.com/siyul-park/minivm/transform"
+	"github.com/siyul-park/minivm/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTailCallPass(t *testing.T) {
+	require.NotNil(t, transform.NewTailCallPass())
+}
+
+func TestTailCallPass_Run(t *testing.T) {
+	unary := &types.FunctionType{Params: []types.Type{types.TypeI32}, Returns: []types.Type{types.TypeI32}}
+
+	inc := types.NewFunctionBuilder(unary).Emit(
+		instr.New(instr.LOCAL_GET, 0),
+		instr.New(instr.I32_CONST, 1),
+		instr.New(instr.I32_ADD),
+		instr.New(instr.RETURN),
+	).MustBuild()
+
+	// call builds a program whose top-level code calls fn, constant 1, with
+	// arg, and whose constant 0 is inc.
+	call := func(fn *types.Function, arg uint64) *program.Program {
+		return program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, arg),
+			instr.New(instr.CONST_GET, 1),
+			instr.New(instr.CALL),
+		}, program.WithConstants(inc, fn))
+	}
+
+	t.Run("rewrites a call before a return", func(t *testing.T) {
+		fn := types.NewFunctionBuilder(unary).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		prog := call(fn, 41)
+
+		manager := pass.NewManager()
+		pass.Register(manager, analysis.NewBlocksAnalysis())
+		pass.Register(manager, analysis.NewLivenessAnalysis())
+		preserved, err := transform.NewTailCallPass().Run(manager, prog)
+		require.NoError(t, err)
+		require.Equal(t, pass.PreserveNone(), preserved)
+		require.NoError(t, program.Verify(prog))
+		require.Equal(t, instr.Format(instr.Marshal([]instr.Instruction{
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.RETURN_CALL),
+		})), instr.Format(fn.Code))
+	})
+
+	t.Run("keeps a return a branch reaches", func(t *testing.T) {
+		build := func(call instr.Opcode) *types.Function {
+			b := types.NewFunctionBuilder(unary)
+			ret := b.Label()
+			return b.Emit(
+				instr.New(instr.LOCAL_GET, 0),
+				instr.New(instr.LOCAL_GET, 0),
+			).BrIf(ret).Emit(
+				instr.New(instr.CONST_GET, 0),
+				instr.New(call),
+			).Bind(ret).Emit(
+				instr.New(instr.RETURN),
+			).MustBuild()
+		}
+		fn := build(instr.CALL)
+		prog := call(fn, 41)
+		require.NoError(t, program.Verify(prog))
+
+		manager := pass.NewManager()
+		pass.Register(manager, analysis.NewBlocksAnalysis())
+		pass.Register(manager, analysis.NewLivenessAnalysis())
+		_, err := transform.NewTailCallPass().Run(manager, prog)
+		require.NoError(t, err)
+		require.NoError(t, program.Verify(prog))
+		require.Equal(t, instr.Format(build(instr.RETURN_CALL).Code), instr.Format(fn.Code))
+	})
+
+	t.Run("keeps a call inside a protected range", func(t *testing.T) {
+		b := types.NewFunctionBuilder(unary)
+		start, end, catch := b.Label(), b.Label(), b.Label()
+		fn := b.Bind(start).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+		).Bind(end).Emit(
+			instr.New(instr.RETURN),
+		).Bind(catch).Emit(
+			instr.New(instr.DROP),
+			instr.New(instr.I32_CONST, 0),
+			instr.New(instr.RETURN),
+		).Try(start, end, catch, 1).MustBuild()
+		prog := call(fn, 41)
+		code := fn.Code
+
+		manager := pass.NewManager()
+		pass.Register(manager, analysis.NewBlocksAnalysis())
+		pass.Register(manager, analysis.NewLivenessAnalysis())
+		preserved, err := transform.NewTailCallPass().Run(manager, prog)
+		require.NoError(t, err)
+		require.Equal(t, pass.PreserveAll(), preserved)
+		require.Equal(t, code, fn.Code)
+	})
+
+	t.Run("keeps a call whose results differ", func(t *testing.T) {
+		fn := types.NewFunctionBuilder(&types.FunctionType{Params: []types.Type{types.TypeI32}}).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		prog := call(fn, 41)
+
+		manager := pass.NewManager()
+		pass.Register(manager, analysis.NewBlocksAnalysis())
+		pass.Register(manager, analysis.NewLivenessAnalysis())
+		preserved, err := transform.NewTailCallPass().Run(manager, prog)
+		require.NoError(t, err)
+		require.Equal(t, pass.PreserveAll(), preserved)
+		require.NotContains(t, instr.Format(fn.Code), "return_call")
+	})
+
+	t.Run("forwards a store to the next load", func(t *testing.T) {
+		fn := types.NewFunctionBuilder(unary).Locals(types.TypeI32).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_CONST, 2),
+			instr.New(instr.I32_MUL),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.I32_ADD),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		prog := call(fn, 5)
+
+		manager := pass.NewManager()
+		pass.Register(manager, analysis.NewBlocksAnalysis())
+		pass.Register(manager, analysis.NewLivenessAnalysis())
+		_, err := transform.NewTailCallPass().Run(manager, prog)
+		require.NoError(t, err)
+		require.NoError(t, program.Verify(prog))
+		require.Equal(t, instr.Format(instr.Marshal([]instr.Instruction{
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.I32_CONST, 2),
+			instr.New(instr.I32_MUL),
+			instr.New(instr.LOCAL_TEE, 1),
+			instr.New(instr.NOP),
+			instr.New(instr.NOP),
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.I32_ADD),
+			instr.New(instr.RETURN),
+		})), instr.Format(fn.Code))
+	})
+
+	t.Run("removes a store its next load only reads", func(t *testing.T) {
+		fn := types.NewFunctionBuilder(unary).Locals(types.TypeI32).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		prog := call(fn, 5)
+
+		manager := pass.NewManager()
+		pass.Register(manager, analysis.NewBlocksAnalysis())
+		pass.Register(manager, analysis.NewLivenessAnalysis())
+		_, err := transform.NewTailCallPass().Run(manager, prog)
+		require.NoError(t, err)
+		require.NoError(t, program.Verify(prog))
+		require.Equal(t, instr.Format(instr.Marshal([]instr.Instruction{
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.NOP),
+			instr.New(instr.NOP),
+			instr.New(instr.NOP),
+			instr.New(instr.NOP),
+			instr.New(instr.RETURN),
+		})), instr.Format(fn.Code))
+	})
+
+	t.Run("drops dead stores", func(t *testing.T) {
+		fn := types.NewFunctionBuilder(unary).Locals(types.TypeI32).Emit(
+			instr.New(instr.I32_CONST, 9),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.LOCAL_TEE, 1),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		prog := call(fn, 5)
+
+		manager := pass.NewManager()
+		pass.Register(manager, analysis.NewBlocksAnalysis())
+		pass.Register(manager, analysis.NewLivenessAnalysis())
+		_, err := transform.NewTailCallPass().Run(manager, prog)
+		require.NoError(t, err)
+		require.NoError(t, program.Verify(prog))
+		require.Equal(t, instr.Format(instr.Marshal([]instr.Instruction{
+			instr.New(instr.I32_CONST, 9),
+			instr.New(instr.DROP),
+			instr.New(instr.NOP),
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.NOP),
+			instr.New(instr.NOP),
+			instr.New(instr.RETURN),
+		})), instr.Format(fn.Code))
+	})
+
+	t.Run("top level keeps its stores", func(t *testing.T) {
+		prog := program.New([]instr.Instruction{
+			instr.New(instr.I32_CONST, 1),
+			instr.New(instr.LOCAL_SET, 0),
+		}, program.WithLocals(types.TypeI32))
+		code := prog.Code
+
+		manager := pass.NewManager()
+		pass.Register(manager, analysis.NewBlocksAnalysis())
+		pass.Register(manager, analysis.NewLivenessAnalysis())
+		preserved, err := transform.NewTailCallPass().Run(manager, prog)
+		require.NoError(t, err)
+		require.Equal(t, pass.PreserveAll(), preserved)
+		require.Equal(t, code, prog.Code)
+	})
+
+	t.Run("preserves execution", func(t *testing.T) {
+		fn := types.NewFunctionBuilder(unary).Locals(types.TypeI32).Emit(
+			instr.New(instr.LOCAL_GET, 0),
+			instr.New(instr.LOCAL_SET, 1),
+			instr.New(instr.LOCAL_GET, 1),
+			instr.New(instr.CONST_GET, 0),
+			instr.New(instr.CALL),
+			instr.New(instr.RETURN),
+		).MustBuild()
+		prog := call(fn, 41)
+		before := interp.New(prog, interp.WithTick(1), interp.WithThreshold(-1))
+		defer before.Close()
+		require.NoError(t, before.Run(context.Background()))
+		want, err := before.Pop()
+		require.NoError(t, err)
+
+		manager := pass.NewManager()
+		pass.Register(manager, analysis.NewBlocksAnalysis())
+		pass.Register(manager, analysis.NewLivenessAnalysis())
+		_, err = transform.NewTailCallPass().Run(manager, prog)
+		require.NoError(t, err)
+		after := interp.New(prog, interp.WithTick(1), interp.WithThreshold(-1))
+		defer after.Close()
+		require.NoError(t, after.Run(context.Background()))
+		got, err := after.Pop()
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	})
+}
@@ -0,0 +1,98 @@
+package types
+
+import (
+	"sort"
+	"strconv"
+)
+
+// Debug is a function's optional source-level information. It never affects
+// execution; tools read it to name functions and slots and to map bytecode
+// offsets back to the source that produced them.
+type Debug struct {
+	Name   string
+	Locals []string
+	Lines  []Line
+}
+
+// Line starts a source range: every instruction from IP up to the next Line's
+// IP maps to Pos. Lines are sorted by strictly increasing IP. A Line whose Pos
+// is invalid ends the previous range without starting a new one.
+type Line struct {
+	IP  int
+	Pos Position
+}
+
+// Position is a location in a source file. Line and Column are 1-based; a zero
+// Column means the whole line.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+// Local returns the name of stack slot idx, numbered like Function.Declared:
+// params first, then declared locals. It returns "" for an unnamed slot.
+func (d *Debug) Local(idx int) string {
+	if d == nil || idx < 0 || idx >= len(d.Locals) {
+		return ""
+	}
+	return d.Locals[idx]
+}
+
+// Position returns the source position of the instruction at ip.
+func (d *Debug) Position(ip int) (Position, bool) {
+	if d == nil {
+		return Position{}, false
+	}
+	k := sort.Search(len(d.Lines), func(k int) bool { return d.Lines[k].IP > ip })
+	if k == 0 || !d.Lines[k-1].Pos.IsValid() {
+		return Position{}, false
+	}
+	return d.Lines[k-1].Pos, true
+}
+
+// Remap returns a copy of d with every Line moved through remap, which maps an
+// old instruction offset to its new one. Offsets removed by a rewrite map to
+// the instruction that now follows them; when several Lines land on one
+// offset the last wins, and a Line mapped to size or beyond is dropped.
+func (d *Debug) Remap(remap func(ip int) int, size int) *Debug {
+	if d == nil {
+		return nil
+	}
+	out := &Debug{Name: d.Name, Locals: d.Locals}
+	for _, l := range d.Lines {
+		ip := remap(l.IP)
+		if ip >= size {
+			continue
+		}
+		if n := len(out.Lines); n > 0 && out.Lines[n-1].IP == ip {
+			out.Lines[n-1] = Line{IP: ip, Pos: l.Pos}
+			continue
+		}
+		out.Lines = append(out.Lines, Line{IP: ip, Pos: l.Pos})
+	}
+	return out
+}
+
+// IsValid reports whether p names a source line.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	s := p.File
+	if !p.IsValid() {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	if s != "" {
+		s += ":"
+	}
+	s += strconv.Itoa(p.Line)
+	if p.Column > 0 {
+		s += ":" + strconv.Itoa(p.Column)
+	}
+	return s
+}
@@ -0,0 +1,92 @@
+package types_test
+
+import (
+	"testing"
+
+	types "github.com/siyul-park/minivm/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebug_Local(t *testing.T) {
+	d := &types.Debug{Locals: []string{"x", "", "y"}}
+	require.Equal(t, "x", d.Local(0))
+	require.Equal(t, "", d.Local(1))
+	require.Equal(t, "y", d.Local(2))
+	require.Equal(t, "", d.Local(3))
+	require.Equal(t, "", d.Local(-1))
+
+	var nilDebug *types.Debug
+	require.Equal(t, "", nilDebug.Local(0))
+}
+
+func TestDebug_Position(t *testing.T) {
+	a := types.Position{File: "main.mvm", Line: 1, Column: 1}
+	b := types.Position{File: "main.mvm", Line: 2, Column: 5}
+	d := &types.Debug{Lines: []types.Line{{IP: 2, Pos: a}, {IP: 5, Pos: b}, {IP: 9}}}
+
+	tests := []struct {
+		ip   int
+		want types.Position
+		ok   bool
+	}{
+		{ip: 0},
+		{ip: 2, want: a, ok: true},
+		{ip: 4, want: a, ok: true},
+		{ip: 5, want: b, ok: true},
+		{ip: 8, want: b, ok: true},
+		{ip: 9},
+		{ip: 20},
+	}
+	for _, tt := range tests {
+		pos, ok := d.Position(tt.ip)
+		require.Equal(t, tt.ok, ok, "ip=%d", tt.ip)
+		require.Equal(t, tt.want, pos, "ip=%d", tt.ip)
+	}
+
+	var nilDebug *types.Debug
+	_, ok := nilDebug.Position(0)
+	require.False(t, ok)
+}
+
+func TestDebug_Remap(t *testing.T) {
+	a := types.Position{Line: 1}
+	b := types.Position{Line: 2}
+	c := types.Position{Line: 3}
+	d := &types.Debug{Name: "f", Lines: []types.Line{{IP: 0, Pos: a}, {IP: 1, Pos: b}, {IP: 4, Pos: c}}}
+
+	// Offset 0 was removed, so its Line collapses onto the instruction that
+	// replaced it and the later Line for that offset wins.
+	remap := []int{0, 0, 1, 2, 3}
+	got := d.Remap(func(ip int) int { return remap[ip] }, 4)
+	require.Equal(t, "f", got.Name)
+	require.Equal(t, []types.Line{{IP: 0, Pos: b}, {IP: 3, Pos: c}}, got.Lines)
+	require.Len(t, d.Lines, 3)
+
+	got = d.Remap(func(ip int) int { return remap[ip] }, 3)
+	require.Equal(t, []types.Line{{IP: 0, Pos: b}}, got.Lines)
+
+	var nilDebug *types.Debug
+	require.Nil(t, nilDebug.Remap(func(ip int) int { return ip }, 0))
+}
+
+func TestPosition_IsValid(t *testing.T) {
+	require.False(t, types.Position{}.IsValid())
+	require.False(t, types.Position{File: "a.mvm", Column: 2}.IsValid())
+	require.True(t, types.Position{Line: 1}.IsValid())
+}
+
+func TestPosition_String(t *testing.T) {
+	tests := []struct {
+		pos  types.Position
+		want string
+	}{
+		{pos: types.Position{}, want: "-"},
+		{pos: types.Position{File: "a.mvm"}, want: "a.mvm"},
+		{pos: types.Position{Line: 3}, want: "3"},
+		{pos: types.Position{File: "a.mvm", Line: 3}, want: "a.mvm:3"},
+		{pos: types.Position{File: "a.mvm", Line: 3, Column: 7}, want: "a.mvm:3:7"},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, tt.pos.String())
+	}
+}
@@ -21,6 +21,7 @@ type Function struct {
 	Captures []Type
 	Code     []byte
 	Handlers []instr.Handler
+	Debug    *Debug
 }
 
 type FunctionType struct {
@@ -159,7 +160,7 @@ func (f *Function) String() string {
 	if len(f.Locals) > 0 {
 		sb.WriteString("\n")
 	}
-	sb.WriteString(instr.Format(f.Code))
+	sb.WriteString(instr.FormatLabels(f.Code))
 	return sb.String()
 }
 
@@ -13,6 +13,12 @@ import (
 //	lines 1..k-1: local type strings (one per line)
 //	lines k..:    disassembly lines ("0000:\t…")
 func ParseFunction(lines []string) (*Function, error) {
+	return ParseFunctionSymbols(lines, nil)
+}
+
+// ParseFunctionSymbols is like ParseFunction but resolves "$name" operands in
+// the body through symbols, as instr.ParseSymbols does.
+func ParseFunctionSymbols(lines []string, symbols map[string]int) (*Function, error) {
 	if len(lines) == 0 {
 		return nil, fmt.Errorf("empty function definition")
 	}
@@ -62,7 +68,7 @@ func ParseFunction(lines []string) (*Function, error) {
 		locals = append(locals, t)
 	}
 
-	codeInstrs, err := instr.ParseAll(strings.NewReader(strings.Join(lines[localsEnd:], "\n")))
+	codeInstrs, err := instr.ParseSymbols(strings.NewReader(strings.Join(lines[localsEnd:], "\n")), symbols)
 	if err != nil {
 		return nil, fmt.Errorf("function code: %w", err)
 	}
@@ -85,6 +85,14 @@ func TestParseFunction(t *testing.T) {
 				MustBuild().String(), "\n",
 			),
 		},
+		{
+			// with branches
+			lines: strings.Split(func() *types.Function {
+				b := types.NewFunctionBuilder(&types.FunctionType{Params: []types.Type{types.TypeI32}})
+				loop := b.Label()
+				return b.Bind(loop).Emit(instr.New(instr.LOCAL_GET, 0)).BrIf(loop).Emit(instr.New(instr.RETURN)).MustBuild()
+			}().String(), "\n"),
+		},
 	}
 
 	for _, tt := range tests {
@@ -151,4 +159,36 @@ func TestParseFunction(t *testing.T) {
 		require.Equal(t, []types.Type{types.TypeI64}, fn.Locals)
 		require.Equal(t, 2, len(instr.Unmarshal(fn.Code)))
 	})
+
+	t.Run("labels", func(t *testing.T) {
+		lines := []string{
+			"func(i32)",
+			"loop:",
+			"local.get 0",
+			"br_if loop",
+			"return",
+		}
+		fn, err := types.ParseFunction(lines)
+		require.NoError(t, err)
+		require.Nil(t, fn.Locals)
+		require.Equal(t, instr.New(instr.BR_IF, uint64(uint16(0xFFFB))), instr.Unmarshal(fn.Code)[1])
+	})
+}
+
+func TestParseFunctionSymbols(t *testing.T) {
+	t.Run("resolves names", func(t *testing.T) {
+		lines := []string{
+			"func() i32",
+			"call $answer",
+			"return",
+		}
+		fn, err := types.ParseFunctionSymbols(lines, map[string]int{"answer": 2})
+		require.NoError(t, err)
+		require.Equal(t, []instr.Instruction{instr.New(instr.CONST_GET, 2), instr.New(instr.CALL), instr.New(instr.RETURN)}, instr.Unmarshal(fn.Code))
+	})
+
+	t.Run("rejects unknown names", func(t *testing.T) {
+		_, err := types.ParseFunctionSymbols([]string{"func()", "call $missing"}, nil)
+		require.Error(t, err)
+	})
 }
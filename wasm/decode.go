@@ -0,0 +1,551 @@
+package wasm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/siyul-park/minivm/types"
+)
+
+// module is the decoded form of a WebAssembly binary, limited to the parts of
+// the MVP that translate: imported functions, one table of functions, one
+// memory, numeric globals with constant initializers, and active segments.
+type module struct {
+	types   []*types.FunctionType
+	imports []funcImport
+	funcs   []uint32
+	table   *limits
+	memory  *limits
+	globals []global
+	exports []export
+	start   int
+	elems   []segment
+	codes   []body
+	datas   []segment
+}
+
+type funcImport struct {
+	module string
+	name   string
+	typ    uint32
+}
+
+// limits is a table or memory size range; max is -1 when unbounded.
+type limits struct {
+	min int64
+	max int64
+}
+
+type global struct {
+	typ     types.Type
+	mutable bool
+	init    constExpr
+}
+
+// constExpr is a constant initializer: one numeric const instruction.
+type constExpr struct {
+	op    byte
+	value uint64
+}
+
+type export struct {
+	name  string
+	kind  byte
+	index uint32
+}
+
+// segment is an active element or data segment placed at a constant offset
+// in table or memory 0. funcs holds element function indices and data the
+// data bytes.
+type segment struct {
+	offset int64
+	funcs  []uint32
+	data   []byte
+}
+
+type body struct {
+	locals []types.Type
+	code   []byte
+	off    int
+}
+
+// decoder reads the WebAssembly binary format from data. Like the program
+// decoder, the first failure is latched in err and later reads return zero
+// values.
+type decoder struct {
+	data []byte
+	off  int
+	base int
+	err  error
+}
+
+const (
+	sectionCustom byte = iota
+	sectionType
+	sectionImport
+	sectionFunction
+	sectionTable
+	sectionMemory
+	sectionGlobal
+	sectionExport
+	sectionStart
+	sectionElement
+	sectionCode
+	sectionData
+	sectionDataCount
+)
+
+const (
+	externFunc byte = iota
+	externTable
+	externMemory
+	externGlobal
+)
+
+const (
+	valI32     byte = 0x7F
+	valI64     byte = 0x7E
+	valF32     byte = 0x7D
+	valF64     byte = 0x7C
+	valFuncref byte = 0x70
+	blockEmpty byte = 0x40
+	formFunc   byte = 0x60
+)
+
+// maxPages bounds a memory so its byte length stays a valid i32 array index.
+const maxPages = math.MaxInt32 / pageSize
+
+const pageSize = 65536
+
+func decode(data []byte) (*module, error) {
+	if len(data) < 8 || string(data[:4]) != Magic {
+		return nil, ErrInvalidMagic
+	}
+	if v := binary.LittleEndian.Uint32(data[4:8]); v != Version {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, v)
+	}
+
+	m := &module{start: -1}
+	d := &decoder{data: data, off: 8}
+	var last byte
+	for d.off < len(d.data) {
+		id := d.byte()
+		size := d.u32()
+		if d.err != nil {
+			return nil, d.err
+		}
+		if int(size) > len(d.data)-d.off {
+			return nil, d.malformed("section %d overruns the module", id)
+		}
+		s := &decoder{data: d.data[d.off : d.off+int(size)], base: d.off}
+		d.off += int(size)
+		if id != sectionCustom {
+			if id <= last || id > sectionDataCount {
+				return nil, s.malformed("section %d out of order", id)
+			}
+			last = id
+		}
+
+		switch id {
+		case sectionCustom:
+			s.off = len(s.data)
+		case sectionType:
+			s.typeSection(m)
+		case sectionImport:
+			s.importSection(m)
+		case sectionFunction:
+			for n := s.u32(); n > 0 && s.err == nil; n-- {
+				m.funcs = append(m.funcs, s.u32())
+			}
+		case sectionTable:
+			s.tableSection(m)
+		case sectionMemory:
+			s.memorySection(m)
+		case sectionGlobal:
+			s.globalSection(m)
+		case sectionExport:
+			for n := s.u32(); n > 0 && s.err == nil; n-- {
+				m.exports = append(m.exports, export{name: s.name(), kind: s.byte(), index: s.u32()})
+			}
+		case sectionStart:
+			m.start = int(s.u32())
+		case sectionElement:
+			s.elementSection(m)
+		case sectionCode:
+			s.codeSection(m)
+		case sectionData:
+			s.dataSection(m)
+		case sectionDataCount:
+			s.u32()
+		}
+		if s.err != nil {
+			return nil, s.err
+		}
+		if s.off != len(s.data) {
+			return nil, s.malformed("section %d has %d trailing bytes", id, len(s.data)-s.off)
+		}
+	}
+	if len(m.codes) != len(m.funcs) {
+		return nil, d.malformed("%d function bodies for %d functions", len(m.codes), len(m.funcs))
+	}
+	return m, nil
+}
+
+func (d *decoder) typeSection(m *module) {
+	for n := d.u32(); n > 0 && d.err == nil; n-- {
+		if form := d.byte(); form != formFunc && d.err == nil {
+			d.fail(ErrMalformed, "type form 0x%02x", form)
+			return
+		}
+		typ := &types.FunctionType{Params: d.valtypes(), Returns: d.valtypes()}
+		if len(typ.Returns) > 1 && d.err == nil {
+			d.fail(ErrUnsupported, "multiple results")
+		}
+		m.types = append(m.types, typ)
+	}
+}
+
+func (d *decoder) importSection(m *module) {
+	for n := d.u32(); n > 0 && d.err == nil; n-- {
+		imp := funcImport{module: d.name(), name: d.name()}
+		kind := d.byte()
+		if d.err != nil {
+			return
+		}
+		if kind != externFunc {
+			d.fail(ErrUnsupported, "import %s.%s of kind %d", imp.module, imp.name, kind)
+			return
+		}
+		imp.typ = d.u32()
+		m.imports = append(m.imports, imp)
+	}
+}
+
+func (d *decoder) tableSection(m *module) {
+	for n := d.u32(); n > 0 && d.err == nil; n-- {
+		if elem := d.byte(); elem != valFuncref && d.err == nil {
+			d.fail(ErrUnsupported, "table element type 0x%02x", elem)
+			return
+		}
+		l := d.limits()
+		if m.table != nil && d.err == nil {
+			d.fail(ErrUnsupported, "multiple tables")
+			return
+		}
+		m.table = &l
+	}
+}
+
+func (d *decoder) memorySection(m *module) {
+	for n := d.u32(); n > 0 && d.err == nil; n-- {
+		l := d.limits()
+		if d.err != nil {
+			return
+		}
+		if m.memory != nil {
+			d.fail(ErrUnsupported, "multiple memories")
+			return
+		}
+		if l.min > maxPages {
+			d.fail(ErrUnsupported, "memory of %d pages", l.min)
+			return
+		}
+		m.memory = &l
+	}
+}
+
+func (d *decoder) globalSection(m *module) {
+	for n := d.u32(); n > 0 && d.err == nil; n-- {
+		g := global{typ: d.valtype()}
+		switch mut := d.byte(); mut {
+		case 0:
+		case 1:
+			g.mutable = true
+		default:
+			d.fail(ErrMalformed, "global mutability %d", mut)
+		}
+		g.init = d.constExpr()
+		if d.err == nil && constTypes[g.init.op] != g.typ {
+			d.fail(ErrMalformed, "global initializer of type %v for %v", constTypes[g.init.op], g.typ)
+		}
+		m.globals = append(m.globals, g)
+	}
+}
+
+func (d *decoder) elementSection(m *module) {
+	for n := d.u32(); n > 0 && d.err == nil; n-- {
+		if flags := d.u32(); flags != 0 && d.err == nil {
+			d.fail(ErrUnsupported, "element segment flags %d", flags)
+			return
+		}
+		s := segment{offset: d.offset()}
+		for k := d.u32(); k > 0 && d.err == nil; k-- {
+			s.funcs = append(s.funcs, d.u32())
+		}
+		m.elems = append(m.elems, s)
+	}
+}
+
+func (d *decoder) codeSection(m *module) {
+	for n := d.u32(); n > 0 && d.err == nil; n-- {
+		size := int(d.u32())
+		if d.err != nil {
+			return
+		}
+		if size > len(d.data)-d.off {
+			d.fail(ErrMalformed, "function body overruns the section")
+			return
+		}
+		f := &decoder{data: d.data[d.off : d.off+size], base: d.base + d.off}
+		d.off += size
+
+		var b body
+		var total uint64
+		for k := f.u32(); k > 0 && f.err == nil; k-- {
+			count := f.u32()
+			typ := f.valtype()
+			if total += uint64(count); total > math.MaxUint16 {
+				f.fail(ErrUnsupported, "more than %d locals", math.MaxUint16)
+				break
+			}
+			for range count {
+				b.locals = append(b.locals, typ)
+			}
+		}
+		if f.err != nil {
+			d.err = f.err
+			return
+		}
+		b.code, b.off = f.data[f.off:], f.base+f.off
+		m.codes = append(m.codes, b)
+	}
+}
+
+func (d *decoder) dataSection(m *module) {
+	for n := d.u32(); n > 0 && d.err == nil; n-- {
+		if flags := d.u32(); flags != 0 && d.err == nil {
+			d.fail(ErrUnsupported, "data segment flags %d", flags)
+			return
+		}
+		s := segment{offset: d.offset()}
+		size := int(d.u32())
+		if d.err == nil && size > len(d.data)-d.off {
+			d.fail(ErrMalformed, "data segment overruns the section")
+			return
+		}
+		if d.err == nil {
+			s.data = d.data[d.off : d.off+size]
+			d.off += size
+		}
+		m.datas = append(m.datas, s)
+	}
+}
+
+func (d *decoder) limits() limits {
+	switch flag := d.byte(); flag {
+	case 0:
+		return limits{min: int64(d.u32()), max: -1}
+	case 1:
+		l := limits{min: int64(d.u32()), max: int64(d.u32())}
+		if l.max < l.min && d.err == nil {
+			d.fail(ErrMalformed, "limits maximum %d below minimum %d", l.max, l.min)
+		}
+		return l
+	default:
+		if d.err == nil {
+			d.fail(ErrUnsupported, "limits flags %d", flag)
+		}
+		return limits{}
+	}
+}
+
+// offset reads a segment offset, which must be an i32.const expression.
+func (d *decoder) offset() int64 {
+	e := d.constExpr()
+	if d.err == nil && e.op != opI32Const {
+		d.fail(ErrUnsupported, "segment offset of type %v", constTypes[e.op])
+	}
+	return int64(uint32(e.value))
+}
+
+func (d *decoder) constExpr() constExpr {
+	var e constExpr
+	switch e.op = d.byte(); e.op {
+	case opI32Const:
+		e.value = uint64(uint32(d.s32()))
+	case opI64Const:
+		e.value = uint64(d.s64())
+	case opF32Const:
+		e.value = uint64(d.u32le())
+	case opF64Const:
+		e.value = d.u64le()
+	default:
+		if d.err == nil {
+			d.fail(ErrUnsupported, "constant expression opcode 0x%02x", e.op)
+		}
+		return e
+	}
+	if end := d.byte(); end != opEnd && d.err == nil {
+		d.fail(ErrUnsupported, "constant expression of more than one instruction")
+	}
+	return e
+}
+
+func (d *decoder) valtypes() []types.Type {
+	var ts []types.Type
+	for n := d.u32(); n > 0 && d.err == nil; n-- {
+		ts = append(ts, d.valtype())
+	}
+	return ts
+}
+
+func (d *decoder) valtype() types.Type {
+	b := d.byte()
+	if d.err != nil {
+		return nil
+	}
+	t, ok := valtypes[b]
+	if !ok {
+		d.fail(ErrUnsupported, "value type 0x%02x", b)
+	}
+	return t
+}
+
+func (d *decoder) name() string {
+	n := int(d.u32())
+	if d.err != nil {
+		return ""
+	}
+	if n > len(d.data)-d.off {
+		d.fail(ErrMalformed, "name overruns the section")
+		return ""
+	}
+	s := string(d.data[d.off : d.off+n])
+	d.off += n
+	return s
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if d.off >= len(d.data) {
+		d.fail(ErrMalformed, "unexpected end")
+		return 0
+	}
+	b := d.data[d.off]
+	d.off++
+	return b
+}
+
+func (d *decoder) u32le() uint32 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data)-d.off < 4 {
+		d.fail(ErrMalformed, "unexpected end")
+		return 0
+	}
+	v := binary.LittleEndian.Uint32(d.data[d.off:])
+	d.off += 4
+	return v
+}
+
+func (d *decoder) u64le() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data)-d.off < 8 {
+		d.fail(ErrMalformed, "unexpected end")
+		return 0
+	}
+	v := binary.LittleEndian.Uint64(d.data[d.off:])
+	d.off += 8
+	return v
+}
+
+// u32 reads an unsigned LEB128 value of at most 32 bits.
+func (d *decoder) u32() uint32 {
+	var v uint64
+	for shift := 0; ; shift += 7 {
+		b := d.byte()
+		if d.err != nil {
+			return 0
+		}
+		v |= uint64(b&0x7F) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift >= 28 {
+			d.fail(ErrMalformed, "integer too long")
+			return 0
+		}
+	}
+	if v > math.MaxUint32 {
+		d.fail(ErrMalformed, "integer too large")
+		return 0
+	}
+	return uint32(v)
+}
+
+func (d *decoder) s32() int32 {
+	v := d.sleb(32)
+	if d.err == nil && (v < math.MinInt32 || v > math.MaxInt32) {
+		d.fail(ErrMalformed, "integer too large")
+	}
+	return int32(v)
+}
+
+func (d *decoder) s64() int64 {
+	return d.sleb(64)
+}
+
+// sleb reads a signed LEB128 value of at most bits bits.
+func (d *decoder) sleb(bits int) int64 {
+	var v int64
+	shift := 0
+	for {
+		b := d.byte()
+		if d.err != nil {
+			return 0
+		}
+		v |= int64(b&0x7F) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 != 0 {
+				v |= -1 << shift
+			}
+			return v
+		}
+		if shift >= bits {
+			d.fail(ErrMalformed, "integer too long")
+			return 0
+		}
+	}
+}
+
+func (d *decoder) malformed(format string, args ...any) error {
+	d.fail(ErrMalformed, format, args...)
+	return d.err
+}
+
+func (d *decoder) fail(kind error, format string, args ...any) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w at offset %d: %s", kind, d.base+d.off, fmt.Sprintf(format, args...))
+	}
+}
+
+var valtypes = map[byte]types.Type{
+	valI32: types.TypeI32,
+	valI64: types.TypeI64,
+	valF32: types.TypeF32,
+	valF64: types.TypeF64,
+}
+
+var constTypes = map[byte]types.Type{
+	opI32Const: types.TypeI32,
+	opI64Const: types.TypeI64,
+	opF32Const: types.TypeF32,
+	opF64Const: types.TypeF64,
+}
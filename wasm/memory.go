@@ -0,0 +1,265 @@
+package wasm
+
+import (
+	"math"
+
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/types"
+)
+
+// helper is a function the translator adds to the constant pool on first use
+// to lower an operator no single opcode matches. Most access linear memory,
+// which lives in an i8 array global: loads take the address and the static
+// offset; stores take the address, the value, and the offset. Multi-byte
+// values are little-endian. The rest, in numeric.go, guard numeric operators.
+type helper int
+
+const (
+	load8S helper = iota
+	load8U
+	load16S
+	load16U
+	load32
+	load64
+	store8
+	store16
+	store32
+	store64
+	grow
+	divS32
+	divS64
+	divF32
+	divF64
+	truncF32S32
+	truncF32U32
+	truncF64S32
+	truncF64U32
+	truncF32S64
+	truncF32U64
+	truncF64S64
+	truncF64U64
+)
+
+var helperNames = map[helper]string{
+	load8S:  "load8_s",
+	load8U:  "load8_u",
+	load16S: "load16_s",
+	load16U: "load16_u",
+	load32:  "load32",
+	load64:  "load64",
+	store8:  "store8",
+	store16: "store16",
+	store32: "store32",
+	store64: "store64",
+	grow:    "memory.grow",
+
+	divS32:      "i32.div_s",
+	divS64:      "i64.div_s",
+	divF32:      "f32.div",
+	divF64:      "f64.div",
+	truncF32S32: "i32.trunc_f32_s",
+	truncF32U32: "i32.trunc_f32_u",
+	truncF64S32: "i32.trunc_f64_s",
+	truncF64U32: "i32.trunc_f64_u",
+	truncF32S64: "i64.trunc_f32_s",
+	truncF32U64: "i64.trunc_f32_u",
+	truncF64S64: "i64.trunc_f64_s",
+	truncF64U64: "i64.trunc_f64_u",
+}
+
+// helper returns the constant index of h, building it on first use.
+func (t *translator) helper(h helper) int {
+	if idx, ok := t.helpers[h]; ok {
+		return idx
+	}
+	var fn *types.Function
+	switch h {
+	case load8S, load8U, load16S, load16U, load32:
+		fn = t.load(h, types.TypeI32)
+	case load64:
+		fn = t.load(h, types.TypeI64)
+	case store8, store16, store32:
+		fn = t.store(h, types.TypeI32)
+	case store64:
+		fn = t.store(h, types.TypeI64)
+	case grow:
+		fn = t.grow()
+	case divS32:
+		fn = t.divS(types.TypeI32)
+	case divS64:
+		fn = t.divS(types.TypeI64)
+	case divF32:
+		fn = t.divF(types.TypeF32)
+	case divF64:
+		fn = t.divF(types.TypeF64)
+	default:
+		fn = t.trunc(truncs[h])
+	}
+	fn.Debug = &types.Debug{Name: helperNames[h]}
+	t.helpers[h] = t.constant(fn)
+	return t.helpers[h]
+}
+
+// load builds a helper reading h's width of bytes. The address is checked
+// once up front, so a partially out-of-bounds read traps like a wholly
+// out-of-bounds one.
+func (t *translator) load(h helper, result types.Type) *types.Function {
+	const (
+		addr = iota
+		offset
+		ea
+		idx
+	)
+	b := types.NewFunctionBuilder(&types.FunctionType{
+		Params:  []types.Type{types.TypeI32, types.TypeI64},
+		Returns: []types.Type{result},
+	}).Locals(types.TypeI64, types.TypeI32)
+	t.index(b, addr, offset, ea, idx)
+
+	width := widths[h]
+	for k := range width {
+		b.Emit(instr.New(instr.GLOBAL_GET, uint64(t.memory)), instr.New(instr.LOCAL_GET, idx))
+		if k > 0 {
+			b.Emit(instr.New(instr.I32_CONST, uint64(k)), instr.New(instr.I32_ADD))
+		}
+		b.Emit(
+			instr.New(instr.ARRAY_GET),
+			instr.New(instr.I32_CONST, 0xFF),
+			instr.New(instr.I32_AND),
+		)
+		if result == types.TypeI64 {
+			b.Emit(instr.New(instr.I32_TO_I64_U))
+			if k > 0 {
+				b.Emit(instr.New(instr.I64_CONST, uint64(8*k)), instr.New(instr.I64_SHL), instr.New(instr.I64_OR))
+			}
+		} else if k > 0 {
+			b.Emit(instr.New(instr.I32_CONST, uint64(8*k)), instr.New(instr.I32_SHL), instr.New(instr.I32_OR))
+		}
+	}
+	switch h {
+	case load8S:
+		b.Emit(instr.New(instr.I32_EXTEND8_S))
+	case load16S:
+		b.Emit(instr.New(instr.I32_EXTEND16_S))
+	}
+	return b.Emit(instr.New(instr.RETURN)).MustBuild()
+}
+
+// store builds a helper writing h's width of bytes. The highest byte is
+// written first: when it is in bounds so is every byte below it, so a store
+// that traps leaves memory untouched.
+func (t *translator) store(h helper, value types.Type) *types.Function {
+	const (
+		addr = iota
+		val
+		offset
+		ea
+		idx
+	)
+	b := types.NewFunctionBuilder(&types.FunctionType{
+		Params: []types.Type{types.TypeI32, value, types.TypeI64},
+	}).Locals(types.TypeI64, types.TypeI32)
+	t.index(b, addr, offset, ea, idx)
+
+	for k := widths[h] - 1; k >= 0; k-- {
+		b.Emit(instr.New(instr.GLOBAL_GET, uint64(t.memory)), instr.New(instr.LOCAL_GET, idx))
+		if k > 0 {
+			b.Emit(instr.New(instr.I32_CONST, uint64(k)), instr.New(instr.I32_ADD))
+		}
+		b.Emit(instr.New(instr.LOCAL_GET, val))
+		if value == types.TypeI64 {
+			if k > 0 {
+				b.Emit(instr.New(instr.I64_CONST, uint64(8*k)), instr.New(instr.I64_SHR_U))
+			}
+			b.Emit(instr.New(instr.I64_TO_I32))
+		} else if k > 0 {
+			b.Emit(instr.New(instr.I32_CONST, uint64(8*k)), instr.New(instr.I32_SHR_U))
+		}
+		b.Emit(instr.New(instr.ARRAY_SET))
+	}
+	return b.Emit(instr.New(instr.RETURN)).MustBuild()
+}
+
+// index emits the effective address of an access into local idx. An address
+// past the i32 range becomes math.MinInt32, which every byte index derived
+// from it leaves negative, so the array access traps as out of range.
+func (t *translator) index(b *types.FunctionBuilder, addr, offset, ea, idx uint64) {
+	b.Emit(
+		instr.New(instr.I32_CONST, uint64(1)<<31),
+		instr.New(instr.LOCAL_GET, addr),
+		instr.New(instr.I32_TO_I64_U),
+		instr.New(instr.LOCAL_GET, offset),
+		instr.New(instr.I64_ADD),
+		instr.New(instr.LOCAL_TEE, ea),
+		instr.New(instr.I64_TO_I32),
+		instr.New(instr.LOCAL_GET, ea),
+		instr.New(instr.I64_CONST, math.MaxInt32),
+		instr.New(instr.I64_GT_U),
+		instr.New(instr.SELECT),
+		instr.New(instr.LOCAL_SET, idx),
+	)
+}
+
+// grow builds memory.grow: it reallocates the memory array with delta more
+// pages and returns the old page count, or -1 past the memory's maximum.
+func (t *translator) grow() *types.Function {
+	const (
+		delta = iota
+		old
+	)
+	limit := int64(maxPages)
+	if max := t.m.memory.max; max >= 0 && max < limit {
+		limit = max
+	}
+	mem := uint64(t.memory)
+	b := types.NewFunctionBuilder(&types.FunctionType{
+		Params:  []types.Type{types.TypeI32},
+		Returns: []types.Type{types.TypeI32},
+	}).Locals(types.TypeI32)
+	fail := b.Label()
+	return b.Emit(
+		instr.New(instr.GLOBAL_GET, mem),
+		instr.New(instr.ARRAY_LEN),
+		instr.New(instr.I32_CONST, 16),
+		instr.New(instr.I32_SHR_U),
+		instr.New(instr.LOCAL_SET, old),
+		instr.New(instr.LOCAL_GET, delta),
+		instr.New(instr.I32_CONST, uint64(limit)),
+		instr.New(instr.LOCAL_GET, old),
+		instr.New(instr.I32_SUB),
+		instr.New(instr.I32_GT_U),
+	).BrIf(fail).Emit(
+		instr.New(instr.LOCAL_GET, old),
+		instr.New(instr.LOCAL_GET, delta),
+		instr.New(instr.I32_ADD),
+		instr.New(instr.I32_CONST, 16),
+		instr.New(instr.I32_SHL),
+		instr.New(instr.ARRAY_NEW_DEFAULT, uint64(t.typ(types.TypeI8Array))),
+		instr.New(instr.DUP),
+		instr.New(instr.I32_CONST, 0),
+		instr.New(instr.GLOBAL_GET, mem),
+		instr.New(instr.I32_CONST, 0),
+		instr.New(instr.GLOBAL_GET, mem),
+		instr.New(instr.ARRAY_LEN),
+		instr.New(instr.ARRAY_COPY),
+		instr.New(instr.GLOBAL_SET, mem),
+		instr.New(instr.LOCAL_GET, old),
+		instr.New(instr.RETURN),
+	).Bind(fail).Emit(
+		instr.New(instr.I32_CONST, math.MaxUint32),
+		instr.New(instr.RETURN),
+	).MustBuild()
+}
+
+var widths = map[helper]int{
+	load8S:  1,
+	load8U:  1,
+	load16S: 2,
+	load16U: 2,
+	load32:  4,
+	load64:  8,
+	store8:  1,
+	store16: 2,
+	store32: 4,
+	store64: 8,
+}
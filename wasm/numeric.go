@@ -0,0 +1,166 @@
+package wasm
+
+import (
+	"math"
+
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/types"
+)
+
+// guard is the lowering of a WebAssembly operator whose opcode disagrees with
+// it on some inputs: a call to a helper taking in operands that handles those
+// inputs before applying the opcode.
+type guard struct {
+	helper helper
+	in     int
+}
+
+// conversion is a trapping float-to-integer truncation. Inputs in (lo, hi)
+// convert; the rest, NaN included, trap. closed admits lo itself, for the
+// bounds where lo is the least target value and the value below it is not
+// representable in the source type.
+type conversion struct {
+	from   types.Type
+	to     types.Type
+	op     instr.Opcode
+	lo, hi float64
+	closed bool
+}
+
+var guards = map[byte]guard{
+	0x6D: {helper: divS32, in: 2},
+	0x7F: {helper: divS64, in: 2},
+	0x95: {helper: divF32, in: 2},
+	0xA3: {helper: divF64, in: 2},
+	0xA8: {helper: truncF32S32, in: 1},
+	0xA9: {helper: truncF32U32, in: 1},
+	0xAA: {helper: truncF64S32, in: 1},
+	0xAB: {helper: truncF64U32, in: 1},
+	0xAE: {helper: truncF32S64, in: 1},
+	0xAF: {helper: truncF32U64, in: 1},
+	0xB0: {helper: truncF64S64, in: 1},
+	0xB1: {helper: truncF64U64, in: 1},
+}
+
+var truncs = map[helper]conversion{
+	truncF32S32: {from: types.TypeF32, to: types.TypeI32, op: instr.F32_TO_I32_S, lo: math.MinInt32, hi: 1 << 31, closed: true},
+	truncF32U32: {from: types.TypeF32, to: types.TypeI32, op: instr.F32_TO_I32_U, lo: -1, hi: 1 << 32},
+	truncF64S32: {from: types.TypeF64, to: types.TypeI32, op: instr.F64_TO_I32_S, lo: math.MinInt32 - 1, hi: 1 << 31},
+	truncF64U32: {from: types.TypeF64, to: types.TypeI32, op: instr.F64_TO_I32_U, lo: -1, hi: 1 << 32},
+	truncF32S64: {from: types.TypeF32, to: types.TypeI64, op: instr.F32_TO_I64_S, lo: math.MinInt64, hi: 1 << 63, closed: true},
+	truncF32U64: {from: types.TypeF32, to: types.TypeI64, op: instr.F32_TO_I64_U, lo: -1, hi: 1 << 64},
+	truncF64S64: {from: types.TypeF64, to: types.TypeI64, op: instr.F64_TO_I64_S, lo: math.MinInt64, hi: 1 << 63, closed: true},
+	truncF64U64: {from: types.TypeF64, to: types.TypeI64, op: instr.F64_TO_I64_U, lo: -1, hi: 1 << 64},
+}
+
+// divS builds a signed division that traps on the one quotient that
+// overflows, the least value divided by -1, which the opcode wraps. Division
+// by zero traps in the opcode itself.
+func (t *translator) divS(typ types.Type) *types.Function {
+	const (
+		lhs = iota
+		rhs
+	)
+	ne, div := instr.I32_NE, instr.I32_DIV_S
+	minusOne, least := instr.New(instr.I32_CONST, math.MaxUint32), instr.New(instr.I32_CONST, 1<<31)
+	if typ == types.TypeI64 {
+		ne, div = instr.I64_NE, instr.I64_DIV_S
+		minusOne, least = instr.New(instr.I64_CONST, math.MaxUint64), instr.New(instr.I64_CONST, 1<<63)
+	}
+	b := types.NewFunctionBuilder(&types.FunctionType{
+		Params:  []types.Type{typ, typ},
+		Returns: []types.Type{typ},
+	})
+	ok := b.Label()
+	return b.Emit(
+		instr.New(instr.LOCAL_GET, rhs),
+		minusOne,
+		instr.New(ne),
+	).BrIf(ok).Emit(
+		instr.New(instr.LOCAL_GET, lhs),
+		least,
+		instr.New(ne),
+	).BrIf(ok).Emit(
+		instr.New(instr.UNREACHABLE),
+	).Bind(ok).Emit(
+		instr.New(instr.LOCAL_GET, lhs),
+		instr.New(instr.LOCAL_GET, rhs),
+		instr.New(div),
+		instr.New(instr.RETURN),
+	).MustBuild()
+}
+
+// divF builds an IEEE 754 division. The opcode traps on a zero divisor, where
+// the quotient is lhs times an infinity carrying the divisor's sign: a signed
+// infinity, or NaN when lhs is zero or NaN.
+func (t *translator) divF(typ types.Type) *types.Function {
+	const (
+		lhs = iota
+		rhs
+	)
+	ne, copysign, mul, div := instr.F32_NE, instr.F32_COPYSIGN, instr.F32_MUL, instr.F32_DIV
+	zero, inf := instr.New(instr.F32_CONST, 0), instr.New(instr.F32_CONST, uint64(math.Float32bits(float32(math.Inf(1)))))
+	if typ == types.TypeF64 {
+		ne, copysign, mul, div = instr.F64_NE, instr.F64_COPYSIGN, instr.F64_MUL, instr.F64_DIV
+		zero, inf = instr.New(instr.F64_CONST, 0), instr.New(instr.F64_CONST, math.Float64bits(math.Inf(1)))
+	}
+	b := types.NewFunctionBuilder(&types.FunctionType{
+		Params:  []types.Type{typ, typ},
+		Returns: []types.Type{typ},
+	})
+	nonzero := b.Label()
+	return b.Emit(
+		instr.New(instr.LOCAL_GET, rhs),
+		zero,
+		instr.New(ne),
+	).BrIf(nonzero).Emit(
+		instr.New(instr.LOCAL_GET, lhs),
+		inf,
+		instr.New(instr.LOCAL_GET, rhs),
+		instr.New(copysign),
+		instr.New(mul),
+		instr.New(instr.RETURN),
+	).Bind(nonzero).Emit(
+		instr.New(instr.LOCAL_GET, lhs),
+		instr.New(instr.LOCAL_GET, rhs),
+		instr.New(div),
+		instr.New(instr.RETURN),
+	).MustBuild()
+}
+
+// trunc builds c, trapping on the inputs the opcode would saturate. Every
+// comparison with NaN is false, so NaN fails the lower bound.
+func (t *translator) trunc(c conversion) *types.Function {
+	lower, less := instr.F32_GT, instr.F32_LT
+	lo, hi := instr.New(instr.F32_CONST, uint64(math.Float32bits(float32(c.lo)))), instr.New(instr.F32_CONST, uint64(math.Float32bits(float32(c.hi))))
+	if c.from == types.TypeF64 {
+		lower, less = instr.F64_GT, instr.F64_LT
+		lo, hi = instr.New(instr.F64_CONST, math.Float64bits(c.lo)), instr.New(instr.F64_CONST, math.Float64bits(c.hi))
+	}
+	if c.closed {
+		lower = instr.F32_GE
+		if c.from == types.TypeF64 {
+			lower = instr.F64_GE
+		}
+	}
+	b := types.NewFunctionBuilder(&types.FunctionType{
+		Params:  []types.Type{c.from},
+		Returns: []types.Type{c.to},
+	})
+	above, trap, ok := b.Label(), b.Label(), b.Label()
+	return b.Emit(
+		instr.New(instr.LOCAL_GET, 0),
+		lo,
+		instr.New(lower),
+	).BrIf(above).Br(trap).Bind(above).Emit(
+		instr.New(instr.LOCAL_GET, 0),
+		hi,
+		instr.New(less),
+	).BrIf(ok).Bind(trap).Emit(
+		instr.New(instr.UNREACHABLE),
+	).Bind(ok).Emit(
+		instr.New(instr.LOCAL_GET, 0),
+		instr.New(c.op),
+		instr.New(instr.RETURN),
+	).MustBuild()
+}
@@ -0,0 +1,923 @@
+package wasm
+
+import (
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/siyul-park/minivm/instr"
+	"github.com/siyul-park/minivm/program"
+	"github.com/siyul-park/minivm/types"
+)
+
+// translator lowers a decoded module to a program, interning the constant and
+// type pools its functions share.
+type translator struct {
+	m         *module
+	constants []types.Value
+	typs      []types.Type
+	globals   []types.Type
+	memory    int
+	table     int
+	helpers   map[helper]int
+}
+
+// function translates one function body. height is the operand-stack height
+// WebAssembly validation assigns to the current instruction, counted from the
+// function's first operand; dead marks code after an unconditional branch,
+// which is decoded but not emitted.
+type function struct {
+	t      *translator
+	r      *decoder
+	typ    *types.FunctionType
+	locals []types.Type
+	code   *instr.Builder
+	frames []frame
+	height int
+	dead   bool
+}
+
+// frame is one open block, loop, or if. Branches to it land on label with
+// its arity of values on top of the entry height; a loop's label is its head
+// and takes no values. A frame opened in dead code is skipped whole.
+type frame struct {
+	loop    bool
+	cond    bool
+	results int
+	height  int
+	label   instr.Label
+	els     instr.Label
+	hasElse bool
+	dead    bool
+}
+
+// numeric is the lowering of a WebAssembly operator to one opcode taking in
+// operands and pushing one result.
+type numeric struct {
+	op instr.Opcode
+	in int
+}
+
+// access is the lowering of a load or store: conversions applied to the
+// value around a call to the memory helper.
+type access struct {
+	helper helper
+	pre    []instr.Opcode
+	post   []instr.Opcode
+}
+
+const (
+	opUnreachable  byte = 0x00
+	opNop          byte = 0x01
+	opBlock        byte = 0x02
+	opLoop         byte = 0x03
+	opIf           byte = 0x04
+	opElse         byte = 0x05
+	opEnd          byte = 0x0B
+	opBr           byte = 0x0C
+	opBrIf         byte = 0x0D
+	opBrTable      byte = 0x0E
+	opReturn       byte = 0x0F
+	opCall         byte = 0x10
+	opCallIndirect byte = 0x11
+	opDrop         byte = 0x1A
+	opSelect       byte = 0x1B
+	opLocalGet     byte = 0x20
+	opLocalSet     byte = 0x21
+	opLocalTee     byte = 0x22
+	opGlobalGet    byte = 0x23
+	opGlobalSet    byte = 0x24
+	opMemorySize   byte = 0x3F
+	opMemoryGrow   byte = 0x40
+	opI32Const     byte = 0x41
+	opI64Const     byte = 0x42
+	opF32Const     byte = 0x43
+	opF64Const     byte = 0x44
+)
+
+// maxTargets is the most cases a BR_TABLE can encode.
+const maxTargets = math.MaxUint8
+
+var numerics = map[byte]numeric{
+	0x45: {instr.I32_EQZ, 1},
+	0x46: {instr.I32_EQ, 2},
+	0x47: {instr.I32_NE, 2},
+	0x48: {instr.I32_LT_S, 2},
+	0x49: {instr.I32_LT_U, 2},
+	0x4A: {instr.I32_GT_S, 2},
+	0x4B: {instr.I32_GT_U, 2},
+	0x4C: {instr.I32_LE_S, 2},
+	0x4D: {instr.I32_LE_U, 2},
+	0x4E: {instr.I32_GE_S, 2},
+	0x4F: {instr.I32_GE_U, 2},
+	0x50: {instr.I64_EQZ, 1},
+	0x51: {instr.I64_EQ, 2},
+	0x52: {instr.I64_NE, 2},
+	0x53: {instr.I64_LT_S, 2},
+	0x54: {instr.I64_LT_U, 2},
+	0x55: {instr.I64_GT_S, 2},
+	0x56: {instr.I64_GT_U, 2},
+	0x57: {instr.I64_LE_S, 2},
+	0x58: {instr.I64_LE_U, 2},
+	0x59: {instr.I64_GE_S, 2},
+	0x5A: {instr.I64_GE_U, 2},
+	0x5B: {instr.F32_EQ, 2},
+	0x5C: {instr.F32_NE, 2},
+	0x5D: {instr.F32_LT, 2},
+	0x5E: {instr.F32_GT, 2},
+	0x5F: {instr.F32_LE, 2},
+	0x60: {instr.F32_GE, 2},
+	0x61: {instr.F64_EQ, 2},
+	0x62: {instr.F64_NE, 2},
+	0x63: {instr.F64_LT, 2},
+	0x64: {instr.F64_GT, 2},
+	0x65: {instr.F64_LE, 2},
+	0x66: {instr.F64_GE, 2},
+	0x67: {instr.I32_CLZ, 1},
+	0x68: {instr.I32_CTZ, 1},
+	0x69: {instr.I32_POPCNT, 1},
+	0x6A: {instr.I32_ADD, 2},
+	0x6B: {instr.I32_SUB, 2},
+	0x6C: {instr.I32_MUL, 2},
+	0x6E: {instr.I32_DIV_U, 2},
+	0x6F: {instr.I32_REM_S, 2},
+	0x70: {instr.I32_REM_U, 2},
+	0x71: {instr.I32_AND, 2},
+	0x72: {instr.I32_OR, 2},
+	0x73: {instr.I32_XOR, 2},
+	0x74: {instr.I32_SHL, 2},
+	0x75: {instr.I32_SHR_S, 2},
+	0x76: {instr.I32_SHR_U, 2},
+	0x77: {instr.I32_ROTL, 2},
+	0x78: {instr.I32_ROTR, 2},
+	0x79: {instr.I64_CLZ, 1},
+	0x7A: {instr.I64_CTZ, 1},
+	0x7B: {instr.I64_POPCNT, 1},
+	0x7C: {instr.I64_ADD, 2},
+	0x7D: {instr.I64_SUB, 2},
+	0x7E: {instr.I64_MUL, 2},
+	0x80: {instr.I64_DIV_U, 2},
+	0x81: {instr.I64_REM_S, 2},
+	0x82: {instr.I64_REM_U, 2},
+	0x83: {instr.I64_AND, 2},
+	0x84: {instr.I64_OR, 2},
+	0x85: {instr.I64_XOR, 2},
+	0x86: {instr.I64_SHL, 2},
+	0x87: {instr.I64_SHR_S, 2},
+	0x88: {instr.I64_SHR_U, 2},
+	0x89: {instr.I64_ROTL, 2},
+	0x8A: {instr.I64_ROTR, 2},
+	0x8B: {instr.F32_ABS, 1},
+	0x8C: {instr.F32_NEG, 1},
+	0x8D: {instr.F32_CEIL, 1},
+	0x8E: {instr.F32_FLOOR, 1},
+	0x8F: {instr.F32_TRUNC, 1},
+	0x90: {instr.F32_NEAREST, 1},
+	0x91: {instr.F32_SQRT, 1},
+	0x92: {instr.F32_ADD, 2},
+	0x93: {instr.F32_SUB, 2},
+	0x94: {instr.F32_MUL, 2},
+	0x96: {instr.F32_MIN, 2},
+	0x97: {instr.F32_MAX, 2},
+	0x98: {instr.F32_COPYSIGN, 2},
+	0x99: {instr.F64_ABS, 1},
+	0x9A: {instr.F64_NEG, 1},
+	0x9B: {instr.F64_CEIL, 1},
+	0x9C: {instr.F64_FLOOR, 1},
+	0x9D: {instr.F64_TRUNC, 1},
+	0x9E: {instr.F64_NEAREST, 1},
+	0x9F: {instr.F64_SQRT, 1},
+	0xA0: {instr.F64_ADD, 2},
+	0xA1: {instr.F64_SUB, 2},
+	0xA2: {instr.F64_MUL, 2},
+	0xA4: {instr.F64_MIN, 2},
+	0xA5: {instr.F64_MAX, 2},
+	0xA6: {instr.F64_COPYSIGN, 2},
+	0xA7: {instr.I64_TO_I32, 1},
+	0xAC: {instr.I32_TO_I64_S, 1},
+	0xAD: {instr.I32_TO_I64_U, 1},
+	0xB2: {instr.I32_TO_F32_S, 1},
+	0xB3: {instr.I32_TO_F32_U, 1},
+	0xB4: {instr.I64_TO_F32_S, 1},
+	0xB5: {instr.I64_TO_F32_U, 1},
+	0xB6: {instr.F64_TO_F32, 1},
+	0xB7: {instr.I32_TO_F64_S, 1},
+	0xB8: {instr.I32_TO_F64_U, 1},
+	0xB9: {instr.I64_TO_F64_S, 1},
+	0xBA: {instr.I64_TO_F64_U, 1},
+	0xBB: {instr.F32_TO_F64, 1},
+	0xBC: {instr.I32_REINTERPRET_F32, 1},
+	0xBD: {instr.I64_REINTERPRET_F64, 1},
+	0xBE: {instr.F32_REINTERPRET_I32, 1},
+	0xBF: {instr.F64_REINTERPRET_I64, 1},
+	0xC0: {instr.I32_EXTEND8_S, 1},
+	0xC1: {instr.I32_EXTEND16_S, 1},
+	0xC2: {instr.I64_EXTEND8_S, 1},
+	0xC3: {instr.I64_EXTEND16_S, 1},
+	0xC4: {instr.I64_EXTEND32_S, 1},
+}
+
+var loads = map[byte]access{
+	0x28: {helper: load32},
+	0x29: {helper: load64},
+	0x2A: {helper: load32, post: []instr.Opcode{instr.F32_REINTERPRET_I32}},
+	0x2B: {helper: load64, post: []instr.Opcode{instr.F64_REINTERPRET_I64}},
+	0x2C: {helper: load8S},
+	0x2D: {helper: load8U},
+	0x2E: {helper: load16S},
+	0x2F: {helper: load16U},
+	0x30: {helper: load8S, post: []instr.Opcode{instr.I32_TO_I64_S}},
+	0x31: {helper: load8U, post: []instr.Opcode{instr.I32_TO_I64_U}},
+	0x32: {helper: load16S, post: []instr.Opcode{instr.I32_TO_I64_S}},
+	0x33: {helper: load16U, post: []instr.Opcode{instr.I32_TO_I64_U}},
+	0x34: {helper: load32, post: []instr.Opcode{instr.I32_TO_I64_S}},
+	0x35: {helper: load32, post: []instr.Opcode{instr.I32_TO_I64_U}},
+}
+
+var stores = map[byte]access{
+	0x36: {helper: store32},
+	0x37: {helper: store64},
+	0x38: {helper: store32, pre: []instr.Opcode{instr.I32_REINTERPRET_F32}},
+	0x39: {helper: store64, pre: []instr.Opcode{instr.I64_REINTERPRET_F64}},
+	0x3A: {helper: store8},
+	0x3B: {helper: store16},
+	0x3C: {helper: store8, pre: []instr.Opcode{instr.I64_TO_I32}},
+	0x3D: {helper: store16, pre: []instr.Opcode{instr.I64_TO_I32}},
+	0x3E: {helper: store32, pre: []instr.Opcode{instr.I64_TO_I32}},
+}
+
+func newTranslator(m *module) *translator {
+	return &translator{m: m, memory: -1, table: -1, helpers: map[helper]int{}}
+}
+
+func (t *translator) translate() (*program.Program, error) {
+	m := t.m
+	for _, g := range m.globals {
+		t.globals = append(t.globals, g.typ)
+	}
+	if m.memory != nil {
+		t.memory = len(t.globals)
+		t.globals = append(t.globals, types.TypeI8Array)
+	}
+	if m.table != nil {
+		t.table = len(t.globals)
+		t.globals = append(t.globals, types.NewArrayType(types.TypeAny))
+	}
+
+	var imports []program.Import
+	for i, imp := range m.imports {
+		typ, err := t.signature(imp.typ)
+		if err != nil {
+			return nil, err
+		}
+		t.constants = append(t.constants, program.NewDeclaration(typ))
+		imports = append(imports, program.Import{Name: imp.module + "." + imp.name, Typ: typ, Const: i})
+	}
+	t.constants = append(t.constants, make([]types.Value, len(m.funcs))...)
+
+	names := map[uint32]string{}
+	var exports []program.Export
+	for _, e := range m.exports {
+		ex := program.Export{Name: e.name, Kind: program.ExportGlobal}
+		switch e.kind {
+		case externFunc:
+			if int(e.index) >= len(t.constants) {
+				return nil, fmt.Errorf("%w: export %q of function %d", ErrMalformed, e.name, e.index)
+			}
+			ex.Kind, ex.Index = program.ExportFunction, int(e.index)
+			if _, ok := names[e.index]; !ok {
+				names[e.index] = e.name
+			}
+		case externGlobal:
+			if int(e.index) >= len(m.globals) {
+				return nil, fmt.Errorf("%w: export %q of global %d", ErrMalformed, e.name, e.index)
+			}
+			ex.Index = int(e.index)
+		case externMemory:
+			if t.memory < 0 || e.index != 0 {
+				return nil, fmt.Errorf("%w: export %q of memory %d", ErrMalformed, e.name, e.index)
+			}
+			ex.Index = t.memory
+		case externTable:
+			if t.table < 0 || e.index != 0 {
+				return nil, fmt.Errorf("%w: export %q of table %d", ErrMalformed, e.name, e.index)
+			}
+			ex.Index = t.table
+		default:
+			return nil, fmt.Errorf("%w: export %q of kind %d", ErrMalformed, e.name, e.kind)
+		}
+		exports = append(exports, ex)
+	}
+
+	for i, idx := range m.funcs {
+		typ, err := t.signature(idx)
+		if err != nil {
+			return nil, err
+		}
+		k := len(m.imports) + i
+		fn, err := t.function(k, typ, m.codes[i])
+		if err != nil {
+			return nil, err
+		}
+		if name, ok := names[uint32(k)]; ok {
+			fn.Debug = &types.Debug{Name: name}
+		}
+		t.constants[k] = fn
+	}
+
+	code, err := t.init()
+	if err != nil {
+		return nil, err
+	}
+	return program.New(code,
+		program.WithGlobals(t.globals...),
+		program.WithConstants(t.constants...),
+		program.WithTypes(t.typs...),
+		program.WithExports(exports...),
+		program.WithImports(imports...),
+	), nil
+}
+
+// init emits the program's code: global initializers, memory and table
+// allocation, active segments, and the call to the start function.
+func (t *translator) init() ([]instr.Instruction, error) {
+	m := t.m
+	var code []instr.Instruction
+	emit := func(op instr.Opcode, operands ...uint64) {
+		code = append(code, instr.New(op, operands...))
+	}
+
+	for i, g := range m.globals {
+		emit(constOps[g.init.op], g.init.value)
+		emit(instr.GLOBAL_SET, uint64(i))
+	}
+
+	if m.memory != nil {
+		emit(instr.I32_CONST, uint64(m.memory.min*pageSize))
+		emit(instr.ARRAY_NEW_DEFAULT, uint64(t.typ(types.TypeI8Array)))
+		emit(instr.GLOBAL_SET, uint64(t.memory))
+	}
+	for _, s := range m.datas {
+		if m.memory == nil || s.offset+int64(len(s.data)) > m.memory.min*pageSize {
+			return nil, fmt.Errorf("%w: data segment at %d does not fit in memory", ErrMalformed, s.offset)
+		}
+		if len(s.data) == 0 {
+			continue
+		}
+		data := make(types.TypedArray[int8], len(s.data))
+		for i, b := range s.data {
+			data[i] = int8(b)
+		}
+		emit(instr.GLOBAL_GET, uint64(t.memory))
+		emit(instr.I32_CONST, uint64(s.offset))
+		emit(instr.CONST_GET, uint64(t.constant(data)))
+		emit(instr.I32_CONST, 0)
+		emit(instr.I32_CONST, uint64(len(data)))
+		emit(instr.ARRAY_COPY)
+	}
+
+	if m.table != nil {
+		emit(instr.I32_CONST, uint64(m.table.min))
+		emit(instr.ARRAY_NEW_DEFAULT, uint64(t.typ(t.globals[t.table])))
+		emit(instr.GLOBAL_SET, uint64(t.table))
+	}
+	for _, s := range m.elems {
+		if m.table == nil || s.offset+int64(len(s.funcs)) > m.table.min {
+			return nil, fmt.Errorf("%w: element segment at %d does not fit in table", ErrMalformed, s.offset)
+		}
+		for i, fn := range s.funcs {
+			if int(fn) >= len(t.constants) {
+				return nil, fmt.Errorf("%w: element of function %d", ErrMalformed, fn)
+			}
+			emit(instr.GLOBAL_GET, uint64(t.table))
+			emit(instr.I32_CONST, uint64(s.offset)+uint64(i))
+			emit(instr.CONST_GET, uint64(fn))
+			emit(instr.ARRAY_SET)
+		}
+	}
+
+	if m.start >= 0 {
+		if m.start >= len(m.imports)+len(m.funcs) {
+			return nil, fmt.Errorf("%w: start function %d", ErrMalformed, m.start)
+		}
+		emit(instr.CONST_GET, uint64(m.start))
+		emit(instr.CALL)
+	}
+	return code, nil
+}
+
+func (t *translator) signature(idx uint32) (*types.FunctionType, error) {
+	if int(idx) >= len(t.m.types) {
+		return nil, fmt.Errorf("%w: type %d", ErrMalformed, idx)
+	}
+	return t.m.types[idx], nil
+}
+
+// funcType returns the signature of function idx, imported or defined.
+func (t *translator) funcType(idx uint32) (*types.FunctionType, bool) {
+	m := t.m
+	switch {
+	case int(idx) < len(m.imports):
+		typ, err := t.signature(m.imports[idx].typ)
+		return typ, err == nil
+	case int(idx) < len(m.imports)+len(m.funcs):
+		typ, err := t.signature(m.funcs[int(idx)-len(m.imports)])
+		return typ, err == nil
+	}
+	return nil, false
+}
+
+// constant appends v to the constant pool.
+func (t *translator) constant(v types.Value) int {
+	t.constants = append(t.constants, v)
+	return len(t.constants) - 1
+}
+
+// typ interns typ into the type pool.
+func (t *translator) typ(typ types.Type) int {
+	for i, u := range t.typs {
+		if u == typ {
+			return i
+		}
+	}
+	t.typs = append(t.typs, typ)
+	return len(t.typs) - 1
+}
+
+// function translates the body of function idx.
+func (t *translator) function(idx int, typ *types.FunctionType, b body) (*types.Function, error) {
+	f := &function{
+		t:      t,
+		r:      &decoder{data: b.code, base: b.off},
+		typ:    typ,
+		locals: append(slices.Clone(typ.Params), b.locals...),
+		code:   instr.NewBuilder(),
+	}
+	for i, l := range b.locals {
+		f.emit(zeros[l], 0)
+		f.emit(instr.LOCAL_SET, uint64(len(typ.Params)+i))
+	}
+	f.frames = []frame{{results: len(typ.Returns), label: f.code.Label()}}
+	for len(f.frames) > 0 && f.r.err == nil {
+		f.instr(f.r.byte())
+	}
+	if f.r.err == nil && f.r.off != len(f.r.data) {
+		f.fail(ErrMalformed, "trailing bytes after end")
+	}
+	if f.r.err != nil {
+		return nil, fmt.Errorf("function %d: %w", idx, f.r.err)
+	}
+
+	code, err := f.code.Assemble()
+	if err != nil {
+		return nil, fmt.Errorf("%w: function %d: %v", ErrUnsupported, idx, err)
+	}
+	return &types.Function{Typ: typ, Locals: b.locals, Code: instr.Marshal(code)}, nil
+}
+
+// instr translates the instruction starting with op. Immediates are always
+// decoded; nothing is emitted for dead code beyond tracking nested frames.
+func (f *function) instr(op byte) {
+	t := f.t
+	switch op {
+	case opUnreachable:
+		if !f.dead {
+			f.emit(instr.UNREACHABLE)
+			f.dead = true
+		}
+	case opNop:
+	case opBlock, opLoop, opIf:
+		results := f.blocktype()
+		if f.dead {
+			f.frames = append(f.frames, frame{dead: true})
+			return
+		}
+		fr := frame{results: results, label: f.code.Label()}
+		switch op {
+		case opLoop:
+			fr.loop = true
+			f.code.Bind(fr.label)
+		case opIf:
+			if !f.pop(1) {
+				return
+			}
+			fr.cond, fr.els = true, f.code.Label()
+			f.emit(instr.I32_EQZ)
+			f.code.BrIf(fr.els)
+		}
+		fr.height = f.height
+		f.frames = append(f.frames, fr)
+	case opElse:
+		fr := &f.frames[len(f.frames)-1]
+		if fr.dead {
+			return
+		}
+		if !fr.cond || fr.hasElse {
+			f.fail(ErrMalformed, "else outside if")
+			return
+		}
+		if !f.dead {
+			if !f.balanced(fr) {
+				return
+			}
+			f.code.Br(fr.label)
+		}
+		f.code.Bind(fr.els)
+		fr.hasElse = true
+		f.height, f.dead = fr.height, false
+	case opEnd:
+		fr := f.frames[len(f.frames)-1]
+		f.frames = f.frames[:len(f.frames)-1]
+		if fr.dead {
+			return
+		}
+		if !f.dead && !f.balanced(&fr) {
+			return
+		}
+		if fr.cond && !fr.hasElse {
+			if fr.results > 0 {
+				f.fail(ErrMalformed, "if without else has results")
+				return
+			}
+			f.code.Bind(fr.els)
+		}
+		if !fr.loop {
+			f.code.Bind(fr.label)
+		}
+		f.height, f.dead = fr.height+fr.results, false
+		if len(f.frames) == 0 {
+			f.emit(instr.RETURN)
+		}
+	case opBr:
+		depth := f.r.u32()
+		if f.dead {
+			return
+		}
+		if fr, ok := f.target(depth); ok {
+			f.code.Br(fr.label)
+		}
+		f.dead = true
+	case opBrIf:
+		depth := f.r.u32()
+		if f.dead || !f.pop(1) {
+			return
+		}
+		fr := f.frame(depth)
+		if fr == nil {
+			return
+		}
+		if f.excess(fr) == 0 {
+			f.code.BrIf(fr.label)
+			return
+		}
+		skip := f.code.Label()
+		f.emit(instr.I32_EQZ)
+		f.code.BrIf(skip)
+		if _, ok := f.target(depth); ok {
+			f.code.Br(fr.label)
+		}
+		f.code.Bind(skip)
+	case opBrTable:
+		n := f.r.u32()
+		var depths []uint32
+		for i := uint32(0); i <= n && f.r.err == nil; i++ {
+			depths = append(depths, f.r.u32())
+		}
+		if f.dead || f.r.err != nil {
+			return
+		}
+		if n > maxTargets {
+			f.fail(ErrUnsupported, "br_table of %d targets", n)
+			return
+		}
+		if !f.pop(1) {
+			return
+		}
+		f.table(depths)
+		f.dead = true
+	case opReturn:
+		if !f.dead {
+			f.emit(instr.RETURN)
+			f.dead = true
+		}
+	case opCall:
+		idx := f.r.u32()
+		if f.dead {
+			return
+		}
+		typ, ok := t.funcType(idx)
+		if !ok {
+			f.fail(ErrMalformed, "call of function %d", idx)
+			return
+		}
+		if f.pop(len(typ.Params)) {
+			f.emit(instr.CONST_GET, uint64(idx))
+			f.emit(instr.CALL)
+			f.push(len(typ.Returns))
+		}
+	case opCallIndirect:
+		idx := f.r.u32()
+		table := f.r.byte()
+		if f.dead || f.r.err != nil {
+			return
+		}
+		if t.table < 0 || table != 0 {
+			f.fail(ErrMalformed, "call_indirect through table %d", table)
+			return
+		}
+		typ, err := t.signature(idx)
+		if err != nil {
+			f.fail(ErrMalformed, "call_indirect of type %d", idx)
+			return
+		}
+		if f.pop(1 + len(typ.Params)) {
+			f.emit(instr.GLOBAL_GET, uint64(t.table))
+			f.emit(instr.SWAP)
+			f.emit(instr.ARRAY_GET)
+			f.emit(instr.REF_CAST, uint64(t.typ(typ)))
+			f.emit(instr.CALL)
+			f.push(len(typ.Returns))
+		}
+	case opDrop:
+		if !f.dead && f.pop(1) {
+			f.emit(instr.DROP)
+		}
+	case opSelect:
+		if !f.dead && f.pop(3) {
+			f.emit(instr.SELECT)
+			f.push(1)
+		}
+	case opLocalGet, opLocalSet, opLocalTee:
+		idx := f.r.u32()
+		if f.dead {
+			return
+		}
+		if int(idx) >= len(f.locals) {
+			f.fail(ErrMalformed, "local %d", idx)
+			return
+		}
+		switch op {
+		case opLocalGet:
+			f.emit(instr.LOCAL_GET, uint64(idx))
+			f.push(1)
+		case opLocalSet:
+			if f.pop(1) {
+				f.emit(instr.LOCAL_SET, uint64(idx))
+			}
+		default:
+			if f.pop(1) {
+				f.emit(instr.LOCAL_TEE, uint64(idx))
+				f.push(1)
+			}
+		}
+	case opGlobalGet, opGlobalSet:
+		idx := f.r.u32()
+		if f.dead {
+			return
+		}
+		if int(idx) >= len(t.m.globals) {
+			f.fail(ErrMalformed, "global %d", idx)
+			return
+		}
+		if op == opGlobalGet {
+			f.emit(instr.GLOBAL_GET, uint64(idx))
+			f.push(1)
+			return
+		}
+		if !t.m.globals[idx].mutable {
+			f.fail(ErrMalformed, "global.set of immutable global %d", idx)
+			return
+		}
+		if f.pop(1) {
+			f.emit(instr.GLOBAL_SET, uint64(idx))
+		}
+	case opMemorySize, opMemoryGrow:
+		mem := f.r.byte()
+		if f.dead || f.r.err != nil {
+			return
+		}
+		if t.memory < 0 || mem != 0 {
+			f.fail(ErrMalformed, "access to memory %d", mem)
+			return
+		}
+		if op == opMemorySize {
+			f.emit(instr.GLOBAL_GET, uint64(t.memory))
+			f.emit(instr.ARRAY_LEN)
+			f.emit(instr.I32_CONST, 16)
+			f.emit(instr.I32_SHR_U)
+			f.push(1)
+			return
+		}
+		if f.pop(1) {
+			f.emit(instr.CONST_GET, uint64(t.helper(grow)))
+			f.emit(instr.CALL)
+			f.push(1)
+		}
+	case opI32Const:
+		v := f.r.s32()
+		if !f.dead {
+			f.emit(instr.I32_CONST, uint64(uint32(v)))
+			f.push(1)
+		}
+	case opI64Const:
+		v := f.r.s64()
+		if !f.dead {
+			f.emit(instr.I64_CONST, uint64(v))
+			f.push(1)
+		}
+	case opF32Const:
+		v := f.r.u32le()
+		if !f.dead {
+			f.emit(instr.F32_CONST, uint64(v))
+			f.push(1)
+		}
+	case opF64Const:
+		v := f.r.u64le()
+		if !f.dead {
+			f.emit(instr.F64_CONST, v)
+			f.push(1)
+		}
+	default:
+		if a, ok := loads[op]; ok {
+			f.access(a, 1, 1)
+		} else if a, ok := stores[op]; ok {
+			f.access(a, 2, 0)
+		} else if g, ok := guards[op]; ok {
+			if !f.dead && f.pop(g.in) {
+				f.emit(instr.CONST_GET, uint64(t.helper(g.helper)))
+				f.emit(instr.CALL)
+				f.push(1)
+			}
+		} else if n, ok := numerics[op]; ok {
+			if !f.dead && f.pop(n.in) {
+				f.emit(n.op)
+				f.push(1)
+			}
+		} else {
+			f.fail(ErrUnsupported, "opcode 0x%02x", op)
+		}
+	}
+}
+
+// access lowers a load or store through its memory helper, which takes the
+// address, the value for a store, and the static offset.
+func (f *function) access(a access, in, out int) {
+	f.r.u32()
+	offset := f.r.u32()
+	if f.dead || f.r.err != nil {
+		return
+	}
+	if f.t.memory < 0 {
+		f.fail(ErrMalformed, "memory access without a memory")
+		return
+	}
+	if !f.pop(in) {
+		return
+	}
+	for _, op := range a.pre {
+		f.emit(op)
+	}
+	f.emit(instr.I64_CONST, uint64(offset))
+	f.emit(instr.CONST_GET, uint64(f.t.helper(a.helper)))
+	f.emit(instr.CALL)
+	for _, op := range a.post {
+		f.emit(op)
+	}
+	f.push(out)
+}
+
+// table lowers br_table. When every target is reached without unwinding the
+// stack, the cases branch straight to their labels; otherwise each target
+// that needs unwinding gets a landing pad that drops the excess first.
+func (f *function) table(depths []uint32) {
+	labels := make([]instr.Label, len(depths))
+	pads := map[uint32]instr.Label{}
+	for i, depth := range depths {
+		fr := f.frame(depth)
+		if fr == nil {
+			return
+		}
+		labels[i] = fr.label
+		if f.excess(fr) != 0 {
+			if _, ok := pads[depth]; !ok {
+				pads[depth] = f.code.Label()
+			}
+			labels[i] = pads[depth]
+		}
+	}
+	f.code.BrTable(labels[len(labels)-1], labels[:len(labels)-1]...)
+	for _, depth := range depths {
+		pad, ok := pads[depth]
+		if !ok {
+			continue
+		}
+		delete(pads, depth)
+		f.code.Bind(pad)
+		if fr, ok := f.target(depth); ok {
+			f.code.Br(fr.label)
+		}
+	}
+}
+
+// target emits the unwinding a branch to depth needs and returns the frame
+// it lands on.
+func (f *function) target(depth uint32) (*frame, bool) {
+	fr := f.frame(depth)
+	if fr == nil {
+		return nil, false
+	}
+	arity := fr.results
+	if fr.loop {
+		arity = 0
+	}
+	for range f.excess(fr) {
+		if arity > 0 {
+			f.emit(instr.SWAP)
+		}
+		f.emit(instr.DROP)
+	}
+	return fr, true
+}
+
+func (f *function) frame(depth uint32) *frame {
+	if int(depth) >= len(f.frames) {
+		f.fail(ErrMalformed, "branch depth %d", depth)
+		return nil
+	}
+	fr := &f.frames[len(f.frames)-1-int(depth)]
+	if f.excess(fr) < 0 {
+		f.fail(ErrMalformed, "branch with too few operands")
+		return nil
+	}
+	return fr
+}
+
+// excess counts the operands below a branch's values that it must drop to
+// land at fr's entry height.
+func (f *function) excess(fr *frame) int {
+	arity := fr.results
+	if fr.loop {
+		arity = 0
+	}
+	return f.height - fr.height - arity
+}
+
+func (f *function) balanced(fr *frame) bool {
+	if f.height != fr.height+fr.results {
+		f.fail(ErrMalformed, "block ends with %d operands, want %d", f.height-fr.height, fr.results)
+		return false
+	}
+	return true
+}
+
+// blocktype reads a block type and returns its result count.
+func (f *function) blocktype() int {
+	b := f.r.byte()
+	if f.r.err != nil || b == blockEmpty {
+		return 0
+	}
+	if _, ok := valtypes[b]; ok {
+		return 1
+	}
+	f.fail(ErrUnsupported, "block type 0x%02x", b)
+	return 0
+}
+
+func (f *function) pop(n int) bool {
+	base := 0
+	if len(f.frames) > 0 {
+		base = f.frames[len(f.frames)-1].height
+	}
+	if f.height-n < base {
+		f.fail(ErrMalformed, "operand stack underflow")
+		return false
+	}
+	f.height -= n
+	return true
+}
+
+func (f *function) push(n int) {
+	f.height += n
+}
+
+func (f *function) emit(op instr.Opcode, operands ...uint64) {
+	f.code.Emit(op, operands...)
+}
+
+func (f *function) fail(kind error, format string, args ...any) {
+	f.r.fail(kind, format, args...)
+}
+
+var constOps = map[byte]instr.Opcode{
+	opI32Const: instr.I32_CONST,
+	opI64Const: instr.I64_CONST,
+	opF32Const: instr.F32_CONST,
+	opF64Const: instr.F64_CONST,
+}
+
+var zeros = map[types.Type]instr.Opcode{
+	types.TypeI32: instr.I32_CONST,
+	types.TypeI64: instr.I64_CONST,
+	types.TypeF32: instr.F32_CONST,
+	types.TypeF64: instr.F64_CONST,
+}
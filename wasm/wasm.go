@@ -0,0 +1,60 @@
+// Package wasm translates WebAssembly modules into verified programs, so code
+// compiled to .wasm can run on minivm. It covers the numeric subset of the MVP:
+// i32, i64, f32, and f64 values and operators, locals and globals, structured
+// control flow, direct and indirect calls, imported functions, one table, and
+// one linear memory. Anything else is rejected with ErrUnsupported.
+package wasm
+
+import (
+	"errors"
+	"io"
+
+	"github.com/siyul-park/minivm/program"
+)
+
+const (
+	// Magic starts every WebAssembly binary.
+	Magic = "\x00asm"
+	// Version is the only binary format version Translate reads.
+	Version = 1
+	// Ext is the file extension of WebAssembly binaries.
+	Ext = ".wasm"
+)
+
+var (
+	ErrInvalidMagic       = errors.New("invalid wasm magic")
+	ErrUnsupportedVersion = errors.New("unsupported wasm version")
+	ErrMalformed          = errors.New("malformed wasm module")
+	ErrUnsupported        = errors.New("unsupported wasm feature")
+)
+
+// Translate reads a WebAssembly binary from r and translates it into a
+// verified program.
+//
+// Imported functions become program imports named "module.name", bound with
+// interp.WithImports, followed by one function constant per defined function,
+// so a function index is also its constant index. Globals keep their indices;
+// the memory, when present, follows them as an i8 array global of the
+// memory's byte length, and then the table as an array global of functions.
+// The program's code initializes the globals, copies the active data and
+// element segments into place, and calls the start function. Exported
+// functions, globals, the memory, and the table are exported under their
+// names.
+func Translate(r io.Reader) (*program.Program, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	m, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := newTranslator(m).translate()
+	if err != nil {
+		return nil, err
+	}
+	if err := program.Verify(prog); err != nil {
+		return nil, err
+	}
+	return prog, nil
+}
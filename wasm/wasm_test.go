@@ -0,0 +1,551 @@
+package wasm_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/siyul-park/minivm/interp"
+	"github.com/siyul-park/minivm/types"
+	"github.com/siyul-park/minivm/wasm"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	i32 = 0x7F
+	i64 = 0x7E
+	f32 = 0x7D
+	f64 = 0x7C
+)
+
+// module assembles a WebAssembly binary from its sections.
+func module(sections ...[]byte) []byte {
+	out := []byte("\x00asm\x01\x00\x00\x00")
+	for _, s := range sections {
+		out = append(out, s...)
+	}
+	return out
+}
+
+// section encodes a section holding a vector of items.
+func section(id byte, items ...[]byte) []byte {
+	return raw(id, vec(items...))
+}
+
+func raw(id byte, payload []byte) []byte {
+	return cat([]byte{id}, uleb(uint64(len(payload))), payload)
+}
+
+func vec(items ...[]byte) []byte {
+	out := uleb(uint64(len(items)))
+	for _, item := range items {
+		out = append(out, item...)
+	}
+	return out
+}
+
+func cat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func uleb(v uint64) []byte {
+	var out []byte
+	for {
+		b := byte(v & 0x7F)
+		v >>= 7
+		if v == 0 {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+func sleb(v int64) []byte {
+	var out []byte
+	for {
+		b := byte(v & 0x7F)
+		v >>= 7
+		if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+func name(s string) []byte {
+	return append(uleb(uint64(len(s))), s...)
+}
+
+func functype(params, results []byte) []byte {
+	return cat([]byte{0x60}, uleb(uint64(len(params))), params, uleb(uint64(len(results))), results)
+}
+
+func exportFunc(n string, idx uint64) []byte {
+	return cat(name(n), []byte{0x00}, uleb(idx))
+}
+
+// body encodes a function body: locals as (count, type) pairs, then code,
+// which must include the final end.
+func body(locals []byte, code ...[]byte) []byte {
+	b := cat(uleb(uint64(len(locals)/2)), locals, cat(code...))
+	return append(uleb(uint64(len(b))), b...)
+}
+
+func op(b ...byte) []byte { return b }
+
+func i32c(v int32) []byte { return append([]byte{0x41}, sleb(int64(v))...) }
+
+func i64c(v int64) []byte { return append([]byte{0x42}, sleb(v)...) }
+
+func f64c(v float64) []byte {
+	return binary.LittleEndian.AppendUint64([]byte{0x44}, math.Float64bits(v))
+}
+
+// operator assembles a module exporting "f", which applies code to its
+// parameters.
+func operator(params []byte, result byte, code byte) []byte {
+	var get []byte
+	for k := range params {
+		get = append(get, 0x20, byte(k))
+	}
+	return module(
+		section(1, functype(params, op(result))),
+		section(3, uleb(0)),
+		section(7, exportFunc("f", 0)),
+		section(10, body(nil, get, op(code, 0x0B))),
+	)
+}
+
+func memarg(code byte, offset uint64) []byte {
+	return cat([]byte{code, 0x00}, uleb(offset))
+}
+
+func TestTranslate(t *testing.T) {
+	tests := []struct {
+		name   string
+		module []byte
+		fn     string
+		args   []types.Boxed
+		want   []types.Boxed
+		err    error
+	}{
+		{
+			name: "add",
+			module: module(
+				section(1, functype(op(i32, i32), op(i32))),
+				section(3, uleb(0)),
+				section(7, exportFunc("add", 0)),
+				section(10, body(nil, op(0x20, 0, 0x20, 1, 0x6A, 0x0B))),
+			),
+			fn:   "add",
+			args: []types.Boxed{types.BoxI32(2), types.BoxI32(3)},
+			want: []types.Boxed{types.BoxI32(5)},
+		},
+		{
+			name: "recursive factorial",
+			module: module(
+				section(1, functype(op(i64), op(i64))),
+				section(3, uleb(0)),
+				section(7, exportFunc("fac", 0)),
+				section(10, body(nil,
+					op(0x20, 0, 0x50), // local.get 0; i64.eqz
+					op(0x04, i64),     // if (result i64)
+					i64c(1),
+					op(0x05),             // else
+					op(0x20, 0, 0x20, 0), // local.get 0; local.get 0
+					i64c(1), op(0x7D),    // i64.sub
+					op(0x10, 0, 0x7E, 0x0B), // call 0; i64.mul; end
+					op(0x0B),
+				)),
+			),
+			fn:   "fac",
+			args: []types.Boxed{types.BoxI64(10)},
+			want: []types.Boxed{types.BoxI64(3628800)},
+		},
+		{
+			name: "loop",
+			module: module(
+				section(1, functype(op(i32), op(i32))),
+				section(3, uleb(0)),
+				section(7, exportFunc("sum", 0)),
+				section(10, body(op(1, i32),
+					op(0x03, 0x40),                      // loop
+					op(0x20, 1, 0x20, 0, 0x6A, 0x21, 1), // acc += n
+					op(0x20, 0), i32c(1), op(0x6B),      // n - 1
+					op(0x22, 0, 0x0D, 0), // local.tee 0; br_if 0
+					op(0x0B),
+					op(0x20, 1, 0x0B),
+				)),
+			),
+			fn:   "sum",
+			args: []types.Boxed{types.BoxI32(100)},
+			want: []types.Boxed{types.BoxI32(5050)},
+		},
+		{
+			name: "branch unwinds operands",
+			module: module(
+				section(1, functype(nil, op(i32))),
+				section(3, uleb(0)),
+				section(7, exportFunc("f", 0)),
+				section(10, body(nil,
+					i32c(7),
+					op(0x02, i32), // block (result i32)
+					i32c(9), i32c(42),
+					op(0x0C, 0), // br 0
+					op(0x0B),
+					op(0x6A, 0x0B),
+				)),
+			),
+			fn:   "f",
+			want: []types.Boxed{types.BoxI32(49)},
+		},
+		{
+			name: "conditional branch unwinds operands",
+			module: module(
+				section(1, functype(op(i32), op(i32))),
+				section(3, uleb(0)),
+				section(7, exportFunc("f", 0)),
+				section(10, body(nil,
+					op(0x02, i32), // block (result i32)
+					i32c(9), i32c(42),
+					op(0x20, 0, 0x0D, 0), // br_if 0
+					op(0x6A),
+					op(0x0B, 0x0B),
+				)),
+			),
+			fn:   "f",
+			args: []types.Boxed{types.BoxI32(1)},
+			want: []types.Boxed{types.BoxI32(42)},
+		},
+		{
+			name: "branch table",
+			module: module(
+				section(1, functype(op(i32), op(i32))),
+				section(3, uleb(0)),
+				section(7, exportFunc("f", 0)),
+				section(10, body(nil,
+					op(0x02, 0x40, 0x02, 0x40, 0x02, 0x40), // block block block
+					i32c(5),                                // excess operand
+					op(0x20, 0, 0x0E, 2, 0, 1, 2),          // br_table 0 1 2
+					op(0x0B), i32c(10), op(0x0F),           // end; return 10
+					op(0x0B), i32c(20), op(0x0F), // end; return 20
+					op(0x0B), i32c(30), op(0x0B), // end; 30
+				)),
+			),
+			fn:   "f",
+			args: []types.Boxed{types.BoxI32(1)},
+			want: []types.Boxed{types.BoxI32(20)},
+		},
+		{
+			name: "float",
+			module: module(
+				section(1, functype(op(f64), op(f64))),
+				section(3, uleb(0)),
+				section(7, exportFunc("f", 0)),
+				section(10, body(nil, op(0x20, 0), f64c(0.5), op(0xA2, 0x9F, 0x0B))),
+			),
+			fn:   "f",
+			args: []types.Boxed{types.BoxF64(32)},
+			want: []types.Boxed{types.BoxF64(4)},
+		},
+		{
+			name:   "f32.div by zero",
+			module: operator(op(f32, f32), f32, 0x95),
+			fn:     "f",
+			args:   []types.Boxed{types.BoxF32(1), types.BoxF32(float32(math.Copysign(0, -1)))},
+			want:   []types.Boxed{types.BoxF32(float32(math.Inf(-1)))},
+		},
+		{
+			name:   "f64.div by zero",
+			module: operator(op(f64, f64), f64, 0xA3),
+			fn:     "f",
+			args:   []types.Boxed{types.BoxF64(-2), types.BoxF64(math.Copysign(0, -1))},
+			want:   []types.Boxed{types.BoxF64(math.Inf(1))},
+		},
+		{
+			name:   "i32.div_s",
+			module: operator(op(i32, i32), i32, 0x6D),
+			fn:     "f",
+			args:   []types.Boxed{types.BoxI32(-7), types.BoxI32(2)},
+			want:   []types.Boxed{types.BoxI32(-3)},
+		},
+		{
+			name:   "i32.div_s overflow",
+			module: operator(op(i32, i32), i32, 0x6D),
+			fn:     "f",
+			args:   []types.Boxed{types.BoxI32(math.MinInt32), types.BoxI32(-1)},
+			err:    interp.ErrUnreachableExecuted,
+		},
+		{
+			name: "i64.div_s overflow",
+			module: module(
+				section(1, functype(nil, op(i64))),
+				section(3, uleb(0)),
+				section(7, exportFunc("f", 0)),
+				section(10, body(nil, i64c(math.MinInt64), i64c(-1), op(0x7F, 0x0B))),
+			),
+			fn:  "f",
+			err: interp.ErrUnreachableExecuted,
+		},
+		{
+			name:   "i32.trunc_f64_s",
+			module: operator(op(f64), i32, 0xAA),
+			fn:     "f",
+			args:   []types.Boxed{types.BoxF64(-2147483648.9)},
+			want:   []types.Boxed{types.BoxI32(math.MinInt32)},
+		},
+		{
+			name:   "i32.trunc_f32_s of NaN",
+			module: operator(op(f32), i32, 0xA8),
+			fn:     "f",
+			args:   []types.Boxed{types.BoxF32(float32(math.NaN()))},
+			err:    interp.ErrUnreachableExecuted,
+		},
+		{
+			name:   "i32.trunc_f64_u out of range",
+			module: operator(op(f64), i32, 0xAB),
+			fn:     "f",
+			args:   []types.Boxed{types.BoxF64(-1)},
+			err:    interp.ErrUnreachableExecuted,
+		},
+		{
+			name:   "i64.trunc_f32_s out of range",
+			module: operator(op(f32), i64, 0xAE),
+			fn:     "f",
+			args:   []types.Boxed{types.BoxF32(1 << 63)},
+			err:    interp.ErrUnreachableExecuted,
+		},
+		{
+			name:   "i64.trunc_f64_u",
+			module: operator(op(f64), i64, 0xB1),
+			fn:     "f",
+			args:   []types.Boxed{types.BoxF64(1 << 40)},
+			want:   []types.Boxed{types.BoxI64(1 << 40)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prog, err := wasm.Translate(bytes.NewReader(tt.module))
+			require.NoError(t, err)
+
+			i := interp.New(prog)
+			defer i.Close()
+
+			require.NoError(t, i.Run(context.Background()))
+			got, err := i.Call(context.Background(), tt.fn, tt.args...)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("zero divided by zero", func(t *testing.T) {
+		prog, err := wasm.Translate(bytes.NewReader(operator(op(f32, f32), f32, 0x95)))
+		require.NoError(t, err)
+
+		i := interp.New(prog)
+		defer i.Close()
+
+		require.NoError(t, i.Run(context.Background()))
+		got, err := i.Call(context.Background(), "f", types.BoxF32(0), types.BoxF32(0))
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		require.True(t, math.IsNaN(float64(got[0].F32())))
+	})
+
+	t.Run("memory", func(t *testing.T) {
+		m := module(
+			section(1,
+				functype(op(i32), op(i32)),
+				functype(op(i32, i64), nil),
+				functype(op(i32), op(i64)),
+				functype(nil, op(i32)),
+			),
+			section(3, uleb(0), uleb(1), uleb(2), uleb(0), uleb(3)),
+			section(5, op(0x01, 1, 2)),
+			section(7,
+				exportFunc("load8_s", 0),
+				exportFunc("store64", 1),
+				exportFunc("load64", 2),
+				exportFunc("grow", 3),
+				exportFunc("size", 4),
+				cat(name("memory"), op(0x02, 0)),
+			),
+			section(10,
+				body(nil, op(0x20, 0), memarg(0x2C, 1), op(0x0B)),
+				body(nil, op(0x20, 0, 0x20, 1), memarg(0x37, 0), op(0x0B)),
+				body(nil, op(0x20, 0), memarg(0x29, 0), op(0x0B)),
+				body(nil, op(0x20, 0, 0x40, 0, 0x0B)),
+				body(nil, op(0x3F, 0, 0x0B)),
+			),
+			section(11, cat(uleb(0), i32c(16), op(0x0B), name("\x01\xff"))),
+		)
+		prog, err := wasm.Translate(bytes.NewReader(m))
+		require.NoError(t, err)
+
+		i := interp.New(prog)
+		defer i.Close()
+		ctx := context.Background()
+		require.NoError(t, i.Run(ctx))
+
+		got, err := i.Call(ctx, "load8_s", types.BoxI32(16))
+		require.NoError(t, err)
+		require.Equal(t, []types.Boxed{types.BoxI32(-1)}, got)
+
+		_, err = i.Call(ctx, "store64", types.BoxI32(8), types.BoxI64(0x0102030405060708))
+		require.NoError(t, err)
+		got, err = i.Call(ctx, "load64", types.BoxI32(8))
+		require.NoError(t, err)
+		require.Equal(t, []types.Boxed{types.BoxI64(0x0102030405060708)}, got)
+
+		_, err = i.Call(ctx, "load64", types.BoxI32(pageSize-4))
+		require.ErrorIs(t, err, interp.ErrIndexOutOfRange)
+
+		got, err = i.Call(ctx, "grow", types.BoxI32(1))
+		require.NoError(t, err)
+		require.Equal(t, []types.Boxed{types.BoxI32(1)}, got)
+		got, err = i.Call(ctx, "grow", types.BoxI32(1))
+		require.NoError(t, err)
+		require.Equal(t, []types.Boxed{types.BoxI32(-1)}, got)
+		got, err = i.Call(ctx, "size")
+		require.NoError(t, err)
+		require.Equal(t, []types.Boxed{types.BoxI32(2)}, got)
+		got, err = i.Call(ctx, "load64", types.BoxI32(8))
+		require.NoError(t, err)
+		require.Equal(t, []types.Boxed{types.BoxI64(0x0102030405060708)}, got)
+	})
+
+	t.Run("globals and start", func(t *testing.T) {
+		m := module(
+			section(1, functype(nil, nil)),
+			section(3, uleb(0)),
+			section(6, cat(op(i32, 1), i32c(40), op(0x0B))),
+			section(7, cat(name("counter"), op(0x03, 0))),
+			raw(8, uleb(0)),
+			section(10, body(nil, op(0x23, 0), i32c(2), op(0x6A, 0x24, 0, 0x0B))),
+		)
+		prog, err := wasm.Translate(bytes.NewReader(m))
+		require.NoError(t, err)
+
+		i := interp.New(prog)
+		defer i.Close()
+		require.NoError(t, i.Run(context.Background()))
+		got, err := i.Global(0)
+		require.NoError(t, err)
+		require.Equal(t, types.BoxI32(42), got)
+	})
+
+	t.Run("indirect calls", func(t *testing.T) {
+		m := module(
+			section(1, functype(op(i32), op(i32)), functype(nil, op(i32))),
+			section(3, uleb(0), uleb(1), uleb(0)),
+			section(4, op(0x70, 0x00, 3)),
+			section(7, exportFunc("dispatch", 2)),
+			section(9, cat(uleb(0), i32c(0), op(0x0B), vec(uleb(0), uleb(1)))),
+			section(10,
+				body(nil, op(0x20, 0), i32c(2), op(0x6C, 0x0B)),
+				body(nil, i32c(7), op(0x0B)),
+				body(nil, i32c(21), op(0x20, 0, 0x11, 0, 0, 0x0B)),
+			),
+		)
+		prog, err := wasm.Translate(bytes.NewReader(m))
+		require.NoError(t, err)
+
+		i := interp.New(prog)
+		defer i.Close()
+		ctx := context.Background()
+		require.NoError(t, i.Run(ctx))
+
+		got, err := i.Call(ctx, "dispatch", types.BoxI32(0))
+		require.NoError(t, err)
+		require.Equal(t, []types.Boxed{types.BoxI32(42)}, got)
+
+		_, err = i.Call(ctx, "dispatch", types.BoxI32(1))
+		require.ErrorIs(t, err, interp.ErrTypeMismatch)
+		_, err = i.Call(ctx, "dispatch", types.BoxI32(2))
+		require.ErrorIs(t, err, interp.ErrTypeMismatch)
+		_, err = i.Call(ctx, "dispatch", types.BoxI32(3))
+		require.ErrorIs(t, err, interp.ErrIndexOutOfRange)
+	})
+
+	t.Run("imports", func(t *testing.T) {
+		m := module(
+			section(1, functype(op(i32), op(i32))),
+			section(2, cat(name("env"), name("scale"), op(0x00, 0))),
+			section(3, uleb(0)),
+			section(7, exportFunc("f", 1)),
+			section(10, body(nil, op(0x20, 0, 0x10, 0), i32c(1), op(0x6A, 0x0B))),
+		)
+		prog, err := wasm.Translate(bytes.NewReader(m))
+		require.NoError(t, err)
+		require.Len(t, prog.Imports, 1)
+		require.Equal(t, "env.scale", prog.Imports[0].Name)
+
+		scale := interp.NewHostFunction(prog.Imports[0].Typ, func(_ *interp.Interpreter, params []types.Boxed) ([]types.Boxed, error) {
+			return []types.Boxed{types.BoxI32(params[0].I32() * 10)}, nil
+		})
+		i := interp.New(prog, interp.WithImports(map[string]*interp.HostFunction{"env.scale": scale}))
+		defer i.Close()
+		require.NoError(t, i.Run(context.Background()))
+
+		got, err := i.Call(context.Background(), "f", types.BoxI32(4))
+		require.NoError(t, err)
+		require.Equal(t, []types.Boxed{types.BoxI32(41)}, got)
+	})
+
+	errs := []struct {
+		name   string
+		module []byte
+		err    error
+	}{
+		{name: "magic", module: []byte("\x00wasm\x01\x00\x00"), err: wasm.ErrInvalidMagic},
+		{name: "version", module: []byte("\x00asm\x02\x00\x00\x00"), err: wasm.ErrUnsupportedVersion},
+		{name: "truncated", module: module(section(1, functype(op(i32), op(i32)))[:5]), err: wasm.ErrMalformed},
+		{
+			name:   "memory import",
+			module: module(section(2, cat(name("env"), name("mem"), op(0x02, 0x00, 1)))),
+			err:    wasm.ErrUnsupported,
+		},
+		{
+			name: "prefixed opcode",
+			module: module(
+				section(1, functype(nil, nil)),
+				section(3, uleb(0)),
+				section(10, body(nil, op(0xFC, 0x0B, 0x00, 0x0B))),
+			),
+			err: wasm.ErrUnsupported,
+		},
+		{
+			name: "stack underflow",
+			module: module(
+				section(1, functype(nil, nil)),
+				section(3, uleb(0)),
+				section(10, body(nil, op(0x1A, 0x0B))),
+			),
+			err: wasm.ErrMalformed,
+		},
+		{
+			name: "missing body",
+			module: module(
+				section(1, functype(nil, nil)),
+				section(3, uleb(0)),
+			),
+			err: wasm.ErrMalformed,
+		},
+	}
+
+	for _, tt := range errs {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := wasm.Translate(bytes.NewReader(tt.module))
+			require.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+const pageSize = 65536